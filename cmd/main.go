@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"erp-project/database"
 	"erp-project/handlers"
 	"erp-project/middleware"
+	"erp-project/models"
 	"erp-project/repositories"
 	"erp-project/utils"
 
@@ -16,36 +19,73 @@ import (
 )
 
 func main() {
-	// Initialize database
-	if err := database.InitDB(); err != nil {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	// Initialize database; refuses to start against an unmigrated schema
+	db, err := database.InitDB()
+	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
-	defer database.DB.Close()
+	defer db.Close()
+
+	// Deadlines: QUERY_TIMEOUT bounds each statement, REQUEST_TIMEOUT the whole request
+	db.QueryTimeout = utils.DurationFromEnv("QUERY_TIMEOUT", 10*time.Second)
+	requestTimeout := utils.DurationFromEnv("REQUEST_TIMEOUT", 30*time.Second)
+
+	// Exchange rates are quoted against BASE_CURRENCY and reports are in it
+	if code := os.Getenv("BASE_CURRENCY"); code != "" {
+		if err := models.SetBaseCurrency(code); err != nil {
+			log.Fatal("Invalid BASE_CURRENCY:", err)
+		}
+	}
 
 	// Initialize repositories
-	productRepo := repositories.NewProductRepository(database.DB)
-	customerRepo := repositories.NewCustomerRepository(database.DB)
-	orderRepo := repositories.NewOrderRepository(database.DB)
-	supplierRepo := repositories.NewSupplierRepository(database.DB)
-	warehouseRepo := repositories.NewWarehouseRepository(database.DB)
+	store := repositories.NewStore(db)
+
+	if err := bootstrapAdmin(context.Background(), store.Users); err != nil {
+		log.Fatal("Failed to create admin user:", err)
+	}
+	tokens := utils.NewTokenManagerFromEnv()
 
 	// Initialize handlers
-	productHandler := handlers.NewProductHandler(productRepo)
-	customerHandler := handlers.NewCustomerHandler(customerRepo)
-	orderHandler := handlers.NewOrderHandler(orderRepo, productRepo, customerRepo)
-	supplierHandler := handlers.NewSupplierHandler(supplierRepo)
-	warehouseHandler := handlers.NewWarehouseHandler(warehouseRepo)
+	productHandler := handlers.NewProductHandler(store.Products)
+	customerHandler := handlers.NewCustomerHandler(store.Customers)
+	orderHandler := handlers.NewOrderHandler(store.Orders, store.Products, store.Customers, store.ExchangeRates, store.PriceLists, store.Promotions, store.Taxes)
+	supplierHandler := handlers.NewSupplierHandler(store.Suppliers)
+	warehouseHandler := handlers.NewWarehouseHandler(store.Warehouses)
+	stockMovementHandler := handlers.NewStockMovementHandler(store.StockMovements)
+	transferHandler := handlers.NewTransferHandler(store.Transfers, store.Warehouses, store.Products)
+	purchaseOrderHandler := handlers.NewPurchaseOrderHandler(store.PurchaseOrders, store.Suppliers, store.Products, store.Warehouses, store.ExchangeRates)
+	replenishmentHandler := handlers.NewReplenishmentHandler(store.Replenishment, store.PurchaseOrders)
+	authHandler := handlers.NewAuthHandler(store.Users, tokens)
+	userHandler := handlers.NewUserHandler(store.Users)
+	auditHandler := handlers.NewAuditHandler(store.Audit)
+	exchangeRateHandler := handlers.NewExchangeRateHandler(store.ExchangeRates)
+	priceListHandler := handlers.NewPriceListHandler(store.PriceLists, store.Customers, store.Products)
+	promotionHandler := handlers.NewPromotionHandler(store.Promotions, store.Customers, store.Products)
+	taxHandler := handlers.NewTaxHandler(store.Taxes)
+	invoiceHandler := handlers.NewInvoiceHandler(store.Invoices, store.Orders, store.Customers)
+	paymentHandler := handlers.NewPaymentHandler(store.Payments, store.Customers, store.ExchangeRates)
+	supplierBillHandler := handlers.NewSupplierBillHandler(store.SupplierBills, store.Suppliers, store.PurchaseOrders, store.ExchangeRates)
 
 	// Create Gin router
 	r := gin.Default()
 
 	// ✅ ADD CORS MIDDLEWARE
+	// Credentials are only allowed for an explicit origin list; a wildcard origin with
+	// credentials would let any site make authenticated calls
 	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true // Allow all origins for now
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		config.AllowOrigins = strings.Split(origins, ",")
+		config.AllowCredentials = true
+	} else {
+		config.AllowAllOrigins = true
+	}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", middleware.APIKeyHeader}
 	config.ExposeHeaders = []string{"Content-Length"}
-	config.AllowCredentials = true
 
 	r.Use(cors.New(config))
 
@@ -55,6 +95,14 @@ func main() {
 	// ✅ Add your existing middleware - REMOVE the duplicate logging middleware below
 	r.Use(middleware.RequestLogger())
 
+	// Give every request a deadline; repositories stop working on it once it passes
+	r.Use(middleware.Timeout(requestTimeout))
+
+	// Every route except the root, health check, login and token refresh requires a
+	// bearer access token or an API key
+	authenticate := middleware.Authenticate(tokens, store.Users)
+	can := middleware.RequirePermission
+
 	// Add a root route with standardized response format
 	r.GET("/", func(c *gin.Context) {
 		utils.SuccessResponse(c, "ERP API is running", map[string]interface{}{
@@ -74,11 +122,18 @@ func main() {
 					"get_all":  "GET /api/customers",
 					"get_list": "GET /api/customers/list",
 					"get_one":  "GET /api/customers/:id",
+					"balance":  "GET /api/customers/:id/balance",
 				},
 				"orders": map[string]string{
-					"create":    "POST /api/orders",
-					"get_all":   "GET /api/orders",
-					"get_items": "GET /api/orders/:id/items",
+					"create":      "POST /api/orders",
+					"get_all":     "GET /api/orders",
+					"summary":     "GET /api/orders/summary?from=&to=",
+					"get_items":   "GET /api/orders/:id/items",
+					"transition":  "POST /api/orders/:id/transitions",
+					"get_history": "GET /api/orders/:id/history",
+					"cancel":      "POST /api/orders/:id/cancel",
+					"get_cancels": "GET /api/orders/:id/cancellations",
+					"allocations": "GET /api/orders/:id/allocations",
 				},
 				"suppliers": map[string]string{
 					"create":       "POST /api/suppliers",
@@ -100,6 +155,109 @@ func main() {
 					"available_locations": "GET /api/warehouses/:id/locations/available",
 					"add_inventory":       "POST /api/warehouses/:id/inventory",
 					"get_inventory":       "GET /api/warehouses/:id/inventory",
+					"update_inventory":    "PUT /api/warehouses/inventory/:inventory_id",
+				},
+				"inventory": map[string]string{
+					"movements": "GET /api/inventory/movements",
+					"stock_at":  "GET /api/inventory/stock",
+				},
+				"transfers": map[string]string{
+					"create":       "POST /api/transfers",
+					"get_all":      "GET /api/transfers",
+					"get_one":      "GET /api/transfers/:id",
+					"dispatch":     "POST /api/transfers/:id/dispatch",
+					"receive":      "POST /api/transfers/:id/receipts",
+					"get_receipts": "GET /api/transfers/:id/receipts",
+					"cancel":       "POST /api/transfers/:id/cancel",
+				},
+				"purchase_orders": map[string]string{
+					"create":       "POST /api/purchase-orders",
+					"get_all":      "GET /api/purchase-orders",
+					"get_one":      "GET /api/purchase-orders/:id",
+					"approve":      "POST /api/purchase-orders/:id/approve",
+					"send":         "POST /api/purchase-orders/:id/send",
+					"cancel":       "POST /api/purchase-orders/:id/cancel",
+					"receive":      "POST /api/purchase-orders/:id/receipts",
+					"get_receipts": "GET /api/purchase-orders/:id/receipts",
+					"close":        "POST /api/purchase-orders/:id/close",
+				},
+				"replenishment": map[string]string{
+					"suggestions":     "GET /api/replenishment/suggestions",
+					"purchase_orders": "POST /api/replenishment/purchase-orders",
+				},
+				"exchange_rates": map[string]string{
+					"currencies": "GET /api/currencies",
+					"get_all":    "GET /api/exchange-rates?currency=&from=&to=",
+					"create":     "POST /api/exchange-rates",
+					"import":     "POST /api/exchange-rates/import",
+					"convert":    "GET /api/exchange-rates/convert?amount=&from=&to=&date=",
+				},
+				"price_lists": map[string]string{
+					"create":  "POST /api/price-lists",
+					"get_all": "GET /api/price-lists?customer_id=&customer_group=&valid_on=",
+					"get_one": "GET /api/price-lists/:id",
+					"update":  "PUT /api/price-lists/:id",
+				},
+				"promotions": map[string]string{
+					"create":         "POST /api/promotions",
+					"get_all":        "GET /api/promotions?active_at=&requires_coupon=",
+					"get_one":        "GET /api/promotions/:id",
+					"update":         "PUT /api/promotions/:id",
+					"create_coupon":  "POST /api/promotions/:id/coupons",
+					"get_coupons":    "GET /api/promotions/:id/coupons",
+					"redeem_coupons": "POST /api/orders with coupon_code",
+				},
+				"tax": map[string]string{
+					"create_rate": "POST /api/tax/rates",
+					"get_rates":   "GET /api/tax/rates?country=&region=&tax_category=&valid_on=",
+					"get_rate":    "GET /api/tax/rates/:id",
+					"update_rate": "PUT /api/tax/rates/:id",
+					"summary":     "GET /api/tax/summary?from=&to=",
+				},
+				"invoices": map[string]string{
+					"create":       "POST /api/invoices",
+					"get_all":      "GET /api/invoices?customer_id=&order_id=&type=&status=&currency=&from=&to=&overdue=",
+					"get_one":      "GET /api/invoices/:id",
+					"issue":        "POST /api/invoices/:id/issue",
+					"void":         "POST /api/invoices/:id/void",
+					"credit_notes": "POST /api/invoices/:id/credit-notes",
+					"payments":     "GET /api/invoices/:id/payments",
+					"aging":        "GET /api/invoices/aging?as_of=",
+				},
+				"payments": map[string]string{
+					"create":   "POST /api/payments",
+					"get_all":  "GET /api/payments?customer_id=&status=&method=&currency=&from=&to=&unallocated=",
+					"get_one":  "GET /api/payments/:id",
+					"allocate": "POST /api/payments/:id/allocations",
+					"void":     "POST /api/payments/:id/void",
+				},
+				"bills": map[string]string{
+					"create":  "POST /api/bills",
+					"get_all": "GET /api/bills?supplier_id=&purchase_order_id=&status=&match_status=&from=&to=&overdue=",
+					"get_one": "GET /api/bills/:id",
+					"approve": "POST /api/bills/:id/approve",
+					"void":    "POST /api/bills/:id/void",
+					"pay":     "POST /api/bills/:id/payments",
+					"aging":   "GET /api/bills/aging?as_of=",
+				},
+				"auth": map[string]string{
+					"login":   "POST /api/auth/login",
+					"refresh": "POST /api/auth/refresh",
+					"logout":  "POST /api/auth/logout",
+					"me":      "GET /api/auth/me",
+				},
+				"users": map[string]string{
+					"roles":          "GET /api/users/roles",
+					"create":         "POST /api/users",
+					"get_all":        "GET /api/users",
+					"get_one":        "GET /api/users/:id",
+					"update":         "PUT /api/users/:id",
+					"create_api_key": "POST /api/users/:id/api-keys",
+					"get_api_keys":   "GET /api/users/:id/api-keys",
+					"revoke_api_key": "DELETE /api/users/:id/api-keys/:key_id",
+				},
+				"audit": map[string]string{
+					"get_all": "GET /api/audit?entity=product&id=:id",
 				},
 				"health":   "GET /health",
 				"debug_db": "GET /debug/db",
@@ -107,75 +265,225 @@ func main() {
 		})
 	})
 
+	// Authentication routes; only login, refresh and logout are public
+	auth := r.Group("/api/auth")
+	{
+		auth.POST("/login", authHandler.Login)
+		auth.POST("/refresh", authHandler.RefreshToken)
+		auth.POST("/logout", authHandler.Logout)
+		auth.GET("/me", authenticate, authHandler.Me)
+	}
+
+	// User routes
+	users := r.Group("/api/users", authenticate, can(models.PermissionUsersManage))
+	{
+		users.GET("/roles", userHandler.GetRoles)
+		users.POST("/", userHandler.CreateUser)
+		users.GET("/", userHandler.GetUsers)
+		users.GET("/:id", userHandler.GetUserByID)
+		users.PUT("/:id", userHandler.UpdateUser)
+		users.POST("/:id/api-keys", userHandler.CreateAPIKey)
+		users.GET("/:id/api-keys", userHandler.GetAPIKeys)
+		users.DELETE("/:id/api-keys/:key_id", userHandler.RevokeAPIKey)
+	}
+
+	// Audit routes
+	audit := r.Group("/api/audit", authenticate, can(models.PermissionAuditRead))
+	{
+		audit.GET("/", auditHandler.GetAuditLogs)
+	}
+
 	// ✅ FIXED: Add leading slashes to all routes in groups
-	products := r.Group("/api/products")
+	products := r.Group("/api/products", authenticate)
 	{
-		products.POST("/", productHandler.CreateProduct)      // ✅ POST /api/products/
-		products.GET("/", productHandler.GetAllProducts)      // ✅ GET /api/products/
-		products.GET("/list", productHandler.GetListProducts) // ✅ GET /api/products/list
-		products.GET("/:id", productHandler.GetProductByID)   // ✅ GET /api/products/:id
-		products.PUT("/:id", productHandler.UpdateProduct)    // ✅ PUT /api/products/:id
-		products.DELETE("/:id", productHandler.DeleteProduct) // ✅ DELETE /api/products/:id
+		products.POST("/", can(models.PermissionProductsCreate), productHandler.CreateProduct)      // ✅ POST /api/products/
+		products.GET("/", can(models.PermissionProductsRead), productHandler.GetAllProducts)        // ✅ GET /api/products/
+		products.GET("/list", can(models.PermissionProductsRead), productHandler.GetListProducts)   // ✅ GET /api/products/list
+		products.GET("/:id", can(models.PermissionProductsRead), productHandler.GetProductByID)     // ✅ GET /api/products/:id
+		products.PUT("/:id", can(models.PermissionProductsUpdate), productHandler.UpdateProduct)    // ✅ PUT /api/products/:id
+		products.DELETE("/:id", can(models.PermissionProductsDelete), productHandler.DeleteProduct) // ✅ DELETE /api/products/:id
 	}
 
 	// Customer routes - FIXED with leading slashes
-	customers := r.Group("/api/customers")
+	customers := r.Group("/api/customers", authenticate)
 	{
-		customers.POST("/", customerHandler.CreateCustomer)      // ✅ POST /api/customers/
-		customers.GET("/", customerHandler.GetAllCustomers)      // ✅ GET /api/customers/
-		customers.GET("/list", customerHandler.GetListCustomers) // ✅ GET /api/customers/list
-		customers.GET("/:id", customerHandler.GetCustomerByID)   // ✅ GET /api/customers/:id
-		customers.PUT("/:id", customerHandler.UpdateCustomer)    // ✅ PUT /api/customers/:id
-		customers.DELETE("/:id", customerHandler.DeleteCustomer) // ✅ DELETE /api/customers/:id
+		customers.POST("/", can(models.PermissionCustomersCreate), customerHandler.CreateCustomer)      // ✅ POST /api/customers/
+		customers.GET("/", can(models.PermissionCustomersRead), customerHandler.GetAllCustomers)        // ✅ GET /api/customers/
+		customers.GET("/list", can(models.PermissionCustomersRead), customerHandler.GetListCustomers)   // ✅ GET /api/customers/list
+		customers.GET("/:id", can(models.PermissionCustomersRead), customerHandler.GetCustomerByID)     // ✅ GET /api/customers/:id
+		customers.PUT("/:id", can(models.PermissionCustomersUpdate), customerHandler.UpdateCustomer)    // ✅ PUT /api/customers/:id
+		customers.DELETE("/:id", can(models.PermissionCustomersDelete), customerHandler.DeleteCustomer) // ✅ DELETE /api/customers/:id
+		customers.GET("/:id/balance", can(models.PermissionPaymentsRead), paymentHandler.GetCustomerBalance)
 	}
 
 	// Order routes - FIXED with leading slashes
-	orders := r.Group("/api/orders")
+	orders := r.Group("/api/orders", authenticate)
 	{
-		orders.POST("/", orderHandler.CreateOrder)           // ✅ POST /api/orders/
-		orders.GET("/", orderHandler.GetOrders)              // ✅ GET /api/orders/
-		orders.GET("/:id/items", orderHandler.GetOrderItems) // ✅ GET /api/orders/:id/items
+		orders.POST("/", can(models.PermissionOrdersCreate), orderHandler.CreateOrder)         // ✅ POST /api/orders/
+		orders.GET("/", can(models.PermissionOrdersRead), orderHandler.GetOrders)              // ✅ GET /api/orders/
+		orders.GET("/:id/items", can(models.PermissionOrdersRead), orderHandler.GetOrderItems) // ✅ GET /api/orders/:id/items
+		orders.GET("/summary", can(models.PermissionOrdersRead), orderHandler.GetOrderSummary)
+		orders.POST("/:id/transitions", can(models.PermissionOrdersUpdate), orderHandler.TransitionOrder)
+		orders.GET("/:id/history", can(models.PermissionOrdersRead), orderHandler.GetOrderHistory)
+		orders.POST("/:id/cancel", can(models.PermissionOrdersCancel), orderHandler.CancelOrder)
+		orders.GET("/:id/cancellations", can(models.PermissionOrdersRead), orderHandler.GetOrderCancellations)
+		orders.GET("/:id/allocations", can(models.PermissionOrdersRead), orderHandler.GetOrderAllocations)
 	}
 
 	// Supplier routes
-	suppliers := r.Group("/api/suppliers")
+	suppliers := r.Group("/api/suppliers", authenticate)
 	{
-		suppliers.POST("/", supplierHandler.CreateSupplier)
-		suppliers.GET("/", supplierHandler.GetAllSuppliers)
-		suppliers.GET("/:id", supplierHandler.GetSupplierByID)
-		suppliers.PUT("/:id", supplierHandler.UpdateSupplier)
-		suppliers.DELETE("/:id", supplierHandler.DeleteSupplier)
+		suppliers.POST("/", can(models.PermissionSuppliersCreate), supplierHandler.CreateSupplier)
+		suppliers.GET("/", can(models.PermissionSuppliersRead), supplierHandler.GetAllSuppliers)
+		suppliers.GET("/:id", can(models.PermissionSuppliersRead), supplierHandler.GetSupplierByID)
+		suppliers.PUT("/:id", can(models.PermissionSuppliersUpdate), supplierHandler.UpdateSupplier)
+		suppliers.DELETE("/:id", can(models.PermissionSuppliersDelete), supplierHandler.DeleteSupplier)
 
 		// Product-Supplier relationships
-		suppliers.POST("/:id/products", supplierHandler.AddProductSupplier)
-		suppliers.GET("/:id/products", supplierHandler.GetSupplierProducts)
-		suppliers.DELETE("/products/:product_supplier_id", supplierHandler.RemoveProductSupplier)
+		suppliers.POST("/:id/products", can(models.PermissionSuppliersUpdate), supplierHandler.AddProductSupplier)
+		suppliers.GET("/:id/products", can(models.PermissionSuppliersRead), supplierHandler.GetSupplierProducts)
+		suppliers.DELETE("/products/:product_supplier_id", can(models.PermissionSuppliersUpdate), supplierHandler.RemoveProductSupplier)
 	}
 
 	// Warehouse routes
-	warehouses := r.Group("/api/warehouses")
+	warehouses := r.Group("/api/warehouses", authenticate)
 	{
-		warehouses.POST("/", warehouseHandler.CreateWarehouse)
-		warehouses.GET("/", warehouseHandler.GetAllWarehouses)
-		warehouses.GET("/:id", warehouseHandler.GetWarehouseByID)
-		warehouses.PUT("/:id", warehouseHandler.UpdateWarehouse)
-		warehouses.DELETE("/:id", warehouseHandler.DeleteWarehouse)
+		warehouses.POST("/", can(models.PermissionWarehousesCreate), warehouseHandler.CreateWarehouse)
+		warehouses.GET("/", can(models.PermissionWarehousesRead), warehouseHandler.GetAllWarehouses)
+		warehouses.GET("/:id", can(models.PermissionWarehousesRead), warehouseHandler.GetWarehouseByID)
+		warehouses.PUT("/:id", can(models.PermissionWarehousesUpdate), warehouseHandler.UpdateWarehouse)
+		warehouses.DELETE("/:id", can(models.PermissionWarehousesDelete), warehouseHandler.DeleteWarehouse)
 
 		// Location management
-		warehouses.POST("/:id/locations", warehouseHandler.CreateLocation)
-		warehouses.GET("/:id/locations", warehouseHandler.GetWarehouseLocations)
-		warehouses.GET("/:id/locations/available", warehouseHandler.GetAvailableLocations)
+		warehouses.POST("/:id/locations", can(models.PermissionWarehousesUpdate), warehouseHandler.CreateLocation)
+		warehouses.GET("/:id/locations", can(models.PermissionWarehousesRead), warehouseHandler.GetWarehouseLocations)
+		warehouses.GET("/:id/locations/available", can(models.PermissionWarehousesRead), warehouseHandler.GetAvailableLocations)
 
 		// Inventory management
-		warehouses.POST("/:id/inventory", warehouseHandler.CreateInventory)
-		warehouses.GET("/:id/inventory", warehouseHandler.GetWarehouseInventory)
-		warehouses.PUT("/inventory/:inventory_id", warehouseHandler.UpdateInventory)
+		warehouses.POST("/:id/inventory", can(models.PermissionInventoryAdjust), warehouseHandler.CreateInventory)
+		warehouses.GET("/:id/inventory", can(models.PermissionInventoryRead), warehouseHandler.GetWarehouseInventory)
+		warehouses.PUT("/inventory/:inventory_id", can(models.PermissionInventoryAdjust), warehouseHandler.UpdateInventory)
+	}
+
+	// Stock ledger routes
+	inventory := r.Group("/api/inventory", authenticate)
+	{
+		inventory.GET("/movements", can(models.PermissionInventoryRead), stockMovementHandler.GetMovements)
+		inventory.GET("/stock", can(models.PermissionInventoryRead), stockMovementHandler.GetStockAt)
+	}
+
+	// Transfer order routes
+	transfers := r.Group("/api/transfers", authenticate)
+	{
+		transfers.POST("/", can(models.PermissionTransfersCreate), transferHandler.CreateTransfer)
+		transfers.GET("/", can(models.PermissionTransfersRead), transferHandler.GetTransfers)
+		transfers.GET("/:id", can(models.PermissionTransfersRead), transferHandler.GetTransferByID)
+		transfers.POST("/:id/dispatch", can(models.PermissionTransfersDispatch), transferHandler.DispatchTransfer)
+		transfers.POST("/:id/receipts", can(models.PermissionTransfersReceive), transferHandler.ReceiveTransfer)
+		transfers.GET("/:id/receipts", can(models.PermissionTransfersRead), transferHandler.GetTransferReceipts)
+		transfers.POST("/:id/cancel", can(models.PermissionTransfersCancel), transferHandler.CancelTransfer)
+	}
+
+	// Purchase order routes
+	purchaseOrders := r.Group("/api/purchase-orders", authenticate)
+	{
+		purchaseOrders.POST("/", can(models.PermissionPurchaseOrdersCreate), purchaseOrderHandler.CreatePurchaseOrder)
+		purchaseOrders.GET("/", can(models.PermissionPurchaseOrdersRead), purchaseOrderHandler.GetPurchaseOrders)
+		purchaseOrders.GET("/:id", can(models.PermissionPurchaseOrdersRead), purchaseOrderHandler.GetPurchaseOrderByID)
+		purchaseOrders.POST("/:id/approve", can(models.PermissionPurchaseOrdersApprove), purchaseOrderHandler.ApprovePurchaseOrder)
+		purchaseOrders.POST("/:id/send", can(models.PermissionPurchaseOrdersSend), purchaseOrderHandler.SendPurchaseOrder)
+		purchaseOrders.POST("/:id/cancel", can(models.PermissionPurchaseOrdersCancel), purchaseOrderHandler.CancelPurchaseOrder)
+		purchaseOrders.POST("/:id/receipts", can(models.PermissionPurchaseOrdersReceive), purchaseOrderHandler.ReceivePurchaseOrder)
+		purchaseOrders.GET("/:id/receipts", can(models.PermissionPurchaseOrdersRead), purchaseOrderHandler.GetPurchaseOrderReceipts)
+		purchaseOrders.POST("/:id/close", can(models.PermissionPurchaseOrdersCancel), purchaseOrderHandler.ClosePurchaseOrder)
+	}
+
+	// Replenishment routes
+	replenishment := r.Group("/api/replenishment", authenticate)
+	{
+		replenishment.GET("/suggestions", can(models.PermissionReplenishmentRead), replenishmentHandler.GetSuggestions)
+		replenishment.POST("/purchase-orders", can(models.PermissionReplenishmentCreate), replenishmentHandler.CreatePurchaseOrders)
+	}
+
+	// Currency and exchange rate routes
+	r.GET("/api/currencies", authenticate, can(models.PermissionExchangeRatesRead), exchangeRateHandler.GetCurrencies)
+	exchangeRates := r.Group("/api/exchange-rates", authenticate)
+	{
+		exchangeRates.GET("/", can(models.PermissionExchangeRatesRead), exchangeRateHandler.GetExchangeRates)
+		exchangeRates.GET("/convert", can(models.PermissionExchangeRatesRead), exchangeRateHandler.Convert)
+		exchangeRates.POST("/", can(models.PermissionExchangeRatesManage), exchangeRateHandler.CreateExchangeRates)
+		exchangeRates.POST("/import", can(models.PermissionExchangeRatesManage), exchangeRateHandler.ImportExchangeRates)
+	}
+
+	// Price list routes
+	priceLists := r.Group("/api/price-lists", authenticate)
+	{
+		priceLists.POST("/", can(models.PermissionPriceListsManage), priceListHandler.CreatePriceList)
+		priceLists.GET("/", can(models.PermissionPriceListsRead), priceListHandler.GetPriceLists)
+		priceLists.GET("/:id", can(models.PermissionPriceListsRead), priceListHandler.GetPriceListByID)
+		priceLists.PUT("/:id", can(models.PermissionPriceListsManage), priceListHandler.UpdatePriceList)
+	}
+
+	// Promotion and coupon routes
+	promotions := r.Group("/api/promotions", authenticate)
+	{
+		promotions.POST("/", can(models.PermissionPromotionsManage), promotionHandler.CreatePromotion)
+		promotions.GET("/", can(models.PermissionPromotionsRead), promotionHandler.GetPromotions)
+		promotions.GET("/:id", can(models.PermissionPromotionsRead), promotionHandler.GetPromotionByID)
+		promotions.PUT("/:id", can(models.PermissionPromotionsManage), promotionHandler.UpdatePromotion)
+		promotions.POST("/:id/coupons", can(models.PermissionPromotionsManage), promotionHandler.CreateCoupon)
+		promotions.GET("/:id/coupons", can(models.PermissionPromotionsRead), promotionHandler.GetCoupons)
+	}
+
+	// Tax rate and tax reporting routes
+	tax := r.Group("/api/tax", authenticate)
+	{
+		tax.POST("/rates/", can(models.PermissionTaxManage), taxHandler.CreateTaxRate)
+		tax.GET("/rates/", can(models.PermissionTaxRead), taxHandler.GetTaxRates)
+		tax.GET("/rates/:id", can(models.PermissionTaxRead), taxHandler.GetTaxRateByID)
+		tax.PUT("/rates/:id", can(models.PermissionTaxManage), taxHandler.UpdateTaxRate)
+		tax.GET("/summary", can(models.PermissionTaxRead), taxHandler.GetTaxSummary)
+	}
+
+	// Sales invoice and credit note routes
+	invoices := r.Group("/api/invoices", authenticate)
+	{
+		invoices.POST("/", can(models.PermissionInvoicesCreate), invoiceHandler.CreateInvoice)
+		invoices.GET("/", can(models.PermissionInvoicesRead), invoiceHandler.GetInvoices)
+		invoices.GET("/:id", can(models.PermissionInvoicesRead), invoiceHandler.GetInvoiceByID)
+		invoices.POST("/:id/issue", can(models.PermissionInvoicesIssue), invoiceHandler.IssueInvoice)
+		invoices.POST("/:id/void", can(models.PermissionInvoicesVoid), invoiceHandler.VoidInvoice)
+		invoices.POST("/:id/credit-notes", can(models.PermissionInvoicesCreate), invoiceHandler.CreateCreditNote)
+		invoices.GET("/:id/payments", can(models.PermissionPaymentsRead), paymentHandler.GetInvoicePayments)
+		invoices.GET("/aging", can(models.PermissionInvoicesRead), paymentHandler.GetReceivablesAging)
+	}
+
+	// Customer payment routes
+	payments := r.Group("/api/payments", authenticate)
+	{
+		payments.POST("/", can(models.PermissionPaymentsCreate), paymentHandler.CreatePayment)
+		payments.GET("/", can(models.PermissionPaymentsRead), paymentHandler.GetPayments)
+		payments.GET("/:id", can(models.PermissionPaymentsRead), paymentHandler.GetPaymentByID)
+		payments.POST("/:id/allocations", can(models.PermissionPaymentsCreate), paymentHandler.AllocatePayment)
+		payments.POST("/:id/void", can(models.PermissionPaymentsVoid), paymentHandler.VoidPayment)
+	}
+
+	// Supplier bill and accounts payable routes
+	bills := r.Group("/api/bills", authenticate)
+	{
+		bills.POST("/", can(models.PermissionBillsCreate), supplierBillHandler.CreateSupplierBill)
+		bills.GET("/", can(models.PermissionBillsRead), supplierBillHandler.GetSupplierBills)
+		bills.GET("/aging", can(models.PermissionBillsRead), supplierBillHandler.GetPayablesAging)
+		bills.GET("/:id", can(models.PermissionBillsRead), supplierBillHandler.GetSupplierBillByID)
+		bills.POST("/:id/approve", can(models.PermissionBillsApprove), supplierBillHandler.ApproveSupplierBill)
+		bills.POST("/:id/void", can(models.PermissionBillsVoid), supplierBillHandler.VoidSupplierBill)
+		bills.POST("/:id/payments", can(models.PermissionBillsPay), supplierBillHandler.PaySupplierBill)
 	}
 
 	// Health check with standardized response format
 	r.GET("/health", func(c *gin.Context) {
 		// Check database connection
-		err := database.DB.Ping()
+		err := db.Ping()
 		databaseStatus := "connected"
 		if err != nil {
 			databaseStatus = "disconnected"
@@ -190,20 +498,20 @@ func main() {
 	})
 
 	// Debug endpoint to test database with standardized response format
-	r.GET("/debug/db", func(c *gin.Context) {
+	r.GET("/debug/db", authenticate, can(models.PermissionSystemDebug), func(c *gin.Context) {
 		var productCount, customerCount, orderCount, supplierCount, warehouseCount int
 		var errorMsg string
 
 		// Get counts with error handling
-		if err := database.DB.QueryRow("SELECT COUNT(*) FROM products").Scan(&productCount); err != nil {
+		if err := db.QueryRow("SELECT COUNT(*) FROM products").Scan(&productCount); err != nil {
 			errorMsg = "Failed to count products: " + err.Error()
 			productCount = -1
 		}
-		if err := database.DB.QueryRow("SELECT COUNT(*) FROM customers").Scan(&customerCount); err != nil {
+		if err := db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&customerCount); err != nil {
 			errorMsg = "Failed to count customers: " + err.Error()
 			customerCount = -1
 		}
-		if err := database.DB.QueryRow("SELECT COUNT(*) FROM orders").Scan(&orderCount); err != nil {
+		if err := db.QueryRow("SELECT COUNT(*) FROM orders").Scan(&orderCount); err != nil {
 			errorMsg = "Failed to count orders: " + err.Error()
 			orderCount = -1
 		}
@@ -227,7 +535,7 @@ func main() {
 	})
 
 	// Test endpoint with standardized response format
-	r.POST("/api/test-simple", func(c *gin.Context) {
+	r.POST("/api/test-simple", authenticate, can(models.PermissionSystemDebug), func(c *gin.Context) {
 		log.Println("🎯 SIMPLE POST ENDPOINT HIT!")
 
 		var data map[string]interface{}
@@ -259,3 +567,50 @@ func main() {
 		log.Fatal("Failed to start server:", err)
 	}
 }
+
+// bootstrapAdmin makes sure someone can manage users. When no active admin exists and
+// ADMIN_PASSWORD is set, the ADMIN_USERNAME user (default "admin") is created, or
+// given the admin role if it already exists.
+func bootstrapAdmin(ctx context.Context, userRepo repositories.UserStore) error {
+	admins, err := userRepo.CountUsersWithRole(ctx, models.RoleAdmin)
+	if err != nil || admins > 0 {
+		return err
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		log.Println("⚠️ No admin user exists; set ADMIN_PASSWORD to create one")
+		return nil
+	}
+	username := os.Getenv("ADMIN_USERNAME")
+	if username == "" {
+		username = "admin"
+	}
+
+	existing, err := userRepo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if !existing.HasRole(models.RoleAdmin) {
+			existing.Roles = append(existing.Roles, models.RoleAdmin)
+		}
+		existing.Status = models.UserStatusActive
+		existing.UpdatedAt = time.Now()
+		if err := userRepo.UpdateUser(ctx, existing); err != nil {
+			return err
+		}
+		log.Printf("👤 Granted admin role to user %q", username)
+		return nil
+	}
+
+	user, err := models.NewUser(username, "", "Administrator", password, []string{models.RoleAdmin})
+	if err != nil {
+		return err
+	}
+	if err := userRepo.CreateUser(ctx, user); err != nil {
+		return err
+	}
+	log.Printf("👤 Created admin user %q", username)
+	return nil
+}
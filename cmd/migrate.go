@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"erp-project/database"
+)
+
+const migrateUsage = `usage: main migrate <command>
+
+commands:
+  up             apply all pending migrations
+  down [steps]   roll back the last applied migration, or the last steps migrations
+  status         list migrations and whether each has been applied
+  to <version>   apply or roll back until the schema is at version (0 rolls back everything)`
+
+// runMigrate handles `main migrate ...` and returns the process exit code
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	var ran []database.Migration
+	direction := "Applied"
+
+	switch args[0] {
+	case "up":
+		ran, err = db.MigrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, "steps must be a positive number")
+				return 2
+			}
+		}
+		direction = "Rolled back"
+		ran, err = db.MigrateDown(steps)
+	case "to":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < 0 {
+			fmt.Fprintln(os.Stderr, "version must be a non-negative number")
+			return 2
+		}
+		direction = "Ran"
+		ran, err = db.MigrateTo(version)
+	case "status":
+		return printMigrationStatus(db)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	for _, migration := range ran {
+		fmt.Printf("%s %04d_%s\n", direction, migration.Version, migration.Name)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(ran) == 0 {
+		fmt.Println("Nothing to do; schema is already at the requested version")
+	}
+	return 0
+}
+
+func printMigrationStatus(db *database.Conn) int {
+	statuses, err := db.Status()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d_%-40s %s\n", status.Version, status.Name, state)
+	}
+	return 0
+}
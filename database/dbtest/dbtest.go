@@ -0,0 +1,49 @@
+// Package dbtest runs tests against every database dialect the ERP supports
+package dbtest
+
+import (
+	"os"
+	"testing"
+
+	"erp-project/database"
+)
+
+// ForEachDialect runs fn against a freshly migrated in-memory SQLite database and, when
+// TEST_POSTGRES_URL is set, against PostgreSQL, so code is held to the same behaviour
+// on both. The PostgreSQL database is migrated down to nothing first, so point it at a
+// database the tests may wipe.
+func ForEachDialect(t *testing.T, fn func(t *testing.T, db *database.Conn)) {
+	t.Helper()
+
+	t.Run(string(database.SQLite), func(t *testing.T) {
+		db, err := database.Open(database.SQLite, ":memory:")
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		defer db.Close()
+		if _, err := db.MigrateUp(); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		fn(t, db)
+	})
+
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Log("TEST_POSTGRES_URL not set; skipping PostgreSQL")
+		return
+	}
+	t.Run(string(database.Postgres), func(t *testing.T) {
+		db, err := database.Open(database.Postgres, url)
+		if err != nil {
+			t.Fatalf("open postgres: %v", err)
+		}
+		defer db.Close()
+		if _, err := db.MigrateTo(0); err != nil {
+			t.Fatalf("reset: %v", err)
+		}
+		if _, err := db.MigrateUp(); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		fn(t, db)
+	})
+}
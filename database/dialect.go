@@ -0,0 +1,273 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Dialect papers over the SQL differences between the databases we run on. Queries are
+// written once, PostgreSQL style with $n placeholders, and rebound when they execute.
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// Rebind rewrites $n placeholders for the dialect. SQLite gets its own numbered ?n form,
+// so a placeholder can still be used more than once. Quoted text is left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != SQLite || !strings.Contains(query, "$") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query))
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			ch = '?'
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// Now is the SQL expression for the current timestamp
+func (d Dialect) Now() string {
+	if d == Postgres {
+		return "NOW()"
+	}
+	return "CURRENT_TIMESTAMP"
+}
+
+// Bool is the SQL literal for a boolean; SQLite stores booleans as integers
+func (d Dialect) Bool(value bool) string {
+	switch {
+	case d == Postgres && value:
+		return "TRUE"
+	case d == Postgres:
+		return "FALSE"
+	case value:
+		return "1"
+	default:
+		return "0"
+	}
+}
+
+// Like matches column case-insensitively against the placeholder on both dialects.
+// SQLite's LIKE already ignores case for ASCII; PostgreSQL needs ILIKE.
+func (d Dialect) Like(column, placeholder string) string {
+	if d == Postgres {
+		return column + " ILIKE " + placeholder
+	}
+	return column + " LIKE " + placeholder
+}
+
+// Upsert is the clause that follows an INSERT to update the given columns when a row
+// with the same conflict columns already exists. With no update columns the insert is
+// skipped instead.
+func (d Dialect) Upsert(conflictColumns []string, updateColumns ...string) string {
+	clause := fmt.Sprintf("ON CONFLICT (%s) DO ", strings.Join(conflictColumns, ", "))
+	if len(updateColumns) == 0 {
+		return clause + "NOTHING"
+	}
+
+	sets := make([]string, len(updateColumns))
+	for i, column := range updateColumns {
+		sets[i] = fmt.Sprintf("%s = excluded.%s", column, column)
+	}
+	return clause + "UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// Returning is the clause that makes an INSERT, UPDATE or DELETE return columns of the
+// rows it touched. SQLite supports it from 3.35.
+func (d Dialect) Returning(columns ...string) string {
+	return "RETURNING " + strings.Join(columns, ", ")
+}
+
+// DialectValuer is implemented by values stored differently on each dialect, such as
+// money, which is DECIMAL on PostgreSQL and a whole number of minor units on SQLite.
+// Conn and Tx convert such arguments before they reach the driver.
+type DialectValuer interface {
+	DialectValue(dialect Dialect) driver.Value
+}
+
+// bindArgs converts DialectValuer arguments for the dialect, leaving the caller's slice
+// untouched
+func (d Dialect) bindArgs(args []interface{}) []interface{} {
+	var bound []interface{}
+	for i, arg := range args {
+		valuer, ok := arg.(DialectValuer)
+		if !ok {
+			continue
+		}
+		if bound == nil {
+			bound = append([]interface{}(nil), args...)
+		}
+		if v := reflect.ValueOf(arg); v.Kind() == reflect.Pointer && v.IsNil() {
+			bound[i] = nil
+		} else {
+			bound[i] = valuer.DialectValue(d)
+		}
+	}
+	if bound == nil {
+		return args
+	}
+	return bound
+}
+
+// Conn is a database handle that rebinds every query and its arguments for its dialect. When QueryTimeout
+// is set, each statement is cancelled once it runs that long, on top of any deadline
+// the caller's context already carries.
+type Conn struct {
+	*sql.DB
+	Dialect      Dialect
+	QueryTimeout time.Duration
+}
+
+func (db *Conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(ctx, db.QueryTimeout)
+	defer cancel()
+	result, err := db.DB.ExecContext(ctx, db.Dialect.Rebind(query), db.Dialect.bindArgs(args)...)
+	return result, contextError(ctx, err)
+}
+
+func (db *Conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+func (db *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, cancel := withQueryTimeout(ctx, db.QueryTimeout)
+	rows, err := db.DB.QueryContext(ctx, db.Dialect.Rebind(query), db.Dialect.bindArgs(args)...)
+	if err != nil {
+		cancel()
+	}
+	return rows, contextError(ctx, err)
+}
+
+func (db *Conn) QueryRow(query string, args ...interface{}) *Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+func (db *Conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, cancel := withQueryTimeout(ctx, db.QueryTimeout)
+	return &Row{Row: db.DB.QueryRowContext(ctx, db.Dialect.Rebind(query), db.Dialect.bindArgs(args)...), ctx: ctx, cancel: cancel}
+}
+
+func (db *Conn) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+// BeginTx starts a transaction bound to ctx: it is rolled back if ctx ends first.
+// Statements inside it get QueryTimeout each.
+func (db *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, Dialect: db.Dialect, QueryTimeout: db.QueryTimeout}, nil
+}
+
+// Tx is a transaction that rebinds every query and its arguments for its dialect
+type Tx struct {
+	*sql.Tx
+	Dialect      Dialect
+	QueryTimeout time.Duration
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(ctx, tx.QueryTimeout)
+	defer cancel()
+	result, err := tx.Tx.ExecContext(ctx, tx.Dialect.Rebind(query), tx.Dialect.bindArgs(args)...)
+	return result, contextError(ctx, err)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.QueryContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, cancel := withQueryTimeout(ctx, tx.QueryTimeout)
+	rows, err := tx.Tx.QueryContext(ctx, tx.Dialect.Rebind(query), tx.Dialect.bindArgs(args)...)
+	if err != nil {
+		cancel()
+	}
+	return rows, contextError(ctx, err)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *Row {
+	return tx.QueryRowContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, cancel := withQueryTimeout(ctx, tx.QueryTimeout)
+	return &Row{Row: tx.Tx.QueryRowContext(ctx, tx.Dialect.Rebind(query), tx.Dialect.bindArgs(args)...), ctx: ctx, cancel: cancel}
+}
+
+// Row is the result of QueryRowContext. The statement's timeout stays in force until
+// the row is scanned, which releases it.
+type Row struct {
+	*sql.Row
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *Row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return contextError(r.ctx, r.Row.Scan(dest...))
+}
+
+// withQueryTimeout bounds one statement. Rows keep reading through the context after
+// the query returns, so QueryContext cannot cancel it itself; the deadline timer
+// releases it instead. QueryRowContext leaves it to Row.Scan.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// contextError makes a failure caused by ctx ending recognisable as such; drivers
+// report an interrupted statement in their own terms
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ctx.Err(), err)
+}
+
+// IsTimeout reports whether err means a statement ran past its deadline or was cancelled
+// by the database because of one
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	// query_canceled: PostgreSQL stopped the statement when lib/pq relayed the cancellation
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
@@ -0,0 +1,272 @@
+package database_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"erp-project/database"
+	"erp-project/database/dbtest"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect database.Dialect
+		query   string
+		want    string
+	}{
+		{database.Postgres, `SELECT * FROM t WHERE a = $1 AND b = $2`, `SELECT * FROM t WHERE a = $1 AND b = $2`},
+		{database.SQLite, `SELECT * FROM t WHERE a = $1 AND b = $2`, `SELECT * FROM t WHERE a = ?1 AND b = ?2`},
+		{database.SQLite, `SELECT * FROM t WHERE a LIKE $1 OR b LIKE $1`, `SELECT * FROM t WHERE a LIKE ?1 OR b LIKE ?1`},
+		{database.SQLite, `LIMIT $10 OFFSET $11`, `LIMIT ?10 OFFSET ?11`},
+		{database.SQLite, `SELECT '$1', "$2" FROM t WHERE a = $3`, `SELECT '$1', "$2" FROM t WHERE a = ?3`},
+		{database.SQLite, `SELECT 'it''s $1' WHERE a = $1`, `SELECT 'it''s $1' WHERE a = ?1`},
+		{database.SQLite, `SELECT price * 2 AS "$" FROM t`, `SELECT price * 2 AS "$" FROM t`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.dialect.Rebind(tt.query); got != tt.want {
+			t.Errorf("%s.Rebind(%q) = %q, want %q", tt.dialect, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestUpsertClause(t *testing.T) {
+	got := database.SQLite.Upsert([]string{"a", "b"}, "c", "d")
+	want := "ON CONFLICT (a, b) DO UPDATE SET c = excluded.c, d = excluded.d"
+	if got != want {
+		t.Errorf("Upsert = %q, want %q", got, want)
+	}
+	if got := database.Postgres.Upsert([]string{"a"}); got != "ON CONFLICT (a) DO NOTHING" {
+		t.Errorf("Upsert without updates = %q", got)
+	}
+}
+
+// TestDialectAgainstDatabase runs every helper against each real database, so the SQL
+// they produce is known to behave the same on both
+func TestDialectAgainstDatabase(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		d := db.Dialect
+		if _, err := db.Exec(`CREATE TABLE dialect_check (
+			id INTEGER PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			active BOOLEAN NOT NULL,
+			checked_at TIMESTAMP
+		)`); err != nil {
+			t.Fatalf("create table: %v", err)
+		}
+		defer db.Exec(`DROP TABLE dialect_check`)
+
+		// Placeholders, reused placeholders and RETURNING
+		var id int
+		err := db.QueryRow(
+			`INSERT INTO dialect_check (id, name, active, checked_at) VALUES ($1, $2, $3, `+d.Now()+`) `+d.Returning("id"),
+			1, "Widget", true,
+		).Scan(&id)
+		if err != nil || id != 1 {
+			t.Fatalf("insert returning: id=%d err=%v", id, err)
+		}
+
+		// Upsert updates the existing row instead of failing
+		_, err = db.Exec(
+			`INSERT INTO dialect_check (id, name, active) VALUES ($1, $2, $3) `+d.Upsert([]string{"id"}, "name"),
+			1, "Gadget", true,
+		)
+		if err != nil {
+			t.Fatalf("upsert: %v", err)
+		}
+		_, err = db.Exec(
+			`INSERT INTO dialect_check (id, name, active) VALUES ($1, $2, $3) `+d.Upsert([]string{"id"}),
+			1, "Ignored", false,
+		)
+		if err != nil {
+			t.Fatalf("upsert do nothing: %v", err)
+		}
+
+		var name string
+		var checkedAt time.Time
+		err = db.QueryRow(
+			`SELECT name, checked_at FROM dialect_check WHERE active = `+d.Bool(true)+` AND (`+d.Like("name", "$1")+` OR `+d.Like("name", "$1")+`)`,
+			"%gad%",
+		).Scan(&name, &checkedAt)
+		if err != nil {
+			t.Fatalf("select: %v", err)
+		}
+		if name != "Gadget" {
+			t.Errorf("name = %q, want Gadget", name)
+		}
+		if time.Since(checkedAt) > time.Hour || time.Since(checkedAt) < -time.Hour {
+			t.Errorf("checked_at = %v, want about now", checkedAt)
+		}
+
+		var inactive int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM dialect_check WHERE active = ` + d.Bool(false)).Scan(&inactive); err != nil || inactive != 0 {
+			t.Errorf("inactive rows = %d, err = %v", inactive, err)
+		}
+
+		// Transactions rebind too
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("begin: %v", err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`UPDATE dialect_check SET name = $1 WHERE id = $2`, "Gizmo", 1); err != nil {
+			t.Fatalf("tx update: %v", err)
+		}
+		if err := tx.QueryRow(`SELECT name FROM dialect_check WHERE id = $1`, 1).Scan(&name); err != nil || name != "Gizmo" {
+			t.Errorf("tx select: name=%q err=%v", name, err)
+		}
+	})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		db.QueryTimeout = 50 * time.Millisecond
+
+		slow := `WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n WHERE x < 1000000000) SELECT COUNT(*) FROM n`
+		start := time.Now()
+		_, err := db.ExecContext(t.Context(), slow)
+		if !database.IsTimeout(err) {
+			t.Fatalf("slow statement: err = %v, want a timeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("slow statement ran for %s after its deadline", elapsed)
+		}
+
+		// Other statements are unaffected
+		var one int
+		if err := db.QueryRowContext(t.Context(), `SELECT 1`).Scan(&one); err != nil || one != 1 {
+			t.Errorf("fast statement: %d err=%v", one, err)
+		}
+	})
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		if err := db.CheckMigrations(); err != nil {
+			t.Fatalf("check after migrate up: %v", err)
+		}
+		if _, err := db.MigrateTo(0); err != nil {
+			t.Fatalf("migrate to 0: %v", err)
+		}
+		if err := db.CheckMigrations(); err == nil {
+			t.Fatal("check passed on an empty schema")
+		}
+		if _, err := db.MigrateUp(); err != nil {
+			t.Fatalf("migrate up again: %v", err)
+		}
+		if err := db.CheckMigrations(); err != nil {
+			t.Fatalf("check after second migrate up: %v", err)
+		}
+	})
+}
+
+func TestMigrateDownAndTo(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		migrations, err := db.Migrations()
+		if err != nil || len(migrations) < 3 {
+			t.Fatalf("migrations: %d err=%v", len(migrations), err)
+		}
+		latest := migrations[len(migrations)-1].Version
+
+		if _, err := db.MigrateDown(0); err == nil {
+			t.Error("migrate down 0 steps passed")
+		}
+		if ran, err := db.MigrateTo(latest + 1); err == nil || len(ran) != 0 {
+			t.Errorf("migrate to unknown version: ran %d err=%v", len(ran), err)
+		}
+
+		// Rolling back goes newest first and leaves the schema behind this build
+		rolledBack, err := db.MigrateDown(2)
+		if err != nil || len(rolledBack) != 2 || rolledBack[0].Version != latest || rolledBack[1].Version != migrations[len(migrations)-2].Version {
+			t.Fatalf("migrate down 2: %+v err=%v", rolledBack, err)
+		}
+		statuses, err := db.Status()
+		if err != nil || len(statuses) != len(migrations) || !statuses[0].Applied || statuses[len(statuses)-1].Applied || statuses[len(statuses)-2].Applied {
+			t.Errorf("status after migrate down: %+v err=%v", statuses, err)
+		}
+		if err := db.CheckMigrations(); err == nil {
+			t.Error("check passed with pending migrations")
+		}
+
+		// Migrating to a version applies only what lies up to it
+		target := migrations[len(migrations)-2].Version
+		if ran, err := db.MigrateTo(target); err != nil || len(ran) != 1 || ran[0].Version != target {
+			t.Errorf("migrate to %d: %+v err=%v", target, ran, err)
+		}
+		if ran, err := db.MigrateUp(); err != nil || len(ran) != 1 || ran[0].Version != latest {
+			t.Errorf("migrate up: %+v err=%v", ran, err)
+		}
+		if err := db.CheckMigrations(); err != nil {
+			t.Errorf("check after migrate up: %v", err)
+		}
+
+		// A database migrated past this build is refused
+		if _, err := db.Exec(fmt.Sprintf("INSERT INTO schema_migrations (version, name) VALUES (%d, 'from_the_future')", latest+1)); err != nil {
+			t.Fatalf("record unknown migration: %v", err)
+		}
+		if err := db.CheckMigrations(); err == nil {
+			t.Error("check passed with an unknown migration applied")
+		}
+		if _, err := db.Exec(fmt.Sprintf("DELETE FROM schema_migrations WHERE version = %d", latest+1)); err != nil {
+			t.Errorf("forget unknown migration: %v", err)
+		}
+	})
+}
+
+func TestSupplierPaymentTermsMigration(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		if _, err := db.MigrateTo(9); err != nil {
+			t.Fatalf("migrate to 9: %v", err)
+		}
+		legacy := map[string]string{
+			"net":     "Net 45",
+			"short":   "N60",
+			"early":   "2/10 Net 30",
+			"decimal": "1.5%/15, net 45 days",
+			"receipt": "Due on receipt",
+			"prose":   "end of month",
+			"late":    "2/40 net 30",
+		}
+		for code, terms := range legacy {
+			if _, err := db.Exec(`INSERT INTO suppliers (id, name, code, payment_terms) VALUES ($1, $2, $3, $4)`,
+				code, code, code, terms); err != nil {
+				t.Fatalf("insert %s: %v", code, err)
+			}
+		}
+		if _, err := db.MigrateUp(); err != nil {
+			t.Fatalf("migrate up: %v", err)
+		}
+
+		want := map[string]string{
+			"net":     "45 0 0",
+			"short":   "60 0 0",
+			"early":   "30 2 10",
+			"decimal": "45 1.5 15",
+			"receipt": "0 0 0",
+			"prose":   "30 0 0",
+			"late":    "30 0 0",
+		}
+		for code, terms := range want {
+			var netDays, discountDays int
+			var percent float64
+			var text string
+			err := db.QueryRow(`SELECT payment_terms_net_days, payment_terms_discount_percent, payment_terms_discount_days, legacy_payment_terms
+				FROM suppliers WHERE code = $1`, code).Scan(&netDays, &percent, &discountDays, &text)
+			if db.Dialect == database.SQLite {
+				percent /= 10000
+			}
+			if got := fmt.Sprintf("%d %g %d", netDays, percent, discountDays); err != nil || got != terms || text != legacy[code] {
+				t.Errorf("%q: terms %s, legacy %q, err %v; want %s", legacy[code], got, text, err, terms)
+			}
+		}
+
+		if _, err := db.MigrateTo(9); err != nil {
+			t.Fatalf("migrate back to 9: %v", err)
+		}
+		var text string
+		if err := db.QueryRow(`SELECT payment_terms FROM suppliers WHERE code = 'prose'`).Scan(&text); err != nil || text != "end of month" {
+			t.Errorf("terms after migrating down: %q err=%v", text, err)
+		}
+	})
+}
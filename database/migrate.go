@@ -0,0 +1,277 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/ as NNNN_name.up.sql and NNNN_name.down.sql.
+// Both dialects must define the same versions. Never edit a migration that has shipped;
+// add a new one instead.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+const schemaMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);`
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied to the database
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrations returns the migrations for the connected dialect, oldest first
+func (db *Conn) Migrations() ([]Migration, error) {
+	dir := path.Join("migrations", string(db.Dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for dialect %q: %w", db.Dialect, err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected file in %s: %s", dir, entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status lists every known migration and whether it has been applied
+func (db *Conn) Status() ([]MigrationStatus, error) {
+	migrations, err := db.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// CheckMigrations fails when a migration has not been applied, or when the database has
+// been migrated past what this build knows about
+func (db *Conn) CheckMigrations() error {
+	migrations, err := db.Migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	known := map[int]bool{}
+	pending := 0
+	for _, migration := range migrations {
+		known[migration.Version] = true
+		if _, ok := applied[migration.Version]; !ok {
+			pending++
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("database has migration %d applied, which this build does not know; deploy a newer build or migrate down", version)
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("database schema is out of date: %d pending migration(s); run `migrate up` first", pending)
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration and returns the ones it applied
+func (db *Conn) MigrateUp() ([]Migration, error) {
+	migrations, err := db.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, nil
+	}
+	return db.MigrateTo(migrations[len(migrations)-1].Version)
+}
+
+// MigrateDown rolls back the most recently applied migrations, steps at a time
+func (db *Conn) MigrateDown(steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("steps must be at least 1")
+	}
+	migrations, err := db.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	for i := len(migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		if _, ok := applied[migrations[i].Version]; !ok {
+			continue
+		}
+		if err := db.runMigration(migrations[i], false); err != nil {
+			return rolledBack, err
+		}
+		rolledBack = append(rolledBack, migrations[i])
+	}
+	return rolledBack, nil
+}
+
+// MigrateTo moves the schema to version: pending migrations up to it are applied and
+// applied migrations after it are rolled back. Version 0 rolls back everything.
+func (db *Conn) MigrateTo(version int) ([]Migration, error) {
+	migrations, err := db.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 {
+		found := false
+		for _, migration := range migrations {
+			found = found || migration.Version == version
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown migration version %d", version)
+		}
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	// Roll back newest first, then apply oldest first
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[migrations[i].Version]; ok && migrations[i].Version > version {
+			if err := db.runMigration(migrations[i], false); err != nil {
+				return ran, err
+			}
+			ran = append(ran, migrations[i])
+		}
+	}
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			if err := db.runMigration(migration, true); err != nil {
+				return ran, err
+			}
+			ran = append(ran, migration)
+		}
+	}
+	return ran, nil
+}
+
+// runMigration executes one direction of a migration and records it in the same
+// transaction, so a failed migration leaves no trace
+func (db *Conn) runMigration(migration Migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, direction := migration.Up, "up"
+	if !up {
+		script, direction = migration.Down, "down"
+	}
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %d_%s %s failed: %w", migration.Version, migration.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			migration.Version, migration.Name, time.Now())
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return tx.Commit()
+}
+
+// appliedMigrations returns when each applied version was applied, creating the
+// schema_migrations table on first use
+func (db *Conn) appliedMigrations() (map[int]time.Time, error) {
+	if _, err := db.Exec(schemaMigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
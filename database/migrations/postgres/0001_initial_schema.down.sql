@@ -0,0 +1,24 @@
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS purchase_order_receipts;
+DROP TABLE IF EXISTS purchase_order_lines;
+DROP TABLE IF EXISTS purchase_orders;
+DROP TABLE IF EXISTS transfer_receipts;
+DROP TABLE IF EXISTS transfer_order_lines;
+DROP TABLE IF EXISTS transfer_orders;
+DROP TABLE IF EXISTS stock_movements;
+DROP TABLE IF EXISTS order_allocations;
+DROP TABLE IF EXISTS order_cancellations;
+DROP TABLE IF EXISTS order_status_history;
+DROP TABLE IF EXISTS inventory;
+DROP TABLE IF EXISTS warehouse_locations;
+DROP TABLE IF EXISTS warehouses;
+DROP TABLE IF EXISTS product_suppliers;
+DROP TABLE IF EXISTS suppliers;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS customers;
+DROP TABLE IF EXISTS products;
@@ -0,0 +1,337 @@
+-- Schema as created by the original createTables. IF NOT EXISTS lets this baseline
+-- an existing database that predates schema_migrations.
+
+CREATE TABLE IF NOT EXISTS products (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    sku VARCHAR(255) UNIQUE NOT NULL,
+    price DECIMAL(10,2) NOT NULL,
+    quantity INTEGER NOT NULL,
+    category VARCHAR(100),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS customers (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) UNIQUE NOT NULL,
+    phone VARCHAR(50),
+    address TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id VARCHAR(255) PRIMARY KEY,
+    customer_id VARCHAR(255) NOT NULL,
+    total_amount DECIMAL(10,2) NOT NULL,
+    status VARCHAR(50) DEFAULT 'pending',
+    order_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id VARCHAR(255) PRIMARY KEY,
+    order_id VARCHAR(255) NOT NULL,
+    product_id VARCHAR(255) NOT NULL,
+    quantity INTEGER NOT NULL,
+    cancelled_quantity INTEGER DEFAULT 0,
+    unit_price DECIMAL(10,2) NOT NULL,
+    total_price DECIMAL(10,2) NOT NULL,
+    status VARCHAR(50) DEFAULT 'active',
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS suppliers (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    code VARCHAR(50) UNIQUE NOT NULL,
+    contact_person VARCHAR(255),
+    email VARCHAR(255),
+    phone VARCHAR(50),
+    address TEXT,
+    tax_id VARCHAR(100),
+    payment_terms TEXT,
+    status VARCHAR(50) DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS product_suppliers (
+    id VARCHAR(255) PRIMARY KEY,
+    product_id VARCHAR(255) NOT NULL,
+    supplier_id VARCHAR(255) NOT NULL,
+    supplier_sku VARCHAR(100),
+    cost_price DECIMAL(10,2),
+    lead_time_days INTEGER,
+    is_primary BOOLEAN DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (supplier_id) REFERENCES suppliers(id) ON DELETE CASCADE,
+    UNIQUE(product_id, supplier_id)
+);
+
+CREATE TABLE IF NOT EXISTS warehouses (
+    id VARCHAR(255) PRIMARY KEY,
+    code VARCHAR(50) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    location TEXT,
+    manager_name VARCHAR(255),
+    phone VARCHAR(50),
+    email VARCHAR(255),
+    capacity INTEGER,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    status VARCHAR(50) DEFAULT 'active', --active, inactive
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS warehouse_locations (
+    id VARCHAR(255) PRIMARY KEY,
+    warehouse_id VARCHAR(255) NOT NULL,
+    location_code VARCHAR(100) NOT NULL,
+    location_name VARCHAR(255),
+    zone VARCHAR(50),
+    row_number INTEGER,
+    shelf_number INTEGER,
+    max_capacity INTEGER,
+    current_quantity INTEGER DEFAULT 0,
+    status VARCHAR(50) DEFAULT 'available', -- available, full, inactive, ocupied, reserved, maintanance
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses(id) ON DELETE CASCADE,
+    UNIQUE(warehouse_id, location_code)
+);
+
+CREATE TABLE IF NOT EXISTS inventory (
+    id VARCHAR(255) PRIMARY KEY,
+    product_id VARCHAR(255) NOT NULL,
+    warehouse_id VARCHAR(255) NOT NULL,
+    location_id VARCHAR(255),
+    quantity INTEGER NOT NULL DEFAULT 0,
+    reserved_quantity INTEGER DEFAULT 0,
+    min_quantity INTEGER DEFAULT 0,
+    max_quantity INTEGER,
+    last_restocked TIMESTAMP,
+    last_checked TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses(id) ON DELETE CASCADE,
+    FOREIGN KEY (location_id) REFERENCES warehouse_locations(id) ON DELETE SET NULL,
+    UNIQUE(product_id, warehouse_id, location_id)
+);
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id VARCHAR(255) PRIMARY KEY,
+    order_id VARCHAR(255) NOT NULL,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    reason TEXT,
+    changed_by VARCHAR(255),
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_cancellations (
+    id VARCHAR(255) PRIMARY KEY,
+    order_id VARCHAR(255) NOT NULL,
+    order_item_id VARCHAR(255) NOT NULL,
+    product_id VARCHAR(255) NOT NULL,
+    quantity INTEGER NOT NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    reason TEXT NOT NULL,
+    cancelled_by VARCHAR(255),
+    cancelled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_allocations (
+    id VARCHAR(255) PRIMARY KEY,
+    order_id VARCHAR(255) NOT NULL,
+    order_item_id VARCHAR(255) NOT NULL,
+    product_id VARCHAR(255) NOT NULL,
+    warehouse_id VARCHAR(255) NOT NULL,
+    inventory_id VARCHAR(255) NOT NULL,
+    location_id VARCHAR(255),
+    quantity INTEGER NOT NULL,
+    status VARCHAR(50) DEFAULT 'reserved', -- reserved, shipped, released
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE,
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses(id),
+    FOREIGN KEY (inventory_id) REFERENCES inventory(id)
+);
+
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id VARCHAR(255) PRIMARY KEY,
+    movement_type VARCHAR(50) NOT NULL, -- receipt, issue, adjustment, transfer, return, count_correction
+    product_id VARCHAR(255) NOT NULL,
+    warehouse_id VARCHAR(255), -- NULL for movements of products.quantity
+    location_id VARCHAR(255),
+    inventory_id VARCHAR(255),
+    quantity INTEGER NOT NULL, -- signed change
+    quantity_before INTEGER NOT NULL,
+    quantity_after INTEGER NOT NULL,
+    reference_type VARCHAR(50),
+    reference_id VARCHAR(255),
+    reason TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS transfer_orders (
+    id VARCHAR(255) PRIMARY KEY,
+    source_warehouse_id VARCHAR(255) NOT NULL,
+    destination_warehouse_id VARCHAR(255) NOT NULL,
+    status VARCHAR(50) DEFAULT 'draft', -- draft, in_transit, received, cancelled
+    notes TEXT,
+    created_by VARCHAR(255),
+    dispatched_at TIMESTAMP,
+    received_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (source_warehouse_id) REFERENCES warehouses(id),
+    FOREIGN KEY (destination_warehouse_id) REFERENCES warehouses(id)
+);
+
+CREATE TABLE IF NOT EXISTS transfer_order_lines (
+    id VARCHAR(255) PRIMARY KEY,
+    transfer_order_id VARCHAR(255) NOT NULL,
+    product_id VARCHAR(255) NOT NULL,
+    source_location_id VARCHAR(255),
+    destination_location_id VARCHAR(255),
+    source_inventory_id VARCHAR(255),
+    quantity INTEGER NOT NULL,
+    received_quantity INTEGER DEFAULT 0,
+    discrepancy_quantity INTEGER DEFAULT 0,
+    FOREIGN KEY (transfer_order_id) REFERENCES transfer_orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE TABLE IF NOT EXISTS transfer_receipts (
+    id VARCHAR(255) PRIMARY KEY,
+    transfer_order_id VARCHAR(255) NOT NULL,
+    transfer_order_line_id VARCHAR(255) NOT NULL,
+    product_id VARCHAR(255) NOT NULL,
+    location_id VARCHAR(255),
+    destination_inventory_id VARCHAR(255),
+    received_quantity INTEGER NOT NULL DEFAULT 0,
+    discrepancy_quantity INTEGER NOT NULL DEFAULT 0,
+    discrepancy_reason TEXT,
+    received_by VARCHAR(255),
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (transfer_order_id) REFERENCES transfer_orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (transfer_order_line_id) REFERENCES transfer_order_lines(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS purchase_orders (
+    id VARCHAR(255) PRIMARY KEY,
+    po_number VARCHAR(50) UNIQUE NOT NULL,
+    supplier_id VARCHAR(255) NOT NULL,
+    warehouse_id VARCHAR(255),
+    status VARCHAR(50) DEFAULT 'draft', -- draft, approved, sent, partially_received, received, closed, cancelled
+    order_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expected_date TIMESTAMP,
+    total_amount DECIMAL(10,2) NOT NULL DEFAULT 0,
+    notes TEXT,
+    created_by VARCHAR(255),
+    approved_by VARCHAR(255),
+    approved_at TIMESTAMP,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses(id)
+);
+
+CREATE TABLE IF NOT EXISTS purchase_order_lines (
+    id VARCHAR(255) PRIMARY KEY,
+    purchase_order_id VARCHAR(255) NOT NULL,
+    product_id VARCHAR(255) NOT NULL,
+    supplier_sku VARCHAR(100),
+    quantity INTEGER NOT NULL,
+    received_quantity INTEGER DEFAULT 0,
+    unit_cost DECIMAL(10,2) NOT NULL,
+    total_cost DECIMAL(10,2) NOT NULL,
+    lead_time_days INTEGER DEFAULT 0,
+    expected_date TIMESTAMP,
+    FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE TABLE IF NOT EXISTS purchase_order_receipts (
+    id VARCHAR(255) PRIMARY KEY,
+    purchase_order_id VARCHAR(255) NOT NULL,
+    purchase_order_line_id VARCHAR(255) NOT NULL,
+    product_id VARCHAR(255) NOT NULL,
+    warehouse_id VARCHAR(255) NOT NULL,
+    location_id VARCHAR(255),
+    inventory_id VARCHAR(255),
+    quantity INTEGER NOT NULL,
+    notes TEXT,
+    received_by VARCHAR(255),
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (purchase_order_line_id) REFERENCES purchase_order_lines(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(255) PRIMARY KEY,
+    username VARCHAR(255) UNIQUE NOT NULL,
+    email VARCHAR(255) UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    full_name VARCHAR(255),
+    status VARCHAR(50) DEFAULT 'active', -- active, inactive
+    last_login_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    key_prefix VARCHAR(50) NOT NULL,
+    key_hash VARCHAR(255) UNIQUE NOT NULL,
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id VARCHAR(255) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL, -- admin, sales, warehouse, purchasing, viewer
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id VARCHAR(255) PRIMARY KEY,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    action VARCHAR(50) NOT NULL, -- create, update, delete
+    actor_id VARCHAR(255),
+    actor_name VARCHAR(255),
+    changes TEXT NOT NULL, -- JSON object of field: {before, after}
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
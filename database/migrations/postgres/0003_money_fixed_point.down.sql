@@ -0,0 +1,9 @@
+ALTER TABLE products ALTER COLUMN price TYPE DECIMAL(10,2);
+ALTER TABLE orders ALTER COLUMN total_amount TYPE DECIMAL(10,2);
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE DECIMAL(10,2);
+ALTER TABLE order_items ALTER COLUMN total_price TYPE DECIMAL(10,2);
+ALTER TABLE product_suppliers ALTER COLUMN cost_price TYPE DECIMAL(10,2);
+ALTER TABLE order_cancellations ALTER COLUMN amount TYPE DECIMAL(10,2);
+ALTER TABLE purchase_orders ALTER COLUMN total_amount TYPE DECIMAL(10,2);
+ALTER TABLE purchase_order_lines ALTER COLUMN unit_cost TYPE DECIMAL(10,2);
+ALTER TABLE purchase_order_lines ALTER COLUMN total_cost TYPE DECIMAL(10,2);
@@ -0,0 +1,11 @@
+-- Money columns hold exact amounts with four decimal places: DECIMAL(19,4) instead of
+-- DECIMAL(10,2), so sub-cent unit costs and large totals fit
+ALTER TABLE products ALTER COLUMN price TYPE DECIMAL(19,4);
+ALTER TABLE orders ALTER COLUMN total_amount TYPE DECIMAL(19,4);
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE DECIMAL(19,4);
+ALTER TABLE order_items ALTER COLUMN total_price TYPE DECIMAL(19,4);
+ALTER TABLE product_suppliers ALTER COLUMN cost_price TYPE DECIMAL(19,4);
+ALTER TABLE order_cancellations ALTER COLUMN amount TYPE DECIMAL(19,4);
+ALTER TABLE purchase_orders ALTER COLUMN total_amount TYPE DECIMAL(19,4);
+ALTER TABLE purchase_order_lines ALTER COLUMN unit_cost TYPE DECIMAL(19,4);
+ALTER TABLE purchase_order_lines ALTER COLUMN total_cost TYPE DECIMAL(19,4);
@@ -0,0 +1,24 @@
+-- Amounts carry the currency they are in. Everything recorded before now is in USD,
+-- the only currency there was.
+ALTER TABLE products ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE product_suppliers ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE purchase_orders ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+
+-- Orders keep the rate to the base currency they were placed at, and their total in it
+ALTER TABLE orders ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE orders ADD COLUMN exchange_rate DECIMAL(18,8) NOT NULL DEFAULT 1;
+ALTER TABLE orders ADD COLUMN base_total_amount DECIMAL(19,4) NOT NULL DEFAULT 0;
+UPDATE orders SET base_total_amount = total_amount;
+
+-- What one unit of currency is worth in base_currency from effective_date on
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id VARCHAR(36) PRIMARY KEY,
+    base_currency VARCHAR(3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    rate DECIMAL(18,8) NOT NULL,
+    effective_date TIMESTAMP NOT NULL,
+    source VARCHAR(20) NOT NULL, -- api, csv
+    created_by VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(base_currency, currency, effective_date)
+);
@@ -0,0 +1,72 @@
+-- Discount rules. Eligibility columns left empty match every product or customer.
+CREATE TABLE IF NOT EXISTS promotions (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    type VARCHAR(20) NOT NULL, -- percentage, fixed, buy_x_get_y
+    scope VARCHAR(10) NOT NULL, -- line, order
+    value DECIMAL(19,4) NOT NULL DEFAULT 0,
+    currency VARCHAR(3) NOT NULL,
+    buy_quantity INTEGER NOT NULL DEFAULT 0,
+    get_quantity INTEGER NOT NULL DEFAULT 0,
+    min_order_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    product_id VARCHAR(255),
+    category VARCHAR(100) NOT NULL DEFAULT '',
+    customer_id VARCHAR(255),
+    customer_group VARCHAR(100) NOT NULL DEFAULT '',
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP,
+    requires_coupon BOOLEAN NOT NULL DEFAULT false,
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+    FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE
+);
+
+-- Codes that unlock a promotion. A usage limit of 0 is unlimited.
+CREATE TABLE IF NOT EXISTS coupons (
+    id VARCHAR(36) PRIMARY KEY,
+    code VARCHAR(50) UNIQUE NOT NULL,
+    promotion_id VARCHAR(36) NOT NULL,
+    usage_limit INTEGER NOT NULL DEFAULT 0,
+    per_customer_limit INTEGER NOT NULL DEFAULT 0,
+    times_used INTEGER NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (promotion_id) REFERENCES promotions(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id VARCHAR(36) PRIMARY KEY,
+    coupon_id VARCHAR(36) NOT NULL,
+    order_id VARCHAR(255) NOT NULL,
+    customer_id VARCHAR(255) NOT NULL,
+    redeemed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_customer ON coupon_redemptions (coupon_id, customer_id);
+
+ALTER TABLE orders ADD COLUMN coupon_id VARCHAR(36);
+
+-- Each promotion's discount on each line. total_price is net of discount_amount.
+ALTER TABLE order_items ADD COLUMN discount_amount DECIMAL(19,4) NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS order_item_discounts (
+    id VARCHAR(36) PRIMARY KEY,
+    order_id VARCHAR(255) NOT NULL,
+    order_item_id VARCHAR(255) NOT NULL,
+    promotion_id VARCHAR(36) NOT NULL,
+    coupon_id VARCHAR(36),
+    description VARCHAR(255) NOT NULL,
+    scope VARCHAR(10) NOT NULL, -- line, order
+    amount DECIMAL(19,4) NOT NULL,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_item_discounts_order ON order_item_discounts (order_id);
@@ -0,0 +1,22 @@
+ALTER TABLE order_items DROP COLUMN tax_amount;
+ALTER TABLE order_items DROP COLUMN tax_rate;
+ALTER TABLE order_items DROP COLUMN tax_rate_id;
+ALTER TABLE order_items DROP COLUMN tax_category;
+
+ALTER TABLE orders DROP COLUMN tax_exemption_number;
+ALTER TABLE orders DROP COLUMN tax_exempt;
+ALTER TABLE orders DROP COLUMN tax_region;
+ALTER TABLE orders DROP COLUMN tax_country;
+ALTER TABLE orders DROP COLUMN prices_include_tax;
+ALTER TABLE orders DROP COLUMN tax_amount;
+
+DROP TABLE IF EXISTS tax_rates;
+
+ALTER TABLE customers DROP COLUMN tax_exemption_expires_at;
+ALTER TABLE customers DROP COLUMN tax_exemption_number;
+ALTER TABLE customers DROP COLUMN tax_exempt;
+ALTER TABLE customers DROP COLUMN postal_code;
+ALTER TABLE customers DROP COLUMN region;
+ALTER TABLE customers DROP COLUMN country;
+
+ALTER TABLE products DROP COLUMN tax_category;
@@ -0,0 +1,42 @@
+-- Products are taxed by category; customers by where they are, unless exempt
+ALTER TABLE products ADD COLUMN tax_category VARCHAR(50) NOT NULL DEFAULT 'standard';
+
+ALTER TABLE customers ADD COLUMN country VARCHAR(2) NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN region VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN postal_code VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN tax_exempt BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE customers ADD COLUMN tax_exemption_number VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN tax_exemption_expires_at TIMESTAMP;
+
+-- The percentage charged on a tax category in a country, or in one region of it,
+-- between valid_from and valid_to (open ended when NULL)
+CREATE TABLE IF NOT EXISTS tax_rates (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    country VARCHAR(2) NOT NULL,
+    region VARCHAR(50) NOT NULL DEFAULT '',
+    tax_category VARCHAR(50) NOT NULL,
+    rate DECIMAL(19,4) NOT NULL,
+    valid_from TIMESTAMP NOT NULL,
+    valid_to TIMESTAMP,
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tax_rates_jurisdiction ON tax_rates (country, region, tax_category);
+
+-- How each order was taxed. total_amount includes tax_amount.
+ALTER TABLE orders ADD COLUMN tax_amount DECIMAL(19,4) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN prices_include_tax BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE orders ADD COLUMN tax_country VARCHAR(2) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN tax_region VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN tax_exempt BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE orders ADD COLUMN tax_exemption_number VARCHAR(100) NOT NULL DEFAULT '';
+
+-- Each line's tax. total_price includes tax_amount.
+ALTER TABLE order_items ADD COLUMN tax_category VARCHAR(50) NOT NULL DEFAULT 'standard';
+ALTER TABLE order_items ADD COLUMN tax_rate_id VARCHAR(36);
+ALTER TABLE order_items ADD COLUMN tax_rate DECIMAL(19,4) NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN tax_amount DECIMAL(19,4) NOT NULL DEFAULT 0;
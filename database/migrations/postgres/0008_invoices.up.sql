@@ -0,0 +1,78 @@
+-- Customers' agreed time to pay, in days from the invoice date
+ALTER TABLE customers ADD COLUMN payment_terms_days INTEGER NOT NULL DEFAULT 30;
+
+-- Units of each order line on invoices that are not void
+ALTER TABLE order_items ADD COLUMN invoiced_quantity INTEGER NOT NULL DEFAULT 0;
+
+-- The last number handed out for each type of invoice. Numbers are taken inside the
+-- transaction that issues the invoice, so a failed issue hands its number back.
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+    invoice_type VARCHAR(20) PRIMARY KEY,
+    last_number BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO invoice_sequences (invoice_type, last_number) VALUES ('invoice', 0), ('credit_note', 0);
+
+-- Invoices and credit notes. Drafts have no number; amounts are in the order currency.
+CREATE TABLE IF NOT EXISTS invoices (
+    id VARCHAR(36) PRIMARY KEY,
+    invoice_number VARCHAR(20) UNIQUE,
+    type VARCHAR(20) NOT NULL, -- invoice, credit_note
+    status VARCHAR(20) NOT NULL, -- draft, issued, partially_paid, paid, void
+    order_id VARCHAR(255) NOT NULL,
+    customer_id VARCHAR(255) NOT NULL,
+    credited_invoice_id VARCHAR(36),
+    currency VARCHAR(3) NOT NULL,
+    exchange_rate DECIMAL(18,8) NOT NULL,
+    payment_terms_days INTEGER NOT NULL DEFAULT 0,
+    invoice_date TIMESTAMP,
+    due_date TIMESTAMP,
+    subtotal_amount DECIMAL(19,4) NOT NULL,
+    tax_amount DECIMAL(19,4) NOT NULL,
+    total_amount DECIMAL(19,4) NOT NULL,
+    base_total_amount DECIMAL(19,4) NOT NULL,
+    amount_credited DECIMAL(19,4) NOT NULL DEFAULT 0,
+    amount_paid DECIMAL(19,4) NOT NULL DEFAULT 0,
+    prices_include_tax BOOLEAN NOT NULL DEFAULT false,
+    tax_country VARCHAR(2) NOT NULL DEFAULT '',
+    tax_region VARCHAR(50) NOT NULL DEFAULT '',
+    tax_exempt BOOLEAN NOT NULL DEFAULT false,
+    tax_exemption_number VARCHAR(100) NOT NULL DEFAULT '',
+    notes TEXT,
+    reason TEXT,
+    void_reason TEXT,
+    created_by VARCHAR(255),
+    issued_by VARCHAR(255),
+    issued_at TIMESTAMP,
+    voided_by VARCHAR(255),
+    voided_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE,
+    FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE,
+    FOREIGN KEY (credited_invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_customer ON invoices (customer_id, status);
+CREATE INDEX IF NOT EXISTS idx_invoices_order ON invoices (order_id);
+
+CREATE TABLE IF NOT EXISTS invoice_lines (
+    id VARCHAR(36) PRIMARY KEY,
+    invoice_id VARCHAR(36) NOT NULL,
+    order_item_id VARCHAR(255) NOT NULL,
+    credited_line_id VARCHAR(36),
+    product_id VARCHAR(255) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    quantity INTEGER NOT NULL,
+    credited_quantity INTEGER NOT NULL DEFAULT 0,
+    unit_price DECIMAL(19,4) NOT NULL,
+    discount_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    tax_category VARCHAR(50) NOT NULL DEFAULT 'standard',
+    tax_rate DECIMAL(19,4) NOT NULL DEFAULT 0,
+    tax_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    total_price DECIMAL(19,4) NOT NULL,
+    FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE,
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_lines_invoice ON invoice_lines (invoice_id);
@@ -0,0 +1,40 @@
+-- Money received from customers. Amounts are in the payment currency; whatever has not
+-- been allocated to invoices is credit the customer holds.
+CREATE TABLE IF NOT EXISTS payments (
+    id VARCHAR(36) PRIMARY KEY,
+    customer_id VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL, -- received, void
+    method VARCHAR(20) NOT NULL, -- cash, check, bank_transfer, card, other
+    reference VARCHAR(255) NOT NULL DEFAULT '',
+    currency VARCHAR(3) NOT NULL,
+    exchange_rate DECIMAL(18,8) NOT NULL,
+    amount DECIMAL(19,4) NOT NULL,
+    base_amount DECIMAL(19,4) NOT NULL,
+    amount_allocated DECIMAL(19,4) NOT NULL DEFAULT 0,
+    payment_date TIMESTAMP NOT NULL,
+    notes TEXT,
+    void_reason TEXT,
+    created_by VARCHAR(255),
+    voided_by VARCHAR(255),
+    voided_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_customer ON payments (customer_id, status);
+
+-- How much of a payment went to each invoice
+CREATE TABLE IF NOT EXISTS payment_allocations (
+    id VARCHAR(36) PRIMARY KEY,
+    payment_id VARCHAR(36) NOT NULL,
+    invoice_id VARCHAR(36) NOT NULL,
+    amount DECIMAL(19,4) NOT NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE CASCADE,
+    FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_payment ON payment_allocations (payment_id);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_invoice ON payment_allocations (invoice_id);
@@ -0,0 +1,114 @@
+-- Structured payment terms replace the free text: due after net days, less a discount
+-- for paying within discount days. Terms written the usual way ("Net 45", "2/10 net 30",
+-- "1.5%/15, net 45", "due on receipt") carry over. The rest keep the default of net 30;
+-- their text stays in legacy_payment_terms so they can be entered by hand.
+ALTER TABLE suppliers ADD COLUMN payment_terms_net_days INTEGER NOT NULL DEFAULT 30;
+ALTER TABLE suppliers ADD COLUMN payment_terms_discount_percent DECIMAL(19,4) NOT NULL DEFAULT 0;
+ALTER TABLE suppliers ADD COLUMN payment_terms_discount_days INTEGER NOT NULL DEFAULT 0;
+
+-- The text with spaces, %, commas and "days" taken out and "net" shortened to "n", so
+-- "2/10 net 30" reads "2/10n30" and "Net 45 days" reads "n45"
+UPDATE suppliers SET payment_terms_net_days = 0
+WHERE REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(LOWER(TRIM(payment_terms)), ' ', ''), '%', ''), ',', ''), 'days', ''), 'day', ''), 'net', 'n') IN ('dueonreceipt', 'cod');
+
+UPDATE suppliers
+SET payment_terms_net_days = CAST(terms.m[3] AS INTEGER),
+    payment_terms_discount_percent = COALESCE(CAST(terms.m[1] AS DECIMAL(19,4)), 0),
+    payment_terms_discount_days = COALESCE(CAST(terms.m[2] AS INTEGER), 0)
+FROM (
+    SELECT id, regexp_match(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(LOWER(TRIM(payment_terms)), ' ', ''), '%', ''), ',', ''), 'days', ''), 'day', ''), 'net', 'n'), '^(?:([0-9]+(?:[.][0-9]+)?)/([0-9]{1,3}))?n([0-9]{1,3})$') AS m
+    FROM suppliers
+) AS terms
+WHERE terms.id = suppliers.id
+  AND terms.m IS NOT NULL
+  AND CAST(terms.m[3] AS INTEGER) <= 365
+  AND (terms.m[1] IS NULL
+       OR (CAST(terms.m[1] AS NUMERIC) < 100
+           AND CAST(terms.m[2] AS INTEGER) BETWEEN 1 AND CAST(terms.m[3] AS INTEGER)));
+
+ALTER TABLE suppliers RENAME COLUMN payment_terms TO legacy_payment_terms;
+
+-- Units of each purchase order line on supplier bills that are not void
+ALTER TABLE purchase_order_lines ADD COLUMN billed_quantity INTEGER NOT NULL DEFAULT 0;
+
+-- Bills suppliers send for purchase orders. Amounts are in the purchase order currency.
+CREATE TABLE IF NOT EXISTS supplier_bills (
+    id VARCHAR(36) PRIMARY KEY,
+    bill_number VARCHAR(100) NOT NULL, -- The supplier's own invoice number
+    supplier_id VARCHAR(255) NOT NULL,
+    purchase_order_id VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL, -- draft, approved, partially_paid, paid, void
+    match_status VARCHAR(20) NOT NULL, -- matched, variance
+    variances_accepted BOOLEAN NOT NULL DEFAULT false,
+    currency VARCHAR(3) NOT NULL,
+    exchange_rate DECIMAL(18,8) NOT NULL,
+    payment_terms_net_days INTEGER NOT NULL,
+    payment_terms_discount_percent DECIMAL(19,4) NOT NULL DEFAULT 0,
+    payment_terms_discount_days INTEGER NOT NULL DEFAULT 0,
+    bill_date TIMESTAMP NOT NULL,
+    due_date TIMESTAMP NOT NULL,
+    discount_date TIMESTAMP,
+    subtotal_amount DECIMAL(19,4) NOT NULL,
+    tax_amount DECIMAL(19,4) NOT NULL DEFAULT 0,
+    total_amount DECIMAL(19,4) NOT NULL,
+    base_total_amount DECIMAL(19,4) NOT NULL,
+    amount_paid DECIMAL(19,4) NOT NULL DEFAULT 0,
+    discount_taken DECIMAL(19,4) NOT NULL DEFAULT 0,
+    notes TEXT,
+    void_reason TEXT,
+    created_by VARCHAR(255),
+    approved_by VARCHAR(255),
+    approved_at TIMESTAMP,
+    voided_by VARCHAR(255),
+    voided_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (supplier_id, bill_number),
+    FOREIGN KEY (supplier_id) REFERENCES suppliers(id) ON DELETE CASCADE,
+    FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_supplier_bills_supplier ON supplier_bills (supplier_id, status);
+CREATE INDEX IF NOT EXISTS idx_supplier_bills_purchase_order ON supplier_bills (purchase_order_id);
+
+-- Bill lines with what they were matched against: the purchase order line, what had
+-- been received and billed before, and the agreed cost
+CREATE TABLE IF NOT EXISTS supplier_bill_lines (
+    id VARCHAR(36) PRIMARY KEY,
+    bill_id VARCHAR(36) NOT NULL,
+    purchase_order_line_id VARCHAR(36) NOT NULL,
+    product_id VARCHAR(255) NOT NULL,
+    quantity INTEGER NOT NULL,
+    unit_cost DECIMAL(19,4) NOT NULL,
+    total_cost DECIMAL(19,4) NOT NULL,
+    ordered_quantity INTEGER NOT NULL,
+    received_quantity INTEGER NOT NULL,
+    previously_billed_quantity INTEGER NOT NULL,
+    ordered_unit_cost DECIMAL(19,4) NOT NULL,
+    agreed_unit_cost DECIMAL(19,4) NOT NULL,
+    quantity_variance INTEGER NOT NULL DEFAULT 0,
+    price_variance DECIMAL(19,4) NOT NULL DEFAULT 0,
+    FOREIGN KEY (bill_id) REFERENCES supplier_bills(id) ON DELETE CASCADE,
+    FOREIGN KEY (purchase_order_line_id) REFERENCES purchase_order_lines(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_supplier_bill_lines_bill ON supplier_bill_lines (bill_id);
+
+-- Payments made to suppliers against their bills
+CREATE TABLE IF NOT EXISTS supplier_payments (
+    id VARCHAR(36) PRIMARY KEY,
+    bill_id VARCHAR(36) NOT NULL,
+    supplier_id VARCHAR(255) NOT NULL,
+    method VARCHAR(20) NOT NULL, -- cash, check, bank_transfer, card, other
+    reference VARCHAR(255) NOT NULL DEFAULT '',
+    currency VARCHAR(3) NOT NULL,
+    amount DECIMAL(19,4) NOT NULL,
+    discount_taken DECIMAL(19,4) NOT NULL DEFAULT 0,
+    payment_date TIMESTAMP NOT NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (bill_id) REFERENCES supplier_bills(id) ON DELETE CASCADE,
+    FOREIGN KEY (supplier_id) REFERENCES suppliers(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_supplier_payments_bill ON supplier_payments (bill_id);
@@ -0,0 +1,337 @@
+-- Schema as created by the original createTables. IF NOT EXISTS lets this baseline
+-- an existing database that predates schema_migrations.
+
+CREATE TABLE IF NOT EXISTS products (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    sku TEXT UNIQUE NOT NULL,
+    price REAL NOT NULL,
+    quantity INTEGER NOT NULL,
+    category TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS customers (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    phone TEXT,
+    address TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL,
+    total_amount REAL NOT NULL,
+    status TEXT DEFAULT 'pending',
+    order_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (customer_id) REFERENCES customers(id)
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    cancelled_quantity INTEGER DEFAULT 0,
+    unit_price REAL NOT NULL,
+    total_price REAL NOT NULL,
+    status TEXT DEFAULT 'active',
+    FOREIGN KEY (order_id) REFERENCES orders(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE TABLE IF NOT EXISTS suppliers (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    code TEXT UNIQUE NOT NULL,
+    contact_person TEXT,
+    email TEXT,
+    phone TEXT,
+    address TEXT,
+    tax_id TEXT,
+    payment_terms TEXT,
+    status TEXT DEFAULT 'active',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS product_suppliers (
+    id TEXT PRIMARY KEY,
+    product_id TEXT NOT NULL,
+    supplier_id TEXT NOT NULL,
+    supplier_sku TEXT,
+    cost_price REAL,
+    lead_time_days INTEGER,
+    is_primary INTEGER DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id),
+    FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+    UNIQUE(product_id, supplier_id)
+);
+
+CREATE TABLE IF NOT EXISTS warehouses (
+    id TEXT PRIMARY KEY,
+    code TEXT UNIQUE NOT NULL,
+    name TEXT NOT NULL,
+    location TEXT,
+    manager_name TEXT,
+    phone TEXT,
+    email TEXT,
+    capacity INTEGER,
+    latitude REAL,
+    longitude REAL,
+    status TEXT DEFAULT 'active', --active, inactive
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS warehouse_locations (
+    id TEXT PRIMARY KEY,
+    warehouse_id TEXT NOT NULL,
+    location_code TEXT NOT NULL,
+    location_name TEXT,
+    zone TEXT,
+    row_number INTEGER,
+    shelf_number INTEGER,
+    max_capacity INTEGER,
+    current_quantity INTEGER DEFAULT 0,
+    status TEXT DEFAULT 'available', -- available, full, inactive, ocupied, reserved, maintanance
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses(id),
+    UNIQUE(warehouse_id, location_code)
+);
+
+CREATE TABLE IF NOT EXISTS inventory (
+    id TEXT PRIMARY KEY,
+    product_id TEXT NOT NULL,
+    warehouse_id TEXT NOT NULL,
+    location_id TEXT,
+    quantity INTEGER NOT NULL DEFAULT 0,
+    reserved_quantity INTEGER DEFAULT 0,
+    min_quantity INTEGER DEFAULT 0,
+    max_quantity INTEGER,
+    last_restocked TIMESTAMP,
+    last_checked TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id),
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses(id),
+    FOREIGN KEY (location_id) REFERENCES warehouse_locations(id),
+    UNIQUE(product_id, warehouse_id, location_id)
+);
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    reason TEXT,
+    changed_by TEXT,
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+CREATE TABLE IF NOT EXISTS order_cancellations (
+    id TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL,
+    order_item_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    amount REAL NOT NULL,
+    reason TEXT NOT NULL,
+    cancelled_by TEXT,
+    cancelled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id),
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id)
+);
+
+CREATE TABLE IF NOT EXISTS order_allocations (
+    id TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL,
+    order_item_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    warehouse_id TEXT NOT NULL,
+    inventory_id TEXT NOT NULL,
+    location_id TEXT,
+    quantity INTEGER NOT NULL,
+    status TEXT DEFAULT 'reserved', -- reserved, shipped, released
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id),
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id),
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses(id),
+    FOREIGN KEY (inventory_id) REFERENCES inventory(id)
+);
+
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id TEXT PRIMARY KEY,
+    movement_type TEXT NOT NULL, -- receipt, issue, adjustment, transfer, return, count_correction
+    product_id TEXT NOT NULL,
+    warehouse_id TEXT, -- NULL for movements of products.quantity
+    location_id TEXT,
+    inventory_id TEXT,
+    quantity INTEGER NOT NULL, -- signed change
+    quantity_before INTEGER NOT NULL,
+    quantity_after INTEGER NOT NULL,
+    reference_type TEXT,
+    reference_id TEXT,
+    reason TEXT,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS transfer_orders (
+    id TEXT PRIMARY KEY,
+    source_warehouse_id TEXT NOT NULL,
+    destination_warehouse_id TEXT NOT NULL,
+    status TEXT DEFAULT 'draft', -- draft, in_transit, received, cancelled
+    notes TEXT,
+    created_by TEXT,
+    dispatched_at TIMESTAMP,
+    received_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (source_warehouse_id) REFERENCES warehouses(id),
+    FOREIGN KEY (destination_warehouse_id) REFERENCES warehouses(id)
+);
+
+CREATE TABLE IF NOT EXISTS transfer_order_lines (
+    id TEXT PRIMARY KEY,
+    transfer_order_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    source_location_id TEXT,
+    destination_location_id TEXT,
+    source_inventory_id TEXT,
+    quantity INTEGER NOT NULL,
+    received_quantity INTEGER DEFAULT 0,
+    discrepancy_quantity INTEGER DEFAULT 0,
+    FOREIGN KEY (transfer_order_id) REFERENCES transfer_orders(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE TABLE IF NOT EXISTS transfer_receipts (
+    id TEXT PRIMARY KEY,
+    transfer_order_id TEXT NOT NULL,
+    transfer_order_line_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    location_id TEXT,
+    destination_inventory_id TEXT,
+    received_quantity INTEGER NOT NULL DEFAULT 0,
+    discrepancy_quantity INTEGER NOT NULL DEFAULT 0,
+    discrepancy_reason TEXT,
+    received_by TEXT,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (transfer_order_id) REFERENCES transfer_orders(id),
+    FOREIGN KEY (transfer_order_line_id) REFERENCES transfer_order_lines(id)
+);
+
+CREATE TABLE IF NOT EXISTS purchase_orders (
+    id TEXT PRIMARY KEY,
+    po_number TEXT UNIQUE NOT NULL,
+    supplier_id TEXT NOT NULL,
+    warehouse_id TEXT,
+    status TEXT DEFAULT 'draft', -- draft, approved, sent, partially_received, received, closed, cancelled
+    order_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expected_date TIMESTAMP,
+    total_amount REAL NOT NULL DEFAULT 0,
+    notes TEXT,
+    created_by TEXT,
+    approved_by TEXT,
+    approved_at TIMESTAMP,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+    FOREIGN KEY (warehouse_id) REFERENCES warehouses(id)
+);
+
+CREATE TABLE IF NOT EXISTS purchase_order_lines (
+    id TEXT PRIMARY KEY,
+    purchase_order_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    supplier_sku TEXT,
+    quantity INTEGER NOT NULL,
+    received_quantity INTEGER DEFAULT 0,
+    unit_cost REAL NOT NULL,
+    total_cost REAL NOT NULL,
+    lead_time_days INTEGER DEFAULT 0,
+    expected_date TIMESTAMP,
+    FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE TABLE IF NOT EXISTS purchase_order_receipts (
+    id TEXT PRIMARY KEY,
+    purchase_order_id TEXT NOT NULL,
+    purchase_order_line_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    warehouse_id TEXT NOT NULL,
+    location_id TEXT,
+    inventory_id TEXT,
+    quantity INTEGER NOT NULL,
+    notes TEXT,
+    received_by TEXT,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders(id),
+    FOREIGN KEY (purchase_order_line_id) REFERENCES purchase_order_lines(id)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+    username TEXT UNIQUE NOT NULL,
+    email TEXT UNIQUE,
+    password_hash TEXT NOT NULL,
+    full_name TEXT,
+    status TEXT DEFAULT 'active', -- active, inactive
+    last_login_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT UNIQUE NOT NULL,
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id TEXT NOT NULL,
+    role TEXT NOT NULL, -- admin, sales, warehouse, purchasing, viewer
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id TEXT PRIMARY KEY,
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    action TEXT NOT NULL, -- create, update, delete
+    actor_id TEXT,
+    actor_name TEXT,
+    changes TEXT NOT NULL, -- JSON object of field: {before, after}
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,44 @@
+ALTER TABLE products ADD COLUMN price_real REAL NOT NULL DEFAULT 0;
+UPDATE products SET price_real = price / 10000.0;
+ALTER TABLE products DROP COLUMN price;
+ALTER TABLE products RENAME COLUMN price_real TO price;
+
+ALTER TABLE orders ADD COLUMN total_amount_real REAL NOT NULL DEFAULT 0;
+UPDATE orders SET total_amount_real = total_amount / 10000.0;
+ALTER TABLE orders DROP COLUMN total_amount;
+ALTER TABLE orders RENAME COLUMN total_amount_real TO total_amount;
+
+ALTER TABLE order_items ADD COLUMN unit_price_real REAL NOT NULL DEFAULT 0;
+UPDATE order_items SET unit_price_real = unit_price / 10000.0;
+ALTER TABLE order_items DROP COLUMN unit_price;
+ALTER TABLE order_items RENAME COLUMN unit_price_real TO unit_price;
+
+ALTER TABLE order_items ADD COLUMN total_price_real REAL NOT NULL DEFAULT 0;
+UPDATE order_items SET total_price_real = total_price / 10000.0;
+ALTER TABLE order_items DROP COLUMN total_price;
+ALTER TABLE order_items RENAME COLUMN total_price_real TO total_price;
+
+ALTER TABLE product_suppliers ADD COLUMN cost_price_real REAL;
+UPDATE product_suppliers SET cost_price_real = cost_price / 10000.0;
+ALTER TABLE product_suppliers DROP COLUMN cost_price;
+ALTER TABLE product_suppliers RENAME COLUMN cost_price_real TO cost_price;
+
+ALTER TABLE order_cancellations ADD COLUMN amount_real REAL NOT NULL DEFAULT 0;
+UPDATE order_cancellations SET amount_real = amount / 10000.0;
+ALTER TABLE order_cancellations DROP COLUMN amount;
+ALTER TABLE order_cancellations RENAME COLUMN amount_real TO amount;
+
+ALTER TABLE purchase_orders ADD COLUMN total_amount_real REAL NOT NULL DEFAULT 0;
+UPDATE purchase_orders SET total_amount_real = total_amount / 10000.0;
+ALTER TABLE purchase_orders DROP COLUMN total_amount;
+ALTER TABLE purchase_orders RENAME COLUMN total_amount_real TO total_amount;
+
+ALTER TABLE purchase_order_lines ADD COLUMN unit_cost_real REAL NOT NULL DEFAULT 0;
+UPDATE purchase_order_lines SET unit_cost_real = unit_cost / 10000.0;
+ALTER TABLE purchase_order_lines DROP COLUMN unit_cost;
+ALTER TABLE purchase_order_lines RENAME COLUMN unit_cost_real TO unit_cost;
+
+ALTER TABLE purchase_order_lines ADD COLUMN total_cost_real REAL NOT NULL DEFAULT 0;
+UPDATE purchase_order_lines SET total_cost_real = total_cost / 10000.0;
+ALTER TABLE purchase_order_lines DROP COLUMN total_cost;
+ALTER TABLE purchase_order_lines RENAME COLUMN total_cost_real TO total_cost;
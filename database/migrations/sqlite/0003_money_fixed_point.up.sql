@@ -0,0 +1,48 @@
+-- Money columns become INTEGER counts of ten-thousandths of a currency unit instead of
+-- REAL, so amounts are exact. SQLite cannot change a column type in place, so each
+-- column is rebuilt: add, copy, drop, rename.
+
+ALTER TABLE products ADD COLUMN price_units INTEGER NOT NULL DEFAULT 0;
+UPDATE products SET price_units = CAST(ROUND(price * 10000) AS INTEGER);
+ALTER TABLE products DROP COLUMN price;
+ALTER TABLE products RENAME COLUMN price_units TO price;
+
+ALTER TABLE orders ADD COLUMN total_amount_units INTEGER NOT NULL DEFAULT 0;
+UPDATE orders SET total_amount_units = CAST(ROUND(total_amount * 10000) AS INTEGER);
+ALTER TABLE orders DROP COLUMN total_amount;
+ALTER TABLE orders RENAME COLUMN total_amount_units TO total_amount;
+
+ALTER TABLE order_items ADD COLUMN unit_price_units INTEGER NOT NULL DEFAULT 0;
+UPDATE order_items SET unit_price_units = CAST(ROUND(unit_price * 10000) AS INTEGER);
+ALTER TABLE order_items DROP COLUMN unit_price;
+ALTER TABLE order_items RENAME COLUMN unit_price_units TO unit_price;
+
+ALTER TABLE order_items ADD COLUMN total_price_units INTEGER NOT NULL DEFAULT 0;
+UPDATE order_items SET total_price_units = CAST(ROUND(total_price * 10000) AS INTEGER);
+ALTER TABLE order_items DROP COLUMN total_price;
+ALTER TABLE order_items RENAME COLUMN total_price_units TO total_price;
+
+ALTER TABLE product_suppliers ADD COLUMN cost_price_units INTEGER;
+UPDATE product_suppliers SET cost_price_units = CAST(ROUND(cost_price * 10000) AS INTEGER);
+ALTER TABLE product_suppliers DROP COLUMN cost_price;
+ALTER TABLE product_suppliers RENAME COLUMN cost_price_units TO cost_price;
+
+ALTER TABLE order_cancellations ADD COLUMN amount_units INTEGER NOT NULL DEFAULT 0;
+UPDATE order_cancellations SET amount_units = CAST(ROUND(amount * 10000) AS INTEGER);
+ALTER TABLE order_cancellations DROP COLUMN amount;
+ALTER TABLE order_cancellations RENAME COLUMN amount_units TO amount;
+
+ALTER TABLE purchase_orders ADD COLUMN total_amount_units INTEGER NOT NULL DEFAULT 0;
+UPDATE purchase_orders SET total_amount_units = CAST(ROUND(total_amount * 10000) AS INTEGER);
+ALTER TABLE purchase_orders DROP COLUMN total_amount;
+ALTER TABLE purchase_orders RENAME COLUMN total_amount_units TO total_amount;
+
+ALTER TABLE purchase_order_lines ADD COLUMN unit_cost_units INTEGER NOT NULL DEFAULT 0;
+UPDATE purchase_order_lines SET unit_cost_units = CAST(ROUND(unit_cost * 10000) AS INTEGER);
+ALTER TABLE purchase_order_lines DROP COLUMN unit_cost;
+ALTER TABLE purchase_order_lines RENAME COLUMN unit_cost_units TO unit_cost;
+
+ALTER TABLE purchase_order_lines ADD COLUMN total_cost_units INTEGER NOT NULL DEFAULT 0;
+UPDATE purchase_order_lines SET total_cost_units = CAST(ROUND(total_cost * 10000) AS INTEGER);
+ALTER TABLE purchase_order_lines DROP COLUMN total_cost;
+ALTER TABLE purchase_order_lines RENAME COLUMN total_cost_units TO total_cost;
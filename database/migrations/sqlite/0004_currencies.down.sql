@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS exchange_rates;
+
+ALTER TABLE orders DROP COLUMN base_total_amount;
+ALTER TABLE orders DROP COLUMN exchange_rate;
+ALTER TABLE orders DROP COLUMN currency;
+ALTER TABLE purchase_orders DROP COLUMN currency;
+ALTER TABLE product_suppliers DROP COLUMN currency;
+ALTER TABLE products DROP COLUMN currency;
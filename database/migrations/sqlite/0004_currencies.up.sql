@@ -0,0 +1,25 @@
+-- Amounts carry the currency they are in. Everything recorded before now is in USD,
+-- the only currency there was.
+ALTER TABLE products ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE product_suppliers ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE purchase_orders ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+-- Orders keep the rate to the base currency they were placed at, and their total in
+-- it. Rates are INTEGER counts of hundred-millionths, money of ten-thousandths.
+ALTER TABLE orders ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+ALTER TABLE orders ADD COLUMN exchange_rate INTEGER NOT NULL DEFAULT 100000000;
+ALTER TABLE orders ADD COLUMN base_total_amount INTEGER NOT NULL DEFAULT 0;
+UPDATE orders SET base_total_amount = total_amount;
+
+-- What one unit of currency is worth in base_currency from effective_date on
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id TEXT PRIMARY KEY,
+    base_currency TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    rate INTEGER NOT NULL,
+    effective_date TIMESTAMP NOT NULL,
+    source TEXT NOT NULL, -- api, csv
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(base_currency, currency, effective_date)
+);
@@ -0,0 +1,8 @@
+ALTER TABLE order_items DROP COLUMN price_break_quantity;
+ALTER TABLE order_items DROP COLUMN price_list_id;
+ALTER TABLE order_items DROP COLUMN list_price;
+
+DROP TABLE IF EXISTS price_list_items;
+DROP TABLE IF EXISTS price_lists;
+
+ALTER TABLE customers DROP COLUMN customer_group;
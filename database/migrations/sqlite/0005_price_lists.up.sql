@@ -0,0 +1,41 @@
+-- Customers can belong to a group that shares price lists
+ALTER TABLE customers ADD COLUMN customer_group TEXT NOT NULL DEFAULT '';
+
+-- A price list sets prices for one customer or for every customer in a group, between
+-- valid_from and valid_to (open ended when NULL)
+CREATE TABLE IF NOT EXISTS price_lists (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    customer_id TEXT,
+    customer_group TEXT NOT NULL DEFAULT '',
+    currency TEXT NOT NULL,
+    valid_from TIMESTAMP NOT NULL,
+    valid_to TIMESTAMP,
+    active INTEGER NOT NULL DEFAULT 1,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (customer_id) REFERENCES customers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_price_lists_customer ON price_lists (customer_id);
+CREATE INDEX IF NOT EXISTS idx_price_lists_group ON price_lists (customer_group);
+
+-- Each product may have several tiers; a tier applies to lines of at least min_quantity
+CREATE TABLE IF NOT EXISTS price_list_items (
+    id TEXT PRIMARY KEY,
+    price_list_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    min_quantity INTEGER NOT NULL DEFAULT 1,
+    unit_price INTEGER NOT NULL,
+    FOREIGN KEY (price_list_id) REFERENCES price_lists(id),
+    FOREIGN KEY (product_id) REFERENCES products(id),
+    UNIQUE(price_list_id, product_id, min_quantity)
+);
+
+-- Order lines record how their price was arrived at: the catalogue price in the order
+-- currency, and the price list and tier that replaced it, if any
+ALTER TABLE order_items ADD COLUMN list_price INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN price_list_id TEXT;
+ALTER TABLE order_items ADD COLUMN price_break_quantity INTEGER NOT NULL DEFAULT 0;
+UPDATE order_items SET list_price = unit_price;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS order_item_discounts;
+ALTER TABLE order_items DROP COLUMN discount_amount;
+ALTER TABLE orders DROP COLUMN coupon_id;
+
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupons;
+DROP TABLE IF EXISTS promotions;
@@ -0,0 +1,72 @@
+-- Discount rules. Eligibility columns left empty match every product or customer.
+CREATE TABLE IF NOT EXISTS promotions (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    type TEXT NOT NULL, -- percentage, fixed, buy_x_get_y
+    scope TEXT NOT NULL, -- line, order
+    value INTEGER NOT NULL DEFAULT 0,
+    currency TEXT NOT NULL,
+    buy_quantity INTEGER NOT NULL DEFAULT 0,
+    get_quantity INTEGER NOT NULL DEFAULT 0,
+    min_order_amount INTEGER NOT NULL DEFAULT 0,
+    product_id TEXT,
+    category TEXT NOT NULL DEFAULT '',
+    customer_id TEXT,
+    customer_group TEXT NOT NULL DEFAULT '',
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP,
+    requires_coupon INTEGER NOT NULL DEFAULT 0,
+    active INTEGER NOT NULL DEFAULT 1,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id),
+    FOREIGN KEY (customer_id) REFERENCES customers(id)
+);
+
+-- Codes that unlock a promotion. A usage limit of 0 is unlimited.
+CREATE TABLE IF NOT EXISTS coupons (
+    id TEXT PRIMARY KEY,
+    code TEXT UNIQUE NOT NULL,
+    promotion_id TEXT NOT NULL,
+    usage_limit INTEGER NOT NULL DEFAULT 0,
+    per_customer_limit INTEGER NOT NULL DEFAULT 0,
+    times_used INTEGER NOT NULL DEFAULT 0,
+    active INTEGER NOT NULL DEFAULT 1,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (promotion_id) REFERENCES promotions(id)
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id TEXT PRIMARY KEY,
+    coupon_id TEXT NOT NULL,
+    order_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    redeemed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (coupon_id) REFERENCES coupons(id),
+    FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_customer ON coupon_redemptions (coupon_id, customer_id);
+
+ALTER TABLE orders ADD COLUMN coupon_id TEXT;
+
+-- Each promotion's discount on each line. total_price is net of discount_amount.
+ALTER TABLE order_items ADD COLUMN discount_amount INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS order_item_discounts (
+    id TEXT PRIMARY KEY,
+    order_id TEXT NOT NULL,
+    order_item_id TEXT NOT NULL,
+    promotion_id TEXT NOT NULL,
+    coupon_id TEXT,
+    description TEXT NOT NULL,
+    scope TEXT NOT NULL, -- line, order
+    amount INTEGER NOT NULL,
+    FOREIGN KEY (order_id) REFERENCES orders(id),
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_item_discounts_order ON order_item_discounts (order_id);
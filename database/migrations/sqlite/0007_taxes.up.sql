@@ -0,0 +1,42 @@
+-- Products are taxed by category; customers by where they are, unless exempt
+ALTER TABLE products ADD COLUMN tax_category TEXT NOT NULL DEFAULT 'standard';
+
+ALTER TABLE customers ADD COLUMN country TEXT NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN region TEXT NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN postal_code TEXT NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN tax_exempt INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE customers ADD COLUMN tax_exemption_number TEXT NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN tax_exemption_expires_at TIMESTAMP;
+
+-- The percentage charged on a tax category in a country, or in one region of it,
+-- between valid_from and valid_to (open ended when NULL)
+CREATE TABLE IF NOT EXISTS tax_rates (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    country TEXT NOT NULL,
+    region TEXT NOT NULL DEFAULT '',
+    tax_category TEXT NOT NULL,
+    rate INTEGER NOT NULL,
+    valid_from TIMESTAMP NOT NULL,
+    valid_to TIMESTAMP,
+    active INTEGER NOT NULL DEFAULT 1,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tax_rates_jurisdiction ON tax_rates (country, region, tax_category);
+
+-- How each order was taxed. total_amount includes tax_amount.
+ALTER TABLE orders ADD COLUMN tax_amount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN prices_include_tax INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN tax_country TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN tax_region TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN tax_exempt INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN tax_exemption_number TEXT NOT NULL DEFAULT '';
+
+-- Each line's tax. total_price includes tax_amount.
+ALTER TABLE order_items ADD COLUMN tax_category TEXT NOT NULL DEFAULT 'standard';
+ALTER TABLE order_items ADD COLUMN tax_rate_id TEXT;
+ALTER TABLE order_items ADD COLUMN tax_rate INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN tax_amount INTEGER NOT NULL DEFAULT 0;
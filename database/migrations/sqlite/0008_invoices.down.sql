@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS invoice_lines;
+DROP TABLE IF EXISTS invoices;
+DROP TABLE IF EXISTS invoice_sequences;
+
+ALTER TABLE order_items DROP COLUMN invoiced_quantity;
+
+ALTER TABLE customers DROP COLUMN payment_terms_days;
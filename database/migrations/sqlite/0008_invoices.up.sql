@@ -0,0 +1,78 @@
+-- Customers' agreed time to pay, in days from the invoice date
+ALTER TABLE customers ADD COLUMN payment_terms_days INTEGER NOT NULL DEFAULT 30;
+
+-- Units of each order line on invoices that are not void
+ALTER TABLE order_items ADD COLUMN invoiced_quantity INTEGER NOT NULL DEFAULT 0;
+
+-- The last number handed out for each type of invoice. Numbers are taken inside the
+-- transaction that issues the invoice, so a failed issue hands its number back.
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+    invoice_type TEXT PRIMARY KEY,
+    last_number INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO invoice_sequences (invoice_type, last_number) VALUES ('invoice', 0), ('credit_note', 0);
+
+-- Invoices and credit notes. Drafts have no number; amounts are in the order currency.
+CREATE TABLE IF NOT EXISTS invoices (
+    id TEXT PRIMARY KEY,
+    invoice_number TEXT UNIQUE,
+    type TEXT NOT NULL, -- invoice, credit_note
+    status TEXT NOT NULL, -- draft, issued, partially_paid, paid, void
+    order_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    credited_invoice_id TEXT,
+    currency TEXT NOT NULL,
+    exchange_rate INTEGER NOT NULL,
+    payment_terms_days INTEGER NOT NULL DEFAULT 0,
+    invoice_date TIMESTAMP,
+    due_date TIMESTAMP,
+    subtotal_amount INTEGER NOT NULL,
+    tax_amount INTEGER NOT NULL,
+    total_amount INTEGER NOT NULL,
+    base_total_amount INTEGER NOT NULL,
+    amount_credited INTEGER NOT NULL DEFAULT 0,
+    amount_paid INTEGER NOT NULL DEFAULT 0,
+    prices_include_tax INTEGER NOT NULL DEFAULT 0,
+    tax_country TEXT NOT NULL DEFAULT '',
+    tax_region TEXT NOT NULL DEFAULT '',
+    tax_exempt INTEGER NOT NULL DEFAULT 0,
+    tax_exemption_number TEXT NOT NULL DEFAULT '',
+    notes TEXT,
+    reason TEXT,
+    void_reason TEXT,
+    created_by TEXT,
+    issued_by TEXT,
+    issued_at TIMESTAMP,
+    voided_by TEXT,
+    voided_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id),
+    FOREIGN KEY (customer_id) REFERENCES customers(id),
+    FOREIGN KEY (credited_invoice_id) REFERENCES invoices(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_customer ON invoices (customer_id, status);
+CREATE INDEX IF NOT EXISTS idx_invoices_order ON invoices (order_id);
+
+CREATE TABLE IF NOT EXISTS invoice_lines (
+    id TEXT PRIMARY KEY,
+    invoice_id TEXT NOT NULL,
+    order_item_id TEXT NOT NULL,
+    credited_line_id TEXT,
+    product_id TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    quantity INTEGER NOT NULL,
+    credited_quantity INTEGER NOT NULL DEFAULT 0,
+    unit_price INTEGER NOT NULL,
+    discount_amount INTEGER NOT NULL DEFAULT 0,
+    tax_category TEXT NOT NULL DEFAULT 'standard',
+    tax_rate INTEGER NOT NULL DEFAULT 0,
+    tax_amount INTEGER NOT NULL DEFAULT 0,
+    total_price INTEGER NOT NULL,
+    FOREIGN KEY (invoice_id) REFERENCES invoices(id),
+    FOREIGN KEY (order_item_id) REFERENCES order_items(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_lines_invoice ON invoice_lines (invoice_id);
@@ -0,0 +1,40 @@
+-- Money received from customers. Amounts are in the payment currency; whatever has not
+-- been allocated to invoices is credit the customer holds.
+CREATE TABLE IF NOT EXISTS payments (
+    id TEXT PRIMARY KEY,
+    customer_id TEXT NOT NULL,
+    status TEXT NOT NULL, -- received, void
+    method TEXT NOT NULL, -- cash, check, bank_transfer, card, other
+    reference TEXT NOT NULL DEFAULT '',
+    currency TEXT NOT NULL,
+    exchange_rate INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    base_amount INTEGER NOT NULL,
+    amount_allocated INTEGER NOT NULL DEFAULT 0,
+    payment_date TIMESTAMP NOT NULL,
+    notes TEXT,
+    void_reason TEXT,
+    created_by TEXT,
+    voided_by TEXT,
+    voided_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (customer_id) REFERENCES customers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payments_customer ON payments (customer_id, status);
+
+-- How much of a payment went to each invoice
+CREATE TABLE IF NOT EXISTS payment_allocations (
+    id TEXT PRIMARY KEY,
+    payment_id TEXT NOT NULL,
+    invoice_id TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (payment_id) REFERENCES payments(id),
+    FOREIGN KEY (invoice_id) REFERENCES invoices(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_payment ON payment_allocations (payment_id);
+CREATE INDEX IF NOT EXISTS idx_payment_allocations_invoice ON payment_allocations (invoice_id);
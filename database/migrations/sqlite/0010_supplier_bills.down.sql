@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS supplier_payments;
+DROP TABLE IF EXISTS supplier_bill_lines;
+DROP TABLE IF EXISTS supplier_bills;
+
+ALTER TABLE purchase_order_lines DROP COLUMN billed_quantity;
+
+ALTER TABLE suppliers RENAME COLUMN legacy_payment_terms TO payment_terms;
+ALTER TABLE suppliers DROP COLUMN payment_terms_discount_days;
+ALTER TABLE suppliers DROP COLUMN payment_terms_discount_percent;
+ALTER TABLE suppliers DROP COLUMN payment_terms_net_days;
@@ -0,0 +1,125 @@
+-- Structured payment terms replace the free text: due after net days, less a discount
+-- for paying within discount days. Terms written the usual way ("Net 45", "2/10 net 30",
+-- "1.5%/15, net 45", "due on receipt") carry over. The rest keep the default of net 30;
+-- their text stays in legacy_payment_terms so they can be entered by hand.
+ALTER TABLE suppliers ADD COLUMN payment_terms_net_days INTEGER NOT NULL DEFAULT 30;
+ALTER TABLE suppliers ADD COLUMN payment_terms_discount_percent INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE suppliers ADD COLUMN payment_terms_discount_days INTEGER NOT NULL DEFAULT 0;
+
+-- The text with spaces, %, commas and "days" taken out and "net" shortened to "n", so
+-- "2/10 net 30" reads "2/10n30" and "Net 45 days" reads "n45"
+UPDATE suppliers SET payment_terms_net_days = 0
+WHERE REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(LOWER(TRIM(payment_terms)), ' ', ''), '%', ''), ',', ''), 'days', ''), 'day', ''), 'net', 'n') IN ('dueonreceipt', 'cod');
+
+UPDATE suppliers
+SET payment_terms_net_days = CAST(terms.net AS INTEGER),
+    payment_terms_discount_percent = CAST(ROUND(CAST(terms.percent AS REAL) * 10000) AS INTEGER),
+    payment_terms_discount_days = CAST(terms.days AS INTEGER)
+FROM (
+    SELECT id,
+           SUBSTR(text, 1, INSTR(text, '/') - 1) AS percent,
+           SUBSTR(text, INSTR(text, '/') + 1, INSTR(text, 'n') - INSTR(text, '/') - 1) AS days,
+           SUBSTR(text, INSTR(text, 'n') + 1) AS net,
+           INSTR(text, '/') AS slash,
+           INSTR(text, 'n') AS n
+    FROM (SELECT id, REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(REPLACE(LOWER(TRIM(payment_terms)), ' ', ''), '%', ''), ',', ''), 'days', ''), 'day', ''), 'net', 'n') AS text FROM suppliers WHERE payment_terms IS NOT NULL)
+) AS terms
+WHERE terms.id = suppliers.id
+  AND terms.n > 0
+  AND terms.net <> '' AND terms.net NOT GLOB '*[^0-9]*' AND LENGTH(terms.net) <= 3
+  AND CAST(terms.net AS INTEGER) <= 365
+  AND (
+      (terms.slash = 0 AND terms.n = 1)
+      OR (terms.slash > 1
+          AND terms.percent GLOB '[0-9]*' AND terms.percent NOT GLOB '*[^0-9.]*' AND terms.percent NOT GLOB '*.*.*'
+          AND CAST(terms.percent AS REAL) < 100
+          AND terms.days <> '' AND terms.days NOT GLOB '*[^0-9]*' AND LENGTH(terms.days) <= 3
+          AND CAST(terms.days AS INTEGER) BETWEEN 1 AND CAST(terms.net AS INTEGER))
+  );
+
+ALTER TABLE suppliers RENAME COLUMN payment_terms TO legacy_payment_terms;
+
+-- Units of each purchase order line on supplier bills that are not void
+ALTER TABLE purchase_order_lines ADD COLUMN billed_quantity INTEGER NOT NULL DEFAULT 0;
+
+-- Bills suppliers send for purchase orders. Amounts are in the purchase order currency.
+CREATE TABLE IF NOT EXISTS supplier_bills (
+    id TEXT PRIMARY KEY,
+    bill_number TEXT NOT NULL, -- The supplier's own invoice number
+    supplier_id TEXT NOT NULL,
+    purchase_order_id TEXT NOT NULL,
+    status TEXT NOT NULL, -- draft, approved, partially_paid, paid, void
+    match_status TEXT NOT NULL, -- matched, variance
+    variances_accepted INTEGER NOT NULL DEFAULT 0,
+    currency TEXT NOT NULL,
+    exchange_rate INTEGER NOT NULL,
+    payment_terms_net_days INTEGER NOT NULL,
+    payment_terms_discount_percent INTEGER NOT NULL DEFAULT 0,
+    payment_terms_discount_days INTEGER NOT NULL DEFAULT 0,
+    bill_date TIMESTAMP NOT NULL,
+    due_date TIMESTAMP NOT NULL,
+    discount_date TIMESTAMP,
+    subtotal_amount INTEGER NOT NULL,
+    tax_amount INTEGER NOT NULL DEFAULT 0,
+    total_amount INTEGER NOT NULL,
+    base_total_amount INTEGER NOT NULL,
+    amount_paid INTEGER NOT NULL DEFAULT 0,
+    discount_taken INTEGER NOT NULL DEFAULT 0,
+    notes TEXT,
+    void_reason TEXT,
+    created_by TEXT,
+    approved_by TEXT,
+    approved_at TIMESTAMP,
+    voided_by TEXT,
+    voided_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (supplier_id, bill_number),
+    FOREIGN KEY (supplier_id) REFERENCES suppliers(id),
+    FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_supplier_bills_supplier ON supplier_bills (supplier_id, status);
+CREATE INDEX IF NOT EXISTS idx_supplier_bills_purchase_order ON supplier_bills (purchase_order_id);
+
+-- Bill lines with what they were matched against: the purchase order line, what had
+-- been received and billed before, and the agreed cost
+CREATE TABLE IF NOT EXISTS supplier_bill_lines (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL,
+    purchase_order_line_id TEXT NOT NULL,
+    product_id TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    unit_cost INTEGER NOT NULL,
+    total_cost INTEGER NOT NULL,
+    ordered_quantity INTEGER NOT NULL,
+    received_quantity INTEGER NOT NULL,
+    previously_billed_quantity INTEGER NOT NULL,
+    ordered_unit_cost INTEGER NOT NULL,
+    agreed_unit_cost INTEGER NOT NULL,
+    quantity_variance INTEGER NOT NULL DEFAULT 0,
+    price_variance INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (bill_id) REFERENCES supplier_bills(id),
+    FOREIGN KEY (purchase_order_line_id) REFERENCES purchase_order_lines(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_supplier_bill_lines_bill ON supplier_bill_lines (bill_id);
+
+-- Payments made to suppliers against their bills
+CREATE TABLE IF NOT EXISTS supplier_payments (
+    id TEXT PRIMARY KEY,
+    bill_id TEXT NOT NULL,
+    supplier_id TEXT NOT NULL,
+    method TEXT NOT NULL, -- cash, check, bank_transfer, card, other
+    reference TEXT NOT NULL DEFAULT '',
+    currency TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    discount_taken INTEGER NOT NULL DEFAULT 0,
+    payment_date TIMESTAMP NOT NULL,
+    created_by TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (bill_id) REFERENCES supplier_bills(id),
+    FOREIGN KEY (supplier_id) REFERENCES suppliers(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_supplier_payments_bill ON supplier_payments (bill_id);
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"erp-project/middleware"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	repo repositories.AuditStore
+}
+
+func NewAuditHandler(repo repositories.AuditStore) *AuditHandler {
+	return &AuditHandler{repo: repo}
+}
+
+// GetAuditLogs returns the change history, newest first. entity and id narrow it down
+// to one record, e.g. /api/audit?entity=product&id=...
+func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
+	page, pageSize := utils.GetPaginationParams(c)
+
+	filter := repositories.AuditLogFilter{
+		EntityType: c.Query("entity"),
+		EntityID:   c.Query("id"),
+		Action:     c.Query("action"),
+		ActorID:    c.Query("actor_id"),
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseTimeParam(to, true)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.To = &t
+	}
+
+	entries, total, err := h.repo.GetAuditLogs(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		log.Printf("GetAuditLogs error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve audit log", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"entries": entries,
+		"pagination": utils.Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+			Pages:    utils.CalculateTotalPages(total, pageSize),
+		},
+	}
+
+	utils.SuccessResponse(c, "Audit log retrieved successfully", responseData)
+}
+
+// auditContext returns the request context, asking the repository that saves a change
+// to record it in the audit log on behalf of the current user. The entry is written in
+// the same transaction as the change, so a failure to write it fails the request.
+func auditContext(c *gin.Context, entityType, entityID, action string, before, after interface{}) context.Context {
+	return repositories.WithAudit(c.Request.Context(), middleware.CurrentUser(c), entityType, entityID, action, before, after)
+}
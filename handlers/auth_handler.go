@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"erp-project/middleware"
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthHandler struct {
+	userRepo repositories.UserStore
+	tokens   *utils.TokenManager
+}
+
+func NewAuthHandler(userRepo repositories.UserStore, tokens *utils.TokenManager) *AuthHandler {
+	return &AuthHandler{userRepo: userRepo, tokens: tokens}
+}
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	user, err := h.userRepo.GetUserByUsername(c.Request.Context(), req.Username)
+	if err != nil {
+		log.Printf("Login - GetUserByUsername error: %v", err)
+		storeErrorResponse(c, err, "Failed to log in", "Database error")
+		return
+	}
+	// The same answer, after the same work, for unknown users and wrong passwords, so
+	// usernames cannot be probed
+	if user == nil {
+		models.CheckMissingUserPassword(req.Password)
+		utils.UnauthorizedResponse(c, "Invalid username or password")
+		return
+	}
+	if !user.CheckPassword(req.Password) {
+		utils.UnauthorizedResponse(c, "Invalid username or password")
+		return
+	}
+	if !user.IsActive() {
+		utils.UnauthorizedResponse(c, "User account is disabled")
+		return
+	}
+
+	pair, err := h.tokens.IssueTokenPair(user.ID, user.Username)
+	if err != nil {
+		log.Printf("Login - IssueTokenPair error: %v", err)
+		utils.InternalErrorResponse(c, "Failed to log in", "Token error")
+		return
+	}
+	if err := h.userRepo.SaveRefreshToken(c.Request.Context(), refreshTokenRecord(user.ID, pair)); err != nil {
+		storeErrorResponse(c, err, "Failed to log in", "Database error")
+		return
+	}
+	if err := h.userRepo.RecordLogin(c.Request.Context(), user.ID); err != nil {
+		log.Printf("Login - RecordLogin error: %v", err)
+	}
+
+	utils.SuccessResponse(c, "Logged in successfully", map[string]interface{}{
+		"tokens": pair,
+		"user":   user,
+	})
+}
+
+// RefreshToken exchanges a refresh token for a new token pair. The old refresh token
+// is revoked, so each one can be used once.
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	claims, err := h.tokens.ParseToken(req.RefreshToken, utils.TokenTypeRefresh)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "Invalid or expired refresh token")
+		return
+	}
+
+	user, err := h.userRepo.GetUserByID(c.Request.Context(), claims.Subject)
+	if err != nil {
+		log.Printf("RefreshToken - GetUserByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to refresh token", "Database error")
+		return
+	}
+	if user == nil || !user.IsActive() {
+		utils.UnauthorizedResponse(c, "User account is disabled")
+		return
+	}
+
+	pair, err := h.tokens.IssueTokenPair(user.ID, user.Username)
+	if err != nil {
+		log.Printf("RefreshToken - IssueTokenPair error: %v", err)
+		utils.InternalErrorResponse(c, "Failed to refresh token", "Token error")
+		return
+	}
+
+	if err := h.userRepo.RotateRefreshToken(c.Request.Context(), claims.ID, refreshTokenRecord(user.ID, pair)); err != nil {
+		if errors.Is(err, repositories.ErrInvalidRefreshToken) {
+			utils.UnauthorizedResponse(c, "Refresh token has already been used or revoked")
+			return
+		}
+		storeErrorResponse(c, err, "Failed to refresh token", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Token refreshed successfully", map[string]interface{}{
+		"tokens": pair,
+	})
+}
+
+// Logout revokes a refresh token. Access tokens stay valid until they expire.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	claims, err := h.tokens.ParseToken(req.RefreshToken, utils.TokenTypeRefresh)
+	if err != nil {
+		utils.UnauthorizedResponse(c, "Invalid or expired refresh token")
+		return
+	}
+
+	if err := h.userRepo.RevokeRefreshToken(c.Request.Context(), claims.ID, claims.Subject); err != nil {
+		if errors.Is(err, repositories.ErrInvalidRefreshToken) {
+			utils.UnauthorizedResponse(c, "Refresh token has already been used or revoked")
+			return
+		}
+		storeErrorResponse(c, err, "Failed to log out", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Logged out successfully", nil)
+}
+
+func (h *AuthHandler) Me(c *gin.Context) {
+	utils.SuccessResponse(c, "Current user retrieved successfully", map[string]interface{}{
+		"user":        middleware.CurrentUser(c),
+		"auth_method": middleware.AuthMethod(c),
+	})
+}
+
+func refreshTokenRecord(userID string, pair *utils.TokenPair) *models.RefreshToken {
+	return &models.RefreshToken{
+		ID:        pair.RefreshTokenID,
+		UserID:    userID,
+		ExpiresAt: pair.RefreshExpiresAt,
+		CreatedAt: time.Now(),
+	}
+}
@@ -13,25 +13,46 @@ import (
 )
 
 type CustomerHandler struct {
-	repo *repositories.CustomerRepository
+	repo repositories.CustomerStore
 }
 
-func NewCustomerHandler(repo *repositories.CustomerRepository) *CustomerHandler {
+func NewCustomerHandler(repo repositories.CustomerStore) *CustomerHandler {
 	return &CustomerHandler{repo: repo}
 }
 
 type CreateCustomerRequest struct {
-	Name    string `json:"name" binding:"required,min=2,max=100"`
-	Email   string `json:"email" binding:"required,email"`
-	Phone   string `json:"phone" binding:"omitempty,min=10,max=20"`
-	Address string `json:"address" binding:"max=200"`
+	Name          string `json:"name" binding:"required,min=2,max=100"`
+	Email         string `json:"email" binding:"required,email"`
+	Phone         string `json:"phone" binding:"omitempty,min=10,max=20"`
+	Address       string `json:"address" binding:"max=200"`
+	CustomerGroup string `json:"customer_group" binding:"max=100"`
+
+	// Tax address and exemption
+	Country               string `json:"country" binding:"omitempty,len=2,alpha"` // ISO 3166-1 alpha-2
+	Region                string `json:"region" binding:"max=50"`
+	PostalCode            string `json:"postal_code" binding:"max=20"`
+	TaxExempt             bool   `json:"tax_exempt"`
+	TaxExemptionNumber    string `json:"tax_exemption_number" binding:"max=100"` // Required when tax_exempt
+	TaxExemptionExpiresAt string `json:"tax_exemption_expires_at"`               // YYYY-MM-DD, never when empty
+
+	PaymentTermsDays *int `json:"payment_terms_days" binding:"omitempty,gte=0,lte=365"` // Defaults to 30
 }
 
 type UpdateCustomerRequest struct {
-	Name    string `json:"name" binding:"omitempty,min=2,max=100"`
-	Email   string `json:"email" binding:"omitempty,email"`
-	Phone   string `json:"phone" binding:"omitempty,min=10,max=20"`
-	Address string `json:"address" binding:"omitempty,max=200"`
+	Name          string  `json:"name" binding:"omitempty,min=2,max=100"`
+	Email         string  `json:"email" binding:"omitempty,email"`
+	Phone         string  `json:"phone" binding:"omitempty,min=10,max=20"`
+	Address       string  `json:"address" binding:"omitempty,max=200"`
+	CustomerGroup *string `json:"customer_group" binding:"omitempty,max=100"` // An empty string takes the customer out of its group
+
+	Country               *string `json:"country" binding:"omitempty,max=2"`
+	Region                *string `json:"region" binding:"omitempty,max=50"`
+	PostalCode            *string `json:"postal_code" binding:"omitempty,max=20"`
+	TaxExempt             *bool   `json:"tax_exempt"`
+	TaxExemptionNumber    *string `json:"tax_exemption_number" binding:"omitempty,max=100"`
+	TaxExemptionExpiresAt *string `json:"tax_exemption_expires_at"` // An empty string removes the expiry
+
+	PaymentTermsDays *int `json:"payment_terms_days" binding:"omitempty,gte=0,lte=365"`
 }
 
 func (h *CustomerHandler) CreateCustomer(c *gin.Context) {
@@ -47,16 +68,33 @@ func (h *CustomerHandler) CreateCustomer(c *gin.Context) {
 		req.Phone,
 		req.Address,
 	)
+	customer.Group = strings.TrimSpace(req.CustomerGroup)
+	customer.Country = models.NormalizeCountry(req.Country)
+	customer.Region = models.NormalizeRegion(req.Region)
+	customer.PostalCode = strings.TrimSpace(req.PostalCode)
+	customer.TaxExempt = req.TaxExempt
+	customer.TaxExemptionNumber = strings.TrimSpace(req.TaxExemptionNumber)
+	expiresAt, ok := taxExemptionExpiry(c, req.TaxExemptionExpiresAt)
+	if !ok {
+		return
+	}
+	customer.TaxExemptionExpiresAt = expiresAt
+	if !validTaxExemption(c, customer) {
+		return
+	}
+	if req.PaymentTermsDays != nil {
+		customer.PaymentTermsDays = *req.PaymentTermsDays
+	}
 
-	if err := h.repo.CreateCustomer(customer); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") {
+	ctx := auditContext(c, models.AuditEntityCustomer, customer.ID, models.AuditActionCreate, nil, customer)
+	if err := h.repo.CreateCustomer(ctx, customer); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate email", "A customer with this email already exists")
 			return
 		}
 
 		log.Printf("CreateCustomer error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to create customer", "Database error")
+		storeErrorResponse(c, err, "Failed to create customer", "Database error")
 		return
 	}
 
@@ -68,10 +106,10 @@ func (h *CustomerHandler) GetAllCustomers(c *gin.Context) {
 	search := c.Query("search")
 	email := c.Query("email")
 
-	customers, total, err := h.repo.GetCustomerWithPagination(page, pageSize, search, email)
+	customers, total, err := h.repo.GetCustomerWithPagination(c.Request.Context(), page, pageSize, search, email)
 	if err != nil {
 		log.Printf("GetAllCustomers error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve customers", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve customers", "Database error")
 		return
 	}
 
@@ -91,10 +129,10 @@ func (h *CustomerHandler) GetAllCustomers(c *gin.Context) {
 }
 
 func (h *CustomerHandler) GetListCustomers(c *gin.Context) {
-	customers, err := h.repo.GetAllCustomers()
+	customers, err := h.repo.GetAllCustomers(c.Request.Context())
 	if err != nil {
 		log.Printf("GetListCustomers error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve customers", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve customers", "Database error")
 		return
 	}
 
@@ -103,10 +141,10 @@ func (h *CustomerHandler) GetListCustomers(c *gin.Context) {
 
 func (h *CustomerHandler) GetCustomerByID(c *gin.Context) {
 	id := c.Param("id")
-	customer, err := h.repo.GetCustomerByID(id)
+	customer, err := h.repo.GetCustomerByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("GetCustomerByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve customer", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve customer", "Database error")
 		return
 	}
 	if customer == nil {
@@ -126,10 +164,10 @@ func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
 	}
 
 	// get existing customer
-	customer, err := h.repo.GetCustomerByID(id)
+	customer, err := h.repo.GetCustomerByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("UpdateCustomer - GetCustomerByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve customer", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve customer", "Database error")
 		return
 	}
 
@@ -137,6 +175,7 @@ func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
 		utils.NotFoundResponse(c, "Customer not found")
 		return
 	}
+	before := *customer
 
 	// update fields only if they are provided in request
 	updatedFields := []string{}
@@ -156,6 +195,53 @@ func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
 		customer.Address = req.Address
 		updatedFields = append(updatedFields, "address")
 	}
+	if req.CustomerGroup != nil && strings.TrimSpace(*req.CustomerGroup) != customer.Group {
+		customer.Group = strings.TrimSpace(*req.CustomerGroup)
+		updatedFields = append(updatedFields, "customer_group")
+	}
+	if req.Country != nil && models.NormalizeCountry(*req.Country) != customer.Country {
+		country := models.NormalizeCountry(*req.Country)
+		if country != "" && (len(country) != 2 || strings.Trim(country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+			utils.ValidationErrorResponse(c, "Validation error", "country must be a two-letter ISO 3166-1 code")
+			return
+		}
+		customer.Country = country
+		updatedFields = append(updatedFields, "country")
+	}
+	if req.Region != nil && models.NormalizeRegion(*req.Region) != customer.Region {
+		customer.Region = models.NormalizeRegion(*req.Region)
+		updatedFields = append(updatedFields, "region")
+	}
+	if req.PostalCode != nil && strings.TrimSpace(*req.PostalCode) != customer.PostalCode {
+		customer.PostalCode = strings.TrimSpace(*req.PostalCode)
+		updatedFields = append(updatedFields, "postal_code")
+	}
+	if req.TaxExempt != nil && *req.TaxExempt != customer.TaxExempt {
+		customer.TaxExempt = *req.TaxExempt
+		updatedFields = append(updatedFields, "tax_exempt")
+	}
+	if req.TaxExemptionNumber != nil && strings.TrimSpace(*req.TaxExemptionNumber) != customer.TaxExemptionNumber {
+		customer.TaxExemptionNumber = strings.TrimSpace(*req.TaxExemptionNumber)
+		updatedFields = append(updatedFields, "tax_exemption_number")
+	}
+	if req.TaxExemptionExpiresAt != nil {
+		expiresAt, ok := taxExemptionExpiry(c, *req.TaxExemptionExpiresAt)
+		if !ok {
+			return
+		}
+		if (expiresAt == nil) != (customer.TaxExemptionExpiresAt == nil) ||
+			(expiresAt != nil && !expiresAt.Equal(*customer.TaxExemptionExpiresAt)) {
+			customer.TaxExemptionExpiresAt = expiresAt
+			updatedFields = append(updatedFields, "tax_exemption_expires_at")
+		}
+	}
+	if !validTaxExemption(c, customer) {
+		return
+	}
+	if req.PaymentTermsDays != nil && *req.PaymentTermsDays != customer.PaymentTermsDays {
+		customer.PaymentTermsDays = *req.PaymentTermsDays
+		updatedFields = append(updatedFields, "payment_terms_days")
+	}
 
 	// If no fields were updated
 	if len(updatedFields) == 0 {
@@ -166,25 +252,24 @@ func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
 	// Update timestamp
 	customer.UpdatedAt = time.Now()
 
-	if err := h.repo.UpdateCustomer(customer); err != nil {
+	ctx := auditContext(c, models.AuditEntityCustomer, id, models.AuditActionUpdate, &before, customer)
+	if err := h.repo.UpdateCustomer(ctx, customer); err != nil {
 		// Check for duplicate email error
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") ||
-			strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate email", "A customer with this email already exists")
 			return
 		}
 
 		log.Printf("UpdateCustomer - UpdateCustomer error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to update customer", "Database error")
+		storeErrorResponse(c, err, "Failed to update customer", "Database error")
 		return
 	}
 
 	// Get updated customer to return fresh data
-	updatedCustomer, err := h.repo.GetCustomerByID(id)
+	updatedCustomer, err := h.repo.GetCustomerByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("UpdateCustomer - Get updated customer error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve updated customer", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve updated customer", "Database error")
 		return
 	}
 
@@ -198,10 +283,10 @@ func (h *CustomerHandler) UpdateCustomer(c *gin.Context) {
 
 func (h *CustomerHandler) DeleteCustomer(c *gin.Context) {
 	id := c.Param("id")
-	customer, err := h.repo.GetCustomerByID(id)
+	customer, err := h.repo.GetCustomerByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("DeleteCustomer - GetCustomerByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve customer", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve customer", "Database error")
 		return
 	}
 	if customer == nil {
@@ -209,11 +294,37 @@ func (h *CustomerHandler) DeleteCustomer(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.DeleteCustomer(id); err != nil {
+	ctx := auditContext(c, models.AuditEntityCustomer, id, models.AuditActionDelete, customer, nil)
+	if err := h.repo.DeleteCustomer(ctx, id); err != nil {
 		log.Printf("DeleteCustomer - DeleteCustomer error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to delete customer", "Database error")
+		storeErrorResponse(c, err, "Failed to delete customer", "Database error")
 		return
 	}
 
 	utils.SuccessResponse(c, "Customer deleted successfully", nil)
 }
+
+// taxExemptionExpiry parses the last day an exemption certificate is valid. It writes
+// the error response and returns false when the date is malformed.
+func taxExemptionExpiry(c *gin.Context, value string) (*time.Time, bool) {
+	if strings.TrimSpace(value) == "" {
+		return nil, true
+	}
+	t, err := time.Parse("2006-01-02", strings.TrimSpace(value))
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", "tax_exemption_expires_at must be a date (YYYY-MM-DD)")
+		return nil, false
+	}
+	return &t, true
+}
+
+// validTaxExemption requires an exemption certificate number for every exempt customer,
+// so each untaxed sale can be traced to one. It writes the error response and returns
+// false when the number is missing.
+func validTaxExemption(c *gin.Context, customer *models.Customer) bool {
+	if customer.TaxExempt && customer.TaxExemptionNumber == "" {
+		utils.ValidationErrorResponse(c, "Validation error", "tax_exemption_number is required for a tax exempt customer")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"erp-project/database"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isUniqueViolation recognizes unique constraint errors from PostgreSQL and SQLite
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "duplicate key value") ||
+		strings.Contains(err.Error(), "violates unique constraint") ||
+		strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// storeErrorResponse reports a failed repository call. Calls stopped by the request or
+// query deadline get the timeout code so clients can tell them apart and retry.
+func storeErrorResponse(c *gin.Context, err error, message string, details interface{}) {
+	if database.IsTimeout(err) || errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		utils.TimeoutResponse(c, message)
+		return
+	}
+	utils.InternalErrorResponse(c, message, details)
+}
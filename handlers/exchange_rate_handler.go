@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"erp-project/middleware"
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRateImportBytes caps the size of an uploaded rate file
+const maxRateImportBytes = 1 << 20
+
+type ExchangeRateHandler struct {
+	repo repositories.ExchangeRateStore
+}
+
+func NewExchangeRateHandler(repo repositories.ExchangeRateStore) *ExchangeRateHandler {
+	return &ExchangeRateHandler{repo: repo}
+}
+
+type CreateExchangeRatesRequest struct {
+	Rates []ExchangeRateRequest `json:"rates" binding:"required,min=1,dive"`
+}
+
+// ExchangeRateRequest sets what one unit of a currency is worth in the base currency
+// from a day on
+type ExchangeRateRequest struct {
+	Currency      string      `json:"currency" binding:"required,len=3"`
+	Rate          models.Rate `json:"rate" binding:"required,gt=0"`
+	EffectiveDate string      `json:"effective_date" binding:"required"` // YYYY-MM-DD
+}
+
+// CreateExchangeRates saves a batch of rates sent as JSON. A rate for a currency and
+// day that already has one replaces it.
+func (h *ExchangeRateHandler) CreateExchangeRates(c *gin.Context) {
+	var req CreateExchangeRatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	var rates []*models.ExchangeRate
+	var problems []string
+	for i, rateReq := range req.Rates {
+		rate, err := newExchangeRate(rateReq.Currency, rateReq.Rate.String(), rateReq.EffectiveDate,
+			models.ExchangeRateSourceAPI, currentUsername(c))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("rates[%d]: %v", i, err))
+			continue
+		}
+		rates = append(rates, rate)
+	}
+	if len(problems) > 0 {
+		utils.ValidationErrorResponse(c, "Invalid exchange rates", problems)
+		return
+	}
+
+	h.saveRates(c, rates)
+}
+
+// ImportExchangeRates loads rates from a CSV file with a header row naming the columns
+// currency, rate and effective_date, in any order. The file is sent either as the
+// "file" field of a multipart form or as the request body. Nothing is saved unless
+// every row is valid.
+func (h *ExchangeRateHandler) ImportExchangeRates(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRateImportBytes)
+
+	var source io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "Upload the CSV file in the \"file\" form field")
+			return
+		}
+		opened, err := file.Open()
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "Could not read the uploaded file")
+			return
+		}
+		defer opened.Close()
+		source = opened
+	}
+
+	rates, problems := parseExchangeRateCSV(source, currentUsername(c))
+	if len(problems) > 0 {
+		utils.ValidationErrorResponse(c, "Invalid exchange rate file", problems)
+		return
+	}
+	if len(rates) == 0 {
+		utils.ValidationErrorResponse(c, "Invalid exchange rate file", "The file has no rates")
+		return
+	}
+
+	h.saveRates(c, rates)
+}
+
+func (h *ExchangeRateHandler) saveRates(c *gin.Context, rates []*models.ExchangeRate) {
+	if err := h.repo.CreateExchangeRates(c.Request.Context(), rates); err != nil {
+		log.Printf("CreateExchangeRates error: %v", err)
+		storeErrorResponse(c, err, "Failed to save exchange rates", "Database error")
+		return
+	}
+
+	utils.CreatedResponse(c, "Exchange rates saved successfully", map[string]interface{}{
+		"base_currency": models.BaseCurrency.Code,
+		"count":         len(rates),
+		"rates":         rates,
+	})
+}
+
+// GetExchangeRates returns the rate history against the base currency, newest first
+// per currency. currency, from and to narrow it down.
+func (h *ExchangeRateHandler) GetExchangeRates(c *gin.Context) {
+	filter := repositories.ExchangeRateFilter{Currency: c.Query("currency")}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseTimeParam(to, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.To = &t
+	}
+
+	rates, err := h.repo.GetExchangeRates(c.Request.Context(), filter)
+	if err != nil {
+		log.Printf("GetExchangeRates error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve exchange rates", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Exchange rates retrieved successfully", map[string]interface{}{
+		"base_currency": models.BaseCurrency.Code,
+		"rates":         rates,
+	})
+}
+
+// GetCurrencies lists the currencies amounts may be in
+func (h *ExchangeRateHandler) GetCurrencies(c *gin.Context) {
+	utils.SuccessResponse(c, "Currencies retrieved successfully", map[string]interface{}{
+		"base_currency": models.BaseCurrency,
+		"currencies":    models.Currencies(),
+	})
+}
+
+// Convert converts an amount between two currencies at the rates in effect on a day,
+// today by default, e.g. /api/exchange-rates/convert?amount=100&from=EUR&to=GBP
+func (h *ExchangeRateHandler) Convert(c *gin.Context) {
+	amount, err := models.ParseMoney(c.Query("amount"))
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", "amount must be a decimal number with at most four decimal places")
+		return
+	}
+	from, fromOK := models.LookupCurrency(c.DefaultQuery("from", models.BaseCurrency.Code))
+	to, toOK := models.LookupCurrency(c.DefaultQuery("to", models.BaseCurrency.Code))
+	if !fromOK || !toOK {
+		utils.ValidationErrorResponse(c, "Validation error", "from and to must be supported currency codes")
+		return
+	}
+	on := time.Now()
+	if date := c.Query("date"); date != "" {
+		if on, err = parseTimeParam(date, false); err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "date must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+	}
+
+	book := newRateBook(h.repo, on)
+	fromRate, err := book.rate(c.Request.Context(), from.Code)
+	if err != nil {
+		rateErrorResponse(c, err, "Failed to convert amount")
+		return
+	}
+	toRate, err := book.rate(c.Request.Context(), to.Code)
+	if err != nil {
+		rateErrorResponse(c, err, "Failed to convert amount")
+		return
+	}
+
+	utils.SuccessResponse(c, "Amount converted successfully", map[string]interface{}{
+		"amount":        amount,
+		"from":          from.Code,
+		"to":            to.Code,
+		"date":          models.RateDate(on).Format("2006-01-02"),
+		"from_rate":     fromRate,
+		"to_rate":       toRate,
+		"base_currency": models.BaseCurrency.Code,
+		"converted":     to.Round(models.ConvertMoney(amount, fromRate, toRate)),
+	})
+}
+
+// parseExchangeRateCSV reads a rate file, returning a problem per invalid row
+func parseExchangeRateCSV(source io.Reader, createdBy string) ([]*models.ExchangeRate, []string) {
+	reader := csv.NewReader(source)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []string{fmt.Sprintf("header: %v", err)}
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	var problems []string
+	for _, name := range []string{"currency", "rate", "effective_date"} {
+		if _, ok := columns[name]; !ok {
+			problems = append(problems, fmt.Sprintf("header: missing column %q", name))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	var rates []*models.ExchangeRate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, append(problems, fmt.Sprintf("the file is larger than %d bytes", maxRateImportBytes))
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+
+		rate, err := newExchangeRate(record[columns["currency"]], record[columns["rate"]], record[columns["effective_date"]],
+			models.ExchangeRateSourceCSV, createdBy)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		rates = append(rates, rate)
+	}
+	return rates, problems
+}
+
+// newExchangeRate validates one rate as sent by a client
+func newExchangeRate(currencyCode, rateText, effectiveDate, source, createdBy string) (*models.ExchangeRate, error) {
+	currency, ok := models.LookupCurrency(strings.TrimSpace(currencyCode))
+	if !ok {
+		return nil, fmt.Errorf("unsupported currency %q", currencyCode)
+	}
+	if currency.Code == models.BaseCurrency.Code {
+		return nil, fmt.Errorf("%s is the base currency, whose rate is always 1", currency.Code)
+	}
+	rate, err := models.ParseRate(rateText)
+	if err != nil {
+		return nil, err
+	}
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(effectiveDate))
+	if err != nil {
+		return nil, fmt.Errorf("effective_date %q must be a date (YYYY-MM-DD)", effectiveDate)
+	}
+	return models.NewExchangeRate(currency.Code, rate, date, source, createdBy), nil
+}
+
+// currentUsername names the authenticated user for created_by columns
+func currentUsername(c *gin.Context) string {
+	if user := middleware.CurrentUser(c); user != nil {
+		return user.Username
+	}
+	return ""
+}
+
+// missingRateError reports a currency with no exchange rate in effect on a day
+type missingRateError struct {
+	Currency string
+	Date     time.Time
+}
+
+func (e *missingRateError) Error() string {
+	return fmt.Sprintf("no exchange rate for %s to %s on %s", e.Currency, models.BaseCurrency.Code, e.Date.Format("2006-01-02"))
+}
+
+// rateBook looks up exchange rates to the base currency for one day, fetching each
+// currency's rate at most once
+type rateBook struct {
+	repo  repositories.ExchangeRateStore
+	on    time.Time
+	rates map[string]models.Rate
+}
+
+func newRateBook(repo repositories.ExchangeRateStore, on time.Time) *rateBook {
+	return &rateBook{repo: repo, on: on, rates: map[string]models.Rate{}}
+}
+
+// rate returns the currency's rate to the base currency, or a *missingRateError
+func (b *rateBook) rate(ctx context.Context, currency string) (models.Rate, error) {
+	if rate, ok := b.rates[currency]; ok {
+		return rate, nil
+	}
+	rate, err := b.repo.GetRate(ctx, currency, b.on)
+	if err != nil {
+		return 0, err
+	}
+	if rate == nil {
+		return 0, &missingRateError{Currency: currency, Date: models.RateDate(b.on)}
+	}
+	b.rates[currency] = rate.Rate
+	return rate.Rate, nil
+}
+
+// convert converts an amount between currencies, unrounded beyond Money's precision
+func (b *rateBook) convert(ctx context.Context, amount models.Money, from, to string) (models.Money, error) {
+	if from == to {
+		return amount, nil
+	}
+	fromRate, err := b.rate(ctx, from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := b.rate(ctx, to)
+	if err != nil {
+		return 0, err
+	}
+	return models.ConvertMoney(amount, fromRate, toRate), nil
+}
+
+// rateErrorResponse answers a failed rate lookup: a missing rate is the client's to
+// fix, anything else is a store error
+func rateErrorResponse(c *gin.Context, err error, message string) {
+	var missing *missingRateError
+	if errors.As(err, &missing) {
+		utils.BadRequestResponse(c, "Missing exchange rate", map[string]interface{}{
+			"currency":      missing.Currency,
+			"base_currency": models.BaseCurrency.Code,
+			"date":          missing.Date.Format("2006-01-02"),
+			"message":       missing.Error(),
+		})
+		return
+	}
+	log.Printf("%s: %v", message, err)
+	storeErrorResponse(c, err, message, "Database error")
+}
+
+// requestCurrency returns the currency a request names, or the base currency when it
+// names none. It answers the request itself when the currency is not supported.
+func requestCurrency(c *gin.Context, code string) (models.Currency, bool) {
+	if code == "" {
+		return models.BaseCurrency, true
+	}
+	currency, ok := models.LookupCurrency(code)
+	if !ok {
+		utils.ValidationErrorResponse(c, "Unsupported currency", map[string]interface{}{
+			"currency": code,
+			"message":  "See /api/currencies for the supported currency codes",
+		})
+	}
+	return currency, ok
+}
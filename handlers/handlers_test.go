@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"erp-project/middleware"
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testServer is the product, auth and user API wired to an in-memory store, with a user
+// of each role the tests need and an access token for each
+type testServer struct {
+	router *gin.Engine
+	store  *repositories.Store
+	users  map[string]*models.User
+	tokens map[string]string
+}
+
+func newTestServer(t *testing.T, products repositories.ProductStore) *testServer {
+	t.Helper()
+
+	store, err := repositories.NewInMemoryStore()
+	if err != nil {
+		t.Fatalf("in-memory store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if products == nil {
+		products = store.Products
+	}
+
+	tokenManager := utils.NewTokenManager([]byte("test-secret"), time.Minute, time.Hour)
+	users := map[string]*models.User{}
+	tokens := map[string]string{}
+	for _, role := range []string{models.RoleAdmin, models.RoleWarehouse, models.RoleViewer} {
+		user, err := models.NewUser(role, "", role, "password123", []string{role})
+		if err != nil {
+			t.Fatalf("new user: %v", err)
+		}
+		if err := store.Users.CreateUser(t.Context(), user); err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+		pair, err := tokenManager.IssueTokenPair(user.ID, user.Username)
+		if err != nil {
+			t.Fatalf("issue token: %v", err)
+		}
+		users[role] = user
+		tokens[role] = pair.AccessToken
+	}
+
+	handler := NewProductHandler(products)
+	authHandler := NewAuthHandler(store.Users, tokenManager)
+	userHandler := NewUserHandler(store.Users)
+	authenticate := middleware.Authenticate(tokenManager, store.Users)
+	can := middleware.RequirePermission
+	router := gin.New()
+	router.Use(middleware.Timeout(10 * time.Second))
+	group := router.Group("/api/products", authenticate)
+	group.POST("/", can(models.PermissionProductsCreate), handler.CreateProduct)
+	group.GET("/", can(models.PermissionProductsRead), handler.GetAllProducts)
+	group.GET("/:id", can(models.PermissionProductsRead), handler.GetProductByID)
+	group.PUT("/:id", can(models.PermissionProductsUpdate), handler.UpdateProduct)
+	group.DELETE("/:id", can(models.PermissionProductsDelete), handler.DeleteProduct)
+
+	auth := router.Group("/api/auth")
+	auth.POST("/login", authHandler.Login)
+	auth.POST("/refresh", authHandler.RefreshToken)
+	auth.POST("/logout", authHandler.Logout)
+	auth.GET("/me", authenticate, authHandler.Me)
+
+	admin := router.Group("/api/users", authenticate, can(models.PermissionUsersManage))
+	admin.GET("/", userHandler.GetUsers)
+	admin.PUT("/:id", userHandler.UpdateUser)
+	admin.POST("/:id/api-keys", userHandler.CreateAPIKey)
+	admin.DELETE("/:id/api-keys/:key_id", userHandler.RevokeAPIKey)
+
+	return &testServer{router: router, store: store, users: users, tokens: tokens}
+}
+
+// do sends a request as the given role and decodes the standard response envelope
+func (s *testServer) do(t *testing.T, role, method, path string, body interface{}) (int, utils.Response) {
+	t.Helper()
+
+	header := http.Header{}
+	if token := s.tokens[role]; token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return s.send(t, header, method, path, body)
+}
+
+// send sends a request with the given headers, such as other credentials, and decodes
+// the standard response envelope
+func (s *testServer) send(t *testing.T, header http.Header, method, path string, body interface{}) (int, utils.Response) {
+	t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+
+	var resp utils.Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %s %s response %q: %v", method, path, rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestProductHandler(t *testing.T) {
+	s := newTestServer(t, nil)
+	widget := map[string]interface{}{"name": "Widget", "sku": "WID-001", "price": 9.5, "quantity": 3}
+
+	status, resp := s.do(t, models.RoleAdmin, http.MethodPost, "/api/products/", widget)
+	if status != http.StatusCreated || resp.ResponseCode != utils.CodeCreated {
+		t.Fatalf("create: status %d, response %+v", status, resp)
+	}
+	id, _ := resp.ResponseData.(map[string]interface{})["id"].(string)
+
+	if _, resp := s.do(t, models.RoleAdmin, http.MethodPost, "/api/products/", widget); resp.ResponseCode != utils.CodeDuplicate {
+		t.Errorf("duplicate SKU: got code %s", resp.ResponseCode)
+	}
+	if _, resp := s.do(t, models.RoleAdmin, http.MethodPost, "/api/products/", map[string]interface{}{"name": "X"}); resp.ResponseCode != utils.CodeValidation {
+		t.Errorf("invalid body: got code %s", resp.ResponseCode)
+	}
+
+	if status, resp := s.do(t, models.RoleViewer, http.MethodGet, "/api/products/"+id, nil); status != http.StatusOK || resp.ResponseCode != utils.CodeSuccess {
+		t.Errorf("get: status %d, response %+v", status, resp)
+	}
+	if _, resp := s.do(t, models.RoleViewer, http.MethodGet, "/api/products/missing", nil); resp.ResponseCode != utils.CodeNotFound {
+		t.Errorf("get missing: got code %s", resp.ResponseCode)
+	}
+
+	// An update that leaves out the quantity leaves the stock alone
+	if status, resp := s.do(t, models.RoleAdmin, http.MethodPut, "/api/products/"+id, map[string]interface{}{"name": "Gizmo"}); status != http.StatusOK {
+		t.Errorf("update: status %d, response %+v", status, resp)
+	}
+	if product, err := s.store.Products.GetProductByID(t.Context(), id); err != nil || product.Name != "Gizmo" || product.Quantity != 3 {
+		t.Errorf("after update: %+v err=%v", product, err)
+	}
+	// A stock change is put down to the signed-in user
+	if status, resp := s.do(t, models.RoleAdmin, http.MethodPut, "/api/products/"+id, map[string]interface{}{"quantity": 5}); status != http.StatusOK {
+		t.Errorf("update quantity: status %d, response %+v", status, resp)
+	}
+	movements, _, err := s.store.StockMovements.GetMovements(t.Context(), repositories.StockMovementFilter{ProductID: id, MovementType: models.MovementTypeAdjustment}, 1, 10)
+	if err != nil || len(movements) != 1 || movements[0].Quantity != 2 || movements[0].CreatedBy != models.RoleAdmin {
+		t.Errorf("quantity movement: %+v err=%v", movements, err)
+	}
+
+	entries, total, err := s.store.Audit.GetAuditLogs(t.Context(), repositories.AuditLogFilter{EntityType: models.AuditEntityProduct, EntityID: id}, 1, 10)
+	if err != nil || total != 3 || entries[2].Action != models.AuditActionCreate {
+		t.Errorf("audit trail: %+v total=%d err=%v", entries, total, err)
+	}
+}
+
+func TestProductHandlerPermissions(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	if status, resp := s.do(t, "", http.MethodGet, "/api/products/", nil); status != http.StatusUnauthorized || resp.ResponseCode != utils.CodeUnauthorized {
+		t.Errorf("anonymous: status %d, code %s", status, resp.ResponseCode)
+	}
+	product := map[string]interface{}{"name": "Widget", "sku": "WID-001", "price": 9.5, "quantity": 3}
+	if status, resp := s.do(t, models.RoleViewer, http.MethodPost, "/api/products/", product); status != http.StatusForbidden || resp.ResponseCode != utils.CodeForbidden {
+		t.Errorf("viewer create: status %d, code %s", status, resp.ResponseCode)
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	if status, resp := s.do(t, models.RoleViewer, http.MethodGet, "/api/users/", nil); status != http.StatusForbidden || resp.ResponseCode != utils.CodeForbidden {
+		t.Errorf("viewer lists users: status %d, code %s", status, resp.ResponseCode)
+	}
+	if status, resp := s.do(t, models.RoleAdmin, http.MethodGet, "/api/users/", nil); status != http.StatusOK {
+		t.Errorf("admin lists users: status %d, response %+v", status, resp)
+	}
+
+	status, resp := s.do(t, models.RoleAdmin, http.MethodPost, "/api/products/", map[string]interface{}{"name": "Widget", "sku": "WID-001", "price": 9.5, "quantity": 3})
+	if status != http.StatusCreated {
+		t.Fatalf("create: status %d, response %+v", status, resp)
+	}
+	id, _ := resp.ResponseData.(map[string]interface{})["id"].(string)
+
+	// The warehouse can count stock but not reprice it
+	if status, resp := s.do(t, models.RoleWarehouse, http.MethodPut, "/api/products/"+id, map[string]interface{}{"quantity": 4}); status != http.StatusOK {
+		t.Errorf("warehouse updates quantity: status %d, response %+v", status, resp)
+	}
+	status, resp = s.do(t, models.RoleWarehouse, http.MethodPut, "/api/products/"+id, map[string]interface{}{"price": 12})
+	if details, _ := resp.ResponseData.(map[string]interface{}); status != http.StatusForbidden || details["required_permission"] != models.PermissionProductsUpdatePrice {
+		t.Errorf("warehouse updates price: status %d, response %+v", status, resp)
+	}
+	if product, err := s.store.Products.GetProductByID(t.Context(), id); err != nil || product.Quantity != 4 || product.Price != models.MustParseMoney("9.5") {
+		t.Errorf("after warehouse updates: %+v err=%v", product, err)
+	}
+	if status, resp := s.do(t, models.RoleAdmin, http.MethodPut, "/api/products/"+id, map[string]interface{}{"price": 12}); status != http.StatusOK {
+		t.Errorf("admin updates price: status %d, response %+v", status, resp)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s := newTestServer(t, nil)
+	viewer := s.users[models.RoleViewer]
+
+	if status, resp := s.do(t, "", http.MethodPost, "/api/auth/login", map[string]string{"username": viewer.Username, "password": "wrong-password"}); status != http.StatusUnauthorized || resp.ResponseCode != utils.CodeUnauthorized {
+		t.Errorf("wrong password: status %d, code %s", status, resp.ResponseCode)
+	}
+	if status, resp := s.do(t, "", http.MethodPost, "/api/auth/login", map[string]string{"username": "nobody", "password": "password123"}); status != http.StatusUnauthorized || resp.ResponseDesc != "Invalid username or password" {
+		t.Errorf("unknown user: status %d, response %+v", status, resp)
+	}
+	status, resp := s.do(t, "", http.MethodPost, "/api/auth/login", map[string]string{"username": viewer.Username, "password": "password123"})
+	if status != http.StatusOK {
+		t.Fatalf("login: status %d, response %+v", status, resp)
+	}
+	tokens, _ := resp.ResponseData.(map[string]interface{})["tokens"].(map[string]interface{})
+	access, _ := tokens["access_token"].(string)
+	refresh, _ := tokens["refresh_token"].(string)
+
+	header := func(key, value string) http.Header {
+		h := http.Header{}
+		h.Set(key, value)
+		return h
+	}
+	bearer := func(token string) http.Header {
+		return header("Authorization", "Bearer "+token)
+	}
+	status, resp = s.send(t, bearer(access), http.MethodGet, "/api/auth/me", nil)
+	if me, _ := resp.ResponseData.(map[string]interface{}); status != http.StatusOK || me["auth_method"] != middleware.AuthMethodJWT {
+		t.Errorf("me with access token: status %d, response %+v", status, resp)
+	}
+	// A refresh token is not an access token
+	if status, _ := s.send(t, bearer(refresh), http.MethodGet, "/api/auth/me", nil); status != http.StatusUnauthorized {
+		t.Errorf("me with refresh token: status %d", status)
+	}
+
+	// A refresh token can be exchanged once
+	if status, resp := s.do(t, "", http.MethodPost, "/api/auth/refresh", map[string]string{"refresh_token": refresh}); status != http.StatusOK {
+		t.Errorf("refresh: status %d, response %+v", status, resp)
+	}
+	if status, resp := s.do(t, "", http.MethodPost, "/api/auth/refresh", map[string]string{"refresh_token": refresh}); status != http.StatusUnauthorized || resp.ResponseCode != utils.CodeUnauthorized {
+		t.Errorf("refresh reused: status %d, code %s", status, resp.ResponseCode)
+	}
+
+	// An API key authenticates as the user it was issued to until it is revoked
+	status, resp = s.do(t, models.RoleAdmin, http.MethodPost, "/api/users/"+viewer.ID+"/api-keys", map[string]string{"name": "Reporting"})
+	if status != http.StatusCreated {
+		t.Fatalf("create API key: status %d, response %+v", status, resp)
+	}
+	created, _ := resp.ResponseData.(map[string]interface{})
+	key, _ := created["key"].(string)
+	keyID, _ := created["api_key"].(map[string]interface{})["id"].(string)
+	withKey := header(middleware.APIKeyHeader, key)
+
+	if status, resp := s.send(t, withKey, http.MethodGet, "/api/products/", nil); status != http.StatusOK {
+		t.Errorf("list with API key: status %d, response %+v", status, resp)
+	}
+	status, resp = s.send(t, withKey, http.MethodGet, "/api/auth/me", nil)
+	if me, _ := resp.ResponseData.(map[string]interface{}); status != http.StatusOK || me["auth_method"] != middleware.AuthMethodAPIKey {
+		t.Errorf("me with API key: status %d, response %+v", status, resp)
+	}
+	if status, _ := s.send(t, header(middleware.APIKeyHeader, key+"x"), http.MethodGet, "/api/products/", nil); status != http.StatusUnauthorized {
+		t.Errorf("unknown API key: status %d", status)
+	}
+	if status, resp := s.do(t, models.RoleAdmin, http.MethodDelete, "/api/users/"+viewer.ID+"/api-keys/"+keyID, nil); status != http.StatusOK {
+		t.Errorf("revoke API key: status %d, response %+v", status, resp)
+	}
+	if status, _ := s.send(t, withKey, http.MethodGet, "/api/products/", nil); status != http.StatusUnauthorized {
+		t.Errorf("revoked API key: status %d", status)
+	}
+
+	// Disabling an account shuts out its outstanding access tokens
+	if status, resp := s.do(t, models.RoleAdmin, http.MethodPut, "/api/users/"+viewer.ID, map[string]string{"status": "inactive"}); status != http.StatusOK {
+		t.Errorf("disable user: status %d, response %+v", status, resp)
+	}
+	if status, resp := s.send(t, bearer(access), http.MethodGet, "/api/products/", nil); status != http.StatusUnauthorized || resp.ResponseCode != utils.CodeUnauthorized {
+		t.Errorf("disabled user: status %d, code %s", status, resp.ResponseCode)
+	}
+}
+
+// failingProducts is a ProductStore whose lookups always fail, standing in for a
+// database that has gone away
+type failingProducts struct {
+	repositories.ProductStore
+}
+
+func (failingProducts) GetProductByID(ctx context.Context, id string) (*models.Product, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestProductHandlerStoreError(t *testing.T) {
+	s := newTestServer(t, failingProducts{})
+
+	status, resp := s.do(t, models.RoleAdmin, http.MethodGet, "/api/products/any", nil)
+	if status != http.StatusInternalServerError || resp.ResponseCode != utils.CodeInternal {
+		t.Errorf("store error: status %d, code %s", status, resp.ResponseCode)
+	}
+}
+
+// slowProducts is a ProductStore whose lookups never finish before the request deadline
+type slowProducts struct {
+	repositories.ProductStore
+}
+
+func (slowProducts) GetProductByID(ctx context.Context, id string) (*models.Product, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestProductHandlerTimeout(t *testing.T) {
+	// The shared server's deadline leaves room for password hashing, so this route gets
+	// a router of its own with a short one
+	router := gin.New()
+	router.Use(middleware.Timeout(200 * time.Millisecond))
+	router.GET("/api/products/:id", NewProductHandler(slowProducts{}).GetProductByID)
+	s := &testServer{router: router}
+
+	status, resp := s.do(t, "", http.MethodGet, "/api/products/any", nil)
+	if status != http.StatusGatewayTimeout || resp.ResponseCode != utils.CodeTimeout {
+		t.Errorf("slow store: status %d, code %s", status, resp.ResponseCode)
+	}
+}
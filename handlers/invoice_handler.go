@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InvoiceHandler struct {
+	repo         repositories.InvoiceStore
+	orderRepo    repositories.OrderStore
+	customerRepo repositories.CustomerStore
+}
+
+func NewInvoiceHandler(repo repositories.InvoiceStore, orderRepo repositories.OrderStore, customerRepo repositories.CustomerStore) *InvoiceHandler {
+	return &InvoiceHandler{repo: repo, orderRepo: orderRepo, customerRepo: customerRepo}
+}
+
+// CreateInvoiceRequest bills shipped units of an order. Without lines every shipped
+// unit not yet invoiced is billed.
+type CreateInvoiceRequest struct {
+	OrderID          string                    `json:"order_id" binding:"required"`
+	Lines            []InvoiceOrderLineRequest `json:"lines" binding:"omitempty,dive"`
+	PaymentTermsDays *int                      `json:"payment_terms_days" binding:"omitempty,gte=0,lte=365"` // Defaults to the customer's
+	Notes            string                    `json:"notes" binding:"max=1000"`
+	Issue            bool                      `json:"issue"` // Issue straight away instead of saving a draft
+}
+
+type InvoiceOrderLineRequest struct {
+	OrderItemID string `json:"order_item_id" binding:"required"`
+	Quantity    int    `json:"quantity" binding:"required,gt=0"`
+}
+
+// CreateCreditNoteRequest credits units of an issued invoice. Without lines every unit
+// not yet credited is credited.
+type CreateCreditNoteRequest struct {
+	Reason string                  `json:"reason" binding:"required,max=500"`
+	Lines  []CreditNoteLineRequest `json:"lines" binding:"omitempty,dive"`
+	Issue  bool                    `json:"issue"`
+}
+
+type CreditNoteLineRequest struct {
+	InvoiceLineID string `json:"invoice_line_id" binding:"required"`
+	Quantity      int    `json:"quantity" binding:"required,gt=0"`
+}
+
+type VoidInvoiceRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+func (h *InvoiceHandler) CreateInvoice(c *gin.Context) {
+	var req CreateInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	order, err := h.orderRepo.GetOrderByID(c.Request.Context(), req.OrderID)
+	if err != nil {
+		log.Printf("CreateInvoice - GetOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve order", "Database error")
+		return
+	}
+	if order == nil {
+		utils.BadRequestResponse(c, "Invalid order ID", "Order not found")
+		return
+	}
+
+	// The customer's payment terms apply unless the invoice agrees others
+	paymentTermsDays := models.DefaultPaymentTermsDays
+	if req.PaymentTermsDays != nil {
+		paymentTermsDays = *req.PaymentTermsDays
+	} else {
+		customer, err := h.customerRepo.GetCustomerByID(c.Request.Context(), order.CustomerID)
+		if err != nil {
+			log.Printf("CreateInvoice - GetCustomerByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to retrieve customer", "Database error")
+			return
+		}
+		if customer != nil {
+			paymentTermsDays = customer.PaymentTermsDays
+		}
+	}
+
+	quantities := map[string]int{}
+	for _, line := range req.Lines {
+		quantities[line.OrderItemID] += line.Quantity
+	}
+
+	invoice := models.NewInvoice(order, paymentTermsDays, req.Notes, currentUsername(c))
+	if err := h.repo.CreateInvoice(c.Request.Context(), invoice, quantities); err != nil {
+		h.invoiceErrorResponse(c, err, "create")
+		return
+	}
+
+	h.respondCreated(c, invoice.ID, req.Issue, "Invoice created successfully", "Invoice created and issued successfully")
+}
+
+// GetInvoices lists invoices and credit notes, newest first. Filters: customer_id,
+// order_id, type, status, currency, from and to (invoice date) and overdue=true.
+func (h *InvoiceHandler) GetInvoices(c *gin.Context) {
+	page, pageSize := utils.GetPaginationParams(c)
+
+	filter := repositories.InvoiceFilter{
+		CustomerID: c.Query("customer_id"),
+		OrderID:    c.Query("order_id"),
+		Type:       c.Query("type"),
+		Status:     c.Query("status"),
+		Currency:   c.Query("currency"),
+	}
+	switch filter.Type {
+	case "", models.InvoiceTypeInvoice, models.InvoiceTypeCreditNote:
+	default:
+		utils.ValidationErrorResponse(c, "Validation error", "type must be invoice or credit_note")
+		return
+	}
+	switch filter.Status {
+	case "", models.InvoiceStatusDraft, models.InvoiceStatusIssued, models.InvoiceStatusPartiallyPaid,
+		models.InvoiceStatusPaid, models.InvoiceStatusVoid:
+	default:
+		utils.ValidationErrorResponse(c, "Validation error", "status must be draft, issued, partially_paid, paid or void")
+		return
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseTimeParam(to, true)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.To = &t
+	}
+	if c.Query("overdue") == "true" {
+		now := time.Now()
+		filter.OverdueOn = &now
+	}
+
+	invoices, total, err := h.repo.GetInvoices(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		log.Printf("GetInvoices error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve invoices", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"invoices": invoices,
+		"pagination": utils.Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+			Pages:    utils.CalculateTotalPages(total, pageSize),
+		},
+	}
+
+	utils.SuccessResponse(c, "Invoices retrieved successfully", responseData)
+}
+
+// GetInvoiceByID returns an invoice with its lines and the credit notes raised against it
+func (h *InvoiceHandler) GetInvoiceByID(c *gin.Context) {
+	invoice, err := h.repo.GetInvoiceByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetInvoiceByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve invoice", "Database error")
+		return
+	}
+	if invoice == nil {
+		utils.NotFoundResponse(c, "Invoice not found")
+		return
+	}
+
+	creditNotes := []models.Invoice{}
+	if invoice.Type == models.InvoiceTypeInvoice {
+		creditNotes, err = h.repo.GetCreditNotes(c.Request.Context(), invoice.ID)
+		if err != nil {
+			log.Printf("GetInvoiceByID - GetCreditNotes error: %v", err)
+			storeErrorResponse(c, err, "Failed to retrieve credit notes", "Database error")
+			return
+		}
+	}
+
+	utils.SuccessResponse(c, "Invoice retrieved successfully", map[string]interface{}{
+		"invoice":      invoice,
+		"credit_notes": creditNotes,
+		"overdue":      invoice.Overdue(time.Now()),
+	})
+}
+
+// IssueInvoice gives a draft invoice or credit note the next number in its sequence and
+// dates it today
+func (h *InvoiceHandler) IssueInvoice(c *gin.Context) {
+	invoice, err := h.repo.IssueInvoice(c.Request.Context(), c.Param("id"), currentUsername(c), time.Now())
+	if err != nil {
+		h.invoiceErrorResponse(c, err, "issue")
+		return
+	}
+
+	utils.SuccessResponse(c, "Invoice issued successfully", invoice)
+}
+
+func (h *InvoiceHandler) VoidInvoice(c *gin.Context) {
+	var req VoidInvoiceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	invoice, err := h.repo.VoidInvoice(c.Request.Context(), c.Param("id"), req.Reason, currentUsername(c))
+	if err != nil {
+		h.invoiceErrorResponse(c, err, "void")
+		return
+	}
+
+	utils.SuccessResponse(c, "Invoice voided successfully", invoice)
+}
+
+// CreateCreditNote raises a credit note against an issued invoice. Once issued it
+// reduces what the customer owes on the invoice.
+func (h *InvoiceHandler) CreateCreditNote(c *gin.Context) {
+	var req CreateCreditNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	invoice, err := h.repo.GetInvoiceByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("CreateCreditNote - GetInvoiceByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve invoice", "Database error")
+		return
+	}
+	if invoice == nil {
+		utils.NotFoundResponse(c, "Invoice not found")
+		return
+	}
+
+	quantities := map[string]int{}
+	for _, line := range req.Lines {
+		quantities[line.InvoiceLineID] += line.Quantity
+	}
+
+	creditNote := models.NewCreditNote(invoice, req.Reason, currentUsername(c))
+	if err := h.repo.CreateCreditNote(c.Request.Context(), creditNote, quantities); err != nil {
+		h.invoiceErrorResponse(c, err, "create credit note for")
+		return
+	}
+
+	h.respondCreated(c, creditNote.ID, req.Issue, "Credit note created successfully", "Credit note created and issued successfully")
+}
+
+// respondCreated writes out a newly created draft, issuing it first when asked to
+func (h *InvoiceHandler) respondCreated(c *gin.Context, id string, issue bool, message, issuedMessage string) {
+	var invoice *models.Invoice
+	var err error
+	if issue {
+		invoice, err = h.repo.IssueInvoice(c.Request.Context(), id, currentUsername(c), time.Now())
+		message = issuedMessage
+	} else {
+		invoice, err = h.repo.GetInvoiceByID(c.Request.Context(), id)
+	}
+	if err != nil {
+		log.Printf("Invoice respondCreated error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve invoice", "Database error")
+		return
+	}
+
+	utils.CreatedResponse(c, message, invoice)
+}
+
+// invoiceErrorResponse maps invoice errors from the repository to responses
+func (h *InvoiceHandler) invoiceErrorResponse(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, repositories.ErrInvoiceNotFound):
+		utils.NotFoundResponse(c, "Invoice not found")
+	case errors.Is(err, repositories.ErrOrderNotFound):
+		utils.BadRequestResponse(c, "Invalid order ID", "Order not found")
+	case errors.Is(err, repositories.ErrInvalidInvoiceStatus):
+		utils.BadRequestResponse(c, "Invalid invoice status", err.Error())
+	case errors.Is(err, repositories.ErrInvalidInvoice):
+		utils.BadRequestResponse(c, "Invalid invoice", err.Error())
+	default:
+		log.Printf("Invoice %s error: %v", action, err)
+		storeErrorResponse(c, err, "Failed to "+action+" invoice", "Database error")
+	}
+}
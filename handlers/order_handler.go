@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"log"
+	"os"
+	"time"
 
 	"erp-project/models"
 	"erp-project/repositories"
@@ -11,25 +15,48 @@ import (
 )
 
 type OrderHandler struct {
-	orderRepo    *repositories.OrderRepository
-	productRepo  *repositories.ProductRepository
-	customerRepo *repositories.CustomerRepository
+	orderRepo        repositories.OrderStore
+	productRepo      repositories.ProductStore
+	customerRepo     repositories.CustomerStore
+	exchangeRateRepo repositories.ExchangeRateStore
+	priceListRepo    repositories.PriceListStore
+	promotionRepo    repositories.PromotionStore
+	taxRepo          repositories.TaxStore
 }
 
 func NewOrderHandler(
-	orderRepo *repositories.OrderRepository,
-	productRepo *repositories.ProductRepository,
-	customerRepo *repositories.CustomerRepository) *OrderHandler {
+	orderRepo repositories.OrderStore,
+	productRepo repositories.ProductStore,
+	customerRepo repositories.CustomerStore,
+	exchangeRateRepo repositories.ExchangeRateStore,
+	priceListRepo repositories.PriceListStore,
+	promotionRepo repositories.PromotionStore,
+	taxRepo repositories.TaxStore) *OrderHandler {
 	return &OrderHandler{
-		orderRepo:    orderRepo,
-		productRepo:  productRepo,
-		customerRepo: customerRepo,
+		orderRepo:        orderRepo,
+		productRepo:      productRepo,
+		customerRepo:     customerRepo,
+		exchangeRateRepo: exchangeRateRepo,
+		priceListRepo:    priceListRepo,
+		promotionRepo:    promotionRepo,
+		taxRepo:          taxRepo,
 	}
 }
 
 type CreateOrderRequest struct {
 	CustomerID string             `json:"customer_id" binding:"required"`
+	Currency   string             `json:"currency" binding:"omitempty,len=3"` // Defaults to the base currency
 	Items      []OrderItemRequest `json:"items" binding:"required,min=1"`
+	CouponCode string             `json:"coupon_code" binding:"max=50"`
+
+	// Whether unit prices already include tax, defaults to PRICES_INCLUDE_TAX
+	PricesIncludeTax *bool `json:"prices_include_tax"`
+
+	// Warehouse allocation, defaults to ORDER_ALLOCATION_STRATEGY or split
+	AllocationStrategy string   `json:"allocation_strategy" binding:"omitempty,oneof=single nearest split"`
+	WarehouseID        string   `json:"warehouse_id"`
+	ShipToLatitude     *float64 `json:"ship_to_latitude" binding:"omitempty,gte=-90,lte=90"`
+	ShipToLongitude    *float64 `json:"ship_to_longitude" binding:"omitempty,gte=-180,lte=180"`
 }
 
 type OrderItemRequest struct {
@@ -37,18 +64,37 @@ type OrderItemRequest struct {
 	Quantity  int    `json:"quantity" binding:"required,gt=0"`
 }
 
+type CancelOrderRequest struct {
+	Reason string                   `json:"reason" binding:"required,max=500"`
+	Items  []CancelOrderItemRequest `json:"items" binding:"omitempty,dive"`
+}
+
+type CancelOrderItemRequest struct {
+	OrderItemID string `json:"order_item_id" binding:"required"`
+	Quantity    int    `json:"quantity" binding:"omitempty,gt=0"` // Defaults to the rest of the line
+}
+
+type OrderTransitionRequest struct {
+	Status string `json:"status" binding:"required,oneof=pending confirmed picking shipped delivered cancelled on_hold"`
+	Reason string `json:"reason" binding:"max=500"`
+}
+
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	var req CreateOrderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.ValidationErrorResponse(c, "Validation error", err.Error())
 		return
 	}
+	currency, ok := requestCurrency(c, req.Currency)
+	if !ok {
+		return
+	}
 
 	// Verify customer exists
-	customer, err := h.customerRepo.GetCustomerByID(req.CustomerID)
+	customer, err := h.customerRepo.GetCustomerByID(c.Request.Context(), req.CustomerID)
 	if err != nil {
 		log.Printf("CreateOrder - GetCustomerByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to validate customer", "Database error")
+		storeErrorResponse(c, err, "Failed to validate customer", "Database error")
 		return
 	}
 
@@ -57,16 +103,70 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	var totalAmount float64
+	// Prices are converted at the rates in effect on the order date, and the order keeps
+	// its own rate to the base currency for reporting
+	orderDate := time.Now()
+	rates := newRateBook(h.exchangeRateRepo, orderDate)
+	orderRate, err := rates.rate(c.Request.Context(), currency.Code)
+	if err != nil {
+		rateErrorResponse(c, err, "Failed to load exchange rate")
+		return
+	}
+
+	// The customer's own price lists and its group's that apply on the order date
+	// replace catalogue prices line by line
+	priceLists, err := h.priceListRepo.GetApplicablePriceLists(c.Request.Context(), customer.ID, customer.Group, orderDate)
+	if err != nil {
+		log.Printf("CreateOrder - GetApplicablePriceLists error: %v", err)
+		storeErrorResponse(c, err, "Failed to load price lists", "Database error")
+		return
+	}
+
+	// Promotions that apply on their own, plus the one the coupon unlocks
+	promotions, coupon, ok := h.orderPromotions(c, req.CouponCode, customer, orderDate)
+	if !ok {
+		return
+	}
+	for i := range promotions {
+		if err := convertPromotion(c.Request.Context(), rates, &promotions[i], currency.Code); err != nil {
+			rateErrorResponse(c, err, "Failed to load exchange rate")
+			return
+		}
+	}
+
+	// Sales are taxed where the customer is, or at home for customers with no address
+	// on file; an exempt customer's sales are recorded but not taxed
+	jurisdiction := customer.Jurisdiction()
+	if jurisdiction.Country == "" {
+		jurisdiction = homeTaxJurisdiction()
+	}
+	taxExempt := customer.TaxExemptOn(orderDate)
+	var taxRates []models.TaxRate
+	if !taxExempt && jurisdiction.Country != "" {
+		taxRates, err = h.taxRepo.GetApplicableTaxRates(c.Request.Context(), jurisdiction, orderDate)
+		if err != nil {
+			log.Printf("CreateOrder - GetApplicableTaxRates error: %v", err)
+			storeErrorResponse(c, err, "Failed to load tax rates", "Database error")
+			return
+		}
+	}
+	pricesIncludeTax := defaultPricesIncludeTax()
+	if req.PricesIncludeTax != nil {
+		pricesIncludeTax = *req.PricesIncludeTax
+	}
+
 	var orderItems []*models.OrderItem
+	productNames := map[string]string{}
+	categories := map[string]string{}
+	taxCategories := map[string]string{}
 
 	// process each item
 	for _, itemReq := range req.Items {
 		// get product
-		product, err := h.productRepo.GetProductByID(itemReq.ProductID)
+		product, err := h.productRepo.GetProductByID(c.Request.Context(), itemReq.ProductID)
 		if err != nil {
 			log.Printf("CreateOrder - GetProductByID error: %v", err)
-			utils.InternalErrorResponse(c, "Failed to validate product", "Database error")
+			storeErrorResponse(c, err, "Failed to validate product", "Database error")
 			return
 		}
 
@@ -78,55 +178,165 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 			return
 		}
 
-		// check stock
-		if product.Quantity < itemReq.Quantity {
-			utils.BadRequestResponse(c, "Insufficient stock", map[string]interface{}{
-				"product_id":   itemReq.ProductID,
-				"product_name": product.Name,
-				"available":    product.Quantity,
-				"requested":    itemReq.Quantity,
-			})
+		// Stock is checked and decremented atomically in CreateOrderWithItems
+		productNames[product.ID] = product.Name
+		categories[product.ID] = product.Category
+		taxCategories[product.ID] = product.TaxCategory
+
+		listPrice, err := rates.convert(c.Request.Context(), product.Price, models.CurrencyOf(product.Currency).Code, currency.Code)
+		if err != nil {
+			rateErrorResponse(c, err, "Failed to load exchange rate")
 			return
 		}
 
-		// calculate total amount
-		itemTotal := product.Price * float64(itemReq.Quantity)
-		totalAmount += itemTotal
+		// create order item; it works out its own rounded line total
+		orderItem := models.NewOrderItem("", product.ID, itemReq.Quantity, listPrice, currency)
 
-		// create order item
-		orderItem := models.NewOrderItem("", product.ID, itemReq.Quantity, product.Price)
+		// A price list tier for this quantity overrides the catalogue price, and the line
+		// records which one it was
+		if list, tier := models.ResolvePrice(priceLists, product.ID, itemReq.Quantity, orderDate); tier != nil {
+			unitPrice, err := rates.convert(c.Request.Context(), tier.UnitPrice, models.CurrencyOf(list.Currency).Code, currency.Code)
+			if err != nil {
+				rateErrorResponse(c, err, "Failed to load exchange rate")
+				return
+			}
+			orderItem.ApplyPriceList(list, tier, unitPrice, currency)
+		}
 		orderItems = append(orderItems, orderItem)
 	}
 
-	// create order
-	order := models.NewOrder(req.CustomerID, totalAmount)
+	// Discounts come off the priced lines
+	models.ApplyPromotions(orderItems, promotions, models.PromotionContext{
+		CustomerID:    customer.ID,
+		CustomerGroup: customer.Group,
+		Categories:    categories,
+		Currency:      currency,
+		On:            orderDate,
+		Coupon:        coupon,
+	})
+	if coupon != nil && !couponApplied(orderItems, coupon) {
+		utils.BadRequestResponse(c, "Coupon does not apply", map[string]interface{}{
+			"coupon_code": coupon.Code,
+			"message":     "None of the order's lines qualify for the coupon's promotion, or the order is below its minimum",
+		})
+		return
+	}
+
+	// Tax is reckoned on the discounted lines
+	for _, item := range orderItems {
+		category := taxCategories[item.ProductID]
+		item.ApplyTax(category, models.ResolveTaxRate(taxRates, jurisdiction, category, orderDate), pricesIncludeTax, currency)
+	}
+
+	// create order; its total and tax are the exact sums of the lines'
+	order := models.NewOrder(req.CustomerID, models.OrderItemsTotal(orderItems))
+	order.TaxAmount = models.OrderItemsTax(orderItems)
+	order.OrderDate = orderDate
+	order.SetCurrency(currency, orderRate)
+	if coupon != nil {
+		order.CouponID = &coupon.ID
+	}
+	order.PricesIncludeTax = pricesIncludeTax
+	order.TaxCountry = jurisdiction.Country
+	order.TaxRegion = jurisdiction.Region
+	if taxExempt {
+		order.TaxExempt = true
+		order.TaxExemptionNumber = customer.TaxExemptionNumber
+	}
 
 	// update order items with order ID
 	for i := range orderItems {
 		orderItems[i].OrderID = order.ID
 	}
 
+	allocationOptions := models.AllocationOptions{
+		Strategy:    req.AllocationStrategy,
+		WarehouseID: req.WarehouseID,
+		Latitude:    req.ShipToLatitude,
+		Longitude:   req.ShipToLongitude,
+	}
+	if allocationOptions.Strategy == "" {
+		allocationOptions.Strategy = defaultAllocationStrategy()
+	}
+
 	// save order with items (transaction)
-	if err := h.orderRepo.CreateOrderWithItems(order, orderItems); err != nil {
+	allocations, err := h.orderRepo.CreateOrderWithItems(c.Request.Context(), order, orderItems, allocationOptions, currentUsername(c))
+	if err != nil {
+		if errors.Is(err, repositories.ErrCouponUnavailable) {
+			utils.BadRequestResponse(c, "Coupon not available", map[string]interface{}{
+				"coupon_code": coupon.Code,
+				"message":     err.Error(),
+			})
+			return
+		}
+
+		var stockErr *repositories.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			details := map[string]interface{}{
+				"product_id":   stockErr.ProductID,
+				"product_name": productNames[stockErr.ProductID],
+				"available":    stockErr.Available,
+				"requested":    stockErr.Requested,
+			}
+			if stockErr.Strategy != "" {
+				details["allocation_strategy"] = stockErr.Strategy
+				utils.BadRequestResponse(c, "Insufficient warehouse stock", details)
+				return
+			}
+			utils.BadRequestResponse(c, "Insufficient stock", details)
+			return
+		}
+
 		log.Printf("CreateOrder - CreateOrderWithItems error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to create order", "Database error")
+		storeErrorResponse(c, err, "Failed to create order", "Database error")
 		return
 	}
 
+	var discountAmount models.Money
+	for _, item := range orderItems {
+		discountAmount += item.DiscountAmount
+	}
+	// Tax added on top is not part of the prices the subtotal is made of
+	subtotalAmount := order.TotalAmount + discountAmount
+	if !order.PricesIncludeTax {
+		subtotalAmount -= order.TaxAmount
+	}
+	couponCode := ""
+	if coupon != nil {
+		couponCode = coupon.Code
+	}
+
 	// Prepare response data
 	responseData := map[string]interface{}{
 		"order": map[string]interface{}{
-			"id":            order.ID,
-			"customer_id":   order.CustomerID,
-			"customer_name": customer.Name,
-			"total_amount":  order.TotalAmount,
-			"status":        order.Status,
-			"order_date":    order.OrderDate,
+			"id":                 order.ID,
+			"customer_id":        order.CustomerID,
+			"customer_name":      customer.Name,
+			"currency":           order.Currency,
+			"total_amount":       order.TotalAmount,
+			"discount_amount":    discountAmount,
+			"coupon_code":        couponCode,
+			"tax_amount":         order.TaxAmount,
+			"prices_include_tax": order.PricesIncludeTax,
+			"tax_country":        order.TaxCountry,
+			"tax_region":         order.TaxRegion,
+			"tax_exempt":         order.TaxExempt,
+			"exchange_rate":      order.ExchangeRate,
+			"base_total_amount":  order.BaseTotalAmount,
+			"status":             order.Status,
+			"order_date":         order.OrderDate,
 		},
-		"items": orderItems,
+		"items":       orderItems,
+		"allocations": allocations,
 		"summary": map[string]interface{}{
-			"total_items":  len(orderItems),
-			"total_amount": totalAmount,
+			"total_items":         len(orderItems),
+			"currency":            order.Currency,
+			"subtotal_amount":     subtotalAmount,
+			"discount_amount":     discountAmount,
+			"tax_amount":          order.TaxAmount,
+			"total_amount":        order.TotalAmount,
+			"base_total_amount":   order.BaseTotalAmount,
+			"allocation_strategy": allocationOptions.Strategy,
 		},
 	}
 
@@ -134,25 +344,355 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 }
 
 func (h *OrderHandler) GetOrders(c *gin.Context) {
-	orders, err := h.orderRepo.GetOrders()
+	orders, err := h.orderRepo.GetOrders(c.Request.Context())
 	if err != nil {
 		log.Printf("GetOrders error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to fetch orders", "Database error")
+		storeErrorResponse(c, err, "Failed to fetch orders", "Database error")
 		return
 	}
 
 	utils.SuccessResponse(c, "Orders retrieved successfully", orders)
 }
 
+// GetOrderSummary totals orders per currency and in the base currency, optionally for
+// orders placed between from and to
+func (h *OrderHandler) GetOrderSummary(c *gin.Context) {
+	var from, to *time.Time
+	if value := c.Query("from"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		from = &t
+	}
+	if value := c.Query("to"); value != "" {
+		t, err := parseTimeParam(value, true)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		to = &t
+	}
+
+	totals, err := h.orderRepo.GetOrderTotals(c.Request.Context(), from, to)
+	if err != nil {
+		log.Printf("GetOrderSummary error: %v", err)
+		storeErrorResponse(c, err, "Failed to summarise orders", "Database error")
+		return
+	}
+
+	var orders int
+	var baseTotal models.Money
+	for _, total := range totals {
+		orders += total.Orders
+		baseTotal += total.BaseTotalAmount
+	}
+
+	utils.SuccessResponse(c, "Order summary retrieved successfully", map[string]interface{}{
+		"base_currency":     models.BaseCurrency.Code,
+		"orders":            orders,
+		"base_total_amount": baseTotal,
+		"currencies":        totals,
+	})
+}
+
 func (h *OrderHandler) GetOrderItems(c *gin.Context) {
 	orderID := c.Param("id")
 
-	items, err := h.orderRepo.GetOrderItems(orderID)
+	items, err := h.orderRepo.GetOrderItems(c.Request.Context(), orderID)
 	if err != nil {
 		log.Printf("GetOrderItems error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to fetch order items", "Database error")
+		storeErrorResponse(c, err, "Failed to fetch order items", "Database error")
 		return
 	}
 
 	utils.SuccessResponse(c, "Order items retrieved successfully", items)
 }
+
+func (h *OrderHandler) TransitionOrder(c *gin.Context) {
+	orderID := c.Param("id")
+
+	var req OrderTransitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	order, err := h.orderRepo.GetOrderByID(c.Request.Context(), orderID)
+	if err != nil {
+		log.Printf("TransitionOrder - GetOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve order", "Database error")
+		return
+	}
+	if order == nil {
+		utils.NotFoundResponse(c, "Order not found")
+		return
+	}
+
+	history, err := h.orderRepo.TransitionOrderStatus(c.Request.Context(), orderID, req.Status, req.Reason, currentUsername(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrOrderNotFound):
+			utils.NotFoundResponse(c, "Order not found")
+		case errors.Is(err, repositories.ErrInvalidStatusTransition):
+			utils.BadRequestResponse(c, "Invalid status transition", map[string]interface{}{
+				"current_status":      order.Status,
+				"requested_status":    req.Status,
+				"allowed_transitions": models.AllowedOrderTransitions(order.Status),
+			})
+		case errors.Is(err, repositories.ErrOrderStatusChanged):
+			utils.BadRequestResponse(c, "Order status changed, please retry", map[string]interface{}{
+				"requested_status": req.Status,
+			})
+		default:
+			log.Printf("TransitionOrder - TransitionOrderStatus error: %v", err)
+			storeErrorResponse(c, err, "Failed to update order status", "Database error")
+		}
+		return
+	}
+
+	order.Status = history.ToStatus
+
+	responseData := map[string]interface{}{
+		"order":               order,
+		"transition":          history,
+		"allowed_transitions": models.AllowedOrderTransitions(order.Status),
+	}
+
+	utils.SuccessResponse(c, "Order status updated successfully", responseData)
+}
+
+func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
+	orderID := c.Param("id")
+
+	order, err := h.orderRepo.GetOrderByID(c.Request.Context(), orderID)
+	if err != nil {
+		log.Printf("GetOrderHistory - GetOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve order", "Database error")
+		return
+	}
+	if order == nil {
+		utils.NotFoundResponse(c, "Order not found")
+		return
+	}
+
+	history, err := h.orderRepo.GetOrderStatusHistory(c.Request.Context(), orderID)
+	if err != nil {
+		log.Printf("GetOrderHistory error: %v", err)
+		storeErrorResponse(c, err, "Failed to fetch order history", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"order":               order,
+		"history":             history,
+		"allowed_transitions": models.AllowedOrderTransitions(order.Status),
+	}
+
+	utils.SuccessResponse(c, "Order history retrieved successfully", responseData)
+}
+
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+	orderID := c.Param("id")
+
+	var req CancelOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	order, err := h.orderRepo.GetOrderByID(c.Request.Context(), orderID)
+	if err != nil {
+		log.Printf("CancelOrder - GetOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve order", "Database error")
+		return
+	}
+	if order == nil {
+		utils.NotFoundResponse(c, "Order not found")
+		return
+	}
+
+	lines := map[string]int{}
+	for _, item := range req.Items {
+		if _, exists := lines[item.OrderItemID]; exists {
+			utils.BadRequestResponse(c, "Duplicate order item", map[string]interface{}{
+				"order_item_id": item.OrderItemID,
+			})
+			return
+		}
+		lines[item.OrderItemID] = item.Quantity
+	}
+
+	cancellations, history, err := h.orderRepo.CancelOrder(c.Request.Context(), orderID, lines, req.Reason, currentUsername(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrOrderNotFound):
+			utils.NotFoundResponse(c, "Order not found")
+		case errors.Is(err, repositories.ErrInvalidStatusTransition):
+			utils.BadRequestResponse(c, "Order can no longer be cancelled", map[string]interface{}{
+				"current_status": order.Status,
+			})
+		case errors.Is(err, repositories.ErrInvalidCancellation):
+			utils.BadRequestResponse(c, "Invalid cancellation", err.Error())
+		case errors.Is(err, repositories.ErrOrderStatusChanged):
+			utils.BadRequestResponse(c, "Order status changed, please retry", nil)
+		default:
+			log.Printf("CancelOrder error: %v", err)
+			storeErrorResponse(c, err, "Failed to cancel order", "Database error")
+		}
+		return
+	}
+
+	updatedOrder, err := h.orderRepo.GetOrderByID(c.Request.Context(), orderID)
+	if err != nil {
+		log.Printf("CancelOrder - Get updated order error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve updated order", "Database error")
+		return
+	}
+
+	var restoredQuantity int
+	for _, cancellation := range cancellations {
+		restoredQuantity += cancellation.Quantity
+	}
+
+	responseData := map[string]interface{}{
+		"order":         updatedOrder,
+		"cancellations": cancellations,
+		"summary": map[string]interface{}{
+			"cancelled_lines":   len(cancellations),
+			"restored_quantity": restoredQuantity,
+			"fully_cancelled":   history != nil,
+		},
+	}
+
+	utils.SuccessResponse(c, "Order cancelled successfully", responseData)
+}
+
+func (h *OrderHandler) GetOrderCancellations(c *gin.Context) {
+	orderID := c.Param("id")
+
+	cancellations, err := h.orderRepo.GetOrderCancellations(c.Request.Context(), orderID)
+	if err != nil {
+		log.Printf("GetOrderCancellations error: %v", err)
+		storeErrorResponse(c, err, "Failed to fetch order cancellations", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Order cancellations retrieved successfully", cancellations)
+}
+
+func (h *OrderHandler) GetOrderAllocations(c *gin.Context) {
+	orderID := c.Param("id")
+
+	allocations, err := h.orderRepo.GetOrderAllocations(c.Request.Context(), orderID)
+	if err != nil {
+		log.Printf("GetOrderAllocations error: %v", err)
+		storeErrorResponse(c, err, "Failed to fetch order allocations", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Order allocations retrieved successfully", allocations)
+}
+
+// defaultAllocationStrategy reads ORDER_ALLOCATION_STRATEGY, falling back to split
+func defaultAllocationStrategy() string {
+	switch strategy := os.Getenv("ORDER_ALLOCATION_STRATEGY"); strategy {
+	case models.AllocationStrategySingle, models.AllocationStrategyNearest, models.AllocationStrategySplit:
+		return strategy
+	default:
+		return models.AllocationStrategySplit
+	}
+}
+
+// orderPromotions loads the promotions an order placed now may use: every running
+// promotion that needs no coupon, and the promotion behind the coupon code if one is
+// given. It writes the error response and returns false when the coupon cannot be used.
+func (h *OrderHandler) orderPromotions(c *gin.Context, couponCode string, customer *models.Customer, on time.Time) ([]models.Promotion, *models.Coupon, bool) {
+	requiresCoupon := false
+	promotions, err := h.promotionRepo.GetPromotions(c.Request.Context(), repositories.PromotionFilter{
+		ActiveAt:       &on,
+		RequiresCoupon: &requiresCoupon,
+	})
+	if err != nil {
+		log.Printf("CreateOrder - GetPromotions error: %v", err)
+		storeErrorResponse(c, err, "Failed to load promotions", "Database error")
+		return nil, nil, false
+	}
+	if models.NormalizeCouponCode(couponCode) == "" {
+		return promotions, nil, true
+	}
+
+	coupon, err := h.promotionRepo.GetCouponByCode(c.Request.Context(), couponCode)
+	if err != nil {
+		log.Printf("CreateOrder - GetCouponByCode error: %v", err)
+		storeErrorResponse(c, err, "Failed to validate coupon", "Database error")
+		return nil, nil, false
+	}
+	if coupon == nil {
+		utils.BadRequestResponse(c, "Invalid coupon code", "Coupon not found")
+		return nil, nil, false
+	}
+	promotion, err := h.promotionRepo.GetPromotionByID(c.Request.Context(), coupon.PromotionID)
+	if err != nil {
+		log.Printf("CreateOrder - GetPromotionByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to validate coupon", "Database error")
+		return nil, nil, false
+	}
+
+	problem := ""
+	switch {
+	case !coupon.Active:
+		problem = "The coupon has been deactivated"
+	case coupon.Exhausted():
+		problem = "The coupon's usage limit has been reached"
+	case promotion == nil || !promotion.ActiveAt(on):
+		problem = "The coupon's promotion is not running"
+	case !promotion.Eligible(models.PromotionContext{CustomerID: customer.ID, CustomerGroup: customer.Group, On: on, Coupon: coupon}):
+		problem = "The coupon's promotion is not open to this customer"
+	}
+	if problem != "" {
+		utils.BadRequestResponse(c, "Coupon not available", map[string]interface{}{
+			"coupon_code": coupon.Code,
+			"message":     problem,
+		})
+		return nil, nil, false
+	}
+
+	if promotion.RequiresCoupon {
+		promotions = append(promotions, *promotion)
+	}
+	return promotions, coupon, true
+}
+
+// convertPromotion puts a promotion's amounts into the order's currency. Percentages
+// and free units need no conversion.
+func convertPromotion(ctx context.Context, rates *rateBook, promotion *models.Promotion, currency string) error {
+	from := models.CurrencyOf(promotion.Currency).Code
+	if promotion.Type == models.PromotionTypeFixed {
+		value, err := rates.convert(ctx, promotion.Value, from, currency)
+		if err != nil {
+			return err
+		}
+		promotion.Value = value
+	}
+	minimum, err := rates.convert(ctx, promotion.MinOrderAmount, from, currency)
+	if err != nil {
+		return err
+	}
+	promotion.MinOrderAmount = minimum
+	promotion.Currency = currency
+	return nil
+}
+
+// couponApplied reports whether the coupon's promotion discounted any of the lines
+func couponApplied(items []*models.OrderItem, coupon *models.Coupon) bool {
+	for _, item := range items {
+		for _, discount := range item.Discounts {
+			if discount.CouponID != nil && *discount.CouponID == coupon.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
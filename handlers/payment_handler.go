@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PaymentHandler struct {
+	repo             repositories.PaymentStore
+	customerRepo     repositories.CustomerStore
+	exchangeRateRepo repositories.ExchangeRateStore
+}
+
+func NewPaymentHandler(repo repositories.PaymentStore, customerRepo repositories.CustomerStore, exchangeRateRepo repositories.ExchangeRateStore) *PaymentHandler {
+	return &PaymentHandler{repo: repo, customerRepo: customerRepo, exchangeRateRepo: exchangeRateRepo}
+}
+
+// CreatePaymentRequest records money received from a customer. It is allocated to the
+// invoices listed, or with auto_allocate to the oldest open invoices first; anything
+// over stays on the payment as credit.
+type CreatePaymentRequest struct {
+	CustomerID   string                     `json:"customer_id" binding:"required"`
+	Method       string                     `json:"method" binding:"required,oneof=cash check bank_transfer card other"`
+	Reference    string                     `json:"reference" binding:"max=255"`
+	Currency     string                     `json:"currency"` // Defaults to the base currency
+	Amount       models.Money               `json:"amount" binding:"gt=0"`
+	PaymentDate  string                     `json:"payment_date"` // YYYY-MM-DD, defaults to today
+	Notes        string                     `json:"notes" binding:"max=1000"`
+	Allocations  []PaymentAllocationRequest `json:"allocations" binding:"omitempty,dive"`
+	AutoAllocate bool                       `json:"auto_allocate"`
+}
+
+type PaymentAllocationRequest struct {
+	InvoiceID string       `json:"invoice_id" binding:"required"`
+	Amount    models.Money `json:"amount" binding:"gt=0"`
+}
+
+// AllocatePaymentRequest allocates what is left of a payment, to the invoices listed or
+// with auto_allocate to the oldest open invoices first
+type AllocatePaymentRequest struct {
+	Allocations  []PaymentAllocationRequest `json:"allocations" binding:"omitempty,dive"`
+	AutoAllocate bool                       `json:"auto_allocate"`
+}
+
+type VoidPaymentRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+func (h *PaymentHandler) CreatePayment(c *gin.Context) {
+	var req CreatePaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+	currency, ok := requestCurrency(c, req.Currency)
+	if !ok {
+		return
+	}
+	paymentDate := time.Now()
+	if req.PaymentDate != "" {
+		t, err := parseTimeParam(req.PaymentDate, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "payment_date must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		if t.After(time.Now()) {
+			utils.ValidationErrorResponse(c, "Validation error", "payment_date cannot be in the future")
+			return
+		}
+		paymentDate = t
+	}
+
+	customer, err := h.customerRepo.GetCustomerByID(c.Request.Context(), req.CustomerID)
+	if err != nil {
+		log.Printf("CreatePayment - GetCustomerByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to validate customer", "Database error")
+		return
+	}
+	if customer == nil {
+		utils.BadRequestResponse(c, "Invalid customer ID", "Customer not found")
+		return
+	}
+
+	// The payment keeps the rate on the day it was received, for reporting
+	rate, err := newRateBook(h.exchangeRateRepo, paymentDate).rate(c.Request.Context(), currency.Code)
+	if err != nil {
+		rateErrorResponse(c, err, "Failed to load exchange rate")
+		return
+	}
+
+	payment := models.NewPayment(customer.ID, req.Method, req.Reference, currency, rate, req.Amount, paymentDate, req.Notes, currentUsername(c))
+	if err := h.repo.CreatePayment(c.Request.Context(), payment, allocationAmounts(req.Allocations), req.AutoAllocate); err != nil {
+		h.paymentErrorResponse(c, err, "create")
+		return
+	}
+
+	saved, err := h.repo.GetPaymentByID(c.Request.Context(), payment.ID)
+	if err != nil {
+		log.Printf("CreatePayment - GetPaymentByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve payment", "Database error")
+		return
+	}
+
+	utils.CreatedResponse(c, "Payment recorded successfully", saved)
+}
+
+// GetPayments lists payments, newest first. Filters: customer_id, status, method,
+// currency, from and to (payment date) and unallocated=true.
+func (h *PaymentHandler) GetPayments(c *gin.Context) {
+	page, pageSize := utils.GetPaginationParams(c)
+
+	filter := repositories.PaymentFilter{
+		CustomerID:  c.Query("customer_id"),
+		Status:      c.Query("status"),
+		Method:      c.Query("method"),
+		Currency:    c.Query("currency"),
+		Unallocated: c.Query("unallocated") == "true",
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseTimeParam(to, true)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.To = &t
+	}
+
+	payments, total, err := h.repo.GetPayments(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		log.Printf("GetPayments error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve payments", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"payments": payments,
+		"pagination": utils.Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+			Pages:    utils.CalculateTotalPages(total, pageSize),
+		},
+	}
+
+	utils.SuccessResponse(c, "Payments retrieved successfully", responseData)
+}
+
+func (h *PaymentHandler) GetPaymentByID(c *gin.Context) {
+	payment, err := h.repo.GetPaymentByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetPaymentByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve payment", "Database error")
+		return
+	}
+	if payment == nil {
+		utils.NotFoundResponse(c, "Payment not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Payment retrieved successfully", payment)
+}
+
+// AllocatePayment applies credit left on a payment to invoices
+func (h *PaymentHandler) AllocatePayment(c *gin.Context) {
+	var req AllocatePaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+	if len(req.Allocations) == 0 && !req.AutoAllocate {
+		utils.ValidationErrorResponse(c, "Validation error", "List the allocations or set auto_allocate")
+		return
+	}
+
+	payment, err := h.repo.AllocatePayment(c.Request.Context(), c.Param("id"), allocationAmounts(req.Allocations), req.AutoAllocate, currentUsername(c))
+	if err != nil {
+		h.paymentErrorResponse(c, err, "allocate")
+		return
+	}
+
+	utils.SuccessResponse(c, "Payment allocated successfully", payment)
+}
+
+// VoidPayment cancels a payment, for instance one that bounced. The invoices it settled
+// are owed again.
+func (h *PaymentHandler) VoidPayment(c *gin.Context) {
+	var req VoidPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	payment, err := h.repo.VoidPayment(c.Request.Context(), c.Param("id"), req.Reason, currentUsername(c))
+	if err != nil {
+		h.paymentErrorResponse(c, err, "void")
+		return
+	}
+
+	utils.SuccessResponse(c, "Payment voided successfully", payment)
+}
+
+// GetInvoicePayments lists the payments allocated to an invoice
+func (h *PaymentHandler) GetInvoicePayments(c *gin.Context) {
+	allocations, err := h.repo.GetInvoiceAllocations(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetInvoicePayments error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve invoice payments", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Invoice payments retrieved successfully", allocations)
+}
+
+// GetCustomerBalance shows what a customer owes in each currency they deal in: invoiced,
+// credited, paid, overdue and credit not yet allocated
+func (h *PaymentHandler) GetCustomerBalance(c *gin.Context) {
+	customer, err := h.customerRepo.GetCustomerByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetCustomerBalance - GetCustomerByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve customer", "Database error")
+		return
+	}
+	if customer == nil {
+		utils.NotFoundResponse(c, "Customer not found")
+		return
+	}
+
+	balances, err := h.repo.GetCustomerBalance(c.Request.Context(), customer.ID, time.Now())
+	if err != nil {
+		log.Printf("GetCustomerBalance error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve customer balance", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Customer balance retrieved successfully", map[string]interface{}{
+		"customer_id":        customer.ID,
+		"customer_name":      customer.Name,
+		"payment_terms_days": customer.PaymentTermsDays,
+		"balances":           balances,
+	})
+}
+
+// GetReceivablesAging ages what each customer owes into current, 1-30, 31-60, 61-90 and
+// over 90 days past due, in the base currency, as of today or ?as_of=YYYY-MM-DD
+func (h *PaymentHandler) GetReceivablesAging(c *gin.Context) {
+	asOf := time.Now()
+	if value := c.Query("as_of"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "as_of must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		asOf = t
+	}
+
+	lines, err := h.repo.GetReceivablesAging(c.Request.Context(), asOf)
+	if err != nil {
+		log.Printf("GetReceivablesAging error: %v", err)
+		storeErrorResponse(c, err, "Failed to age receivables", "Database error")
+		return
+	}
+
+	var totals models.AgingBuckets
+	var credit models.Money
+	for _, line := range lines {
+		totals.AddBuckets(line.AgingBuckets)
+		credit += line.UnallocatedCredit
+	}
+
+	utils.SuccessResponse(c, "Receivables aging retrieved successfully", map[string]interface{}{
+		"as_of":              models.RateDate(asOf).Format("2006-01-02"),
+		"base_currency":      models.BaseCurrency.Code,
+		"totals":             totals,
+		"unallocated_credit": credit,
+		"balance":            totals.Total - credit,
+		"customers":          lines,
+	})
+}
+
+// allocationAmounts totals the requested allocations by invoice
+func allocationAmounts(requests []PaymentAllocationRequest) map[string]models.Money {
+	allocations := map[string]models.Money{}
+	for _, allocation := range requests {
+		allocations[allocation.InvoiceID] += allocation.Amount
+	}
+	return allocations
+}
+
+// paymentErrorResponse maps payment errors from the repository to responses
+func (h *PaymentHandler) paymentErrorResponse(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, repositories.ErrPaymentNotFound):
+		utils.NotFoundResponse(c, "Payment not found")
+	case errors.Is(err, repositories.ErrInvalidPaymentStatus):
+		utils.BadRequestResponse(c, "Invalid payment status", err.Error())
+	case errors.Is(err, repositories.ErrInvalidPayment):
+		utils.BadRequestResponse(c, "Invalid payment", err.Error())
+	case errors.Is(err, repositories.ErrInvoiceNotFound), errors.Is(err, repositories.ErrInvalidInvoiceStatus):
+		utils.BadRequestResponse(c, "Invalid invoice", err.Error())
+	default:
+		log.Printf("Payment %s error: %v", action, err)
+		storeErrorResponse(c, err, "Failed to "+action+" payment", "Database error")
+	}
+}
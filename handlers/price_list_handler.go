@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PriceListHandler struct {
+	repo         repositories.PriceListStore
+	customerRepo repositories.CustomerStore
+	productRepo  repositories.ProductStore
+}
+
+func NewPriceListHandler(
+	repo repositories.PriceListStore,
+	customerRepo repositories.CustomerStore,
+	productRepo repositories.ProductStore) *PriceListHandler {
+	return &PriceListHandler{
+		repo:         repo,
+		customerRepo: customerRepo,
+		productRepo:  productRepo,
+	}
+}
+
+// CreatePriceListRequest sets prices for either one customer or a customer group
+type CreatePriceListRequest struct {
+	Name          string                 `json:"name" binding:"required,max=255"`
+	CustomerID    string                 `json:"customer_id"`
+	CustomerGroup string                 `json:"customer_group" binding:"max=100"`
+	Currency      string                 `json:"currency" binding:"omitempty,len=3"` // Defaults to the base currency
+	ValidFrom     string                 `json:"valid_from" binding:"required"`      // YYYY-MM-DD
+	ValidTo       string                 `json:"valid_to"`                           // YYYY-MM-DD, open ended when empty
+	Items         []PriceListItemRequest `json:"items" binding:"required,min=1,dive"`
+}
+
+type UpdatePriceListRequest struct {
+	Name      string                 `json:"name" binding:"omitempty,max=255"`
+	ValidFrom string                 `json:"valid_from"`                     // YYYY-MM-DD
+	ValidTo   *string                `json:"valid_to"`                       // An empty string makes the list open ended
+	Active    *bool                  `json:"active"`                         // Inactive lists are never applied
+	Items     []PriceListItemRequest `json:"items" binding:"omitempty,dive"` // Replaces every item when given
+}
+
+// PriceListItemRequest prices lines of at least MinQuantity units of a product
+type PriceListItemRequest struct {
+	ProductID   string       `json:"product_id" binding:"required"`
+	MinQuantity int          `json:"min_quantity" binding:"omitempty,gte=1"` // Defaults to 1
+	UnitPrice   models.Money `json:"unit_price" binding:"required,gt=0"`
+}
+
+func (h *PriceListHandler) CreatePriceList(c *gin.Context) {
+	var req CreatePriceListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	req.CustomerID = strings.TrimSpace(req.CustomerID)
+	req.CustomerGroup = strings.TrimSpace(req.CustomerGroup)
+	if (req.CustomerID == "") == (req.CustomerGroup == "") {
+		utils.ValidationErrorResponse(c, "Validation error", "Set exactly one of customer_id and customer_group")
+		return
+	}
+	currency, ok := requestCurrency(c, req.Currency)
+	if !ok {
+		return
+	}
+	validFrom, validTo, ok := parseValidity(c, req.ValidFrom, req.ValidTo)
+	if !ok {
+		return
+	}
+
+	var customerID *string
+	if req.CustomerID != "" {
+		customer, err := h.customerRepo.GetCustomerByID(c.Request.Context(), req.CustomerID)
+		if err != nil {
+			log.Printf("CreatePriceList - GetCustomerByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to validate customer", "Database error")
+			return
+		}
+		if customer == nil {
+			utils.BadRequestResponse(c, "Invalid customer ID", "Customer not found")
+			return
+		}
+		customerID = &customer.ID
+	}
+
+	list := models.NewPriceList(req.Name, customerID, req.CustomerGroup, currency.Code, validFrom, validTo, currentUsername(c))
+	if !h.addItems(c, list, req.Items) {
+		return
+	}
+
+	ctx := auditContext(c, models.AuditEntityPriceList, list.ID, models.AuditActionCreate, nil, list)
+	if err := h.repo.CreatePriceList(ctx, list); err != nil {
+		log.Printf("CreatePriceList error: %v", err)
+		storeErrorResponse(c, err, "Failed to create price list", "Database error")
+		return
+	}
+
+	utils.CreatedResponse(c, "Price list created successfully", list)
+}
+
+// GetPriceLists lists price lists, optionally for a customer or group, or only those
+// that apply on a day (?valid_on=YYYY-MM-DD)
+func (h *PriceListHandler) GetPriceLists(c *gin.Context) {
+	filter := repositories.PriceListFilter{
+		CustomerID:    c.Query("customer_id"),
+		CustomerGroup: c.Query("customer_group"),
+	}
+	if value := c.Query("valid_on"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "valid_on must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.ValidOn = &t
+	}
+
+	lists, err := h.repo.GetPriceLists(c.Request.Context(), filter)
+	if err != nil {
+		log.Printf("GetPriceLists error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve price lists", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Price lists retrieved successfully", lists)
+}
+
+func (h *PriceListHandler) GetPriceListByID(c *gin.Context) {
+	list, err := h.repo.GetPriceListByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetPriceListByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve price list", "Database error")
+		return
+	}
+	if list == nil {
+		utils.NotFoundResponse(c, "Price list not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Price list retrieved successfully", list)
+}
+
+// UpdatePriceList renames a list, changes its validity, switches it on or off or
+// replaces its items. Lists are deactivated rather than deleted so the orders they
+// priced can still name them.
+func (h *PriceListHandler) UpdatePriceList(c *gin.Context) {
+	id := c.Param("id")
+
+	var req UpdatePriceListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	list, err := h.repo.GetPriceListByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("UpdatePriceList - GetPriceListByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve price list", "Database error")
+		return
+	}
+	if list == nil {
+		utils.NotFoundResponse(c, "Price list not found")
+		return
+	}
+	before := *list
+
+	updatedFields := []string{}
+	if req.Name != "" && req.Name != list.Name {
+		list.Name = req.Name
+		updatedFields = append(updatedFields, "name")
+	}
+
+	validFrom := list.ValidFrom.Format("2006-01-02")
+	if req.ValidFrom != "" {
+		validFrom = req.ValidFrom
+	}
+	validTo := ""
+	if list.ValidTo != nil {
+		validTo = list.ValidTo.Format("2006-01-02")
+	}
+	if req.ValidTo != nil {
+		validTo = *req.ValidTo
+	}
+	from, to, ok := parseValidity(c, validFrom, validTo)
+	if !ok {
+		return
+	}
+	if !from.Equal(list.ValidFrom) {
+		list.ValidFrom = from
+		updatedFields = append(updatedFields, "valid_from")
+	}
+	if (to == nil) != (list.ValidTo == nil) || (to != nil && !to.Equal(*list.ValidTo)) {
+		list.SetValidTo(to)
+		updatedFields = append(updatedFields, "valid_to")
+	}
+
+	if req.Active != nil && *req.Active != list.Active {
+		list.Active = *req.Active
+		updatedFields = append(updatedFields, "active")
+	}
+	if req.Items != nil {
+		list.Items = nil
+		if !h.addItems(c, list, req.Items) {
+			return
+		}
+		updatedFields = append(updatedFields, "items")
+	}
+
+	if len(updatedFields) == 0 {
+		utils.SuccessResponse(c, "No changes detected", list)
+		return
+	}
+
+	ctx := auditContext(c, models.AuditEntityPriceList, id, models.AuditActionUpdate, &before, list)
+	if err := h.repo.UpdatePriceList(ctx, list); err != nil {
+		log.Printf("UpdatePriceList error: %v", err)
+		storeErrorResponse(c, err, "Failed to update price list", "Database error")
+		return
+	}
+
+	updatedList, err := h.repo.GetPriceListByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("UpdatePriceList - Get updated price list error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve updated price list", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Price list updated successfully", map[string]interface{}{
+		"price_list":     updatedList,
+		"updated_fields": updatedFields,
+	})
+}
+
+// addItems checks every requested tier and adds them to the list. It writes the error
+// response and returns false when a product is unknown or a tier is given twice.
+func (h *PriceListHandler) addItems(c *gin.Context, list *models.PriceList, items []PriceListItemRequest) bool {
+	var problems []string
+	tiers := map[string]bool{}
+	for i, itemReq := range items {
+		minQuantity := itemReq.MinQuantity
+		if minQuantity < 1 {
+			minQuantity = 1
+		}
+		key := fmt.Sprintf("%s/%d", itemReq.ProductID, minQuantity)
+		if tiers[key] {
+			problems = append(problems, fmt.Sprintf("items[%d]: product %s already has a price from quantity %d", i, itemReq.ProductID, minQuantity))
+			continue
+		}
+		tiers[key] = true
+
+		product, err := h.productRepo.GetProductByID(c.Request.Context(), itemReq.ProductID)
+		if err != nil {
+			log.Printf("PriceList - GetProductByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to validate product", "Database error")
+			return false
+		}
+		if product == nil {
+			problems = append(problems, fmt.Sprintf("items[%d]: product %s not found", i, itemReq.ProductID))
+			continue
+		}
+
+		list.AddItem(product.ID, minQuantity, itemReq.UnitPrice)
+	}
+	if len(problems) > 0 {
+		utils.ValidationErrorResponse(c, "Invalid price list items", problems)
+		return false
+	}
+	return true
+}
+
+// parseValidity parses the first and last day a price list or tax rate applies. It
+// writes the error response and returns false when either is not a YYYY-MM-DD date or
+// the range is empty.
+func parseValidity(c *gin.Context, validFrom, validTo string) (time.Time, *time.Time, bool) {
+	from, err := time.Parse("2006-01-02", strings.TrimSpace(validFrom))
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", "valid_from must be a date (YYYY-MM-DD)")
+		return time.Time{}, nil, false
+	}
+	if strings.TrimSpace(validTo) == "" {
+		return from, nil, true
+	}
+	to, err := time.Parse("2006-01-02", strings.TrimSpace(validTo))
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", "valid_to must be a date (YYYY-MM-DD)")
+		return time.Time{}, nil, false
+	}
+	if to.Before(from) {
+		utils.ValidationErrorResponse(c, "Validation error", "valid_to must not be before valid_from")
+		return time.Time{}, nil, false
+	}
+	return from, &to, true
+}
@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"log"
-	"strings"
 	"time"
 
+	"erp-project/middleware"
 	"erp-project/models"
 	"erp-project/repositories"
 	"erp-project/utils"
@@ -13,29 +14,33 @@ import (
 )
 
 type ProductHandler struct {
-	repo *repositories.ProductRepository
+	repo repositories.ProductStore
 }
 
-func NewProductHandler(repo *repositories.ProductRepository) *ProductHandler {
+func NewProductHandler(repo repositories.ProductStore) *ProductHandler {
 	return &ProductHandler{repo: repo}
 }
 
 type CreateProductRequest struct {
-	Name        string  `json:"name" binding:"required,min=2,max=100"`
-	Description string  `json:"description" binding:"max=500"`
-	SKU         string  `json:"sku" binding:"required,min=3,max=50"`
-	Price       float64 `json:"price" binding:"required,gt=0"`
-	Quantity    int     `json:"quantity" binding:"required,gte=0"`
-	Category    string  `json:"category" binding:"max=50"`
+	Name        string       `json:"name" binding:"required,min=2,max=100"`
+	Description string       `json:"description" binding:"max=500"`
+	SKU         string       `json:"sku" binding:"required,min=3,max=50"`
+	Price       models.Money `json:"price" binding:"required,gt=0"`
+	Currency    string       `json:"currency" binding:"omitempty,len=3"` // Defaults to the base currency
+	Quantity    int          `json:"quantity" binding:"required,gte=0"`
+	Category    string       `json:"category" binding:"max=50"`
+	TaxCategory string       `json:"tax_category" binding:"max=50"` // Defaults to standard
 }
 
 type UpdateProductRequest struct {
-	Name        string  `json:"name" binding:"omitempty,min=2,max=100"`
-	Description string  `json:"description" binding:"omitempty,max=500"`
-	SKU         string  `json:"sku" binding:"omitempty,min=3,max=50"`
-	Price       float64 `json:"price" binding:"omitempty,gt=0"`
-	Quantity    int     `json:"quantity" binding:"omitempty,gte=0"`
-	Category    string  `json:"category" binding:"omitempty,max=50"`
+	Name        string       `json:"name" binding:"omitempty,min=2,max=100"`
+	Description string       `json:"description" binding:"omitempty,max=500"`
+	SKU         string       `json:"sku" binding:"omitempty,min=3,max=50"`
+	Price       models.Money `json:"price" binding:"omitempty,gt=0"`
+	Currency    string       `json:"currency" binding:"omitempty,len=3"`
+	Quantity    *int         `json:"quantity" binding:"omitempty,gte=0"` // Stock is left alone when omitted
+	Category    string       `json:"category" binding:"omitempty,max=50"`
+	TaxCategory string       `json:"tax_category" binding:"omitempty,max=50"`
 }
 
 func (h *ProductHandler) CreateProduct(c *gin.Context) {
@@ -44,6 +49,10 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		utils.ValidationErrorResponse(c, "Validation error", err.Error())
 		return
 	}
+	currency, ok := requestCurrency(c, req.Currency)
+	if !ok {
+		return
+	}
 
 	product := models.NewProduct(
 		req.Name,
@@ -53,17 +62,18 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		req.Price,
 		req.Quantity,
 	)
+	product.Currency = currency.Code
+	product.TaxCategory = models.NormalizeTaxCategory(req.TaxCategory)
 
-	if err := h.repo.CreateProduct(product); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") ||
-			strings.Contains(err.Error(), "already exists") {
+	ctx := auditContext(c, models.AuditEntityProduct, product.ID, models.AuditActionCreate, nil, product)
+	if err := h.repo.CreateProduct(ctx, product); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate SKU", "A product with this SKU already exists")
 			return
 		}
 
 		log.Printf("CreateProduct error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to create product", "Database error occurred")
+		storeErrorResponse(c, err, "Failed to create product", "Database error occurred")
 		return
 	}
 
@@ -79,10 +89,10 @@ func (h *ProductHandler) GetAllProducts(c *gin.Context) {
 	category := c.Query("category")
 
 	// get products with pagination
-	products, total, err := h.repo.GetProductsWithPagination(page, pageSize, search, category)
+	products, total, err := h.repo.GetProductsWithPagination(c.Request.Context(), page, pageSize, search, category)
 	if err != nil {
 		log.Printf("GetAllProducts error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve products", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve products", "Database error")
 		return
 	}
 
@@ -104,10 +114,10 @@ func (h *ProductHandler) GetAllProducts(c *gin.Context) {
 }
 
 func (h *ProductHandler) GetListProducts(c *gin.Context) {
-	products, err := h.repo.GetAll()
+	products, err := h.repo.GetAll(c.Request.Context())
 	if err != nil {
 		log.Printf("GetListProducts error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to fetch products", "Database error")
+		storeErrorResponse(c, err, "Failed to fetch products", "Database error")
 		return
 	}
 
@@ -116,10 +126,10 @@ func (h *ProductHandler) GetListProducts(c *gin.Context) {
 
 func (h *ProductHandler) GetProductByID(c *gin.Context) {
 	id := c.Param("id")
-	product, err := h.repo.GetProductByID(id)
+	product, err := h.repo.GetProductByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("GetProductByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve product", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve product", "Database error")
 		return
 	}
 
@@ -165,10 +175,10 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	}
 
 	// get existing product
-	product, err := h.repo.GetProductByID(id)
+	product, err := h.repo.GetProductByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("UpdateProduct - GetProductByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve product", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve product", "Database error")
 		return
 	}
 
@@ -176,6 +186,7 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		utils.NotFoundResponse(c, "Product not found")
 		return
 	}
+	before := *product
 
 	// update fields
 	updatedFields := []string{}
@@ -191,18 +202,46 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		product.SKU = req.SKU
 		updatedFields = append(updatedFields, "sku")
 	}
-	if req.Price > 0 && req.Price != product.Price {
+	currency, ok := requestCurrency(c, req.Currency)
+	if !ok {
+		return
+	}
+	priceChanged := req.Price > 0 && req.Price != product.Price
+	currencyChanged := req.Currency != "" && currency.Code != product.Currency
+	if priceChanged || currencyChanged {
+		// Changing the selling price, or the currency it is in, needs its own permission
+		// on top of products:update
+		if !middleware.HasPermission(c, models.PermissionProductsUpdatePrice) {
+			utils.ForbiddenResponse(c, "Permission denied", map[string]string{
+				"required_permission": models.PermissionProductsUpdatePrice,
+			})
+			return
+		}
+	}
+	if priceChanged {
 		product.Price = req.Price
 		updatedFields = append(updatedFields, "price")
 	}
-	if req.Quantity >= 0 && req.Quantity != product.Quantity {
-		product.Quantity = req.Quantity
+	if currencyChanged {
+		product.Currency = currency.Code
+		updatedFields = append(updatedFields, "currency")
+	}
+	// The new quantity is applied as a change to the stock as it was read, so orders
+	// placed in the meantime still count
+	quantityChange := 0
+	if req.Quantity != nil && *req.Quantity != product.Quantity {
+		quantityChange = *req.Quantity - product.Quantity
+		product.Quantity = *req.Quantity
 		updatedFields = append(updatedFields, "quantity")
 	}
 	if req.Category != "" && req.Category != product.Category {
 		product.Category = req.Category
 		updatedFields = append(updatedFields, "category")
 	}
+	if req.TaxCategory != "" && models.NormalizeTaxCategory(req.TaxCategory) != product.TaxCategory {
+		product.TaxCategory = models.NormalizeTaxCategory(req.TaxCategory)
+		updatedFields = append(updatedFields, "tax_category")
+	}
 
 	// If no fields were updated
 	if len(updatedFields) == 0 {
@@ -212,9 +251,19 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 
 	product.UpdatedAt = time.Now()
 
-	if err := h.repo.UpdateProduct(product); err != nil {
+	ctx := auditContext(c, models.AuditEntityProduct, id, models.AuditActionUpdate, &before, product)
+	if err := h.repo.UpdateProduct(ctx, product, quantityChange, currentUsername(c)); err != nil {
+		var stockErr *repositories.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			utils.BadRequestResponse(c, "Insufficient stock", map[string]interface{}{
+				"product_id": stockErr.ProductID,
+				"available":  stockErr.Available,
+				"requested":  stockErr.Requested,
+			})
+			return
+		}
 		log.Printf("UpdateProduct error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to update product", "Database error")
+		storeErrorResponse(c, err, "Failed to update product", "Database error")
 		return
 	}
 
@@ -230,10 +279,10 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 	id := c.Param("id")
 
 	// Check if product exists
-	product, err := h.repo.GetProductByID(id)
+	product, err := h.repo.GetProductByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("DeleteProduct - GetProductByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to fetch product", "Database error")
+		storeErrorResponse(c, err, "Failed to fetch product", "Database error")
 		return
 	}
 
@@ -242,9 +291,10 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.DeleteProduct(id); err != nil {
+	ctx := auditContext(c, models.AuditEntityProduct, id, models.AuditActionDelete, product, nil)
+	if err := h.repo.DeleteProduct(ctx, id); err != nil {
 		log.Printf("DeleteProduct error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to delete product", "Database error")
+		storeErrorResponse(c, err, "Failed to delete product", "Database error")
 		return
 	}
 
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PromotionHandler struct {
+	repo         repositories.PromotionStore
+	customerRepo repositories.CustomerStore
+	productRepo  repositories.ProductStore
+}
+
+func NewPromotionHandler(
+	repo repositories.PromotionStore,
+	customerRepo repositories.CustomerStore,
+	productRepo repositories.ProductStore) *PromotionHandler {
+	return &PromotionHandler{
+		repo:         repo,
+		customerRepo: customerRepo,
+		productRepo:  productRepo,
+	}
+}
+
+// CreatePromotionRequest defines a discount rule. Product, category, customer and
+// customer group narrow down who and what it applies to; empty ones match everything.
+type CreatePromotionRequest struct {
+	Name           string       `json:"name" binding:"required,max=255"`
+	Description    string       `json:"description" binding:"max=1000"`
+	Type           string       `json:"type" binding:"required,oneof=percentage fixed buy_x_get_y"`
+	Scope          string       `json:"scope" binding:"omitempty,oneof=line order"` // Defaults to line
+	Value          models.Money `json:"value" binding:"gte=0"`                      // Percent off, or amount off in Currency
+	Currency       string       `json:"currency" binding:"omitempty,len=3"`         // Defaults to the base currency
+	BuyQuantity    int          `json:"buy_quantity" binding:"gte=0"`
+	GetQuantity    int          `json:"get_quantity" binding:"gte=0"`
+	MinOrderAmount models.Money `json:"min_order_amount" binding:"gte=0"` // Order scope only
+	ProductID      string       `json:"product_id"`
+	Category       string       `json:"category" binding:"max=100"`
+	CustomerID     string       `json:"customer_id"`
+	CustomerGroup  string       `json:"customer_group" binding:"max=100"`
+	StartsAt       string       `json:"starts_at"` // Date or RFC3339, defaults to now
+	EndsAt         string       `json:"ends_at"`   // Date or RFC3339, open ended when empty
+	RequiresCoupon bool         `json:"requires_coupon"`
+}
+
+type UpdatePromotionRequest struct {
+	Name        string  `json:"name" binding:"omitempty,max=255"`
+	Description *string `json:"description" binding:"omitempty,max=1000"`
+	StartsAt    string  `json:"starts_at"`
+	EndsAt      *string `json:"ends_at"` // An empty string makes the promotion open ended
+	Active      *bool   `json:"active"`
+}
+
+type CreateCouponRequest struct {
+	Code             string `json:"code" binding:"required,max=50"`
+	UsageLimit       int    `json:"usage_limit" binding:"gte=0"`        // Unlimited when 0
+	PerCustomerLimit int    `json:"per_customer_limit" binding:"gte=0"` // Unlimited when 0
+}
+
+func (h *PromotionHandler) CreatePromotion(c *gin.Context) {
+	var req CreatePromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	if req.Scope == "" {
+		req.Scope = models.PromotionScopeLine
+	}
+	if problems := promotionRuleProblems(&req); len(problems) > 0 {
+		utils.ValidationErrorResponse(c, "Invalid promotion", problems)
+		return
+	}
+	currency, ok := requestCurrency(c, req.Currency)
+	if !ok {
+		return
+	}
+	startsAt, endsAt, ok := promotionWindow(c, req.StartsAt, req.EndsAt)
+	if !ok {
+		return
+	}
+
+	promotion := models.NewPromotion(req.Name, req.Description, req.Type, req.Scope, req.Value, currency.Code, startsAt, endsAt, currentUsername(c))
+	promotion.BuyQuantity = req.BuyQuantity
+	promotion.GetQuantity = req.GetQuantity
+	promotion.MinOrderAmount = req.MinOrderAmount
+	promotion.Category = strings.TrimSpace(req.Category)
+	promotion.CustomerGroup = strings.TrimSpace(req.CustomerGroup)
+	promotion.RequiresCoupon = req.RequiresCoupon
+
+	if req.ProductID != "" {
+		product, err := h.productRepo.GetProductByID(c.Request.Context(), req.ProductID)
+		if err != nil {
+			log.Printf("CreatePromotion - GetProductByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to validate product", "Database error")
+			return
+		}
+		if product == nil {
+			utils.BadRequestResponse(c, "Invalid product ID", "Product not found")
+			return
+		}
+		promotion.ProductID = &product.ID
+	}
+	if req.CustomerID != "" {
+		customer, err := h.customerRepo.GetCustomerByID(c.Request.Context(), req.CustomerID)
+		if err != nil {
+			log.Printf("CreatePromotion - GetCustomerByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to validate customer", "Database error")
+			return
+		}
+		if customer == nil {
+			utils.BadRequestResponse(c, "Invalid customer ID", "Customer not found")
+			return
+		}
+		promotion.CustomerID = &customer.ID
+	}
+
+	ctx := auditContext(c, models.AuditEntityPromotion, promotion.ID, models.AuditActionCreate, nil, promotion)
+	if err := h.repo.CreatePromotion(ctx, promotion); err != nil {
+		log.Printf("CreatePromotion error: %v", err)
+		storeErrorResponse(c, err, "Failed to create promotion", "Database error")
+		return
+	}
+
+	utils.CreatedResponse(c, "Promotion created successfully", promotion)
+}
+
+// GetPromotions lists promotions, optionally only those running at a time
+// (?active_at=YYYY-MM-DD or RFC3339) or only those that do or do not need a coupon
+func (h *PromotionHandler) GetPromotions(c *gin.Context) {
+	var filter repositories.PromotionFilter
+	if value := c.Query("active_at"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "active_at must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.ActiveAt = &t
+	}
+	if value := c.Query("requires_coupon"); value != "" {
+		requiresCoupon := value == "true"
+		if !requiresCoupon && value != "false" {
+			utils.ValidationErrorResponse(c, "Validation error", "requires_coupon must be true or false")
+			return
+		}
+		filter.RequiresCoupon = &requiresCoupon
+	}
+
+	promotions, err := h.repo.GetPromotions(c.Request.Context(), filter)
+	if err != nil {
+		log.Printf("GetPromotions error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve promotions", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Promotions retrieved successfully", promotions)
+}
+
+func (h *PromotionHandler) GetPromotionByID(c *gin.Context) {
+	promotion, err := h.repo.GetPromotionByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetPromotionByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve promotion", "Database error")
+		return
+	}
+	if promotion == nil {
+		utils.NotFoundResponse(c, "Promotion not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Promotion retrieved successfully", promotion)
+}
+
+// UpdatePromotion renames a promotion, changes when it runs or switches it on or off.
+// The discount itself is fixed once created so orders that used it stay explainable;
+// end the promotion and create another to change it.
+func (h *PromotionHandler) UpdatePromotion(c *gin.Context) {
+	id := c.Param("id")
+
+	var req UpdatePromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	promotion, err := h.repo.GetPromotionByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("UpdatePromotion - GetPromotionByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve promotion", "Database error")
+		return
+	}
+	if promotion == nil {
+		utils.NotFoundResponse(c, "Promotion not found")
+		return
+	}
+	before := *promotion
+
+	updatedFields := []string{}
+	if req.Name != "" && req.Name != promotion.Name {
+		promotion.Name = req.Name
+		updatedFields = append(updatedFields, "name")
+	}
+	if req.Description != nil && *req.Description != promotion.Description {
+		promotion.Description = *req.Description
+		updatedFields = append(updatedFields, "description")
+	}
+
+	startsAt := promotion.StartsAt.Format(time.RFC3339Nano)
+	if req.StartsAt != "" {
+		startsAt = req.StartsAt
+	}
+	endsAt := ""
+	if promotion.EndsAt != nil {
+		endsAt = promotion.EndsAt.Format(time.RFC3339Nano)
+	}
+	if req.EndsAt != nil {
+		endsAt = *req.EndsAt
+	}
+	from, to, ok := promotionWindow(c, startsAt, endsAt)
+	if !ok {
+		return
+	}
+	if !from.Equal(promotion.StartsAt) {
+		promotion.StartsAt = from
+		updatedFields = append(updatedFields, "starts_at")
+	}
+	if (to == nil) != (promotion.EndsAt == nil) || (to != nil && !to.Equal(*promotion.EndsAt)) {
+		promotion.EndsAt = to
+		updatedFields = append(updatedFields, "ends_at")
+	}
+
+	if req.Active != nil && *req.Active != promotion.Active {
+		promotion.Active = *req.Active
+		updatedFields = append(updatedFields, "active")
+	}
+
+	if len(updatedFields) == 0 {
+		utils.SuccessResponse(c, "No changes detected", promotion)
+		return
+	}
+
+	ctx := auditContext(c, models.AuditEntityPromotion, id, models.AuditActionUpdate, &before, promotion)
+	if err := h.repo.UpdatePromotion(ctx, promotion); err != nil {
+		log.Printf("UpdatePromotion error: %v", err)
+		storeErrorResponse(c, err, "Failed to update promotion", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Promotion updated successfully", map[string]interface{}{
+		"promotion":      promotion,
+		"updated_fields": updatedFields,
+	})
+}
+
+// CreateCoupon issues a code for a promotion. Codes are case-insensitive and unique
+// across all promotions.
+func (h *PromotionHandler) CreateCoupon(c *gin.Context) {
+	promotionID := c.Param("id")
+
+	var req CreateCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+	code := models.NormalizeCouponCode(req.Code)
+	if code == "" || strings.ContainsAny(code, " \t\r\n") {
+		utils.ValidationErrorResponse(c, "Validation error", "code must be a single word")
+		return
+	}
+	if req.UsageLimit > 0 && req.PerCustomerLimit > req.UsageLimit {
+		utils.ValidationErrorResponse(c, "Validation error", "per_customer_limit must not exceed usage_limit")
+		return
+	}
+
+	promotion, err := h.repo.GetPromotionByID(c.Request.Context(), promotionID)
+	if err != nil {
+		log.Printf("CreateCoupon - GetPromotionByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve promotion", "Database error")
+		return
+	}
+	if promotion == nil {
+		utils.NotFoundResponse(c, "Promotion not found")
+		return
+	}
+
+	coupon := models.NewCoupon(code, promotion.ID, req.UsageLimit, req.PerCustomerLimit, currentUsername(c))
+	ctx := auditContext(c, models.AuditEntityCoupon, coupon.ID, models.AuditActionCreate, nil, coupon)
+	if err := h.repo.CreateCoupon(ctx, coupon); err != nil {
+		if isUniqueViolation(err) {
+			utils.DuplicateErrorResponse(c, "Duplicate coupon code", "A coupon with this code already exists")
+			return
+		}
+
+		log.Printf("CreateCoupon error: %v", err)
+		storeErrorResponse(c, err, "Failed to create coupon", "Database error")
+		return
+	}
+	coupon.PromotionName = promotion.Name
+
+	utils.CreatedResponse(c, "Coupon created successfully", coupon)
+}
+
+func (h *PromotionHandler) GetCoupons(c *gin.Context) {
+	promotionID := c.Param("id")
+
+	promotion, err := h.repo.GetPromotionByID(c.Request.Context(), promotionID)
+	if err != nil {
+		log.Printf("GetCoupons - GetPromotionByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve promotion", "Database error")
+		return
+	}
+	if promotion == nil {
+		utils.NotFoundResponse(c, "Promotion not found")
+		return
+	}
+
+	coupons, err := h.repo.GetCoupons(c.Request.Context(), promotionID)
+	if err != nil {
+		log.Printf("GetCoupons error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve coupons", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Coupons retrieved successfully", coupons)
+}
+
+// promotionRuleProblems checks that a promotion's type, scope and amounts fit together
+func promotionRuleProblems(req *CreatePromotionRequest) []string {
+	var problems []string
+	switch req.Type {
+	case models.PromotionTypePercentage:
+		if req.Value <= 0 || req.Value > models.MustParseMoney("100") {
+			problems = append(problems, "value must be a percentage above 0 and at most 100")
+		}
+	case models.PromotionTypeFixed:
+		if req.Value <= 0 {
+			problems = append(problems, "value must be an amount above 0")
+		}
+	case models.PromotionTypeBuyXGetY:
+		if req.Scope != models.PromotionScopeLine {
+			problems = append(problems, "buy_x_get_y promotions apply to lines, so scope must be line")
+		}
+		if req.BuyQuantity < 1 || req.GetQuantity < 1 {
+			problems = append(problems, "buy_quantity and get_quantity must both be at least 1")
+		}
+	}
+	if req.Type != models.PromotionTypeBuyXGetY && (req.BuyQuantity != 0 || req.GetQuantity != 0) {
+		problems = append(problems, "buy_quantity and get_quantity only apply to buy_x_get_y promotions")
+	}
+	if req.Scope != models.PromotionScopeOrder && req.MinOrderAmount != 0 {
+		problems = append(problems, "min_order_amount only applies to order scope promotions")
+	}
+	return problems
+}
+
+// promotionWindow parses when a promotion starts and ends. It writes the error response
+// and returns false when either is malformed or the window is empty.
+func promotionWindow(c *gin.Context, startsAt, endsAt string) (time.Time, *time.Time, bool) {
+	from := time.Now()
+	if value := strings.TrimSpace(startsAt); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "starts_at must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return time.Time{}, nil, false
+		}
+		from = t
+	}
+	value := strings.TrimSpace(endsAt)
+	if value == "" {
+		return from, nil, true
+	}
+	to, err := parseTimeParam(value, true)
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", "ends_at must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+		return time.Time{}, nil, false
+	}
+	if to.Before(from) {
+		utils.ValidationErrorResponse(c, "Validation error", "ends_at must not be before starts_at")
+		return time.Time{}, nil, false
+	}
+	return from, &to, true
+}
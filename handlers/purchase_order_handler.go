@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PurchaseOrderHandler struct {
+	purchaseOrderRepo repositories.PurchaseOrderStore
+	supplierRepo      repositories.SupplierStore
+	productRepo       repositories.ProductStore
+	warehouseRepo     repositories.WarehouseStore
+	exchangeRateRepo  repositories.ExchangeRateStore
+}
+
+func NewPurchaseOrderHandler(
+	purchaseOrderRepo repositories.PurchaseOrderStore,
+	supplierRepo repositories.SupplierStore,
+	productRepo repositories.ProductStore,
+	warehouseRepo repositories.WarehouseStore,
+	exchangeRateRepo repositories.ExchangeRateStore) *PurchaseOrderHandler {
+	return &PurchaseOrderHandler{
+		purchaseOrderRepo: purchaseOrderRepo,
+		supplierRepo:      supplierRepo,
+		productRepo:       productRepo,
+		warehouseRepo:     warehouseRepo,
+		exchangeRateRepo:  exchangeRateRepo,
+	}
+}
+
+// CreatePurchaseOrderRequest raises a purchase order with a supplier. Without a
+// currency the order is in the currency the supplier quotes the first linked product
+// in, or the base currency when none is linked.
+type CreatePurchaseOrderRequest struct {
+	SupplierID  string                           `json:"supplier_id" binding:"required"`
+	Currency    string                           `json:"currency" binding:"omitempty,len=3"`
+	WarehouseID string                           `json:"warehouse_id"`
+	Notes       string                           `json:"notes" binding:"max=500"`
+	Lines       []CreatePurchaseOrderLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// CreatePurchaseOrderLineRequest orders one product. Unit cost, supplier SKU and lead
+// time default to the product's supplier link when omitted; a unit cost given here is
+// in the order's currency, one taken from the link is converted into it.
+type CreatePurchaseOrderLineRequest struct {
+	ProductID    string        `json:"product_id" binding:"required"`
+	Quantity     int           `json:"quantity" binding:"required,gt=0"`
+	UnitCost     *models.Money `json:"unit_cost" binding:"omitempty,gte=0"`
+	SupplierSKU  string        `json:"supplier_sku" binding:"max=100"`
+	LeadTimeDays *int          `json:"lead_time_days" binding:"omitempty,gte=0"`
+}
+
+type ReceivePurchaseOrderRequest struct {
+	WarehouseID      string                            `json:"warehouse_id"` // Defaults to the order's ship-to warehouse
+	AllowOverReceipt bool                              `json:"allow_over_receipt"`
+	Lines            []ReceivePurchaseOrderLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// ReceivePurchaseOrderLineRequest books goods against one purchase order line. Without
+// a location the first available location with room for the quantity is used.
+type ReceivePurchaseOrderLineRequest struct {
+	LineID     string `json:"line_id" binding:"required"`
+	Quantity   int    `json:"quantity" binding:"required,gt=0"`
+	LocationID string `json:"location_id"`
+	Notes      string `json:"notes" binding:"max=500"`
+}
+
+func (h *PurchaseOrderHandler) CreatePurchaseOrder(c *gin.Context) {
+	var req CreatePurchaseOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+	if _, ok := requestCurrency(c, req.Currency); !ok {
+		return
+	}
+
+	supplier, err := h.supplierRepo.GetSupplierByID(c.Request.Context(), req.SupplierID)
+	if err != nil {
+		log.Printf("CreatePurchaseOrder - GetSupplierByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to validate supplier", "Database error")
+		return
+	}
+	if supplier == nil {
+		utils.BadRequestResponse(c, "Invalid supplier ID", "Supplier not found")
+		return
+	}
+	if supplier.Status == "inactive" {
+		utils.BadRequestResponse(c, "Invalid supplier", "Supplier is inactive")
+		return
+	}
+
+	var warehouseID *string
+	if req.WarehouseID != "" {
+		warehouse, err := h.warehouseRepo.GetWarehouseByID(c.Request.Context(), req.WarehouseID)
+		if err != nil {
+			log.Printf("CreatePurchaseOrder - GetWarehouseByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to validate warehouse", "Database error")
+			return
+		}
+		if warehouse == nil {
+			utils.BadRequestResponse(c, "Invalid warehouse ID", "Warehouse not found")
+			return
+		}
+		warehouseID = &req.WarehouseID
+	}
+
+	po := models.NewPurchaseOrder(req.SupplierID, warehouseID, req.Notes, currentUsername(c))
+
+	currencyCode := req.Currency
+	productSuppliers := make([]*models.ProductSupplier, len(req.Lines))
+	for i, lineReq := range req.Lines {
+		if product, err := h.productRepo.GetProductByID(c.Request.Context(), lineReq.ProductID); err != nil || product == nil {
+			utils.BadRequestResponse(c, "Invalid product ID", map[string]interface{}{
+				"product_id": lineReq.ProductID,
+				"message":    "Product not found",
+			})
+			return
+		}
+
+		productSupplier, err := h.supplierRepo.GetProductSupplier(c.Request.Context(), lineReq.ProductID, req.SupplierID)
+		if err != nil {
+			log.Printf("CreatePurchaseOrder - GetProductSupplier error: %v", err)
+			storeErrorResponse(c, err, "Failed to load supplier terms", "Database error")
+			return
+		}
+
+		// Products the supplier is not linked to can still be ordered, but the buyer has to
+		// name the price because there is nothing to default it from
+		if productSupplier == nil && lineReq.UnitCost == nil {
+			utils.BadRequestResponse(c, "Missing unit cost", map[string]interface{}{
+				"product_id": lineReq.ProductID,
+				"message":    "Product is not linked to this supplier; unit_cost is required",
+			})
+			return
+		}
+		productSuppliers[i] = productSupplier
+		if currencyCode == "" && productSupplier != nil {
+			currencyCode = productSupplier.Currency
+		}
+	}
+
+	currency := models.CurrencyOf(currencyCode)
+	po.Currency = currency.Code
+	rates := newRateBook(h.exchangeRateRepo, time.Now())
+
+	for i, lineReq := range req.Lines {
+		productSupplier := productSuppliers[i]
+		supplierSKU := lineReq.SupplierSKU
+		var unitCost models.Money
+		var leadTimeDays int
+		if productSupplier != nil {
+			unitCost, err = rates.convert(c.Request.Context(), productSupplier.CostPrice, models.CurrencyOf(productSupplier.Currency).Code, currency.Code)
+			if err != nil {
+				rateErrorResponse(c, err, "Failed to load exchange rate")
+				return
+			}
+			leadTimeDays = productSupplier.LeadTimeDays
+			if supplierSKU == "" {
+				supplierSKU = productSupplier.SupplierSKU
+			}
+		}
+		if lineReq.UnitCost != nil {
+			unitCost = *lineReq.UnitCost
+		}
+		if lineReq.LeadTimeDays != nil {
+			leadTimeDays = *lineReq.LeadTimeDays
+		}
+
+		line := models.NewPurchaseOrderLine(po.ID, lineReq.ProductID, supplierSKU, lineReq.Quantity, unitCost, currency, leadTimeDays)
+		po.Lines = append(po.Lines, *line)
+	}
+
+	// Provisional dates; they are recalculated when the order is sent to the supplier
+	po.ScheduleFrom(po.OrderDate)
+
+	if err := h.purchaseOrderRepo.CreatePurchaseOrder(c.Request.Context(), po); err != nil {
+		log.Printf("CreatePurchaseOrder error: %v", err)
+		storeErrorResponse(c, err, "Failed to create purchase order", "Database error")
+		return
+	}
+
+	h.respondWithPurchaseOrder(c, po.ID, "Purchase order created successfully", true)
+}
+
+func (h *PurchaseOrderHandler) GetPurchaseOrders(c *gin.Context) {
+	purchaseOrders, err := h.purchaseOrderRepo.GetPurchaseOrders(c.Request.Context(), c.Query("status"), c.Query("supplier_id"))
+	if err != nil {
+		log.Printf("GetPurchaseOrders error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve purchase orders", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase orders retrieved successfully", purchaseOrders)
+}
+
+func (h *PurchaseOrderHandler) GetPurchaseOrderByID(c *gin.Context) {
+	h.respondWithPurchaseOrder(c, c.Param("id"), "Purchase order retrieved successfully", false)
+}
+
+func (h *PurchaseOrderHandler) ApprovePurchaseOrder(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.purchaseOrderRepo.ApprovePurchaseOrder(c.Request.Context(), id, currentUsername(c)); err != nil {
+		h.handleTransitionError(c, err, "approve", "Only draft purchase orders can be approved")
+		return
+	}
+
+	h.respondWithPurchaseOrder(c, id, "Purchase order approved successfully", false)
+}
+
+func (h *PurchaseOrderHandler) SendPurchaseOrder(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.purchaseOrderRepo.SendPurchaseOrder(c.Request.Context(), id); err != nil {
+		h.handleTransitionError(c, err, "send", "Only approved purchase orders can be sent")
+		return
+	}
+
+	h.respondWithPurchaseOrder(c, id, "Purchase order sent successfully", false)
+}
+
+func (h *PurchaseOrderHandler) CancelPurchaseOrder(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.purchaseOrderRepo.CancelPurchaseOrder(c.Request.Context(), id); err != nil {
+		h.handleTransitionError(c, err, "cancel", "Only draft, approved or sent purchase orders can be cancelled")
+		return
+	}
+
+	h.respondWithPurchaseOrder(c, id, "Purchase order cancelled successfully", false)
+}
+
+func (h *PurchaseOrderHandler) ReceivePurchaseOrder(c *gin.Context) {
+	id := c.Param("id")
+
+	var req ReceivePurchaseOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	po, err := h.purchaseOrderRepo.GetPurchaseOrderByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("ReceivePurchaseOrder - GetPurchaseOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve purchase order", "Database error")
+		return
+	}
+	if po == nil {
+		utils.NotFoundResponse(c, "Purchase order not found")
+		return
+	}
+
+	warehouseID := req.WarehouseID
+	if warehouseID == "" && po.WarehouseID != nil {
+		warehouseID = *po.WarehouseID
+	}
+	if warehouseID == "" {
+		utils.ValidationErrorResponse(c, "Validation error", "warehouse_id is required when the purchase order has no ship-to warehouse")
+		return
+	}
+	if warehouse, err := h.warehouseRepo.GetWarehouseByID(c.Request.Context(), warehouseID); err != nil || warehouse == nil {
+		utils.BadRequestResponse(c, "Invalid warehouse ID", "Warehouse not found")
+		return
+	}
+
+	// Putaway suggestions account for what earlier lines of this receipt already placed
+	var available []models.WarehouseLocation
+	placed := map[string]int{}
+	for _, lineReq := range req.Lines {
+		if lineReq.LocationID == "" {
+			available, err = h.warehouseRepo.GetAvailableLocations(c.Request.Context(), warehouseID)
+			if err != nil {
+				log.Printf("ReceivePurchaseOrder - GetAvailableLocations error: %v", err)
+				storeErrorResponse(c, err, "Failed to suggest putaway locations", "Database error")
+				return
+			}
+			break
+		}
+	}
+
+	receipts := make([]models.PurchaseOrderReceipt, 0, len(req.Lines))
+	for _, lineReq := range req.Lines {
+		receipt := models.PurchaseOrderReceipt{
+			PurchaseOrderLineID: lineReq.LineID,
+			Quantity:            lineReq.Quantity,
+			Notes:               lineReq.Notes,
+			ReceivedBy:          currentUsername(c),
+		}
+
+		locationID := lineReq.LocationID
+		if locationID == "" {
+			locationID = suggestPutawayLocation(available, placed, lineReq.Quantity)
+		}
+		if locationID != "" {
+			placed[locationID] += lineReq.Quantity
+			receipt.LocationID = &locationID
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	recorded, err := h.purchaseOrderRepo.ReceivePurchaseOrder(c.Request.Context(), id, warehouseID, receipts, req.AllowOverReceipt)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrPurchaseOrderNotFound):
+			utils.NotFoundResponse(c, "Purchase order not found")
+		case errors.Is(err, repositories.ErrInvalidPurchaseOrderStatus):
+			utils.BadRequestResponse(c, "Invalid purchase order status", "Only sent or partially received purchase orders can be received")
+		case errors.Is(err, repositories.ErrInvalidPurchaseOrderReceipt),
+			errors.Is(err, repositories.ErrLocationNotInWarehouse),
+			errors.Is(err, repositories.ErrLocationCapacityExceeded):
+			utils.BadRequestResponse(c, "Invalid purchase order receipt", err.Error())
+		default:
+			log.Printf("ReceivePurchaseOrder error: %v", err)
+			storeErrorResponse(c, err, "Failed to receive purchase order", "Database error")
+		}
+		return
+	}
+
+	po, err = h.purchaseOrderRepo.GetPurchaseOrderByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("ReceivePurchaseOrder - GetPurchaseOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve purchase order", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"purchase_order": po,
+		"receipts":       recorded,
+	}
+
+	utils.SuccessResponse(c, "Purchase order receipt recorded successfully", responseData)
+}
+
+func (h *PurchaseOrderHandler) GetPurchaseOrderReceipts(c *gin.Context) {
+	id := c.Param("id")
+
+	receipts, err := h.purchaseOrderRepo.GetPurchaseOrderReceipts(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("GetPurchaseOrderReceipts error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve purchase order receipts", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Purchase order receipts retrieved successfully", receipts)
+}
+
+func (h *PurchaseOrderHandler) ClosePurchaseOrder(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.purchaseOrderRepo.ClosePurchaseOrder(c.Request.Context(), id); err != nil {
+		h.handleTransitionError(c, err, "close", "Only partially received purchase orders can be closed")
+		return
+	}
+
+	h.respondWithPurchaseOrder(c, id, "Purchase order closed successfully", false)
+}
+
+// suggestPutawayLocation returns the first available location with room for quantity
+// on top of what is already placed there, or "" to receive without a location
+func suggestPutawayLocation(available []models.WarehouseLocation, placed map[string]int, quantity int) string {
+	for _, location := range available {
+		if location.MaxCapacity == 0 || location.CurrentQuantity+placed[location.ID]+quantity <= location.MaxCapacity {
+			return location.ID
+		}
+	}
+	return ""
+}
+
+// handleTransitionError maps status change errors from the repository to responses
+func (h *PurchaseOrderHandler) handleTransitionError(c *gin.Context, err error, action, invalidMessage string) {
+	switch {
+	case errors.Is(err, repositories.ErrPurchaseOrderNotFound):
+		utils.NotFoundResponse(c, "Purchase order not found")
+	case errors.Is(err, repositories.ErrInvalidPurchaseOrderStatus):
+		utils.BadRequestResponse(c, "Invalid purchase order status", invalidMessage)
+	default:
+		log.Printf("PurchaseOrder %s error: %v", action, err)
+		storeErrorResponse(c, err, "Failed to "+action+" purchase order", "Database error")
+	}
+}
+
+// respondWithPurchaseOrder loads a purchase order with its lines and writes it out
+func (h *PurchaseOrderHandler) respondWithPurchaseOrder(c *gin.Context, id, message string, created bool) {
+	po, err := h.purchaseOrderRepo.GetPurchaseOrderByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("GetPurchaseOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve purchase order", "Database error")
+		return
+	}
+	if po == nil {
+		utils.NotFoundResponse(c, "Purchase order not found")
+		return
+	}
+
+	if created {
+		utils.CreatedResponse(c, message, po)
+		return
+	}
+	utils.SuccessResponse(c, message, po)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReplenishmentHandler struct {
+	replenishmentRepo repositories.ReplenishmentStore
+	purchaseOrderRepo repositories.PurchaseOrderStore
+}
+
+func NewReplenishmentHandler(
+	replenishmentRepo repositories.ReplenishmentStore,
+	purchaseOrderRepo repositories.PurchaseOrderStore) *ReplenishmentHandler {
+	return &ReplenishmentHandler{
+		replenishmentRepo: replenishmentRepo,
+		purchaseOrderRepo: purchaseOrderRepo,
+	}
+}
+
+// CreateReplenishmentOrdersRequest turns the current suggestions into draft purchase
+// orders, one per supplier, warehouse and currency. The filters work like the query parameters
+// of GetSuggestions.
+type CreateReplenishmentOrdersRequest struct {
+	WarehouseID string   `json:"warehouse_id"`
+	SupplierID  string   `json:"supplier_id"`
+	ProductIDs  []string `json:"product_ids"`
+	Notes       string   `json:"notes" binding:"max=500"`
+}
+
+func (h *ReplenishmentHandler) GetSuggestions(c *gin.Context) {
+	groupBy := c.Query("group_by")
+	if groupBy != "" && groupBy != "supplier" {
+		utils.ValidationErrorResponse(c, "Validation error", "group_by must be supplier")
+		return
+	}
+
+	filter := repositories.ReplenishmentFilter{
+		WarehouseID: c.Query("warehouse_id"),
+		SupplierID:  c.Query("supplier_id"),
+	}
+	if productIDs := c.Query("product_ids"); productIDs != "" {
+		filter.ProductIDs = strings.Split(productIDs, ",")
+	}
+
+	suggestions, err := h.replenishmentRepo.GetSuggestions(c.Request.Context(), filter)
+	if err != nil {
+		log.Printf("GetSuggestions error: %v", err)
+		storeErrorResponse(c, err, "Failed to calculate replenishment suggestions", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"count": len(suggestions),
+	}
+	if groupBy == "supplier" {
+		responseData["groups"] = models.GroupReplenishmentSuggestions(suggestions)
+	} else {
+		responseData["suggestions"] = suggestions
+	}
+
+	utils.SuccessResponse(c, "Replenishment suggestions retrieved successfully", responseData)
+}
+
+// CreatePurchaseOrders converts suggestions into draft purchase orders. Products with
+// no supplier to order from are returned as unassigned instead.
+func (h *ReplenishmentHandler) CreatePurchaseOrders(c *gin.Context) {
+	var req CreateReplenishmentOrdersRequest
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	suggestions, err := h.replenishmentRepo.GetSuggestions(c.Request.Context(), repositories.ReplenishmentFilter{
+		WarehouseID: req.WarehouseID,
+		SupplierID:  req.SupplierID,
+		ProductIDs:  req.ProductIDs,
+	})
+	if err != nil {
+		log.Printf("CreatePurchaseOrders - GetSuggestions error: %v", err)
+		storeErrorResponse(c, err, "Failed to calculate replenishment suggestions", "Database error")
+		return
+	}
+
+	notes := req.Notes
+	if notes == "" {
+		notes = "Created from replenishment suggestions"
+	}
+
+	var purchaseOrders []*models.PurchaseOrder
+	unassigned := []models.ReplenishmentSuggestion{}
+	for _, group := range models.GroupReplenishmentSuggestions(suggestions) {
+		if group.SupplierID == nil {
+			unassigned = append(unassigned, group.Suggestions...)
+			continue
+		}
+
+		warehouseID := group.WarehouseID
+		po := models.NewPurchaseOrder(*group.SupplierID, &warehouseID, notes, currentUsername(c))
+		currency := models.CurrencyOf(group.Currency)
+		po.Currency = currency.Code
+		for _, suggestion := range group.Suggestions {
+			line := models.NewPurchaseOrderLine(po.ID, suggestion.ProductID, suggestion.SupplierSKU,
+				suggestion.SuggestedQuantity, suggestion.UnitCost, currency, suggestion.LeadTimeDays)
+			po.Lines = append(po.Lines, *line)
+		}
+		// Provisional dates; they are recalculated when the order is sent to the supplier
+		po.ScheduleFrom(po.OrderDate)
+		purchaseOrders = append(purchaseOrders, po)
+	}
+
+	if len(purchaseOrders) == 0 {
+		utils.BadRequestResponse(c, "Nothing to order", map[string]interface{}{
+			"message":    "No products below their minimum have a supplier to order from",
+			"unassigned": unassigned,
+		})
+		return
+	}
+
+	if err := h.purchaseOrderRepo.CreatePurchaseOrders(c.Request.Context(), purchaseOrders); err != nil {
+		log.Printf("CreatePurchaseOrders error: %v", err)
+		storeErrorResponse(c, err, "Failed to create purchase orders", "Database error")
+		return
+	}
+
+	created := make([]*models.PurchaseOrder, 0, len(purchaseOrders))
+	for _, po := range purchaseOrders {
+		saved, err := h.purchaseOrderRepo.GetPurchaseOrderByID(c.Request.Context(), po.ID)
+		if err != nil || saved == nil {
+			log.Printf("CreatePurchaseOrders - GetPurchaseOrderByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to retrieve purchase orders", "Database error")
+			return
+		}
+		created = append(created, saved)
+	}
+
+	responseData := map[string]interface{}{
+		"purchase_orders": created,
+		"unassigned":      unassigned,
+	}
+
+	utils.CreatedResponse(c, "Purchase orders created from replenishment suggestions", responseData)
+}
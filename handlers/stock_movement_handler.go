@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StockMovementHandler struct {
+	repo repositories.StockMovementStore
+}
+
+func NewStockMovementHandler(repo repositories.StockMovementStore) *StockMovementHandler {
+	return &StockMovementHandler{repo: repo}
+}
+
+func (h *StockMovementHandler) GetMovements(c *gin.Context) {
+	page, pageSize := utils.GetPaginationParams(c)
+
+	filter := repositories.StockMovementFilter{
+		ProductID:     c.Query("product_id"),
+		WarehouseID:   c.Query("warehouse_id"),
+		LocationID:    c.Query("location_id"),
+		MovementType:  c.Query("movement_type"),
+		ReferenceType: c.Query("reference_type"),
+		ReferenceID:   c.Query("reference_id"),
+	}
+
+	if filter.MovementType != "" && !models.IsValidMovementType(filter.MovementType) {
+		utils.ValidationErrorResponse(c, "Validation error", "Unknown movement_type: "+filter.MovementType)
+		return
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseTimeParam(to, true)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.To = &t
+	}
+
+	movements, total, err := h.repo.GetMovements(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		log.Printf("GetMovements error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve stock movements", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"movements": movements,
+		"pagination": utils.Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+			Pages:    utils.CalculateTotalPages(total, pageSize),
+		},
+	}
+
+	utils.SuccessResponse(c, "Stock movements retrieved successfully", responseData)
+}
+
+// GetStockAt rebuilds a product's stock at a point in time from the ledger
+func (h *StockMovementHandler) GetStockAt(c *gin.Context) {
+	productID := c.Query("product_id")
+	if productID == "" {
+		utils.ValidationErrorResponse(c, "Validation error", "product_id is required")
+		return
+	}
+	warehouseID := c.Query("warehouse_id")
+
+	at := time.Now()
+	if value := c.Query("at"); value != "" {
+		t, err := parseTimeParam(value, true)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "at must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		at = t
+	}
+
+	quantity, err := h.repo.GetStockAt(c.Request.Context(), productID, warehouseID, at)
+	if err != nil {
+		log.Printf("GetStockAt error: %v", err)
+		storeErrorResponse(c, err, "Failed to calculate stock", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"product_id": productID,
+		"at":         at,
+		"quantity":   quantity,
+	}
+	if warehouseID != "" {
+		responseData["warehouse_id"] = warehouseID
+	}
+
+	utils.SuccessResponse(c, "Stock calculated successfully", responseData)
+}
+
+// parseTimeParam accepts an RFC3339 timestamp or a plain date. A plain date used as
+// an upper bound covers the whole day.
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SupplierBillHandler struct {
+	repo              repositories.SupplierBillStore
+	supplierRepo      repositories.SupplierStore
+	purchaseOrderRepo repositories.PurchaseOrderStore
+	exchangeRateRepo  repositories.ExchangeRateStore
+}
+
+func NewSupplierBillHandler(repo repositories.SupplierBillStore, supplierRepo repositories.SupplierStore, purchaseOrderRepo repositories.PurchaseOrderStore, exchangeRateRepo repositories.ExchangeRateStore) *SupplierBillHandler {
+	return &SupplierBillHandler{repo: repo, supplierRepo: supplierRepo, purchaseOrderRepo: purchaseOrderRepo, exchangeRateRepo: exchangeRateRepo}
+}
+
+// CreateSupplierBillRequest enters a supplier's bill for received units of a purchase
+// order. Without lines every received unit not yet billed is billed at the purchase
+// order cost.
+type CreateSupplierBillRequest struct {
+	PurchaseOrderID string                    `json:"purchase_order_id" binding:"required"`
+	BillNumber      string                    `json:"bill_number" binding:"required,max=100"` // The supplier's invoice number
+	BillDate        string                    `json:"bill_date"`                              // YYYY-MM-DD, defaults to today
+	PaymentTerms    string                    `json:"payment_terms" binding:"max=50"`         // Defaults to the supplier's, e.g. "2/10 net 30"
+	TaxAmount       models.Money              `json:"tax_amount" binding:"gte=0"`
+	Lines           []SupplierBillLineRequest `json:"lines" binding:"omitempty,dive"`
+	Notes           string                    `json:"notes" binding:"max=1000"`
+}
+
+type SupplierBillLineRequest struct {
+	PurchaseOrderLineID string       `json:"purchase_order_line_id" binding:"required"`
+	Quantity            int          `json:"quantity" binding:"required,gt=0"`
+	UnitCost            models.Money `json:"unit_cost" binding:"gte=0"` // Defaults to the purchase order's
+}
+
+// ApproveSupplierBillRequest approves a bill for payment. The body is optional, but a
+// bill with variances needs accept_variances.
+type ApproveSupplierBillRequest struct {
+	AcceptVariances bool `json:"accept_variances"`
+}
+
+type VoidSupplierBillRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// PaySupplierBillRequest records a payment to the supplier against a bill
+type PaySupplierBillRequest struct {
+	Amount      models.Money `json:"amount" binding:"gt=0"`
+	Method      string       `json:"method" binding:"required,oneof=cash check bank_transfer card other"`
+	Reference   string       `json:"reference" binding:"max=255"`
+	PaymentDate string       `json:"payment_date"` // YYYY-MM-DD, defaults to today
+}
+
+func (h *SupplierBillHandler) CreateSupplierBill(c *gin.Context) {
+	var req CreateSupplierBillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+	billDate, ok := pastDateParam(c, req.BillDate, "bill_date")
+	if !ok {
+		return
+	}
+
+	po, err := h.purchaseOrderRepo.GetPurchaseOrderByID(c.Request.Context(), req.PurchaseOrderID)
+	if err != nil {
+		log.Printf("CreateSupplierBill - GetPurchaseOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve purchase order", "Database error")
+		return
+	}
+	if po == nil {
+		utils.BadRequestResponse(c, "Invalid purchase order ID", "Purchase order not found")
+		return
+	}
+
+	// The supplier's payment terms apply unless the bill states others
+	terms := models.DefaultSupplierPaymentTerms
+	if req.PaymentTerms != "" {
+		terms, err = models.ParsePaymentTerms(req.PaymentTerms)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", err.Error())
+			return
+		}
+	} else {
+		supplier, err := h.supplierRepo.GetSupplierByID(c.Request.Context(), po.SupplierID)
+		if err != nil {
+			log.Printf("CreateSupplierBill - GetSupplierByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to retrieve supplier", "Database error")
+			return
+		}
+		if supplier != nil {
+			terms = supplier.PaymentTerms
+		}
+	}
+
+	// The bill keeps the rate on its date, for reporting payables in the base currency
+	rate, err := newRateBook(h.exchangeRateRepo, billDate).rate(c.Request.Context(), po.Currency)
+	if err != nil {
+		rateErrorResponse(c, err, "Failed to load exchange rate")
+		return
+	}
+
+	lines := map[string]repositories.BilledUnits{}
+	for _, line := range req.Lines {
+		billed := lines[line.PurchaseOrderLineID]
+		billed.Quantity += line.Quantity
+		billed.UnitCost = line.UnitCost
+		lines[line.PurchaseOrderLineID] = billed
+	}
+
+	bill := models.NewSupplierBill(po, req.BillNumber, terms, billDate, rate, req.Notes, currentUsername(c))
+	bill.TaxAmount = models.CurrencyOf(po.Currency).Round(req.TaxAmount)
+	if err := h.repo.CreateSupplierBill(c.Request.Context(), bill, lines, billPriceTolerancePercent()); err != nil {
+		if isUniqueViolation(err) {
+			utils.DuplicateErrorResponse(c, "Duplicate bill number", "This supplier's bill "+req.BillNumber+" is already entered")
+			return
+		}
+		h.supplierBillErrorResponse(c, err, "create")
+		return
+	}
+
+	saved, err := h.repo.GetSupplierBillByID(c.Request.Context(), bill.ID)
+	if err != nil {
+		log.Printf("CreateSupplierBill - GetSupplierBillByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve supplier bill", "Database error")
+		return
+	}
+
+	message := "Supplier bill created successfully"
+	if saved.MatchStatus == models.MatchStatusVariance {
+		message = "Supplier bill created with variances to review"
+	}
+	utils.CreatedResponse(c, message, saved)
+}
+
+// GetSupplierBills lists supplier bills, newest first. Filters: supplier_id,
+// purchase_order_id, status, match_status, from and to (bill date) and overdue=true.
+func (h *SupplierBillHandler) GetSupplierBills(c *gin.Context) {
+	page, pageSize := utils.GetPaginationParams(c)
+
+	filter := repositories.SupplierBillFilter{
+		SupplierID:      c.Query("supplier_id"),
+		PurchaseOrderID: c.Query("purchase_order_id"),
+		Status:          c.Query("status"),
+		MatchStatus:     c.Query("match_status"),
+	}
+	switch filter.Status {
+	case "", models.SupplierBillStatusDraft, models.SupplierBillStatusApproved, models.SupplierBillStatusPartiallyPaid,
+		models.SupplierBillStatusPaid, models.SupplierBillStatusVoid:
+	default:
+		utils.ValidationErrorResponse(c, "Validation error", "status must be draft, approved, partially_paid, paid or void")
+		return
+	}
+	switch filter.MatchStatus {
+	case "", models.MatchStatusMatched, models.MatchStatusVariance:
+	default:
+		utils.ValidationErrorResponse(c, "Validation error", "match_status must be matched or variance")
+		return
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := parseTimeParam(from, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseTimeParam(to, true)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.To = &t
+	}
+	if c.Query("overdue") == "true" {
+		now := time.Now()
+		filter.OverdueOn = &now
+	}
+
+	bills, total, err := h.repo.GetSupplierBills(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		log.Printf("GetSupplierBills error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve supplier bills", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"bills": bills,
+		"pagination": utils.Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+			Pages:    utils.CalculateTotalPages(total, pageSize),
+		},
+	}
+
+	utils.SuccessResponse(c, "Supplier bills retrieved successfully", responseData)
+}
+
+// GetSupplierBillByID returns a bill with its matched lines and payments, and the early
+// payment discount still available today
+func (h *SupplierBillHandler) GetSupplierBillByID(c *gin.Context) {
+	bill, err := h.repo.GetSupplierBillByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetSupplierBillByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve supplier bill", "Database error")
+		return
+	}
+	if bill == nil {
+		utils.NotFoundResponse(c, "Supplier bill not found")
+		return
+	}
+
+	now := time.Now()
+	var discount models.Money
+	if bill.BalanceDue > 0 {
+		discount = bill.EarlyPaymentDiscount(now)
+	}
+	utils.SuccessResponse(c, "Supplier bill retrieved successfully", map[string]interface{}{
+		"bill":                   bill,
+		"overdue":                bill.Overdue(now),
+		"early_payment_discount": discount,
+	})
+}
+
+// ApproveSupplierBill approves a draft bill for payment
+func (h *SupplierBillHandler) ApproveSupplierBill(c *gin.Context) {
+	var req ApproveSupplierBillRequest
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	bill, err := h.repo.ApproveSupplierBill(c.Request.Context(), c.Param("id"), currentUsername(c), req.AcceptVariances)
+	if err != nil {
+		h.supplierBillErrorResponse(c, err, "approve")
+		return
+	}
+
+	utils.SuccessResponse(c, "Supplier bill approved successfully", bill)
+}
+
+// VoidSupplierBill cancels a bill entered in error. Its units can be billed again.
+func (h *SupplierBillHandler) VoidSupplierBill(c *gin.Context) {
+	var req VoidSupplierBillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	bill, err := h.repo.VoidSupplierBill(c.Request.Context(), c.Param("id"), req.Reason, currentUsername(c))
+	if err != nil {
+		h.supplierBillErrorResponse(c, err, "void")
+		return
+	}
+
+	utils.SuccessResponse(c, "Supplier bill voided successfully", bill)
+}
+
+// PaySupplierBill records a payment against an approved bill. Paying off the rest of the
+// bill within its discount window takes the early payment discount.
+func (h *SupplierBillHandler) PaySupplierBill(c *gin.Context) {
+	var req PaySupplierBillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+	paymentDate, ok := pastDateParam(c, req.PaymentDate, "payment_date")
+	if !ok {
+		return
+	}
+
+	payment := models.NewSupplierPayment(c.Param("id"), req.Method, req.Reference, req.Amount, paymentDate, currentUsername(c))
+	bill, err := h.repo.PaySupplierBill(c.Request.Context(), payment)
+	if err != nil {
+		h.supplierBillErrorResponse(c, err, "pay")
+		return
+	}
+
+	utils.SuccessResponse(c, "Supplier bill paid successfully", map[string]interface{}{
+		"payment": payment,
+		"bill":    bill,
+	})
+}
+
+// GetPayablesAging ages what is owed to each supplier into current, 1-30, 31-60, 61-90
+// and over 90 days past due, in the base currency, as of today or ?as_of=YYYY-MM-DD
+func (h *SupplierBillHandler) GetPayablesAging(c *gin.Context) {
+	asOf := time.Now()
+	if value := c.Query("as_of"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "as_of must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		asOf = t
+	}
+
+	lines, err := h.repo.GetPayablesAging(c.Request.Context(), asOf)
+	if err != nil {
+		log.Printf("GetPayablesAging error: %v", err)
+		storeErrorResponse(c, err, "Failed to age payables", "Database error")
+		return
+	}
+
+	var totals models.AgingBuckets
+	for _, line := range lines {
+		totals.AddBuckets(line.AgingBuckets)
+	}
+
+	utils.SuccessResponse(c, "Payables aging retrieved successfully", map[string]interface{}{
+		"as_of":         models.RateDate(asOf).Format("2006-01-02"),
+		"base_currency": models.BaseCurrency.Code,
+		"totals":        totals,
+		"suppliers":     lines,
+	})
+}
+
+// pastDateParam reads an optional date from a request body, defaulting to now. It writes
+// the error response and returns false when the date is malformed or in the future.
+func pastDateParam(c *gin.Context, value, name string) (time.Time, bool) {
+	if value == "" {
+		return time.Now(), true
+	}
+	t, err := parseTimeParam(value, false)
+	if err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", name+" must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+		return time.Time{}, false
+	}
+	if t.After(time.Now()) {
+		utils.ValidationErrorResponse(c, "Validation error", name+" cannot be in the future")
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// billPriceTolerancePercent reads BILL_PRICE_TOLERANCE_PERCENT, how far a billed unit
+// cost may stray from the agreed cost before it is a variance. Without it any
+// difference is.
+func billPriceTolerancePercent() models.Money {
+	tolerance, err := models.ParseMoney(os.Getenv("BILL_PRICE_TOLERANCE_PERCENT"))
+	if err != nil || tolerance < 0 {
+		return 0
+	}
+	return tolerance
+}
+
+// supplierBillErrorResponse maps supplier bill errors from the repository to responses
+func (h *SupplierBillHandler) supplierBillErrorResponse(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, repositories.ErrSupplierBillNotFound):
+		utils.NotFoundResponse(c, "Supplier bill not found")
+	case errors.Is(err, repositories.ErrPurchaseOrderNotFound):
+		utils.BadRequestResponse(c, "Invalid purchase order ID", "Purchase order not found")
+	case errors.Is(err, repositories.ErrInvalidSupplierBillStatus):
+		utils.BadRequestResponse(c, "Invalid supplier bill status", err.Error())
+	case errors.Is(err, repositories.ErrInvalidSupplierBill):
+		utils.BadRequestResponse(c, "Invalid supplier bill", err.Error())
+	default:
+		log.Printf("Supplier bill %s error: %v", action, err)
+		storeErrorResponse(c, err, "Failed to "+action+" supplier bill", "Database error")
+	}
+}
@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"log"
-	"strings"
 	"time"
 
 	"erp-project/models"
@@ -13,10 +12,10 @@ import (
 )
 
 type SupplierHandler struct {
-	repo *repositories.SupplierRepository
+	repo repositories.SupplierStore
 }
 
-func NewSupplierHandler(repo *repositories.SupplierRepository) *SupplierHandler {
+func NewSupplierHandler(repo repositories.SupplierStore) *SupplierHandler {
 	return &SupplierHandler{repo: repo}
 }
 
@@ -29,7 +28,7 @@ type CreateSupplierRequest struct {
 	Phone         string `json:"phone" binding:"omitempty,max=50"`
 	Address       string `json:"address" binding:"max=500"`
 	TaxID         string `json:"tax_id" binding:"max=100"`
-	PaymentTerms  string `json:"payment_terms" binding:"max=500"`
+	PaymentTerms  string `json:"payment_terms" binding:"max=50"` // e.g. "net 30" or "2/10 net 30"; defaults to net 30
 }
 
 type UpdateSupplierRequest struct {
@@ -40,16 +39,17 @@ type UpdateSupplierRequest struct {
 	Phone         string `json:"phone" binding:"omitempty,max=50"`
 	Address       string `json:"address" binding:"omitempty,max=500"`
 	TaxID         string `json:"tax_id" binding:"omitempty,max=100"`
-	PaymentTerms  string `json:"payment_terms" binding:"omitempty,max=500"`
+	PaymentTerms  string `json:"payment_terms" binding:"omitempty,max=50"`
 	Status        string `json:"status" binding:"omitempty,oneof=active inactive"`
 }
 
 type AddProductSupplierRequest struct {
-	ProductID    string  `json:"product_id" binding:"required"`
-	SupplierSKU  string  `json:"supplier_sku" binding:"max=100"`
-	CostPrice    float64 `json:"cost_price" binding:"required,gt=0"`
-	LeadTimeDays int     `json:"lead_time_days" binding:"omitempty,min=0"`
-	IsPrimary    bool    `json:"is_primary"`
+	ProductID    string       `json:"product_id" binding:"required"`
+	SupplierSKU  string       `json:"supplier_sku" binding:"max=100"`
+	CostPrice    models.Money `json:"cost_price" binding:"required,gt=0"`
+	Currency     string       `json:"currency" binding:"omitempty,len=3"` // Defaults to the base currency
+	LeadTimeDays int          `json:"lead_time_days" binding:"omitempty,min=0"`
+	IsPrimary    bool         `json:"is_primary"`
 }
 
 // Supplier CRUD handlers
@@ -59,6 +59,15 @@ func (h *SupplierHandler) CreateSupplier(c *gin.Context) {
 		utils.ValidationErrorResponse(c, "Validation error", err.Error())
 		return
 	}
+	paymentTerms := models.DefaultSupplierPaymentTerms
+	if req.PaymentTerms != "" {
+		terms, err := models.ParsePaymentTerms(req.PaymentTerms)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", err.Error())
+			return
+		}
+		paymentTerms = terms
+	}
 
 	supplier := models.NewSupplier(
 		req.Name,
@@ -68,19 +77,18 @@ func (h *SupplierHandler) CreateSupplier(c *gin.Context) {
 		req.Phone,
 		req.Address,
 		req.TaxID,
-		req.PaymentTerms,
+		paymentTerms,
 	)
 
-	if err := h.repo.CreateSupplier(supplier); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") ||
-			strings.Contains(err.Error(), "UNIQUE constraint failed") {
+	ctx := auditContext(c, models.AuditEntitySupplier, supplier.ID, models.AuditActionCreate, nil, supplier)
+	if err := h.repo.CreateSupplier(ctx, supplier); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate supplier code", "A supplier with this code already exists")
 			return
 		}
 
 		log.Printf("CreateSupplier error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to create supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to create supplier", "Database error")
 		return
 	}
 
@@ -88,10 +96,10 @@ func (h *SupplierHandler) CreateSupplier(c *gin.Context) {
 }
 
 func (h *SupplierHandler) GetAllSuppliers(c *gin.Context) {
-	suppliers, err := h.repo.GetAllSuppliers()
+	suppliers, err := h.repo.GetAllSuppliers(c.Request.Context())
 	if err != nil {
 		log.Printf("GetAllSuppliers error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve suppliers", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve suppliers", "Database error")
 		return
 	}
 
@@ -100,10 +108,10 @@ func (h *SupplierHandler) GetAllSuppliers(c *gin.Context) {
 
 func (h *SupplierHandler) GetSupplierByID(c *gin.Context) {
 	id := c.Param("id")
-	supplier, err := h.repo.GetSupplierByID(id)
+	supplier, err := h.repo.GetSupplierByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("GetSupplierByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve supplier", "Database error")
 		return
 	}
 	if supplier == nil {
@@ -123,10 +131,10 @@ func (h *SupplierHandler) UpdateSupplier(c *gin.Context) {
 	}
 
 	// Get existing supplier
-	supplier, err := h.repo.GetSupplierByID(id)
+	supplier, err := h.repo.GetSupplierByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("UpdateSupplier - GetSupplierByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve supplier", "Database error")
 		return
 	}
 
@@ -134,6 +142,7 @@ func (h *SupplierHandler) UpdateSupplier(c *gin.Context) {
 		utils.NotFoundResponse(c, "Supplier not found")
 		return
 	}
+	before := *supplier
 
 	// Update fields only if provided
 	updatedFields := []string{}
@@ -165,9 +174,16 @@ func (h *SupplierHandler) UpdateSupplier(c *gin.Context) {
 		supplier.TaxID = req.TaxID
 		updatedFields = append(updatedFields, "tax_id")
 	}
-	if req.PaymentTerms != "" && req.PaymentTerms != supplier.PaymentTerms {
-		supplier.PaymentTerms = req.PaymentTerms
-		updatedFields = append(updatedFields, "payment_terms")
+	if req.PaymentTerms != "" {
+		terms, err := models.ParsePaymentTerms(req.PaymentTerms)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", err.Error())
+			return
+		}
+		if terms != supplier.PaymentTerms {
+			supplier.PaymentTerms = terms
+			updatedFields = append(updatedFields, "payment_terms")
+		}
 	}
 	if req.Status != "" && req.Status != supplier.Status {
 		supplier.Status = req.Status
@@ -182,23 +198,23 @@ func (h *SupplierHandler) UpdateSupplier(c *gin.Context) {
 
 	supplier.UpdatedAt = time.Now()
 
-	if err := h.repo.UpdateSupplier(supplier); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") {
+	ctx := auditContext(c, models.AuditEntitySupplier, id, models.AuditActionUpdate, &before, supplier)
+	if err := h.repo.UpdateSupplier(ctx, supplier); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate supplier code", "A supplier with this code already exists")
 			return
 		}
 
 		log.Printf("UpdateSupplier error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to update supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to update supplier", "Database error")
 		return
 	}
 
 	// Get updated supplier
-	updatedSupplier, err := h.repo.GetSupplierByID(id)
+	updatedSupplier, err := h.repo.GetSupplierByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("UpdateSupplier - Get updated supplier error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve updated supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve updated supplier", "Database error")
 		return
 	}
 
@@ -212,10 +228,10 @@ func (h *SupplierHandler) UpdateSupplier(c *gin.Context) {
 
 func (h *SupplierHandler) DeleteSupplier(c *gin.Context) {
 	id := c.Param("id")
-	supplier, err := h.repo.GetSupplierByID(id)
+	supplier, err := h.repo.GetSupplierByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("DeleteSupplier - GetSupplierByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve supplier", "Database error")
 		return
 	}
 	if supplier == nil {
@@ -223,9 +239,10 @@ func (h *SupplierHandler) DeleteSupplier(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.DeleteSupplier(id); err != nil {
+	ctx := auditContext(c, models.AuditEntitySupplier, id, models.AuditActionDelete, supplier, nil)
+	if err := h.repo.DeleteSupplier(ctx, id); err != nil {
 		log.Printf("DeleteSupplier error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to delete supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to delete supplier", "Database error")
 		return
 	}
 
@@ -246,9 +263,13 @@ func (h *SupplierHandler) AddProductSupplier(c *gin.Context) {
 		utils.ValidationErrorResponse(c, "Validation error", err.Error())
 		return
 	}
+	currency, ok := requestCurrency(c, req.Currency)
+	if !ok {
+		return
+	}
 
 	// Verify supplier exists
-	supplier, err := h.repo.GetSupplierByID(supplierID)
+	supplier, err := h.repo.GetSupplierByID(c.Request.Context(), supplierID)
 	if err != nil || supplier == nil {
 		utils.NotFoundResponse(c, "Supplier not found")
 		return
@@ -262,16 +283,17 @@ func (h *SupplierHandler) AddProductSupplier(c *gin.Context) {
 		req.LeadTimeDays,
 		req.IsPrimary,
 	)
+	productSupplier.Currency = currency.Code
 
-	if err := h.repo.AddProductSupplier(productSupplier); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") {
+	ctx := auditContext(c, models.AuditEntityProductSupplier, productSupplier.ID, models.AuditActionCreate, nil, productSupplier)
+	if err := h.repo.AddProductSupplier(ctx, productSupplier); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate product-supplier", "This product is already linked to this supplier")
 			return
 		}
 
 		log.Printf("AddProductSupplier error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to link product to supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to link product to supplier", "Database error")
 		return
 	}
 
@@ -282,16 +304,16 @@ func (h *SupplierHandler) GetSupplierProducts(c *gin.Context) {
 	supplierID := c.Param("id")
 
 	// Verify supplier exists
-	supplier, err := h.repo.GetSupplierByID(supplierID)
+	supplier, err := h.repo.GetSupplierByID(c.Request.Context(), supplierID)
 	if err != nil || supplier == nil {
 		utils.NotFoundResponse(c, "Supplier not found")
 		return
 	}
 
-	products, err := h.repo.GetSupplierProducts(supplierID)
+	products, err := h.repo.GetSupplierProducts(c.Request.Context(), supplierID)
 	if err != nil {
 		log.Printf("GetSupplierProducts error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve supplier products", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve supplier products", "Database error")
 		return
 	}
 
@@ -307,9 +329,21 @@ func (h *SupplierHandler) GetSupplierProducts(c *gin.Context) {
 func (h *SupplierHandler) RemoveProductSupplier(c *gin.Context) {
 	productSupplierID := c.Param("product_supplier_id")
 
-	if err := h.repo.RemoveProductSupplier(productSupplierID); err != nil {
+	productSupplier, err := h.repo.GetProductSupplierByID(c.Request.Context(), productSupplierID)
+	if err != nil {
+		log.Printf("RemoveProductSupplier - GetProductSupplierByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve product supplier", "Database error")
+		return
+	}
+	if productSupplier == nil {
+		utils.NotFoundResponse(c, "Product supplier not found")
+		return
+	}
+
+	ctx := auditContext(c, models.AuditEntityProductSupplier, productSupplierID, models.AuditActionDelete, productSupplier, nil)
+	if err := h.repo.RemoveProductSupplier(ctx, productSupplierID); err != nil {
 		log.Printf("RemoveProductSupplier error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to remove product from supplier", "Database error")
+		storeErrorResponse(c, err, "Failed to remove product from supplier", "Database error")
 		return
 	}
 
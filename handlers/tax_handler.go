@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TaxHandler struct {
+	repo repositories.TaxStore
+}
+
+func NewTaxHandler(repo repositories.TaxStore) *TaxHandler {
+	return &TaxHandler{repo: repo}
+}
+
+// CreateTaxRateRequest sets the rate for a tax category in a country, or in one region
+// of it
+type CreateTaxRateRequest struct {
+	Name        string       `json:"name" binding:"required,max=255"`
+	Country     string       `json:"country" binding:"required,len=2,alpha"` // ISO 3166-1 alpha-2
+	Region      string       `json:"region" binding:"max=50"`                // Whole country when empty
+	TaxCategory string       `json:"tax_category" binding:"max=50"`          // Defaults to standard
+	Rate        models.Money `json:"rate" binding:"gte=0,lte=1000000"`       // Percent, at most 100
+	ValidFrom   string       `json:"valid_from" binding:"required"`          // YYYY-MM-DD
+	ValidTo     string       `json:"valid_to"`                               // YYYY-MM-DD, open ended when empty
+}
+
+type UpdateTaxRateRequest struct {
+	Name      string  `json:"name" binding:"omitempty,max=255"`
+	ValidFrom string  `json:"valid_from"` // YYYY-MM-DD
+	ValidTo   *string `json:"valid_to"`   // An empty string makes the rate open ended
+	Active    *bool   `json:"active"`
+}
+
+func (h *TaxHandler) CreateTaxRate(c *gin.Context) {
+	var req CreateTaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+	if models.NormalizeTaxCategory(req.TaxCategory) == models.TaxCategoryExempt {
+		utils.ValidationErrorResponse(c, "Validation error", "The exempt tax category is never taxed and cannot have a rate")
+		return
+	}
+	validFrom, validTo, ok := parseValidity(c, req.ValidFrom, req.ValidTo)
+	if !ok {
+		return
+	}
+
+	rate := models.NewTaxRate(req.Name, req.Country, req.Region, req.TaxCategory, req.Rate, validFrom, validTo, currentUsername(c))
+	ctx := auditContext(c, models.AuditEntityTaxRate, rate.ID, models.AuditActionCreate, nil, rate)
+	if err := h.repo.CreateTaxRate(ctx, rate); err != nil {
+		log.Printf("CreateTaxRate error: %v", err)
+		storeErrorResponse(c, err, "Failed to create tax rate", "Database error")
+		return
+	}
+
+	utils.CreatedResponse(c, "Tax rate created successfully", rate)
+}
+
+// GetTaxRates lists tax rates, optionally for a jurisdiction or category, or only those
+// in effect on a day (?valid_on=YYYY-MM-DD)
+func (h *TaxHandler) GetTaxRates(c *gin.Context) {
+	filter := repositories.TaxRateFilter{
+		Country:     c.Query("country"),
+		Region:      c.Query("region"),
+		TaxCategory: c.Query("tax_category"),
+	}
+	if value := c.Query("valid_on"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "valid_on must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		filter.ValidOn = &t
+	}
+
+	rates, err := h.repo.GetTaxRates(c.Request.Context(), filter)
+	if err != nil {
+		log.Printf("GetTaxRates error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve tax rates", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Tax rates retrieved successfully", rates)
+}
+
+func (h *TaxHandler) GetTaxRateByID(c *gin.Context) {
+	rate, err := h.repo.GetTaxRateByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetTaxRateByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve tax rate", "Database error")
+		return
+	}
+	if rate == nil {
+		utils.NotFoundResponse(c, "Tax rate not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "Tax rate retrieved successfully", rate)
+}
+
+// UpdateTaxRate renames a rate, changes the days it applies or switches it on or off. A
+// new percentage is a new rate: end this one and create another from the day it
+// changes, so orders already taxed keep pointing at the rate they were charged.
+func (h *TaxHandler) UpdateTaxRate(c *gin.Context) {
+	id := c.Param("id")
+
+	var req UpdateTaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	rate, err := h.repo.GetTaxRateByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("UpdateTaxRate - GetTaxRateByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve tax rate", "Database error")
+		return
+	}
+	if rate == nil {
+		utils.NotFoundResponse(c, "Tax rate not found")
+		return
+	}
+	before := *rate
+
+	updatedFields := []string{}
+	if req.Name != "" && req.Name != rate.Name {
+		rate.Name = req.Name
+		updatedFields = append(updatedFields, "name")
+	}
+
+	validFrom := rate.ValidFrom.Format("2006-01-02")
+	if req.ValidFrom != "" {
+		validFrom = req.ValidFrom
+	}
+	validTo := ""
+	if rate.ValidTo != nil {
+		validTo = rate.ValidTo.Format("2006-01-02")
+	}
+	if req.ValidTo != nil {
+		validTo = *req.ValidTo
+	}
+	from, to, ok := parseValidity(c, validFrom, validTo)
+	if !ok {
+		return
+	}
+	if !from.Equal(rate.ValidFrom) {
+		rate.ValidFrom = from
+		updatedFields = append(updatedFields, "valid_from")
+	}
+	if (to == nil) != (rate.ValidTo == nil) || (to != nil && !to.Equal(*rate.ValidTo)) {
+		rate.SetValidTo(to)
+		updatedFields = append(updatedFields, "valid_to")
+	}
+
+	if req.Active != nil && *req.Active != rate.Active {
+		rate.Active = *req.Active
+		updatedFields = append(updatedFields, "active")
+	}
+
+	if len(updatedFields) == 0 {
+		utils.SuccessResponse(c, "No changes detected", rate)
+		return
+	}
+
+	ctx := auditContext(c, models.AuditEntityTaxRate, id, models.AuditActionUpdate, &before, rate)
+	if err := h.repo.UpdateTaxRate(ctx, rate); err != nil {
+		log.Printf("UpdateTaxRate error: %v", err)
+		storeErrorResponse(c, err, "Failed to update tax rate", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Tax rate updated successfully", map[string]interface{}{
+		"tax_rate":       rate,
+		"updated_fields": updatedFields,
+	})
+}
+
+// GetTaxSummary totals the tax charged on orders placed between from and to by
+// jurisdiction, tax category and rate, ready for filing. Amounts are given in each
+// order currency and in the base currency.
+func (h *TaxHandler) GetTaxSummary(c *gin.Context) {
+	var from, to *time.Time
+	if value := c.Query("from"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "from must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		from = &t
+	}
+	if value := c.Query("to"); value != "" {
+		t, err := parseTimeParam(value, true)
+		if err != nil {
+			utils.ValidationErrorResponse(c, "Validation error", "to must be a date (YYYY-MM-DD) or RFC3339 timestamp")
+			return
+		}
+		to = &t
+	}
+
+	lines, err := h.repo.GetTaxSummary(c.Request.Context(), from, to)
+	if err != nil {
+		log.Printf("GetTaxSummary error: %v", err)
+		storeErrorResponse(c, err, "Failed to summarise tax", "Database error")
+		return
+	}
+
+	var baseTaxable, baseTax models.Money
+	for _, line := range lines {
+		baseTaxable += line.BaseTaxableAmount
+		baseTax += line.BaseTaxAmount
+	}
+
+	utils.SuccessResponse(c, "Tax summary retrieved successfully", map[string]interface{}{
+		"from":                from,
+		"to":                  to,
+		"base_currency":       models.BaseCurrency.Code,
+		"base_taxable_amount": baseTaxable,
+		"base_tax_amount":     baseTax,
+		"lines":               lines,
+	})
+}
+
+// homeTaxJurisdiction is where sales to customers with no country on file are taxed,
+// from TAX_HOME_COUNTRY and TAX_HOME_REGION. Without them such sales are not taxed.
+func homeTaxJurisdiction() models.TaxJurisdiction {
+	return models.TaxJurisdiction{
+		Country: models.NormalizeCountry(os.Getenv("TAX_HOME_COUNTRY")),
+		Region:  models.NormalizeRegion(os.Getenv("TAX_HOME_REGION")),
+	}
+}
+
+// defaultPricesIncludeTax reports whether catalogue and price list prices include tax
+// for orders that do not say, from PRICES_INCLUDE_TAX
+func defaultPricesIncludeTax() bool {
+	return strings.EqualFold(os.Getenv("PRICES_INCLUDE_TAX"), "true")
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TransferHandler struct {
+	transferRepo  repositories.TransferStore
+	warehouseRepo repositories.WarehouseStore
+	productRepo   repositories.ProductStore
+}
+
+func NewTransferHandler(
+	transferRepo repositories.TransferStore,
+	warehouseRepo repositories.WarehouseStore,
+	productRepo repositories.ProductStore) *TransferHandler {
+	return &TransferHandler{
+		transferRepo:  transferRepo,
+		warehouseRepo: warehouseRepo,
+		productRepo:   productRepo,
+	}
+}
+
+type CreateTransferRequest struct {
+	SourceWarehouseID      string                      `json:"source_warehouse_id" binding:"required"`
+	DestinationWarehouseID string                      `json:"destination_warehouse_id" binding:"required"`
+	Notes                  string                      `json:"notes" binding:"max=500"`
+	Lines                  []CreateTransferLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+type CreateTransferLineRequest struct {
+	ProductID             string `json:"product_id" binding:"required"`
+	Quantity              int    `json:"quantity" binding:"required,gt=0"`
+	SourceLocationID      string `json:"source_location_id"`
+	DestinationLocationID string `json:"destination_location_id"`
+}
+
+type ReceiveTransferRequest struct {
+	Lines []ReceiveTransferLineRequest `json:"lines" binding:"required,min=1,dive"`
+}
+
+// ReceiveTransferLineRequest books a delivery against one transfer line. Discrepancy
+// quantity is stock that was dispatched but will not arrive (missing or damaged).
+type ReceiveTransferLineRequest struct {
+	LineID              string `json:"line_id" binding:"required"`
+	ReceivedQuantity    int    `json:"received_quantity" binding:"gte=0"`
+	DiscrepancyQuantity int    `json:"discrepancy_quantity" binding:"gte=0"`
+	DiscrepancyReason   string `json:"discrepancy_reason" binding:"max=500"`
+	LocationID          string `json:"location_id"` // Defaults to the line's destination location
+}
+
+func (h *TransferHandler) CreateTransfer(c *gin.Context) {
+	var req CreateTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	if req.SourceWarehouseID == req.DestinationWarehouseID {
+		utils.BadRequestResponse(c, "Invalid transfer", "Source and destination warehouse must differ")
+		return
+	}
+
+	for _, warehouseID := range []string{req.SourceWarehouseID, req.DestinationWarehouseID} {
+		warehouse, err := h.warehouseRepo.GetWarehouseByID(c.Request.Context(), warehouseID)
+		if err != nil {
+			log.Printf("CreateTransfer - GetWarehouseByID error: %v", err)
+			storeErrorResponse(c, err, "Failed to validate warehouse", "Database error")
+			return
+		}
+		if warehouse == nil {
+			utils.BadRequestResponse(c, "Invalid warehouse ID", map[string]interface{}{
+				"warehouse_id": warehouseID,
+				"message":      "Warehouse not found",
+			})
+			return
+		}
+	}
+
+	transfer := models.NewTransferOrder(req.SourceWarehouseID, req.DestinationWarehouseID, req.Notes, currentUsername(c))
+
+	for _, lineReq := range req.Lines {
+		if product, err := h.productRepo.GetProductByID(c.Request.Context(), lineReq.ProductID); err != nil || product == nil {
+			utils.BadRequestResponse(c, "Invalid product ID", map[string]interface{}{
+				"product_id": lineReq.ProductID,
+				"message":    "Product not found",
+			})
+			return
+		}
+
+		var sourceLocationID, destinationLocationID *string
+		if lineReq.SourceLocationID != "" {
+			sourceLocationID = &lineReq.SourceLocationID
+		}
+		if lineReq.DestinationLocationID != "" {
+			destinationLocationID = &lineReq.DestinationLocationID
+		}
+
+		line := models.NewTransferOrderLine(transfer.ID, lineReq.ProductID, sourceLocationID, destinationLocationID, lineReq.Quantity)
+		transfer.Lines = append(transfer.Lines, *line)
+	}
+
+	if err := h.transferRepo.CreateTransferOrder(c.Request.Context(), transfer); err != nil {
+		if errors.Is(err, repositories.ErrLocationNotInWarehouse) {
+			utils.BadRequestResponse(c, "Invalid location", err.Error())
+			return
+		}
+
+		log.Printf("CreateTransfer error: %v", err)
+		storeErrorResponse(c, err, "Failed to create transfer order", "Database error")
+		return
+	}
+
+	h.respondWithTransfer(c, transfer.ID, "Transfer order created successfully", true)
+}
+
+func (h *TransferHandler) GetTransfers(c *gin.Context) {
+	transfers, err := h.transferRepo.GetTransferOrders(c.Request.Context(), c.Query("status"), c.Query("warehouse_id"))
+	if err != nil {
+		log.Printf("GetTransfers error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve transfer orders", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Transfer orders retrieved successfully", transfers)
+}
+
+func (h *TransferHandler) GetTransferByID(c *gin.Context) {
+	h.respondWithTransfer(c, c.Param("id"), "Transfer order retrieved successfully", false)
+}
+
+func (h *TransferHandler) DispatchTransfer(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.transferRepo.DispatchTransferOrder(c.Request.Context(), id, currentUsername(c)); err != nil {
+		var stockErr *repositories.InsufficientStockError
+		switch {
+		case errors.Is(err, repositories.ErrTransferNotFound):
+			utils.NotFoundResponse(c, "Transfer order not found")
+		case errors.Is(err, repositories.ErrInvalidTransferStatus):
+			utils.BadRequestResponse(c, "Invalid transfer status", "Only draft transfers can be dispatched")
+		case errors.As(err, &stockErr):
+			utils.BadRequestResponse(c, "Insufficient warehouse stock", map[string]interface{}{
+				"product_id": stockErr.ProductID,
+				"available":  stockErr.Available,
+				"requested":  stockErr.Requested,
+			})
+		default:
+			log.Printf("DispatchTransfer error: %v", err)
+			storeErrorResponse(c, err, "Failed to dispatch transfer order", "Database error")
+		}
+		return
+	}
+
+	h.respondWithTransfer(c, id, "Transfer order dispatched successfully", false)
+}
+
+func (h *TransferHandler) ReceiveTransfer(c *gin.Context) {
+	id := c.Param("id")
+
+	var req ReceiveTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	receipts := make([]models.TransferReceipt, 0, len(req.Lines))
+	for _, lineReq := range req.Lines {
+		receipt := models.TransferReceipt{
+			TransferOrderLineID: lineReq.LineID,
+			ReceivedQuantity:    lineReq.ReceivedQuantity,
+			DiscrepancyQuantity: lineReq.DiscrepancyQuantity,
+			DiscrepancyReason:   lineReq.DiscrepancyReason,
+			ReceivedBy:          currentUsername(c),
+		}
+		if lineReq.LocationID != "" {
+			locationID := lineReq.LocationID
+			receipt.LocationID = &locationID
+		}
+		if receipt.DiscrepancyQuantity > 0 && receipt.DiscrepancyReason == "" {
+			utils.ValidationErrorResponse(c, "Validation error", "discrepancy_reason is required when discrepancy_quantity is set")
+			return
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	recorded, err := h.transferRepo.ReceiveTransferOrder(c.Request.Context(), id, receipts)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrTransferNotFound):
+			utils.NotFoundResponse(c, "Transfer order not found")
+		case errors.Is(err, repositories.ErrInvalidTransferStatus):
+			utils.BadRequestResponse(c, "Invalid transfer status", "Only transfers in transit can be received")
+		case errors.Is(err, repositories.ErrInvalidTransferReceipt),
+			errors.Is(err, repositories.ErrLocationNotInWarehouse),
+			errors.Is(err, repositories.ErrLocationCapacityExceeded),
+			errors.Is(err, repositories.ErrInsufficientStock):
+			utils.BadRequestResponse(c, "Invalid transfer receipt", err.Error())
+		default:
+			log.Printf("ReceiveTransfer error: %v", err)
+			storeErrorResponse(c, err, "Failed to receive transfer order", "Database error")
+		}
+		return
+	}
+
+	transfer, err := h.transferRepo.GetTransferOrderByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("ReceiveTransfer - GetTransferOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve transfer order", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"transfer": transfer,
+		"receipts": recorded,
+	}
+
+	utils.SuccessResponse(c, "Transfer receipt recorded successfully", responseData)
+}
+
+func (h *TransferHandler) CancelTransfer(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.transferRepo.CancelTransferOrder(c.Request.Context(), id); err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrTransferNotFound):
+			utils.NotFoundResponse(c, "Transfer order not found")
+		case errors.Is(err, repositories.ErrInvalidTransferStatus):
+			utils.BadRequestResponse(c, "Invalid transfer status", "Only draft transfers can be cancelled")
+		default:
+			log.Printf("CancelTransfer error: %v", err)
+			storeErrorResponse(c, err, "Failed to cancel transfer order", "Database error")
+		}
+		return
+	}
+
+	h.respondWithTransfer(c, id, "Transfer order cancelled successfully", false)
+}
+
+func (h *TransferHandler) GetTransferReceipts(c *gin.Context) {
+	id := c.Param("id")
+
+	receipts, err := h.transferRepo.GetTransferReceipts(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("GetTransferReceipts error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve transfer receipts", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Transfer receipts retrieved successfully", receipts)
+}
+
+// respondWithTransfer loads a transfer order with its lines and writes it out
+func (h *TransferHandler) respondWithTransfer(c *gin.Context, id, message string, created bool) {
+	transfer, err := h.transferRepo.GetTransferOrderByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("GetTransferOrderByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve transfer order", "Database error")
+		return
+	}
+	if transfer == nil {
+		utils.NotFoundResponse(c, "Transfer order not found")
+		return
+	}
+
+	if created {
+		utils.CreatedResponse(c, message, transfer)
+		return
+	}
+	utils.SuccessResponse(c, message, transfer)
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserHandler struct {
+	repo repositories.UserStore
+}
+
+func NewUserHandler(repo repositories.UserStore) *UserHandler {
+	return &UserHandler{repo: repo}
+}
+
+type CreateUserRequest struct {
+	Username string   `json:"username" binding:"required,min=3,max=100"`
+	Email    string   `json:"email" binding:"omitempty,email"`
+	FullName string   `json:"full_name" binding:"max=255"`
+	Password string   `json:"password" binding:"required,min=8,max=72"` // bcrypt ignores anything past 72 bytes
+	Roles    []string `json:"roles" binding:"required,min=1,dive,oneof=admin sales warehouse purchasing viewer"`
+}
+
+type UpdateUserRequest struct {
+	Email    string   `json:"email" binding:"omitempty,email"`
+	FullName string   `json:"full_name" binding:"omitempty,max=255"`
+	Password string   `json:"password" binding:"omitempty,min=8,max=72"`
+	Status   string   `json:"status" binding:"omitempty,oneof=active inactive"`
+	Roles    []string `json:"roles" binding:"omitempty,min=1,dive,oneof=admin sales warehouse purchasing viewer"` // Replaces all roles
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,min=2,max=255"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	user, err := models.NewUser(req.Username, req.Email, req.FullName, req.Password, uniqueRoles(req.Roles))
+	if err != nil {
+		log.Printf("CreateUser - NewUser error: %v", err)
+		utils.InternalErrorResponse(c, "Failed to create user", "Password hashing error")
+		return
+	}
+
+	if err := h.repo.CreateUser(c.Request.Context(), user); err != nil {
+		if isUniqueViolation(err) {
+			utils.DuplicateErrorResponse(c, "Duplicate user", "A user with this username or email already exists")
+			return
+		}
+		log.Printf("CreateUser error: %v", err)
+		storeErrorResponse(c, err, "Failed to create user", "Database error")
+		return
+	}
+
+	utils.CreatedResponse(c, "User created successfully", user)
+}
+
+func (h *UserHandler) GetUsers(c *gin.Context) {
+	users, err := h.repo.GetUsers(c.Request.Context())
+	if err != nil {
+		log.Printf("GetUsers error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve users", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "Users retrieved successfully", users)
+}
+
+// GetRoles lists the roles that can be assigned and the permissions each grants
+func (h *UserHandler) GetRoles(c *gin.Context) {
+	roles := map[string][]string{}
+	for _, role := range models.Roles() {
+		roles[role] = models.RolePermissions(role)
+	}
+
+	utils.SuccessResponse(c, "Roles retrieved successfully", roles)
+}
+
+func (h *UserHandler) GetUserByID(c *gin.Context) {
+	user, err := h.repo.GetUserByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetUserByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve user", "Database error")
+		return
+	}
+	if user == nil {
+		utils.NotFoundResponse(c, "User not found")
+		return
+	}
+
+	utils.SuccessResponse(c, "User retrieved successfully", user)
+}
+
+func (h *UserHandler) UpdateUser(c *gin.Context) {
+	id := c.Param("id")
+
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+
+	user, err := h.repo.GetUserByID(c.Request.Context(), id)
+	if err != nil {
+		log.Printf("UpdateUser - GetUserByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve user", "Database error")
+		return
+	}
+	if user == nil {
+		utils.NotFoundResponse(c, "User not found")
+		return
+	}
+
+	wasAdmin := user.IsActive() && user.HasRole(models.RoleAdmin)
+
+	updatedFields := []string{}
+	if req.Email != "" && req.Email != user.Email {
+		user.Email = req.Email
+		updatedFields = append(updatedFields, "email")
+	}
+	if req.FullName != "" && req.FullName != user.FullName {
+		user.FullName = req.FullName
+		updatedFields = append(updatedFields, "full_name")
+	}
+	if req.Status != "" && req.Status != user.Status {
+		user.Status = req.Status
+		updatedFields = append(updatedFields, "status")
+	}
+	if req.Roles != nil {
+		roles := uniqueRoles(req.Roles)
+		if !sameRoles(roles, user.Roles) {
+			user.Roles = roles
+			updatedFields = append(updatedFields, "roles")
+		}
+	}
+	if req.Password != "" {
+		if err := user.SetPassword(req.Password); err != nil {
+			log.Printf("UpdateUser - SetPassword error: %v", err)
+			storeErrorResponse(c, err, "Failed to update user", "Password hashing error")
+			return
+		}
+		updatedFields = append(updatedFields, "password")
+	}
+
+	if len(updatedFields) == 0 {
+		utils.SuccessResponse(c, "No changes detected", user)
+		return
+	}
+
+	// Keep at least one active admin, or nobody could manage users any more
+	if wasAdmin && (!user.HasRole(models.RoleAdmin) || !user.IsActive()) {
+		admins, err := h.repo.CountUsersWithRole(c.Request.Context(), models.RoleAdmin)
+		if err != nil {
+			log.Printf("UpdateUser - CountUsersWithRole error: %v", err)
+			storeErrorResponse(c, err, "Failed to update user", "Database error")
+			return
+		}
+		if admins <= 1 {
+			utils.BadRequestResponse(c, "Cannot remove the last admin", "Another active admin is required first")
+			return
+		}
+	}
+
+	user.UpdatedAt = time.Now()
+
+	if err := h.repo.UpdateUser(c.Request.Context(), user); err != nil {
+		if isUniqueViolation(err) {
+			utils.DuplicateErrorResponse(c, "Duplicate email", "A user with this email already exists")
+			return
+		}
+		log.Printf("UpdateUser error: %v", err)
+		storeErrorResponse(c, err, "Failed to update user", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"user":           user,
+		"updated_fields": updatedFields,
+	}
+
+	utils.SuccessResponse(c, "User updated successfully", responseData)
+}
+
+// CreateAPIKey issues a long-lived key that authenticates as the user. The key is only
+// returned in this response.
+func (h *UserHandler) CreateAPIKey(c *gin.Context) {
+	userID := c.Param("id")
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ValidationErrorResponse(c, "Validation error", err.Error())
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.ValidationErrorResponse(c, "Validation error", "expires_at must be in the future")
+		return
+	}
+
+	user, err := h.repo.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("CreateAPIKey - GetUserByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve user", "Database error")
+		return
+	}
+	if user == nil {
+		utils.NotFoundResponse(c, "User not found")
+		return
+	}
+
+	key, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		log.Printf("CreateAPIKey - GenerateAPIKey error: %v", err)
+		utils.InternalErrorResponse(c, "Failed to create API key", "Key generation error")
+		return
+	}
+
+	apiKey := models.NewAPIKey(user.ID, req.Name, prefix, utils.HashAPIKey(key), req.ExpiresAt)
+	if err := h.repo.CreateAPIKey(c.Request.Context(), apiKey); err != nil {
+		storeErrorResponse(c, err, "Failed to create API key", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"api_key": apiKey,
+		"key":     key,
+		"message": "Store this key now; it cannot be shown again",
+	}
+
+	utils.CreatedResponse(c, "API key created successfully", responseData)
+}
+
+func (h *UserHandler) GetAPIKeys(c *gin.Context) {
+	keys, err := h.repo.GetAPIKeys(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		log.Printf("GetAPIKeys error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve API keys", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "API keys retrieved successfully", keys)
+}
+
+func (h *UserHandler) RevokeAPIKey(c *gin.Context) {
+	if err := h.repo.RevokeAPIKey(c.Request.Context(), c.Param("key_id"), c.Param("id")); err != nil {
+		if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+			utils.NotFoundResponse(c, "API key not found or already revoked")
+			return
+		}
+		storeErrorResponse(c, err, "Failed to revoke API key", "Database error")
+		return
+	}
+
+	utils.SuccessResponse(c, "API key revoked successfully", nil)
+}
+
+// uniqueRoles drops repeated roles while keeping their order
+func uniqueRoles(roles []string) []string {
+	unique := []string{}
+	for _, role := range roles {
+		if !slices.Contains(unique, role) {
+			unique = append(unique, role)
+		}
+	}
+	return unique
+}
+
+func sameRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, role := range a {
+		if !slices.Contains(b, role) {
+			return false
+		}
+	}
+	return true
+}
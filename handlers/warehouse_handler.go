@@ -1,8 +1,8 @@
 package handlers
 
 import (
+	"errors"
 	"log"
-	"strings"
 	"time"
 
 	"erp-project/models"
@@ -13,33 +13,37 @@ import (
 )
 
 type WarehouseHandler struct {
-	repo *repositories.WarehouseRepository
+	repo repositories.WarehouseStore
 }
 
-func NewWarehouseHandler(repo *repositories.WarehouseRepository) *WarehouseHandler {
+func NewWarehouseHandler(repo repositories.WarehouseStore) *WarehouseHandler {
 	return &WarehouseHandler{repo: repo}
 }
 
 // Request structs
 type CreateWarehouseRequest struct {
-	Code        string `json:"code" binding:"required,min=2,max=50"`
-	Name        string `json:"name" binding:"required,min=2,max=255"`
-	Location    string `json:"location" binding:"max=500"`
-	ManagerName string `json:"manager_name" binding:"max=255"`
-	Phone       string `json:"phone" binding:"omitempty,max=50"`
-	Email       string `json:"email" binding:"omitempty,email"`
-	Capacity    int    `json:"capacity" binding:"omitempty,min=0"`
+	Code        string   `json:"code" binding:"required,min=2,max=50"`
+	Name        string   `json:"name" binding:"required,min=2,max=255"`
+	Location    string   `json:"location" binding:"max=500"`
+	ManagerName string   `json:"manager_name" binding:"max=255"`
+	Phone       string   `json:"phone" binding:"omitempty,max=50"`
+	Email       string   `json:"email" binding:"omitempty,email"`
+	Capacity    int      `json:"capacity" binding:"omitempty,min=0"`
+	Latitude    *float64 `json:"latitude" binding:"omitempty,gte=-90,lte=90"`
+	Longitude   *float64 `json:"longitude" binding:"omitempty,gte=-180,lte=180"`
 }
 
 type UpdateWarehouseRequest struct {
-	Code        string `json:"code" binding:"omitempty,min=2,max=50"`
-	Name        string `json:"name" binding:"omitempty,min=2,max=255"`
-	Location    string `json:"location" binding:"omitempty,max=500"`
-	ManagerName string `json:"manager_name" binding:"omitempty,max=255"`
-	Phone       string `json:"phone" binding:"omitempty,max=50"`
-	Email       string `json:"email" binding:"omitempty,email"`
-	Capacity    int    `json:"capacity" binding:"omitempty,min=0"`
-	Status      string `json:"status" binding:"omitempty,oneof=active inactive"`
+	Code        string   `json:"code" binding:"omitempty,min=2,max=50"`
+	Name        string   `json:"name" binding:"omitempty,min=2,max=255"`
+	Location    string   `json:"location" binding:"omitempty,max=500"`
+	ManagerName string   `json:"manager_name" binding:"omitempty,max=255"`
+	Phone       string   `json:"phone" binding:"omitempty,max=50"`
+	Email       string   `json:"email" binding:"omitempty,email"`
+	Capacity    int      `json:"capacity" binding:"omitempty,min=0"`
+	Latitude    *float64 `json:"latitude" binding:"omitempty,gte=-90,lte=90"`
+	Longitude   *float64 `json:"longitude" binding:"omitempty,gte=-180,lte=180"`
+	Status      string   `json:"status" binding:"omitempty,oneof=active inactive"`
 }
 
 type CreateLocationRequest struct {
@@ -68,9 +72,16 @@ type CreateInventoryRequest struct {
 	MinQuantity int    `json:"min_quantity" binding:"omitempty,min=0"`
 }
 
+// UpdateInventoryRequest adjusts on-hand stock and/or the reorder levels of an
+// inventory row. Quantity is the new on-hand quantity in set mode (the default) and a
+// signed change in delta mode; a reason code is required whenever it is given.
 type UpdateInventoryRequest struct {
-	Quantity    int `json:"quantity" binding:"omitempty,min=0"`
-	MinQuantity int `json:"min_quantity" binding:"omitempty,min=0"`
+	Mode        string `json:"mode" binding:"omitempty,oneof=set delta"`
+	Quantity    *int   `json:"quantity"`
+	ReasonCode  string `json:"reason_code" binding:"omitempty,oneof=count_correction damaged lost found expired returned other"`
+	Note        string `json:"note" binding:"max=500"`
+	MinQuantity *int   `json:"min_quantity" binding:"omitempty,min=0"`
+	MaxQuantity *int   `json:"max_quantity" binding:"omitempty,min=0"`
 }
 
 // Warehouse CRUD handlers
@@ -90,17 +101,18 @@ func (h *WarehouseHandler) CreateWarehouse(c *gin.Context) {
 		req.Email,
 		req.Capacity,
 	)
+	warehouse.Latitude = req.Latitude
+	warehouse.Longitude = req.Longitude
 
-	if err := h.repo.CreateWarehouse(warehouse); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") ||
-			strings.Contains(err.Error(), "UNIQUE constraint failed") {
+	ctx := auditContext(c, models.AuditEntityWarehouse, warehouse.ID, models.AuditActionCreate, nil, warehouse)
+	if err := h.repo.CreateWarehouse(ctx, warehouse); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate warehouse code", "A warehouse with this code already exists")
 			return
 		}
 
 		log.Printf("CreateWarehouse error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to create warehouse", "Database error")
+		storeErrorResponse(c, err, "Failed to create warehouse", "Database error")
 		return
 	}
 
@@ -108,10 +120,10 @@ func (h *WarehouseHandler) CreateWarehouse(c *gin.Context) {
 }
 
 func (h *WarehouseHandler) GetAllWarehouses(c *gin.Context) {
-	warehouses, err := h.repo.GetAllWarehouses()
+	warehouses, err := h.repo.GetAllWarehouses(c.Request.Context())
 	if err != nil {
 		log.Printf("GetAllWarehouses error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve warehouses", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve warehouses", "Database error")
 		return
 	}
 
@@ -120,10 +132,10 @@ func (h *WarehouseHandler) GetAllWarehouses(c *gin.Context) {
 
 func (h *WarehouseHandler) GetWarehouseByID(c *gin.Context) {
 	id := c.Param("id")
-	warehouse, err := h.repo.GetWarehouseByID(id)
+	warehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("GetWarehouseByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve warehouse", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve warehouse", "Database error")
 		return
 	}
 	if warehouse == nil {
@@ -143,10 +155,10 @@ func (h *WarehouseHandler) UpdateWarehouse(c *gin.Context) {
 	}
 
 	// Get existing warehouse
-	warehouse, err := h.repo.GetWarehouseByID(id)
+	warehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("UpdateWarehouse - GetWarehouseByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve warehouse", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve warehouse", "Database error")
 		return
 	}
 
@@ -154,6 +166,7 @@ func (h *WarehouseHandler) UpdateWarehouse(c *gin.Context) {
 		utils.NotFoundResponse(c, "Warehouse not found")
 		return
 	}
+	before := *warehouse
 
 	// Update fields only if provided
 	updatedFields := []string{}
@@ -185,6 +198,14 @@ func (h *WarehouseHandler) UpdateWarehouse(c *gin.Context) {
 		warehouse.Capacity = req.Capacity
 		updatedFields = append(updatedFields, "capacity")
 	}
+	if req.Latitude != nil && (warehouse.Latitude == nil || *req.Latitude != *warehouse.Latitude) {
+		warehouse.Latitude = req.Latitude
+		updatedFields = append(updatedFields, "latitude")
+	}
+	if req.Longitude != nil && (warehouse.Longitude == nil || *req.Longitude != *warehouse.Longitude) {
+		warehouse.Longitude = req.Longitude
+		updatedFields = append(updatedFields, "longitude")
+	}
 	if req.Status != "" && req.Status != warehouse.Status {
 		warehouse.Status = req.Status
 		updatedFields = append(updatedFields, "status")
@@ -198,23 +219,23 @@ func (h *WarehouseHandler) UpdateWarehouse(c *gin.Context) {
 
 	warehouse.UpdatedAt = time.Now()
 
-	if err := h.repo.UpdateWarehouse(warehouse); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") {
+	ctx := auditContext(c, models.AuditEntityWarehouse, id, models.AuditActionUpdate, &before, warehouse)
+	if err := h.repo.UpdateWarehouse(ctx, warehouse); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate warehouse code", "A warehouse with this code already exists")
 			return
 		}
 
 		log.Printf("UpdateWarehouse error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to update warehouse", "Database error")
+		storeErrorResponse(c, err, "Failed to update warehouse", "Database error")
 		return
 	}
 
 	// Get updated warehouse
-	updatedWarehouse, err := h.repo.GetWarehouseByID(id)
+	updatedWarehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("UpdateWarehouse - Get updated warehouse error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve updated warehouse", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve updated warehouse", "Database error")
 		return
 	}
 
@@ -228,10 +249,10 @@ func (h *WarehouseHandler) UpdateWarehouse(c *gin.Context) {
 
 func (h *WarehouseHandler) DeleteWarehouse(c *gin.Context) {
 	id := c.Param("id")
-	warehouse, err := h.repo.GetWarehouseByID(id)
+	warehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("DeleteWarehouse - GetWarehouseByID error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve warehouse", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve warehouse", "Database error")
 		return
 	}
 	if warehouse == nil {
@@ -239,9 +260,10 @@ func (h *WarehouseHandler) DeleteWarehouse(c *gin.Context) {
 		return
 	}
 
-	if err := h.repo.DeleteWarehouse(id); err != nil {
+	ctx := auditContext(c, models.AuditEntityWarehouse, id, models.AuditActionDelete, warehouse, nil)
+	if err := h.repo.DeleteWarehouse(ctx, id); err != nil {
 		log.Printf("DeleteWarehouse error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to delete warehouse", "Database error")
+		storeErrorResponse(c, err, "Failed to delete warehouse", "Database error")
 		return
 	}
 
@@ -264,7 +286,7 @@ func (h *WarehouseHandler) CreateLocation(c *gin.Context) {
 	}
 
 	// Verify warehouse exists
-	warehouse, err := h.repo.GetWarehouseByID(warehouseID)
+	warehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), warehouseID)
 	if err != nil || warehouse == nil {
 		utils.NotFoundResponse(c, "Warehouse not found")
 		return
@@ -280,15 +302,15 @@ func (h *WarehouseHandler) CreateLocation(c *gin.Context) {
 		req.MaxCapacity,
 	)
 
-	if err := h.repo.CreateLocation(location); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") {
+	ctx := auditContext(c, models.AuditEntityLocation, location.ID, models.AuditActionCreate, nil, location)
+	if err := h.repo.CreateLocation(ctx, location); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate location code", "A location with this code already exists in this warehouse")
 			return
 		}
 
 		log.Printf("CreateLocation error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to create location", "Database error")
+		storeErrorResponse(c, err, "Failed to create location", "Database error")
 		return
 	}
 
@@ -299,16 +321,16 @@ func (h *WarehouseHandler) GetWarehouseLocations(c *gin.Context) {
 	warehouseID := c.Param("id")
 
 	// Verify warehouse exists
-	warehouse, err := h.repo.GetWarehouseByID(warehouseID)
+	warehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), warehouseID)
 	if err != nil || warehouse == nil {
 		utils.NotFoundResponse(c, "Warehouse not found")
 		return
 	}
 
-	locations, err := h.repo.GetLocationsByWarehouse(warehouseID)
+	locations, err := h.repo.GetLocationsByWarehouse(c.Request.Context(), warehouseID)
 	if err != nil {
 		log.Printf("GetWarehouseLocations error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve warehouse locations", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve warehouse locations", "Database error")
 		return
 	}
 
@@ -325,16 +347,16 @@ func (h *WarehouseHandler) GetAvailableLocations(c *gin.Context) {
 	warehouseID := c.Param("id")
 
 	// Verify warehouse exists
-	warehouse, err := h.repo.GetWarehouseByID(warehouseID)
+	warehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), warehouseID)
 	if err != nil || warehouse == nil {
 		utils.NotFoundResponse(c, "Warehouse not found")
 		return
 	}
 
-	locations, err := h.repo.GetAvailableLocations(warehouseID)
+	locations, err := h.repo.GetAvailableLocations(c.Request.Context(), warehouseID)
 	if err != nil {
 		log.Printf("GetAvailableLocations error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve available locations", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve available locations", "Database error")
 		return
 	}
 
@@ -358,7 +380,7 @@ func (h *WarehouseHandler) CreateInventory(c *gin.Context) {
 	}
 
 	// Verify warehouse exists
-	warehouse, err := h.repo.GetWarehouseByID(warehouseID)
+	warehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), warehouseID)
 	if err != nil || warehouse == nil {
 		utils.NotFoundResponse(c, "Warehouse not found")
 		return
@@ -384,15 +406,25 @@ func (h *WarehouseHandler) CreateInventory(c *gin.Context) {
 		minQuantity,
 	)
 
-	if err := h.repo.CreateInventory(inventory); err != nil {
-		if strings.Contains(err.Error(), "duplicate key value") ||
-			strings.Contains(err.Error(), "violates unique constraint") {
+	ctx := auditContext(c, models.AuditEntityInventory, inventory.ID, models.AuditActionCreate, nil, inventory)
+	if err := h.repo.CreateInventory(ctx, inventory, currentUsername(c)); err != nil {
+		if isUniqueViolation(err) {
 			utils.DuplicateErrorResponse(c, "Duplicate inventory", "Inventory for this product already exists in this location")
 			return
 		}
+		var stockErr *repositories.InsufficientStockError
+		if errors.As(err, &stockErr) {
+			// Initial stock has to come from product stock no warehouse holds yet
+			utils.BadRequestResponse(c, "Insufficient unplaced stock", map[string]interface{}{
+				"product_id": stockErr.ProductID,
+				"available":  stockErr.Available,
+				"requested":  stockErr.Requested,
+			})
+			return
+		}
 
 		log.Printf("CreateInventory error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to create inventory", "Database error")
+		storeErrorResponse(c, err, "Failed to create inventory", "Database error")
 		return
 	}
 
@@ -403,16 +435,16 @@ func (h *WarehouseHandler) GetWarehouseInventory(c *gin.Context) {
 	warehouseID := c.Param("id")
 
 	// Verify warehouse exists
-	warehouse, err := h.repo.GetWarehouseByID(warehouseID)
+	warehouse, err := h.repo.GetWarehouseByID(c.Request.Context(), warehouseID)
 	if err != nil || warehouse == nil {
 		utils.NotFoundResponse(c, "Warehouse not found")
 		return
 	}
 
-	inventory, err := h.repo.GetInventoryByWarehouse(warehouseID)
+	inventory, err := h.repo.GetInventoryByWarehouse(c.Request.Context(), warehouseID)
 	if err != nil {
 		log.Printf("GetWarehouseInventory error: %v", err)
-		utils.InternalErrorResponse(c, "Failed to retrieve warehouse inventory", "Database error")
+		storeErrorResponse(c, err, "Failed to retrieve warehouse inventory", "Database error")
 		return
 	}
 
@@ -447,11 +479,118 @@ func (h *WarehouseHandler) UpdateInventory(c *gin.Context) {
 		return
 	}
 
-	// Note: For simplicity, we're just updating quantity here
-	// In a real app, you'd have more complex inventory management
+	if req.Quantity == nil && req.MinQuantity == nil && req.MaxQuantity == nil {
+		utils.ValidationErrorResponse(c, "Validation error", "Provide quantity, min_quantity or max_quantity")
+		return
+	}
+	if req.Quantity != nil && req.ReasonCode == "" {
+		utils.ValidationErrorResponse(c, "Validation error", "reason_code is required when adjusting quantity")
+		return
+	}
+
+	inventory, err := h.repo.GetInventoryByID(c.Request.Context(), inventoryID)
+	if err != nil {
+		log.Printf("UpdateInventory - GetInventoryByID error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve inventory", "Database error")
+		return
+	}
+	if inventory == nil {
+		utils.NotFoundResponse(c, "Inventory not found")
+		return
+	}
+
+	// Resolve the reorder levels up front so a bad pair fails before stock is touched
+	minQuantity := inventory.MinQuantity
+	if req.MinQuantity != nil {
+		minQuantity = *req.MinQuantity
+	}
+	maxQuantity := inventory.MaxQuantity
+	if req.MaxQuantity != nil {
+		maxQuantity = req.MaxQuantity
+	}
+	if maxQuantity != nil && *maxQuantity < minQuantity {
+		utils.ValidationErrorResponse(c, "Validation error", "max_quantity cannot be less than min_quantity")
+		return
+	}
+
+	updatedFields := []string{}
+	var movement *models.StockMovement
+
+	if req.Quantity != nil {
+		mode := req.Mode
+		if mode == "" {
+			mode = models.AdjustmentModeSet
+		}
+
+		// The audit entry is completed with the row as the adjustment leaves it
+		ctx := auditContext(c, models.AuditEntityInventory, inventoryID, models.AuditActionUpdate, inventory, nil)
+		movement, err = h.repo.AdjustInventory(ctx, &models.InventoryAdjustment{
+			InventoryID: inventoryID,
+			Mode:        mode,
+			Quantity:    *req.Quantity,
+			ReasonCode:  req.ReasonCode,
+			Note:        req.Note,
+			AdjustedBy:  currentUsername(c),
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, repositories.ErrInventoryNotFound):
+				utils.NotFoundResponse(c, "Inventory not found")
+			case errors.Is(err, repositories.ErrInvalidAdjustment),
+				errors.Is(err, repositories.ErrBelowReservedQuantity),
+				errors.Is(err, repositories.ErrLocationCapacityExceeded),
+				errors.Is(err, repositories.ErrInsufficientStock):
+				utils.BadRequestResponse(c, "Invalid inventory adjustment", err.Error())
+			default:
+				log.Printf("UpdateInventory - AdjustInventory error: %v", err)
+				storeErrorResponse(c, err, "Failed to adjust inventory", "Database error")
+			}
+			return
+		}
+		if movement != nil {
+			updatedFields = append(updatedFields, "quantity")
+		}
+		updatedFields = append(updatedFields, "last_checked")
+	}
+
+	if req.MinQuantity != nil || req.MaxQuantity != nil {
+		// Audited as a change of its own, starting from the row as any adjustment left it
+		before := inventory
+		if req.Quantity != nil {
+			if before, err = h.repo.GetInventoryByID(c.Request.Context(), inventoryID); err != nil {
+				log.Printf("UpdateInventory - GetInventoryByID error: %v", err)
+				storeErrorResponse(c, err, "Failed to retrieve inventory", "Database error")
+				return
+			}
+		}
+		ctx := auditContext(c, models.AuditEntityInventory, inventoryID, models.AuditActionUpdate, before, nil)
+		if err := h.repo.UpdateInventoryLevels(ctx, inventoryID, minQuantity, maxQuantity); err != nil {
+			log.Printf("UpdateInventory - UpdateInventoryLevels error: %v", err)
+			storeErrorResponse(c, err, "Failed to update inventory levels", "Database error")
+			return
+		}
+		if req.MinQuantity != nil {
+			updatedFields = append(updatedFields, "min_quantity")
+		}
+		if req.MaxQuantity != nil {
+			updatedFields = append(updatedFields, "max_quantity")
+		}
+	}
+
+	updatedInventory, err := h.repo.GetInventoryByID(c.Request.Context(), inventoryID)
+	if err != nil {
+		log.Printf("UpdateInventory - Get updated inventory error: %v", err)
+		storeErrorResponse(c, err, "Failed to retrieve updated inventory", "Database error")
+		return
+	}
+
+	responseData := map[string]interface{}{
+		"inventory":      updatedInventory,
+		"updated_fields": updatedFields,
+	}
+	if movement != nil {
+		responseData["movement"] = movement
+	}
 
-	utils.SuccessResponse(c, "Inventory update endpoint (to be implemented)", map[string]interface{}{
-		"inventory_id": inventoryID,
-		"request":      req,
-	})
+	utils.SuccessResponse(c, "Inventory updated successfully", responseData)
 }
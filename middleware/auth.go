@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"erp-project/database"
+	"erp-project/models"
+	"erp-project/repositories"
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Authentication methods recorded in the request context
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "api_key"
+)
+
+// Context keys set by Authenticate
+const (
+	contextUserKey       = "auth_user"
+	contextAuthMethodKey = "auth_method"
+)
+
+// APIKeyHeader carries an API key; JWTs go in the Authorization header as Bearer tokens
+const APIKeyHeader = "X-API-Key"
+
+// Authenticate rejects requests without a valid access token or API key and stores
+// the authenticated user in the context. Inactive users are rejected either way.
+func Authenticate(tokens *utils.TokenManager, userRepo repositories.UserStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user *models.User
+		var method string
+
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			apiKey, err := userRepo.GetAPIKeyByHash(c.Request.Context(), utils.HashAPIKey(key))
+			if err != nil {
+				log.Printf("Authenticate - GetAPIKeyByHash error: %v", err)
+				abortStoreError(c, err)
+				return
+			}
+			if apiKey == nil || !apiKey.IsUsable(time.Now()) {
+				utils.UnauthorizedResponse(c, "Invalid or revoked API key")
+				c.Abort()
+				return
+			}
+
+			user, err = userRepo.GetUserByID(c.Request.Context(), apiKey.UserID)
+			if err != nil {
+				log.Printf("Authenticate - GetUserByID error: %v", err)
+				abortStoreError(c, err)
+				return
+			}
+			if err := userRepo.RecordAPIKeyUse(c.Request.Context(), apiKey.ID); err != nil {
+				log.Printf("Authenticate - RecordAPIKeyUse error: %v", err)
+			}
+			method = AuthMethodAPIKey
+		} else {
+			header := c.GetHeader("Authorization")
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok || token == "" {
+				utils.UnauthorizedResponse(c, "Authentication required")
+				c.Abort()
+				return
+			}
+
+			claims, err := tokens.ParseToken(token, utils.TokenTypeAccess)
+			if err != nil {
+				utils.UnauthorizedResponse(c, "Invalid or expired token")
+				c.Abort()
+				return
+			}
+
+			user, err = userRepo.GetUserByID(c.Request.Context(), claims.Subject)
+			if err != nil {
+				log.Printf("Authenticate - GetUserByID error: %v", err)
+				abortStoreError(c, err)
+				return
+			}
+			method = AuthMethodJWT
+		}
+
+		if user == nil || !user.IsActive() {
+			utils.UnauthorizedResponse(c, "User account is disabled")
+			c.Abort()
+			return
+		}
+
+		c.Set(contextUserKey, user)
+		c.Set(contextAuthMethodKey, method)
+		c.Next()
+	}
+}
+
+// abortStoreError stops a request whose user lookup failed, telling a timeout apart
+// from other database errors
+func abortStoreError(c *gin.Context, err error) {
+	if database.IsTimeout(err) {
+		utils.TimeoutResponse(c, "Failed to authenticate")
+	} else {
+		utils.InternalErrorResponse(c, "Failed to authenticate", "Database error")
+	}
+	c.Abort()
+}
+
+// CurrentUser returns the user authenticated for this request, or nil on public routes
+func CurrentUser(c *gin.Context) *models.User {
+	if value, ok := c.Get(contextUserKey); ok {
+		if user, ok := value.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// AuthMethod returns how the current request was authenticated
+func AuthMethod(c *gin.Context) string {
+	return c.GetString(contextAuthMethodKey)
+}
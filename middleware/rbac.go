@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"erp-project/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission rejects requests whose user has no role granting the permission.
+// It must run after Authenticate.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasPermission(c, permission) {
+			utils.ForbiddenResponse(c, "Permission denied", map[string]string{
+				"required_permission": permission,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasPermission reports whether the current user holds a permission, for handlers
+// that need finer checks than their route
+func HasPermission(c *gin.Context, permission string) bool {
+	user := CurrentUser(c)
+	return user != nil && user.HasPermission(permission)
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// AgingBuckets splits amounts owed by how many days they are past due
+type AgingBuckets struct {
+	Current    Money `json:"current"` // Not yet due
+	Days1To30  Money `json:"days_1_30"`
+	Days31To60 Money `json:"days_31_60"`
+	Days61To90 Money `json:"days_61_90"`
+	Over90     Money `json:"days_over_90"`
+	Total      Money `json:"total"`
+}
+
+// ReceivablesAgingLine is one customer's row of the accounts receivable aging report.
+// Amounts are in the base currency.
+type ReceivablesAgingLine struct {
+	CustomerID   string `json:"customer_id"`
+	CustomerName string `json:"customer_name"`
+	AgingBuckets
+	Invoices          int   `json:"invoices"`           // Open invoices
+	UnallocatedCredit Money `json:"unallocated_credit"` // Unallocated payments and overpaid invoices
+	Balance           Money `json:"balance"`            // Total less credit
+}
+
+// PayablesAgingLine is one supplier's row of the accounts payable aging report.
+// Amounts are in the base currency.
+type PayablesAgingLine struct {
+	SupplierID   string `json:"supplier_id"`
+	SupplierName string `json:"supplier_name"`
+	AgingBuckets
+	Bills int `json:"bills"` // Open bills
+}
+
+// DaysPastDue counts the whole days from the due date to on; zero or less means the
+// amount is not yet overdue
+func DaysPastDue(due, on time.Time) int {
+	return int(RateDate(on).Sub(RateDate(due)).Hours() / 24)
+}
+
+// Add puts an amount due on a day into its bucket as of on
+func (b *AgingBuckets) Add(due, on time.Time, amount Money) {
+	switch days := DaysPastDue(due, on); {
+	case days <= 0:
+		b.Current += amount
+	case days <= 30:
+		b.Days1To30 += amount
+	case days <= 60:
+		b.Days31To60 += amount
+	case days <= 90:
+		b.Days61To90 += amount
+	default:
+		b.Over90 += amount
+	}
+	b.Total += amount
+}
+
+// AddBuckets adds another set of buckets to these, for report totals
+func (b *AgingBuckets) AddBuckets(other AgingBuckets) {
+	b.Current += other.Current
+	b.Days1To30 += other.Days1To30
+	b.Days31To60 += other.Days31To60
+	b.Days61To90 += other.Days61To90
+	b.Over90 += other.Over90
+	b.Total += other.Total
+}
+
+// SortReceivablesAging orders aging rows by customer name
+func SortReceivablesAging(lines []ReceivablesAgingLine) {
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := strings.ToLower(lines[i].CustomerName), strings.ToLower(lines[j].CustomerName)
+		if a != b {
+			return a < b
+		}
+		return lines[i].CustomerID < lines[j].CustomerID
+	})
+}
+
+// SortPayablesAging orders aging rows by supplier name
+func SortPayablesAging(lines []PayablesAgingLine) {
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := strings.ToLower(lines[i].SupplierName), strings.ToLower(lines[j].SupplierName)
+		if a != b {
+			return a < b
+		}
+		return lines[i].SupplierID < lines[j].SupplierID
+	})
+}
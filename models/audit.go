@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audited entity types
+const (
+	AuditEntityProduct         = "product"
+	AuditEntityCustomer        = "customer"
+	AuditEntitySupplier        = "supplier"
+	AuditEntityProductSupplier = "product_supplier"
+	AuditEntityWarehouse       = "warehouse"
+	AuditEntityLocation        = "location"
+	AuditEntityInventory       = "inventory"
+	AuditEntityPriceList       = "price_list"
+	AuditEntityPromotion       = "promotion"
+	AuditEntityCoupon          = "coupon"
+	AuditEntityTaxRate         = "tax_rate"
+)
+
+// Audit actions
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// auditIgnoredFields change on every write and would only add noise to a diff
+var auditIgnoredFields = map[string]bool{
+	"updated_at": true,
+}
+
+// FieldChange is one field's value before and after a mutation. Before is null for
+// created entities and After is null for deleted ones.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditLog records who changed which entity, how, and what each field was before and after
+type AuditLog struct {
+	ID         string                 `json:"id"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   string                 `json:"entity_id"`
+	Action     string                 `json:"action"`
+	ActorID    *string                `json:"actor_id,omitempty"`
+	ActorName  string                 `json:"actor_name,omitempty"`
+	Changes    map[string]FieldChange `json:"changes"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// NewAuditLog builds an entry from the entity as it was before and after the action;
+// pass nil for the side that does not exist. The actor may be nil.
+func NewAuditLog(entityType, entityID, action string, actor *User, before, after interface{}) (*AuditLog, error) {
+	changes, err := DiffFields(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &AuditLog{
+		ID:         uuid.New().String(),
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Changes:    changes,
+		CreatedAt:  time.Now(),
+	}
+	if actor != nil {
+		entry.ActorID = &actor.ID
+		entry.ActorName = actor.Username
+	}
+	return entry, nil
+}
+
+// DiffFields compares two values by their JSON fields and returns the ones that differ
+func DiffFields(before, after interface{}) (map[string]FieldChange, error) {
+	beforeFields, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]FieldChange{}
+	for name, value := range beforeFields {
+		if !auditIgnoredFields[name] && !reflect.DeepEqual(value, afterFields[name]) {
+			changes[name] = FieldChange{Before: value, After: afterFields[name]}
+		}
+	}
+	for name, value := range afterFields {
+		if _, seen := beforeFields[name]; !seen && !auditIgnoredFields[name] && value != nil {
+			changes[name] = FieldChange{Before: nil, After: value}
+		}
+	}
+	return changes, nil
+}
+
+func jsonFields(value interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if value == nil {
+		return fields, nil
+	}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
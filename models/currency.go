@@ -0,0 +1,109 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency and the rule for rounding amounts in it
+type Currency struct {
+	Code       string `json:"code"`
+	MinorUnits int    `json:"minor_units"` // Decimal places amounts are rounded to
+}
+
+// currencies lists the currencies amounts can be rounded in. Most use cents; a few have
+// no minor unit at all and a few use thousandths.
+var currencies = map[string]Currency{
+	"AUD": {Code: "AUD", MinorUnits: 2},
+	"BHD": {Code: "BHD", MinorUnits: 3},
+	"CAD": {Code: "CAD", MinorUnits: 2},
+	"CHF": {Code: "CHF", MinorUnits: 2},
+	"CNY": {Code: "CNY", MinorUnits: 2},
+	"DKK": {Code: "DKK", MinorUnits: 2},
+	"EUR": {Code: "EUR", MinorUnits: 2},
+	"GBP": {Code: "GBP", MinorUnits: 2},
+	"HKD": {Code: "HKD", MinorUnits: 2},
+	"IDR": {Code: "IDR", MinorUnits: 2},
+	"INR": {Code: "INR", MinorUnits: 2},
+	"JOD": {Code: "JOD", MinorUnits: 3},
+	"JPY": {Code: "JPY", MinorUnits: 0},
+	"KRW": {Code: "KRW", MinorUnits: 0},
+	"KWD": {Code: "KWD", MinorUnits: 3},
+	"MXN": {Code: "MXN", MinorUnits: 2},
+	"NOK": {Code: "NOK", MinorUnits: 2},
+	"NZD": {Code: "NZD", MinorUnits: 2},
+	"OMR": {Code: "OMR", MinorUnits: 3},
+	"SEK": {Code: "SEK", MinorUnits: 2},
+	"SGD": {Code: "SGD", MinorUnits: 2},
+	"USD": {Code: "USD", MinorUnits: 2},
+	"VND": {Code: "VND", MinorUnits: 0},
+	"ZAR": {Code: "ZAR", MinorUnits: 2},
+}
+
+// BaseCurrency is the currency exchange rates are quoted against and order totals are
+// reported in. Amounts stored before currencies were recorded are in USD, so change it
+// (see SetBaseCurrency) only on a fresh database.
+var BaseCurrency = currencies["USD"]
+
+// SetBaseCurrency changes the base currency at startup
+func SetBaseCurrency(code string) error {
+	currency, ok := LookupCurrency(code)
+	if !ok {
+		return fmt.Errorf("unsupported base currency %q", code)
+	}
+	BaseCurrency = currency
+	return nil
+}
+
+// LookupCurrency finds a currency by its ISO 4217 code, ignoring case
+func LookupCurrency(code string) (Currency, bool) {
+	currency, ok := currencies[strings.ToUpper(code)]
+	return currency, ok
+}
+
+// CurrencyOf returns the currency with the given code, or the base currency when the
+// code is empty or unknown, which is what amounts recorded without a currency are in
+func CurrencyOf(code string) Currency {
+	if currency, ok := LookupCurrency(code); ok {
+		return currency
+	}
+	return BaseCurrency
+}
+
+// Currencies returns every supported currency, ordered by code
+func Currencies() []Currency {
+	list := make([]Currency, 0, len(currencies))
+	for _, currency := range currencies {
+		list = append(list, currency)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+// Round rounds an amount to the currency's minor unit, halves away from zero, so
+// 0.125 USD becomes 0.13 and -0.125 USD becomes -0.13
+func (c Currency) Round(m Money) Money {
+	if c.MinorUnits >= moneyScale {
+		return m
+	}
+	step := Money(1)
+	for i := c.MinorUnits; i < moneyScale; i++ {
+		step *= 10
+	}
+
+	remainder := m % step
+	rounded := m - remainder
+	switch {
+	case remainder*2 >= step:
+		rounded += step
+	case remainder*2 <= -step:
+		rounded -= step
+	}
+	return rounded
+}
+
+// IsRounded reports whether the amount needs no rounding in this currency
+func (c Currency) IsRounded(m Money) bool {
+	return c.Round(m) == m
+}
@@ -12,8 +12,21 @@ type Customer struct {
 	Email     string    `json:"email"`
 	Phone     string    `json:"phone"`
 	Address   string    `json:"address"`
+	Group     string    `json:"customer_group"` // Shares the group's price lists
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// Where the customer is, which decides how its orders are taxed
+	Country    string `json:"country"` // ISO 3166-1 alpha-2
+	Region     string `json:"region"`  // State or province code
+	PostalCode string `json:"postal_code"`
+
+	// A tax exempt customer is not charged tax while its exemption certificate is valid
+	TaxExempt             bool       `json:"tax_exempt"`
+	TaxExemptionNumber    string     `json:"tax_exemption_number,omitempty"`
+	TaxExemptionExpiresAt *time.Time `json:"tax_exemption_expires_at,omitempty"`
+
+	PaymentTermsDays int `json:"payment_terms_days"` // Days from the invoice date to pay
 }
 
 func NewCustomer(name, email, phone, address string) *Customer {
@@ -25,5 +38,7 @@ func NewCustomer(name, email, phone, address string) *Customer {
 		Address:   address,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
+
+		PaymentTermsDays: DefaultPaymentTermsDays,
 	}
 }
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"erp-project/database"
+
+	"github.com/google/uuid"
+)
+
+// Exchange rate sources
+const (
+	ExchangeRateSourceAPI = "api"
+	ExchangeRateSourceCSV = "csv"
+)
+
+// Rate is an exact exchange rate with eight decimal places, held as a whole number of
+// hundred-millionths. Like Money it is DECIMAL on PostgreSQL, an INTEGER on SQLite and
+// a plain decimal number in JSON.
+type Rate int64
+
+const (
+	rateScale = 8
+
+	// maxRateDigits keeps parsed rates well inside int64 once scaled
+	maxRateDigits = 10
+)
+
+// RateOne is the rate of a currency to itself
+const RateOne Rate = 100000000
+
+var ErrInvalidRate = errors.New("invalid exchange rate")
+
+// ExchangeRate is what one unit of Currency was worth in BaseCurrency from
+// EffectiveDate until the next rate for it takes effect
+type ExchangeRate struct {
+	ID            string    `json:"id"`
+	BaseCurrency  string    `json:"base_currency"`
+	Currency      string    `json:"currency"`
+	Rate          Rate      `json:"rate"`
+	EffectiveDate time.Time `json:"effective_date"`
+	Source        string    `json:"source"` // api, csv
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func NewExchangeRate(currency string, rate Rate, effectiveDate time.Time, source, createdBy string) *ExchangeRate {
+	return &ExchangeRate{
+		ID:            uuid.New().String(),
+		BaseCurrency:  BaseCurrency.Code,
+		Currency:      currency,
+		Rate:          rate,
+		EffectiveDate: RateDate(effectiveDate),
+		Source:        source,
+		CreatedBy:     createdBy,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// RateDate is the day a rate applies to: midnight UTC at the start of the given time's
+// calendar day. Rates change at most once a day.
+func RateDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// ParseRate reads a positive decimal rate such as "1.0825" or "0.00673". More than eight
+// decimal places is an error rather than being rounded away.
+func ParseRate(s string) (Rate, error) {
+	units, err := parseFixed(s, rateScale, maxRateDigits, ErrInvalidRate)
+	if err != nil {
+		return 0, err
+	}
+	if units <= 0 {
+		return 0, fmt.Errorf("%w: %q is not positive", ErrInvalidRate, s)
+	}
+	return Rate(units), nil
+}
+
+// MustParseRate is ParseRate for rates known to be valid, such as constants
+func MustParseRate(s string) Rate {
+	r, err := ParseRate(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// ToBase converts an amount in the rate's currency into the base currency
+func (r Rate) ToBase(amount Money) Money {
+	return ConvertMoney(amount, r, RateOne)
+}
+
+// ConvertMoney converts an amount between two currencies given the rate of each to the
+// base currency. The result is exact to Money's four decimal places, halves rounded away
+// from zero; round it to the target currency where a pricing rule says so.
+func ConvertMoney(amount Money, from, to Rate) Money {
+	if from == to {
+		return amount
+	}
+	return Money(mulDivRound(int64(amount), int64(from), int64(to)))
+}
+
+func (r Rate) String() string {
+	return trimFixed(formatFixed(int64(r), rateScale))
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	parsed, err := ParseRate(jsonDecimalText(data))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// Scan reads DECIMAL text from PostgreSQL and whole hundred-millionths from SQLite
+func (r *Rate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*r = Rate(v)
+	case float64:
+		*r = Rate(math.Round(v))
+	case []byte:
+		return r.scanText(string(v))
+	case string:
+		return r.scanText(v)
+	default:
+		return fmt.Errorf("%w: cannot scan %T into Rate", ErrInvalidRate, src)
+	}
+	return nil
+}
+
+func (r *Rate) scanText(text string) error {
+	units, err := parseFixed(text, rateScale, maxRateDigits, ErrInvalidRate)
+	if err != nil {
+		return err
+	}
+	*r = Rate(units)
+	return nil
+}
+
+// Value stores the rate as decimal text, which every SQL database can convert
+func (r Rate) Value() (driver.Value, error) {
+	return formatFixed(int64(r), rateScale), nil
+}
+
+// DialectValue stores the rate in the column type the dialect uses for rates
+func (r Rate) DialectValue(dialect database.Dialect) driver.Value {
+	if dialect == database.SQLite {
+		return int64(r)
+	}
+	return formatFixed(int64(r), rateScale)
+}
@@ -0,0 +1,308 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invoice types. A credit note takes back all or part of one invoice.
+const (
+	InvoiceTypeInvoice    = "invoice"
+	InvoiceTypeCreditNote = "credit_note"
+)
+
+// Invoice statuses. Drafts have no number yet; issued invoices move between issued,
+// partially_paid and paid as payments and credit notes settle them.
+const (
+	InvoiceStatusDraft         = "draft"
+	InvoiceStatusIssued        = "issued"
+	InvoiceStatusPartiallyPaid = "partially_paid"
+	InvoiceStatusPaid          = "paid"
+	InvoiceStatusVoid          = "void"
+)
+
+// DefaultPaymentTermsDays is how long customers have to pay unless agreed otherwise
+const DefaultPaymentTermsDays = 30
+
+// invoiceNumberPrefixes start the numbers of each type of invoice
+var invoiceNumberPrefixes = map[string]string{
+	InvoiceTypeInvoice:    "INV-",
+	InvoiceTypeCreditNote: "CN-",
+}
+
+// Invoice bills a customer for shipped order lines, or credits them back. Amounts are
+// in the order's currency; a credit note's amounts are positive and reduce what is due
+// on the invoice it credits.
+type Invoice struct {
+	ID                string     `json:"id"`
+	InvoiceNumber     string     `json:"invoice_number,omitempty"` // Assigned when issued, without gaps
+	Type              string     `json:"type"`
+	Status            string     `json:"status"`
+	OrderID           string     `json:"order_id"`
+	CustomerID        string     `json:"customer_id"`
+	CreditedInvoiceID *string    `json:"credited_invoice_id,omitempty"` // Credit notes only
+	Currency          string     `json:"currency"`
+	ExchangeRate      Rate       `json:"exchange_rate"` // The order's
+	PaymentTermsDays  int        `json:"payment_terms_days"`
+	InvoiceDate       *time.Time `json:"invoice_date,omitempty"` // Set when issued
+	DueDate           *time.Time `json:"due_date,omitempty"`     // Invoice date plus the payment terms
+
+	// Totals of the lines; TotalAmount includes TaxAmount
+	SubtotalAmount  Money `json:"subtotal_amount"`
+	TaxAmount       Money `json:"tax_amount"`
+	TotalAmount     Money `json:"total_amount"`
+	BaseTotalAmount Money `json:"base_total_amount"`
+
+	// What has settled an invoice so far, and what is left to pay
+	AmountCredited Money `json:"amount_credited"`
+	AmountPaid     Money `json:"amount_paid"`
+	BalanceDue     Money `json:"balance_due"`
+
+	// How the order was taxed
+	PricesIncludeTax   bool   `json:"prices_include_tax"`
+	TaxCountry         string `json:"tax_country"`
+	TaxRegion          string `json:"tax_region"`
+	TaxExempt          bool   `json:"tax_exempt"`
+	TaxExemptionNumber string `json:"tax_exemption_number,omitempty"`
+
+	Notes      string     `json:"notes"`
+	Reason     string     `json:"reason,omitempty"` // Why a credit note was raised
+	VoidReason string     `json:"void_reason,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	IssuedBy   string     `json:"issued_by,omitempty"`
+	IssuedAt   *time.Time `json:"issued_at,omitempty"`
+	VoidedBy   string     `json:"voided_by,omitempty"`
+	VoidedAt   *time.Time `json:"voided_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	Lines []InvoiceLine `json:"lines,omitempty"`
+
+	// For joins
+	CustomerName          string `json:"customer_name,omitempty"`
+	CreditedInvoiceNumber string `json:"credited_invoice_number,omitempty"`
+}
+
+// InvoiceLine bills some units of an order line, or credits some units of an invoice
+// line. Its discount and tax are the units' exact share of the line they come from.
+type InvoiceLine struct {
+	ID               string  `json:"id"`
+	InvoiceID        string  `json:"invoice_id"`
+	OrderItemID      string  `json:"order_item_id"`
+	CreditedLineID   *string `json:"credited_line_id,omitempty"` // Credit note lines only
+	ProductID        string  `json:"product_id"`
+	Description      string  `json:"description"`
+	Quantity         int     `json:"quantity"`
+	CreditedQuantity int     `json:"credited_quantity"` // On credit notes that are not void
+	UnitPrice        Money   `json:"unit_price"`
+	DiscountAmount   Money   `json:"discount_amount"`
+	TaxCategory      string  `json:"tax_category"`
+	TaxRate          Money   `json:"tax_rate"` // Percent, as TaxRate.Rate
+	TaxAmount        Money   `json:"tax_amount"`
+	TotalPrice       Money   `json:"total_price"` // Including tax
+
+	// For joins
+	SKU string `json:"sku,omitempty"`
+}
+
+// NewInvoice starts a draft invoice for an order. Add lines with AddOrderLine.
+func NewInvoice(order *Order, paymentTermsDays int, notes, createdBy string) *Invoice {
+	now := time.Now()
+	return &Invoice{
+		ID:                 uuid.New().String(),
+		Type:               InvoiceTypeInvoice,
+		Status:             InvoiceStatusDraft,
+		OrderID:            order.ID,
+		CustomerID:         order.CustomerID,
+		Currency:           order.Currency,
+		ExchangeRate:       order.ExchangeRate,
+		PaymentTermsDays:   paymentTermsDays,
+		PricesIncludeTax:   order.PricesIncludeTax,
+		TaxCountry:         order.TaxCountry,
+		TaxRegion:          order.TaxRegion,
+		TaxExempt:          order.TaxExempt,
+		TaxExemptionNumber: order.TaxExemptionNumber,
+		Notes:              notes,
+		CreatedBy:          createdBy,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+}
+
+// NewCreditNote starts a draft credit note against an issued invoice. Add lines with
+// AddCreditLine.
+func NewCreditNote(invoice *Invoice, reason, createdBy string) *Invoice {
+	now := time.Now()
+	creditedID := invoice.ID
+	return &Invoice{
+		ID:                 uuid.New().String(),
+		Type:               InvoiceTypeCreditNote,
+		Status:             InvoiceStatusDraft,
+		OrderID:            invoice.OrderID,
+		CustomerID:         invoice.CustomerID,
+		CreditedInvoiceID:  &creditedID,
+		Currency:           invoice.Currency,
+		ExchangeRate:       invoice.ExchangeRate,
+		PricesIncludeTax:   invoice.PricesIncludeTax,
+		TaxCountry:         invoice.TaxCountry,
+		TaxRegion:          invoice.TaxRegion,
+		TaxExempt:          invoice.TaxExempt,
+		TaxExemptionNumber: invoice.TaxExemptionNumber,
+		Reason:             reason,
+		CreatedBy:          createdBy,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+}
+
+// Uninvoiced is how many shipped units of the line are not on an invoice yet
+func (i *OrderItem) Uninvoiced() int {
+	if n := i.ShippedQuantity - i.InvoicedQuantity; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// AddOrderLine bills quantity units of an order line that follow those already
+// invoiced. Billing a line in several invoices adds up exactly to the order line.
+func (inv *Invoice) AddOrderLine(item *OrderItem, quantity int) {
+	currency := CurrencyOf(inv.Currency)
+	fromDiscount, fromTax, fromTotal := item.unitsAmounts(item.InvoicedQuantity, currency)
+	toDiscount, toTax, toTotal := item.unitsAmounts(item.InvoicedQuantity+quantity, currency)
+
+	inv.Lines = append(inv.Lines, InvoiceLine{
+		ID:             uuid.New().String(),
+		InvoiceID:      inv.ID,
+		OrderItemID:    item.ID,
+		ProductID:      item.ProductID,
+		Description:    item.ProductName,
+		Quantity:       quantity,
+		UnitPrice:      item.UnitPrice,
+		DiscountAmount: toDiscount - fromDiscount,
+		TaxCategory:    item.TaxCategory,
+		TaxRate:        item.TaxRate,
+		TaxAmount:      toTax - fromTax,
+		TotalPrice:     toTotal - fromTotal,
+	})
+	inv.calculateTotals()
+}
+
+// AddCreditLine credits quantity units of an invoice line that follow those already
+// credited. Crediting every unit gives back exactly what the line billed.
+func (inv *Invoice) AddCreditLine(line *InvoiceLine, quantity int) {
+	share := func(amount Money, units int) Money {
+		if units == line.Quantity {
+			return amount
+		}
+		return CurrencyOf(inv.Currency).Round(amount.MulDiv(int64(units), int64(line.Quantity)))
+	}
+	from, to := line.CreditedQuantity, line.CreditedQuantity+quantity
+	creditedID := line.ID
+
+	inv.Lines = append(inv.Lines, InvoiceLine{
+		ID:             uuid.New().String(),
+		InvoiceID:      inv.ID,
+		OrderItemID:    line.OrderItemID,
+		CreditedLineID: &creditedID,
+		ProductID:      line.ProductID,
+		Description:    line.Description,
+		Quantity:       quantity,
+		UnitPrice:      line.UnitPrice,
+		DiscountAmount: share(line.DiscountAmount, to) - share(line.DiscountAmount, from),
+		TaxCategory:    line.TaxCategory,
+		TaxRate:        line.TaxRate,
+		TaxAmount:      share(line.TaxAmount, to) - share(line.TaxAmount, from),
+		TotalPrice:     share(line.TotalPrice, to) - share(line.TotalPrice, from),
+	})
+	inv.calculateTotals()
+}
+
+// Uncredited is how many units of the line are not on a credit note yet
+func (l *InvoiceLine) Uncredited() int {
+	if n := l.Quantity - l.CreditedQuantity; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// calculateTotals sums the lines into the invoice totals, which are exact sums of the
+// rounded line amounts
+func (inv *Invoice) calculateTotals() {
+	inv.TaxAmount, inv.TotalAmount = 0, 0
+	for _, line := range inv.Lines {
+		inv.TaxAmount += line.TaxAmount
+		inv.TotalAmount += line.TotalPrice
+	}
+	inv.SubtotalAmount = inv.TotalAmount - inv.TaxAmount
+	inv.BaseTotalAmount = BaseCurrency.Round(inv.ExchangeRate.ToBase(inv.TotalAmount))
+	inv.CalculateBalance()
+}
+
+// CalculateBalance works out what is left to pay. It is negative when the customer has
+// paid or been credited more than the invoice, and nothing is due on drafts, void
+// invoices or credit notes.
+func (inv *Invoice) CalculateBalance() {
+	inv.BalanceDue = 0
+	if inv.Type == InvoiceTypeInvoice && inv.Status != InvoiceStatusDraft && inv.Status != InvoiceStatusVoid {
+		inv.BalanceDue = inv.TotalAmount - inv.AmountCredited - inv.AmountPaid
+	}
+}
+
+// Settle moves an issued invoice to issued, partially_paid or paid according to what
+// has been paid and credited against it. Other invoices keep their status.
+func (inv *Invoice) Settle() {
+	inv.CalculateBalance()
+	if inv.Type != InvoiceTypeInvoice {
+		return
+	}
+	switch inv.Status {
+	case InvoiceStatusIssued, InvoiceStatusPartiallyPaid, InvoiceStatusPaid:
+	default:
+		return
+	}
+	switch {
+	case inv.BalanceDue <= 0:
+		inv.Status = InvoiceStatusPaid
+	case inv.AmountCredited+inv.AmountPaid > 0:
+		inv.Status = InvoiceStatusPartiallyPaid
+	default:
+		inv.Status = InvoiceStatusIssued
+	}
+}
+
+// Issue numbers the invoice and dates it, due after its payment terms
+func (inv *Invoice) Issue(number int64, on time.Time, issuedBy string) {
+	day := RateDate(on)
+	due := day.AddDate(0, 0, inv.PaymentTermsDays)
+	inv.InvoiceNumber = FormatInvoiceNumber(inv.Type, number)
+	inv.Status = InvoiceStatusIssued
+	inv.InvoiceDate = &day
+	inv.DueDate = &due
+	inv.IssuedBy = issuedBy
+	inv.IssuedAt = &on
+	inv.Settle()
+}
+
+// Overdue reports whether an unpaid invoice was due before on
+func (inv *Invoice) Overdue(on time.Time) bool {
+	return inv.Type == InvoiceTypeInvoice && inv.BalanceDue > 0 && inv.DueDate != nil && inv.DueDate.Before(RateDate(on))
+}
+
+// Voidable reports whether the invoice can still be voided: drafts always, and issued
+// invoices that nothing has been paid or credited against yet
+func (inv *Invoice) Voidable() bool {
+	switch inv.Status {
+	case InvoiceStatusDraft:
+		return true
+	case InvoiceStatusIssued:
+		return inv.AmountPaid == 0 && inv.AmountCredited == 0
+	}
+	return false
+}
+
+// FormatInvoiceNumber renders the nth number in an invoice type's sequence, e.g. INV-000042
+func FormatInvoiceNumber(invoiceType string, n int64) string {
+	return fmt.Sprintf("%s%06d", invoiceNumberPrefixes[invoiceType], n)
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartialInvoicesAddUpToTheOrderLine(t *testing.T) {
+	usd := BaseCurrency
+	rate := NewTaxRate("VAT", "GB", "", "", MustParseMoney("20"), time.Now(), nil, "")
+
+	// 3 x 9.99 = 29.97, less 2.00 off = 27.97, plus 5.59 tax = 33.56
+	item := NewOrderItem("", "p", 3, MustParseMoney("9.99"), usd)
+	item.DiscountAmount = MustParseMoney("2")
+	item.ApplyTax("", rate, false, usd)
+	order := NewOrder("c", item.TotalPrice)
+
+	if got := item.Uninvoiced(); got != 0 {
+		t.Errorf("uninvoiced before shipping = %d, want 0", got)
+	}
+	item.ShippedQuantity = 1
+	if got := item.Uninvoiced(); got != 1 {
+		t.Errorf("uninvoiced once partly shipped = %d, want 1", got)
+	}
+	item.ShippedQuantity = 3
+	if got := item.Uninvoiced(); got != 3 {
+		t.Errorf("uninvoiced once shipped = %d, want 3", got)
+	}
+
+	var discount, tax, total Money
+	for _, units := range []int{1, 2} {
+		invoice := NewInvoice(order, DefaultPaymentTermsDays, "", "")
+		invoice.AddOrderLine(item, units)
+		item.InvoicedQuantity += units
+
+		line := invoice.Lines[0]
+		discount += line.DiscountAmount
+		tax += line.TaxAmount
+		total += line.TotalPrice
+		if invoice.TotalAmount != line.TotalPrice || invoice.SubtotalAmount != line.TotalPrice-line.TaxAmount {
+			t.Errorf("invoice totals %+v do not match its line %+v", invoice, line)
+		}
+	}
+	if discount != item.DiscountAmount || tax != item.TaxAmount || total != item.TotalPrice {
+		t.Errorf("invoiced discount %s, tax %s, total %s; line has %s, %s, %s",
+			discount, tax, total, item.DiscountAmount, item.TaxAmount, item.TotalPrice)
+	}
+	if got := item.Uninvoiced(); got != 0 {
+		t.Errorf("uninvoiced after invoicing everything = %d", got)
+	}
+}
+
+func TestCreditNotesAddUpToTheInvoiceLine(t *testing.T) {
+	line := &InvoiceLine{
+		ID:             "line",
+		Quantity:       3,
+		UnitPrice:      MustParseMoney("9.99"),
+		DiscountAmount: MustParseMoney("2"),
+		TaxAmount:      MustParseMoney("5.59"),
+		TotalPrice:     MustParseMoney("33.56"),
+	}
+	invoice := &Invoice{ID: "inv", Type: InvoiceTypeInvoice, Currency: "USD", ExchangeRate: RateOne, Lines: []InvoiceLine{*line}}
+
+	var tax, total Money
+	for _, units := range []int{1, 1, 1} {
+		creditNote := NewCreditNote(invoice, "damaged", "")
+		creditNote.AddCreditLine(line, units)
+		line.CreditedQuantity += units
+		tax += creditNote.TaxAmount
+		total += creditNote.TotalAmount
+		if creditNote.Lines[0].CreditedLineID == nil || *creditNote.Lines[0].CreditedLineID != line.ID {
+			t.Errorf("credit line does not point at the invoice line: %+v", creditNote.Lines[0])
+		}
+	}
+	if tax != line.TaxAmount || total != line.TotalPrice {
+		t.Errorf("credited tax %s, total %s; invoice line has %s, %s", tax, total, line.TaxAmount, line.TotalPrice)
+	}
+	if line.Uncredited() != 0 {
+		t.Errorf("uncredited = %d", line.Uncredited())
+	}
+}
+
+func TestInvoiceIssueAndSettle(t *testing.T) {
+	invoice := &Invoice{Type: InvoiceTypeInvoice, Status: InvoiceStatusDraft, PaymentTermsDays: 30, TotalAmount: MustParseMoney("100")}
+	if invoice.CalculateBalance(); invoice.BalanceDue != 0 {
+		t.Errorf("draft balance = %s, want 0", invoice.BalanceDue)
+	}
+	if !invoice.Voidable() {
+		t.Error("drafts can be voided")
+	}
+
+	issued := time.Date(2026, time.January, 20, 15, 4, 5, 0, time.UTC)
+	invoice.Issue(7, issued, "finance")
+	if invoice.InvoiceNumber != "INV-000007" || invoice.Status != InvoiceStatusIssued || invoice.BalanceDue != MustParseMoney("100") {
+		t.Fatalf("issued invoice = %+v", invoice)
+	}
+	if want := time.Date(2026, time.February, 19, 0, 0, 0, 0, time.UTC); !invoice.DueDate.Equal(want) {
+		t.Errorf("due %s, want %s", invoice.DueDate, want)
+	}
+	if invoice.Overdue(time.Date(2026, time.February, 19, 23, 0, 0, 0, time.UTC)) {
+		t.Error("overdue on its due date")
+	}
+	if !invoice.Overdue(time.Date(2026, time.February, 20, 0, 0, 0, 0, time.UTC)) {
+		t.Error("not overdue the day after its due date")
+	}
+
+	tests := []struct {
+		credited, paid string
+		status         string
+		balance        string
+	}{
+		{"0", "0", InvoiceStatusIssued, "100"},
+		{"25", "0", InvoiceStatusPartiallyPaid, "75"},
+		{"25", "75", InvoiceStatusPaid, "0"},
+		{"25", "80", InvoiceStatusPaid, "-5"},
+		{"0", "40", InvoiceStatusPartiallyPaid, "60"},
+	}
+	for _, tt := range tests {
+		invoice.AmountCredited, invoice.AmountPaid = MustParseMoney(tt.credited), MustParseMoney(tt.paid)
+		invoice.Settle()
+		if invoice.Status != tt.status || invoice.BalanceDue != MustParseMoney(tt.balance) {
+			t.Errorf("credited %s, paid %s: status %s, balance %s; want %s, %s",
+				tt.credited, tt.paid, invoice.Status, invoice.BalanceDue, tt.status, tt.balance)
+		}
+	}
+	if invoice.Voidable() {
+		t.Error("an invoice with payments can be voided")
+	}
+	if got := FormatInvoiceNumber(InvoiceTypeCreditNote, 123); got != "CN-000123" {
+		t.Errorf("credit note number = %s", got)
+	}
+}
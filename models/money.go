@@ -0,0 +1,249 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"erp-project/database"
+)
+
+// Money is an exact decimal amount with four decimal places, held as a whole number of
+// ten-thousandths of a currency unit. Adding amounts and multiplying them by whole
+// quantities is exact; amounts are only rounded, to a currency's minor unit, where a
+// pricing rule says so (see Currency.Round).
+//
+// It is stored as DECIMAL(19,4) on PostgreSQL and as an INTEGER count of
+// ten-thousandths on SQLite, and travels in JSON as a plain decimal number.
+type Money int64
+
+const (
+	moneyScale = 4
+	moneyUnit  = 10000 // Ten-thousandths in one whole currency unit
+
+	// maxMoneyDigits keeps parsed amounts well inside int64 once scaled
+	maxMoneyDigits = 14
+)
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// ParseMoney reads a decimal amount such as "12", "-0.5" or "1999.9900". More than four
+// decimal places is an error rather than being rounded away.
+func ParseMoney(s string) (Money, error) {
+	units, err := parseFixed(s, moneyScale, maxMoneyDigits, ErrInvalidMoney)
+	return Money(units), err
+}
+
+// MustParseMoney is ParseMoney for amounts known to be valid, such as constants
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Mul multiplies the amount by a whole quantity, exactly
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// MulDiv multiplies the amount by numerator/denominator without overflowing, halves
+// rounded away from zero at four decimal places. It takes a share of an amount, e.g.
+// the part of an order discount that falls on one line.
+func (m Money) MulDiv(numerator, denominator int64) Money {
+	return Money(mulDivRound(int64(m), numerator, denominator))
+}
+
+// Percent is percent per cent of the amount, where percent is itself a decimal amount
+// so 12.5 means 12.5%
+func (m Money) Percent(percent Money) Money {
+	return m.MulDiv(int64(percent), 100*moneyUnit)
+}
+
+// WithoutPercent is the amount before percent per cent was added to it, e.g. 100 for
+// 120 at 20%
+func (m Money) WithoutPercent(percent Money) Money {
+	return m.MulDiv(100*moneyUnit, 100*moneyUnit+int64(percent))
+}
+
+// String formats the amount with as few decimal places as it needs, e.g. "12.5"
+func (m Money) String() string {
+	return trimFixed(m.fixed())
+}
+
+// fixed formats the amount with all four decimal places, e.g. "12.5000"
+func (m Money) fixed() string {
+	return formatFixed(int64(m), moneyScale)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a string holding one
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	parsed, err := ParseMoney(jsonDecimalText(data))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Scan reads DECIMAL text from PostgreSQL and whole ten-thousandths from SQLite. SQLite
+// hands back a REAL when money columns are combined in arithmetic; it is still a count
+// of ten-thousandths.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case int64:
+		*m = Money(v)
+	case float64:
+		*m = Money(math.Round(v))
+	case []byte:
+		return m.scanText(string(v))
+	case string:
+		return m.scanText(v)
+	default:
+		return fmt.Errorf("%w: cannot scan %T into Money", ErrInvalidMoney, src)
+	}
+	return nil
+}
+
+func (m *Money) scanText(text string) error {
+	parsed, err := ParseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores the amount as decimal text, which every SQL database can convert
+func (m Money) Value() (driver.Value, error) {
+	return m.fixed(), nil
+}
+
+// DialectValue stores the amount in the column type the dialect uses for money
+func (m Money) DialectValue(dialect database.Dialect) driver.Value {
+	if dialect == database.SQLite {
+		return int64(m)
+	}
+	return m.fixed()
+}
+
+// SumMoney adds up amounts exactly
+func SumMoney(amounts ...Money) Money {
+	var total Money
+	for _, amount := range amounts {
+		total += amount
+	}
+	return total
+}
+
+// mulDivRound works out value * numerator / denominator exactly and rounds halves away
+// from zero. denominator must be positive.
+func mulDivRound(value, numerator, denominator int64) int64 {
+	product := new(big.Int).Mul(big.NewInt(value), big.NewInt(numerator))
+	divisor := big.NewInt(denominator)
+
+	quotient, remainder := new(big.Int).QuoRem(product, divisor, new(big.Int))
+	// Compare twice the remainder with the divisor to round halves away from zero
+	if remainder.Abs(remainder).Lsh(remainder, 1).Cmp(divisor) >= 0 {
+		if product.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return quotient.Int64()
+}
+
+// parseFixed reads a decimal number as a whole count of 10^-scale units, failing with
+// invalid rather than rounding when it has more decimal places than that
+func parseFixed(s string, scale, maxDigits int, invalid error) (int64, error) {
+	text := strings.TrimSpace(s)
+	negative := false
+	switch {
+	case strings.HasPrefix(text, "-"):
+		negative = true
+		text = text[1:]
+	case strings.HasPrefix(text, "+"):
+		text = text[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(text, ".")
+	if whole == "" && fraction == "" {
+		return 0, fmt.Errorf("%w: %q", invalid, s)
+	}
+	if len(whole) > maxDigits {
+		return 0, fmt.Errorf("%w: %q is too large", invalid, s)
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > scale {
+		return 0, fmt.Errorf("%w: %q has more than %d decimal places", invalid, s, scale)
+	}
+
+	digits := whole + fraction + strings.Repeat("0", scale-len(fraction))
+	for _, ch := range digits {
+		if ch < '0' || ch > '9' {
+			return 0, fmt.Errorf("%w: %q", invalid, s)
+		}
+	}
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", invalid, s)
+	}
+	if negative {
+		units = -units
+	}
+	return units, nil
+}
+
+// formatFixed formats a count of 10^-scale units with all scale decimal places
+func formatFixed(units int64, scale int) string {
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+	// Work in uint64 so the most negative value formats too
+	abs := uint64(units)
+	if units < 0 {
+		abs = uint64(-(units + 1)) + 1
+	}
+	unit := uint64(1)
+	for i := 0; i < scale; i++ {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/unit, scale, abs%unit)
+}
+
+// trimFixed drops the trailing zeros, and the point if nothing follows it, from
+// formatFixed output
+func trimFixed(s string) string {
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// jsonDecimalText returns the decimal text in a JSON number, or in a string holding
+// one. Exponent notation, e.g. 1e2, is valid JSON but not a plain decimal, so it is
+// written out in full.
+func jsonDecimalText(data []byte) string {
+	text := string(data)
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		return unquoted
+	}
+	if strings.ContainsAny(text, "eE") {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+	return text
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"erp-project/database"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		str  string
+	}{
+		{"12", 120000, "12"},
+		{"12.5", 125000, "12.5"},
+		{"0.1", 1000, "0.1"},
+		{"-0.0001", -1, "-0.0001"},
+		{"1999.9900", 19999900, "1999.99"},
+		{".25", 2500, "0.25"},
+		{"+3.", 30000, "3"},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+			continue
+		}
+		if got.String() != tt.str {
+			t.Errorf("Money(%d).String() = %q, want %q", got, got.String(), tt.str)
+		}
+	}
+
+	for _, bad := range []string{"", "-", ".", "1.00001", "1,5", "abc", "1.2.3", "123456789012345"} {
+		if _, err := ParseMoney(bad); err == nil {
+			t.Errorf("ParseMoney(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var v struct {
+		A Money  `json:"a"`
+		B Money  `json:"b"`
+		C *Money `json:"c"`
+	}
+	if err := json.Unmarshal([]byte(`{"a": 0.1, "b": "19.99", "c": null}`), &v); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if v.A != MustParseMoney("0.1") || v.B != MustParseMoney("19.99") || v.C != nil {
+		t.Errorf("unmarshal = %+v", v)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil || string(out) != `{"a":0.1,"b":19.99,"c":null}` {
+		t.Errorf("marshal = %s, %v", out, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"a": 0.30000000000000004}`), &v); err == nil {
+		t.Error("accepted an amount with more than four decimal places")
+	}
+}
+
+func TestMoneySQL(t *testing.T) {
+	price := MustParseMoney("1234.5678")
+	if got := price.DialectValue(database.SQLite); got != int64(12345678) {
+		t.Errorf("SQLite value = %v", got)
+	}
+	if got := price.DialectValue(database.Postgres); got != "1234.5678" {
+		t.Errorf("PostgreSQL value = %v", got)
+	}
+
+	for _, src := range []interface{}{int64(12345678), float64(12345678), []byte("1234.5678"), "1234.56780"} {
+		var m Money
+		if err := m.Scan(src); err != nil || m != price {
+			t.Errorf("Scan(%#v) = %s, %v", src, m, err)
+		}
+	}
+	var m Money
+	if err := m.Scan(nil); err == nil {
+		t.Error("Scan(nil) succeeded")
+	}
+}
+
+func TestCurrencyRound(t *testing.T) {
+	usd, _ := LookupCurrency("usd")
+	jpy, _ := LookupCurrency("JPY")
+	kwd, _ := LookupCurrency("KWD")
+
+	tests := []struct {
+		currency Currency
+		in, want string
+	}{
+		{usd, "0.125", "0.13"},
+		{usd, "0.1249", "0.12"},
+		{usd, "-0.125", "-0.13"},
+		{usd, "-0.1249", "-0.12"},
+		{usd, "10", "10"},
+		{jpy, "99.5", "100"},
+		{jpy, "99.4999", "99"},
+		{kwd, "1.2345", "1.235"},
+		{kwd, "1.2344", "1.234"},
+	}
+	for _, tt := range tests {
+		if got := tt.currency.Round(MustParseMoney(tt.in)); got != MustParseMoney(tt.want) {
+			t.Errorf("%s.Round(%s) = %s, want %s", tt.currency.Code, tt.in, got, tt.want)
+		}
+	}
+
+	if _, ok := LookupCurrency("XXX"); ok {
+		t.Error("found an unknown currency")
+	}
+}
+
+func TestOrderTotalsAreSumOfLines(t *testing.T) {
+	// A third of a dollar three times rounds per line, and the total follows the lines
+	items := []*OrderItem{
+		NewOrderItem("o", "p1", 3, MustParseMoney("0.3333"), BaseCurrency),
+		NewOrderItem("o", "p2", 1, MustParseMoney("1.005"), BaseCurrency),
+		NewOrderItem("o", "p3", 7, MustParseMoney("0.1"), BaseCurrency),
+	}
+	want := []string{"1", "1.01", "0.7"}
+	for i, item := range items {
+		if item.TotalPrice != MustParseMoney(want[i]) {
+			t.Errorf("line %d total = %s, want %s", i, item.TotalPrice, want[i])
+		}
+	}
+	if total := OrderItemsTotal(items); total != MustParseMoney("2.71") {
+		t.Errorf("order total = %s, want 2.71", total)
+	}
+}
+
+func TestRate(t *testing.T) {
+	for _, tt := range []struct{ in, str string }{{"1.0825", "1.0825"}, {"0.00673", "0.00673"}, {"150.5", "150.5"}} {
+		rate, err := ParseRate(tt.in)
+		if err != nil || rate.String() != tt.str {
+			t.Errorf("ParseRate(%q) = %s, %v", tt.in, rate, err)
+		}
+	}
+	for _, bad := range []string{"0", "-1.5", "1.000000001", "abc"} {
+		if _, err := ParseRate(bad); err == nil {
+			t.Errorf("ParseRate(%q) succeeded, want an error", bad)
+		}
+	}
+
+	rate := MustParseRate("0.12345678")
+	if got := rate.DialectValue(database.SQLite); got != int64(12345678) {
+		t.Errorf("SQLite value = %v", got)
+	}
+	var scanned Rate
+	if err := scanned.Scan([]byte("0.12345678")); err != nil || scanned != rate {
+		t.Errorf("Scan = %s, %v", scanned, err)
+	}
+}
+
+func TestConvertMoney(t *testing.T) {
+	eur, gbp, jpy := MustParseRate("1.08"), MustParseRate("1.25"), MustParseRate("0.0067")
+
+	tests := []struct {
+		amount   string
+		from, to Rate
+		want     string
+	}{
+		{"100", eur, RateOne, "108"},
+		{"108", RateOne, eur, "100"},
+		{"100", eur, gbp, "86.4"},
+		{"1", RateOne, gbp, "0.8"},
+		{"1", jpy, RateOne, "0.0067"},
+		{"0.0001", jpy, RateOne, "0"},
+		{"1", RateOne, MustParseRate("3"), "0.3333"},
+		{"2", RateOne, MustParseRate("3"), "0.6667"},
+		{"-2", RateOne, MustParseRate("3"), "-0.6667"},
+	}
+	for _, tt := range tests {
+		if got := ConvertMoney(MustParseMoney(tt.amount), tt.from, tt.to); got != MustParseMoney(tt.want) {
+			t.Errorf("ConvertMoney(%s, %s, %s) = %s, want %s", tt.amount, tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	// Lines are rounded in the order's currency, not the base currency
+	jpyCurrency, _ := LookupCurrency("JPY")
+	if item := NewOrderItem("o", "p", 3, MustParseMoney("33.5"), jpyCurrency); item.TotalPrice != MustParseMoney("101") {
+		t.Errorf("JPY line total = %s, want 101", item.TotalPrice)
+	}
+}
@@ -6,42 +6,315 @@ import (
 	"github.com/google/uuid"
 )
 
+// Order statuses
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusConfirmed = "confirmed"
+	OrderStatusPicking   = "picking"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusOnHold    = "on_hold"
+)
+
+// Order item statuses
+const (
+	OrderItemStatusActive    = "active"
+	OrderItemStatusCancelled = "cancelled"
+)
+
+// Order allocation strategies
+const (
+	AllocationStrategySingle  = "single"  // whole line from one warehouse
+	AllocationStrategyNearest = "nearest" // whole line from the closest warehouse that has it
+	AllocationStrategySplit   = "split"   // line may be spread across warehouses
+)
+
+// Order allocation statuses
+const (
+	AllocationStatusReserved = "reserved"
+	AllocationStatusShipped  = "shipped"
+	AllocationStatusReleased = "released"
+)
+
+// orderStatusTransitions lists the statuses an order may move to from each status.
+// Delivered and cancelled are final.
+var orderStatusTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusConfirmed, OrderStatusOnHold, OrderStatusCancelled},
+	OrderStatusConfirmed: {OrderStatusPicking, OrderStatusOnHold, OrderStatusCancelled},
+	OrderStatusPicking:   {OrderStatusShipped, OrderStatusOnHold, OrderStatusCancelled},
+	OrderStatusShipped:   {OrderStatusDelivered},
+	OrderStatusOnHold:    {OrderStatusPending, OrderStatusConfirmed, OrderStatusPicking, OrderStatusCancelled},
+}
+
 type Order struct {
-	ID           string    `json:"id"`
-	CustomerID   string    `json:"customer_id"`
-	TotalAmount  float64   `json:"total_amount"`
-	Status       string    `json:"status"`
-	OrderDate    time.Time `json:"order_date"`
-	CustomerName string    `json:"customer_name,omitempty"` // For joins
+	ID              string    `json:"id"`
+	CustomerID      string    `json:"customer_id"`
+	Currency        string    `json:"currency"`          // Currency the order is priced in
+	TotalAmount     Money     `json:"total_amount"`      // Always the sum of the items' TotalPrice
+	TaxAmount       Money     `json:"tax_amount"`        // Always the sum of the items' TaxAmount, included in TotalAmount
+	ExchangeRate    Rate      `json:"exchange_rate"`     // Currency to base currency, fixed on the order date
+	BaseTotalAmount Money     `json:"base_total_amount"` // TotalAmount in the base currency, for reporting
+	Status          string    `json:"status"`
+	OrderDate       time.Time `json:"order_date"`
+	CouponID        *string   `json:"coupon_id,omitempty"` // Coupon redeemed on the order
+
+	// How the order was taxed: whether its prices include tax, where the sale was taxed
+	// and, for an exempt customer, the exemption certificate it was sold under
+	PricesIncludeTax   bool   `json:"prices_include_tax"`
+	TaxCountry         string `json:"tax_country"`
+	TaxRegion          string `json:"tax_region"`
+	TaxExempt          bool   `json:"tax_exempt"`
+	TaxExemptionNumber string `json:"tax_exemption_number,omitempty"`
+
+	// For joins
+	CustomerName string `json:"customer_name,omitempty"`
+	CouponCode   string `json:"coupon_code,omitempty"`
+}
+
+// OrderCurrencyTotal sums the orders placed in one currency
+type OrderCurrencyTotal struct {
+	Currency        string `json:"currency"`
+	Orders          int    `json:"orders"`
+	TotalAmount     Money  `json:"total_amount"`
+	BaseTotalAmount Money  `json:"base_total_amount"`
 }
 
 type OrderItem struct {
-	ID          string  `json:"id"`
-	OrderID     string  `json:"order_id"`
-	ProductID   string  `json:"product_id"`
-	Quantity    int     `json:"quantity"`
-	UnitPrice   float64 `json:"unit_price"`
-	TotalPrice  float64 `json:"total_price"`
-	ProductName string  `json:"product_name,omitempty"` // For joins
+	ID                string `json:"id"`
+	OrderID           string `json:"order_id"`
+	ProductID         string `json:"product_id"`
+	Quantity          int    `json:"quantity"`
+	CancelledQuantity int    `json:"cancelled_quantity"`
+	InvoicedQuantity  int    `json:"invoiced_quantity"` // On invoices that are not void
+	ShippedQuantity   int    `json:"shipped_quantity"`  // Loaded: units that have left the warehouse
+	UnitPrice         Money  `json:"unit_price"`        // In the order's currency
+	DiscountAmount    Money  `json:"discount_amount"`   // Promotions' discounts on the whole quantity
+	TaxAmount         Money  `json:"tax_amount"`        // Calculated, on the units still on the line
+	TotalPrice        Money  `json:"total_price"`       // Calculated, including tax: see CalculateTotal
+	Status            string `json:"status"`            // active, cancelled
+
+	// How the unit price was arrived at: the catalogue price in the order's currency and,
+	// when a price list replaced it, the list and the quantity break that applied
+	ListPrice          Money   `json:"list_price"`
+	PriceListID        *string `json:"price_list_id,omitempty"`
+	PriceBreakQuantity int     `json:"price_break_quantity,omitempty"`
+
+	// The tax category the product was sold under and the rate charged on it; an
+	// untaxed line has no rate
+	TaxCategory      string  `json:"tax_category"`
+	TaxRateID        *string `json:"tax_rate_id,omitempty"`
+	TaxRate          Money   `json:"tax_rate"`           // Percent, as TaxRate.Rate
+	PricesIncludeTax bool    `json:"prices_include_tax"` // The order's price mode
+
+	Discounts []OrderItemDiscount `json:"discounts,omitempty"`
+
+	// For joins
+	ProductName   string `json:"product_name,omitempty"`
+	PriceListName string `json:"price_list_name,omitempty"`
+}
+
+// OrderCancellation records stock given back when an order, or part of it, is cancelled
+type OrderCancellation struct {
+	ID          string    `json:"id"`
+	OrderID     string    `json:"order_id"`
+	OrderItemID string    `json:"order_item_id"`
+	ProductID   string    `json:"product_id"`
+	Quantity    int       `json:"quantity"`
+	Amount      Money     `json:"amount"`
+	Reason      string    `json:"reason"`
+	CancelledBy string    `json:"cancelled_by"`
+	CancelledAt time.Time `json:"cancelled_at"`
+}
+
+type OrderStatusHistory struct {
+	ID         string    `json:"id"`
+	OrderID    string    `json:"order_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Reason     string    `json:"reason"`
+	ChangedBy  string    `json:"changed_by"`
+	ChangedAt  time.Time `json:"changed_at"`
 }
 
-func NewOrder(customerID string, totalAmount float64) *Order {
-	return &Order{
+// OrderAllocation reserves part of an order line against a warehouse inventory row
+type OrderAllocation struct {
+	ID          string    `json:"id"`
+	OrderID     string    `json:"order_id"`
+	OrderItemID string    `json:"order_item_id"`
+	ProductID   string    `json:"product_id"`
+	WarehouseID string    `json:"warehouse_id"`
+	InventoryID string    `json:"inventory_id"`
+	LocationID  *string   `json:"location_id,omitempty"`
+	Quantity    int       `json:"quantity"`
+	Status      string    `json:"status"` // reserved, shipped, released
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// For joins
+	WarehouseName string `json:"warehouse_name,omitempty"`
+	LocationCode  string `json:"location_code,omitempty"`
+}
+
+// AllocationOptions controls how order lines are allocated to warehouses
+type AllocationOptions struct {
+	Strategy    string
+	WarehouseID string   // Preferred warehouse, tried first
+	Latitude    *float64 // Ship-to point used to rank warehouses by distance
+	Longitude   *float64
+}
+
+// NewOrder starts an order priced in the base currency; see SetCurrency for others
+func NewOrder(customerID string, totalAmount Money) *Order {
+	order := &Order{
 		ID:          uuid.New().String(),
 		CustomerID:  customerID,
 		TotalAmount: totalAmount,
-		Status:      "pending",
+		Status:      OrderStatusPending,
 		OrderDate:   time.Now(),
 	}
+	order.SetCurrency(BaseCurrency, RateOne)
+	return order
+}
+
+// SetCurrency prices the order in a currency whose rate to the base currency is rate
+func (o *Order) SetCurrency(currency Currency, rate Rate) {
+	o.Currency = currency.Code
+	o.ExchangeRate = rate
+	o.CalculateBaseTotal()
+}
+
+// CalculateBaseTotal converts the order total into the base currency at the order's
+// exchange rate, rounded to the base currency
+func (o *Order) CalculateBaseTotal() {
+	o.BaseTotalAmount = BaseCurrency.Round(o.ExchangeRate.ToBase(o.TotalAmount))
+}
+
+// NewOrderItem prices a line at the catalogue price, in the order's currency
+func NewOrderItem(orderID, productID string, quantity int, unitPrice Money, currency Currency) *OrderItem {
+	item := &OrderItem{
+		ID:        uuid.New().String(),
+		OrderID:   orderID,
+		ProductID: productID,
+		Quantity:  quantity,
+		UnitPrice: unitPrice,
+		Status:    OrderItemStatusActive,
+		ListPrice: unitPrice,
+	}
+	item.CalculateTotal(currency)
+	return item
+}
+
+// ApplyPriceList reprices the line from a price list tier. unitPrice is the tier's
+// price converted into the order's currency; the catalogue price stays in ListPrice.
+func (i *OrderItem) ApplyPriceList(list *PriceList, tier *PriceListItem, unitPrice Money, currency Currency) {
+	listID := list.ID
+	i.PriceListID = &listID
+	i.PriceListName = list.Name
+	i.PriceBreakQuantity = tier.MinQuantity
+	i.UnitPrice = unitPrice
+	i.CalculateTotal(currency)
+}
+
+// GrossTotal is the units still on the line at the unit price, before discounts,
+// rounded to the order's currency
+func (i *OrderItem) GrossTotal(currency Currency) Money {
+	return currency.Round(i.UnitPrice.Mul(i.Quantity - i.CancelledQuantity))
+}
+
+// CalculateTotal prices the units still on the line less their discount, then taxes
+// them, rounding to the order's currency, which is the only place a line total is
+// rounded. A partly cancelled line keeps the share of its discount that the remaining
+// units had. When prices include tax the tax is the part of the total above the rounded
+// net amount, so the total is unchanged; otherwise the rounded tax is added to it.
+func (i *OrderItem) CalculateTotal(currency Currency) {
+	_, i.TaxAmount, i.TotalPrice = i.unitsAmounts(i.Quantity-i.CancelledQuantity, currency)
+}
+
+// unitsAmounts prices the first units of the line: their share of the line discount,
+// their tax and their total including tax, each rounded to the order's currency. The
+// difference between two counts of units is what the units in between come to, so
+// pricing a line in parts adds up exactly to pricing it whole.
+func (i *OrderItem) unitsAmounts(units int, currency Currency) (discount, tax, total Money) {
+	discount = i.DiscountAmount
+	if units != i.Quantity && i.Quantity > 0 {
+		discount = currency.Round(discount.MulDiv(int64(units), int64(i.Quantity)))
+	}
+	amount := currency.Round(i.UnitPrice.Mul(units)) - discount
+	if i.PricesIncludeTax {
+		tax = amount - currency.Round(amount.WithoutPercent(i.TaxRate))
+		return discount, tax, amount
+	}
+	tax = currency.Round(amount.Percent(i.TaxRate))
+	return discount, tax, amount + tax
+}
+
+// OrderItemsTotal is the order total for a set of lines. Line totals are already
+// rounded, so the order total is their exact sum and never drifts from it.
+func OrderItemsTotal(items []*OrderItem) Money {
+	var total Money
+	for _, item := range items {
+		total += item.TotalPrice
+	}
+	return total
+}
+
+// NewOrderCancellation records quantity units taken off an order line. amount is how much
+// the line total went down by, so the order total can drop by exactly the same.
+func NewOrderCancellation(item *OrderItem, quantity int, amount Money, reason, cancelledBy string) *OrderCancellation {
+	return &OrderCancellation{
+		ID:          uuid.New().String(),
+		OrderID:     item.OrderID,
+		OrderItemID: item.ID,
+		ProductID:   item.ProductID,
+		Quantity:    quantity,
+		Amount:      amount,
+		Reason:      reason,
+		CancelledBy: cancelledBy,
+		CancelledAt: time.Now(),
+	}
 }
 
-func NewOrderItem(orderID, productID string, quantity int, unitPrice float64) *OrderItem {
-	return &OrderItem{
+func NewOrderAllocation(item *OrderItem, warehouseID, inventoryID string, locationID *string, quantity int) *OrderAllocation {
+	now := time.Now()
+	return &OrderAllocation{
+		ID:          uuid.New().String(),
+		OrderID:     item.OrderID,
+		OrderItemID: item.ID,
+		ProductID:   item.ProductID,
+		WarehouseID: warehouseID,
+		InventoryID: inventoryID,
+		LocationID:  locationID,
+		Quantity:    quantity,
+		Status:      AllocationStatusReserved,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+func NewOrderStatusHistory(orderID, fromStatus, toStatus, reason, changedBy string) *OrderStatusHistory {
+	return &OrderStatusHistory{
 		ID:         uuid.New().String(),
 		OrderID:    orderID,
-		ProductID:  productID,
-		Quantity:   quantity,
-		UnitPrice:  unitPrice,
-		TotalPrice: float64(quantity) * unitPrice,
+		FromStatus: fromStatus,
+		ToStatus:   toStatus,
+		Reason:     reason,
+		ChangedBy:  changedBy,
+		ChangedAt:  time.Now(),
+	}
+}
+
+// AllowedOrderTransitions returns the statuses an order in the given status may move to
+func AllowedOrderTransitions(status string) []string {
+	return orderStatusTransitions[status]
+}
+
+// CanTransitionOrderStatus reports whether an order may move from one status to another
+func CanTransitionOrderStatus(from, to string) bool {
+	for _, status := range orderStatusTransitions[from] {
+		if status == to {
+			return true
+		}
 	}
+	return false
 }
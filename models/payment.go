@@ -0,0 +1,142 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payment methods
+const (
+	PaymentMethodCash         = "cash"
+	PaymentMethodCheck        = "check"
+	PaymentMethodBankTransfer = "bank_transfer"
+	PaymentMethodCard         = "card"
+	PaymentMethodOther        = "other"
+)
+
+// Payment statuses. A void payment no longer settles the invoices it was allocated to.
+const (
+	PaymentStatusReceived = "received"
+	PaymentStatusVoid     = "void"
+)
+
+// Payment is money received from a customer. It settles invoices in its own currency
+// through allocations; what is left unallocated is credit the customer holds.
+type Payment struct {
+	ID              string     `json:"id"`
+	CustomerID      string     `json:"customer_id"`
+	Status          string     `json:"status"`
+	Method          string     `json:"method"`
+	Reference       string     `json:"reference"` // Check number, bank reference and the like
+	Currency        string     `json:"currency"`
+	ExchangeRate    Rate       `json:"exchange_rate"` // On the payment date
+	Amount          Money      `json:"amount"`
+	BaseAmount      Money      `json:"base_amount"`
+	AmountAllocated Money      `json:"amount_allocated"`
+	Unallocated     Money      `json:"unallocated"`
+	PaymentDate     time.Time  `json:"payment_date"`
+	Notes           string     `json:"notes"`
+	VoidReason      string     `json:"void_reason,omitempty"`
+	CreatedBy       string     `json:"created_by"`
+	VoidedBy        string     `json:"voided_by,omitempty"`
+	VoidedAt        *time.Time `json:"voided_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	Allocations []PaymentAllocation `json:"allocations,omitempty"`
+
+	// For joins
+	CustomerName string `json:"customer_name,omitempty"`
+}
+
+// PaymentAllocation is the part of a payment that went to one invoice
+type PaymentAllocation struct {
+	ID        string    `json:"id"`
+	PaymentID string    `json:"payment_id"`
+	InvoiceID string    `json:"invoice_id"`
+	Amount    Money     `json:"amount"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// For joins
+	InvoiceNumber string `json:"invoice_number,omitempty"`
+}
+
+// CustomerBalance sums up where a customer stands in one currency
+type CustomerBalance struct {
+	Currency          string `json:"currency"`
+	InvoicedAmount    Money  `json:"invoiced_amount"` // Issued invoices that are not void
+	CreditedAmount    Money  `json:"credited_amount"`
+	PaidAmount        Money  `json:"paid_amount"`        // Payments allocated to invoices
+	OutstandingAmount Money  `json:"outstanding_amount"` // Left to pay on invoices
+	OverdueAmount     Money  `json:"overdue_amount"`
+	UnallocatedAmount Money  `json:"unallocated_amount"` // Payments not yet allocated
+	Balance           Money  `json:"balance"`            // Owed by the customer; negative when in credit
+}
+
+func NewPayment(customerID, method, reference string, currency Currency, rate Rate, amount Money, paymentDate time.Time, notes, createdBy string) *Payment {
+	now := time.Now()
+	payment := &Payment{
+		ID:           uuid.New().String(),
+		CustomerID:   customerID,
+		Status:       PaymentStatusReceived,
+		Method:       method,
+		Reference:    reference,
+		Currency:     currency.Code,
+		ExchangeRate: rate,
+		Amount:       currency.Round(amount),
+		PaymentDate:  paymentDate,
+		Notes:        notes,
+		CreatedBy:    createdBy,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	payment.BaseAmount = BaseCurrency.Round(rate.ToBase(payment.Amount))
+	payment.CalculateUnallocated()
+	return payment
+}
+
+func NewPaymentAllocation(paymentID, invoiceID string, amount Money, createdBy string) *PaymentAllocation {
+	return &PaymentAllocation{
+		ID:        uuid.New().String(),
+		PaymentID: paymentID,
+		InvoiceID: invoiceID,
+		Amount:    amount,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+}
+
+// CalculateUnallocated works out how much of the payment is still to be allocated.
+// Nothing is on a void payment.
+func (p *Payment) CalculateUnallocated() {
+	p.Unallocated = 0
+	if p.Status != PaymentStatusVoid {
+		p.Unallocated = p.Amount - p.AmountAllocated
+	}
+}
+
+// AutoAllocate spreads an amount over open invoices in the order given, settling each
+// in full before moving on to the next. Whatever is left over is not allocated.
+func AutoAllocate(amount Money, invoices []Invoice) map[string]Money {
+	allocations := map[string]Money{}
+	for _, invoice := range invoices {
+		if amount <= 0 {
+			break
+		}
+		if invoice.BalanceDue <= 0 {
+			continue
+		}
+		share := min(invoice.BalanceDue, amount)
+		allocations[invoice.ID] = share
+		amount -= share
+	}
+	return allocations
+}
+
+// CalculateBalance works out what is outstanding and what the customer owes in all
+func (b *CustomerBalance) CalculateBalance() {
+	b.OutstandingAmount = b.InvoicedAmount - b.CreditedAmount - b.PaidAmount
+	b.Balance = b.OutstandingAmount - b.UnallocatedAmount
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSupplierPaymentTerms apply to suppliers that have not agreed others
+var DefaultSupplierPaymentTerms = PaymentTerms{NetDays: 30}
+
+var ErrInvalidPaymentTerms = errors.New(`payment terms must look like "net 30", "2/10 net 30" or "due on receipt"`)
+
+// paymentTermsPattern reads terms such as "net 30", "n30", "2/10 net 30" and "1.5%/15, net 45"
+var paymentTermsPattern = regexp.MustCompile(`^(?:(\d+(?:\.\d+)?)\s*%?\s*/\s*(\d+)\s*,?\s*)?(?:net|n)\s*(\d+)$`)
+
+// PaymentTerms say when a bill is due, counted from its date, and the discount for
+// paying early: 2/10 net 30 is due in 30 days, less 2% if paid within 10
+type PaymentTerms struct {
+	NetDays         int   `json:"net_days"`
+	DiscountPercent Money `json:"discount_percent"`
+	DiscountDays    int   `json:"discount_days"`
+}
+
+// ParsePaymentTerms reads payment terms written the usual way, ignoring case
+func ParsePaymentTerms(s string) (PaymentTerms, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "due on receipt" || s == "cod" {
+		return PaymentTerms{}, nil
+	}
+	m := paymentTermsPattern.FindStringSubmatch(s)
+	if m == nil {
+		return PaymentTerms{}, ErrInvalidPaymentTerms
+	}
+
+	var terms PaymentTerms
+	terms.NetDays, _ = strconv.Atoi(m[3])
+	if m[1] != "" {
+		percent, err := ParseMoney(m[1])
+		if err != nil {
+			return PaymentTerms{}, ErrInvalidPaymentTerms
+		}
+		terms.DiscountPercent = percent
+		terms.DiscountDays, _ = strconv.Atoi(m[2])
+	}
+	if err := terms.Validate(); err != nil {
+		return PaymentTerms{}, err
+	}
+	return terms, nil
+}
+
+// Validate checks the terms make sense: the discount is under 100% and its window ends
+// by the due date
+func (t PaymentTerms) Validate() error {
+	switch {
+	case t.NetDays < 0 || t.NetDays > 365:
+		return fmt.Errorf("%w: net days must be between 0 and 365", ErrInvalidPaymentTerms)
+	case t.DiscountPercent < 0 || t.DiscountPercent >= MustParseMoney("100"):
+		return fmt.Errorf("%w: the discount must be under 100%%", ErrInvalidPaymentTerms)
+	case t.DiscountPercent > 0 && (t.DiscountDays <= 0 || t.DiscountDays > t.NetDays):
+		return fmt.Errorf("%w: the discount days must fall within the net days", ErrInvalidPaymentTerms)
+	}
+	return nil
+}
+
+func (t PaymentTerms) String() string {
+	if t.NetDays == 0 && t.DiscountPercent == 0 {
+		return "due on receipt"
+	}
+	if t.DiscountPercent > 0 {
+		return fmt.Sprintf("%s/%d net %d", t.DiscountPercent, t.DiscountDays, t.NetDays)
+	}
+	return fmt.Sprintf("net %d", t.NetDays)
+}
+
+// DueDate is when a bill dated on falls due
+func (t PaymentTerms) DueDate(on time.Time) time.Time {
+	return RateDate(on).AddDate(0, 0, t.NetDays)
+}
+
+// DiscountDate is the last day a bill dated on can be paid at a discount, or nil when
+// the terms offer none
+func (t PaymentTerms) DiscountDate(on time.Time) *time.Time {
+	if t.DiscountPercent == 0 {
+		return nil
+	}
+	day := RateDate(on).AddDate(0, 0, t.DiscountDays)
+	return &day
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoAllocate(t *testing.T) {
+	invoices := []Invoice{
+		{ID: "oldest", BalanceDue: MustParseMoney("40")},
+		{ID: "settled", BalanceDue: 0},
+		{ID: "middle", BalanceDue: MustParseMoney("25.5")},
+		{ID: "newest", BalanceDue: MustParseMoney("100")},
+	}
+
+	tests := []struct {
+		name   string
+		amount string
+		want   map[string]string
+	}{
+		{"partial payment of the oldest", "30", map[string]string{"oldest": "30"}},
+		{"settles in order", "80", map[string]string{"oldest": "40", "middle": "25.5", "newest": "14.5"}},
+		{"over-payment leaves the rest", "200", map[string]string{"oldest": "40", "middle": "25.5", "newest": "100"}},
+		{"nothing to allocate", "0", map[string]string{}},
+	}
+	for _, tt := range tests {
+		got := AutoAllocate(MustParseMoney(tt.amount), invoices)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for id, amount := range tt.want {
+			if got[id] != MustParseMoney(amount) {
+				t.Errorf("%s: %s got %s, want %s", tt.name, id, got[id], amount)
+			}
+		}
+	}
+}
+
+func TestNewPaymentRoundsAndConverts(t *testing.T) {
+	jpy, _ := LookupCurrency("JPY")
+	rate, err := ParseRate("0.0067")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payment := NewPayment("c", PaymentMethodBankTransfer, "REF-1", jpy, rate, MustParseMoney("15000.4"), time.Now(), "", "")
+	if payment.Amount != MustParseMoney("15000") || payment.BaseAmount != MustParseMoney("100.5") {
+		t.Errorf("amount %s, base %s", payment.Amount, payment.BaseAmount)
+	}
+	if payment.Unallocated != payment.Amount {
+		t.Errorf("unallocated %s, want the whole payment", payment.Unallocated)
+	}
+	payment.AmountAllocated = MustParseMoney("4000")
+	payment.Status = PaymentStatusVoid
+	if payment.CalculateUnallocated(); payment.Unallocated != 0 {
+		t.Errorf("void payment has %s unallocated", payment.Unallocated)
+	}
+}
+
+func TestAgingBuckets(t *testing.T) {
+	on := time.Date(2026, time.June, 30, 18, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) time.Time { return on.AddDate(0, 0, -n) }
+
+	var b AgingBuckets
+	b.Add(on.AddDate(0, 0, 5), on, MustParseMoney("1"))
+	b.Add(daysAgo(0), on, MustParseMoney("2"))
+	b.Add(daysAgo(1), on, MustParseMoney("10"))
+	b.Add(daysAgo(30), on, MustParseMoney("20"))
+	b.Add(daysAgo(31), on, MustParseMoney("100"))
+	b.Add(daysAgo(75), on, MustParseMoney("1000"))
+	b.Add(daysAgo(91), on, MustParseMoney("10000"))
+
+	want := AgingBuckets{
+		Current:    MustParseMoney("3"),
+		Days1To30:  MustParseMoney("30"),
+		Days31To60: MustParseMoney("100"),
+		Days61To90: MustParseMoney("1000"),
+		Over90:     MustParseMoney("10000"),
+		Total:      MustParseMoney("11133"),
+	}
+	if b != want {
+		t.Errorf("buckets = %+v, want %+v", b, want)
+	}
+
+	var totals AgingBuckets
+	totals.AddBuckets(b)
+	totals.AddBuckets(b)
+	if totals.Total != 2*want.Total || totals.Over90 != 2*want.Over90 {
+		t.Errorf("totals = %+v", totals)
+	}
+
+	balance := CustomerBalance{InvoicedAmount: MustParseMoney("500"), CreditedAmount: MustParseMoney("50"),
+		PaidAmount: MustParseMoney("300"), UnallocatedAmount: MustParseMoney("200")}
+	balance.CalculateBalance()
+	if balance.OutstandingAmount != MustParseMoney("150") || balance.Balance != MustParseMoney("-50") {
+		t.Errorf("balance = %+v", balance)
+	}
+}
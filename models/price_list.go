@@ -0,0 +1,141 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PriceList overrides catalogue prices for one customer, or for every customer in a
+// customer group, while it is valid. Customer lists take precedence over group lists.
+type PriceList struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	CustomerID    *string    `json:"customer_id,omitempty"`
+	CustomerGroup string     `json:"customer_group,omitempty"`
+	Currency      string     `json:"currency"` // Currency the list's prices are in
+	ValidFrom     time.Time  `json:"valid_from"`
+	ValidTo       *time.Time `json:"valid_to,omitempty"` // Last day the list applies, open ended when nil
+	Active        bool       `json:"active"`
+	CreatedBy     string     `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	Items []PriceListItem `json:"items,omitempty"`
+
+	CustomerName string `json:"customer_name,omitempty"` // For joins
+}
+
+// PriceListItem is one quantity-break tier for a product: lines of at least MinQuantity
+// units are charged UnitPrice
+type PriceListItem struct {
+	ID          string `json:"id"`
+	PriceListID string `json:"price_list_id"`
+	ProductID   string `json:"product_id"`
+	MinQuantity int    `json:"min_quantity"`
+	UnitPrice   Money  `json:"unit_price"`
+
+	// For joins
+	ProductName string `json:"product_name,omitempty"`
+	SKU         string `json:"sku,omitempty"`
+}
+
+func NewPriceList(name string, customerID *string, customerGroup, currency string, validFrom time.Time, validTo *time.Time, createdBy string) *PriceList {
+	list := &PriceList{
+		ID:            uuid.New().String(),
+		Name:          name,
+		CustomerID:    customerID,
+		CustomerGroup: customerGroup,
+		Currency:      currency,
+		ValidFrom:     RateDate(validFrom),
+		Active:        true,
+		CreatedBy:     createdBy,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	list.SetValidTo(validTo)
+	return list
+}
+
+// SetValidTo sets the last day the list applies, or makes it open ended when nil
+func (p *PriceList) SetValidTo(validTo *time.Time) {
+	p.ValidTo = nil
+	if validTo != nil {
+		day := RateDate(*validTo)
+		p.ValidTo = &day
+	}
+}
+
+// AddItem adds a quantity-break tier for a product. A MinQuantity below one means one.
+func (p *PriceList) AddItem(productID string, minQuantity int, unitPrice Money) {
+	if minQuantity < 1 {
+		minQuantity = 1
+	}
+	p.Items = append(p.Items, PriceListItem{
+		ID:          uuid.New().String(),
+		PriceListID: p.ID,
+		ProductID:   productID,
+		MinQuantity: minQuantity,
+		UnitPrice:   unitPrice,
+	})
+}
+
+// ValidOn reports whether the list is active and the given day falls within its
+// validity, both ends included
+func (p *PriceList) ValidOn(t time.Time) bool {
+	day := RateDate(t)
+	if !p.Active || day.Before(RateDate(p.ValidFrom)) {
+		return false
+	}
+	return p.ValidTo == nil || !day.After(RateDate(*p.ValidTo))
+}
+
+// Tier returns the item that prices a line of quantity units of a product: the one
+// with the highest MinQuantity not above quantity. It returns nil when the list has no
+// price for the product at that quantity.
+func (p *PriceList) Tier(productID string, quantity int) *PriceListItem {
+	var tier *PriceListItem
+	for i := range p.Items {
+		item := &p.Items[i]
+		if item.ProductID != productID || item.MinQuantity > quantity {
+			continue
+		}
+		if tier == nil || item.MinQuantity > tier.MinQuantity {
+			tier = item
+		}
+	}
+	return tier
+}
+
+// SortPriceLists orders lists by precedence: lists for a single customer before group
+// lists, then the most recently started first
+func SortPriceLists(lists []PriceList) {
+	sort.SliceStable(lists, func(i, j int) bool {
+		if (lists[i].CustomerID != nil) != (lists[j].CustomerID != nil) {
+			return lists[i].CustomerID != nil
+		}
+		if !lists[i].ValidFrom.Equal(lists[j].ValidFrom) {
+			return lists[i].ValidFrom.After(lists[j].ValidFrom)
+		}
+		return lists[i].ID < lists[j].ID
+	})
+}
+
+// ResolvePrice finds the price list tier that prices a line on the given day. Lists
+// are tried in precedence order (see SortPriceLists) and the first one with a tier for
+// the product and quantity wins, so a customer list without a price for a product
+// falls back to the group's. It returns nil, nil when the catalogue price applies.
+func ResolvePrice(lists []PriceList, productID string, quantity int, on time.Time) (*PriceList, *PriceListItem) {
+	ordered := append([]PriceList(nil), lists...)
+	SortPriceLists(ordered)
+	for i := range ordered {
+		if !ordered[i].ValidOn(on) {
+			continue
+		}
+		if tier := ordered[i].Tier(productID, quantity); tier != nil {
+			return &ordered[i], tier
+		}
+	}
+	return nil, nil
+}
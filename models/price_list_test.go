@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolvePrice(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, time.May, d, 0, 0, 0, 0, time.UTC) }
+	customerID := "c1"
+	juneFirst := day(31).AddDate(0, 0, 1)
+
+	group := NewPriceList("Wholesale", nil, "wholesale", "USD", day(1), nil, "")
+	group.AddItem("bolt", 1, MustParseMoney("1.8"))
+	group.AddItem("bolt", 50, MustParseMoney("1.5"))
+	group.AddItem("nut", 1, MustParseMoney("0.4"))
+
+	// The customer's own list wins over its group's, but only for what it prices
+	customer := NewPriceList("Trade Buyer", &customerID, "", "USD", day(10), &juneFirst, "")
+	customer.AddItem("bolt", 100, MustParseMoney("1.2"))
+
+	lists := []PriceList{*group, *customer}
+	tests := []struct {
+		product  string
+		quantity int
+		on       time.Time
+		list     string
+		want     string
+	}{
+		{"bolt", 10, day(15), "Wholesale", "1.8"},
+		{"bolt", 50, day(15), "Wholesale", "1.5"},
+		{"bolt", 99, day(15), "Wholesale", "1.5"},
+		{"bolt", 100, day(15), "Trade Buyer", "1.2"},
+		{"bolt", 100, day(5), "Wholesale", "1.5"},
+		{"bolt", 100, juneFirst.Add(23 * time.Hour), "Trade Buyer", "1.2"},
+		{"bolt", 100, juneFirst.AddDate(0, 0, 1), "Wholesale", "1.5"},
+		{"nut", 500, day(15), "Wholesale", "0.4"},
+	}
+	for _, tt := range tests {
+		list, tier := ResolvePrice(lists, tt.product, tt.quantity, tt.on)
+		if tier == nil || list.Name != tt.list || tier.UnitPrice != MustParseMoney(tt.want) {
+			t.Errorf("ResolvePrice(%s x%d on %s) = %v, %v; want %s from %s", tt.product, tt.quantity, tt.on.Format("2006-01-02"), list, tier, tt.want, tt.list)
+		}
+	}
+
+	if list, tier := ResolvePrice(lists, "washer", 1, day(15)); list != nil || tier != nil {
+		t.Errorf("unlisted product resolved to %v, %v", list, tier)
+	}
+	group.Active = false
+	if _, tier := ResolvePrice([]PriceList{*group}, "bolt", 10, day(15)); tier != nil {
+		t.Errorf("inactive list priced a line: %v", tier)
+	}
+}
@@ -11,22 +11,26 @@ type Product struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	SKU         string    `json:"sku"`
-	Price       float64   `json:"price"`
+	Price       Money     `json:"price"`
+	Currency    string    `json:"currency"` // Currency the price is in
 	Quantity    int       `json:"quantity"`
 	Category    string    `json:"category"`
+	TaxCategory string    `json:"tax_category"` // Which tax rate applies, see TaxRate
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
-func NewProduct(name, description, sku, category string, price float64, quantity int) *Product {
+func NewProduct(name, description, sku, category string, price Money, quantity int) *Product {
 	return &Product{
 		ID:          uuid.New().String(),
 		Name:        name,
 		Description: description,
 		SKU:         sku,
 		Price:       price,
+		Currency:    BaseCurrency.Code,
 		Quantity:    quantity,
 		Category:    category,
+		TaxCategory: TaxCategoryStandard,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
@@ -0,0 +1,307 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Promotion types
+const (
+	PromotionTypePercentage = "percentage"  // Value per cent off
+	PromotionTypeFixed      = "fixed"       // Value off each unit (line scope) or off the order
+	PromotionTypeBuyXGetY   = "buy_x_get_y" // Of every BuyQuantity + GetQuantity units, GetQuantity are free
+)
+
+// Promotion scopes
+const (
+	PromotionScopeLine  = "line"  // Discounts each eligible line on its own
+	PromotionScopeOrder = "order" // Discounts the eligible lines' total, shared across them
+)
+
+// Promotion is a discount rule. It applies while active inside its window to orders
+// from eligible customers, on lines for eligible products; empty eligibility fields
+// match everything. A promotion that requires a coupon applies only to orders that
+// redeem one of its codes.
+type Promotion struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	Type           string     `json:"type"`  // percentage, fixed, buy_x_get_y
+	Scope          string     `json:"scope"` // line, order
+	Value          Money      `json:"value"` // Percent off, or amount off in Currency
+	Currency       string     `json:"currency"`
+	BuyQuantity    int        `json:"buy_quantity,omitempty"`
+	GetQuantity    int        `json:"get_quantity,omitempty"`
+	MinOrderAmount Money      `json:"min_order_amount"` // Order scope only, in Currency
+	ProductID      *string    `json:"product_id,omitempty"`
+	Category       string     `json:"category,omitempty"`
+	CustomerID     *string    `json:"customer_id,omitempty"`
+	CustomerGroup  string     `json:"customer_group,omitempty"`
+	StartsAt       time.Time  `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at,omitempty"`
+	RequiresCoupon bool       `json:"requires_coupon"`
+	Active         bool       `json:"active"`
+	CreatedBy      string     `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// Coupon is a code that unlocks a promotion, with optional limits on how many orders
+// may use it in total and per customer
+type Coupon struct {
+	ID               string    `json:"id"`
+	Code             string    `json:"code"`
+	PromotionID      string    `json:"promotion_id"`
+	UsageLimit       int       `json:"usage_limit"`        // Unlimited when 0
+	PerCustomerLimit int       `json:"per_customer_limit"` // Unlimited when 0
+	TimesUsed        int       `json:"times_used"`
+	Active           bool      `json:"active"`
+	CreatedBy        string    `json:"created_by"`
+	CreatedAt        time.Time `json:"created_at"`
+
+	PromotionName string `json:"promotion_name,omitempty"` // For joins
+}
+
+// OrderItemDiscount is one promotion's discount on one order line. Order-scope
+// discounts are shared across the lines they cover, so each line carries its part.
+type OrderItemDiscount struct {
+	ID          string  `json:"id"`
+	OrderID     string  `json:"order_id"`
+	OrderItemID string  `json:"order_item_id"`
+	PromotionID string  `json:"promotion_id"`
+	CouponID    *string `json:"coupon_id,omitempty"`
+	Description string  `json:"description"` // The promotion's name when the order was placed
+	Scope       string  `json:"scope"`
+	Amount      Money   `json:"amount"`
+}
+
+// PromotionContext is what decides which promotions an order and its lines qualify for
+type PromotionContext struct {
+	CustomerID    string
+	CustomerGroup string
+	Categories    map[string]string // Category of each product on the order
+	Currency      Currency          // The order's; discounts are rounded to it
+	On            time.Time
+	Coupon        *Coupon // Redeemed on the order, if any
+}
+
+func NewPromotion(name, description, promotionType, scope string, value Money, currency string, startsAt time.Time, endsAt *time.Time, createdBy string) *Promotion {
+	return &Promotion{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Description: description,
+		Type:        promotionType,
+		Scope:       scope,
+		Value:       value,
+		Currency:    currency,
+		StartsAt:    startsAt,
+		EndsAt:      endsAt,
+		Active:      true,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+func NewCoupon(code, promotionID string, usageLimit, perCustomerLimit int, createdBy string) *Coupon {
+	return &Coupon{
+		ID:               uuid.New().String(),
+		Code:             NormalizeCouponCode(code),
+		PromotionID:      promotionID,
+		UsageLimit:       usageLimit,
+		PerCustomerLimit: perCustomerLimit,
+		Active:           true,
+		CreatedBy:        createdBy,
+		CreatedAt:        time.Now(),
+	}
+}
+
+// NormalizeCouponCode makes codes case-insensitive: "spring10 " and "SPRING10" are one code
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Exhausted reports whether the coupon has been used as often as it may be
+func (c *Coupon) Exhausted() bool {
+	return c.UsageLimit > 0 && c.TimesUsed >= c.UsageLimit
+}
+
+// ActiveAt reports whether the promotion is switched on and t is inside its window,
+// both ends included
+func (p *Promotion) ActiveAt(t time.Time) bool {
+	if !p.Active || t.Before(p.StartsAt) {
+		return false
+	}
+	return p.EndsAt == nil || !t.After(*p.EndsAt)
+}
+
+// Eligible reports whether an order qualifies for the promotion, regardless of its lines
+func (p *Promotion) Eligible(ctx PromotionContext) bool {
+	if !p.ActiveAt(ctx.On) {
+		return false
+	}
+	if p.RequiresCoupon && (ctx.Coupon == nil || ctx.Coupon.PromotionID != p.ID) {
+		return false
+	}
+	if p.CustomerID != nil && *p.CustomerID != ctx.CustomerID {
+		return false
+	}
+	return p.CustomerGroup == "" || p.CustomerGroup == ctx.CustomerGroup
+}
+
+// Covers reports whether the promotion applies to a line's product
+func (p *Promotion) Covers(item *OrderItem, ctx PromotionContext) bool {
+	if p.ProductID != nil && *p.ProductID != item.ProductID {
+		return false
+	}
+	return p.Category == "" || strings.EqualFold(p.Category, ctx.Categories[item.ProductID])
+}
+
+// LineDiscount is what a line-scope promotion takes off a line, rounded to the order's
+// currency and never more than the line is worth. Fixed amounts must already be in the
+// order's currency.
+func (p *Promotion) LineDiscount(item *OrderItem, currency Currency) Money {
+	gross := item.GrossTotal(currency)
+	var discount Money
+	switch p.Type {
+	case PromotionTypePercentage:
+		discount = currency.Round(gross.Percent(p.Value))
+	case PromotionTypeFixed:
+		discount = currency.Round(p.Value.Mul(item.Quantity))
+	case PromotionTypeBuyXGetY:
+		if group := p.BuyQuantity + p.GetQuantity; p.GetQuantity > 0 && group > 0 {
+			free := item.Quantity / group * p.GetQuantity
+			discount = currency.Round(item.UnitPrice.Mul(free))
+		}
+	}
+	if discount > gross {
+		discount = gross
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+// orderDiscount is what an order-scope promotion takes off the total of the lines it
+// covers, rounded to the order's currency
+func (p *Promotion) orderDiscount(base Money, currency Currency) Money {
+	var discount Money
+	switch p.Type {
+	case PromotionTypePercentage:
+		discount = currency.Round(base.Percent(p.Value))
+	case PromotionTypeFixed:
+		discount = currency.Round(p.Value)
+	}
+	if discount > base {
+		discount = base
+	}
+	if discount < 0 {
+		discount = 0
+	}
+	return discount
+}
+
+// ApplyPromotions discounts an order's lines. Each line gets the best of the line-scope
+// promotions it qualifies for, then the order gets the best order-scope promotion whose
+// minimum it meets, worked out on the line totals after line discounts and shared across
+// the lines it covers in proportion to their totals. Promotions do not otherwise stack.
+// Fixed amounts and minimums must already be in the order's currency.
+func ApplyPromotions(items []*OrderItem, promotions []Promotion, ctx PromotionContext) {
+	var eligible []*Promotion
+	for i := range promotions {
+		if promotions[i].Eligible(ctx) {
+			eligible = append(eligible, &promotions[i])
+		}
+	}
+	// Sorting settles ties between equal discounts the same way every time
+	sort.SliceStable(eligible, func(i, j int) bool { return eligible[i].ID < eligible[j].ID })
+
+	for _, item := range items {
+		var best *Promotion
+		var bestDiscount Money
+		for _, promotion := range eligible {
+			if promotion.Scope != PromotionScopeLine || !promotion.Covers(item, ctx) {
+				continue
+			}
+			if discount := promotion.LineDiscount(item, ctx.Currency); discount > bestDiscount {
+				best, bestDiscount = promotion, discount
+			}
+		}
+		if best != nil {
+			item.AddDiscount(best, ctx.Coupon, bestDiscount, ctx.Currency)
+		}
+	}
+
+	subtotal := OrderItemsTotal(items)
+	var best *Promotion
+	var bestDiscount Money
+	var bestLines []*OrderItem
+	for _, promotion := range eligible {
+		if promotion.Scope != PromotionScopeOrder || subtotal < promotion.MinOrderAmount {
+			continue
+		}
+		var lines []*OrderItem
+		for _, item := range items {
+			if promotion.Covers(item, ctx) && item.TotalPrice > 0 {
+				lines = append(lines, item)
+			}
+		}
+		if discount := promotion.orderDiscount(OrderItemsTotal(lines), ctx.Currency); discount > bestDiscount {
+			best, bestDiscount, bestLines = promotion, discount, lines
+		}
+	}
+	if best != nil {
+		shares := shareDiscount(bestDiscount, bestLines, ctx.Currency)
+		for i, item := range bestLines {
+			if shares[i] > 0 {
+				item.AddDiscount(best, ctx.Coupon, shares[i], ctx.Currency)
+			}
+		}
+	}
+}
+
+// shareDiscount splits an order discount across lines in proportion to their totals,
+// each share rounded to the currency. Rounding differences go to the largest line so
+// the shares add up to the discount exactly.
+func shareDiscount(discount Money, lines []*OrderItem, currency Currency) []Money {
+	shares := make([]Money, len(lines))
+	base := OrderItemsTotal(lines)
+	if base <= 0 {
+		return shares
+	}
+	largest := 0
+	var allocated Money
+	for i, line := range lines {
+		shares[i] = currency.Round(discount.MulDiv(int64(line.TotalPrice), int64(base)))
+		allocated += shares[i]
+		if line.TotalPrice > lines[largest].TotalPrice {
+			largest = i
+		}
+	}
+	shares[largest] += discount - allocated
+	return shares
+}
+
+// AddDiscount records a promotion's discount on the line and takes it off the line total
+func (i *OrderItem) AddDiscount(promotion *Promotion, coupon *Coupon, amount Money, currency Currency) {
+	discount := OrderItemDiscount{
+		ID:          uuid.New().String(),
+		OrderID:     i.OrderID,
+		OrderItemID: i.ID,
+		PromotionID: promotion.ID,
+		Description: promotion.Name,
+		Scope:       promotion.Scope,
+		Amount:      amount,
+	}
+	if coupon != nil && coupon.PromotionID == promotion.ID {
+		couponID := coupon.ID
+		discount.CouponID = &couponID
+	}
+	i.Discounts = append(i.Discounts, discount)
+	i.DiscountAmount += amount
+	i.CalculateTotal(currency)
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyPromotions(t *testing.T) {
+	on := time.Date(2026, time.May, 15, 12, 0, 0, 0, time.UTC)
+	start := on.AddDate(0, 0, -7)
+	usd := BaseCurrency
+
+	tenOff := NewPromotion("10% off tools", "", PromotionTypePercentage, PromotionScopeLine, MustParseMoney("10"), "USD", start, nil, "")
+	tenOff.Category = "tools"
+	threeForTwo := NewPromotion("3 for 2 bolts", "", PromotionTypeBuyXGetY, PromotionScopeLine, 0, "USD", start, nil, "")
+	bolt := "bolt"
+	threeForTwo.ProductID = &bolt
+	threeForTwo.BuyQuantity, threeForTwo.GetQuantity = 2, 1
+	fiveOff := NewPromotion("5 off orders over 50", "", PromotionTypeFixed, PromotionScopeOrder, MustParseMoney("5"), "USD", start, nil, "")
+	fiveOff.MinOrderAmount = MustParseMoney("50")
+	expired := NewPromotion("Spring sale", "", PromotionTypePercentage, PromotionScopeLine, MustParseMoney("50"), "USD", start, &start, "")
+
+	ctx := PromotionContext{
+		CustomerID: "c1",
+		Categories: map[string]string{"bolt": "hardware", "drill": "Tools"},
+		Currency:   usd,
+		On:         on,
+	}
+	boltLine := NewOrderItem("", "bolt", 7, MustParseMoney("1.5"), usd)
+	drillLine := NewOrderItem("", "drill", 1, MustParseMoney("49.99"), usd)
+	items := []*OrderItem{boltLine, drillLine}
+	ApplyPromotions(items, []Promotion{*tenOff, *threeForTwo, *fiveOff, *expired}, ctx)
+
+	// 7 bolts: two free (3.00 off 10.50); drill: 10% of 49.99 = 5.00 (4.999 rounded);
+	// lines now 7.50 + 44.99 = 52.49, so the order takes 5 off, shared 0.71 / 4.29
+	if got, want := boltLine.DiscountAmount, MustParseMoney("3.71"); got != want {
+		t.Errorf("bolt discount = %s, want %s", got, want)
+	}
+	if got, want := drillLine.DiscountAmount, MustParseMoney("9.29"); got != want {
+		t.Errorf("drill discount = %s, want %s", got, want)
+	}
+	if got, want := OrderItemsTotal(items), MustParseMoney("47.49"); got != want {
+		t.Errorf("order total = %s, want %s", got, want)
+	}
+	if len(boltLine.Discounts) != 2 || boltLine.Discounts[0].PromotionID != threeForTwo.ID || boltLine.Discounts[1].Scope != PromotionScopeOrder {
+		t.Errorf("bolt discounts = %+v", boltLine.Discounts)
+	}
+
+	// A coupon-only promotion needs its coupon, and the coupon marks its discounts
+	members := NewPromotion("Members 20%", "", PromotionTypePercentage, PromotionScopeLine, MustParseMoney("20"), "USD", start, nil, "")
+	members.RequiresCoupon = true
+	line := NewOrderItem("", "drill", 1, MustParseMoney("100"), usd)
+	ApplyPromotions([]*OrderItem{line}, []Promotion{*members}, ctx)
+	if line.DiscountAmount != 0 {
+		t.Errorf("coupon promotion applied without a coupon: %s", line.DiscountAmount)
+	}
+	coupon := NewCoupon(" members ", members.ID, 0, 1, "")
+	ctx.Coupon = coupon
+	ApplyPromotions([]*OrderItem{line}, []Promotion{*members, *tenOff}, ctx)
+	if line.DiscountAmount != MustParseMoney("20") || len(line.Discounts) != 1 ||
+		line.Discounts[0].CouponID == nil || *line.Discounts[0].CouponID != coupon.ID {
+		t.Errorf("coupon line = %s %+v", line.DiscountAmount, line.Discounts)
+	}
+	if coupon.Code != "MEMBERS" {
+		t.Errorf("coupon code = %q", coupon.Code)
+	}
+
+	// A discount never takes a line below zero
+	big := NewPromotion("100 off", "", PromotionTypeFixed, PromotionScopeLine, MustParseMoney("100"), "USD", start, nil, "")
+	cheap := NewOrderItem("", "bolt", 2, MustParseMoney("1.5"), usd)
+	ApplyPromotions([]*OrderItem{cheap}, []Promotion{*big}, PromotionContext{Currency: usd, On: on})
+	if cheap.TotalPrice != 0 || cheap.DiscountAmount != MustParseMoney("3") {
+		t.Errorf("over-discounted line = %s off, total %s", cheap.DiscountAmount, cheap.TotalPrice)
+	}
+}
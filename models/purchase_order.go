@@ -0,0 +1,172 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purchase order statuses
+const (
+	PurchaseOrderStatusDraft             = "draft"
+	PurchaseOrderStatusApproved          = "approved"
+	PurchaseOrderStatusSent              = "sent"
+	PurchaseOrderStatusPartiallyReceived = "partially_received"
+	PurchaseOrderStatusReceived          = "received"
+	PurchaseOrderStatusClosed            = "closed"
+	PurchaseOrderStatusCancelled         = "cancelled"
+)
+
+// purchaseOrderStatusTransitions lists the statuses a purchase order may move to from
+// each status. Received, closed and cancelled are final.
+var purchaseOrderStatusTransitions = map[string][]string{
+	PurchaseOrderStatusDraft:             {PurchaseOrderStatusApproved, PurchaseOrderStatusCancelled},
+	PurchaseOrderStatusApproved:          {PurchaseOrderStatusSent, PurchaseOrderStatusCancelled},
+	PurchaseOrderStatusSent:              {PurchaseOrderStatusPartiallyReceived, PurchaseOrderStatusReceived, PurchaseOrderStatusCancelled},
+	PurchaseOrderStatusPartiallyReceived: {PurchaseOrderStatusReceived, PurchaseOrderStatusClosed},
+}
+
+type PurchaseOrder struct {
+	ID           string     `json:"id"`
+	PONumber     string     `json:"po_number"`
+	SupplierID   string     `json:"supplier_id"`
+	WarehouseID  *string    `json:"warehouse_id,omitempty"` // Ship-to warehouse
+	Status       string     `json:"status"`
+	OrderDate    time.Time  `json:"order_date"`
+	ExpectedDate *time.Time `json:"expected_date,omitempty"` // Latest line expected date
+	Currency     string     `json:"currency"`                // Currency the lines are costed in
+	TotalAmount  Money      `json:"total_amount"`
+	Notes        string     `json:"notes"`
+	CreatedBy    string     `json:"created_by"`
+	ApprovedBy   string     `json:"approved_by,omitempty"`
+	ApprovedAt   *time.Time `json:"approved_at,omitempty"`
+	SentAt       *time.Time `json:"sent_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	Lines []PurchaseOrderLine `json:"lines,omitempty"`
+
+	// For joins
+	SupplierName  string `json:"supplier_name,omitempty"`
+	WarehouseName string `json:"warehouse_name,omitempty"`
+}
+
+type PurchaseOrderLine struct {
+	ID               string     `json:"id"`
+	PurchaseOrderID  string     `json:"purchase_order_id"`
+	ProductID        string     `json:"product_id"`
+	SupplierSKU      string     `json:"supplier_sku"`
+	Quantity         int        `json:"quantity"`
+	ReceivedQuantity int        `json:"received_quantity"`
+	BilledQuantity   int        `json:"billed_quantity"` // On supplier bills that are not void
+	UnitCost         Money      `json:"unit_cost"`
+	TotalCost        Money      `json:"total_cost"` // Calculated: quantity * unit_cost, rounded
+	LeadTimeDays     int        `json:"lead_time_days"`
+	ExpectedDate     *time.Time `json:"expected_date,omitempty"`
+
+	// For joins
+	ProductName string `json:"product_name,omitempty"`
+	SKU         string `json:"sku,omitempty"`
+}
+
+// PurchaseOrderReceipt records goods received against one purchase order line and the
+// location they were put away into
+type PurchaseOrderReceipt struct {
+	ID                  string    `json:"id"`
+	PurchaseOrderID     string    `json:"purchase_order_id"`
+	PurchaseOrderLineID string    `json:"purchase_order_line_id"`
+	ProductID           string    `json:"product_id"`
+	WarehouseID         string    `json:"warehouse_id"`
+	LocationID          *string   `json:"location_id,omitempty"`
+	InventoryID         *string   `json:"inventory_id,omitempty"`
+	Quantity            int       `json:"quantity"`
+	Notes               string    `json:"notes,omitempty"`
+	ReceivedBy          string    `json:"received_by,omitempty"`
+	ReceivedAt          time.Time `json:"received_at"`
+
+	// For joins
+	LocationCode string `json:"location_code,omitempty"`
+}
+
+func NewPurchaseOrder(supplierID string, warehouseID *string, notes, createdBy string) *PurchaseOrder {
+	now := time.Now()
+	id := uuid.New().String()
+	return &PurchaseOrder{
+		ID:          id,
+		PONumber:    fmt.Sprintf("PO-%s-%s", now.Format("20060102"), strings.ToUpper(id[:6])),
+		SupplierID:  supplierID,
+		WarehouseID: warehouseID,
+		Currency:    BaseCurrency.Code,
+		Status:      PurchaseOrderStatusDraft,
+		OrderDate:   now,
+		Notes:       notes,
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// NewPurchaseOrderLine costs a line in the purchase order's currency
+func NewPurchaseOrderLine(purchaseOrderID, productID, supplierSKU string, quantity int, unitCost Money, currency Currency, leadTimeDays int) *PurchaseOrderLine {
+	return &PurchaseOrderLine{
+		ID:              uuid.New().String(),
+		PurchaseOrderID: purchaseOrderID,
+		ProductID:       productID,
+		SupplierSKU:     supplierSKU,
+		Quantity:        quantity,
+		UnitCost:        unitCost,
+		TotalCost:       currency.Round(unitCost.Mul(quantity)),
+		LeadTimeDays:    leadTimeDays,
+	}
+}
+
+func NewPurchaseOrderReceipt(line *PurchaseOrderLine, warehouseID string, locationID *string, quantity int, notes, receivedBy string) *PurchaseOrderReceipt {
+	return &PurchaseOrderReceipt{
+		ID:                  uuid.New().String(),
+		PurchaseOrderID:     line.PurchaseOrderID,
+		PurchaseOrderLineID: line.ID,
+		ProductID:           line.ProductID,
+		WarehouseID:         warehouseID,
+		LocationID:          locationID,
+		Quantity:            quantity,
+		Notes:               notes,
+		ReceivedBy:          receivedBy,
+		ReceivedAt:          time.Now(),
+	}
+}
+
+// Outstanding returns how much of a line has not been received yet. Over-received
+// lines have nothing outstanding.
+func (l *PurchaseOrderLine) Outstanding() int {
+	if l.ReceivedQuantity >= l.Quantity {
+		return 0
+	}
+	return l.Quantity - l.ReceivedQuantity
+}
+
+// ScheduleFrom sets each line's expected date to from plus its lead time, the order's
+// expected date to the latest of them, and the order total to the sum of the lines
+func (po *PurchaseOrder) ScheduleFrom(from time.Time) {
+	po.ExpectedDate = nil
+	po.TotalAmount = 0
+	for i := range po.Lines {
+		expected := from.AddDate(0, 0, po.Lines[i].LeadTimeDays)
+		po.Lines[i].ExpectedDate = &expected
+		if po.ExpectedDate == nil || expected.After(*po.ExpectedDate) {
+			po.ExpectedDate = &expected
+		}
+		po.TotalAmount += po.Lines[i].TotalCost
+	}
+}
+
+// CanTransitionPurchaseOrderStatus reports whether a purchase order may move from one status to another
+func CanTransitionPurchaseOrderStatus(from, to string) bool {
+	for _, status := range purchaseOrderStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
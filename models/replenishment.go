@@ -0,0 +1,91 @@
+package models
+
+// ReplenishmentSuggestion proposes how much of a product to buy for a warehouse whose
+// stock has fallen below its minimum. Quantities are summed over the product's
+// inventory rows in the warehouse; the minimum and maximum are the highest set on any
+// of them.
+type ReplenishmentSuggestion struct {
+	ProductID         string  `json:"product_id"`
+	WarehouseID       string  `json:"warehouse_id"`
+	OnHandQuantity    int     `json:"on_hand_quantity"`
+	ReservedQuantity  int     `json:"reserved_quantity"`
+	AvailableQuantity int     `json:"available_quantity"`
+	OnOrderQuantity   int     `json:"on_order_quantity"` // Outstanding on open purchase orders
+	MinQuantity       int     `json:"min_quantity"`
+	MaxQuantity       *int    `json:"max_quantity,omitempty"`
+	SuggestedQuantity int     `json:"suggested_quantity"`
+	SupplierID        *string `json:"supplier_id,omitempty"` // Nil when no supplier is linked
+	SupplierSKU       string  `json:"supplier_sku,omitempty"`
+	UnitCost          Money   `json:"unit_cost"`
+	Currency          string  `json:"currency"` // Currency the supplier quotes the unit cost in
+	LeadTimeDays      int     `json:"lead_time_days"`
+	EstimatedCost     Money   `json:"estimated_cost"`
+
+	// For joins
+	ProductName   string `json:"product_name,omitempty"`
+	SKU           string `json:"sku,omitempty"`
+	WarehouseName string `json:"warehouse_name,omitempty"`
+	SupplierName  string `json:"supplier_name,omitempty"`
+}
+
+// ReplenishmentGroup collects the suggestions that would go on one purchase order
+type ReplenishmentGroup struct {
+	SupplierID    *string                   `json:"supplier_id,omitempty"`
+	SupplierName  string                    `json:"supplier_name,omitempty"`
+	WarehouseID   string                    `json:"warehouse_id"`
+	WarehouseName string                    `json:"warehouse_name,omitempty"`
+	Currency      string                    `json:"currency"`
+	EstimatedCost Money                     `json:"estimated_cost"`
+	Suggestions   []ReplenishmentSuggestion `json:"suggestions"`
+}
+
+// Calculate works out the order-up-to quantity: enough to bring available stock plus
+// stock already on order up to the maximum, or up to the minimum when no usable
+// maximum is set. It returns false when the product does not need reordering.
+func (s *ReplenishmentSuggestion) Calculate() bool {
+	position := s.AvailableQuantity + s.OnOrderQuantity
+	if position >= s.MinQuantity {
+		s.SuggestedQuantity = 0
+		s.EstimatedCost = 0
+		return false
+	}
+
+	target := s.MinQuantity
+	if s.MaxQuantity != nil && *s.MaxQuantity > target {
+		target = *s.MaxQuantity
+	}
+
+	s.SuggestedQuantity = target - position
+	s.EstimatedCost = CurrencyOf(s.Currency).Round(s.UnitCost.Mul(s.SuggestedQuantity))
+	return true
+}
+
+// GroupReplenishmentSuggestions groups suggestions per supplier, warehouse and currency,
+// keeping the order in which each group first appears. A supplier quoting in two
+// currencies gets a purchase order for each.
+func GroupReplenishmentSuggestions(suggestions []ReplenishmentSuggestion) []ReplenishmentGroup {
+	groups := []ReplenishmentGroup{}
+	index := map[string]int{}
+	for _, suggestion := range suggestions {
+		key := suggestion.WarehouseID + "/" + suggestion.Currency
+		if suggestion.SupplierID != nil {
+			key = *suggestion.SupplierID + "/" + key
+		}
+
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, ReplenishmentGroup{
+				SupplierID:    suggestion.SupplierID,
+				SupplierName:  suggestion.SupplierName,
+				WarehouseID:   suggestion.WarehouseID,
+				WarehouseName: suggestion.WarehouseName,
+				Currency:      suggestion.Currency,
+			})
+		}
+		groups[i].Suggestions = append(groups[i].Suggestions, suggestion)
+		groups[i].EstimatedCost += suggestion.EstimatedCost
+	}
+	return groups
+}
@@ -0,0 +1,205 @@
+package models
+
+import "strings"
+
+// Roles a user can hold
+const (
+	RoleAdmin      = "admin"
+	RoleSales      = "sales"
+	RoleWarehouse  = "warehouse"
+	RolePurchasing = "purchasing"
+	RoleFinance    = "finance"
+	RoleViewer     = "viewer"
+)
+
+// Permissions are resource:action pairs checked on every protected route
+const (
+	PermissionProductsRead        = "products:read"
+	PermissionProductsCreate      = "products:create"
+	PermissionProductsUpdate      = "products:update"
+	PermissionProductsUpdatePrice = "products:update_price"
+	PermissionProductsDelete      = "products:delete"
+
+	PermissionCustomersRead   = "customers:read"
+	PermissionCustomersCreate = "customers:create"
+	PermissionCustomersUpdate = "customers:update"
+	PermissionCustomersDelete = "customers:delete"
+
+	PermissionOrdersRead   = "orders:read"
+	PermissionOrdersCreate = "orders:create"
+	PermissionOrdersUpdate = "orders:update" // Status transitions
+	PermissionOrdersCancel = "orders:cancel"
+
+	PermissionSuppliersRead   = "suppliers:read"
+	PermissionSuppliersCreate = "suppliers:create"
+	PermissionSuppliersUpdate = "suppliers:update"
+	PermissionSuppliersDelete = "suppliers:delete"
+
+	PermissionWarehousesRead   = "warehouses:read"
+	PermissionWarehousesCreate = "warehouses:create"
+	PermissionWarehousesUpdate = "warehouses:update"
+	PermissionWarehousesDelete = "warehouses:delete"
+
+	PermissionInventoryRead   = "inventory:read"
+	PermissionInventoryAdjust = "inventory:adjust"
+
+	PermissionTransfersRead     = "transfers:read"
+	PermissionTransfersCreate   = "transfers:create"
+	PermissionTransfersDispatch = "transfers:dispatch"
+	PermissionTransfersReceive  = "transfers:receive"
+	PermissionTransfersCancel   = "transfers:cancel"
+
+	PermissionPurchaseOrdersRead    = "purchase_orders:read"
+	PermissionPurchaseOrdersCreate  = "purchase_orders:create"
+	PermissionPurchaseOrdersApprove = "purchase_orders:approve"
+	PermissionPurchaseOrdersSend    = "purchase_orders:send"
+	PermissionPurchaseOrdersReceive = "purchase_orders:receive"
+	PermissionPurchaseOrdersCancel  = "purchase_orders:cancel" // Also covers closing short
+
+	PermissionReplenishmentRead   = "replenishment:read"
+	PermissionReplenishmentCreate = "replenishment:create"
+
+	PermissionExchangeRatesRead   = "exchange_rates:read"
+	PermissionExchangeRatesManage = "exchange_rates:manage" // Load rates by API or CSV
+
+	PermissionPriceListsRead   = "price_lists:read"
+	PermissionPriceListsManage = "price_lists:manage"
+
+	PermissionPromotionsRead   = "promotions:read"
+	PermissionPromotionsManage = "promotions:manage" // Also covers issuing coupons
+
+	PermissionTaxRead   = "tax:read" // Rates and the tax summary
+	PermissionTaxManage = "tax:manage"
+
+	PermissionInvoicesRead   = "invoices:read"
+	PermissionInvoicesCreate = "invoices:create" // Drafts and credit notes
+	PermissionInvoicesIssue  = "invoices:issue"
+	PermissionInvoicesVoid   = "invoices:void"
+
+	PermissionPaymentsRead   = "payments:read"   // Also customer balances
+	PermissionPaymentsCreate = "payments:create" // Also allocating credit to invoices
+	PermissionPaymentsVoid   = "payments:void"
+
+	PermissionBillsRead    = "bills:read" // Also the payables aging
+	PermissionBillsCreate  = "bills:create"
+	PermissionBillsApprove = "bills:approve" // Also accepting match variances
+	PermissionBillsPay     = "bills:pay"
+	PermissionBillsVoid    = "bills:void"
+
+	PermissionUsersManage = "users:manage"
+	PermissionAuditRead   = "audit:read"
+	PermissionSystemDebug = "system:debug"
+)
+
+// permissionAll grants every permission
+const permissionAll = "*"
+
+// rolePermissions lists what each role may do. A permission ending in ":*" grants
+// every action on that resource.
+var rolePermissions = map[string][]string{
+	RoleAdmin: {permissionAll},
+	RoleSales: {
+		PermissionProductsRead,
+		"customers:*",
+		"orders:*",
+		PermissionInventoryRead,
+		PermissionWarehousesRead,
+		PermissionExchangeRatesRead,
+		PermissionPriceListsRead,
+		PermissionPromotionsRead,
+		PermissionTaxRead,
+		PermissionInvoicesRead,
+		PermissionPaymentsRead,
+	},
+	RoleWarehouse: {
+		PermissionProductsRead,
+		PermissionProductsUpdate,
+		PermissionOrdersRead,
+		PermissionOrdersUpdate,
+		PermissionSuppliersRead,
+		"warehouses:*",
+		"inventory:*",
+		"transfers:*",
+		PermissionPurchaseOrdersRead,
+		PermissionPurchaseOrdersReceive,
+		PermissionReplenishmentRead,
+	},
+	RolePurchasing: {
+		PermissionProductsRead,
+		"suppliers:*",
+		"purchase_orders:*",
+		"replenishment:*",
+		PermissionInventoryRead,
+		PermissionWarehousesRead,
+		PermissionExchangeRatesRead,
+		PermissionBillsRead,
+	},
+	RoleFinance: {
+		PermissionProductsRead,
+		PermissionCustomersRead,
+		PermissionCustomersUpdate, // Payment terms
+		PermissionOrdersRead,
+		PermissionExchangeRatesRead,
+		PermissionTaxRead,
+		PermissionSuppliersRead,
+		PermissionPurchaseOrdersRead,
+		"invoices:*",
+		"payments:*",
+		"bills:*",
+	},
+	RoleViewer: {
+		PermissionProductsRead,
+		PermissionCustomersRead,
+		PermissionOrdersRead,
+		PermissionSuppliersRead,
+		PermissionWarehousesRead,
+		PermissionInventoryRead,
+		PermissionTransfersRead,
+		PermissionPurchaseOrdersRead,
+		PermissionReplenishmentRead,
+		PermissionExchangeRatesRead,
+		PermissionPriceListsRead,
+		PermissionPromotionsRead,
+		PermissionTaxRead,
+		PermissionInvoicesRead,
+		PermissionPaymentsRead,
+		PermissionBillsRead,
+	},
+}
+
+// Roles returns every defined role
+func Roles() []string {
+	return []string{RoleAdmin, RoleSales, RoleWarehouse, RolePurchasing, RoleFinance, RoleViewer}
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolePermissions returns the permissions granted to a role
+func RolePermissions(role string) []string {
+	return rolePermissions[role]
+}
+
+// HasPermission reports whether any of the user's roles grants the permission
+func (u *User) HasPermission(permission string) bool {
+	resource, _, _ := strings.Cut(permission, ":")
+	for _, role := range u.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permissionAll || granted == permission || granted == resource+":*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stock movement types
+const (
+	MovementTypeReceipt         = "receipt"
+	MovementTypeIssue           = "issue"
+	MovementTypeAdjustment      = "adjustment"
+	MovementTypeTransfer        = "transfer"
+	MovementTypeReturn          = "return"
+	MovementTypeCountCorrection = "count_correction"
+)
+
+// Documents a stock movement can point back to
+const (
+	ReferenceTypeOrder         = "order"
+	ReferenceTypeProduct       = "product"
+	ReferenceTypeInventory     = "inventory"
+	ReferenceTypeTransferOrder = "transfer_order"
+	ReferenceTypePurchaseOrder = "purchase_order"
+)
+
+// StockMovement is one immutable entry in the stock ledger. Movements without a
+// warehouse record changes to products.quantity; the others record changes to a
+// single inventory row.
+type StockMovement struct {
+	ID             string    `json:"id"`
+	MovementType   string    `json:"movement_type"`
+	ProductID      string    `json:"product_id"`
+	WarehouseID    *string   `json:"warehouse_id,omitempty"`
+	LocationID     *string   `json:"location_id,omitempty"`
+	InventoryID    *string   `json:"inventory_id,omitempty"`
+	Quantity       int       `json:"quantity"` // Signed: negative when stock leaves
+	QuantityBefore int       `json:"quantity_before"`
+	QuantityAfter  int       `json:"quantity_after"`
+	ReferenceType  string    `json:"reference_type,omitempty"`
+	ReferenceID    string    `json:"reference_id,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	CreatedBy      string    `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// For joins
+	ProductName   string `json:"product_name,omitempty"`
+	SKU           string `json:"sku,omitempty"`
+	WarehouseName string `json:"warehouse_name,omitempty"`
+	LocationCode  string `json:"location_code,omitempty"`
+}
+
+func NewStockMovement(movementType, productID string, quantity int, referenceType, referenceID, reason, createdBy string) *StockMovement {
+	return &StockMovement{
+		ID:            uuid.New().String(),
+		MovementType:  movementType,
+		ProductID:     productID,
+		Quantity:      quantity,
+		ReferenceType: referenceType,
+		ReferenceID:   referenceID,
+		Reason:        reason,
+		CreatedBy:     createdBy,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// IsValidMovementType reports whether t is one of the stock movement types
+func IsValidMovementType(t string) bool {
+	switch t {
+	case MovementTypeReceipt, MovementTypeIssue, MovementTypeAdjustment,
+		MovementTypeTransfer, MovementTypeReturn, MovementTypeCountCorrection:
+		return true
+	}
+	return false
+}
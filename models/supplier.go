@@ -7,18 +7,18 @@ import (
 )
 
 type Supplier struct {
-	ID            string    `json:"id"`
-	Name          string    `json:"name"`
-	Code          string    `json:"code"`
-	ContactPerson string    `json:"contact_person"`
-	Email         string    `json:"email"`
-	Phone         string    `json:"phone"`
-	Address       string    `json:"address"`
-	TaxID         string    `json:"tax_id"`
-	PaymentTerms  string    `json:"payment_terms"`
-	Status        string    `json:"status"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Code          string       `json:"code"`
+	ContactPerson string       `json:"contact_person"`
+	Email         string       `json:"email"`
+	Phone         string       `json:"phone"`
+	Address       string       `json:"address"`
+	TaxID         string       `json:"tax_id"`
+	PaymentTerms  PaymentTerms `json:"payment_terms"` // When its bills fall due
+	Status        string       `json:"status"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
 }
 
 type ProductSupplier struct {
@@ -26,7 +26,8 @@ type ProductSupplier struct {
 	ProductID    string    `json:"product_id"`
 	SupplierID   string    `json:"supplier_id"`
 	SupplierSKU  string    `json:"supplier_sku"`
-	CostPrice    float64   `json:"cost_price"`
+	CostPrice    Money     `json:"cost_price"`
+	Currency     string    `json:"currency"` // Currency the cost price is in
 	LeadTimeDays int       `json:"lead_time_days"`
 	IsPrimary    bool      `json:"is_primary"`
 	CreatedAt    time.Time `json:"created_at"`
@@ -36,7 +37,7 @@ type ProductSupplier struct {
 	SupplierName *Supplier `json:"supplier_name,omitempty"`
 }
 
-func NewSupplier(name, code, contactPerson, email, phone, address, taxID, paymentTerms string) *Supplier {
+func NewSupplier(name, code, contactPerson, email, phone, address, taxID string, paymentTerms PaymentTerms) *Supplier {
 	now := time.Now()
 
 	return &Supplier{
@@ -55,7 +56,7 @@ func NewSupplier(name, code, contactPerson, email, phone, address, taxID, paymen
 	}
 }
 
-func NewProductSupplier(productID, supplierID, supplierSKU string, costPrice float64, leadTimeDays int, isPrimary bool) *ProductSupplier {
+func NewProductSupplier(productID, supplierID, supplierSKU string, costPrice Money, leadTimeDays int, isPrimary bool) *ProductSupplier {
 	now := time.Now()
 
 	return &ProductSupplier{
@@ -64,6 +65,7 @@ func NewProductSupplier(productID, supplierID, supplierSKU string, costPrice flo
 		SupplierID:   supplierID,
 		SupplierSKU:  supplierSKU,
 		CostPrice:    costPrice,
+		Currency:     BaseCurrency.Code,
 		LeadTimeDays: leadTimeDays,
 		IsPrimary:    isPrimary,
 		CreatedAt:    now,
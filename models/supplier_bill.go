@@ -0,0 +1,298 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Supplier bill statuses. Drafts wait for approval before they can be paid; approved
+// bills move between approved, partially_paid and paid as they are paid.
+const (
+	SupplierBillStatusDraft         = "draft"
+	SupplierBillStatusApproved      = "approved"
+	SupplierBillStatusPartiallyPaid = "partially_paid"
+	SupplierBillStatusPaid          = "paid"
+	SupplierBillStatusVoid          = "void"
+)
+
+// Three-way match results. A bill with variances is only approved once someone accepts
+// them.
+const (
+	MatchStatusMatched  = "matched"
+	MatchStatusVariance = "variance"
+)
+
+// Variances a bill line can have
+const (
+	VarianceQuantity = "quantity" // Billed for more units than were received
+	VariancePrice    = "price"    // Billed at a cost outside the tolerance of the agreed cost
+)
+
+// SupplierBill is a supplier's invoice for goods on a purchase order, matched against
+// the order and what was received. Amounts are in the purchase order's currency.
+type SupplierBill struct {
+	ID                string       `json:"id"`
+	BillNumber        string       `json:"bill_number"` // The supplier's own invoice number
+	SupplierID        string       `json:"supplier_id"`
+	PurchaseOrderID   string       `json:"purchase_order_id"`
+	Status            string       `json:"status"`
+	MatchStatus       string       `json:"match_status"`
+	VariancesAccepted bool         `json:"variances_accepted"`
+	Currency          string       `json:"currency"`
+	ExchangeRate      Rate         `json:"exchange_rate"` // On the bill date
+	PaymentTerms      PaymentTerms `json:"payment_terms"`
+	BillDate          time.Time    `json:"bill_date"`
+	DueDate           time.Time    `json:"due_date"`
+	DiscountDate      *time.Time   `json:"discount_date,omitempty"` // Last day to pay at the early payment discount
+
+	// Totals of the lines; TotalAmount includes TaxAmount
+	SubtotalAmount  Money `json:"subtotal_amount"`
+	TaxAmount       Money `json:"tax_amount"`
+	TotalAmount     Money `json:"total_amount"`
+	BaseTotalAmount Money `json:"base_total_amount"`
+
+	// What has settled the bill so far, and what is left to pay
+	AmountPaid    Money `json:"amount_paid"`
+	DiscountTaken Money `json:"discount_taken"`
+	BalanceDue    Money `json:"balance_due"`
+
+	Notes      string     `json:"notes"`
+	VoidReason string     `json:"void_reason,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	ApprovedBy string     `json:"approved_by,omitempty"`
+	ApprovedAt *time.Time `json:"approved_at,omitempty"`
+	VoidedBy   string     `json:"voided_by,omitempty"`
+	VoidedAt   *time.Time `json:"voided_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	Lines    []SupplierBillLine `json:"lines,omitempty"`
+	Payments []SupplierPayment  `json:"payments,omitempty"`
+
+	// For joins
+	SupplierName string `json:"supplier_name,omitempty"`
+	PONumber     string `json:"po_number,omitempty"`
+}
+
+// SupplierBillLine bills units of a purchase order line, keeping what it was matched
+// against when it was entered
+type SupplierBillLine struct {
+	ID                       string `json:"id"`
+	BillID                   string `json:"bill_id"`
+	PurchaseOrderLineID      string `json:"purchase_order_line_id"`
+	ProductID                string `json:"product_id"`
+	Quantity                 int    `json:"quantity"`
+	UnitCost                 Money  `json:"unit_cost"`
+	TotalCost                Money  `json:"total_cost"`
+	OrderedQuantity          int    `json:"ordered_quantity"`
+	ReceivedQuantity         int    `json:"received_quantity"`
+	PreviouslyBilledQuantity int    `json:"previously_billed_quantity"`
+	OrderedUnitCost          Money  `json:"ordered_unit_cost"`
+	AgreedUnitCost           Money  `json:"agreed_unit_cost"` // The supplier's cost price for the product
+
+	// Units billed beyond those received, and how far the unit cost is over (or under)
+	// the agreed cost when that is outside the tolerance
+	QuantityVariance int      `json:"quantity_variance"`
+	PriceVariance    Money    `json:"price_variance"`
+	Variances        []string `json:"variances,omitempty"`
+
+	// For joins
+	ProductName string `json:"product_name,omitempty"`
+	SKU         string `json:"sku,omitempty"`
+}
+
+// SupplierPayment is money paid to a supplier against one bill. DiscountTaken is the
+// early payment discount it settled on top of the amount paid.
+type SupplierPayment struct {
+	ID            string    `json:"id"`
+	BillID        string    `json:"bill_id"`
+	SupplierID    string    `json:"supplier_id"`
+	Method        string    `json:"method"`
+	Reference     string    `json:"reference"`
+	Currency      string    `json:"currency"`
+	Amount        Money     `json:"amount"`
+	DiscountTaken Money     `json:"discount_taken"`
+	PaymentDate   time.Time `json:"payment_date"`
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// NewSupplierBill starts a draft bill for a purchase order, due according to the
+// payment terms. Add lines with AddLine.
+func NewSupplierBill(po *PurchaseOrder, billNumber string, terms PaymentTerms, billDate time.Time, rate Rate, notes, createdBy string) *SupplierBill {
+	now := time.Now()
+	bill := &SupplierBill{
+		ID:              uuid.New().String(),
+		BillNumber:      billNumber,
+		SupplierID:      po.SupplierID,
+		PurchaseOrderID: po.ID,
+		Status:          SupplierBillStatusDraft,
+		MatchStatus:     MatchStatusMatched,
+		Currency:        po.Currency,
+		ExchangeRate:    rate,
+		BillDate:        RateDate(billDate),
+		Notes:           notes,
+		CreatedBy:       createdBy,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	bill.SetPaymentTerms(terms)
+	return bill
+}
+
+// SetPaymentTerms dates the bill's due and discount dates from its terms
+func (b *SupplierBill) SetPaymentTerms(terms PaymentTerms) {
+	b.PaymentTerms = terms
+	b.DueDate = terms.DueDate(b.BillDate)
+	b.DiscountDate = terms.DiscountDate(b.BillDate)
+}
+
+// Unbilled is how many received units of the line are not on a bill yet
+func (l *PurchaseOrderLine) Unbilled() int {
+	if n := l.ReceivedQuantity - l.BilledQuantity; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// AddLine bills quantity units of a purchase order line at unitCost and matches them
+// against the units received and the agreed cost. The price is a variance when it is
+// more than tolerancePercent of the agreed cost away from it.
+func (b *SupplierBill) AddLine(poLine *PurchaseOrderLine, quantity int, unitCost, agreedUnitCost, tolerancePercent Money) {
+	line := SupplierBillLine{
+		ID:                       uuid.New().String(),
+		BillID:                   b.ID,
+		PurchaseOrderLineID:      poLine.ID,
+		ProductID:                poLine.ProductID,
+		Quantity:                 quantity,
+		UnitCost:                 unitCost,
+		TotalCost:                CurrencyOf(b.Currency).Round(unitCost.Mul(quantity)),
+		OrderedQuantity:          poLine.Quantity,
+		ReceivedQuantity:         poLine.ReceivedQuantity,
+		PreviouslyBilledQuantity: poLine.BilledQuantity,
+		OrderedUnitCost:          poLine.UnitCost,
+		AgreedUnitCost:           agreedUnitCost,
+		ProductName:              poLine.ProductName,
+		SKU:                      poLine.SKU,
+	}
+	if over := line.PreviouslyBilledQuantity + quantity - line.ReceivedQuantity; over > 0 {
+		line.QuantityVariance = over
+	}
+	difference := unitCost - agreedUnitCost
+	if tolerance := agreedUnitCost.Percent(tolerancePercent); difference > tolerance || -difference > tolerance {
+		line.PriceVariance = difference
+	}
+	line.CalculateVariances()
+
+	b.Lines = append(b.Lines, line)
+	b.CalculateTotals()
+}
+
+// CalculateVariances lists what the line does not match
+func (l *SupplierBillLine) CalculateVariances() {
+	l.Variances = nil
+	if l.QuantityVariance != 0 {
+		l.Variances = append(l.Variances, VarianceQuantity)
+	}
+	if l.PriceVariance != 0 {
+		l.Variances = append(l.Variances, VariancePrice)
+	}
+}
+
+// CalculateTotals sums the lines and tax into the bill totals and works out whether the
+// bill matches
+func (b *SupplierBill) CalculateTotals() {
+	b.SubtotalAmount = 0
+	b.MatchStatus = MatchStatusMatched
+	for _, line := range b.Lines {
+		b.SubtotalAmount += line.TotalCost
+		if len(line.Variances) > 0 {
+			b.MatchStatus = MatchStatusVariance
+		}
+	}
+	b.TotalAmount = b.SubtotalAmount + b.TaxAmount
+	b.BaseTotalAmount = BaseCurrency.Round(b.ExchangeRate.ToBase(b.TotalAmount))
+	b.CalculateBalance()
+}
+
+// CalculateBalance works out what is left to pay. Nothing is due on drafts or void
+// bills.
+func (b *SupplierBill) CalculateBalance() {
+	b.BalanceDue = 0
+	if b.Status != SupplierBillStatusDraft && b.Status != SupplierBillStatusVoid {
+		b.BalanceDue = b.TotalAmount - b.AmountPaid - b.DiscountTaken
+	}
+}
+
+// Settle moves an approved bill to approved, partially_paid or paid according to what
+// has been paid against it. Other bills keep their status.
+func (b *SupplierBill) Settle() {
+	b.CalculateBalance()
+	switch b.Status {
+	case SupplierBillStatusApproved, SupplierBillStatusPartiallyPaid, SupplierBillStatusPaid:
+	default:
+		return
+	}
+	switch {
+	case b.BalanceDue <= 0:
+		b.Status = SupplierBillStatusPaid
+	case b.AmountPaid > 0:
+		b.Status = SupplierBillStatusPartiallyPaid
+	default:
+		b.Status = SupplierBillStatusApproved
+	}
+}
+
+// EarlyPaymentDiscount is the discount the terms allow for paying the bill in full on
+// a day, or zero once the discount date has passed or a discount was already taken
+func (b *SupplierBill) EarlyPaymentDiscount(on time.Time) Money {
+	if b.DiscountDate == nil || RateDate(on).After(*b.DiscountDate) || b.DiscountTaken != 0 {
+		return 0
+	}
+	return CurrencyOf(b.Currency).Round(b.TotalAmount.Percent(b.PaymentTerms.DiscountPercent))
+}
+
+// PaymentDiscount is the discount a payment of amount on a day settles along with it.
+// The discount only applies to a payment that clears the bill, so it is whatever of the
+// balance the payment leaves, up to the early payment discount.
+func (b *SupplierBill) PaymentDiscount(amount Money, on time.Time) Money {
+	available := b.EarlyPaymentDiscount(on)
+	left := b.BalanceDue - amount
+	if available == 0 || left <= 0 || left > available {
+		return 0
+	}
+	return left
+}
+
+// Overdue reports whether an unpaid bill was due before on
+func (b *SupplierBill) Overdue(on time.Time) bool {
+	return b.BalanceDue > 0 && b.DueDate.Before(RateDate(on))
+}
+
+// Voidable reports whether the bill can still be voided: drafts, and approved bills
+// nothing has been paid against yet
+func (b *SupplierBill) Voidable() bool {
+	switch b.Status {
+	case SupplierBillStatusDraft:
+		return true
+	case SupplierBillStatusApproved:
+		return b.AmountPaid == 0 && b.DiscountTaken == 0
+	}
+	return false
+}
+
+// NewSupplierPayment starts a payment against a bill. Paying it fills in the supplier,
+// currency and any discount taken from the bill.
+func NewSupplierPayment(billID, method, reference string, amount Money, paymentDate time.Time, createdBy string) *SupplierPayment {
+	return &SupplierPayment{
+		ID:          uuid.New().String(),
+		BillID:      billID,
+		Method:      method,
+		Reference:   reference,
+		Amount:      amount,
+		PaymentDate: paymentDate,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now(),
+	}
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePaymentTerms(t *testing.T) {
+	tests := []struct {
+		in   string
+		want PaymentTerms
+		text string
+	}{
+		{"net 30", PaymentTerms{NetDays: 30}, "net 30"},
+		{"N45", PaymentTerms{NetDays: 45}, "net 45"},
+		{"2/10 net 30", PaymentTerms{NetDays: 30, DiscountPercent: MustParseMoney("2"), DiscountDays: 10}, "2/10 net 30"},
+		{"1.5%/15, net 45", PaymentTerms{NetDays: 45, DiscountPercent: MustParseMoney("1.5"), DiscountDays: 15}, "1.5/15 net 45"},
+		{"Due on receipt", PaymentTerms{}, "due on receipt"},
+	}
+	for _, tt := range tests {
+		got, err := ParsePaymentTerms(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParsePaymentTerms(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+			continue
+		}
+		if got.String() != tt.text {
+			t.Errorf("%q reads back as %q, want %q", tt.in, got.String(), tt.text)
+		}
+	}
+
+	for _, in := range []string{"", "30 days", "net", "2/40 net 30", "100/10 net 30", "net 400"} {
+		if _, err := ParsePaymentTerms(in); !errors.Is(err, ErrInvalidPaymentTerms) {
+			t.Errorf("ParsePaymentTerms(%q): err = %v", in, err)
+		}
+	}
+}
+
+func TestSupplierBillMatching(t *testing.T) {
+	po := &PurchaseOrder{ID: "po", SupplierID: "s", Currency: BaseCurrency.Code}
+	terms := PaymentTerms{NetDays: 30, DiscountPercent: MustParseMoney("2"), DiscountDays: 10}
+	billDate := time.Date(2026, time.March, 1, 15, 0, 0, 0, time.UTC)
+	bill := NewSupplierBill(po, "B-1", terms, billDate, RateOne, "", "")
+	if !bill.DueDate.Equal(time.Date(2026, time.March, 31, 0, 0, 0, 0, time.UTC)) ||
+		bill.DiscountDate == nil || !bill.DiscountDate.Equal(time.Date(2026, time.March, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("due %v, discount date %v", bill.DueDate, bill.DiscountDate)
+	}
+
+	// 10 received, 4 billed already; 6 more at 1.04 against 1.00 agreed is within 5%
+	line := &PurchaseOrderLine{ID: "l", Quantity: 12, ReceivedQuantity: 10, BilledQuantity: 4, UnitCost: MustParseMoney("1")}
+	bill.AddLine(line, 6, MustParseMoney("1.04"), MustParseMoney("1"), MustParseMoney("5"))
+	if bill.MatchStatus != MatchStatusMatched || len(bill.Lines[0].Variances) != 0 {
+		t.Errorf("within tolerance: %+v", bill.Lines[0])
+	}
+
+	// One more unit than received, well under the agreed cost
+	bill.AddLine(line, 7, MustParseMoney("0.9"), MustParseMoney("1"), MustParseMoney("5"))
+	got := bill.Lines[1]
+	if bill.MatchStatus != MatchStatusVariance || got.QuantityVariance != 1 || got.PriceVariance != MustParseMoney("-0.1") || len(got.Variances) != 2 {
+		t.Errorf("variances: %+v", got)
+	}
+	if bill.TotalAmount != MustParseMoney("12.54") || bill.BalanceDue != 0 {
+		t.Errorf("draft totals: total %s, balance %s", bill.TotalAmount, bill.BalanceDue)
+	}
+}
+
+func TestSupplierBillPaymentDiscount(t *testing.T) {
+	discountDate := time.Date(2026, time.March, 11, 0, 0, 0, 0, time.UTC)
+	bill := &SupplierBill{
+		Status:       SupplierBillStatusApproved,
+		Currency:     BaseCurrency.Code,
+		PaymentTerms: PaymentTerms{NetDays: 30, DiscountPercent: MustParseMoney("2"), DiscountDays: 10},
+		DiscountDate: &discountDate,
+		TotalAmount:  MustParseMoney("100"),
+		AmountPaid:   MustParseMoney("30"),
+	}
+	bill.CalculateBalance()
+	within := discountDate.Add(20 * time.Hour)
+
+	tests := []struct {
+		name   string
+		amount string
+		on     time.Time
+		want   string
+	}{
+		{"clears the bill less the discount", "68", within, "2"},
+		{"pays in full anyway", "70", within, "0"},
+		{"leaves more than the discount", "60", within, "0"},
+		{"after the discount date", "68", discountDate.AddDate(0, 0, 1), "0"},
+	}
+	for _, tt := range tests {
+		if got := bill.PaymentDiscount(MustParseMoney(tt.amount), tt.on); got != MustParseMoney(tt.want) {
+			t.Errorf("%s: discount %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	bill.AmountPaid += MustParseMoney("68")
+	bill.DiscountTaken = MustParseMoney("2")
+	bill.Settle()
+	if bill.Status != SupplierBillStatusPaid || bill.BalanceDue != 0 || bill.EarlyPaymentDiscount(within) != 0 {
+		t.Errorf("settled bill: %+v", bill)
+	}
+}
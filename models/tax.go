@@ -0,0 +1,213 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Tax categories. Products may use other categories too, as long as the jurisdictions
+// they are sold into have rates for them.
+const (
+	TaxCategoryStandard = "standard"
+	TaxCategoryReduced  = "reduced"
+	TaxCategoryZero     = "zero"
+	TaxCategoryExempt   = "exempt" // Never taxed, whatever the jurisdiction
+)
+
+// TaxJurisdiction is where a sale is taxed: a country (ISO 3166-1 alpha-2) and
+// optionally a region within it, such as a state or province
+type TaxJurisdiction struct {
+	Country string `json:"country"`
+	Region  string `json:"region,omitempty"`
+}
+
+// TaxRate is the percentage charged on one tax category in a jurisdiction between two
+// days. A rate for a whole country (no region) applies to every region in it that has
+// no rate of its own; a region's rate is the full rate charged there, not a surcharge.
+type TaxRate struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Country     string     `json:"country"`
+	Region      string     `json:"region"`
+	TaxCategory string     `json:"tax_category"`
+	Rate        Money      `json:"rate"` // Percent, so 8.875 means 8.875%
+	ValidFrom   time.Time  `json:"valid_from"`
+	ValidTo     *time.Time `json:"valid_to,omitempty"` // Last day it applies, open ended when nil
+	Active      bool       `json:"active"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// TaxSummaryLine totals the tax charged on one category at one rate in a jurisdiction,
+// in one currency and in the base currency at each order's rate
+type TaxSummaryLine struct {
+	Country           string `json:"country"`
+	Region            string `json:"region"`
+	TaxCategory       string `json:"tax_category"`
+	TaxRate           Money  `json:"tax_rate"`
+	Exempt            bool   `json:"exempt"` // Sales to exempt customers
+	Currency          string `json:"currency"`
+	Lines             int    `json:"lines"`
+	TaxableAmount     Money  `json:"taxable_amount"`
+	TaxAmount         Money  `json:"tax_amount"`
+	BaseTaxableAmount Money  `json:"base_taxable_amount"`
+	BaseTaxAmount     Money  `json:"base_tax_amount"`
+}
+
+func NewTaxRate(name, country, region, category string, rate Money, validFrom time.Time, validTo *time.Time, createdBy string) *TaxRate {
+	taxRate := &TaxRate{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Country:     NormalizeCountry(country),
+		Region:      NormalizeRegion(region),
+		TaxCategory: NormalizeTaxCategory(category),
+		Rate:        rate,
+		ValidFrom:   RateDate(validFrom),
+		Active:      true,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	taxRate.SetValidTo(validTo)
+	return taxRate
+}
+
+// SetValidTo sets the last day the rate applies, or makes it open ended when nil
+func (r *TaxRate) SetValidTo(validTo *time.Time) {
+	r.ValidTo = nil
+	if validTo != nil {
+		day := RateDate(*validTo)
+		r.ValidTo = &day
+	}
+}
+
+// ValidOn reports whether the rate is switched on and t falls on one of its days
+func (r *TaxRate) ValidOn(t time.Time) bool {
+	day := RateDate(t)
+	if !r.Active || day.Before(r.ValidFrom) {
+		return false
+	}
+	return r.ValidTo == nil || !day.After(*r.ValidTo)
+}
+
+// NormalizeCountry upper-cases a country code: "us " and "US" are one country
+func NormalizeCountry(country string) string {
+	return strings.ToUpper(strings.TrimSpace(country))
+}
+
+// NormalizeRegion upper-cases a region code, e.g. "ca" becomes "CA"
+func NormalizeRegion(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
+
+// NormalizeTaxCategory lower-cases a tax category and defaults it to standard
+func NormalizeTaxCategory(category string) string {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" {
+		return TaxCategoryStandard
+	}
+	return category
+}
+
+// ResolveTaxRate picks the rate for a tax category in a jurisdiction on a day: the
+// region's own rate if it has one, otherwise the country's, and of those the one that
+// took effect most recently. It returns nil when nothing applies, and always for the
+// exempt category.
+func ResolveTaxRate(rates []TaxRate, jurisdiction TaxJurisdiction, category string, on time.Time) *TaxRate {
+	category = NormalizeTaxCategory(category)
+	if category == TaxCategoryExempt {
+		return nil
+	}
+	var candidates []*TaxRate
+	for i := range rates {
+		rate := &rates[i]
+		if rate.Country != jurisdiction.Country || rate.TaxCategory != category || !rate.ValidOn(on) {
+			continue
+		}
+		if rate.Region != "" && rate.Region != jurisdiction.Region {
+			continue
+		}
+		candidates = append(candidates, rate)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if (candidates[i].Region != "") != (candidates[j].Region != "") {
+			return candidates[i].Region != ""
+		}
+		return candidates[i].ValidFrom.After(candidates[j].ValidFrom)
+	})
+	return candidates[0]
+}
+
+// Jurisdiction is where sales to the customer are taxed, from its address. It is
+// empty when the customer has no country on file.
+func (c *Customer) Jurisdiction() TaxJurisdiction {
+	return TaxJurisdiction{Country: NormalizeCountry(c.Country), Region: NormalizeRegion(c.Region)}
+}
+
+// TaxExemptOn reports whether the customer's exemption covers a sale on t. An
+// exemption certificate stops covering sales the day after it expires.
+func (c *Customer) TaxExemptOn(t time.Time) bool {
+	if !c.TaxExempt {
+		return false
+	}
+	return c.TaxExemptionExpiresAt == nil || !RateDate(t).After(RateDate(*c.TaxExemptionExpiresAt))
+}
+
+// ApplyTax taxes the line at a rate, or leaves it untaxed when rate is nil. With
+// pricesIncludeTax the line's prices already contain the tax and it is worked out of
+// them; otherwise it is added on top. Apply it after discounts, which come off the
+// price before tax is reckoned.
+func (i *OrderItem) ApplyTax(category string, rate *TaxRate, pricesIncludeTax bool, currency Currency) {
+	i.TaxCategory = NormalizeTaxCategory(category)
+	i.TaxRateID = nil
+	i.TaxRate = 0
+	if rate != nil {
+		rateID := rate.ID
+		i.TaxRateID = &rateID
+		i.TaxRate = rate.Rate
+	}
+	i.PricesIncludeTax = pricesIncludeTax
+	i.CalculateTotal(currency)
+}
+
+// TaxableAmount is the part of the line total that tax was charged on
+func (i *OrderItem) TaxableAmount() Money {
+	return i.TotalPrice - i.TaxAmount
+}
+
+// OrderItemsTax is the tax on a set of lines: the exact sum of the lines' rounded tax
+func OrderItemsTax(items []*OrderItem) Money {
+	var total Money
+	for _, item := range items {
+		total += item.TaxAmount
+	}
+	return total
+}
+
+// SortTaxSummary orders summary rows by jurisdiction, then category, rate and currency
+func SortTaxSummary(rows []TaxSummaryLine) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch {
+		case a.Country != b.Country:
+			return a.Country < b.Country
+		case a.Region != b.Region:
+			return a.Region < b.Region
+		case a.TaxCategory != b.TaxCategory:
+			return a.TaxCategory < b.TaxCategory
+		case a.TaxRate != b.TaxRate:
+			return a.TaxRate > b.TaxRate
+		case a.Exempt != b.Exempt:
+			return !a.Exempt
+		default:
+			return a.Currency < b.Currency
+		}
+	})
+}
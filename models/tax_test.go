@@ -0,0 +1,97 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveTaxRate(t *testing.T) {
+	day := func(m time.Month, d int) time.Time { return time.Date(2026, m, d, 0, 0, 0, 0, time.UTC) }
+	oct := day(time.October, 1)
+
+	usOld := NewTaxRate("US standard", "us", "", "", MustParseMoney("5"), day(time.January, 1), nil, "")
+	usNew := NewTaxRate("US standard 2026H2", "US", "", "standard", MustParseMoney("6"), day(time.July, 1), nil, "")
+	california := NewTaxRate("California", "US", "ca", "standard", MustParseMoney("7.25"), day(time.January, 1), nil, "")
+	reduced := NewTaxRate("US reduced", "US", "", "Reduced", MustParseMoney("2"), day(time.January, 1), nil, "")
+	ended := day(time.March, 31)
+	expired := NewTaxRate("Texas", "US", "TX", "standard", MustParseMoney("6.25"), day(time.January, 1), &ended, "")
+	rates := []TaxRate{*usOld, *usNew, *california, *reduced, *expired}
+
+	tests := []struct {
+		name         string
+		jurisdiction TaxJurisdiction
+		category     string
+		on           time.Time
+		want         *TaxRate
+	}{
+		{"region rate beats the country's", TaxJurisdiction{"US", "CA"}, "", oct, california},
+		{"latest country rate", TaxJurisdiction{"US", "NY"}, "standard", oct, usNew},
+		{"country rate before the change", TaxJurisdiction{"US", "NY"}, "standard", day(time.June, 30), usOld},
+		{"expired region rate falls back", TaxJurisdiction{"US", "TX"}, "standard", oct, usNew},
+		{"region rate while it applies", TaxJurisdiction{"US", "TX"}, "standard", day(time.March, 31), expired},
+		{"category", TaxJurisdiction{"US", "CA"}, "reduced", oct, reduced},
+		{"exempt category", TaxJurisdiction{"US", ""}, TaxCategoryExempt, oct, nil},
+		{"no rate for the country", TaxJurisdiction{"DE", ""}, "standard", oct, nil},
+	}
+	for _, tt := range tests {
+		got := ResolveTaxRate(rates, tt.jurisdiction, tt.category, tt.on)
+		if (got == nil) != (tt.want == nil) || (got != nil && got.ID != tt.want.ID) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyTax(t *testing.T) {
+	usd := BaseCurrency
+	rate := NewTaxRate("VAT", "GB", "", "", MustParseMoney("20"), time.Now(), nil, "")
+
+	// Tax added on top: 3 x 9.99 = 29.97, less 2.00 off = 27.97, 20% = 5.594 -> 5.59
+	line := NewOrderItem("", "p", 3, MustParseMoney("9.99"), usd)
+	line.DiscountAmount = MustParseMoney("2")
+	line.ApplyTax("", rate, false, usd)
+	if line.TaxAmount != MustParseMoney("5.59") || line.TotalPrice != MustParseMoney("33.56") {
+		t.Errorf("exclusive: tax %s, total %s", line.TaxAmount, line.TotalPrice)
+	}
+	if line.TaxableAmount() != MustParseMoney("27.97") || line.TaxRateID == nil || *line.TaxRateID != rate.ID || line.TaxRate != rate.Rate {
+		t.Errorf("exclusive: taxable %s, rate %v at %s", line.TaxableAmount(), line.TaxRateID, line.TaxRate)
+	}
+
+	// Tax worked out of the price: 27.97 / 1.2 = 23.308.. -> 23.31 net, 4.66 tax
+	line.ApplyTax("", rate, true, usd)
+	if line.TaxAmount != MustParseMoney("4.66") || line.TotalPrice != MustParseMoney("27.97") {
+		t.Errorf("inclusive: tax %s, total %s", line.TaxAmount, line.TotalPrice)
+	}
+
+	// Cancelling a unit retaxes what is left: 19.98 - 1.33 = 18.65, 20% = 3.73
+	line.CancelledQuantity = 1
+	line.ApplyTax("", rate, false, usd)
+	if line.TaxAmount != MustParseMoney("3.73") || line.TotalPrice != MustParseMoney("22.38") {
+		t.Errorf("after cancel: tax %s, total %s", line.TaxAmount, line.TotalPrice)
+	}
+
+	// No rate, no tax
+	line.ApplyTax(TaxCategoryExempt, nil, false, usd)
+	if line.TaxAmount != 0 || line.TaxRateID != nil || line.TaxCategory != TaxCategoryExempt {
+		t.Errorf("untaxed: %+v", line)
+	}
+
+	items := []*OrderItem{line, NewOrderItem("", "q", 1, MustParseMoney("10"), usd)}
+	items[1].ApplyTax("", rate, false, usd)
+	if OrderItemsTax(items) != MustParseMoney("2") {
+		t.Errorf("order tax = %s", OrderItemsTax(items))
+	}
+}
+
+func TestCustomerTaxExemptOn(t *testing.T) {
+	customer := NewCustomer("Acme", "acme@example.com", "", "")
+	expires := time.Date(2026, time.June, 30, 0, 0, 0, 0, time.UTC)
+	customer.TaxExempt = true
+	customer.TaxExemptionExpiresAt = &expires
+
+	if !customer.TaxExemptOn(time.Date(2026, time.June, 30, 18, 0, 0, 0, time.UTC)) {
+		t.Error("exemption not honoured on its last day")
+	}
+	if customer.TaxExemptOn(time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("exemption honoured after it expired")
+	}
+}
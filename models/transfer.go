@@ -0,0 +1,113 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Transfer order statuses
+const (
+	TransferStatusDraft     = "draft"
+	TransferStatusInTransit = "in_transit"
+	TransferStatusReceived  = "received"
+	TransferStatusCancelled = "cancelled"
+)
+
+// TransferOrder moves stock from one warehouse to another. Stock leaves the source
+// when the transfer is dispatched and is in transit until it is received.
+type TransferOrder struct {
+	ID                     string     `json:"id"`
+	SourceWarehouseID      string     `json:"source_warehouse_id"`
+	DestinationWarehouseID string     `json:"destination_warehouse_id"`
+	Status                 string     `json:"status"` // draft, in_transit, received, cancelled
+	Notes                  string     `json:"notes"`
+	CreatedBy              string     `json:"created_by"`
+	DispatchedAt           *time.Time `json:"dispatched_at,omitempty"`
+	ReceivedAt             *time.Time `json:"received_at,omitempty"`
+	CreatedAt              time.Time  `json:"created_at"`
+	UpdatedAt              time.Time  `json:"updated_at"`
+
+	Lines []TransferOrderLine `json:"lines,omitempty"`
+
+	// For joins
+	SourceWarehouseName      string `json:"source_warehouse_name,omitempty"`
+	DestinationWarehouseName string `json:"destination_warehouse_name,omitempty"`
+}
+
+type TransferOrderLine struct {
+	ID                    string  `json:"id"`
+	TransferOrderID       string  `json:"transfer_order_id"`
+	ProductID             string  `json:"product_id"`
+	SourceLocationID      *string `json:"source_location_id,omitempty"`
+	DestinationLocationID *string `json:"destination_location_id,omitempty"`
+	SourceInventoryID     *string `json:"source_inventory_id,omitempty"` // Set on dispatch, to the fullest row drawn on
+	Quantity              int     `json:"quantity"`
+	ReceivedQuantity      int     `json:"received_quantity"`
+	DiscrepancyQuantity   int     `json:"discrepancy_quantity"` // Dispatched but reported missing or damaged
+	InTransitQuantity     int     `json:"in_transit_quantity"`  // Calculated: quantity - received - discrepancy once dispatched
+
+	// For joins
+	ProductName string `json:"product_name,omitempty"`
+	SKU         string `json:"sku,omitempty"`
+}
+
+// TransferReceipt records one delivery against a transfer order line
+type TransferReceipt struct {
+	ID                     string    `json:"id"`
+	TransferOrderID        string    `json:"transfer_order_id"`
+	TransferOrderLineID    string    `json:"transfer_order_line_id"`
+	ProductID              string    `json:"product_id"`
+	LocationID             *string   `json:"location_id,omitempty"`
+	DestinationInventoryID *string   `json:"destination_inventory_id,omitempty"`
+	ReceivedQuantity       int       `json:"received_quantity"`
+	DiscrepancyQuantity    int       `json:"discrepancy_quantity"`
+	DiscrepancyReason      string    `json:"discrepancy_reason,omitempty"`
+	ReceivedBy             string    `json:"received_by,omitempty"`
+	ReceivedAt             time.Time `json:"received_at"`
+}
+
+func NewTransferOrder(sourceWarehouseID, destinationWarehouseID, notes, createdBy string) *TransferOrder {
+	now := time.Now()
+	return &TransferOrder{
+		ID:                     uuid.New().String(),
+		SourceWarehouseID:      sourceWarehouseID,
+		DestinationWarehouseID: destinationWarehouseID,
+		Status:                 TransferStatusDraft,
+		Notes:                  notes,
+		CreatedBy:              createdBy,
+		CreatedAt:              now,
+		UpdatedAt:              now,
+	}
+}
+
+func NewTransferOrderLine(transferOrderID, productID string, sourceLocationID, destinationLocationID *string, quantity int) *TransferOrderLine {
+	return &TransferOrderLine{
+		ID:                    uuid.New().String(),
+		TransferOrderID:       transferOrderID,
+		ProductID:             productID,
+		SourceLocationID:      sourceLocationID,
+		DestinationLocationID: destinationLocationID,
+		Quantity:              quantity,
+	}
+}
+
+func NewTransferReceipt(line *TransferOrderLine, locationID *string, receivedQuantity, discrepancyQuantity int, discrepancyReason, receivedBy string) *TransferReceipt {
+	return &TransferReceipt{
+		ID:                  uuid.New().String(),
+		TransferOrderID:     line.TransferOrderID,
+		TransferOrderLineID: line.ID,
+		ProductID:           line.ProductID,
+		LocationID:          locationID,
+		ReceivedQuantity:    receivedQuantity,
+		DiscrepancyQuantity: discrepancyQuantity,
+		DiscrepancyReason:   discrepancyReason,
+		ReceivedBy:          receivedBy,
+		ReceivedAt:          time.Now(),
+	}
+}
+
+// Outstanding returns how much of a dispatched line is still in transit
+func (l *TransferOrderLine) Outstanding() int {
+	return l.Quantity - l.ReceivedQuantity - l.DiscrepancyQuantity
+}
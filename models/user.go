@@ -0,0 +1,126 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User statuses
+const (
+	UserStatusActive   = "active"
+	UserStatusInactive = "inactive"
+)
+
+type User struct {
+	ID           string     `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"-"`
+	FullName     string     `json:"full_name"`
+	Status       string     `json:"status"`
+	Roles        []string   `json:"roles"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// APIKey is a long-lived credential for an integration. Only a hash of the key is
+// stored; the key itself is shown once when it is created.
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"` // First characters of the key, to tell keys apart
+	KeyHash    string     `json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// For joins
+	Username string `json:"username,omitempty"`
+}
+
+// RefreshToken records an issued refresh token so it can be rotated and revoked
+type RefreshToken struct {
+	ID        string     `json:"id"` // The token's jti claim
+	UserID    string     `json:"user_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func NewUser(username, email, fullName, password string, roles []string) (*User, error) {
+	now := time.Now()
+
+	user := &User{
+		ID:        uuid.New().String(),
+		Username:  username,
+		Email:     email,
+		FullName:  fullName,
+		Status:    UserStatusActive,
+		Roles:     roles,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func NewAPIKey(userID, name, keyPrefix, keyHash string, expiresAt *time.Time) *APIKey {
+	return &APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		KeyPrefix: keyPrefix,
+		KeyHash:   keyHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+}
+
+// SetPassword stores a bcrypt hash of password
+func (u *User) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// CheckPassword reports whether password matches the stored hash
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// missingUserHash is a hash at the same cost as real ones, made the first time a login
+// names an unknown user
+var missingUserHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("no such user"), bcrypt.DefaultCost)
+	return hash
+})
+
+// CheckMissingUserPassword does the work of a password check for a username that does
+// not exist and always fails, so response times do not tell which usernames exist
+func CheckMissingUserPassword(password string) bool {
+	bcrypt.CompareHashAndPassword(missingUserHash(), []byte(password))
+	return false
+}
+
+func (u *User) IsActive() bool {
+	return u.Status == UserStatusActive
+}
+
+// IsUsable reports whether the key may still authenticate requests
+func (k *APIKey) IsUsable(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
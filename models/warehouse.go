@@ -1,6 +1,7 @@
 package models
 
 import (
+	"math"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,6 +16,8 @@ type Warehouse struct {
 	Phone       string    `json:"phone"`
 	Email       string    `json:"email"`
 	Capacity    int       `json:"capacity"`
+	Latitude    *float64  `json:"latitude,omitempty"`
+	Longitude   *float64  `json:"longitude,omitempty"`
 	Status      string    `json:"status"` // active, inactive
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -37,6 +40,23 @@ type WarehouseLocation struct {
 	WarehouseName string `json:"warehouse_name,omitempty"`
 }
 
+// Inventory adjustment modes
+const (
+	AdjustmentModeSet   = "set"   // quantity is the new on-hand quantity
+	AdjustmentModeDelta = "delta" // quantity is added to the on-hand quantity
+)
+
+// Inventory adjustment reason codes
+const (
+	AdjustmentReasonCountCorrection = "count_correction"
+	AdjustmentReasonDamaged         = "damaged"
+	AdjustmentReasonLost            = "lost"
+	AdjustmentReasonFound           = "found"
+	AdjustmentReasonExpired         = "expired"
+	AdjustmentReasonReturned        = "returned"
+	AdjustmentReasonOther           = "other"
+)
+
 type Inventory struct {
 	ID                string     `json:"id"`
 	ProductID         string     `json:"product_id"`
@@ -59,6 +79,16 @@ type Inventory struct {
 	SKU           string `json:"sku,omitempty"`
 }
 
+// InventoryAdjustment is a manual correction of the quantity on an inventory row
+type InventoryAdjustment struct {
+	InventoryID string
+	Mode        string
+	Quantity    int
+	ReasonCode  string
+	Note        string
+	AdjustedBy  string
+}
+
 func NewWarehouse(code, name, location, managerName, phone, email string, capacity int) *Warehouse {
 	now := time.Now()
 	return &Warehouse{
@@ -76,6 +106,24 @@ func NewWarehouse(code, name, location, managerName, phone, email string, capaci
 	}
 }
 
+// DistanceTo returns the great-circle distance in kilometres from the warehouse to a point.
+// ok is false when the warehouse has no coordinates.
+func (w *Warehouse) DistanceTo(latitude, longitude float64) (distance float64, ok bool) {
+	if w.Latitude == nil || w.Longitude == nil {
+		return 0, false
+	}
+
+	const earthRadiusKm = 6371.0
+	lat1 := *w.Latitude * math.Pi / 180
+	lat2 := latitude * math.Pi / 180
+	dLat := (latitude - *w.Latitude) * math.Pi / 180
+	dLng := (longitude - *w.Longitude) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a)), true
+}
+
 func NewWarehouseLocation(warehouseID, locationCode, locationName, zone string, rowNumber, shelfNumber, maxCapacity int) *WarehouseLocation {
 	now := time.Now()
 	return &WarehouseLocation{
@@ -0,0 +1,203 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"erp-project/database"
+	"erp-project/models"
+	"erp-project/utils"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// AuditLogFilter narrows down the audit trail. Empty fields are ignored.
+type AuditLogFilter struct {
+	EntityType string
+	EntityID   string
+	Action     string
+	ActorID    string
+	From       *time.Time
+	To         *time.Time
+}
+
+type AuditRepository struct {
+	DB *database.Conn
+}
+
+func NewAuditRepository(db *database.Conn) *AuditRepository {
+	return &AuditRepository{DB: db}
+}
+
+// auditContextKey carries a pendingAudit in a context
+type auditContextKey struct{}
+
+// pendingAudit is a change to record in the audit log once it is written
+type pendingAudit struct {
+	entityType string
+	entityID   string
+	action     string
+	actor      *models.User
+	before     interface{}
+	after      interface{}
+}
+
+// WithAudit returns a copy of ctx asking the repository method that saves a change to
+// record it in the audit log as well. The entry is written in the same transaction as
+// the change, so one is never committed without the other. before and after are the
+// entity as it was and as it is being saved, nil for the side that does not exist.
+// They are compared when the entry is written, so after includes whatever the save
+// fills in. The actor may be nil.
+func WithAudit(ctx context.Context, actor *models.User, entityType, entityID, action string, before, after interface{}) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, &pendingAudit{
+		entityType: entityType,
+		entityID:   entityID,
+		action:     action,
+		actor:      actor,
+		before:     before,
+		after:      after,
+	})
+}
+
+// recordAudit writes the audit entry ctx asks for, if any, through tx. A non-nil after
+// stands in for the one in ctx, for changes whose result is only known once they are
+// applied. Updates that change no audited field are not recorded.
+func recordAudit(ctx context.Context, tx *database.Tx, after interface{}) error {
+	pending, ok := ctx.Value(auditContextKey{}).(*pendingAudit)
+	if !ok {
+		return nil
+	}
+	if after == nil {
+		after = pending.after
+	}
+
+	entry, err := models.NewAuditLog(pending.entityType, pending.entityID, pending.action, pending.actor, pending.before, after)
+	if err != nil {
+		return fmt.Errorf("failed to build audit entry for %s %s: %w", pending.entityType, pending.entityID, err)
+	}
+	if pending.action == models.AuditActionUpdate && len(entry.Changes) == 0 {
+		return nil
+	}
+	return insertAuditLog(ctx, tx, entry)
+}
+
+func (r *AuditRepository) CreateAuditLog(ctx context.Context, entry *models.AuditLog) error {
+	return insertAuditLog(ctx, r.DB, entry)
+}
+
+func insertAuditLog(ctx context.Context, exec stockExecutor, entry *models.AuditLog) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO audit_log (id, entity_type, entity_id, action, actor_id, actor_name, changes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err = exec.ExecContext(ctx,
+		query,
+		entry.ID,
+		entry.EntityType,
+		entry.EntityID,
+		entry.Action,
+		entry.ActorID,
+		entry.ActorName,
+		string(changes),
+		entry.CreatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating audit log entry: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *AuditRepository) GetAuditLogs(ctx context.Context, filter AuditLogFilter, page, pageSize int) ([]models.AuditLog, int, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.EntityType != "" {
+		addClause("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		addClause("entity_id = $%d", filter.EntityID)
+	}
+	if filter.Action != "" {
+		addClause("action = $%d", filter.Action)
+	}
+	if filter.ActorID != "" {
+		addClause("actor_id = $%d", filter.ActorID)
+	}
+	if filter.From != nil {
+		addClause("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addClause("created_at <= $%d", *filter.To)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	var total int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log "+whereClause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, entity_type, entity_id, action, actor_id, actor_name, changes, created_at
+		FROM audit_log
+		%s
+		ORDER BY created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, whereClause, len(args)+1, len(args)+2)
+	args = append(args, pageSize, utils.CalculateOffset(page, pageSize))
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditLog{}
+	for rows.Next() {
+		var entry models.AuditLog
+		var actorID, actorName sql.NullString
+		var changes string
+		err := rows.Scan(
+			&entry.ID,
+			&entry.EntityType,
+			&entry.EntityID,
+			&entry.Action,
+			&actorID,
+			&actorName,
+			&changes,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Error scanning audit log entry: %v", err)
+			return nil, 0, err
+		}
+
+		if actorID.Valid {
+			entry.ActorID = &actorID.String
+		}
+		entry.ActorName = actorName.String
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			log.Printf("Error decoding audit log changes: %v", err)
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
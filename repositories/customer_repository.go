@@ -1,7 +1,9 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"erp-project/database"
 	"erp-project/models"
 	"erp-project/utils"
 	"fmt"
@@ -10,27 +12,47 @@ import (
 	"time"
 )
 
+// customerColumns are the columns scanCustomer reads, in order
+const customerColumns = `id, name, email, phone, address, customer_group, country, region, postal_code, tax_exempt,
+	tax_exemption_number, tax_exemption_expires_at, payment_terms_days, created_at`
+
 type CustomerRepository struct {
-	DB *sql.DB
+	DB *database.Conn
 }
 
-func NewCustomerRepository(db *sql.DB) *CustomerRepository {
+func NewCustomerRepository(db *database.Conn) *CustomerRepository {
 	return &CustomerRepository{DB: db}
 }
 
-func (r *CustomerRepository) CreateCustomer(customer *models.Customer) error {
+func (r *CustomerRepository) CreateCustomer(ctx context.Context, customer *models.Customer) error {
 	// FIXED: Changed ? to $1, $2, etc.
 	query := `
-		INSERT INTO customers (id, name, email, phone, address, created_at) 
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO customers (id, name, email, phone, address, customer_group, country, region, postal_code,
+		                       tax_exempt, tax_exemption_number, tax_exemption_expires_at, payment_terms_days, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`
-	_, err := r.DB.Exec(
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
 		query,
 		customer.ID,
 		customer.Name,
 		customer.Email,
 		customer.Phone,
 		customer.Address,
+		customer.Group,
+		customer.Country,
+		customer.Region,
+		customer.PostalCode,
+		customer.TaxExempt,
+		customer.TaxExemptionNumber,
+		customer.TaxExemptionExpiresAt,
+		customer.PaymentTermsDays,
 		customer.CreatedAt,
 	)
 
@@ -38,21 +60,28 @@ func (r *CustomerRepository) CreateCustomer(customer *models.Customer) error {
 		log.Printf("Error creating customer: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *CustomerRepository) GetCustomerWithPagination(page, pageSize int, search, email string) ([]models.Customer, int, error) {
+func (r *CustomerRepository) GetCustomerWithPagination(ctx context.Context, page, pageSize int, search, email string) ([]models.Customer, int, error) {
 	// Build WHERE clause with PostgreSQL placeholders
 	var whereClauses []string
 	var args []interface{}
 	argCounter := 1
 
 	if search != "" {
-		whereClauses = append(whereClauses, fmt.Sprintf("(name LIKE $%d OR email LIKE $%d OR phone LIKE $%d)",
-			argCounter, argCounter+1, argCounter+2))
-		searchTerm := "%" + search + "%"
-		args = append(args, searchTerm, searchTerm, searchTerm)
-		argCounter += 3
+		// Case-insensitive on both dialects
+		placeholder := fmt.Sprintf("$%d", argCounter)
+		whereClauses = append(whereClauses, fmt.Sprintf("(%s OR %s OR %s)",
+			r.DB.Dialect.Like("name", placeholder),
+			r.DB.Dialect.Like("email", placeholder),
+			r.DB.Dialect.Like("phone", placeholder)))
+		args = append(args, "%"+search+"%")
+		argCounter++
 	}
 
 	if email != "" {
@@ -73,7 +102,7 @@ func (r *CustomerRepository) GetCustomerWithPagination(page, pageSize int, searc
 	}
 
 	var total int
-	err := r.DB.QueryRow(countQuery, args...).Scan(&total)
+	err := r.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -81,7 +110,7 @@ func (r *CustomerRepository) GetCustomerWithPagination(page, pageSize int, searc
 	// Get paginated data
 	offset := utils.CalculateOffset(page, pageSize)
 	query := fmt.Sprintf(`
-		SELECT id, name, email, phone, address, created_at
+		SELECT `+customerColumns+`
 		FROM customers %s
 		ORDER BY created_at DESC
 		LIMIT $%d OFFSET $%d
@@ -90,7 +119,7 @@ func (r *CustomerRepository) GetCustomerWithPagination(page, pageSize int, searc
 	// Add pagination parameters
 	args = append(args, pageSize, offset)
 
-	rows, err := r.DB.Query(query, args...)
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -98,28 +127,20 @@ func (r *CustomerRepository) GetCustomerWithPagination(page, pageSize int, searc
 
 	var customers []models.Customer
 	for rows.Next() {
-		var c models.Customer
-		err := rows.Scan(
-			&c.ID,
-			&c.Name,
-			&c.Email,
-			&c.Phone,
-			&c.Address,
-			&c.CreatedAt,
-		)
+		c, err := scanCustomer(rows)
 		if err != nil {
 			log.Printf("Error scanning customer: %v", err)
 			continue
 		}
-		customers = append(customers, c)
+		customers = append(customers, *c)
 	}
 
 	return customers, total, nil
 }
 
-func (r *CustomerRepository) GetAllCustomers() ([]*models.Customer, error) {
-	query := `SELECT id, name, email, phone, address, created_at FROM customers`
-	rows, err := r.DB.Query(query)
+func (r *CustomerRepository) GetAllCustomers(ctx context.Context) ([]*models.Customer, error) {
+	query := `SELECT ` + customerColumns + ` FROM customers`
+	rows, err := r.DB.QueryContext(ctx, query)
 	if err != nil {
 		log.Printf("Error getting customers: %v", err)
 		return nil, err
@@ -128,15 +149,7 @@ func (r *CustomerRepository) GetAllCustomers() ([]*models.Customer, error) {
 
 	var customers []*models.Customer
 	for rows.Next() {
-		customer := &models.Customer{}
-		err := rows.Scan(
-			&customer.ID,
-			&customer.Name,
-			&customer.Email,
-			&customer.Phone,
-			&customer.Address,
-			&customer.CreatedAt,
-		)
+		customer, err := scanCustomer(rows)
 		if err != nil {
 			log.Printf("Error scanning customer: %v", err)
 			return nil, err
@@ -146,20 +159,13 @@ func (r *CustomerRepository) GetAllCustomers() ([]*models.Customer, error) {
 	return customers, nil
 }
 
-func (r *CustomerRepository) GetCustomerByID(id string) (*models.Customer, error) {
+func (r *CustomerRepository) GetCustomerByID(ctx context.Context, id string) (*models.Customer, error) {
 	// FIXED: Changed ? to $1
-	query := `SELECT id, name, email, phone, address, created_at FROM customers WHERE id = $1`
-	row := r.DB.QueryRow(query, id)
-
-	customer := &models.Customer{}
-	err := row.Scan(
-		&customer.ID,
-		&customer.Name,
-		&customer.Email,
-		&customer.Phone,
-		&customer.Address,
-		&customer.CreatedAt,
-	)
+	query := `SELECT ` + customerColumns + ` FROM customers WHERE id = $1`
+	customer, err := scanCustomer(r.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		log.Printf("Error scanning customer: %v", err)
 		return nil, err
@@ -167,31 +173,94 @@ func (r *CustomerRepository) GetCustomerByID(id string) (*models.Customer, error
 	return customer, nil
 }
 
-func (r *CustomerRepository) UpdateCustomer(customer *models.Customer) error {
+func (r *CustomerRepository) UpdateCustomer(ctx context.Context, customer *models.Customer) error {
 	query := `
 		UPDATE customers 
-		SET name = $1, email = $2, phone = $3, address = $4, updated_at = $5
-		WHERE id = $6`
+		SET name = $1, email = $2, phone = $3, address = $4, customer_group = $5, country = $6, region = $7,
+		    postal_code = $8, tax_exempt = $9, tax_exemption_number = $10, tax_exemption_expires_at = $11,
+		    payment_terms_days = $12, updated_at = $13
+		WHERE id = $14`
 
 	customer.UpdatedAt = time.Now()
-	_, err := r.DB.Exec(
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
 		query,
 		customer.Name,
 		customer.Email,
 		customer.Phone,
 		customer.Address,
+		customer.Group,
+		customer.Country,
+		customer.Region,
+		customer.PostalCode,
+		customer.TaxExempt,
+		customer.TaxExemptionNumber,
+		customer.TaxExemptionExpiresAt,
+		customer.PaymentTermsDays,
 		customer.UpdatedAt,
 		customer.ID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *CustomerRepository) DeleteCustomer(id string) error {
+func (r *CustomerRepository) DeleteCustomer(ctx context.Context, id string) error {
 	query := `DELETE FROM customers WHERE id = $1`
-	_, err := r.DB.Exec(query, id)
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, id)
 	if err != nil {
 		log.Printf("Error deleting customer: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func scanCustomer(row rowScanner) (*models.Customer, error) {
+	customer := &models.Customer{}
+	var expiresAt sql.NullTime
+	err := row.Scan(
+		&customer.ID,
+		&customer.Name,
+		&customer.Email,
+		&customer.Phone,
+		&customer.Address,
+		&customer.Group,
+		&customer.Country,
+		&customer.Region,
+		&customer.PostalCode,
+		&customer.TaxExempt,
+		&customer.TaxExemptionNumber,
+		&expiresAt,
+		&customer.PaymentTermsDays,
+		&customer.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		customer.TaxExemptionExpiresAt = &expiresAt.Time
+	}
+	return customer, nil
 }
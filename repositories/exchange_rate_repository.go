@@ -0,0 +1,158 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ExchangeRateFilter narrows down the rate history. Empty fields are ignored; rates are
+// always those quoted against the current base currency.
+type ExchangeRateFilter struct {
+	Currency string
+	From     *time.Time
+	To       *time.Time
+}
+
+type ExchangeRateRepository struct {
+	DB *database.Conn
+}
+
+func NewExchangeRateRepository(db *database.Conn) *ExchangeRateRepository {
+	return &ExchangeRateRepository{DB: db}
+}
+
+// CreateExchangeRates saves a batch of rates in one transaction. A rate for a currency
+// and day that already has one replaces it.
+func (r *ExchangeRateRepository) CreateExchangeRates(ctx context.Context, rates []*models.ExchangeRate) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO exchange_rates (id, base_currency, currency, rate, effective_date, source, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	` + r.DB.Dialect.Upsert([]string{"base_currency", "currency", "effective_date"},
+		"rate", "source", "created_by", "created_at")
+
+	for _, rate := range rates {
+		_, err := tx.ExecContext(ctx,
+			query,
+			rate.ID,
+			rate.BaseCurrency,
+			rate.Currency,
+			rate.Rate,
+			rate.EffectiveDate,
+			rate.Source,
+			rate.CreatedBy,
+			rate.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Error saving exchange rate: %v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *ExchangeRateRepository) GetExchangeRates(ctx context.Context, filter ExchangeRateFilter) ([]models.ExchangeRate, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	addClause("base_currency = $%d", models.BaseCurrency.Code)
+	if filter.Currency != "" {
+		addClause("currency = $%d", strings.ToUpper(filter.Currency))
+	}
+	if filter.From != nil {
+		addClause("effective_date >= $%d", models.RateDate(*filter.From))
+	}
+	if filter.To != nil {
+		addClause("effective_date <= $%d", models.RateDate(*filter.To))
+	}
+
+	query := `
+		SELECT id, base_currency, currency, rate, effective_date, source, created_by, created_at
+		FROM exchange_rates
+		WHERE ` + strings.Join(whereClauses, " AND ") + `
+		ORDER BY currency, effective_date DESC
+	`
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []models.ExchangeRate{}
+	for rows.Next() {
+		rate, err := scanExchangeRate(rows)
+		if err != nil {
+			log.Printf("Error scanning exchange rate: %v", err)
+			return nil, err
+		}
+		rates = append(rates, *rate)
+	}
+	return rates, rows.Err()
+}
+
+// GetRate returns the rate of a currency to the base currency in effect on the given
+// day: the latest one that took effect on or before it. The base currency's rate is
+// always one. It returns nil when the currency has no rate yet on that day.
+func (r *ExchangeRateRepository) GetRate(ctx context.Context, currency string, on time.Time) (*models.ExchangeRate, error) {
+	currency = strings.ToUpper(currency)
+	if currency == models.BaseCurrency.Code {
+		rate := models.NewExchangeRate(currency, models.RateOne, on, "", "")
+		rate.ID = ""
+		return rate, nil
+	}
+
+	query := `
+		SELECT id, base_currency, currency, rate, effective_date, source, created_by, created_at
+		FROM exchange_rates
+		WHERE base_currency = $1 AND currency = $2 AND effective_date <= $3
+		ORDER BY effective_date DESC
+		LIMIT 1
+	`
+	rate, err := scanExchangeRate(r.DB.QueryRowContext(ctx, query, models.BaseCurrency.Code, currency, models.RateDate(on)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting exchange rate: %v", err)
+		return nil, err
+	}
+	return rate, nil
+}
+
+func scanExchangeRate(row rowScanner) (*models.ExchangeRate, error) {
+	rate := &models.ExchangeRate{}
+	var createdBy sql.NullString
+	err := row.Scan(
+		&rate.ID,
+		&rate.BaseCurrency,
+		&rate.Currency,
+		&rate.Rate,
+		&rate.EffectiveDate,
+		&rate.Source,
+		&createdBy,
+		&rate.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	rate.CreatedBy = createdBy.String
+	return rate, nil
+}
@@ -0,0 +1,221 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"erp-project/models"
+)
+
+// The interfaces below are what handlers and middleware depend on. The SQL repositories
+// in this package implement them; tests and tools embedding the ERP core can supply
+// their own. Every method takes the caller's context, so a cancelled request or an
+// expired deadline stops the work it started.
+
+type ProductStore interface {
+	CreateProduct(ctx context.Context, product *models.Product) error
+	GetAll(ctx context.Context) ([]models.Product, error)
+	GetProductsWithPagination(ctx context.Context, page, pageSize int, search, category string) ([]models.Product, int, error)
+	GetAllProducts(ctx context.Context) ([]models.Product, error)
+	GetProductByID(ctx context.Context, id string) (*models.Product, error)
+	UpdateProduct(ctx context.Context, product *models.Product, quantityChange int, updatedBy string) error
+	DeleteProduct(ctx context.Context, id string) error
+	UpdateProductQuantity(ctx context.Context, id string, quantity int) error
+}
+
+type CustomerStore interface {
+	CreateCustomer(ctx context.Context, customer *models.Customer) error
+	GetCustomerWithPagination(ctx context.Context, page, pageSize int, search, email string) ([]models.Customer, int, error)
+	GetAllCustomers(ctx context.Context) ([]*models.Customer, error)
+	GetCustomerByID(ctx context.Context, id string) (*models.Customer, error)
+	UpdateCustomer(ctx context.Context, customer *models.Customer) error
+	DeleteCustomer(ctx context.Context, id string) error
+}
+
+type OrderStore interface {
+	CreateOrder(ctx context.Context, order *models.Order) error
+	CreateOrderWithItems(ctx context.Context, order *models.Order, items []*models.OrderItem, opts models.AllocationOptions, placedBy string) ([]*models.OrderAllocation, error)
+	GetOrders(ctx context.Context) ([]*models.Order, error)
+	GetOrderItems(ctx context.Context, orderID string) ([]models.OrderItem, error)
+	GetOrderByID(ctx context.Context, id string) (*models.Order, error)
+	TransitionOrderStatus(ctx context.Context, orderID, toStatus, reason, changedBy string) (*models.OrderStatusHistory, error)
+	GetOrderStatusHistory(ctx context.Context, orderID string) ([]models.OrderStatusHistory, error)
+	CancelOrder(ctx context.Context, orderID string, lines map[string]int, reason, cancelledBy string) ([]*models.OrderCancellation, *models.OrderStatusHistory, error)
+	GetOrderCancellations(ctx context.Context, orderID string) ([]models.OrderCancellation, error)
+	GetOrderAllocations(ctx context.Context, orderID string) ([]models.OrderAllocation, error)
+	GetOrderTotals(ctx context.Context, from, to *time.Time) ([]models.OrderCurrencyTotal, error)
+}
+
+type SupplierStore interface {
+	CreateSupplier(ctx context.Context, supplier *models.Supplier) error
+	GetAllSuppliers(ctx context.Context) ([]models.Supplier, error)
+	GetSupplierByID(ctx context.Context, id string) (*models.Supplier, error)
+	UpdateSupplier(ctx context.Context, supplier *models.Supplier) error
+	DeleteSupplier(ctx context.Context, id string) error
+	AddProductSupplier(ctx context.Context, ps *models.ProductSupplier) error
+	GetProductSuppliers(ctx context.Context, productID string) ([]models.ProductSupplier, error)
+	GetSupplierProducts(ctx context.Context, supplierID string) ([]models.ProductSupplier, error)
+	GetProductSupplier(ctx context.Context, productID, supplierID string) (*models.ProductSupplier, error)
+	GetProductSupplierByID(ctx context.Context, id string) (*models.ProductSupplier, error)
+	RemoveProductSupplier(ctx context.Context, id string) error
+}
+
+type WarehouseStore interface {
+	CreateWarehouse(ctx context.Context, warehouse *models.Warehouse) error
+	GetAllWarehouses(ctx context.Context) ([]models.Warehouse, error)
+	GetWarehouseByID(ctx context.Context, id string) (*models.Warehouse, error)
+	UpdateWarehouse(ctx context.Context, warehouse *models.Warehouse) error
+	DeleteWarehouse(ctx context.Context, id string) error
+	CreateLocation(ctx context.Context, location *models.WarehouseLocation) error
+	GetLocationsByWarehouse(ctx context.Context, warehouseID string) ([]models.WarehouseLocation, error)
+	GetAvailableLocations(ctx context.Context, warehouseID string) ([]models.WarehouseLocation, error)
+	UpdateLocation(ctx context.Context, location *models.WarehouseLocation) error
+	CreateInventory(ctx context.Context, inventory *models.Inventory, createdBy string) error
+	GetInventoryByProduct(ctx context.Context, productID string) ([]models.Inventory, error)
+	GetInventoryByWarehouse(ctx context.Context, warehouseID string) ([]models.Inventory, error)
+	UpdateInventoryQuantity(ctx context.Context, id string, quantity, reservedQuantity int) error
+	GetInventoryByID(ctx context.Context, id string) (*models.Inventory, error)
+	AdjustInventory(ctx context.Context, adjustment *models.InventoryAdjustment) (*models.StockMovement, error)
+	UpdateInventoryLevels(ctx context.Context, id string, minQuantity int, maxQuantity *int) error
+}
+
+type StockMovementStore interface {
+	GetMovements(ctx context.Context, filter StockMovementFilter, page, pageSize int) ([]models.StockMovement, int, error)
+	GetStockAt(ctx context.Context, productID, warehouseID string, at time.Time) (int, error)
+}
+
+type TransferStore interface {
+	CreateTransferOrder(ctx context.Context, transfer *models.TransferOrder) error
+	GetTransferOrders(ctx context.Context, status, warehouseID string) ([]models.TransferOrder, error)
+	GetTransferOrderByID(ctx context.Context, id string) (*models.TransferOrder, error)
+	GetTransferReceipts(ctx context.Context, transferOrderID string) ([]models.TransferReceipt, error)
+	DispatchTransferOrder(ctx context.Context, id, dispatchedBy string) error
+	ReceiveTransferOrder(ctx context.Context, id string, receipts []models.TransferReceipt) ([]*models.TransferReceipt, error)
+	CancelTransferOrder(ctx context.Context, id string) error
+}
+
+type PurchaseOrderStore interface {
+	CreatePurchaseOrder(ctx context.Context, po *models.PurchaseOrder) error
+	CreatePurchaseOrders(ctx context.Context, purchaseOrders []*models.PurchaseOrder) error
+	GetPurchaseOrders(ctx context.Context, status, supplierID string) ([]models.PurchaseOrder, error)
+	GetPurchaseOrderByID(ctx context.Context, id string) (*models.PurchaseOrder, error)
+	ApprovePurchaseOrder(ctx context.Context, id, approvedBy string) error
+	SendPurchaseOrder(ctx context.Context, id string) error
+	CancelPurchaseOrder(ctx context.Context, id string) error
+	ReceivePurchaseOrder(ctx context.Context, id, warehouseID string, receipts []models.PurchaseOrderReceipt, allowOverReceipt bool) ([]*models.PurchaseOrderReceipt, error)
+	ClosePurchaseOrder(ctx context.Context, id string) error
+	GetPurchaseOrderReceipts(ctx context.Context, purchaseOrderID string) ([]models.PurchaseOrderReceipt, error)
+}
+
+type ReplenishmentStore interface {
+	GetSuggestions(ctx context.Context, filter ReplenishmentFilter) ([]models.ReplenishmentSuggestion, error)
+}
+
+type ExchangeRateStore interface {
+	CreateExchangeRates(ctx context.Context, rates []*models.ExchangeRate) error
+	GetExchangeRates(ctx context.Context, filter ExchangeRateFilter) ([]models.ExchangeRate, error)
+	GetRate(ctx context.Context, currency string, on time.Time) (*models.ExchangeRate, error)
+}
+
+type PriceListStore interface {
+	CreatePriceList(ctx context.Context, list *models.PriceList) error
+	UpdatePriceList(ctx context.Context, list *models.PriceList) error
+	GetPriceLists(ctx context.Context, filter PriceListFilter) ([]models.PriceList, error)
+	GetPriceListByID(ctx context.Context, id string) (*models.PriceList, error)
+	GetApplicablePriceLists(ctx context.Context, customerID, customerGroup string, on time.Time) ([]models.PriceList, error)
+}
+
+type PromotionStore interface {
+	CreatePromotion(ctx context.Context, promotion *models.Promotion) error
+	UpdatePromotion(ctx context.Context, promotion *models.Promotion) error
+	GetPromotions(ctx context.Context, filter PromotionFilter) ([]models.Promotion, error)
+	GetPromotionByID(ctx context.Context, id string) (*models.Promotion, error)
+	CreateCoupon(ctx context.Context, coupon *models.Coupon) error
+	GetCoupons(ctx context.Context, promotionID string) ([]models.Coupon, error)
+	GetCouponByCode(ctx context.Context, code string) (*models.Coupon, error)
+}
+
+type TaxStore interface {
+	CreateTaxRate(ctx context.Context, rate *models.TaxRate) error
+	UpdateTaxRate(ctx context.Context, rate *models.TaxRate) error
+	GetTaxRates(ctx context.Context, filter TaxRateFilter) ([]models.TaxRate, error)
+	GetTaxRateByID(ctx context.Context, id string) (*models.TaxRate, error)
+	GetApplicableTaxRates(ctx context.Context, jurisdiction models.TaxJurisdiction, on time.Time) ([]models.TaxRate, error)
+	GetTaxSummary(ctx context.Context, from, to *time.Time) ([]models.TaxSummaryLine, error)
+}
+
+type InvoiceStore interface {
+	CreateInvoice(ctx context.Context, invoice *models.Invoice, quantities map[string]int) error
+	CreateCreditNote(ctx context.Context, creditNote *models.Invoice, quantities map[string]int) error
+	IssueInvoice(ctx context.Context, id, issuedBy string, on time.Time) (*models.Invoice, error)
+	VoidInvoice(ctx context.Context, id, reason, voidedBy string) (*models.Invoice, error)
+	GetInvoices(ctx context.Context, filter InvoiceFilter, page, pageSize int) ([]models.Invoice, int, error)
+	GetInvoiceByID(ctx context.Context, id string) (*models.Invoice, error)
+	GetCreditNotes(ctx context.Context, invoiceID string) ([]models.Invoice, error)
+}
+
+type PaymentStore interface {
+	CreatePayment(ctx context.Context, payment *models.Payment, allocations map[string]models.Money, autoAllocate bool) error
+	AllocatePayment(ctx context.Context, id string, allocations map[string]models.Money, autoAllocate bool, allocatedBy string) (*models.Payment, error)
+	VoidPayment(ctx context.Context, id, reason, voidedBy string) (*models.Payment, error)
+	GetPayments(ctx context.Context, filter PaymentFilter, page, pageSize int) ([]models.Payment, int, error)
+	GetPaymentByID(ctx context.Context, id string) (*models.Payment, error)
+	GetInvoiceAllocations(ctx context.Context, invoiceID string) ([]models.PaymentAllocation, error)
+	GetCustomerBalance(ctx context.Context, customerID string, on time.Time) ([]models.CustomerBalance, error)
+	GetReceivablesAging(ctx context.Context, on time.Time) ([]models.ReceivablesAgingLine, error)
+}
+
+type SupplierBillStore interface {
+	CreateSupplierBill(ctx context.Context, bill *models.SupplierBill, lines map[string]BilledUnits, tolerancePercent models.Money) error
+	ApproveSupplierBill(ctx context.Context, id, approvedBy string, acceptVariances bool) (*models.SupplierBill, error)
+	VoidSupplierBill(ctx context.Context, id, reason, voidedBy string) (*models.SupplierBill, error)
+	PaySupplierBill(ctx context.Context, payment *models.SupplierPayment) (*models.SupplierBill, error)
+	GetSupplierBills(ctx context.Context, filter SupplierBillFilter, page, pageSize int) ([]models.SupplierBill, int, error)
+	GetSupplierBillByID(ctx context.Context, id string) (*models.SupplierBill, error)
+	GetPayablesAging(ctx context.Context, on time.Time) ([]models.PayablesAgingLine, error)
+}
+
+type UserStore interface {
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUsers(ctx context.Context) ([]models.User, error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	UpdateUser(ctx context.Context, user *models.User) error
+	CountUsers(ctx context.Context) (int, error)
+	CountUsersWithRole(ctx context.Context, role string) (int, error)
+	RecordLogin(ctx context.Context, id string) error
+	SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	RotateRefreshToken(ctx context.Context, oldID string, replacement *models.RefreshToken) error
+	RevokeRefreshToken(ctx context.Context, id, userID string) error
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	GetAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id, userID string) error
+	RecordAPIKeyUse(ctx context.Context, id string) error
+}
+
+type AuditStore interface {
+	CreateAuditLog(ctx context.Context, entry *models.AuditLog) error
+	GetAuditLogs(ctx context.Context, filter AuditLogFilter, page, pageSize int) ([]models.AuditLog, int, error)
+}
+
+var (
+	_ ProductStore       = (*ProductRepository)(nil)
+	_ CustomerStore      = (*CustomerRepository)(nil)
+	_ OrderStore         = (*OrderRepository)(nil)
+	_ SupplierStore      = (*SupplierRepository)(nil)
+	_ WarehouseStore     = (*WarehouseRepository)(nil)
+	_ StockMovementStore = (*StockMovementRepository)(nil)
+	_ TransferStore      = (*TransferRepository)(nil)
+	_ PurchaseOrderStore = (*PurchaseOrderRepository)(nil)
+	_ ReplenishmentStore = (*ReplenishmentRepository)(nil)
+	_ ExchangeRateStore  = (*ExchangeRateRepository)(nil)
+	_ PriceListStore     = (*PriceListRepository)(nil)
+	_ PromotionStore     = (*PromotionRepository)(nil)
+	_ TaxStore           = (*TaxRepository)(nil)
+	_ InvoiceStore       = (*InvoiceRepository)(nil)
+	_ PaymentStore       = (*PaymentRepository)(nil)
+	_ SupplierBillStore  = (*SupplierBillRepository)(nil)
+	_ UserStore          = (*UserRepository)(nil)
+	_ AuditStore         = (*AuditRepository)(nil)
+)
@@ -0,0 +1,738 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"erp-project/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvoiceNotFound      = errors.New("invoice not found")
+	ErrInvalidInvoiceStatus = errors.New("invoice is not in a valid status for this action")
+	ErrInvalidInvoice       = errors.New("invalid invoice")
+)
+
+// invoiceColumns are the columns scanInvoice reads, in order, from invoices i joined to
+// customers c and the credited invoice ci
+const invoiceColumns = `i.id, i.invoice_number, i.type, i.status, i.order_id, i.customer_id, i.credited_invoice_id,
+	i.currency, i.exchange_rate, i.payment_terms_days, i.invoice_date, i.due_date, i.subtotal_amount, i.tax_amount,
+	i.total_amount, i.base_total_amount, i.amount_credited, i.amount_paid, i.prices_include_tax, i.tax_country,
+	i.tax_region, i.tax_exempt, i.tax_exemption_number, i.notes, i.reason, i.void_reason, i.created_by, i.issued_by,
+	i.issued_at, i.voided_by, i.voided_at, i.created_at, i.updated_at, c.name, ci.invoice_number`
+
+const invoiceJoins = `
+	FROM invoices i
+	LEFT JOIN customers c ON i.customer_id = c.id
+	LEFT JOIN invoices ci ON i.credited_invoice_id = ci.id`
+
+// InvoiceFilter narrows down invoice lists. Empty fields are ignored.
+type InvoiceFilter struct {
+	CustomerID string
+	OrderID    string
+	Type       string
+	Status     string
+	Currency   string
+	From       *time.Time // Invoice date, or creation date for drafts
+	To         *time.Time
+	OverdueOn  *time.Time // Invoices with something left to pay that were due before this day
+}
+
+type InvoiceRepository struct {
+	DB *database.Conn
+}
+
+func NewInvoiceRepository(db *database.Conn) *InvoiceRepository {
+	return &InvoiceRepository{DB: db}
+}
+
+// CreateInvoice saves a draft invoice for shipped units of its order. quantities maps
+// order line IDs to the units to bill; when it is empty every shipped unit not yet on
+// an invoice is billed. The lines are priced here, inside the transaction that books
+// the units as invoiced, so two invoices can never bill the same units.
+func (r *InvoiceRepository) CreateInvoice(ctx context.Context, invoice *models.Invoice, quantities map[string]int) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1`, invoice.OrderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading order status: %v", err)
+		return err
+	}
+	if status != models.OrderStatusShipped && status != models.OrderStatusDelivered {
+		return fmt.Errorf("%w: only shipped or delivered orders can be invoiced, this one is %s", ErrInvalidInvoice, status)
+	}
+
+	items, err := orderItems(ctx, tx, invoice.OrderID)
+	if err != nil {
+		return err
+	}
+	byID := map[string]*models.OrderItem{}
+	for i := range items {
+		byID[items[i].ID] = &items[i]
+	}
+	if len(quantities) == 0 {
+		quantities = map[string]int{}
+		for _, item := range items {
+			if n := item.Uninvoiced(); n > 0 {
+				quantities[item.ID] = n
+			}
+		}
+		if len(quantities) == 0 {
+			return fmt.Errorf("%w: every shipped unit of the order is already invoiced", ErrInvalidInvoice)
+		}
+	}
+
+	invoice.Lines = nil
+	for _, item := range items {
+		quantity, ok := quantities[item.ID]
+		if !ok {
+			continue
+		}
+		if quantity <= 0 {
+			return fmt.Errorf("%w: quantity must be positive", ErrInvalidInvoice)
+		}
+		if quantity > item.Uninvoiced() {
+			return fmt.Errorf("%w: line %s has %d shipped units not yet invoiced", ErrInvalidInvoice, item.ID, item.Uninvoiced())
+		}
+		invoice.AddOrderLine(byID[item.ID], quantity)
+
+		// Only book the units while nobody else has invoiced any of the line since it was read
+		result, err := tx.ExecContext(ctx,
+			`UPDATE order_items SET invoiced_quantity = invoiced_quantity + $1 WHERE id = $2 AND invoiced_quantity = $3`,
+			quantity,
+			item.ID,
+			item.InvoicedQuantity,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return fmt.Errorf("%w: line %s was invoiced by another request", ErrInvalidInvoice, item.ID)
+		}
+	}
+	for id := range quantities {
+		if byID[id] == nil {
+			return fmt.Errorf("%w: line %s does not belong to this order", ErrInvalidInvoice, id)
+		}
+	}
+
+	if err := insertInvoice(ctx, tx, invoice); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateCreditNote saves a draft credit note against an issued invoice. quantities maps
+// the invoice's line IDs to the units to credit; when it is empty every unit not yet
+// on a credit note is credited.
+func (r *InvoiceRepository) CreateCreditNote(ctx context.Context, creditNote *models.Invoice, quantities map[string]int) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	invoice, err := getInvoice(ctx, tx, *creditNote.CreditedInvoiceID)
+	if err != nil {
+		return err
+	}
+	if invoice.Type != models.InvoiceTypeInvoice {
+		return fmt.Errorf("%w: only invoices can be credited", ErrInvalidInvoice)
+	}
+	switch invoice.Status {
+	case models.InvoiceStatusIssued, models.InvoiceStatusPartiallyPaid, models.InvoiceStatusPaid:
+	default:
+		return fmt.Errorf("%w: cannot credit a %s invoice", ErrInvalidInvoiceStatus, invoice.Status)
+	}
+
+	byID := map[string]*models.InvoiceLine{}
+	for i := range invoice.Lines {
+		byID[invoice.Lines[i].ID] = &invoice.Lines[i]
+	}
+	if len(quantities) == 0 {
+		quantities = map[string]int{}
+		for _, line := range invoice.Lines {
+			if n := line.Uncredited(); n > 0 {
+				quantities[line.ID] = n
+			}
+		}
+		if len(quantities) == 0 {
+			return fmt.Errorf("%w: the invoice is already fully credited", ErrInvalidInvoice)
+		}
+	}
+
+	creditNote.Lines = nil
+	for _, line := range invoice.Lines {
+		quantity, ok := quantities[line.ID]
+		if !ok {
+			continue
+		}
+		if quantity <= 0 {
+			return fmt.Errorf("%w: quantity must be positive", ErrInvalidInvoice)
+		}
+		if quantity > line.Uncredited() {
+			return fmt.Errorf("%w: line %s has %d units not yet credited", ErrInvalidInvoice, line.ID, line.Uncredited())
+		}
+		creditNote.AddCreditLine(byID[line.ID], quantity)
+
+		result, err := tx.ExecContext(ctx,
+			`UPDATE invoice_lines SET credited_quantity = credited_quantity + $1 WHERE id = $2 AND credited_quantity = $3`,
+			quantity,
+			line.ID,
+			line.CreditedQuantity,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return fmt.Errorf("%w: line %s was credited by another request", ErrInvalidInvoice, line.ID)
+		}
+	}
+	for id := range quantities {
+		if byID[id] == nil {
+			return fmt.Errorf("%w: line %s does not belong to this invoice", ErrInvalidInvoice, id)
+		}
+	}
+
+	if err := insertInvoice(ctx, tx, creditNote); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IssueInvoice numbers and dates a draft invoice or credit note. The number is the next
+// in its type's sequence, taken in the same transaction, so issued numbers have no gaps.
+// An issued credit note is applied to the invoice it credits.
+func (r *InvoiceRepository) IssueInvoice(ctx context.Context, id, issuedBy string, on time.Time) (*models.Invoice, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invoice, err := getInvoice(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Status != models.InvoiceStatusDraft {
+		return nil, fmt.Errorf("%w: only drafts can be issued, this one is %s", ErrInvalidInvoiceStatus, invoice.Status)
+	}
+
+	number, err := nextInvoiceNumber(ctx, tx, invoice.Type)
+	if err != nil {
+		return nil, err
+	}
+	invoice.Issue(number, on, issuedBy)
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE invoices SET invoice_number = $1, status = $2, invoice_date = $3, due_date = $4, issued_by = $5,
+		                     issued_at = $6, updated_at = $7
+		 WHERE id = $8 AND status = $9`,
+		invoice.InvoiceNumber,
+		invoice.Status,
+		invoice.InvoiceDate,
+		invoice.DueDate,
+		invoice.IssuedBy,
+		invoice.IssuedAt,
+		time.Now(),
+		id,
+		models.InvoiceStatusDraft,
+	)
+	if err != nil {
+		log.Printf("Error issuing invoice: %v", err)
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, fmt.Errorf("%w: the invoice was changed by another request", ErrInvalidInvoiceStatus)
+	}
+
+	if invoice.Type == models.InvoiceTypeCreditNote {
+		if err := settleInvoice(ctx, tx, *invoice.CreditedInvoiceID, invoice.TotalAmount, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetInvoiceByID(ctx, id)
+}
+
+// VoidInvoice cancels a draft, or an issued invoice or credit note nothing has settled
+// yet. Its units can be invoiced or credited again, and a voided credit note no longer
+// reduces what is due on its invoice. A void invoice keeps its number.
+func (r *InvoiceRepository) VoidInvoice(ctx context.Context, id, reason, voidedBy string) (*models.Invoice, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invoice, err := getInvoice(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !invoice.Voidable() {
+		return nil, fmt.Errorf("%w: a %s invoice with payments or credits cannot be voided", ErrInvalidInvoiceStatus, invoice.Status)
+	}
+
+	if invoice.Type == models.InvoiceTypeInvoice {
+		var creditNotes int
+		err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM invoices WHERE credited_invoice_id = $1 AND status <> $2`,
+			id,
+			models.InvoiceStatusVoid,
+		).Scan(&creditNotes)
+		if err != nil {
+			return nil, err
+		}
+		if creditNotes > 0 {
+			return nil, fmt.Errorf("%w: void the invoice's credit notes first", ErrInvalidInvoiceStatus)
+		}
+		for _, line := range invoice.Lines {
+			_, err := tx.ExecContext(ctx,
+				`UPDATE order_items SET invoiced_quantity = invoiced_quantity - $1 WHERE id = $2`,
+				line.Quantity,
+				line.OrderItemID,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for _, line := range invoice.Lines {
+			_, err := tx.ExecContext(ctx,
+				`UPDATE invoice_lines SET credited_quantity = credited_quantity - $1 WHERE id = $2`,
+				line.Quantity,
+				line.CreditedLineID,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if invoice.Status == models.InvoiceStatusIssued {
+			if err := settleInvoice(ctx, tx, *invoice.CreditedInvoiceID, -invoice.TotalAmount, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE invoices SET status = $1, void_reason = $2, voided_by = $3, voided_at = $4, updated_at = $5
+		 WHERE id = $6 AND status = $7`,
+		models.InvoiceStatusVoid,
+		reason,
+		voidedBy,
+		now,
+		now,
+		id,
+		invoice.Status,
+	)
+	if err != nil {
+		log.Printf("Error voiding invoice: %v", err)
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, fmt.Errorf("%w: the invoice was changed by another request", ErrInvalidInvoiceStatus)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetInvoiceByID(ctx, id)
+}
+
+// GetInvoices lists invoices and credit notes without their lines, newest first
+func (r *InvoiceRepository) GetInvoices(ctx context.Context, filter InvoiceFilter, page, pageSize int) ([]models.Invoice, int, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.CustomerID != "" {
+		addClause("i.customer_id = $%d", filter.CustomerID)
+	}
+	if filter.OrderID != "" {
+		addClause("i.order_id = $%d", filter.OrderID)
+	}
+	if filter.Type != "" {
+		addClause("i.type = $%d", filter.Type)
+	}
+	if filter.Status != "" {
+		addClause("i.status = $%d", filter.Status)
+	}
+	if filter.Currency != "" {
+		addClause("i.currency = $%d", strings.ToUpper(filter.Currency))
+	}
+	if filter.From != nil {
+		addClause("COALESCE(i.invoice_date, i.created_at) >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addClause("COALESCE(i.invoice_date, i.created_at) <= $%d", *filter.To)
+	}
+	if filter.OverdueOn != nil {
+		// Drafts have no due date, so this leaves issued invoices with something to pay
+		addClause("i.type = $%d", models.InvoiceTypeInvoice)
+		addClause("i.status <> $%d AND i.total_amount > i.amount_credited + i.amount_paid", models.InvoiceStatusVoid)
+		addClause("i.due_date < $%d", models.RateDate(*filter.OverdueOn))
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	var total int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM invoices i "+whereClause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s %s %s
+		ORDER BY COALESCE(i.invoice_date, i.created_at) DESC, i.created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, invoiceColumns, invoiceJoins, whereClause, len(args)+1, len(args)+2)
+	args = append(args, pageSize, utils.CalculateOffset(page, pageSize))
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	invoices := []models.Invoice{}
+	for rows.Next() {
+		invoice, err := scanInvoice(rows)
+		if err != nil {
+			log.Printf("Error scanning invoice: %v", err)
+			return nil, 0, err
+		}
+		invoices = append(invoices, *invoice)
+	}
+	return invoices, total, rows.Err()
+}
+
+// GetInvoiceByID returns an invoice or credit note with its lines
+func (r *InvoiceRepository) GetInvoiceByID(ctx context.Context, id string) (*models.Invoice, error) {
+	invoice, err := getInvoice(ctx, r.DB, id)
+	if errors.Is(err, ErrInvoiceNotFound) {
+		return nil, nil
+	}
+	return invoice, err
+}
+
+// GetCreditNotes lists the credit notes raised against an invoice, oldest first
+func (r *InvoiceRepository) GetCreditNotes(ctx context.Context, invoiceID string) ([]models.Invoice, error) {
+	query := `SELECT ` + invoiceColumns + invoiceJoins + ` WHERE i.credited_invoice_id = $1 ORDER BY i.created_at`
+	rows, err := r.DB.QueryContext(ctx, query, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creditNotes := []models.Invoice{}
+	for rows.Next() {
+		creditNote, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		creditNotes = append(creditNotes, *creditNote)
+	}
+	return creditNotes, rows.Err()
+}
+
+// invoiceQuerier reads invoices inside a transaction or not
+type invoiceQuerier interface {
+	rowQuerier
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *database.Row
+}
+
+// getInvoice loads an invoice with its lines, or ErrInvoiceNotFound
+func getInvoice(ctx context.Context, q invoiceQuerier, id string) (*models.Invoice, error) {
+	query := `SELECT ` + invoiceColumns + invoiceJoins + ` WHERE i.id = $1`
+	invoice, err := scanInvoice(q.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvoiceNotFound
+	}
+	if err != nil {
+		log.Printf("Error getting invoice: %v", err)
+		return nil, err
+	}
+	invoice.Lines, err = invoiceLines(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+func insertInvoice(ctx context.Context, tx *database.Tx, invoice *models.Invoice) error {
+	query := `
+		INSERT INTO invoices (id, type, status, order_id, customer_id, credited_invoice_id, currency, exchange_rate,
+		                      payment_terms_days, subtotal_amount, tax_amount, total_amount, base_total_amount,
+		                      prices_include_tax, tax_country, tax_region, tax_exempt, tax_exemption_number, notes,
+		                      reason, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)
+	`
+	_, err := tx.ExecContext(ctx,
+		query,
+		invoice.ID,
+		invoice.Type,
+		invoice.Status,
+		invoice.OrderID,
+		invoice.CustomerID,
+		invoice.CreditedInvoiceID,
+		invoice.Currency,
+		invoice.ExchangeRate,
+		invoice.PaymentTermsDays,
+		invoice.SubtotalAmount,
+		invoice.TaxAmount,
+		invoice.TotalAmount,
+		invoice.BaseTotalAmount,
+		invoice.PricesIncludeTax,
+		invoice.TaxCountry,
+		invoice.TaxRegion,
+		invoice.TaxExempt,
+		invoice.TaxExemptionNumber,
+		invoice.Notes,
+		invoice.Reason,
+		invoice.CreatedBy,
+		invoice.CreatedAt,
+		invoice.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating invoice: %v", err)
+		return err
+	}
+
+	lineQuery := `
+		INSERT INTO invoice_lines (id, invoice_id, order_item_id, credited_line_id, product_id, description, quantity,
+		                           unit_price, discount_amount, tax_category, tax_rate, tax_amount, total_price)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`
+	for _, line := range invoice.Lines {
+		_, err := tx.ExecContext(ctx,
+			lineQuery,
+			line.ID,
+			line.InvoiceID,
+			line.OrderItemID,
+			line.CreditedLineID,
+			line.ProductID,
+			line.Description,
+			line.Quantity,
+			line.UnitPrice,
+			line.DiscountAmount,
+			line.TaxCategory,
+			line.TaxRate,
+			line.TaxAmount,
+			line.TotalPrice,
+		)
+		if err != nil {
+			log.Printf("Error creating invoice line: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// nextInvoiceNumber takes the next number in an invoice type's sequence. The row stays
+// locked until the transaction ends, and rolling back hands the number back.
+func nextInvoiceNumber(ctx context.Context, tx *database.Tx, invoiceType string) (int64, error) {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE invoice_sequences SET last_number = last_number + 1 WHERE invoice_type = $1`,
+		invoiceType,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var number int64
+	err = tx.QueryRowContext(ctx, `SELECT last_number FROM invoice_sequences WHERE invoice_type = $1`, invoiceType).Scan(&number)
+	if err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+// settleInvoice adds credits and payments to what has settled an invoice and moves it
+// to the status its balance calls for. Negative amounts reverse earlier ones.
+func settleInvoice(ctx context.Context, tx *database.Tx, id string, credited, paid models.Money) error {
+	invoice := &models.Invoice{ID: id}
+	err := tx.QueryRowContext(ctx,
+		`SELECT type, status, total_amount, amount_credited, amount_paid FROM invoices WHERE id = $1`,
+		id,
+	).Scan(&invoice.Type, &invoice.Status, &invoice.TotalAmount, &invoice.AmountCredited, &invoice.AmountPaid)
+	if err == sql.ErrNoRows {
+		return ErrInvoiceNotFound
+	}
+	if err != nil {
+		return err
+	}
+	previousCredited, previousPaid := invoice.AmountCredited, invoice.AmountPaid
+	invoice.AmountCredited += credited
+	invoice.AmountPaid += paid
+	invoice.Settle()
+
+	// Only settle while nobody else has paid or credited the invoice since it was read
+	result, err := tx.ExecContext(ctx,
+		`UPDATE invoices SET amount_credited = $1, amount_paid = $2, status = $3, updated_at = $4
+		 WHERE id = $5 AND amount_credited = $6 AND amount_paid = $7`,
+		invoice.AmountCredited,
+		invoice.AmountPaid,
+		invoice.Status,
+		time.Now(),
+		id,
+		previousCredited,
+		previousPaid,
+	)
+	if err != nil {
+		log.Printf("Error settling invoice: %v", err)
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return fmt.Errorf("%w: the invoice was changed by another request", ErrInvalidInvoiceStatus)
+	}
+	return nil
+}
+
+func scanInvoice(row rowScanner) (*models.Invoice, error) {
+	invoice := &models.Invoice{}
+	var number, creditedID, notes, reason, voidReason, createdBy, issuedBy, voidedBy sql.NullString
+	var customerName, creditedNumber sql.NullString
+	var invoiceDate, dueDate, issuedAt, voidedAt sql.NullTime
+	err := row.Scan(
+		&invoice.ID,
+		&number,
+		&invoice.Type,
+		&invoice.Status,
+		&invoice.OrderID,
+		&invoice.CustomerID,
+		&creditedID,
+		&invoice.Currency,
+		&invoice.ExchangeRate,
+		&invoice.PaymentTermsDays,
+		&invoiceDate,
+		&dueDate,
+		&invoice.SubtotalAmount,
+		&invoice.TaxAmount,
+		&invoice.TotalAmount,
+		&invoice.BaseTotalAmount,
+		&invoice.AmountCredited,
+		&invoice.AmountPaid,
+		&invoice.PricesIncludeTax,
+		&invoice.TaxCountry,
+		&invoice.TaxRegion,
+		&invoice.TaxExempt,
+		&invoice.TaxExemptionNumber,
+		&notes,
+		&reason,
+		&voidReason,
+		&createdBy,
+		&issuedBy,
+		&issuedAt,
+		&voidedBy,
+		&voidedAt,
+		&invoice.CreatedAt,
+		&invoice.UpdatedAt,
+		&customerName,
+		&creditedNumber,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if creditedID.Valid {
+		invoice.CreditedInvoiceID = &creditedID.String
+	}
+	if invoiceDate.Valid {
+		invoice.InvoiceDate = &invoiceDate.Time
+	}
+	if dueDate.Valid {
+		invoice.DueDate = &dueDate.Time
+	}
+	if issuedAt.Valid {
+		invoice.IssuedAt = &issuedAt.Time
+	}
+	if voidedAt.Valid {
+		invoice.VoidedAt = &voidedAt.Time
+	}
+	invoice.InvoiceNumber = number.String
+	invoice.Notes = notes.String
+	invoice.Reason = reason.String
+	invoice.VoidReason = voidReason.String
+	invoice.CreatedBy = createdBy.String
+	invoice.IssuedBy = issuedBy.String
+	invoice.VoidedBy = voidedBy.String
+	invoice.CustomerName = customerName.String
+	invoice.CreditedInvoiceNumber = creditedNumber.String
+	invoice.CalculateBalance()
+	return invoice, nil
+}
+
+func invoiceLines(ctx context.Context, q rowQuerier, invoiceID string) ([]models.InvoiceLine, error) {
+	query := `
+		SELECT il.id, il.invoice_id, il.order_item_id, il.credited_line_id, il.product_id, il.description, il.quantity,
+		       il.credited_quantity, il.unit_price, il.discount_amount, il.tax_category, il.tax_rate, il.tax_amount,
+		       il.total_price, p.sku
+		FROM invoice_lines il
+		LEFT JOIN products p ON il.product_id = p.id
+		WHERE il.invoice_id = $1
+		ORDER BY il.description, il.id
+	`
+	rows, err := q.QueryContext(ctx, query, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []models.InvoiceLine{}
+	for rows.Next() {
+		var line models.InvoiceLine
+		var creditedLineID, sku sql.NullString
+		err := rows.Scan(
+			&line.ID,
+			&line.InvoiceID,
+			&line.OrderItemID,
+			&creditedLineID,
+			&line.ProductID,
+			&line.Description,
+			&line.Quantity,
+			&line.CreditedQuantity,
+			&line.UnitPrice,
+			&line.DiscountAmount,
+			&line.TaxCategory,
+			&line.TaxRate,
+			&line.TaxAmount,
+			&line.TotalPrice,
+			&sku,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if creditedLineID.Valid {
+			line.CreditedLineID = &creditedLineID.String
+		}
+		line.SKU = sku.String
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
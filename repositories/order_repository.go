@@ -1,21 +1,58 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"erp-project/database"
 	"erp-project/models"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"time"
 )
 
+var (
+	ErrOrderNotFound           = errors.New("order not found")
+	ErrInvalidStatusTransition = errors.New("invalid order status transition")
+	ErrOrderStatusChanged      = errors.New("order status was changed by another request")
+	ErrInvalidCancellation     = errors.New("invalid order cancellation")
+	ErrInsufficientStock       = errors.New("insufficient stock")
+	ErrOrderTotalMismatch      = errors.New("order total does not equal the sum of its lines")
+)
+
+// InsufficientStockError reports the product that could not be decremented when an
+// order was placed. It wraps ErrInsufficientStock.
+type InsufficientStockError struct {
+	ProductID string
+	Available int
+	Requested int
+	Strategy  string // Set when the warehouse allocation failed rather than the product stock
+}
+
+func (e *InsufficientStockError) Error() string {
+	if e.Strategy != "" {
+		return fmt.Sprintf("insufficient warehouse stock for product %s using %s allocation: available %d, requested %d",
+			e.ProductID, e.Strategy, e.Available, e.Requested)
+	}
+	return fmt.Sprintf("insufficient stock for product %s: available %d, requested %d", e.ProductID, e.Available, e.Requested)
+}
+
+func (e *InsufficientStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
 type OrderRepository struct {
-	DB *sql.DB
+	DB *database.Conn
 }
 
-func NewOrderRepository(db *sql.DB) *OrderRepository {
+func NewOrderRepository(db *database.Conn) *OrderRepository {
 	return &OrderRepository{DB: db}
 }
 
-func (r *OrderRepository) CreateOrder(order *models.Order) error {
-	tx, err := r.DB.Begin()
+func (r *OrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
 		return err
@@ -23,14 +60,17 @@ func (r *OrderRepository) CreateOrder(order *models.Order) error {
 
 	// FIXED: Changed ? to $1, $2, etc.
 	query := `
-		INSERT INTO orders (id, customer_id, total_amount, status, order_date) 
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO orders (id, customer_id, currency, total_amount, exchange_rate, base_total_amount, status, order_date) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 	`
-	_, err = tx.Exec(
+	_, err = tx.ExecContext(ctx,
 		query,
 		order.ID,
 		order.CustomerID,
+		order.Currency,
 		order.TotalAmount,
+		order.ExchangeRate,
+		order.BaseTotalAmount,
 		order.Status,
 		order.OrderDate,
 	)
@@ -44,76 +84,174 @@ func (r *OrderRepository) CreateOrder(order *models.Order) error {
 	return tx.Commit()
 }
 
-func (r *OrderRepository) CreateOrderWithItems(order *models.Order, items []*models.OrderItem) error {
-	tx, err := r.DB.Begin()
+// CreateOrderWithItems saves an order, takes its stock from products.quantity and
+// reserves each line against warehouse inventory using the given allocation options.
+// Products with no inventory rows in any warehouse are not allocated. The order total
+// must equal the sum of the line totals. placedBy is recorded on the stock movements
+// and the order's first status history entry.
+func (r *OrderRepository) CreateOrderWithItems(ctx context.Context, order *models.Order, items []*models.OrderItem, opts models.AllocationOptions, placedBy string) ([]*models.OrderAllocation, error) {
+	if total := models.OrderItemsTotal(items); order.TotalAmount != total {
+		return nil, fmt.Errorf("%w: total %s, lines %s", ErrOrderTotalMismatch, order.TotalAmount, total)
+	}
+	if tax := models.OrderItemsTax(items); order.TaxAmount != tax {
+		return nil, fmt.Errorf("%w: tax %s, lines %s", ErrOrderTotalMismatch, order.TaxAmount, tax)
+	}
+	order.CalculateBaseTotal()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
-		return err
+		return nil, err
 	}
 
 	// FIXED: Changed ? to $1, $2, etc.
 	orderQuery := `
-		INSERT INTO orders (id, customer_id, total_amount, status, order_date) 
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO orders (id, customer_id, currency, total_amount, exchange_rate, base_total_amount, status, order_date,
+		                    coupon_id, tax_amount, prices_include_tax, tax_country, tax_region, tax_exempt,
+		                    tax_exemption_number) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 	`
-	_, err = tx.Exec(
+	_, err = tx.ExecContext(ctx,
 		orderQuery,
 		order.ID,
 		order.CustomerID,
+		order.Currency,
 		order.TotalAmount,
+		order.ExchangeRate,
+		order.BaseTotalAmount,
 		order.Status,
 		order.OrderDate,
+		order.CouponID,
+		order.TaxAmount,
+		order.PricesIncludeTax,
+		order.TaxCountry,
+		order.TaxRegion,
+		order.TaxExempt,
+		order.TaxExemptionNumber,
 	)
 	if err != nil {
 		tx.Rollback()
 		log.Printf("Error creating order: %v", err)
-		return err
+		return nil, err
+	}
+
+	if order.CouponID != nil {
+		if err := redeemCoupon(ctx, tx, *order.CouponID, order.ID, order.CustomerID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
 	// FIXED: Changed ? to $1, $2, etc.
 	itemQuery := `
-		INSERT INTO order_items (id, order_id, product_id, quantity, unit_price, total_price) 
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO order_items (id, order_id, product_id, quantity, cancelled_quantity, unit_price, discount_amount,
+		                         total_price, status, list_price, price_list_id, price_break_quantity, tax_category,
+		                         tax_rate_id, tax_rate, tax_amount) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 	`
+	var allocations []*models.OrderAllocation
 	for _, item := range items {
-		_, err = tx.Exec(
+		_, err = tx.ExecContext(ctx,
 			itemQuery,
 			item.ID,
 			item.OrderID,
 			item.ProductID,
 			item.Quantity,
+			item.CancelledQuantity,
 			item.UnitPrice,
+			item.DiscountAmount,
 			item.TotalPrice,
+			item.Status,
+			item.ListPrice,
+			item.PriceListID,
+			item.PriceBreakQuantity,
+			item.TaxCategory,
+			item.TaxRateID,
+			item.TaxRate,
+			item.TaxAmount,
 		)
 		if err != nil {
 			tx.Rollback()
 			log.Printf("Error creating order item: %v", err)
-			return err
+			return nil, err
+		}
+
+		if err := insertOrderItemDiscounts(ctx, tx, item); err != nil {
+			tx.Rollback()
+			log.Printf("Error recording order item discounts: %v", err)
+			return nil, err
 		}
 
-		// FIXED: Changed ? to $1, $2
+		// Check and decrement in one statement so concurrent orders cannot both take the
+		// last units: the row is only updated while enough stock is left
 		updateQuery := `
-			UPDATE products SET quantity = quantity - $1 WHERE id = $2
+			UPDATE products SET quantity = quantity - $1 WHERE id = $2 AND quantity >= $1
 		`
-		_, err = tx.Exec(updateQuery, item.Quantity, item.ProductID)
+		result, err := tx.ExecContext(ctx, updateQuery, item.Quantity, item.ProductID)
 		if err != nil {
 			tx.Rollback()
 			log.Printf("Error updating product quantity: %v", err)
-			return err
+			return nil, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
 		}
+		if affected == 0 {
+			var available int
+			if err := tx.QueryRowContext(ctx, `SELECT quantity FROM products WHERE id = $1`, item.ProductID).Scan(&available); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			tx.Rollback()
+			return nil, &InsufficientStockError{
+				ProductID: item.ProductID,
+				Available: available,
+				Requested: item.Quantity,
+			}
+		}
+
+		movement := models.NewStockMovement(models.MovementTypeIssue, item.ProductID, -item.Quantity,
+			models.ReferenceTypeOrder, order.ID, "Order placed", placedBy)
+		if err := recordProductMovement(ctx, tx, movement); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		itemAllocations, err := allocateOrderItem(ctx, tx, item, opts)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		allocations = append(allocations, itemAllocations...)
 	}
 
-	return tx.Commit()
+	history := models.NewOrderStatusHistory(order.ID, "", order.Status, "Order created", placedBy)
+	if err = insertOrderStatusHistory(ctx, tx, history); err != nil {
+		tx.Rollback()
+		log.Printf("Error recording order status history: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return allocations, nil
 }
 
-func (r *OrderRepository) GetOrders() ([]*models.Order, error) {
+func (r *OrderRepository) GetOrders(ctx context.Context) ([]*models.Order, error) {
 	query := `
-		SELECT o.id, o.customer_id, o.total_amount, o.status, o.order_date, c.name 
+		SELECT o.id, o.customer_id, o.currency, o.total_amount, o.exchange_rate, o.base_total_amount,
+		       o.status, o.order_date, o.coupon_id, o.tax_amount, o.prices_include_tax, o.tax_country, o.tax_region,
+		       o.tax_exempt, o.tax_exemption_number, c.name, cp.code
 		FROM orders o
 		LEFT JOIN customers c ON o.customer_id = c.id
+		LEFT JOIN coupons cp ON o.coupon_id = cp.id
 		ORDER BY o.order_date DESC
 	`
-	rows, err := r.DB.Query(query)
+	rows, err := r.DB.QueryContext(ctx, query)
 	if err != nil {
 		log.Printf("Error getting orders: %v", err)
 		return nil, err
@@ -122,15 +260,7 @@ func (r *OrderRepository) GetOrders() ([]*models.Order, error) {
 
 	var orders []*models.Order
 	for rows.Next() {
-		order := &models.Order{}
-		err := rows.Scan(
-			&order.ID,
-			&order.CustomerID,
-			&order.TotalAmount,
-			&order.Status,
-			&order.OrderDate,
-			&order.CustomerName,
-		)
+		order, err := scanOrder(rows)
 		if err != nil {
 			log.Printf("Error scanning order: %v", err)
 			return nil, err
@@ -140,15 +270,79 @@ func (r *OrderRepository) GetOrders() ([]*models.Order, error) {
 	return orders, nil
 }
 
-func (r *OrderRepository) GetOrderItems(orderID string) ([]models.OrderItem, error) {
-	// FIXED: Changed ? to $1
+// GetOrderTotals sums orders placed in the given period per currency, with each
+// currency's total converted into the base currency at the orders' own rates. Either
+// end of the period may be left open.
+func (r *OrderRepository) GetOrderTotals(ctx context.Context, from, to *time.Time) ([]models.OrderCurrencyTotal, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if from != nil {
+		addClause("order_date >= $%d", *from)
+	}
+	if to != nil {
+		addClause("order_date <= $%d", *to)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
 	query := `
-		SELECT oi.id, oi.order_id, oi.product_id, oi.quantity, oi.unit_price, oi.total_price, p.name
+		SELECT currency, COUNT(*), COALESCE(SUM(total_amount), 0), COALESCE(SUM(base_total_amount), 0)
+		FROM orders
+		` + whereClause + `
+		GROUP BY currency
+		ORDER BY currency
+	`
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error getting order totals: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []models.OrderCurrencyTotal{}
+	for rows.Next() {
+		var total models.OrderCurrencyTotal
+		if err := rows.Scan(&total.Currency, &total.Orders, &total.TotalAmount, &total.BaseTotalAmount); err != nil {
+			log.Printf("Error scanning order totals: %v", err)
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
+
+func (r *OrderRepository) GetOrderItems(ctx context.Context, orderID string) ([]models.OrderItem, error) {
+	return orderItems(ctx, r.DB, orderID)
+}
+
+// orderItems loads an order's lines with their discounts, inside a transaction or not.
+// A line's shipped quantity is what its shipped warehouse allocations hold; a line that
+// was never allocated, because no warehouse holds the product, has shipped in full once
+// the order has.
+func orderItems(ctx context.Context, q rowQuerier, orderID string) ([]models.OrderItem, error) {
+	query := `
+		SELECT oi.id, oi.order_id, oi.product_id, oi.quantity, oi.cancelled_quantity, oi.invoiced_quantity,
+		       oi.unit_price, oi.discount_amount, oi.total_price, oi.status, oi.list_price, oi.price_list_id,
+		       oi.price_break_quantity, oi.tax_category, oi.tax_rate_id, oi.tax_rate, oi.tax_amount,
+		       o.prices_include_tax, p.name, pl.name, o.status,
+		       (SELECT COUNT(*) FROM order_allocations a WHERE a.order_item_id = oi.id),
+		       (SELECT COALESCE(SUM(a.quantity), 0) FROM order_allocations a WHERE a.order_item_id = oi.id AND a.status = $2)
 		FROM order_items oi
+		JOIN orders o ON oi.order_id = o.id
 		LEFT JOIN products p ON oi.product_id = p.id
+		LEFT JOIN price_lists pl ON oi.price_list_id = pl.id
 		WHERE oi.order_id = $1
 	`
-	rows, err := r.DB.Query(query, orderID)
+	rows, err := q.QueryContext(ctx, query, orderID, models.AllocationStatusShipped)
 	if err != nil {
 		return nil, err
 	}
@@ -157,19 +351,928 @@ func (r *OrderRepository) GetOrderItems(orderID string) ([]models.OrderItem, err
 	items := []models.OrderItem{}
 	for rows.Next() {
 		var item models.OrderItem
+		var priceListID, taxRateID, productName, priceListName sql.NullString
+		var orderStatus string
+		var allocations int
 		err := rows.Scan(
 			&item.ID,
 			&item.OrderID,
 			&item.ProductID,
 			&item.Quantity,
+			&item.CancelledQuantity,
+			&item.InvoicedQuantity,
 			&item.UnitPrice,
+			&item.DiscountAmount,
 			&item.TotalPrice,
-			&item.ProductName,
+			&item.Status,
+			&item.ListPrice,
+			&priceListID,
+			&item.PriceBreakQuantity,
+			&item.TaxCategory,
+			&taxRateID,
+			&item.TaxRate,
+			&item.TaxAmount,
+			&item.PricesIncludeTax,
+			&productName,
+			&priceListName,
+			&orderStatus,
+			&allocations,
+			&item.ShippedQuantity,
 		)
 		if err != nil {
 			return nil, err
 		}
+		if allocations == 0 && (orderStatus == models.OrderStatusShipped || orderStatus == models.OrderStatusDelivered) {
+			item.ShippedQuantity = item.Quantity - item.CancelledQuantity
+		}
+		if priceListID.Valid {
+			item.PriceListID = &priceListID.String
+		}
+		if taxRateID.Valid {
+			item.TaxRateID = &taxRateID.String
+		}
+		item.ProductName = productName.String
+		item.PriceListName = priceListName.String
 		items = append(items, item)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	discounts, err := orderItemDiscounts(ctx, q, orderID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Discounts = discounts[items[i].ID]
+	}
 	return items, nil
 }
+
+func (r *OrderRepository) GetOrderByID(ctx context.Context, id string) (*models.Order, error) {
+	query := `
+		SELECT o.id, o.customer_id, o.currency, o.total_amount, o.exchange_rate, o.base_total_amount,
+		       o.status, o.order_date, o.coupon_id, o.tax_amount, o.prices_include_tax, o.tax_country, o.tax_region,
+		       o.tax_exempt, o.tax_exemption_number, c.name, cp.code
+		FROM orders o
+		LEFT JOIN customers c ON o.customer_id = c.id
+		LEFT JOIN coupons cp ON o.coupon_id = cp.id
+		WHERE o.id = $1
+	`
+	order, err := scanOrder(r.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting order: %v", err)
+		return nil, err
+	}
+	return order, nil
+}
+
+func scanOrder(row rowScanner) (*models.Order, error) {
+	order := &models.Order{}
+	var couponID, customerName, couponCode sql.NullString
+	err := row.Scan(
+		&order.ID,
+		&order.CustomerID,
+		&order.Currency,
+		&order.TotalAmount,
+		&order.ExchangeRate,
+		&order.BaseTotalAmount,
+		&order.Status,
+		&order.OrderDate,
+		&couponID,
+		&order.TaxAmount,
+		&order.PricesIncludeTax,
+		&order.TaxCountry,
+		&order.TaxRegion,
+		&order.TaxExempt,
+		&order.TaxExemptionNumber,
+		&customerName,
+		&couponCode,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if couponID.Valid {
+		order.CouponID = &couponID.String
+	}
+	order.CustomerName = customerName.String
+	order.CouponCode = couponCode.String
+	return order, nil
+}
+
+// TransitionOrderStatus moves an order to a new status and records the change in
+// order_status_history. The update only applies if the order is still in the status
+// it was read in, so concurrent transitions cannot skip a step.
+func (r *OrderRepository) TransitionOrderStatus(ctx context.Context, orderID, toStatus, reason, changedBy string) (*models.OrderStatusHistory, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var fromStatus string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1`, orderID).Scan(&fromStatus)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading order status: %v", err)
+		return nil, err
+	}
+
+	if !models.CanTransitionOrderStatus(fromStatus, toStatus) {
+		return nil, ErrInvalidStatusTransition
+	}
+
+	// An order on hold may only resume to the status it was held from
+	if fromStatus == models.OrderStatusOnHold && toStatus != models.OrderStatusCancelled {
+		heldFrom, err := heldFromStatus(ctx, tx, orderID)
+		if err != nil {
+			log.Printf("Error reading order hold history: %v", err)
+			return nil, err
+		}
+		if heldFrom != "" && heldFrom != toStatus {
+			return nil, ErrInvalidStatusTransition
+		}
+	}
+
+	// Cancelling gives back the stock of every line that is still open, and the coupon use
+	if toStatus == models.OrderStatusCancelled {
+		if _, err := cancelOrderItems(ctx, tx, orderID, nil, reason, changedBy); err != nil {
+			log.Printf("Error cancelling order items: %v", err)
+			return nil, err
+		}
+		if err := releaseCouponRedemption(ctx, tx, orderID); err != nil {
+			log.Printf("Error releasing coupon redemption: %v", err)
+			return nil, err
+		}
+	}
+
+	// Shipping turns warehouse reservations into deductions
+	if toStatus == models.OrderStatusShipped {
+		if err := shipOrderAllocations(ctx, tx, orderID, changedBy); err != nil {
+			log.Printf("Error shipping order allocations: %v", err)
+			return nil, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE orders SET status = $1 WHERE id = $2 AND status = $3`,
+		toStatus,
+		orderID,
+		fromStatus,
+	)
+	if err != nil {
+		log.Printf("Error updating order status: %v", err)
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, ErrOrderStatusChanged
+	}
+
+	history := models.NewOrderStatusHistory(orderID, fromStatus, toStatus, reason, changedBy)
+	if err := insertOrderStatusHistory(ctx, tx, history); err != nil {
+		log.Printf("Error recording order status history: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (r *OrderRepository) GetOrderStatusHistory(ctx context.Context, orderID string) ([]models.OrderStatusHistory, error) {
+	query := `
+		SELECT id, order_id, from_status, to_status, reason, changed_by, changed_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY changed_at
+	`
+	rows, err := r.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.OrderStatusHistory{}
+	for rows.Next() {
+		var h models.OrderStatusHistory
+		var fromStatus, reason, changedBy sql.NullString
+		err := rows.Scan(
+			&h.ID,
+			&h.OrderID,
+			&fromStatus,
+			&h.ToStatus,
+			&reason,
+			&changedBy,
+			&h.ChangedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		h.FromStatus = fromStatus.String
+		h.Reason = reason.String
+		h.ChangedBy = changedBy.String
+		history = append(history, h)
+	}
+	return history, nil
+}
+
+// CancelOrder cancels the given quantities of an order's lines in a single transaction,
+// putting the stock back and reducing the order total. An empty map cancels every
+// open line, and a zero quantity cancels whatever is left of that line. Once nothing
+// is left open the order itself moves to cancelled and its coupon use is given back.
+func (r *OrderRepository) CancelOrder(ctx context.Context, orderID string, lines map[string]int, reason, cancelledBy string) ([]*models.OrderCancellation, *models.OrderStatusHistory, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1`, orderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrOrderNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading order status: %v", err)
+		return nil, nil, err
+	}
+
+	if !models.CanTransitionOrderStatus(status, models.OrderStatusCancelled) {
+		return nil, nil, ErrInvalidStatusTransition
+	}
+
+	cancellations, err := cancelOrderItems(ctx, tx, orderID, lines, reason, cancelledBy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var openLines int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM order_items WHERE order_id = $1 AND status = $2`,
+		orderID,
+		models.OrderItemStatusActive,
+	).Scan(&openLines)
+	if err != nil {
+		log.Printf("Error counting open order items: %v", err)
+		return nil, nil, err
+	}
+
+	var history *models.OrderStatusHistory
+	if openLines == 0 {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE orders SET status = $1 WHERE id = $2 AND status = $3`,
+			models.OrderStatusCancelled,
+			orderID,
+			status,
+		)
+		if err != nil {
+			log.Printf("Error updating order status: %v", err)
+			return nil, nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return nil, nil, ErrOrderStatusChanged
+		}
+		if err := releaseCouponRedemption(ctx, tx, orderID); err != nil {
+			log.Printf("Error releasing coupon redemption: %v", err)
+			return nil, nil, err
+		}
+
+		history = models.NewOrderStatusHistory(orderID, status, models.OrderStatusCancelled, reason, cancelledBy)
+		if err := insertOrderStatusHistory(ctx, tx, history); err != nil {
+			log.Printf("Error recording order status history: %v", err)
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return cancellations, history, nil
+}
+
+func (r *OrderRepository) GetOrderCancellations(ctx context.Context, orderID string) ([]models.OrderCancellation, error) {
+	query := `
+		SELECT id, order_id, order_item_id, product_id, quantity, amount, reason, cancelled_by, cancelled_at
+		FROM order_cancellations
+		WHERE order_id = $1
+		ORDER BY cancelled_at
+	`
+	rows, err := r.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cancellations := []models.OrderCancellation{}
+	for rows.Next() {
+		var oc models.OrderCancellation
+		var cancelledBy sql.NullString
+		err := rows.Scan(
+			&oc.ID,
+			&oc.OrderID,
+			&oc.OrderItemID,
+			&oc.ProductID,
+			&oc.Quantity,
+			&oc.Amount,
+			&oc.Reason,
+			&cancelledBy,
+			&oc.CancelledAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		oc.CancelledBy = cancelledBy.String
+		cancellations = append(cancellations, oc)
+	}
+	return cancellations, nil
+}
+
+// cancelOrderItems cancels quantities on an order's open lines, restores product stock
+// and records each cancellation. A nil or empty map cancels all open lines in full.
+func cancelOrderItems(ctx context.Context, tx *database.Tx, orderID string, lines map[string]int, reason, cancelledBy string) ([]*models.OrderCancellation, error) {
+	// Lines are repriced in the currency and price mode the order is in
+	var currencyCode string
+	var pricesIncludeTax bool
+	err := tx.QueryRowContext(ctx, `SELECT currency, prices_include_tax FROM orders WHERE id = $1`, orderID).Scan(&currencyCode, &pricesIncludeTax)
+	if err != nil {
+		return nil, err
+	}
+	currency := models.CurrencyOf(currencyCode)
+
+	query := `
+		SELECT id, order_id, product_id, quantity, cancelled_quantity, unit_price, discount_amount, tax_rate, tax_amount,
+		       total_price, status
+		FROM order_items
+		WHERE order_id = $1
+	`
+	rows, err := tx.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := map[string]*models.OrderItem{}
+	var order []string
+	for rows.Next() {
+		item := &models.OrderItem{PricesIncludeTax: pricesIncludeTax}
+		err := rows.Scan(
+			&item.ID,
+			&item.OrderID,
+			&item.ProductID,
+			&item.Quantity,
+			&item.CancelledQuantity,
+			&item.UnitPrice,
+			&item.DiscountAmount,
+			&item.TaxRate,
+			&item.TaxAmount,
+			&item.TotalPrice,
+			&item.Status,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		items[item.ID] = item
+		order = append(order, item.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := map[string]int{}
+	for id, quantity := range lines {
+		pending[id] = quantity
+	}
+	if len(pending) == 0 {
+		for id, item := range items {
+			if item.Status == models.OrderItemStatusActive {
+				pending[id] = 0
+			}
+		}
+	}
+
+	var cancellations []*models.OrderCancellation
+	var cancelledAmount, cancelledTax models.Money
+	for _, id := range order {
+		quantity, ok := pending[id]
+		if !ok {
+			continue
+		}
+		item := items[id]
+
+		remaining := item.Quantity - item.CancelledQuantity
+		if quantity == 0 {
+			quantity = remaining
+		}
+		if item.Status != models.OrderItemStatusActive || quantity <= 0 || quantity > remaining {
+			return nil, fmt.Errorf("%w: order item %s has %d units left to cancel", ErrInvalidCancellation, id, remaining)
+		}
+
+		previousTotal, previousTax := item.TotalPrice, item.TaxAmount
+		item.CancelledQuantity += quantity
+		item.CalculateTotal(currency)
+		if item.CancelledQuantity == item.Quantity {
+			item.Status = models.OrderItemStatusCancelled
+		}
+
+		_, err := tx.ExecContext(ctx,
+			`UPDATE order_items SET cancelled_quantity = $1, total_price = $2, tax_amount = $3, status = $4 WHERE id = $5`,
+			item.CancelledQuantity,
+			item.TotalPrice,
+			item.TaxAmount,
+			item.Status,
+			item.ID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE products SET quantity = quantity + $1 WHERE id = $2`, quantity, item.ProductID)
+		if err != nil {
+			return nil, err
+		}
+
+		movement := models.NewStockMovement(models.MovementTypeReturn, item.ProductID, quantity,
+			models.ReferenceTypeOrder, orderID, reason, cancelledBy)
+		if err := recordProductMovement(ctx, tx, movement); err != nil {
+			return nil, err
+		}
+
+		if err := releaseOrderItemAllocations(ctx, tx, item.ID, quantity); err != nil {
+			return nil, err
+		}
+
+		cancellation := models.NewOrderCancellation(item, quantity, previousTotal-item.TotalPrice, reason, cancelledBy)
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO order_cancellations (id, order_id, order_item_id, product_id, quantity, amount, reason, cancelled_by, cancelled_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			cancellation.ID,
+			cancellation.OrderID,
+			cancellation.OrderItemID,
+			cancellation.ProductID,
+			cancellation.Quantity,
+			cancellation.Amount,
+			cancellation.Reason,
+			cancellation.CancelledBy,
+			cancellation.CancelledAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		cancelledAmount += cancellation.Amount
+		cancelledTax += previousTax - item.TaxAmount
+		cancellations = append(cancellations, cancellation)
+		delete(pending, id)
+	}
+
+	for id := range pending {
+		return nil, fmt.Errorf("%w: order item %s does not belong to this order", ErrInvalidCancellation, id)
+	}
+
+	// The base total follows the new total at the rate the order was placed at
+	o := &models.Order{ID: orderID}
+	err = tx.QueryRowContext(ctx,
+		`UPDATE orders SET total_amount = total_amount - $1, tax_amount = tax_amount - $2 WHERE id = $3 `+
+			tx.Dialect.Returning("total_amount", "exchange_rate"),
+		cancelledAmount,
+		cancelledTax,
+		orderID,
+	).Scan(&o.TotalAmount, &o.ExchangeRate)
+	if err != nil {
+		return nil, err
+	}
+	o.CalculateBaseTotal()
+	_, err = tx.ExecContext(ctx, `UPDATE orders SET base_total_amount = $1 WHERE id = $2`, o.BaseTotalAmount, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return cancellations, nil
+}
+
+func (r *OrderRepository) GetOrderAllocations(ctx context.Context, orderID string) ([]models.OrderAllocation, error) {
+	query := `
+		SELECT oa.id, oa.order_id, oa.order_item_id, oa.product_id, oa.warehouse_id, oa.inventory_id,
+		       oa.location_id, oa.quantity, oa.status, oa.created_at, oa.updated_at,
+		       w.name, wl.location_code
+		FROM order_allocations oa
+		JOIN warehouses w ON oa.warehouse_id = w.id
+		LEFT JOIN warehouse_locations wl ON oa.location_id = wl.id
+		WHERE oa.order_id = $1
+		ORDER BY oa.created_at
+	`
+	rows, err := r.DB.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allocations := []models.OrderAllocation{}
+	for rows.Next() {
+		var a models.OrderAllocation
+		var locationID, locationCode sql.NullString
+		err := rows.Scan(
+			&a.ID,
+			&a.OrderID,
+			&a.OrderItemID,
+			&a.ProductID,
+			&a.WarehouseID,
+			&a.InventoryID,
+			&locationID,
+			&a.Quantity,
+			&a.Status,
+			&a.CreatedAt,
+			&a.UpdatedAt,
+			&a.WarehouseName,
+			&locationCode,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if locationID.Valid {
+			a.LocationID = &locationID.String
+		}
+		a.LocationCode = locationCode.String
+		allocations = append(allocations, a)
+	}
+	return allocations, nil
+}
+
+// warehouseStock is the free stock of one product in one warehouse
+type warehouseStock struct {
+	warehouse models.Warehouse
+	available int
+	rows      []inventoryStock
+}
+
+type inventoryStock struct {
+	inventoryID string
+	locationID  *string
+	available   int
+}
+
+// allocateOrderItem reserves an order line against warehouse inventory. The preferred
+// warehouse is always tried first; after that the single strategy ranks warehouses by
+// free stock, nearest by distance to the ship-to point, and split by distance when a
+// ship-to point is known and by free stock otherwise.
+func allocateOrderItem(ctx context.Context, tx *database.Tx, item *models.OrderItem, opts models.AllocationOptions) ([]*models.OrderAllocation, error) {
+	query := `
+		SELECT i.id, i.warehouse_id, i.location_id, i.quantity - COALESCE(i.reserved_quantity, 0),
+		       w.name, w.status, w.latitude, w.longitude
+		FROM inventory i
+		JOIN warehouses w ON i.warehouse_id = w.id
+		WHERE i.product_id = $1
+		ORDER BY i.quantity - COALESCE(i.reserved_quantity, 0) DESC
+	`
+	rows, err := tx.QueryContext(ctx, query, item.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := false
+	byWarehouse := map[string]*warehouseStock{}
+	var stocks []*warehouseStock
+	for rows.Next() {
+		var row inventoryStock
+		var warehouse models.Warehouse
+		var locationID sql.NullString
+		var latitude, longitude sql.NullFloat64
+		err := rows.Scan(
+			&row.inventoryID,
+			&warehouse.ID,
+			&locationID,
+			&row.available,
+			&warehouse.Name,
+			&warehouse.Status,
+			&latitude,
+			&longitude,
+		)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tracked = true
+
+		if warehouse.Status != "active" || row.available <= 0 {
+			continue
+		}
+		if locationID.Valid {
+			row.locationID = &locationID.String
+		}
+		setWarehouseCoordinates(&warehouse, latitude, longitude)
+
+		stock, ok := byWarehouse[warehouse.ID]
+		if !ok {
+			stock = &warehouseStock{warehouse: warehouse}
+			byWarehouse[warehouse.ID] = stock
+			stocks = append(stocks, stock)
+		}
+		stock.available += row.available
+		stock.rows = append(stock.rows, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Products that are not stocked in any warehouse are only tracked on products.quantity
+	if !tracked {
+		return nil, nil
+	}
+
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = models.AllocationStrategySplit
+	}
+	useDistance := strategy != models.AllocationStrategySingle && opts.Latitude != nil && opts.Longitude != nil
+
+	sort.SliceStable(stocks, func(i, j int) bool {
+		a, b := stocks[i], stocks[j]
+		if opts.WarehouseID != "" && (a.warehouse.ID == opts.WarehouseID) != (b.warehouse.ID == opts.WarehouseID) {
+			return a.warehouse.ID == opts.WarehouseID
+		}
+		if useDistance {
+			distA, okA := a.warehouse.DistanceTo(*opts.Latitude, *opts.Longitude)
+			distB, okB := b.warehouse.DistanceTo(*opts.Latitude, *opts.Longitude)
+			if okA != okB {
+				return okA
+			}
+			if okA && distA != distB {
+				return distA < distB
+			}
+		}
+		return a.available > b.available
+	})
+
+	insufficient := &InsufficientStockError{
+		ProductID: item.ProductID,
+		Requested: item.Quantity,
+		Strategy:  strategy,
+	}
+
+	if strategy == models.AllocationStrategySplit {
+		var allocations []*models.OrderAllocation
+		remaining := item.Quantity
+		for _, stock := range stocks {
+			if remaining == 0 {
+				break
+			}
+			quantity := min(remaining, stock.available)
+			reserved, err := reserveWarehouseStock(ctx, tx, item, stock, quantity, strategy)
+			if err != nil {
+				return nil, err
+			}
+			allocations = append(allocations, reserved...)
+			remaining -= quantity
+			insufficient.Available += quantity
+		}
+		if remaining > 0 {
+			return nil, insufficient
+		}
+		return allocations, nil
+	}
+
+	for _, stock := range stocks {
+		if stock.available >= item.Quantity {
+			return reserveWarehouseStock(ctx, tx, item, stock, item.Quantity, strategy)
+		}
+		insufficient.Available = max(insufficient.Available, stock.available)
+	}
+	return nil, insufficient
+}
+
+// reserveWarehouseStock reserves quantity from a warehouse's inventory rows, fullest row
+// first. A row that lost stock since it was read fails the reservation as a shortfall
+// of the allocation strategy.
+func reserveWarehouseStock(ctx context.Context, tx *database.Tx, item *models.OrderItem, stock *warehouseStock, quantity int, strategy string) ([]*models.OrderAllocation, error) {
+	query := `
+		UPDATE inventory SET reserved_quantity = COALESCE(reserved_quantity, 0) + $1, updated_at = $2
+		WHERE id = $3 AND quantity - COALESCE(reserved_quantity, 0) >= $1
+	`
+
+	var allocations []*models.OrderAllocation
+	for _, row := range stock.rows {
+		if quantity == 0 {
+			break
+		}
+		take := min(quantity, row.available)
+
+		result, err := tx.ExecContext(ctx, query, take, time.Now(), row.inventoryID)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return nil, &InsufficientStockError{
+				ProductID: item.ProductID,
+				Available: stock.available,
+				Requested: item.Quantity,
+				Strategy:  strategy,
+			}
+		}
+
+		allocation := models.NewOrderAllocation(item, stock.warehouse.ID, row.inventoryID, row.locationID, take)
+		allocation.WarehouseName = stock.warehouse.Name
+		if err := insertOrderAllocation(ctx, tx, allocation); err != nil {
+			return nil, err
+		}
+
+		allocations = append(allocations, allocation)
+		quantity -= take
+	}
+	return allocations, nil
+}
+
+// releaseOrderItemAllocations gives back reserved warehouse stock for a cancelled
+// quantity of an order line, newest reservation first
+func releaseOrderItemAllocations(ctx context.Context, tx *database.Tx, orderItemID string, quantity int) error {
+	allocations, err := reservedAllocations(ctx, tx, "order_item_id", orderItemID)
+	if err != nil {
+		return err
+	}
+
+	for i := len(allocations) - 1; i >= 0 && quantity > 0; i-- {
+		allocation := allocations[i]
+		release := min(quantity, allocation.Quantity)
+		now := time.Now()
+
+		_, err := tx.ExecContext(ctx,
+			`UPDATE inventory SET reserved_quantity = reserved_quantity - $1, updated_at = $2 WHERE id = $3`,
+			release,
+			now,
+			allocation.InventoryID,
+		)
+		if err != nil {
+			return err
+		}
+
+		status := models.AllocationStatusReserved
+		if release == allocation.Quantity {
+			status = models.AllocationStatusReleased
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE order_allocations SET quantity = $1, status = $2, updated_at = $3 WHERE id = $4`,
+			allocation.Quantity-release,
+			status,
+			now,
+			allocation.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		quantity -= release
+	}
+	return nil
+}
+
+// shipOrderAllocations deducts every reserved allocation of an order from inventory and
+// from the location it was picked from
+func shipOrderAllocations(ctx context.Context, tx *database.Tx, orderID, shippedBy string) error {
+	allocations, err := reservedAllocations(ctx, tx, "order_id", orderID)
+	if err != nil {
+		return err
+	}
+
+	for _, allocation := range allocations {
+		now := time.Now()
+
+		_, err := tx.ExecContext(ctx,
+			`UPDATE inventory SET quantity = quantity - $1, reserved_quantity = reserved_quantity - $1, updated_at = $2 WHERE id = $3`,
+			allocation.Quantity,
+			now,
+			allocation.InventoryID,
+		)
+		if err != nil {
+			return err
+		}
+
+		movement := models.NewStockMovement(models.MovementTypeIssue, allocation.ProductID, -allocation.Quantity,
+			models.ReferenceTypeOrder, orderID, "Order shipped", shippedBy)
+		if err := recordInventoryMovement(ctx, tx, allocation.InventoryID, movement); err != nil {
+			return err
+		}
+
+		if allocation.LocationID != nil {
+			_, err = tx.ExecContext(ctx,
+				`UPDATE warehouse_locations SET current_quantity = current_quantity - $1, updated_at = $2 WHERE id = $3`,
+				allocation.Quantity,
+				now,
+				*allocation.LocationID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE order_allocations SET status = $1, updated_at = $2 WHERE id = $3`,
+			models.AllocationStatusShipped,
+			now,
+			allocation.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reservedAllocations returns the reserved allocations whose column (order_id or
+// order_item_id) matches id
+func reservedAllocations(ctx context.Context, tx *database.Tx, column, id string) ([]models.OrderAllocation, error) {
+	query := `
+		SELECT id, inventory_id, location_id, quantity
+		FROM order_allocations
+		WHERE ` + column + ` = $1 AND status = $2
+		ORDER BY created_at
+	`
+	rows, err := tx.QueryContext(ctx, query, id, models.AllocationStatusReserved)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allocations []models.OrderAllocation
+	for rows.Next() {
+		var a models.OrderAllocation
+		var locationID sql.NullString
+		if err := rows.Scan(&a.ID, &a.InventoryID, &locationID, &a.Quantity); err != nil {
+			return nil, err
+		}
+		if locationID.Valid {
+			a.LocationID = &locationID.String
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
+
+func insertOrderAllocation(ctx context.Context, tx *database.Tx, allocation *models.OrderAllocation) error {
+	query := `
+		INSERT INTO order_allocations (id, order_id, order_item_id, product_id, warehouse_id, inventory_id,
+		                               location_id, quantity, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	_, err := tx.ExecContext(ctx,
+		query,
+		allocation.ID,
+		allocation.OrderID,
+		allocation.OrderItemID,
+		allocation.ProductID,
+		allocation.WarehouseID,
+		allocation.InventoryID,
+		allocation.LocationID,
+		allocation.Quantity,
+		allocation.Status,
+		allocation.CreatedAt,
+		allocation.UpdatedAt,
+	)
+	return err
+}
+
+// heldFromStatus returns the status an order was in when it was last put on hold
+func heldFromStatus(ctx context.Context, tx *database.Tx, orderID string) (string, error) {
+	query := `
+		SELECT from_status FROM order_status_history
+		WHERE order_id = $1 AND to_status = $2
+		ORDER BY changed_at DESC
+		LIMIT 1
+	`
+	var fromStatus sql.NullString
+	err := tx.QueryRowContext(ctx, query, orderID, models.OrderStatusOnHold).Scan(&fromStatus)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fromStatus.String, nil
+}
+
+func insertOrderStatusHistory(ctx context.Context, tx *database.Tx, history *models.OrderStatusHistory) error {
+	query := `
+		INSERT INTO order_status_history (id, order_id, from_status, to_status, reason, changed_by, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := tx.ExecContext(ctx,
+		query,
+		history.ID,
+		history.OrderID,
+		history.FromStatus,
+		history.ToStatus,
+		history.Reason,
+		history.ChangedBy,
+		history.ChangedAt,
+	)
+	return err
+}
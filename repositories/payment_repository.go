@@ -0,0 +1,630 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"erp-project/utils"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrInvalidPaymentStatus = errors.New("payment is not in a valid status for this action")
+	ErrInvalidPayment       = errors.New("invalid payment")
+)
+
+// paymentColumns are the columns scanPayment reads, in order, from payments p joined to
+// customers c
+const paymentColumns = `p.id, p.customer_id, p.status, p.method, p.reference, p.currency, p.exchange_rate, p.amount,
+	p.base_amount, p.amount_allocated, p.payment_date, p.notes, p.void_reason, p.created_by, p.voided_by, p.voided_at,
+	p.created_at, p.updated_at, c.name`
+
+// PaymentFilter narrows down payment lists. Empty fields are ignored.
+type PaymentFilter struct {
+	CustomerID  string
+	Status      string
+	Method      string
+	Currency    string
+	From        *time.Time // Payment date
+	To          *time.Time
+	Unallocated bool // Only payments with something left to allocate
+}
+
+type PaymentRepository struct {
+	DB *database.Conn
+}
+
+func NewPaymentRepository(db *database.Conn) *PaymentRepository {
+	return &PaymentRepository{DB: db}
+}
+
+// CreatePayment records a payment and allocates it to the customer's invoices in the
+// same transaction. allocations maps invoice IDs to amounts; with autoAllocate and no
+// allocations the payment settles open invoices oldest due first. Whatever is not
+// allocated stays on the payment as credit.
+func (r *PaymentRepository) CreatePayment(ctx context.Context, payment *models.Payment, allocations map[string]models.Money, autoAllocate bool) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO payments (id, customer_id, status, method, reference, currency, exchange_rate, amount, base_amount,
+		                      amount_allocated, payment_date, notes, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	`
+	_, err = tx.ExecContext(ctx,
+		query,
+		payment.ID,
+		payment.CustomerID,
+		payment.Status,
+		payment.Method,
+		payment.Reference,
+		payment.Currency,
+		payment.ExchangeRate,
+		payment.Amount,
+		payment.BaseAmount,
+		payment.AmountAllocated,
+		payment.PaymentDate,
+		payment.Notes,
+		payment.CreatedBy,
+		payment.CreatedAt,
+		payment.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating payment: %v", err)
+		return err
+	}
+
+	if _, err := allocatePayment(ctx, tx, payment, allocations, autoAllocate, payment.CreatedBy); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AllocatePayment allocates what is left of a payment to the customer's invoices, the
+// same way CreatePayment does
+func (r *PaymentRepository) AllocatePayment(ctx context.Context, id string, allocations map[string]models.Money, autoAllocate bool, allocatedBy string) (*models.Payment, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payment, err := getPayment(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != models.PaymentStatusReceived {
+		return nil, fmt.Errorf("%w: cannot allocate a %s payment", ErrInvalidPaymentStatus, payment.Status)
+	}
+	if payment.Unallocated <= 0 {
+		return nil, fmt.Errorf("%w: the payment is already fully allocated", ErrInvalidPayment)
+	}
+
+	allocated, err := allocatePayment(ctx, tx, payment, allocations, autoAllocate, allocatedBy)
+	if err != nil {
+		return nil, err
+	}
+	if allocated == 0 {
+		return nil, fmt.Errorf("%w: the customer has no open invoices in %s", ErrInvalidPayment, payment.Currency)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetPaymentByID(ctx, id)
+}
+
+// VoidPayment cancels a payment, say one that bounced. The invoices it was allocated to
+// are owed again.
+func (r *PaymentRepository) VoidPayment(ctx context.Context, id, reason, voidedBy string) (*models.Payment, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payment, err := getPayment(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != models.PaymentStatusReceived {
+		return nil, fmt.Errorf("%w: the payment is already %s", ErrInvalidPaymentStatus, payment.Status)
+	}
+
+	for _, allocation := range payment.Allocations {
+		if err := settleInvoice(ctx, tx, allocation.InvoiceID, 0, -allocation.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE payments SET status = $1, void_reason = $2, voided_by = $3, voided_at = $4, updated_at = $5
+		 WHERE id = $6 AND status = $7`,
+		models.PaymentStatusVoid,
+		reason,
+		voidedBy,
+		now,
+		now,
+		id,
+		models.PaymentStatusReceived,
+	)
+	if err != nil {
+		log.Printf("Error voiding payment: %v", err)
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, fmt.Errorf("%w: the payment was changed by another request", ErrInvalidPaymentStatus)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetPaymentByID(ctx, id)
+}
+
+// GetPayments lists payments without their allocations, newest first
+func (r *PaymentRepository) GetPayments(ctx context.Context, filter PaymentFilter, page, pageSize int) ([]models.Payment, int, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.CustomerID != "" {
+		addClause("p.customer_id = $%d", filter.CustomerID)
+	}
+	if filter.Status != "" {
+		addClause("p.status = $%d", filter.Status)
+	}
+	if filter.Method != "" {
+		addClause("p.method = $%d", filter.Method)
+	}
+	if filter.Currency != "" {
+		addClause("p.currency = $%d", strings.ToUpper(filter.Currency))
+	}
+	if filter.From != nil {
+		addClause("p.payment_date >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addClause("p.payment_date <= $%d", *filter.To)
+	}
+	if filter.Unallocated {
+		addClause("p.status = $%d AND p.amount > p.amount_allocated", models.PaymentStatusReceived)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	var total int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM payments p "+whereClause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s
+		FROM payments p
+		LEFT JOIN customers c ON p.customer_id = c.id
+		%s
+		ORDER BY p.payment_date DESC, p.created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, paymentColumns, whereClause, len(args)+1, len(args)+2)
+	args = append(args, pageSize, utils.CalculateOffset(page, pageSize))
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	payments := []models.Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			log.Printf("Error scanning payment: %v", err)
+			return nil, 0, err
+		}
+		payments = append(payments, *payment)
+	}
+	return payments, total, rows.Err()
+}
+
+// GetPaymentByID returns a payment with its allocations
+func (r *PaymentRepository) GetPaymentByID(ctx context.Context, id string) (*models.Payment, error) {
+	payment, err := getPayment(ctx, r.DB, id)
+	if errors.Is(err, ErrPaymentNotFound) {
+		return nil, nil
+	}
+	return payment, err
+}
+
+// GetInvoiceAllocations lists the payments that settled an invoice, leaving out void ones
+func (r *PaymentRepository) GetInvoiceAllocations(ctx context.Context, invoiceID string) ([]models.PaymentAllocation, error) {
+	query := `
+		SELECT a.id, a.payment_id, a.invoice_id, a.amount, a.created_by, a.created_at, i.invoice_number
+		FROM payment_allocations a
+		JOIN payments p ON a.payment_id = p.id
+		LEFT JOIN invoices i ON a.invoice_id = i.id
+		WHERE a.invoice_id = $1 AND p.status <> $2
+		ORDER BY a.created_at
+	`
+	rows, err := r.DB.QueryContext(ctx, query, invoiceID, models.PaymentStatusVoid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPaymentAllocations(rows)
+}
+
+// GetCustomerBalance sums up a customer's issued invoices and payments in each currency
+// they have dealt in. Amounts are overdue when they were due before on.
+func (r *PaymentRepository) GetCustomerBalance(ctx context.Context, customerID string, on time.Time) ([]models.CustomerBalance, error) {
+	balances := map[string]*models.CustomerBalance{}
+	balance := func(currency string) *models.CustomerBalance {
+		if balances[currency] == nil {
+			balances[currency] = &models.CustomerBalance{Currency: currency}
+		}
+		return balances[currency]
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT currency, total_amount, amount_credited, amount_paid, due_date
+		FROM invoices
+		WHERE customer_id = $1 AND type = $2 AND status IN ($3, $4, $5)
+	`, customerID, models.InvoiceTypeInvoice, models.InvoiceStatusIssued, models.InvoiceStatusPartiallyPaid, models.InvoiceStatusPaid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var total, credited, paid models.Money
+		var dueDate sql.NullTime
+		if err := rows.Scan(&currency, &total, &credited, &paid, &dueDate); err != nil {
+			return nil, err
+		}
+		b := balance(currency)
+		b.InvoicedAmount += total
+		b.CreditedAmount += credited
+		b.PaidAmount += paid
+		if due := total - credited - paid; due > 0 && dueDate.Valid && models.DaysPastDue(dueDate.Time, on) > 0 {
+			b.OverdueAmount += due
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.DB.QueryContext(ctx, `
+		SELECT currency, amount - amount_allocated
+		FROM payments
+		WHERE customer_id = $1 AND status = $2 AND amount > amount_allocated
+	`, customerID, models.PaymentStatusReceived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var currency string
+		var unallocated models.Money
+		if err := rows.Scan(&currency, &unallocated); err != nil {
+			return nil, err
+		}
+		balance(currency).UnallocatedAmount += unallocated
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]models.CustomerBalance, 0, len(balances))
+	for _, b := range balances {
+		b.CalculateBalance()
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result, nil
+}
+
+// GetReceivablesAging ages what every customer owes as of a day, in the base currency at
+// each invoice's rate. Invoices are aged by how long they are past due; unallocated
+// payments and overpaid invoices are the customer's credit.
+func (r *PaymentRepository) GetReceivablesAging(ctx context.Context, on time.Time) ([]models.ReceivablesAgingLine, error) {
+	lines := map[string]*models.ReceivablesAgingLine{}
+	line := func(customerID string, customerName sql.NullString) *models.ReceivablesAgingLine {
+		if lines[customerID] == nil {
+			lines[customerID] = &models.ReceivablesAgingLine{CustomerID: customerID, CustomerName: customerName.String}
+		}
+		return lines[customerID]
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT i.customer_id, c.name, i.due_date, i.exchange_rate, i.total_amount - i.amount_credited - i.amount_paid
+		FROM invoices i
+		LEFT JOIN customers c ON i.customer_id = c.id
+		WHERE i.type = $1 AND i.status IN ($2, $3, $4) AND i.total_amount <> i.amount_credited + i.amount_paid
+	`, models.InvoiceTypeInvoice, models.InvoiceStatusIssued, models.InvoiceStatusPartiallyPaid, models.InvoiceStatusPaid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var customerID string
+		var customerName sql.NullString
+		var dueDate sql.NullTime
+		var rate models.Rate
+		var due models.Money
+		if err := rows.Scan(&customerID, &customerName, &dueDate, &rate, &due); err != nil {
+			return nil, err
+		}
+		l := line(customerID, customerName)
+		base := models.BaseCurrency.Round(rate.ToBase(due))
+		if base < 0 {
+			l.UnallocatedCredit -= base
+			continue
+		}
+		if !dueDate.Valid {
+			dueDate.Time = on
+		}
+		l.Add(dueDate.Time, on, base)
+		l.Invoices++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.DB.QueryContext(ctx, `
+		SELECT p.customer_id, c.name, p.exchange_rate, p.amount - p.amount_allocated
+		FROM payments p
+		LEFT JOIN customers c ON p.customer_id = c.id
+		WHERE p.status = $1 AND p.amount > p.amount_allocated
+	`, models.PaymentStatusReceived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var customerID string
+		var customerName sql.NullString
+		var rate models.Rate
+		var unallocated models.Money
+		if err := rows.Scan(&customerID, &customerName, &rate, &unallocated); err != nil {
+			return nil, err
+		}
+		line(customerID, customerName).UnallocatedCredit += models.BaseCurrency.Round(rate.ToBase(unallocated))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]models.ReceivablesAgingLine, 0, len(lines))
+	for _, l := range lines {
+		l.Balance = l.Total - l.UnallocatedCredit
+		result = append(result, *l)
+	}
+	models.SortReceivablesAging(result)
+	return result, nil
+}
+
+// allocatePayment allocates part of a payment to open invoices of its customer in its
+// currency, and returns how much it allocated. Each invoice can take at most its balance
+// due, and together no more than the payment has left.
+func allocatePayment(ctx context.Context, tx *database.Tx, payment *models.Payment, allocations map[string]models.Money, autoAllocate bool, allocatedBy string) (models.Money, error) {
+	if len(allocations) == 0 && !autoAllocate {
+		return 0, nil
+	}
+
+	invoices, err := openInvoices(ctx, tx, payment.CustomerID, payment.Currency)
+	if err != nil {
+		return 0, err
+	}
+	if len(allocations) == 0 {
+		allocations = models.AutoAllocate(payment.Unallocated, invoices)
+	}
+
+	currency := models.CurrencyOf(payment.Currency)
+	open := map[string]bool{}
+	var allocated models.Money
+	for _, invoice := range invoices {
+		open[invoice.ID] = true
+		amount, ok := allocations[invoice.ID]
+		if !ok {
+			continue
+		}
+		amount = currency.Round(amount)
+		if amount <= 0 {
+			return 0, fmt.Errorf("%w: allocated amounts must be positive", ErrInvalidPayment)
+		}
+		if amount > invoice.BalanceDue {
+			return 0, fmt.Errorf("%w: invoice %s has %s left to pay", ErrInvalidPayment, invoice.InvoiceNumber, invoice.BalanceDue)
+		}
+		allocated += amount
+
+		allocation := models.NewPaymentAllocation(payment.ID, invoice.ID, amount, allocatedBy)
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO payment_allocations (id, payment_id, invoice_id, amount, created_by, created_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+			allocation.ID,
+			allocation.PaymentID,
+			allocation.InvoiceID,
+			allocation.Amount,
+			allocation.CreatedBy,
+			allocation.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Error creating payment allocation: %v", err)
+			return 0, err
+		}
+		if err := settleInvoice(ctx, tx, invoice.ID, 0, amount); err != nil {
+			return 0, err
+		}
+	}
+	for id := range allocations {
+		if !open[id] {
+			return 0, fmt.Errorf("%w: invoice %s is not an open invoice of this customer in %s", ErrInvalidPayment, id, payment.Currency)
+		}
+	}
+	if allocated > payment.Unallocated {
+		return 0, fmt.Errorf("%w: allocations total %s but only %s of the payment is unallocated", ErrInvalidPayment, allocated, payment.Unallocated)
+	}
+	if allocated == 0 {
+		return 0, nil
+	}
+
+	// Only allocate while nobody else has allocated any of the payment since it was read
+	result, err := tx.ExecContext(ctx,
+		`UPDATE payments SET amount_allocated = amount_allocated + $1, updated_at = $2
+		 WHERE id = $3 AND amount_allocated = $4 AND status = $5`,
+		allocated,
+		time.Now(),
+		payment.ID,
+		payment.AmountAllocated,
+		models.PaymentStatusReceived,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return 0, fmt.Errorf("%w: the payment was allocated by another request", ErrInvalidPayment)
+	}
+	payment.AmountAllocated += allocated
+	payment.CalculateUnallocated()
+	return allocated, nil
+}
+
+// openInvoices lists a customer's issued invoices in a currency that still have
+// something to pay, oldest due first
+func openInvoices(ctx context.Context, q rowQuerier, customerID, currency string) ([]models.Invoice, error) {
+	query := `SELECT ` + invoiceColumns + invoiceJoins + `
+		WHERE i.customer_id = $1 AND i.currency = $2 AND i.type = $3 AND i.status IN ($4, $5)
+		  AND i.total_amount > i.amount_credited + i.amount_paid
+		ORDER BY i.due_date, i.invoice_number`
+	rows, err := q.QueryContext(ctx, query, customerID, currency, models.InvoiceTypeInvoice,
+		models.InvoiceStatusIssued, models.InvoiceStatusPartiallyPaid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []models.Invoice{}
+	for rows.Next() {
+		invoice, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, *invoice)
+	}
+	return invoices, rows.Err()
+}
+
+// getPayment loads a payment with its allocations, or ErrPaymentNotFound
+func getPayment(ctx context.Context, q invoiceQuerier, id string) (*models.Payment, error) {
+	query := `SELECT ` + paymentColumns + `
+		FROM payments p
+		LEFT JOIN customers c ON p.customer_id = c.id
+		WHERE p.id = $1`
+	payment, err := scanPayment(q.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrPaymentNotFound
+	}
+	if err != nil {
+		log.Printf("Error getting payment: %v", err)
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT a.id, a.payment_id, a.invoice_id, a.amount, a.created_by, a.created_at, i.invoice_number
+		FROM payment_allocations a
+		LEFT JOIN invoices i ON a.invoice_id = i.id
+		WHERE a.payment_id = $1
+		ORDER BY a.created_at
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	payment.Allocations, err = scanPaymentAllocations(rows)
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+func scanPayment(row rowScanner) (*models.Payment, error) {
+	payment := &models.Payment{}
+	var notes, voidReason, createdBy, voidedBy, customerName sql.NullString
+	var voidedAt sql.NullTime
+	err := row.Scan(
+		&payment.ID,
+		&payment.CustomerID,
+		&payment.Status,
+		&payment.Method,
+		&payment.Reference,
+		&payment.Currency,
+		&payment.ExchangeRate,
+		&payment.Amount,
+		&payment.BaseAmount,
+		&payment.AmountAllocated,
+		&payment.PaymentDate,
+		&notes,
+		&voidReason,
+		&createdBy,
+		&voidedBy,
+		&voidedAt,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+		&customerName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if voidedAt.Valid {
+		payment.VoidedAt = &voidedAt.Time
+	}
+	payment.Notes = notes.String
+	payment.VoidReason = voidReason.String
+	payment.CreatedBy = createdBy.String
+	payment.VoidedBy = voidedBy.String
+	payment.CustomerName = customerName.String
+	payment.CalculateUnallocated()
+	return payment, nil
+}
+
+func scanPaymentAllocations(rows *sql.Rows) ([]models.PaymentAllocation, error) {
+	allocations := []models.PaymentAllocation{}
+	for rows.Next() {
+		var allocation models.PaymentAllocation
+		var createdBy, invoiceNumber sql.NullString
+		err := rows.Scan(
+			&allocation.ID,
+			&allocation.PaymentID,
+			&allocation.InvoiceID,
+			&allocation.Amount,
+			&createdBy,
+			&allocation.CreatedAt,
+			&invoiceNumber,
+		)
+		if err != nil {
+			return nil, err
+		}
+		allocation.CreatedBy = createdBy.String
+		allocation.InvoiceNumber = invoiceNumber.String
+		allocations = append(allocations, allocation)
+	}
+	return allocations, rows.Err()
+}
@@ -0,0 +1,320 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// PriceListFilter narrows down price lists. Empty fields are ignored.
+type PriceListFilter struct {
+	CustomerID    string
+	CustomerGroup string
+	ValidOn       *time.Time // Only active lists valid on this day
+}
+
+type PriceListRepository struct {
+	DB *database.Conn
+}
+
+func NewPriceListRepository(db *database.Conn) *PriceListRepository {
+	return &PriceListRepository{DB: db}
+}
+
+// CreatePriceList saves a price list and its items in one transaction
+func (r *PriceListRepository) CreatePriceList(ctx context.Context, list *models.PriceList) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO price_lists (id, name, customer_id, customer_group, currency, valid_from, valid_to, active,
+		                         created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	_, err = tx.ExecContext(ctx,
+		query,
+		list.ID,
+		list.Name,
+		list.CustomerID,
+		list.CustomerGroup,
+		list.Currency,
+		list.ValidFrom,
+		list.ValidTo,
+		list.Active,
+		list.CreatedBy,
+		list.CreatedAt,
+		list.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating price list: %v", err)
+		return err
+	}
+
+	if err := insertPriceListItems(ctx, tx, list.Items); err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePriceList saves a price list's name, validity and active flag and replaces its
+// items, in one transaction. Who the list is for and its currency do not change.
+func (r *PriceListRepository) UpdatePriceList(ctx context.Context, list *models.PriceList) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	list.UpdatedAt = time.Now()
+	_, err = tx.ExecContext(ctx,
+		`UPDATE price_lists SET name = $1, valid_from = $2, valid_to = $3, active = $4, updated_at = $5 WHERE id = $6`,
+		list.Name,
+		list.ValidFrom,
+		list.ValidTo,
+		list.Active,
+		list.UpdatedAt,
+		list.ID,
+	)
+	if err != nil {
+		log.Printf("Error updating price list: %v", err)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM price_list_items WHERE price_list_id = $1`, list.ID); err != nil {
+		log.Printf("Error clearing price list items: %v", err)
+		return err
+	}
+	if err := insertPriceListItems(ctx, tx, list.Items); err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertPriceListItems(ctx context.Context, tx *database.Tx, items []models.PriceListItem) error {
+	query := `
+		INSERT INTO price_list_items (id, price_list_id, product_id, min_quantity, unit_price)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	for _, item := range items {
+		_, err := tx.ExecContext(ctx,
+			query,
+			item.ID,
+			item.PriceListID,
+			item.ProductID,
+			item.MinQuantity,
+			item.UnitPrice,
+		)
+		if err != nil {
+			log.Printf("Error creating price list item: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPriceLists lists price lists without their items
+func (r *PriceListRepository) GetPriceLists(ctx context.Context, filter PriceListFilter) ([]models.PriceList, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.CustomerID != "" {
+		addClause("pl.customer_id = $%d", filter.CustomerID)
+	}
+	if filter.CustomerGroup != "" {
+		addClause("pl.customer_group = $%d", filter.CustomerGroup)
+	}
+	if filter.ValidOn != nil {
+		day := models.RateDate(*filter.ValidOn)
+		addClause("pl.active = $%d", true)
+		addClause("pl.valid_from <= $%d", day)
+		addClause("(pl.valid_to IS NULL OR pl.valid_to >= $%d)", day)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	query := `
+		SELECT pl.id, pl.name, pl.customer_id, pl.customer_group, pl.currency, pl.valid_from, pl.valid_to,
+		       pl.active, pl.created_by, pl.created_at, pl.updated_at, c.name
+		FROM price_lists pl
+		LEFT JOIN customers c ON pl.customer_id = c.id
+		` + whereClause + `
+		ORDER BY pl.name, pl.valid_from DESC
+	`
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []models.PriceList{}
+	for rows.Next() {
+		list, err := scanPriceList(rows)
+		if err != nil {
+			log.Printf("Error scanning price list: %v", err)
+			return nil, err
+		}
+		lists = append(lists, *list)
+	}
+	return lists, rows.Err()
+}
+
+func (r *PriceListRepository) GetPriceListByID(ctx context.Context, id string) (*models.PriceList, error) {
+	query := `
+		SELECT pl.id, pl.name, pl.customer_id, pl.customer_group, pl.currency, pl.valid_from, pl.valid_to,
+		       pl.active, pl.created_by, pl.created_at, pl.updated_at, c.name
+		FROM price_lists pl
+		LEFT JOIN customers c ON pl.customer_id = c.id
+		WHERE pl.id = $1
+	`
+	list, err := scanPriceList(r.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting price list: %v", err)
+		return nil, err
+	}
+
+	list.Items, err = priceListItems(ctx, r.DB, id)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetApplicablePriceLists returns the active lists, with their items, that may price an
+// order for a customer on the given day: the customer's own lists and its group's
+func (r *PriceListRepository) GetApplicablePriceLists(ctx context.Context, customerID, customerGroup string, on time.Time) ([]models.PriceList, error) {
+	day := models.RateDate(on)
+	query := `
+		SELECT pl.id, pl.name, pl.customer_id, pl.customer_group, pl.currency, pl.valid_from, pl.valid_to,
+		       pl.active, pl.created_by, pl.created_at, pl.updated_at, c.name
+		FROM price_lists pl
+		LEFT JOIN customers c ON pl.customer_id = c.id
+		WHERE pl.active = $1 AND pl.valid_from <= $2 AND (pl.valid_to IS NULL OR pl.valid_to >= $2)
+		  AND (pl.customer_id = $3 OR (pl.customer_id IS NULL AND pl.customer_group <> '' AND pl.customer_group = $4))
+	`
+	rows, err := r.DB.QueryContext(ctx, query, true, day, customerID, customerGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	lists := []models.PriceList{}
+	for rows.Next() {
+		list, err := scanPriceList(rows)
+		if err != nil {
+			rows.Close()
+			log.Printf("Error scanning price list: %v", err)
+			return nil, err
+		}
+		lists = append(lists, *list)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range lists {
+		lists[i].Items, err = priceListItems(ctx, r.DB, lists[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	models.SortPriceLists(lists)
+	return lists, nil
+}
+
+func priceListItems(ctx context.Context, q rowQuerier, priceListID string) ([]models.PriceListItem, error) {
+	query := `
+		SELECT pi.id, pi.price_list_id, pi.product_id, pi.min_quantity, pi.unit_price, p.name, p.sku
+		FROM price_list_items pi
+		LEFT JOIN products p ON pi.product_id = p.id
+		WHERE pi.price_list_id = $1
+		ORDER BY p.name, pi.min_quantity
+	`
+	rows, err := q.QueryContext(ctx, query, priceListID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.PriceListItem{}
+	for rows.Next() {
+		var item models.PriceListItem
+		var productName, sku sql.NullString
+		err := rows.Scan(
+			&item.ID,
+			&item.PriceListID,
+			&item.ProductID,
+			&item.MinQuantity,
+			&item.UnitPrice,
+			&productName,
+			&sku,
+		)
+		if err != nil {
+			return nil, err
+		}
+		item.ProductName = productName.String
+		item.SKU = sku.String
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func scanPriceList(row rowScanner) (*models.PriceList, error) {
+	list := &models.PriceList{}
+	var customerID, createdBy, customerName sql.NullString
+	var validTo sql.NullTime
+	err := row.Scan(
+		&list.ID,
+		&list.Name,
+		&customerID,
+		&list.CustomerGroup,
+		&list.Currency,
+		&list.ValidFrom,
+		&validTo,
+		&list.Active,
+		&createdBy,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+		&customerName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if customerID.Valid {
+		list.CustomerID = &customerID.String
+	}
+	if validTo.Valid {
+		list.ValidTo = &validTo.Time
+	}
+	list.CreatedBy = createdBy.String
+	list.CustomerName = customerName.String
+	return list, nil
+}
@@ -1,7 +1,9 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"erp-project/database"
 	"erp-project/models"
 	"erp-project/utils"
 	"fmt"
@@ -11,28 +13,37 @@ import (
 )
 
 type ProductRepository struct {
-	DB *sql.DB
+	DB *database.Conn
 }
 
-func NewProductRepository(db *sql.DB) *ProductRepository {
+func NewProductRepository(db *database.Conn) *ProductRepository {
 	return &ProductRepository{DB: db}
 }
 
 // Create product - FIXED: Changed ? to $1, $2, etc.
-func (r *ProductRepository) CreateProduct(product *models.Product) error {
+func (r *ProductRepository) CreateProduct(ctx context.Context, product *models.Product) error {
 	query := `
-		INSERT INTO products (id, name, description, sku, price, quantity, category, created_at, updated_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO products (id, name, description, sku, price, currency, quantity, category, tax_category, created_at, updated_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 	`
-	_, err := r.DB.Exec(
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
 		query,
 		product.ID,
 		product.Name,
 		product.Description,
 		product.SKU,
 		product.Price,
+		product.Currency,
 		product.Quantity,
 		product.Category,
+		product.TaxCategory,
 		product.CreatedAt,
 		product.UpdatedAt,
 	)
@@ -41,12 +52,25 @@ func (r *ProductRepository) CreateProduct(product *models.Product) error {
 		log.Printf("Error creating product: %v", err)
 		return err
 	}
-	return nil
+
+	// Opening stock is the first entry in the product's ledger
+	if product.Quantity > 0 {
+		movement := models.NewStockMovement(models.MovementTypeReceipt, product.ID, product.Quantity,
+			models.ReferenceTypeProduct, product.ID, "Opening stock", "")
+		if err := recordProductMovement(ctx, tx, movement); err != nil {
+			return err
+		}
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *ProductRepository) GetAll() ([]models.Product, error) {
-	query := `SELECT id, name, description, sku, price, quantity, category, created_at, updated_at FROM products`
-	rows, err := r.DB.Query(query)
+func (r *ProductRepository) GetAll(ctx context.Context) ([]models.Product, error) {
+	query := `SELECT id, name, description, sku, price, currency, quantity, category, tax_category, created_at, updated_at FROM products`
+	rows, err := r.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -61,8 +85,10 @@ func (r *ProductRepository) GetAll() ([]models.Product, error) {
 			&p.Description,
 			&p.SKU,
 			&p.Price,
+			&p.Currency,
 			&p.Quantity,
 			&p.Category,
+			&p.TaxCategory,
 			&p.CreatedAt,
 			&p.UpdatedAt,
 		)
@@ -77,18 +103,21 @@ func (r *ProductRepository) GetAll() ([]models.Product, error) {
 }
 
 // Get products with pagination - FIXED: Parameter placeholders
-func (r *ProductRepository) GetProductsWithPagination(page, pageSize int, search, category string) ([]models.Product, int, error) {
+func (r *ProductRepository) GetProductsWithPagination(ctx context.Context, page, pageSize int, search, category string) ([]models.Product, int, error) {
 	// Build WHERE clause with PostgreSQL placeholders
 	var whereClauses []string
 	var args []interface{}
 	argCounter := 1
 
 	if search != "" {
-		whereClauses = append(whereClauses, fmt.Sprintf("(name LIKE $%d OR description LIKE $%d OR sku LIKE $%d)",
-			argCounter, argCounter+1, argCounter+2))
-		searchTerm := "%" + search + "%"
-		args = append(args, searchTerm, searchTerm, searchTerm)
-		argCounter += 3
+		// Case-insensitive on both dialects
+		placeholder := fmt.Sprintf("$%d", argCounter)
+		whereClauses = append(whereClauses, fmt.Sprintf("(%s OR %s OR %s)",
+			r.DB.Dialect.Like("name", placeholder),
+			r.DB.Dialect.Like("description", placeholder),
+			r.DB.Dialect.Like("sku", placeholder)))
+		args = append(args, "%"+search+"%")
+		argCounter++
 	}
 
 	if category != "" {
@@ -109,7 +138,7 @@ func (r *ProductRepository) GetProductsWithPagination(page, pageSize int, search
 	}
 
 	var total int
-	err := r.DB.QueryRow(countQuery, args...).Scan(&total)
+	err := r.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -117,7 +146,7 @@ func (r *ProductRepository) GetProductsWithPagination(page, pageSize int, search
 	// Get paginated data
 	offset := utils.CalculateOffset(page, pageSize)
 	query := fmt.Sprintf(`
-		SELECT id, name, description, sku, price, quantity, category, created_at, updated_at
+		SELECT id, name, description, sku, price, currency, quantity, category, tax_category, created_at, updated_at
 		FROM products %s
 		ORDER BY created_at DESC
 		LIMIT $%d OFFSET $%d
@@ -126,7 +155,7 @@ func (r *ProductRepository) GetProductsWithPagination(page, pageSize int, search
 	// Add pagination parameters
 	args = append(args, pageSize, offset)
 
-	rows, err := r.DB.Query(query, args...)
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -141,8 +170,10 @@ func (r *ProductRepository) GetProductsWithPagination(page, pageSize int, search
 			&p.Description,
 			&p.SKU,
 			&p.Price,
+			&p.Currency,
 			&p.Quantity,
 			&p.Category,
+			&p.TaxCategory,
 			&p.CreatedAt,
 			&p.UpdatedAt,
 		)
@@ -157,15 +188,15 @@ func (r *ProductRepository) GetProductsWithPagination(page, pageSize int, search
 }
 
 // Get all Products
-func (r *ProductRepository) GetAllProducts() ([]models.Product, error) {
-	products, _, err := r.GetProductsWithPagination(1, 1000, "", "")
+func (r *ProductRepository) GetAllProducts(ctx context.Context) ([]models.Product, error) {
+	products, _, err := r.GetProductsWithPagination(ctx, 1, 1000, "", "")
 	return products, err
 }
 
 // Get product by ID - FIXED: Changed ? to $1
-func (r *ProductRepository) GetProductByID(id string) (*models.Product, error) {
-	query := `SELECT id, name, description, sku, price, quantity, category, created_at, updated_at FROM products WHERE id = $1`
-	row := r.DB.QueryRow(query, id)
+func (r *ProductRepository) GetProductByID(ctx context.Context, id string) (*models.Product, error) {
+	query := `SELECT id, name, description, sku, price, currency, quantity, category, tax_category, created_at, updated_at FROM products WHERE id = $1`
+	row := r.DB.QueryRowContext(ctx, query, id)
 	product := &models.Product{}
 	err := row.Scan(
 		&product.ID,
@@ -173,11 +204,16 @@ func (r *ProductRepository) GetProductByID(id string) (*models.Product, error) {
 		&product.Description,
 		&product.SKU,
 		&product.Price,
+		&product.Currency,
 		&product.Quantity,
 		&product.Category,
+		&product.TaxCategory,
 		&product.CreatedAt,
 		&product.UpdatedAt,
 	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		log.Printf("Error scanning product: %v", err)
 		return nil, err
@@ -186,23 +222,35 @@ func (r *ProductRepository) GetProductByID(id string) (*models.Product, error) {
 }
 
 // Update product - FIXED: Changed ? to $1, $2, etc.
-func (r *ProductRepository) UpdateProduct(product *models.Product) error {
+// The product's quantity is not written as it is; quantityChange is added to the
+// stock on hand instead, so orders placed since the product was read are not undone.
+// A change that would take the stock below zero fails with an InsufficientStockError.
+// On success product.Quantity holds the resulting stock.
+func (r *ProductRepository) UpdateProduct(ctx context.Context, product *models.Product, quantityChange int, updatedBy string) error {
 	query := `
 		UPDATE products 
-		SET name = $1, description = $2, sku = $3, price = $4, quantity = $5, category = $6, updated_at = $7 
-		WHERE id = $8
+		SET name = $1, description = $2, sku = $3, price = $4, currency = $5, category = $6, tax_category = $7, updated_at = $8 
+		WHERE id = $9
 	`
 	// Update timestamp
 	product.UpdatedAt = time.Now()
 
-	_, err := r.DB.Exec(
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
 		query,
 		product.Name,
 		product.Description,
 		product.SKU,
 		product.Price,
-		product.Quantity,
+		product.Currency,
 		product.Category,
+		product.TaxCategory,
 		product.UpdatedAt,
 		product.ID,
 	)
@@ -210,27 +258,94 @@ func (r *ProductRepository) UpdateProduct(product *models.Product) error {
 		log.Printf("Error updating product: %v", err)
 		return err
 	}
-	return nil
+
+	if quantityChange != 0 {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE products SET quantity = quantity + $1 WHERE id = $2 AND quantity + $1 >= 0`,
+			quantityChange, product.ID,
+		)
+		if err != nil {
+			log.Printf("Error updating product quantity: %v", err)
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			var available int
+			if err := tx.QueryRowContext(ctx, `SELECT quantity FROM products WHERE id = $1`, product.ID).Scan(&available); err != nil {
+				return err
+			}
+			return &InsufficientStockError{ProductID: product.ID, Available: available, Requested: -quantityChange}
+		}
+
+		movement := models.NewStockMovement(models.MovementTypeAdjustment, product.ID, quantityChange,
+			models.ReferenceTypeProduct, product.ID, "Product quantity updated", updatedBy)
+		if err := recordProductMovement(ctx, tx, movement); err != nil {
+			return err
+		}
+		product.Quantity = movement.QuantityAfter
+	} else if err := tx.QueryRowContext(ctx, `SELECT quantity FROM products WHERE id = $1`, product.ID).Scan(&product.Quantity); err != nil {
+		log.Printf("Error reading product quantity: %v", err)
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // Delete product - FIXED: Changed ? to $1
-func (r *ProductRepository) DeleteProduct(id string) error {
+func (r *ProductRepository) DeleteProduct(ctx context.Context, id string) error {
 	query := `DELETE FROM products WHERE id = $1`
-	_, err := r.DB.Exec(query, id)
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, id)
 	if err != nil {
 		log.Printf("Error deleting product: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // Update product quantity - FIXED: PostgreSQL CURRENT_TIMESTAMP syntax
-func (r *ProductRepository) UpdateProductQuantity(id string, quantity int) error {
-	query := `UPDATE products SET quantity = $1, updated_at = NOW() WHERE id = $2`
-	_, err := r.DB.Exec(query, quantity, id)
+func (r *ProductRepository) UpdateProductQuantity(ctx context.Context, id string, quantity int) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var previousQuantity int
+	if err := tx.QueryRowContext(ctx, `SELECT quantity FROM products WHERE id = $1`, id).Scan(&previousQuantity); err != nil {
+		log.Printf("Error reading product quantity: %v", err)
+		return err
+	}
+
+	query := `UPDATE products SET quantity = $1, updated_at = ` + r.DB.Dialect.Now() + ` WHERE id = $2`
+	_, err = tx.ExecContext(ctx, query, quantity, id)
 	if err != nil {
 		log.Printf("Error updating product quantity: %v", err)
 		return err
 	}
-	return nil
+
+	if change := quantity - previousQuantity; change != 0 {
+		movement := models.NewStockMovement(models.MovementTypeAdjustment, id, change,
+			models.ReferenceTypeProduct, id, "Product quantity updated", "")
+		if err := recordProductMovement(ctx, tx, movement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
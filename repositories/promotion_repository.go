@@ -0,0 +1,467 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrCouponUnavailable is returned when an order redeems a coupon that has been
+// switched off or has reached one of its usage limits
+var ErrCouponUnavailable = errors.New("coupon is not available")
+
+// PromotionFilter narrows down promotions. Empty fields are ignored.
+type PromotionFilter struct {
+	ActiveAt       *time.Time // Only promotions switched on and running at this time
+	RequiresCoupon *bool
+}
+
+type PromotionRepository struct {
+	DB *database.Conn
+}
+
+func NewPromotionRepository(db *database.Conn) *PromotionRepository {
+	return &PromotionRepository{DB: db}
+}
+
+func (r *PromotionRepository) CreatePromotion(ctx context.Context, promotion *models.Promotion) error {
+	query := `
+		INSERT INTO promotions (id, name, description, type, scope, value, currency, buy_quantity, get_quantity,
+		                        min_order_amount, product_id, category, customer_id, customer_group, starts_at, ends_at,
+		                        requires_coupon, active, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+	`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		query,
+		promotion.ID,
+		promotion.Name,
+		promotion.Description,
+		promotion.Type,
+		promotion.Scope,
+		promotion.Value,
+		promotion.Currency,
+		promotion.BuyQuantity,
+		promotion.GetQuantity,
+		promotion.MinOrderAmount,
+		promotion.ProductID,
+		promotion.Category,
+		promotion.CustomerID,
+		promotion.CustomerGroup,
+		promotion.StartsAt,
+		promotion.EndsAt,
+		promotion.RequiresCoupon,
+		promotion.Active,
+		promotion.CreatedBy,
+		promotion.CreatedAt,
+		promotion.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating promotion: %v", err)
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePromotion saves a promotion's name, description, window and active flag. The
+// discount itself does not change once orders may have used it.
+func (r *PromotionRepository) UpdatePromotion(ctx context.Context, promotion *models.Promotion) error {
+	promotion.UpdatedAt = time.Now()
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE promotions SET name = $1, description = $2, starts_at = $3, ends_at = $4, active = $5, updated_at = $6 WHERE id = $7`,
+		promotion.Name,
+		promotion.Description,
+		promotion.StartsAt,
+		promotion.EndsAt,
+		promotion.Active,
+		promotion.UpdatedAt,
+		promotion.ID,
+	)
+	if err != nil {
+		log.Printf("Error updating promotion: %v", err)
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PromotionRepository) GetPromotions(ctx context.Context, filter PromotionFilter) ([]models.Promotion, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.ActiveAt != nil {
+		addClause("active = $%d", true)
+		addClause("starts_at <= $%d", *filter.ActiveAt)
+		addClause("(ends_at IS NULL OR ends_at >= $%d)", *filter.ActiveAt)
+	}
+	if filter.RequiresCoupon != nil {
+		addClause("requires_coupon = $%d", *filter.RequiresCoupon)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	query := `
+		SELECT id, name, description, type, scope, value, currency, buy_quantity, get_quantity, min_order_amount,
+		       product_id, category, customer_id, customer_group, starts_at, ends_at, requires_coupon, active,
+		       created_by, created_at, updated_at
+		FROM promotions
+		` + whereClause + `
+		ORDER BY starts_at DESC, name
+	`
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := []models.Promotion{}
+	for rows.Next() {
+		promotion, err := scanPromotion(rows)
+		if err != nil {
+			log.Printf("Error scanning promotion: %v", err)
+			return nil, err
+		}
+		promotions = append(promotions, *promotion)
+	}
+	return promotions, rows.Err()
+}
+
+func (r *PromotionRepository) GetPromotionByID(ctx context.Context, id string) (*models.Promotion, error) {
+	query := `
+		SELECT id, name, description, type, scope, value, currency, buy_quantity, get_quantity, min_order_amount,
+		       product_id, category, customer_id, customer_group, starts_at, ends_at, requires_coupon, active,
+		       created_by, created_at, updated_at
+		FROM promotions
+		WHERE id = $1
+	`
+	promotion, err := scanPromotion(r.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting promotion: %v", err)
+		return nil, err
+	}
+	return promotion, nil
+}
+
+func (r *PromotionRepository) CreateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	query := `
+		INSERT INTO coupons (id, code, promotion_id, usage_limit, per_customer_limit, times_used, active, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		query,
+		coupon.ID,
+		coupon.Code,
+		coupon.PromotionID,
+		coupon.UsageLimit,
+		coupon.PerCustomerLimit,
+		coupon.TimesUsed,
+		coupon.Active,
+		coupon.CreatedBy,
+		coupon.CreatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating coupon: %v", err)
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PromotionRepository) GetCoupons(ctx context.Context, promotionID string) ([]models.Coupon, error) {
+	query := `
+		SELECT cp.id, cp.code, cp.promotion_id, cp.usage_limit, cp.per_customer_limit, cp.times_used, cp.active,
+		       cp.created_by, cp.created_at, p.name
+		FROM coupons cp
+		JOIN promotions p ON cp.promotion_id = p.id
+		WHERE cp.promotion_id = $1
+		ORDER BY cp.code
+	`
+	rows, err := r.DB.QueryContext(ctx, query, promotionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	coupons := []models.Coupon{}
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			log.Printf("Error scanning coupon: %v", err)
+			return nil, err
+		}
+		coupons = append(coupons, *coupon)
+	}
+	return coupons, rows.Err()
+}
+
+// GetCouponByCode finds a coupon by its code, ignoring case. It returns nil when there
+// is no such code.
+func (r *PromotionRepository) GetCouponByCode(ctx context.Context, code string) (*models.Coupon, error) {
+	query := `
+		SELECT cp.id, cp.code, cp.promotion_id, cp.usage_limit, cp.per_customer_limit, cp.times_used, cp.active,
+		       cp.created_by, cp.created_at, p.name
+		FROM coupons cp
+		JOIN promotions p ON cp.promotion_id = p.id
+		WHERE cp.code = $1
+	`
+	coupon, err := scanCoupon(r.DB.QueryRowContext(ctx, query, models.NormalizeCouponCode(code)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting coupon: %v", err)
+		return nil, err
+	}
+	return coupon, nil
+}
+
+// redeemCoupon uses up one use of a coupon for an order, inside the transaction that
+// places it. Taking the use first locks the coupon row, so concurrent orders see each
+// other's redemptions and cannot go past either limit.
+func redeemCoupon(ctx context.Context, tx *database.Tx, couponID, orderID, customerID string) error {
+	result, err := tx.ExecContext(ctx,
+		`UPDATE coupons SET times_used = times_used + 1
+		 WHERE id = $1 AND active = $2 AND (usage_limit = 0 OR times_used < usage_limit)`,
+		couponID,
+		true,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: it is inactive or its usage limit has been reached", ErrCouponUnavailable)
+	}
+
+	var perCustomerLimit, used int
+	if err := tx.QueryRowContext(ctx, `SELECT per_customer_limit FROM coupons WHERE id = $1`, couponID).Scan(&perCustomerLimit); err != nil {
+		return err
+	}
+	if perCustomerLimit > 0 {
+		err := tx.QueryRowContext(ctx,
+			`SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = $1 AND customer_id = $2`,
+			couponID,
+			customerID,
+		).Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used >= perCustomerLimit {
+			return fmt.Errorf("%w: the customer has used it the %d times allowed", ErrCouponUnavailable, perCustomerLimit)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO coupon_redemptions (id, coupon_id, order_id, customer_id, redeemed_at) VALUES ($1, $2, $3, $4, $5)`,
+		uuid.New().String(),
+		couponID,
+		orderID,
+		customerID,
+		time.Now(),
+	)
+	return err
+}
+
+// releaseCouponRedemption gives back the coupon use of an order that has been cancelled
+// in full, inside the cancelling transaction, so an order that never happened does not
+// count against either limit
+func releaseCouponRedemption(ctx context.Context, tx *database.Tx, orderID string) error {
+	var couponID string
+	err := tx.QueryRowContext(ctx, `SELECT coupon_id FROM coupon_redemptions WHERE order_id = $1`, orderID).Scan(&couponID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM coupon_redemptions WHERE order_id = $1`, orderID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE coupons SET times_used = times_used - 1 WHERE id = $1 AND times_used > 0`, couponID)
+	return err
+}
+
+func insertOrderItemDiscounts(ctx context.Context, tx *database.Tx, item *models.OrderItem) error {
+	query := `
+		INSERT INTO order_item_discounts (id, order_id, order_item_id, promotion_id, coupon_id, description, scope, amount)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	for i := range item.Discounts {
+		discount := &item.Discounts[i]
+		discount.OrderID = item.OrderID
+		discount.OrderItemID = item.ID
+		_, err := tx.ExecContext(ctx,
+			query,
+			discount.ID,
+			discount.OrderID,
+			discount.OrderItemID,
+			discount.PromotionID,
+			discount.CouponID,
+			discount.Description,
+			discount.Scope,
+			discount.Amount,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderItemDiscounts returns an order's discounts grouped by order item ID
+func orderItemDiscounts(ctx context.Context, q rowQuerier, orderID string) (map[string][]models.OrderItemDiscount, error) {
+	query := `
+		SELECT id, order_id, order_item_id, promotion_id, coupon_id, description, scope, amount
+		FROM order_item_discounts
+		WHERE order_id = $1
+		ORDER BY scope, description
+	`
+	rows, err := q.QueryContext(ctx, query, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	discounts := map[string][]models.OrderItemDiscount{}
+	for rows.Next() {
+		var discount models.OrderItemDiscount
+		var couponID sql.NullString
+		err := rows.Scan(
+			&discount.ID,
+			&discount.OrderID,
+			&discount.OrderItemID,
+			&discount.PromotionID,
+			&couponID,
+			&discount.Description,
+			&discount.Scope,
+			&discount.Amount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if couponID.Valid {
+			discount.CouponID = &couponID.String
+		}
+		discounts[discount.OrderItemID] = append(discounts[discount.OrderItemID], discount)
+	}
+	return discounts, rows.Err()
+}
+
+func scanPromotion(row rowScanner) (*models.Promotion, error) {
+	promotion := &models.Promotion{}
+	var description, productID, customerID, createdBy sql.NullString
+	var endsAt sql.NullTime
+	err := row.Scan(
+		&promotion.ID,
+		&promotion.Name,
+		&description,
+		&promotion.Type,
+		&promotion.Scope,
+		&promotion.Value,
+		&promotion.Currency,
+		&promotion.BuyQuantity,
+		&promotion.GetQuantity,
+		&promotion.MinOrderAmount,
+		&productID,
+		&promotion.Category,
+		&customerID,
+		&promotion.CustomerGroup,
+		&promotion.StartsAt,
+		&endsAt,
+		&promotion.RequiresCoupon,
+		&promotion.Active,
+		&createdBy,
+		&promotion.CreatedAt,
+		&promotion.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if productID.Valid {
+		promotion.ProductID = &productID.String
+	}
+	if customerID.Valid {
+		promotion.CustomerID = &customerID.String
+	}
+	if endsAt.Valid {
+		promotion.EndsAt = &endsAt.Time
+	}
+	promotion.Description = description.String
+	promotion.CreatedBy = createdBy.String
+	return promotion, nil
+}
+
+func scanCoupon(row rowScanner) (*models.Coupon, error) {
+	coupon := &models.Coupon{}
+	var createdBy sql.NullString
+	err := row.Scan(
+		&coupon.ID,
+		&coupon.Code,
+		&coupon.PromotionID,
+		&coupon.UsageLimit,
+		&coupon.PerCustomerLimit,
+		&coupon.TimesUsed,
+		&coupon.Active,
+		&createdBy,
+		&coupon.CreatedAt,
+		&coupon.PromotionName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	coupon.CreatedBy = createdBy.String
+	return coupon, nil
+}
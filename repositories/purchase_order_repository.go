@@ -0,0 +1,600 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrPurchaseOrderNotFound       = errors.New("purchase order not found")
+	ErrInvalidPurchaseOrderStatus  = errors.New("invalid purchase order status transition")
+	ErrInvalidPurchaseOrderReceipt = errors.New("invalid purchase order receipt")
+)
+
+type PurchaseOrderRepository struct {
+	DB *database.Conn
+}
+
+func NewPurchaseOrderRepository(db *database.Conn) *PurchaseOrderRepository {
+	return &PurchaseOrderRepository{DB: db}
+}
+
+func (r *PurchaseOrderRepository) CreatePurchaseOrder(ctx context.Context, po *models.PurchaseOrder) error {
+	return r.CreatePurchaseOrders(ctx, []*models.PurchaseOrder{po})
+}
+
+// CreatePurchaseOrders saves several purchase orders with their lines in one
+// transaction, so either all of them are created or none
+func (r *PurchaseOrderRepository) CreatePurchaseOrders(ctx context.Context, purchaseOrders []*models.PurchaseOrder) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, po := range purchaseOrders {
+		if err := insertPurchaseOrder(ctx, tx, po); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertPurchaseOrder(ctx context.Context, tx *database.Tx, po *models.PurchaseOrder) error {
+	query := `
+		INSERT INTO purchase_orders (id, po_number, supplier_id, warehouse_id, status, order_date, expected_date,
+		                             currency, total_amount, notes, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`
+	_, err := tx.ExecContext(ctx,
+		query,
+		po.ID,
+		po.PONumber,
+		po.SupplierID,
+		po.WarehouseID,
+		po.Status,
+		po.OrderDate,
+		po.ExpectedDate,
+		po.Currency,
+		po.TotalAmount,
+		po.Notes,
+		po.CreatedBy,
+		po.CreatedAt,
+		po.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating purchase order: %v", err)
+		return err
+	}
+
+	lineQuery := `
+		INSERT INTO purchase_order_lines (id, purchase_order_id, product_id, supplier_sku, quantity, received_quantity,
+		                                  unit_cost, total_cost, lead_time_days, expected_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	for _, line := range po.Lines {
+		_, err = tx.ExecContext(ctx,
+			lineQuery,
+			line.ID,
+			line.PurchaseOrderID,
+			line.ProductID,
+			line.SupplierSKU,
+			line.Quantity,
+			line.ReceivedQuantity,
+			line.UnitCost,
+			line.TotalCost,
+			line.LeadTimeDays,
+			line.ExpectedDate,
+		)
+		if err != nil {
+			log.Printf("Error creating purchase order line: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPurchaseOrders lists purchase orders, optionally filtered by status and supplier
+func (r *PurchaseOrderRepository) GetPurchaseOrders(ctx context.Context, status, supplierID string) ([]models.PurchaseOrder, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	if status != "" {
+		args = append(args, status)
+		whereClauses = append(whereClauses, fmt.Sprintf("po.status = $%d", len(args)))
+	}
+	if supplierID != "" {
+		args = append(args, supplierID)
+		whereClauses = append(whereClauses, fmt.Sprintf("po.supplier_id = $%d", len(args)))
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	query := `
+		SELECT po.id, po.po_number, po.supplier_id, po.warehouse_id, po.status, po.order_date, po.expected_date,
+		       po.currency, po.total_amount, po.notes, po.created_by, po.approved_by, po.approved_at, po.sent_at,
+		       po.created_at, po.updated_at, s.name, w.name
+		FROM purchase_orders po
+		JOIN suppliers s ON po.supplier_id = s.id
+		LEFT JOIN warehouses w ON po.warehouse_id = w.id
+		` + whereClause + `
+		ORDER BY po.order_date DESC
+	`
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchaseOrders := []models.PurchaseOrder{}
+	for rows.Next() {
+		po, err := scanPurchaseOrder(rows)
+		if err != nil {
+			log.Printf("Error scanning purchase order: %v", err)
+			return nil, err
+		}
+		purchaseOrders = append(purchaseOrders, *po)
+	}
+	return purchaseOrders, rows.Err()
+}
+
+func (r *PurchaseOrderRepository) GetPurchaseOrderByID(ctx context.Context, id string) (*models.PurchaseOrder, error) {
+	query := `
+		SELECT po.id, po.po_number, po.supplier_id, po.warehouse_id, po.status, po.order_date, po.expected_date,
+		       po.currency, po.total_amount, po.notes, po.created_by, po.approved_by, po.approved_at, po.sent_at,
+		       po.created_at, po.updated_at, s.name, w.name
+		FROM purchase_orders po
+		JOIN suppliers s ON po.supplier_id = s.id
+		LEFT JOIN warehouses w ON po.warehouse_id = w.id
+		WHERE po.id = $1
+	`
+	po, err := scanPurchaseOrder(r.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting purchase order: %v", err)
+		return nil, err
+	}
+
+	po.Lines, err = purchaseOrderLines(ctx, r.DB, id)
+	if err != nil {
+		return nil, err
+	}
+	return po, nil
+}
+
+// ApprovePurchaseOrder approves a draft purchase order
+func (r *PurchaseOrderRepository) ApprovePurchaseOrder(ctx context.Context, id, approvedBy string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := transitionPurchaseOrder(ctx, tx, id, models.PurchaseOrderStatusApproved); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		`UPDATE purchase_orders SET approved_by = $1, approved_at = $2, updated_at = $3 WHERE id = $4`,
+		approvedBy,
+		now,
+		now,
+		id,
+	)
+	if err != nil {
+		log.Printf("Error approving purchase order: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SendPurchaseOrder marks an approved purchase order as sent to the supplier. Expected
+// dates are recalculated from the send date using each line's lead time.
+func (r *PurchaseOrderRepository) SendPurchaseOrder(ctx context.Context, id string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := transitionPurchaseOrder(ctx, tx, id, models.PurchaseOrderStatusSent); err != nil {
+		return err
+	}
+
+	po := &models.PurchaseOrder{ID: id}
+	po.Lines, err = purchaseOrderLines(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	po.ScheduleFrom(now)
+	for _, line := range po.Lines {
+		_, err := tx.ExecContext(ctx, `UPDATE purchase_order_lines SET expected_date = $1 WHERE id = $2`, line.ExpectedDate, line.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE purchase_orders SET sent_at = $1, expected_date = $2, updated_at = $3 WHERE id = $4`,
+		now,
+		po.ExpectedDate,
+		now,
+		id,
+	)
+	if err != nil {
+		log.Printf("Error sending purchase order: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelPurchaseOrder cancels a purchase order that has not received anything yet
+func (r *PurchaseOrderRepository) CancelPurchaseOrder(ctx context.Context, id string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := transitionPurchaseOrder(ctx, tx, id, models.PurchaseOrderStatusCancelled); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReceivePurchaseOrder books goods received against a sent purchase order into the
+// given warehouse. Each receipt is put away at its location, added to the product's
+// stock and written to the ledger. Receiving more than is outstanding on a line is
+// rejected unless allowOverReceipt is set. The order becomes partially_received, or
+// received once every line has been received in full.
+func (r *PurchaseOrderRepository) ReceivePurchaseOrder(ctx context.Context, id, warehouseID string, receipts []models.PurchaseOrderReceipt, allowOverReceipt bool) ([]*models.PurchaseOrderReceipt, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var poWarehouseID sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT status, warehouse_id FROM purchase_orders WHERE id = $1`, id).Scan(&status, &poWarehouseID)
+	if err == sql.ErrNoRows {
+		return nil, ErrPurchaseOrderNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading purchase order: %v", err)
+		return nil, err
+	}
+	if status != models.PurchaseOrderStatusSent && status != models.PurchaseOrderStatusPartiallyReceived {
+		return nil, fmt.Errorf("%w: cannot receive against a %s purchase order", ErrInvalidPurchaseOrderStatus, status)
+	}
+
+	if warehouseID == "" {
+		warehouseID = poWarehouseID.String
+	}
+	if warehouseID == "" {
+		return nil, fmt.Errorf("%w: purchase order has no ship-to warehouse", ErrInvalidPurchaseOrderReceipt)
+	}
+
+	lines, err := purchaseOrderLines(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]*models.PurchaseOrderLine{}
+	for i := range lines {
+		byID[lines[i].ID] = &lines[i]
+	}
+
+	var recorded []*models.PurchaseOrderReceipt
+	for _, input := range receipts {
+		line, ok := byID[input.PurchaseOrderLineID]
+		if !ok {
+			return nil, fmt.Errorf("%w: line %s does not belong to this purchase order", ErrInvalidPurchaseOrderReceipt, input.PurchaseOrderLineID)
+		}
+		if input.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: quantity must be positive", ErrInvalidPurchaseOrderReceipt)
+		}
+		if !allowOverReceipt && input.Quantity > line.Outstanding() {
+			return nil, fmt.Errorf("%w: line %s has %d units outstanding", ErrInvalidPurchaseOrderReceipt, line.ID, line.Outstanding())
+		}
+
+		receipt := models.NewPurchaseOrderReceipt(line, warehouseID, input.LocationID, input.Quantity, input.Notes, input.ReceivedBy)
+
+		movement := models.NewStockMovement(models.MovementTypeReceipt, line.ProductID, 0,
+			models.ReferenceTypePurchaseOrder, id, "Purchase order received", receipt.ReceivedBy)
+		inventoryID, err := receiveInventoryStock(ctx, tx, line.ProductID, warehouseID, receipt.LocationID, receipt.Quantity, movement)
+		if err != nil {
+			return nil, err
+		}
+		receipt.InventoryID = &inventoryID
+
+		_, err = tx.ExecContext(ctx, `UPDATE products SET quantity = quantity + $1 WHERE id = $2`, receipt.Quantity, line.ProductID)
+		if err != nil {
+			return nil, err
+		}
+		productMovement := models.NewStockMovement(models.MovementTypeReceipt, line.ProductID, receipt.Quantity,
+			models.ReferenceTypePurchaseOrder, id, "Purchase order received", receipt.ReceivedBy)
+		if err := recordProductMovement(ctx, tx, productMovement); err != nil {
+			return nil, err
+		}
+
+		// Without over-receipt, only book the receipt while the line still has that much outstanding
+		lineQuery := `UPDATE purchase_order_lines SET received_quantity = received_quantity + $1 WHERE id = $2`
+		if !allowOverReceipt {
+			lineQuery += ` AND quantity - received_quantity >= $1`
+		}
+		result, err := tx.ExecContext(ctx, lineQuery, receipt.Quantity, line.ID)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return nil, fmt.Errorf("%w: line %s was received by another request", ErrInvalidPurchaseOrderReceipt, line.ID)
+		}
+		line.ReceivedQuantity += receipt.Quantity
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO purchase_order_receipts (id, purchase_order_id, purchase_order_line_id, product_id, warehouse_id,
+			                                      location_id, inventory_id, quantity, notes, received_by, received_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			receipt.ID,
+			receipt.PurchaseOrderID,
+			receipt.PurchaseOrderLineID,
+			receipt.ProductID,
+			receipt.WarehouseID,
+			receipt.LocationID,
+			receipt.InventoryID,
+			receipt.Quantity,
+			receipt.Notes,
+			receipt.ReceivedBy,
+			receipt.ReceivedAt,
+		)
+		if err != nil {
+			log.Printf("Error recording purchase order receipt: %v", err)
+			return nil, err
+		}
+		recorded = append(recorded, receipt)
+	}
+
+	newStatus := models.PurchaseOrderStatusReceived
+	for _, line := range lines {
+		if line.Outstanding() > 0 {
+			newStatus = models.PurchaseOrderStatusPartiallyReceived
+			break
+		}
+	}
+
+	if newStatus != status {
+		if _, err := transitionPurchaseOrder(ctx, tx, id, newStatus); err != nil {
+			return nil, err
+		}
+	} else if _, err := tx.ExecContext(ctx, `UPDATE purchase_orders SET updated_at = $1 WHERE id = $2`, time.Now(), id); err != nil {
+		log.Printf("Error updating purchase order: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// ClosePurchaseOrder closes a partially received purchase order when the rest of the
+// goods will not be delivered
+func (r *PurchaseOrderRepository) ClosePurchaseOrder(ctx context.Context, id string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := transitionPurchaseOrder(ctx, tx, id, models.PurchaseOrderStatusClosed); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PurchaseOrderRepository) GetPurchaseOrderReceipts(ctx context.Context, purchaseOrderID string) ([]models.PurchaseOrderReceipt, error) {
+	query := `
+		SELECT pr.id, pr.purchase_order_id, pr.purchase_order_line_id, pr.product_id, pr.warehouse_id, pr.location_id,
+		       pr.inventory_id, pr.quantity, pr.notes, pr.received_by, pr.received_at, wl.location_code
+		FROM purchase_order_receipts pr
+		LEFT JOIN warehouse_locations wl ON pr.location_id = wl.id
+		WHERE pr.purchase_order_id = $1
+		ORDER BY pr.received_at
+	`
+	rows, err := r.DB.QueryContext(ctx, query, purchaseOrderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []models.PurchaseOrderReceipt{}
+	for rows.Next() {
+		var receipt models.PurchaseOrderReceipt
+		var locationID, inventoryID, notes, receivedBy, locationCode sql.NullString
+		err := rows.Scan(
+			&receipt.ID,
+			&receipt.PurchaseOrderID,
+			&receipt.PurchaseOrderLineID,
+			&receipt.ProductID,
+			&receipt.WarehouseID,
+			&locationID,
+			&inventoryID,
+			&receipt.Quantity,
+			&notes,
+			&receivedBy,
+			&receipt.ReceivedAt,
+			&locationCode,
+		)
+		if err != nil {
+			log.Printf("Error scanning purchase order receipt: %v", err)
+			return nil, err
+		}
+		if locationID.Valid {
+			receipt.LocationID = &locationID.String
+		}
+		if inventoryID.Valid {
+			receipt.InventoryID = &inventoryID.String
+		}
+		receipt.Notes = notes.String
+		receipt.ReceivedBy = receivedBy.String
+		receipt.LocationCode = locationCode.String
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// transitionPurchaseOrder moves a purchase order to a new status if the current status
+// allows it and returns the status it moved from
+func transitionPurchaseOrder(ctx context.Context, tx *database.Tx, id, toStatus string) (string, error) {
+	var fromStatus string
+	err := tx.QueryRowContext(ctx, `SELECT status FROM purchase_orders WHERE id = $1`, id).Scan(&fromStatus)
+	if err == sql.ErrNoRows {
+		return "", ErrPurchaseOrderNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading purchase order status: %v", err)
+		return "", err
+	}
+
+	if !models.CanTransitionPurchaseOrderStatus(fromStatus, toStatus) {
+		return "", fmt.Errorf("%w: %s to %s", ErrInvalidPurchaseOrderStatus, fromStatus, toStatus)
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE purchase_orders SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4`,
+		toStatus,
+		time.Now(),
+		id,
+		fromStatus,
+	)
+	if err != nil {
+		log.Printf("Error updating purchase order status: %v", err)
+		return "", err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return "", fmt.Errorf("%w: status was changed by another request", ErrInvalidPurchaseOrderStatus)
+	}
+	return fromStatus, nil
+}
+
+func scanPurchaseOrder(row rowScanner) (*models.PurchaseOrder, error) {
+	var po models.PurchaseOrder
+	var warehouseID, notes, createdBy, approvedBy, warehouseName sql.NullString
+	var expectedDate, approvedAt, sentAt sql.NullTime
+	err := row.Scan(
+		&po.ID,
+		&po.PONumber,
+		&po.SupplierID,
+		&warehouseID,
+		&po.Status,
+		&po.OrderDate,
+		&expectedDate,
+		&po.Currency,
+		&po.TotalAmount,
+		&notes,
+		&createdBy,
+		&approvedBy,
+		&approvedAt,
+		&sentAt,
+		&po.CreatedAt,
+		&po.UpdatedAt,
+		&po.SupplierName,
+		&warehouseName,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if warehouseID.Valid {
+		po.WarehouseID = &warehouseID.String
+	}
+	if expectedDate.Valid {
+		po.ExpectedDate = &expectedDate.Time
+	}
+	if approvedAt.Valid {
+		po.ApprovedAt = &approvedAt.Time
+	}
+	if sentAt.Valid {
+		po.SentAt = &sentAt.Time
+	}
+	po.Notes = notes.String
+	po.CreatedBy = createdBy.String
+	po.ApprovedBy = approvedBy.String
+	po.WarehouseName = warehouseName.String
+	return &po, nil
+}
+
+func purchaseOrderLines(ctx context.Context, q rowQuerier, purchaseOrderID string) ([]models.PurchaseOrderLine, error) {
+	query := `
+		SELECT pl.id, pl.purchase_order_id, pl.product_id, pl.supplier_sku, pl.quantity, pl.received_quantity,
+		       pl.billed_quantity, pl.unit_cost, pl.total_cost, pl.lead_time_days, pl.expected_date, p.name, p.sku
+		FROM purchase_order_lines pl
+		LEFT JOIN products p ON pl.product_id = p.id
+		WHERE pl.purchase_order_id = $1
+		ORDER BY p.name
+	`
+	rows, err := q.QueryContext(ctx, query, purchaseOrderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []models.PurchaseOrderLine{}
+	for rows.Next() {
+		var line models.PurchaseOrderLine
+		var supplierSKU, productName, sku sql.NullString
+		var expectedDate sql.NullTime
+		err := rows.Scan(
+			&line.ID,
+			&line.PurchaseOrderID,
+			&line.ProductID,
+			&supplierSKU,
+			&line.Quantity,
+			&line.ReceivedQuantity,
+			&line.BilledQuantity,
+			&line.UnitCost,
+			&line.TotalCost,
+			&line.LeadTimeDays,
+			&expectedDate,
+			&productName,
+			&sku,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if expectedDate.Valid {
+			line.ExpectedDate = &expectedDate.Time
+		}
+		line.SupplierSKU = supplierSKU.String
+		line.ProductName = productName.String
+		line.SKU = sku.String
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
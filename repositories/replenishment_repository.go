@@ -0,0 +1,217 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"fmt"
+	"log"
+	"strings"
+)
+
+type ReplenishmentRepository struct {
+	DB *database.Conn
+}
+
+func NewReplenishmentRepository(db *database.Conn) *ReplenishmentRepository {
+	return &ReplenishmentRepository{DB: db}
+}
+
+// ReplenishmentFilter narrows the products scanned for reordering. SupplierID matches
+// the supplier chosen for each product, not every supplier linked to it.
+type ReplenishmentFilter struct {
+	WarehouseID string
+	SupplierID  string
+	ProductIDs  []string
+}
+
+// GetSuggestions scans inventory in active warehouses for products whose available
+// stock plus stock on open purchase orders is below the minimum, and proposes an
+// order-up-to quantity from each product's primary supplier. Products without a
+// primary supplier fall back to their cheapest active supplier.
+func (r *ReplenishmentRepository) GetSuggestions(ctx context.Context, filter ReplenishmentFilter) ([]models.ReplenishmentSuggestion, error) {
+	whereClauses := []string{"w.status = 'active'"}
+	var args []interface{}
+
+	if filter.WarehouseID != "" {
+		args = append(args, filter.WarehouseID)
+		whereClauses = append(whereClauses, fmt.Sprintf("i.warehouse_id = $%d", len(args)))
+	}
+	if len(filter.ProductIDs) > 0 {
+		placeholders := make([]string, len(filter.ProductIDs))
+		for i, productID := range filter.ProductIDs {
+			args = append(args, productID)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		whereClauses = append(whereClauses, "i.product_id IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	query := `
+		SELECT i.product_id, i.warehouse_id, SUM(i.quantity), SUM(COALESCE(i.reserved_quantity, 0)),
+		       MAX(COALESCE(i.min_quantity, 0)), MAX(i.max_quantity), p.name, p.sku, w.name
+		FROM inventory i
+		JOIN products p ON i.product_id = p.id
+		JOIN warehouses w ON i.warehouse_id = w.id
+		WHERE ` + strings.Join(whereClauses, " AND ") + `
+		GROUP BY i.product_id, i.warehouse_id, p.name, p.sku, w.name
+		ORDER BY w.name, p.name
+	`
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []models.ReplenishmentSuggestion
+	for rows.Next() {
+		var suggestion models.ReplenishmentSuggestion
+		var maxQuantity sql.NullInt64
+		var productName, sku, warehouseName sql.NullString
+		err := rows.Scan(
+			&suggestion.ProductID,
+			&suggestion.WarehouseID,
+			&suggestion.OnHandQuantity,
+			&suggestion.ReservedQuantity,
+			&suggestion.MinQuantity,
+			&maxQuantity,
+			&productName,
+			&sku,
+			&warehouseName,
+		)
+		if err != nil {
+			log.Printf("Error scanning inventory levels: %v", err)
+			return nil, err
+		}
+		if maxQuantity.Valid {
+			max := int(maxQuantity.Int64)
+			suggestion.MaxQuantity = &max
+		}
+		suggestion.AvailableQuantity = suggestion.OnHandQuantity - suggestion.ReservedQuantity
+		suggestion.ProductName = productName.String
+		suggestion.SKU = sku.String
+		suggestion.WarehouseName = warehouseName.String
+		candidates = append(candidates, suggestion)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	onOrder, err := r.onOrderQuantities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	suppliers, err := r.preferredSuppliers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []models.ReplenishmentSuggestion{}
+	for _, suggestion := range candidates {
+		suggestion.OnOrderQuantity = onOrder[suggestion.ProductID+"/"+suggestion.WarehouseID]
+		suggestion.Currency = models.BaseCurrency.Code
+
+		if ps, ok := suppliers[suggestion.ProductID]; ok {
+			supplierID := ps.SupplierID
+			suggestion.SupplierID = &supplierID
+			suggestion.SupplierSKU = ps.SupplierSKU
+			suggestion.UnitCost = ps.CostPrice
+			suggestion.Currency = ps.Currency
+			suggestion.LeadTimeDays = ps.LeadTimeDays
+			if ps.SupplierName != nil {
+				suggestion.SupplierName = ps.SupplierName.Name
+			}
+		}
+		if filter.SupplierID != "" && (suggestion.SupplierID == nil || *suggestion.SupplierID != filter.SupplierID) {
+			continue
+		}
+
+		if suggestion.Calculate() {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	return suggestions, nil
+}
+
+// onOrderQuantities returns the quantity still outstanding on open purchase orders,
+// keyed by product and ship-to warehouse
+func (r *ReplenishmentRepository) onOrderQuantities(ctx context.Context) (map[string]int, error) {
+	query := `
+		SELECT pl.product_id, po.warehouse_id, SUM(pl.quantity - pl.received_quantity)
+		FROM purchase_order_lines pl
+		JOIN purchase_orders po ON pl.purchase_order_id = po.id
+		WHERE po.status IN ($1, $2, $3, $4) AND po.warehouse_id IS NOT NULL
+		      AND pl.quantity > pl.received_quantity
+		GROUP BY pl.product_id, po.warehouse_id
+	`
+	rows, err := r.DB.QueryContext(ctx, query,
+		models.PurchaseOrderStatusDraft,
+		models.PurchaseOrderStatusApproved,
+		models.PurchaseOrderStatusSent,
+		models.PurchaseOrderStatusPartiallyReceived,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	onOrder := map[string]int{}
+	for rows.Next() {
+		var productID, warehouseID string
+		var quantity int
+		if err := rows.Scan(&productID, &warehouseID, &quantity); err != nil {
+			log.Printf("Error scanning on-order quantity: %v", err)
+			return nil, err
+		}
+		onOrder[productID+"/"+warehouseID] = quantity
+	}
+	return onOrder, rows.Err()
+}
+
+// preferredSuppliers returns the supplier link to reorder each product from: the
+// primary one, otherwise the cheapest by listed cost price, which does not convert
+// between the currencies suppliers quote in. Inactive suppliers are never chosen.
+func (r *ReplenishmentRepository) preferredSuppliers(ctx context.Context) (map[string]models.ProductSupplier, error) {
+	query := `
+		SELECT ps.id, ps.product_id, ps.supplier_id, ps.supplier_sku, ps.cost_price, ps.currency, ps.lead_time_days,
+		       ps.is_primary, s.name
+		FROM product_suppliers ps
+		JOIN suppliers s ON ps.supplier_id = s.id
+		WHERE s.status = 'active'
+		ORDER BY ps.product_id, ps.is_primary DESC, ps.cost_price, s.name
+	`
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suppliers := map[string]models.ProductSupplier{}
+	for rows.Next() {
+		var ps models.ProductSupplier
+		var supplierSKU sql.NullString
+		var supplierName string
+		err := rows.Scan(
+			&ps.ID,
+			&ps.ProductID,
+			&ps.SupplierID,
+			&supplierSKU,
+			&ps.CostPrice,
+			&ps.Currency,
+			&ps.LeadTimeDays,
+			&ps.IsPrimary,
+			&supplierName,
+		)
+		if err != nil {
+			log.Printf("Error scanning product supplier: %v", err)
+			return nil, err
+		}
+		if _, ok := suppliers[ps.ProductID]; ok {
+			continue
+		}
+		ps.SupplierSKU = supplierSKU.String
+		ps.SupplierName = &models.Supplier{ID: ps.SupplierID, Name: supplierName}
+		suppliers[ps.ProductID] = ps
+	}
+	return suppliers, rows.Err()
+}
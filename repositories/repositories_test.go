@@ -0,0 +1,1909 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"erp-project/database"
+	"erp-project/database/dbtest"
+	"erp-project/models"
+)
+
+func TestProductRepository(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		repo := NewProductRepository(db)
+
+		product := models.NewProduct("Blue Widget", "A widget", "WID-001", "widgets", models.MustParseMoney("9.5"), 3)
+		if err := repo.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if err := repo.CreateProduct(t.Context(), models.NewProduct("Red Gadget", "", "GAD-001", "gadgets", models.MustParseMoney("4"), 1)); err != nil {
+			t.Fatalf("create second: %v", err)
+		}
+
+		// Search ignores case on both dialects
+		products, total, err := repo.GetProductsWithPagination(t.Context(), 1, 10, "widget", "")
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		if total != 1 || len(products) != 1 || products[0].ID != product.ID {
+			t.Errorf("search found %d (total %d), want the widget", len(products), total)
+		}
+		_, total, err = repo.GetProductsWithPagination(t.Context(), 1, 10, "", "gadgets")
+		if err != nil || total != 1 {
+			t.Errorf("category filter: total=%d err=%v", total, err)
+		}
+
+		if err := repo.UpdateProductQuantity(t.Context(), product.ID, 7); err != nil {
+			t.Fatalf("update quantity: %v", err)
+		}
+		got, err := repo.GetProductByID(t.Context(), product.ID)
+		if err != nil || got == nil || got.Quantity != 7 {
+			t.Fatalf("get after quantity update: %+v err=%v", got, err)
+		}
+
+		// An update made from a stale read applies its quantity as a change, so stock
+		// taken since (here 2 units) stays taken
+		stale := *got
+		if err := repo.UpdateProductQuantity(t.Context(), product.ID, 5); err != nil {
+			t.Fatalf("update quantity: %v", err)
+		}
+		stale.Name = "Navy Widget"
+		if err := repo.UpdateProduct(t.Context(), &stale, 3, ""); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		if stale.Quantity != 8 {
+			t.Errorf("quantity after update = %d, want 8", stale.Quantity)
+		}
+		stale.Name = "Teal Widget"
+		if err := repo.UpdateProduct(t.Context(), &stale, 0, ""); err != nil || stale.Quantity != 8 {
+			t.Errorf("update without quantity: quantity %d, err %v", stale.Quantity, err)
+		}
+		if err := repo.UpdateProduct(t.Context(), &stale, -9, ""); !errors.Is(err, ErrInsufficientStock) {
+			t.Errorf("update below zero: err = %v", err)
+		}
+		if got, _ := repo.GetProductByID(t.Context(), product.ID); got == nil || got.Name != "Teal Widget" || got.Quantity != 8 {
+			t.Errorf("after failed update: %+v", got)
+		}
+
+		if err := repo.DeleteProduct(t.Context(), product.ID); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if got, err := repo.GetProductByID(t.Context(), product.ID); err != nil || got != nil {
+			t.Errorf("get after delete: %+v err=%v", got, err)
+		}
+	})
+}
+
+func TestOrderTotalsMatchLines(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		repo := NewOrderRepository(db)
+
+		customer := models.NewCustomer("Grace Hopper", "grace@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		var items []*models.OrderItem
+		for i, price := range []string{"0.3333", "1.005", "1234567.8912"} {
+			product := models.NewProduct("Part", "", fmt.Sprintf("PART-%d", i), "", models.MustParseMoney(price), 10)
+			if err := products.CreateProduct(t.Context(), product); err != nil {
+				t.Fatalf("create product: %v", err)
+			}
+			items = append(items, models.NewOrderItem("", product.ID, 3, product.Price, models.BaseCurrency))
+		}
+
+		order := models.NewOrder(customer.ID, models.OrderItemsTotal(items))
+		for _, item := range items {
+			item.OrderID = order.ID
+		}
+		if _, err := repo.CreateOrderWithItems(t.Context(), order, items, models.AllocationOptions{Strategy: models.AllocationStrategySplit}, ""); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+
+		// A total that disagrees with the lines is refused
+		bad := models.NewOrder(customer.ID, order.TotalAmount+1)
+		if _, err := repo.CreateOrderWithItems(t.Context(), bad, nil, models.AllocationOptions{}, ""); !errors.Is(err, ErrOrderTotalMismatch) {
+			t.Errorf("mismatched total: err = %v", err)
+		}
+
+		// Cancel one unit of the line whose total was rounded
+		if _, _, err := repo.CancelOrder(t.Context(), order.ID, map[string]int{items[0].ID: 1}, "changed mind", ""); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+
+		saved, err := repo.GetOrderByID(t.Context(), order.ID)
+		if err != nil || saved == nil {
+			t.Fatalf("get order: %+v err=%v", saved, err)
+		}
+		lines, err := repo.GetOrderItems(t.Context(), order.ID)
+		if err != nil {
+			t.Fatalf("get items: %v", err)
+		}
+		var sum models.Money
+		for _, line := range lines {
+			sum += line.TotalPrice
+		}
+		if saved.TotalAmount != sum {
+			t.Errorf("order total %s != sum of lines %s", saved.TotalAmount, sum)
+		}
+		// 0.6666 -> 0.67, 3.015 -> 3.02, 3703703.6736 -> 3703703.67
+		if want := models.MustParseMoney("3703707.36"); saved.TotalAmount != want {
+			t.Errorf("order total = %s, want %s", saved.TotalAmount, want)
+		}
+	})
+}
+
+// TestConcurrentOrdersDoNotOversell places more orders at once than there is stock for.
+// On SQLite they queue for the single connection; on PostgreSQL they really race.
+func TestConcurrentOrdersDoNotOversell(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		repo := NewOrderRepository(db)
+		movements := NewStockMovementRepository(db)
+
+		const stock, quantity, orders = 10, 3, 8
+		customer := models.NewCustomer("Grace Hopper", "grace@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		product := models.NewProduct("Part", "", "PART-1", "", models.MustParseMoney("2"), stock)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+
+		errs := make(chan error, orders)
+		var wg sync.WaitGroup
+		for range orders {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				item := models.NewOrderItem("", product.ID, quantity, product.Price, models.BaseCurrency)
+				order := models.NewOrder(customer.ID, item.TotalPrice)
+				item.OrderID = order.ID
+				_, err := repo.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, "")
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		placed := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				placed++
+			case !errors.Is(err, ErrInsufficientStock):
+				t.Errorf("order failed with %v, want insufficient stock", err)
+			}
+		}
+		if placed != stock/quantity {
+			t.Errorf("%d orders placed, want %d", placed, stock/quantity)
+		}
+
+		saved, err := products.GetProductByID(t.Context(), product.ID)
+		if err != nil || saved == nil {
+			t.Fatalf("get product: %+v err=%v", saved, err)
+		}
+		if want := stock - placed*quantity; saved.Quantity != want {
+			t.Errorf("quantity = %d, want %d", saved.Quantity, want)
+		}
+		// The ledger shows every step on the way there, none of them below zero
+		ledger, _, err := movements.GetMovements(t.Context(), StockMovementFilter{ProductID: product.ID}, 1, 100)
+		if err != nil {
+			t.Fatalf("get movements: %v", err)
+		}
+		for _, m := range ledger {
+			if m.QuantityAfter < 0 {
+				t.Errorf("stock went to %d: %+v", m.QuantityAfter, m)
+			}
+		}
+	})
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		repo := NewOrderRepository(db)
+
+		customer := models.NewCustomer("Grace Hopper", "grace@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		product := models.NewProduct("Part", "", "PART-1", "", models.MustParseMoney("2"), 10)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		item := models.NewOrderItem("", product.ID, 2, product.Price, models.BaseCurrency)
+		order := models.NewOrder(customer.ID, item.TotalPrice)
+		item.OrderID = order.ID
+		if _, err := repo.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, "seller"); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+
+		move := func(to string) error {
+			_, err := repo.TransitionOrderStatus(t.Context(), order.ID, to, "", "clerk")
+			return err
+		}
+		if err := move(models.OrderStatusOnHold); err != nil {
+			t.Fatalf("hold: %v", err)
+		}
+		// A held order resumes where it was held, not further along
+		if err := move(models.OrderStatusConfirmed); !errors.Is(err, ErrInvalidStatusTransition) {
+			t.Errorf("resume past the held status: err = %v", err)
+		}
+		for _, to := range []string{models.OrderStatusPending, models.OrderStatusConfirmed, models.OrderStatusPicking, models.OrderStatusShipped} {
+			if err := move(to); err != nil {
+				t.Fatalf("move to %s: %v", to, err)
+			}
+		}
+		if err := move(models.OrderStatusCancelled); !errors.Is(err, ErrInvalidStatusTransition) {
+			t.Errorf("cancel a shipped order: err = %v", err)
+		}
+		if err := move(models.OrderStatusDelivered); err != nil {
+			t.Fatalf("deliver: %v", err)
+		}
+		if _, err := repo.TransitionOrderStatus(t.Context(), "missing", models.OrderStatusConfirmed, "", ""); !errors.Is(err, ErrOrderNotFound) {
+			t.Errorf("unknown order: err = %v", err)
+		}
+
+		history, err := repo.GetOrderStatusHistory(t.Context(), order.ID)
+		if err != nil || len(history) != 7 {
+			t.Fatalf("history: %+v err=%v", history, err)
+		}
+		if first := history[0]; first.FromStatus != "" || first.ToStatus != models.OrderStatusPending || first.ChangedBy != "seller" {
+			t.Errorf("creation entry: %+v", first)
+		}
+		if last := history[6]; last.FromStatus != models.OrderStatusShipped || last.ToStatus != models.OrderStatusDelivered || last.ChangedBy != "clerk" {
+			t.Errorf("last change: %+v", last)
+		}
+		if saved, _ := repo.GetOrderByID(t.Context(), order.ID); saved == nil || saved.Status != models.OrderStatusDelivered {
+			t.Errorf("order after delivery: %+v", saved)
+		}
+	})
+}
+
+func TestPartialOrderCancellation(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		repo := NewOrderRepository(db)
+
+		customer := models.NewCustomer("Grace Hopper", "grace@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		product := models.NewProduct("Part", "", "PART-1", "", models.MustParseMoney("2"), 10)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		item := models.NewOrderItem("", product.ID, 5, product.Price, models.BaseCurrency)
+		order := models.NewOrder(customer.ID, item.TotalPrice)
+		item.OrderID = order.ID
+		if _, err := repo.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, ""); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+		stock := func() int {
+			saved, _ := products.GetProductByID(t.Context(), product.ID)
+			if saved == nil {
+				t.Fatal("product not found")
+			}
+			return saved.Quantity
+		}
+
+		// 2 of the 5 units go back on the shelf and come off the total
+		cancellations, history, err := repo.CancelOrder(t.Context(), order.ID, map[string]int{item.ID: 2}, "short", "clerk")
+		if err != nil || len(cancellations) != 1 || cancellations[0].Amount != models.MustParseMoney("4") || history != nil {
+			t.Fatalf("cancel 2: %+v, %+v, err %v", cancellations, history, err)
+		}
+		saved, _ := repo.GetOrderByID(t.Context(), order.ID)
+		if saved == nil || saved.Status != models.OrderStatusPending || saved.TotalAmount != models.MustParseMoney("6") || stock() != 7 {
+			t.Errorf("after cancelling 2: %+v, stock %d", saved, stock())
+		}
+
+		if _, _, err := repo.CancelOrder(t.Context(), order.ID, map[string]int{item.ID: 4}, "", ""); !errors.Is(err, ErrInvalidCancellation) {
+			t.Errorf("cancel more than is left: err = %v", err)
+		}
+		if _, _, err := repo.CancelOrder(t.Context(), order.ID, map[string]int{"missing": 1}, "", ""); !errors.Is(err, ErrInvalidCancellation) {
+			t.Errorf("cancel a line of another order: err = %v", err)
+		}
+		if stock() != 7 {
+			t.Errorf("stock after rejected cancellations: %d", stock())
+		}
+
+		// Cancelling what is left cancels the order
+		_, history, err = repo.CancelOrder(t.Context(), order.ID, nil, "gone", "clerk")
+		if err != nil || history == nil || history.ToStatus != models.OrderStatusCancelled {
+			t.Fatalf("cancel the rest: %+v err=%v", history, err)
+		}
+		saved, _ = repo.GetOrderByID(t.Context(), order.ID)
+		if saved == nil || saved.TotalAmount != 0 || stock() != 10 {
+			t.Errorf("after cancelling the rest: %+v, stock %d", saved, stock())
+		}
+		if _, _, err := repo.CancelOrder(t.Context(), order.ID, nil, "", ""); !errors.Is(err, ErrInvalidStatusTransition) {
+			t.Errorf("cancel a cancelled order: err = %v", err)
+		}
+
+		recorded, err := repo.GetOrderCancellations(t.Context(), order.ID)
+		if err != nil || len(recorded) != 2 || recorded[0].Quantity != 2 || recorded[1].Quantity != 3 || recorded[0].CancelledBy != "clerk" {
+			t.Errorf("cancellations: %+v err=%v", recorded, err)
+		}
+	})
+}
+
+func TestOrderAllocation(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		warehouses := NewWarehouseRepository(db)
+		repo := NewOrderRepository(db)
+
+		customer := models.NewCustomer("Grace Hopper", "grace@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		// 5 of the 15 are not held in any warehouse, so the product has stock to spare for
+		// every order
+		product := models.NewProduct("Part", "", "PART-1", "", models.MustParseMoney("2"), 15)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		// 4 in the first warehouse and 6 in the second
+		var sites []*models.Warehouse
+		var rows []*models.Inventory
+		for i, quantity := range []int{4, 6} {
+			warehouse := models.NewWarehouse(fmt.Sprintf("WH-%d", i+1), "Site", "", "", "", "", 1000)
+			if err := warehouses.CreateWarehouse(t.Context(), warehouse); err != nil {
+				t.Fatalf("create warehouse: %v", err)
+			}
+			inventory := models.NewInventory(product.ID, warehouse.ID, nil, quantity, 0)
+			if err := warehouses.CreateInventory(t.Context(), inventory, ""); err != nil {
+				t.Fatalf("create inventory: %v", err)
+			}
+			sites = append(sites, warehouse)
+			rows = append(rows, inventory)
+		}
+		place := func(quantity int, opts models.AllocationOptions) (*models.Order, *models.OrderItem, []*models.OrderAllocation, error) {
+			item := models.NewOrderItem("", product.ID, quantity, product.Price, models.BaseCurrency)
+			order := models.NewOrder(customer.ID, item.TotalPrice)
+			item.OrderID = order.ID
+			allocations, err := repo.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, opts, "")
+			return order, item, allocations, err
+		}
+		reserved := func(i int) (quantity, reserved int) {
+			got, _ := warehouses.GetInventoryByID(t.Context(), rows[i].ID)
+			if got == nil {
+				t.Fatalf("inventory %d not found", i)
+			}
+			return got.Quantity, got.ReservedQuantity
+		}
+
+		// Split: the preferred warehouse first, the rest from the other
+		order, item, allocations, err := place(8, models.AllocationOptions{Strategy: models.AllocationStrategySplit, WarehouseID: sites[0].ID})
+		if err != nil || len(allocations) != 2 {
+			t.Fatalf("split order: %+v err=%v", allocations, err)
+		}
+		if allocations[0].WarehouseID != sites[0].ID || allocations[0].Quantity != 4 || allocations[1].Quantity != 4 {
+			t.Errorf("split allocations: %+v, %+v", allocations[0], allocations[1])
+		}
+		if _, r := reserved(1); r != 4 {
+			t.Errorf("second warehouse reserved %d, want 4", r)
+		}
+
+		// No single warehouse has 3 free any more, so nothing is placed
+		_, _, _, err = place(3, models.AllocationOptions{Strategy: models.AllocationStrategySingle})
+		var stockErr *InsufficientStockError
+		if !errors.As(err, &stockErr) || stockErr.Strategy != models.AllocationStrategySingle || stockErr.Available != 2 {
+			t.Errorf("single warehouse short: err = %v", err)
+		}
+		if saved, _ := products.GetProductByID(t.Context(), product.ID); saved == nil || saved.Quantity != 7 {
+			t.Errorf("product after refused order: %+v", saved)
+		}
+
+		// Cancelling releases the newest reservation first, and shipping deducts the rest
+		if _, _, err := repo.CancelOrder(t.Context(), order.ID, map[string]int{item.ID: 2}, "", ""); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		if _, r := reserved(1); r != 2 {
+			t.Errorf("second warehouse reserved %d after cancelling 2, want 2", r)
+		}
+		for _, to := range []string{models.OrderStatusConfirmed, models.OrderStatusPicking, models.OrderStatusShipped} {
+			if _, err := repo.TransitionOrderStatus(t.Context(), order.ID, to, "", ""); err != nil {
+				t.Fatalf("move to %s: %v", to, err)
+			}
+		}
+		for i, want := range []int{0, 4} {
+			if q, r := reserved(i); q != want || r != 0 {
+				t.Errorf("warehouse %d after shipping: quantity %d, reserved %d; want %d, 0", i+1, q, r, want)
+			}
+		}
+		saved, err := repo.GetOrderAllocations(t.Context(), order.ID)
+		if err != nil || len(saved) != 2 {
+			t.Fatalf("allocations: %+v err=%v", saved, err)
+		}
+		for _, a := range saved {
+			if a.Status != models.AllocationStatusShipped {
+				t.Errorf("allocation after shipping: %+v", a)
+			}
+		}
+		// The line's shipped quantity is what its shipped allocations hold
+		if items, err := repo.GetOrderItems(t.Context(), order.ID); err != nil || len(items) != 1 || items[0].ShippedQuantity != 6 || items[0].Uninvoiced() != 6 {
+			t.Errorf("shipped line: %+v err=%v", items, err)
+		}
+	})
+}
+
+func TestStockLedger(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		orders := NewOrderRepository(db)
+		repo := NewStockMovementRepository(db)
+
+		customer := models.NewCustomer("Grace Hopper", "grace@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		product := models.NewProduct("Part", "", "PART-1", "", models.MustParseMoney("2"), 10)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		place := func(quantity int) (*models.Order, *models.OrderItem, error) {
+			item := models.NewOrderItem("", product.ID, quantity, product.Price, models.BaseCurrency)
+			order := models.NewOrder(customer.ID, item.TotalPrice)
+			item.OrderID = order.ID
+			_, err := orders.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, "clerk")
+			return order, item, err
+		}
+
+		order, item, err := place(3)
+		if err != nil {
+			t.Fatalf("place order: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		afterOrder := time.Now()
+		time.Sleep(10 * time.Millisecond)
+		if _, _, err := orders.CancelOrder(t.Context(), order.ID, map[string]int{item.ID: 1}, "short", "clerk"); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		// A refused order leaves nothing in the ledger
+		if _, _, err := place(20); !errors.Is(err, ErrInsufficientStock) {
+			t.Fatalf("oversized order: err = %v", err)
+		}
+
+		ledger, total, err := repo.GetMovements(t.Context(), StockMovementFilter{ProductID: product.ID}, 1, 10)
+		if err != nil || total != 3 || len(ledger) != 3 {
+			t.Fatalf("ledger: %+v, total %d, err %v", ledger, total, err)
+		}
+		issues, _, err := repo.GetMovements(t.Context(), StockMovementFilter{ProductID: product.ID, MovementType: models.MovementTypeIssue}, 1, 10)
+		if err != nil || len(issues) != 1 {
+			t.Fatalf("issues: %+v err=%v", issues, err)
+		}
+		if m := issues[0]; m.Quantity != -3 || m.QuantityBefore != 10 || m.QuantityAfter != 7 || m.ReferenceID != order.ID || m.CreatedBy != "clerk" {
+			t.Errorf("issue: %+v", m)
+		}
+		byOrder, total, err := repo.GetMovements(t.Context(), StockMovementFilter{ReferenceType: models.ReferenceTypeOrder, ReferenceID: order.ID}, 1, 10)
+		if err != nil || total != 2 {
+			t.Errorf("movements of the order: %+v, total %d, err %v", byOrder, total, err)
+		}
+
+		// The ledger rebuilds the stock as it stood between the order and the cancellation
+		if got, err := repo.GetStockAt(t.Context(), product.ID, "", afterOrder); err != nil || got != 7 {
+			t.Errorf("stock after the order: %d err=%v", got, err)
+		}
+		if got, err := repo.GetStockAt(t.Context(), product.ID, "", time.Now()); err != nil || got != 8 {
+			t.Errorf("stock now: %d err=%v", got, err)
+		}
+	})
+}
+
+func TestExchangeRates(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		repo := NewExchangeRateRepository(db)
+		day := func(d int) time.Time { return time.Date(2026, time.March, d, 0, 0, 0, 0, time.UTC) }
+
+		rates := []*models.ExchangeRate{
+			models.NewExchangeRate("EUR", models.MustParseRate("1.08"), day(1), models.ExchangeRateSourceCSV, ""),
+			models.NewExchangeRate("EUR", models.MustParseRate("1.1"), day(10), models.ExchangeRateSourceCSV, ""),
+			models.NewExchangeRate("JPY", models.MustParseRate("0.00673"), day(1), models.ExchangeRateSourceAPI, ""),
+		}
+		if err := repo.CreateExchangeRates(t.Context(), rates); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		// Loading a day again replaces its rate
+		if err := repo.CreateExchangeRates(t.Context(), []*models.ExchangeRate{
+			models.NewExchangeRate("EUR", models.MustParseRate("1.09"), day(1), models.ExchangeRateSourceAPI, ""),
+		}); err != nil {
+			t.Fatalf("replace: %v", err)
+		}
+
+		tests := []struct {
+			currency string
+			on       time.Time
+			want     string
+		}{
+			{"EUR", day(1), "1.09"},
+			{"EUR", day(9).Add(23 * time.Hour), "1.09"},
+			{"EUR", day(10), "1.1"},
+			{"eur", day(31), "1.1"},
+			{"JPY", day(5), "0.00673"},
+			{"USD", day(5), "1"},
+		}
+		for _, tt := range tests {
+			rate, err := repo.GetRate(t.Context(), tt.currency, tt.on)
+			if err != nil || rate == nil || rate.Rate != models.MustParseRate(tt.want) {
+				t.Errorf("GetRate(%s, %s) = %+v, %v; want %s", tt.currency, tt.on.Format("2006-01-02"), rate, err, tt.want)
+			}
+		}
+		if rate, err := repo.GetRate(t.Context(), "EUR", day(1).Add(-time.Hour)); err != nil || rate != nil {
+			t.Errorf("rate before the first one = %+v, %v; want none", rate, err)
+		}
+
+		history, err := repo.GetExchangeRates(t.Context(), ExchangeRateFilter{Currency: "EUR"})
+		if err != nil || len(history) != 2 || history[0].Rate != models.MustParseRate("1.1") {
+			t.Errorf("history: %+v err=%v", history, err)
+		}
+	})
+}
+
+func TestOrderInForeignCurrency(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		repo := NewOrderRepository(db)
+
+		customer := models.NewCustomer("Jean Dupont", "jean@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		product := models.NewProduct("Clock", "", "CLK-1", "", models.MustParseMoney("10"), 5)
+		product.Currency = "EUR"
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+
+		eur, _ := models.LookupCurrency("EUR")
+		item := models.NewOrderItem("", product.ID, 3, product.Price, eur)
+		order := models.NewOrder(customer.ID, models.OrderItemsTotal([]*models.OrderItem{item}))
+		order.SetCurrency(eur, models.MustParseRate("1.08335"))
+		item.OrderID = order.ID
+		if _, err := repo.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, ""); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+
+		// 30 EUR at 1.08335 is 32.5005 USD, rounded to 32.50
+		saved, err := repo.GetOrderByID(t.Context(), order.ID)
+		if err != nil || saved == nil || saved.Currency != "EUR" || saved.BaseTotalAmount != models.MustParseMoney("32.5") {
+			t.Fatalf("saved order: %+v err=%v", saved, err)
+		}
+
+		// The base total follows a cancellation at the rate the order was placed at
+		if _, _, err := repo.CancelOrder(t.Context(), order.ID, map[string]int{item.ID: 1}, "damaged", ""); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		totals, err := repo.GetOrderTotals(t.Context(), nil, nil)
+		if err != nil || len(totals) != 1 {
+			t.Fatalf("totals: %+v err=%v", totals, err)
+		}
+		if got := totals[0]; got.Currency != "EUR" || got.TotalAmount != models.MustParseMoney("20") || got.BaseTotalAmount != models.MustParseMoney("21.67") {
+			t.Errorf("totals after cancelling = %+v", got)
+		}
+	})
+}
+
+func TestPriceLists(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		orders := NewOrderRepository(db)
+		repo := NewPriceListRepository(db)
+		day := func(d int) time.Time { return time.Date(2026, time.April, d, 0, 0, 0, 0, time.UTC) }
+
+		customer := models.NewCustomer("Trade Buyer", "buyer@example.com", "", "")
+		customer.Group = "wholesale"
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		other := models.NewCustomer("Walk In", "walkin@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), other); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		product := models.NewProduct("Bolt", "", "BLT-1", "", models.MustParseMoney("2"), 100)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+
+		validTo := day(30)
+		group := models.NewPriceList("Wholesale", nil, "wholesale", "USD", day(1), &validTo, "")
+		group.AddItem(product.ID, 1, models.MustParseMoney("1.8"))
+		group.AddItem(product.ID, 50, models.MustParseMoney("1.5"))
+		if err := repo.CreatePriceList(t.Context(), group); err != nil {
+			t.Fatalf("create group list: %v", err)
+		}
+
+		lists, err := repo.GetApplicablePriceLists(t.Context(), customer.ID, customer.Group, day(15))
+		if err != nil || len(lists) != 1 || len(lists[0].Items) != 2 {
+			t.Fatalf("applicable lists: %+v err=%v", lists, err)
+		}
+		if lists, _ := repo.GetApplicablePriceLists(t.Context(), other.ID, other.Group, day(15)); len(lists) != 0 {
+			t.Errorf("customer outside the group got %d lists", len(lists))
+		}
+		if lists, _ := repo.GetApplicablePriceLists(t.Context(), customer.ID, customer.Group, day(30).Add(25*time.Hour)); len(lists) != 0 {
+			t.Errorf("expired list still applies: %+v", lists)
+		}
+
+		// The line records the list and tier that priced it
+		list, tier := models.ResolvePrice(lists, product.ID, 60, day(15))
+		if tier == nil || tier.UnitPrice != models.MustParseMoney("1.5") {
+			t.Fatalf("resolved tier = %+v", tier)
+		}
+		item := models.NewOrderItem("", product.ID, 60, product.Price, models.BaseCurrency)
+		item.ApplyPriceList(list, tier, tier.UnitPrice, models.BaseCurrency)
+		order := models.NewOrder(customer.ID, models.OrderItemsTotal([]*models.OrderItem{item}))
+		item.OrderID = order.ID
+		if _, err := orders.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, ""); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+		items, err := orders.GetOrderItems(t.Context(), order.ID)
+		if err != nil || len(items) != 1 {
+			t.Fatalf("order items: %+v err=%v", items, err)
+		}
+		got := items[0]
+		if got.PriceListID == nil || *got.PriceListID != group.ID || got.PriceListName != "Wholesale" ||
+			got.PriceBreakQuantity != 50 || got.ListPrice != models.MustParseMoney("2") || got.TotalPrice != models.MustParseMoney("90") {
+			t.Errorf("saved line = %+v", got)
+		}
+
+		// Deactivating a list takes it out of pricing; replacing items drops the old tiers
+		group.Active = false
+		group.Items = nil
+		group.AddItem(product.ID, 10, models.MustParseMoney("1.7"))
+		if err := repo.UpdatePriceList(t.Context(), group); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		if lists, _ := repo.GetApplicablePriceLists(t.Context(), customer.ID, customer.Group, day(15)); len(lists) != 0 {
+			t.Errorf("inactive list still applies: %+v", lists)
+		}
+		saved, err := repo.GetPriceListByID(t.Context(), group.ID)
+		if err != nil || saved == nil || saved.Active || len(saved.Items) != 1 || saved.ValidTo == nil {
+			t.Errorf("saved list: %+v err=%v", saved, err)
+		}
+	})
+}
+
+func TestPromotionsAndCoupons(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		orders := NewOrderRepository(db)
+		repo := NewPromotionRepository(db)
+		start := time.Now().Add(-time.Hour)
+
+		customer := models.NewCustomer("Ada Lovelace", "ada@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		product := models.NewProduct("Drill", "", "DRL-1", "tools", models.MustParseMoney("80"), 100)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+
+		promotion := models.NewPromotion("Welcome", "", models.PromotionTypePercentage, models.PromotionScopeLine, models.MustParseMoney("25"), "USD", start, nil, "")
+		promotion.RequiresCoupon = true
+		if err := repo.CreatePromotion(t.Context(), promotion); err != nil {
+			t.Fatalf("create promotion: %v", err)
+		}
+		coupon := models.NewCoupon("welcome", promotion.ID, 2, 1, "")
+		if err := repo.CreateCoupon(t.Context(), coupon); err != nil {
+			t.Fatalf("create coupon: %v", err)
+		}
+		if err := repo.CreateCoupon(t.Context(), models.NewCoupon("WELCOME", promotion.ID, 0, 0, "")); err == nil {
+			t.Error("duplicate coupon code accepted")
+		}
+
+		now := time.Now()
+		requiresCoupon := false
+		if automatic, err := repo.GetPromotions(t.Context(), PromotionFilter{ActiveAt: &now, RequiresCoupon: &requiresCoupon}); err != nil || len(automatic) != 0 {
+			t.Errorf("automatic promotions = %+v err=%v", automatic, err)
+		}
+		found, err := repo.GetCouponByCode(t.Context(), " Welcome")
+		if err != nil || found == nil || found.ID != coupon.ID || found.PromotionName != "Welcome" {
+			t.Fatalf("coupon by code: %+v err=%v", found, err)
+		}
+
+		placeOrder := func(customerID string) (*models.Order, error) {
+			item := models.NewOrderItem("", product.ID, 2, product.Price, models.BaseCurrency)
+			models.ApplyPromotions([]*models.OrderItem{item}, []models.Promotion{*promotion}, models.PromotionContext{
+				CustomerID: customerID,
+				Currency:   models.BaseCurrency,
+				On:         now,
+				Coupon:     found,
+			})
+			order := models.NewOrder(customerID, item.TotalPrice)
+			order.CouponID = &found.ID
+			item.OrderID = order.ID
+			_, err := orders.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, "")
+			return order, err
+		}
+
+		order, err := placeOrder(customer.ID)
+		if err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+		saved, err := orders.GetOrderByID(t.Context(), order.ID)
+		if err != nil || saved == nil || saved.CouponCode != "WELCOME" || saved.TotalAmount != models.MustParseMoney("120") {
+			t.Errorf("saved order: %+v err=%v", saved, err)
+		}
+		items, err := orders.GetOrderItems(t.Context(), order.ID)
+		if err != nil || len(items) != 1 {
+			t.Fatalf("order items: %+v err=%v", items, err)
+		}
+		if got := items[0]; got.DiscountAmount != models.MustParseMoney("40") || len(got.Discounts) != 1 ||
+			got.Discounts[0].CouponID == nil || got.Discounts[0].Description != "Welcome" {
+			t.Errorf("saved line = %+v", got)
+		}
+
+		// Once per customer, and twice in all
+		if _, err := placeOrder(customer.ID); !errors.Is(err, ErrCouponUnavailable) {
+			t.Errorf("second use by the same customer: err = %v", err)
+		}
+		other := models.NewCustomer("Charles Babbage", "charles@example.com", "", "")
+		third := models.NewCustomer("Mary Somerville", "mary@example.com", "", "")
+		for _, c := range []*models.Customer{other, third} {
+			if err := customers.CreateCustomer(t.Context(), c); err != nil {
+				t.Fatalf("create customer: %v", err)
+			}
+		}
+		second, err := placeOrder(other.ID)
+		if err != nil {
+			t.Fatalf("second customer: %v", err)
+		}
+		if _, err := placeOrder(third.ID); !errors.Is(err, ErrCouponUnavailable) {
+			t.Errorf("use past the limit: err = %v", err)
+		}
+		if found, _ := repo.GetCouponByCode(t.Context(), "WELCOME"); found == nil || found.TimesUsed != 2 || !found.Exhausted() {
+			t.Errorf("coupon after use: %+v", found)
+		}
+
+		// Cancelling part of a line gives back its share of the discount
+		if _, _, err := orders.CancelOrder(t.Context(), order.ID, map[string]int{items[0].ID: 1}, "changed mind", ""); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		if saved, _ := orders.GetOrderByID(t.Context(), order.ID); saved == nil || saved.TotalAmount != models.MustParseMoney("60") {
+			t.Errorf("order after partial cancel: %+v", saved)
+		}
+		if found, _ := repo.GetCouponByCode(t.Context(), "WELCOME"); found == nil || found.TimesUsed != 2 {
+			t.Errorf("coupon after partial cancel: %+v", found)
+		}
+
+		// Cancelling an order in full gives its coupon use back, by either route
+		if _, _, err := orders.CancelOrder(t.Context(), order.ID, nil, "changed mind", ""); err != nil {
+			t.Fatalf("cancel the rest: %v", err)
+		}
+		if _, err := orders.TransitionOrderStatus(t.Context(), second.ID, models.OrderStatusCancelled, "", ""); err != nil {
+			t.Fatalf("cancel second order: %v", err)
+		}
+		if found, _ := repo.GetCouponByCode(t.Context(), "WELCOME"); found == nil || found.TimesUsed != 0 || found.Exhausted() {
+			t.Errorf("coupon after cancelling: %+v", found)
+		}
+		if _, err := placeOrder(customer.ID); err != nil {
+			t.Errorf("use again after cancelling: %v", err)
+		}
+	})
+}
+
+func TestTaxes(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		orders := NewOrderRepository(db)
+		repo := NewTaxRepository(db)
+		start := time.Now().AddDate(0, -1, 0)
+
+		country := models.NewTaxRate("US sales tax", "US", "", "", models.MustParseMoney("5"), start, nil, "")
+		state := models.NewTaxRate("California sales tax", "US", "CA", "", models.MustParseMoney("7.25"), start, nil, "")
+		for _, rate := range []*models.TaxRate{country, state} {
+			if err := repo.CreateTaxRate(t.Context(), rate); err != nil {
+				t.Fatalf("create rate: %v", err)
+			}
+		}
+		rates, err := repo.GetApplicableTaxRates(t.Context(), models.TaxJurisdiction{Country: "US", Region: "CA"}, time.Now())
+		if err != nil || len(rates) != 2 {
+			t.Fatalf("applicable rates = %+v err=%v", rates, err)
+		}
+		if rates, err := repo.GetTaxRates(t.Context(), TaxRateFilter{Region: "ca"}); err != nil || len(rates) != 1 {
+			t.Errorf("filtered rates = %+v err=%v", rates, err)
+		}
+
+		customer := models.NewCustomer("Ada Lovelace", "ada@example.com", "", "")
+		customer.Country, customer.Region = "US", "CA"
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		if got, _ := customers.GetCustomerByID(t.Context(), customer.ID); got == nil || got.Jurisdiction() != (models.TaxJurisdiction{Country: "US", Region: "CA"}) {
+			t.Errorf("saved customer = %+v", got)
+		}
+		product := models.NewProduct("Drill", "", "DRL-1", "tools", models.MustParseMoney("80"), 100)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+
+		// 2 x 80 = 160 at 7.25% = 11.60 tax
+		item := models.NewOrderItem("", product.ID, 2, product.Price, models.BaseCurrency)
+		item.ApplyTax(product.TaxCategory, models.ResolveTaxRate(rates, customer.Jurisdiction(), product.TaxCategory, time.Now()), false, models.BaseCurrency)
+		order := models.NewOrder(customer.ID, item.TotalPrice)
+		order.TaxCountry, order.TaxRegion = "US", "CA"
+		item.OrderID = order.ID
+
+		// An order whose tax disagrees with its lines is refused
+		if _, err := orders.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, ""); !errors.Is(err, ErrOrderTotalMismatch) {
+			t.Errorf("mismatched tax: err = %v", err)
+		}
+		order.TaxAmount = models.OrderItemsTax([]*models.OrderItem{item})
+		if _, err := orders.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, ""); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+		saved, err := orders.GetOrderByID(t.Context(), order.ID)
+		if err != nil || saved == nil || saved.TaxAmount != models.MustParseMoney("11.6") || saved.TotalAmount != models.MustParseMoney("171.6") {
+			t.Fatalf("saved order: %+v err=%v", saved, err)
+		}
+		lines, err := orders.GetOrderItems(t.Context(), order.ID)
+		if err != nil || len(lines) != 1 || lines[0].TaxRateID == nil || *lines[0].TaxRateID != state.ID {
+			t.Fatalf("saved lines: %+v err=%v", lines, err)
+		}
+
+		// Cancelling a unit takes its tax off too: 80 at 7.25% = 5.80
+		if _, _, err := orders.CancelOrder(t.Context(), order.ID, map[string]int{lines[0].ID: 1}, "changed mind", ""); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		if saved, _ := orders.GetOrderByID(t.Context(), order.ID); saved == nil || saved.TaxAmount != models.MustParseMoney("5.8") || saved.TotalAmount != models.MustParseMoney("85.8") {
+			t.Errorf("order after partial cancel: %+v", saved)
+		}
+
+		summary, err := repo.GetTaxSummary(t.Context(), nil, nil)
+		if err != nil || len(summary) != 1 {
+			t.Fatalf("summary = %+v err=%v", summary, err)
+		}
+		if got := summary[0]; got.Region != "CA" || got.TaxRate != models.MustParseMoney("7.25") ||
+			got.TaxableAmount != models.MustParseMoney("80") || got.TaxAmount != models.MustParseMoney("5.8") || got.BaseTaxAmount != got.TaxAmount {
+			t.Errorf("summary line = %+v", got)
+		}
+		future := time.Now().Add(time.Hour)
+		if summary, err := repo.GetTaxSummary(t.Context(), &future, nil); err != nil || len(summary) != 0 {
+			t.Errorf("summary after the order = %+v err=%v", summary, err)
+		}
+	})
+}
+
+func TestInvoices(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		orders := NewOrderRepository(db)
+		repo := NewInvoiceRepository(db)
+
+		customer := models.NewCustomer("Ada Lovelace", "ada@example.com", "", "")
+		customer.PaymentTermsDays = 14
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		if got, _ := customers.GetCustomerByID(t.Context(), customer.ID); got == nil || got.PaymentTermsDays != 14 {
+			t.Errorf("saved customer = %+v", got)
+		}
+		product := models.NewProduct("Drill", "", "DRL-1", "tools", models.MustParseMoney("9.99"), 100)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+
+		// 3 x 9.99 = 29.97, less 2.00 off = 27.97
+		item := models.NewOrderItem("", product.ID, 3, product.Price, models.BaseCurrency)
+		item.DiscountAmount = models.MustParseMoney("2")
+		item.CalculateTotal(models.BaseCurrency)
+		order := models.NewOrder(customer.ID, item.TotalPrice)
+		item.OrderID = order.ID
+		if _, err := orders.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, ""); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+
+		// Nothing has shipped yet
+		draft := models.NewInvoice(order, customer.PaymentTermsDays, "", "")
+		if err := repo.CreateInvoice(t.Context(), draft, nil); !errors.Is(err, ErrInvalidInvoice) {
+			t.Errorf("invoice before shipping: err = %v", err)
+		}
+		for _, status := range []string{models.OrderStatusConfirmed, models.OrderStatusPicking, models.OrderStatusShipped} {
+			if _, err := orders.TransitionOrderStatus(t.Context(), order.ID, status, "", ""); err != nil {
+				t.Fatalf("transition to %s: %v", status, err)
+			}
+		}
+
+		// Bill one unit, then the other two
+		first := models.NewInvoice(order, customer.PaymentTermsDays, "", "")
+		if err := repo.CreateInvoice(t.Context(), first, map[string]int{item.ID: 1}); err != nil {
+			t.Fatalf("create first invoice: %v", err)
+		}
+		tooMany := models.NewInvoice(order, customer.PaymentTermsDays, "", "")
+		if err := repo.CreateInvoice(t.Context(), tooMany, map[string]int{item.ID: 3}); !errors.Is(err, ErrInvalidInvoice) {
+			t.Errorf("invoicing more than was shipped: err = %v", err)
+		}
+		second := models.NewInvoice(order, customer.PaymentTermsDays, "", "")
+		if err := repo.CreateInvoice(t.Context(), second, nil); err != nil {
+			t.Fatalf("create second invoice: %v", err)
+		}
+		if first.TotalAmount+second.TotalAmount != item.TotalPrice {
+			t.Errorf("invoices total %s + %s, order line is %s", first.TotalAmount, second.TotalAmount, item.TotalPrice)
+		}
+		if rest := models.NewInvoice(order, customer.PaymentTermsDays, "", ""); !errors.Is(repo.CreateInvoice(t.Context(), rest, nil), ErrInvalidInvoice) {
+			t.Error("invoiced the order a third time")
+		}
+
+		// Numbers follow on without gaps, in the order invoices are issued
+		issuedSecond, err := repo.IssueInvoice(t.Context(), second.ID, "", time.Now())
+		if err != nil || issuedSecond.InvoiceNumber != "INV-000001" || issuedSecond.BalanceDue != second.TotalAmount {
+			t.Fatalf("issue second: %+v err=%v", issuedSecond, err)
+		}
+		if _, err := repo.IssueInvoice(t.Context(), second.ID, "", time.Now()); !errors.Is(err, ErrInvalidInvoiceStatus) {
+			t.Errorf("issuing twice: err = %v", err)
+		}
+		issued, err := repo.IssueInvoice(t.Context(), first.ID, "", time.Now())
+		if err != nil || issued.InvoiceNumber != "INV-000002" {
+			t.Fatalf("issue first: %+v err=%v", issued, err)
+		}
+		if want := models.RateDate(time.Now()).AddDate(0, 0, 14); !issued.DueDate.Equal(want) {
+			t.Errorf("due %s, want %s", issued.DueDate, want)
+		}
+
+		// Voiding the first invoice frees its unit to be billed again
+		if _, err := repo.VoidInvoice(t.Context(), first.ID, "wrong address", ""); err != nil {
+			t.Fatalf("void: %v", err)
+		}
+		lines, err := orders.GetOrderItems(t.Context(), order.ID)
+		if err != nil || len(lines) != 1 || lines[0].InvoicedQuantity != 2 {
+			t.Fatalf("order lines after void: %+v err=%v", lines, err)
+		}
+
+		// Crediting one of the second invoice's two units leaves it partly settled
+		creditNote := models.NewCreditNote(issuedSecond, "damaged", "")
+		if err := repo.CreateCreditNote(t.Context(), creditNote, map[string]int{issuedSecond.Lines[0].ID: 1}); err != nil {
+			t.Fatalf("create credit note: %v", err)
+		}
+		if _, err := repo.VoidInvoice(t.Context(), second.ID, "", ""); !errors.Is(err, ErrInvalidInvoiceStatus) {
+			t.Errorf("voiding an invoice with a credit note: err = %v", err)
+		}
+		credited, err := repo.IssueInvoice(t.Context(), creditNote.ID, "", time.Now())
+		if err != nil || credited.InvoiceNumber != "CN-000001" || credited.CreditedInvoiceNumber != "INV-000001" {
+			t.Fatalf("issue credit note: %+v err=%v", credited, err)
+		}
+		invoice, err := repo.GetInvoiceByID(t.Context(), second.ID)
+		if err != nil || invoice == nil || invoice.Status != models.InvoiceStatusPartiallyPaid ||
+			invoice.BalanceDue != second.TotalAmount-creditNote.TotalAmount || invoice.Lines[0].CreditedQuantity != 1 {
+			t.Fatalf("credited invoice: %+v err=%v", invoice, err)
+		}
+		if creditNotes, err := repo.GetCreditNotes(t.Context(), second.ID); err != nil || len(creditNotes) != 1 {
+			t.Errorf("credit notes = %+v err=%v", creditNotes, err)
+		}
+
+		// Voiding the credit note puts the balance back
+		if _, err := repo.VoidInvoice(t.Context(), creditNote.ID, "raised in error", ""); err != nil {
+			t.Fatalf("void credit note: %v", err)
+		}
+		invoice, _ = repo.GetInvoiceByID(t.Context(), second.ID)
+		if invoice == nil || invoice.Status != models.InvoiceStatusIssued || invoice.BalanceDue != second.TotalAmount {
+			t.Errorf("invoice after voiding its credit note: %+v", invoice)
+		}
+
+		_, total, err := repo.GetInvoices(t.Context(), InvoiceFilter{CustomerID: customer.ID, Type: models.InvoiceTypeInvoice}, 1, 10)
+		if err != nil || total != 2 {
+			t.Errorf("customer invoices: total=%d err=%v", total, err)
+		}
+		now := time.Now()
+		if _, total, err := repo.GetInvoices(t.Context(), InvoiceFilter{OverdueOn: &now}, 1, 10); err != nil || total != 0 {
+			t.Errorf("overdue today: total=%d err=%v", total, err)
+		}
+		later := now.AddDate(0, 0, 15)
+		overdue, total, err := repo.GetInvoices(t.Context(), InvoiceFilter{OverdueOn: &later}, 1, 10)
+		if err != nil || total != 1 || overdue[0].ID != second.ID {
+			t.Errorf("overdue after the terms: %+v total=%d err=%v", overdue, total, err)
+		}
+	})
+}
+
+func TestPayments(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		customers := NewCustomerRepository(db)
+		orders := NewOrderRepository(db)
+		invoices := NewInvoiceRepository(db)
+		repo := NewPaymentRepository(db)
+
+		customer := models.NewCustomer("Ada Lovelace", "ada@example.com", "", "")
+		if err := customers.CreateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("create customer: %v", err)
+		}
+		product := models.NewProduct("Drill", "", "DRL-1", "tools", models.MustParseMoney("10"), 100)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		item := models.NewOrderItem("", product.ID, 3, product.Price, models.BaseCurrency)
+		order := models.NewOrder(customer.ID, item.TotalPrice)
+		item.OrderID = order.ID
+		if _, err := orders.CreateOrderWithItems(t.Context(), order, []*models.OrderItem{item}, models.AllocationOptions{}, ""); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+		for _, status := range []string{models.OrderStatusConfirmed, models.OrderStatusPicking, models.OrderStatusShipped} {
+			if _, err := orders.TransitionOrderStatus(t.Context(), order.ID, status, "", ""); err != nil {
+				t.Fatalf("transition to %s: %v", status, err)
+			}
+		}
+
+		// Two invoices of 10.00 and 20.00, the first due sooner
+		var issued []*models.Invoice
+		for i, units := range []int{1, 2} {
+			invoice := models.NewInvoice(order, 30*(i+1), "", "")
+			if err := invoices.CreateInvoice(t.Context(), invoice, map[string]int{item.ID: units}); err != nil {
+				t.Fatalf("create invoice: %v", err)
+			}
+			saved, err := invoices.IssueInvoice(t.Context(), invoice.ID, "", time.Now())
+			if err != nil {
+				t.Fatalf("issue invoice: %v", err)
+			}
+			issued = append(issued, saved)
+		}
+		first, second := issued[0], issued[1]
+
+		// Part of the first invoice
+		payment := models.NewPayment(customer.ID, models.PaymentMethodCheck, "CHK-1001", models.BaseCurrency, models.RateOne, models.MustParseMoney("4"), time.Now(), "", "")
+		if err := repo.CreatePayment(t.Context(), payment, map[string]models.Money{first.ID: models.MustParseMoney("4")}, false); err != nil {
+			t.Fatalf("create payment: %v", err)
+		}
+		if got, _ := invoices.GetInvoiceByID(t.Context(), first.ID); got == nil || got.Status != models.InvoiceStatusPartiallyPaid || got.BalanceDue != models.MustParseMoney("6") {
+			t.Errorf("first invoice after part payment: %+v", got)
+		}
+
+		// More than an invoice owes is refused
+		tooMuch := models.NewPayment(customer.ID, models.PaymentMethodCash, "", models.BaseCurrency, models.RateOne, models.MustParseMoney("50"), time.Now(), "", "")
+		if err := repo.CreatePayment(t.Context(), tooMuch, map[string]models.Money{first.ID: models.MustParseMoney("7")}, false); !errors.Is(err, ErrInvalidPayment) {
+			t.Errorf("allocating over the balance: err = %v", err)
+		}
+		if saved, _ := repo.GetPaymentByID(t.Context(), tooMuch.ID); saved != nil {
+			t.Errorf("refused payment was saved: %+v", saved)
+		}
+
+		// An over-payment settles both invoices oldest first and leaves credit
+		over := models.NewPayment(customer.ID, models.PaymentMethodBankTransfer, "", models.BaseCurrency, models.RateOne, models.MustParseMoney("30"), time.Now(), "", "")
+		if err := repo.CreatePayment(t.Context(), over, nil, true); err != nil {
+			t.Fatalf("create over-payment: %v", err)
+		}
+		saved, err := repo.GetPaymentByID(t.Context(), over.ID)
+		if err != nil || saved == nil || len(saved.Allocations) != 2 || saved.Unallocated != models.MustParseMoney("4") {
+			t.Fatalf("over-payment: %+v err=%v", saved, err)
+		}
+		for _, id := range []string{first.ID, second.ID} {
+			if got, _ := invoices.GetInvoiceByID(t.Context(), id); got == nil || got.Status != models.InvoiceStatusPaid || got.BalanceDue != 0 {
+				t.Errorf("invoice after over-payment: %+v", got)
+			}
+		}
+		if _, err := invoices.VoidInvoice(t.Context(), first.ID, "", ""); !errors.Is(err, ErrInvalidInvoiceStatus) {
+			t.Errorf("voiding a paid invoice: err = %v", err)
+		}
+
+		balances, err := repo.GetCustomerBalance(t.Context(), customer.ID, time.Now())
+		if err != nil || len(balances) != 1 {
+			t.Fatalf("balances = %+v err=%v", balances, err)
+		}
+		if b := balances[0]; b.InvoicedAmount != models.MustParseMoney("30") || b.PaidAmount != models.MustParseMoney("30") ||
+			b.UnallocatedAmount != models.MustParseMoney("4") || b.Balance != models.MustParseMoney("-4") {
+			t.Errorf("balance = %+v", b)
+		}
+
+		// Voiding the check puts 4.00 back on the first invoice, which the credit then covers
+		if _, err := repo.VoidPayment(t.Context(), payment.ID, "bounced", ""); err != nil {
+			t.Fatalf("void payment: %v", err)
+		}
+		if got, _ := invoices.GetInvoiceByID(t.Context(), first.ID); got == nil || got.Status != models.InvoiceStatusPartiallyPaid || got.BalanceDue != models.MustParseMoney("4") {
+			t.Errorf("first invoice after the check bounced: %+v", got)
+		}
+		if _, err := repo.VoidPayment(t.Context(), payment.ID, "", ""); !errors.Is(err, ErrInvalidPaymentStatus) {
+			t.Errorf("voiding twice: err = %v", err)
+		}
+
+		// Aging as of 45 days on: the first invoice is 15 days late, the credit offsets it
+		aging, err := repo.GetReceivablesAging(t.Context(), time.Now().AddDate(0, 0, 45))
+		if err != nil || len(aging) != 1 {
+			t.Fatalf("aging = %+v err=%v", aging, err)
+		}
+		if a := aging[0]; a.Days1To30 != models.MustParseMoney("4") || a.Total != a.Days1To30 ||
+			a.UnallocatedCredit != models.MustParseMoney("4") || a.Balance != 0 || a.CustomerName != customer.Name {
+			t.Errorf("aging line = %+v", a)
+		}
+
+		allocated, err := repo.AllocatePayment(t.Context(), over.ID, nil, true, "")
+		if err != nil || allocated.Unallocated != 0 || len(allocated.Allocations) != 3 {
+			t.Fatalf("allocate credit: %+v err=%v", allocated, err)
+		}
+		if _, err := repo.AllocatePayment(t.Context(), over.ID, nil, true, ""); !errors.Is(err, ErrInvalidPayment) {
+			t.Errorf("allocating a fully allocated payment: err = %v", err)
+		}
+		if got, _ := repo.GetInvoiceAllocations(t.Context(), first.ID); len(got) != 2 {
+			t.Errorf("first invoice allocations = %+v", got)
+		}
+		if aging, err := repo.GetReceivablesAging(t.Context(), time.Now()); err != nil || len(aging) != 0 {
+			t.Errorf("aging once settled = %+v err=%v", aging, err)
+		}
+
+		payments, total, err := repo.GetPayments(t.Context(), PaymentFilter{CustomerID: customer.ID, Status: models.PaymentStatusReceived}, 1, 10)
+		if err != nil || total != 1 || payments[0].ID != over.ID {
+			t.Errorf("received payments = %+v total=%d err=%v", payments, total, err)
+		}
+	})
+}
+
+func TestPurchaseOrders(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		suppliers := NewSupplierRepository(db)
+		repo := NewPurchaseOrderRepository(db)
+
+		supplier := models.NewSupplier("Acme", "ACME", "", "", "", "", "", models.DefaultSupplierPaymentTerms)
+		if err := suppliers.CreateSupplier(t.Context(), supplier); err != nil {
+			t.Fatalf("create supplier: %v", err)
+		}
+		bolt := models.NewProduct("Bolt", "", "BOLT-1", "", models.MustParseMoney("1"), 0)
+		if err := products.CreateProduct(t.Context(), bolt); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+
+		// Two lines of the same product, due 5 and 12 days after the order is sent
+		po := models.NewPurchaseOrder(supplier.ID, nil, "", "buyer")
+		for _, lead := range []int{5, 12} {
+			line := models.NewPurchaseOrderLine(po.ID, bolt.ID, "", 10, models.MustParseMoney("0.25"), models.BaseCurrency, lead)
+			po.Lines = append(po.Lines, *line)
+		}
+		po.ScheduleFrom(time.Now())
+		if err := repo.CreatePurchaseOrder(t.Context(), po); err != nil {
+			t.Fatalf("create purchase order: %v", err)
+		}
+
+		if err := repo.SendPurchaseOrder(t.Context(), po.ID); !errors.Is(err, ErrInvalidPurchaseOrderStatus) {
+			t.Errorf("send a draft: err = %v", err)
+		}
+		if err := repo.ApprovePurchaseOrder(t.Context(), po.ID, "manager"); err != nil {
+			t.Fatalf("approve: %v", err)
+		}
+		if err := repo.ApprovePurchaseOrder(t.Context(), po.ID, "manager"); !errors.Is(err, ErrInvalidPurchaseOrderStatus) {
+			t.Errorf("approve twice: err = %v", err)
+		}
+		sent := time.Now()
+		if err := repo.SendPurchaseOrder(t.Context(), po.ID); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+
+		saved, err := repo.GetPurchaseOrderByID(t.Context(), po.ID)
+		if err != nil || saved == nil || len(saved.Lines) != 2 {
+			t.Fatalf("get purchase order: %+v err=%v", saved, err)
+		}
+		if saved.Status != models.PurchaseOrderStatusSent || saved.ApprovedBy != "manager" || saved.ApprovedAt == nil || saved.SentAt == nil ||
+			saved.TotalAmount != models.MustParseMoney("5") {
+			t.Errorf("sent purchase order: %+v", saved)
+		}
+		// Expected on the latest line's date, counted from sending
+		if saved.ExpectedDate == nil || saved.ExpectedDate.Before(sent.AddDate(0, 0, 12).Add(-time.Minute)) {
+			t.Errorf("expected date %v, want 12 days after %v", saved.ExpectedDate, sent)
+		}
+
+		listed, err := repo.GetPurchaseOrders(t.Context(), models.PurchaseOrderStatusSent, supplier.ID)
+		if err != nil || len(listed) != 1 || listed[0].ID != po.ID {
+			t.Errorf("sent purchase orders of the supplier: %+v err=%v", listed, err)
+		}
+		if listed, _ := repo.GetPurchaseOrders(t.Context(), models.PurchaseOrderStatusDraft, ""); len(listed) != 0 {
+			t.Errorf("drafts: %+v", listed)
+		}
+
+		if err := repo.CancelPurchaseOrder(t.Context(), po.ID); err != nil {
+			t.Fatalf("cancel: %v", err)
+		}
+		if err := repo.ApprovePurchaseOrder(t.Context(), po.ID, ""); !errors.Is(err, ErrInvalidPurchaseOrderStatus) {
+			t.Errorf("approve a cancelled order: err = %v", err)
+		}
+		if err := repo.CancelPurchaseOrder(t.Context(), "missing"); !errors.Is(err, ErrPurchaseOrderNotFound) {
+			t.Errorf("cancel an unknown order: err = %v", err)
+		}
+	})
+}
+
+func TestPurchaseOrderReceipts(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		suppliers := NewSupplierRepository(db)
+		warehouses := NewWarehouseRepository(db)
+		repo := NewPurchaseOrderRepository(db)
+
+		supplier := models.NewSupplier("Acme", "ACME", "", "", "", "", "", models.DefaultSupplierPaymentTerms)
+		if err := suppliers.CreateSupplier(t.Context(), supplier); err != nil {
+			t.Fatalf("create supplier: %v", err)
+		}
+		bolt := models.NewProduct("Bolt", "", "BOLT-1", "", models.MustParseMoney("1"), 0)
+		if err := products.CreateProduct(t.Context(), bolt); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		var bins []*models.WarehouseLocation
+		for _, code := range []string{"WH-1", "WH-2"} {
+			warehouse := models.NewWarehouse(code, code, "", "", "", "", 1000)
+			if err := warehouses.CreateWarehouse(t.Context(), warehouse); err != nil {
+				t.Fatalf("create warehouse: %v", err)
+			}
+			location := models.NewWarehouseLocation(warehouse.ID, "A-1", "Aisle 1", "A", 1, 1, 50)
+			if err := warehouses.CreateLocation(t.Context(), location); err != nil {
+				t.Fatalf("create location: %v", err)
+			}
+			bins = append(bins, location)
+		}
+		warehouseID := bins[0].WarehouseID
+
+		po := models.NewPurchaseOrder(supplier.ID, &warehouseID, "", "")
+		line := models.NewPurchaseOrderLine(po.ID, bolt.ID, "", 30, models.MustParseMoney("0.25"), models.BaseCurrency, 5)
+		po.Lines = []models.PurchaseOrderLine{*line}
+		po.ScheduleFrom(time.Now())
+		if err := repo.CreatePurchaseOrder(t.Context(), po); err != nil {
+			t.Fatalf("create purchase order: %v", err)
+		}
+		receive := func(quantity int, location *models.WarehouseLocation) error {
+			receipt := models.NewPurchaseOrderReceipt(line, "", &location.ID, quantity, "", "clerk")
+			_, err := repo.ReceivePurchaseOrder(t.Context(), po.ID, "", []models.PurchaseOrderReceipt{*receipt}, false)
+			return err
+		}
+		status := func() string {
+			saved, _ := repo.GetPurchaseOrderByID(t.Context(), po.ID)
+			if saved == nil {
+				t.Fatal("purchase order not found")
+			}
+			return saved.Status
+		}
+
+		if err := receive(10, bins[0]); !errors.Is(err, ErrInvalidPurchaseOrderStatus) {
+			t.Errorf("receive against a draft: err = %v", err)
+		}
+		if err := repo.ApprovePurchaseOrder(t.Context(), po.ID, ""); err != nil {
+			t.Fatalf("approve: %v", err)
+		}
+		if err := repo.SendPurchaseOrder(t.Context(), po.ID); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+
+		// 20 put away at the location, 10 still to come
+		if err := receive(20, bins[0]); err != nil {
+			t.Fatalf("receive 20: %v", err)
+		}
+		if got := status(); got != models.PurchaseOrderStatusPartiallyReceived {
+			t.Errorf("status after receiving 20: %s", got)
+		}
+		if err := receive(15, bins[0]); !errors.Is(err, ErrInvalidPurchaseOrderReceipt) {
+			t.Errorf("receive more than outstanding: err = %v", err)
+		}
+		if err := receive(10, bins[1]); !errors.Is(err, ErrLocationNotInWarehouse) {
+			t.Errorf("put away in another warehouse: err = %v", err)
+		}
+		if err := receive(10, bins[0]); err != nil {
+			t.Fatalf("receive the rest: %v", err)
+		}
+		if got := status(); got != models.PurchaseOrderStatusReceived {
+			t.Errorf("status after receiving everything: %s", got)
+		}
+		if err := receive(1, bins[0]); !errors.Is(err, ErrInvalidPurchaseOrderStatus) {
+			t.Errorf("receive against a received order: err = %v", err)
+		}
+
+		receipts, err := repo.GetPurchaseOrderReceipts(t.Context(), po.ID)
+		if err != nil || len(receipts) != 2 || receipts[0].InventoryID == nil || receipts[0].ReceivedBy != "clerk" {
+			t.Fatalf("receipts: %+v err=%v", receipts, err)
+		}
+		inventory, _ := warehouses.GetInventoryByID(t.Context(), *receipts[0].InventoryID)
+		if inventory == nil || inventory.Quantity != 30 || inventory.LocationID == nil || *inventory.LocationID != bins[0].ID {
+			t.Errorf("inventory: %+v", inventory)
+		}
+		locations, err := warehouses.GetAvailableLocations(t.Context(), warehouseID)
+		if err != nil || len(locations) != 1 || locations[0].CurrentQuantity != 30 {
+			t.Errorf("location: %+v err=%v", locations, err)
+		}
+		if saved, _ := products.GetProductByID(t.Context(), bolt.ID); saved == nil || saved.Quantity != 30 {
+			t.Errorf("product: %+v", saved)
+		}
+	})
+}
+
+func TestReplenishmentSuggestions(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		suppliers := NewSupplierRepository(db)
+		warehouses := NewWarehouseRepository(db)
+		purchaseOrders := NewPurchaseOrderRepository(db)
+		repo := NewReplenishmentRepository(db)
+
+		acme := models.NewSupplier("Acme", "ACME", "", "", "", "", "", models.DefaultSupplierPaymentTerms)
+		beta := models.NewSupplier("Beta", "BETA", "", "", "", "", "", models.DefaultSupplierPaymentTerms)
+		for _, supplier := range []*models.Supplier{acme, beta} {
+			if err := suppliers.CreateSupplier(t.Context(), supplier); err != nil {
+				t.Fatalf("create supplier: %v", err)
+			}
+		}
+		warehouse := models.NewWarehouse("WH-1", "Main", "", "", "", "", 1000)
+		if err := warehouses.CreateWarehouse(t.Context(), warehouse); err != nil {
+			t.Fatalf("create warehouse: %v", err)
+		}
+		bolt := models.NewProduct("Bolt", "", "BOLT-1", "", models.MustParseMoney("1"), 3)
+		nut := models.NewProduct("Nut", "", "NUT-1", "", models.MustParseMoney("1"), 20)
+		for _, product := range []*models.Product{bolt, nut} {
+			if err := products.CreateProduct(t.Context(), product); err != nil {
+				t.Fatalf("create product: %v", err)
+			}
+		}
+		// Acme is the primary supplier of bolts even though Beta is cheaper
+		for _, link := range []*models.ProductSupplier{
+			models.NewProductSupplier(bolt.ID, acme.ID, "A-BOLT", models.MustParseMoney("0.5"), 7, true),
+			models.NewProductSupplier(bolt.ID, beta.ID, "B-BOLT", models.MustParseMoney("0.4"), 3, false),
+		} {
+			if err := suppliers.AddProductSupplier(t.Context(), link); err != nil {
+				t.Fatalf("link: %v", err)
+			}
+		}
+
+		// 3 bolts against a minimum of 10 and a maximum of 25; 20 nuts against a minimum of 5
+		maxBolts := 25
+		boltStock := models.NewInventory(bolt.ID, warehouse.ID, nil, 3, 10)
+		boltStock.MaxQuantity = &maxBolts
+		nutStock := models.NewInventory(nut.ID, warehouse.ID, nil, 20, 5)
+		for _, inventory := range []*models.Inventory{boltStock, nutStock} {
+			if err := warehouses.CreateInventory(t.Context(), inventory, ""); err != nil {
+				t.Fatalf("create inventory: %v", err)
+			}
+		}
+		// 5 bolts are already on order
+		po := models.NewPurchaseOrder(acme.ID, &warehouse.ID, "", "")
+		po.Lines = []models.PurchaseOrderLine{*models.NewPurchaseOrderLine(po.ID, bolt.ID, "", 5, models.MustParseMoney("0.5"), models.BaseCurrency, 7)}
+		po.ScheduleFrom(time.Now())
+		if err := purchaseOrders.CreatePurchaseOrder(t.Context(), po); err != nil {
+			t.Fatalf("create purchase order: %v", err)
+		}
+
+		suggestions, err := repo.GetSuggestions(t.Context(), ReplenishmentFilter{WarehouseID: warehouse.ID})
+		if err != nil || len(suggestions) != 1 {
+			t.Fatalf("suggestions: %+v err=%v", suggestions, err)
+		}
+		got := suggestions[0]
+		if got.ProductID != bolt.ID || got.OnOrderQuantity != 5 || got.SuggestedQuantity != 17 || got.SupplierID == nil ||
+			*got.SupplierID != acme.ID || got.SupplierSKU != "A-BOLT" || got.EstimatedCost != models.MustParseMoney("8.5") {
+			t.Errorf("bolt suggestion: %+v", got)
+		}
+
+		// Bolts are not bought from Beta, so there is nothing to suggest from it
+		suggestions, err = repo.GetSuggestions(t.Context(), ReplenishmentFilter{SupplierID: beta.ID})
+		if err != nil || len(suggestions) != 0 {
+			t.Errorf("suggestions from Beta: %+v err=%v", suggestions, err)
+		}
+	})
+}
+
+func TestSupplierBills(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		suppliers := NewSupplierRepository(db)
+		warehouses := NewWarehouseRepository(db)
+		purchaseOrders := NewPurchaseOrderRepository(db)
+		repo := NewSupplierBillRepository(db)
+
+		terms, _ := models.ParsePaymentTerms("2/10 net 30")
+		supplier := models.NewSupplier("Acme", "ACME", "", "", "", "", "", terms)
+		if err := suppliers.CreateSupplier(t.Context(), supplier); err != nil {
+			t.Fatalf("create supplier: %v", err)
+		}
+		warehouse := models.NewWarehouse("WH-1", "Main", "", "", "", "", 1000)
+		if err := warehouses.CreateWarehouse(t.Context(), warehouse); err != nil {
+			t.Fatalf("create warehouse: %v", err)
+		}
+		bolt := models.NewProduct("Bolt", "", "BOLT-1", "", models.MustParseMoney("1"), 3)
+		nut := models.NewProduct("Nut", "", "NUT-1", "", models.MustParseMoney("1"), 20)
+		for _, product := range []*models.Product{bolt, nut} {
+			if err := products.CreateProduct(t.Context(), product); err != nil {
+				t.Fatalf("create product: %v", err)
+			}
+		}
+		// The agreed cost of bolts is 0.50; nuts have none, so the order's cost is agreed
+		link := models.NewProductSupplier(bolt.ID, supplier.ID, "", models.MustParseMoney("0.5"), 5, true)
+		if err := suppliers.AddProductSupplier(t.Context(), link); err != nil {
+			t.Fatalf("link: %v", err)
+		}
+
+		// 100 bolts and 50 nuts ordered; 80 bolts and all the nuts received
+		po := models.NewPurchaseOrder(supplier.ID, &warehouse.ID, "", "")
+		boltLine := models.NewPurchaseOrderLine(po.ID, bolt.ID, "", 100, models.MustParseMoney("0.5"), models.BaseCurrency, 5)
+		nutLine := models.NewPurchaseOrderLine(po.ID, nut.ID, "", 50, models.MustParseMoney("0.2"), models.BaseCurrency, 5)
+		po.Lines = []models.PurchaseOrderLine{*boltLine, *nutLine}
+		po.ScheduleFrom(time.Now())
+		if err := purchaseOrders.CreatePurchaseOrder(t.Context(), po); err != nil {
+			t.Fatalf("create purchase order: %v", err)
+		}
+		if err := purchaseOrders.ApprovePurchaseOrder(t.Context(), po.ID, ""); err != nil {
+			t.Fatalf("approve purchase order: %v", err)
+		}
+		if err := purchaseOrders.SendPurchaseOrder(t.Context(), po.ID); err != nil {
+			t.Fatalf("send purchase order: %v", err)
+		}
+		receipts := []models.PurchaseOrderReceipt{
+			*models.NewPurchaseOrderReceipt(boltLine, "", nil, 80, "", ""),
+			*models.NewPurchaseOrderReceipt(nutLine, "", nil, 50, "", ""),
+		}
+		if _, err := purchaseOrders.ReceivePurchaseOrder(t.Context(), po.ID, "", receipts, false); err != nil {
+			t.Fatalf("receive purchase order: %v", err)
+		}
+
+		// Billed for 90 bolts at 0.55: ten more than received and 10% over the agreed cost
+		billDate := time.Now().AddDate(0, 0, -5)
+		bill := models.NewSupplierBill(po, "ACME-7001", supplier.PaymentTerms, billDate, models.RateOne, "", "")
+		bill.TaxAmount = models.MustParseMoney("5")
+		lines := map[string]BilledUnits{
+			boltLine.ID: {Quantity: 90, UnitCost: models.MustParseMoney("0.55")},
+			nutLine.ID:  {Quantity: 50},
+		}
+		if err := repo.CreateSupplierBill(t.Context(), bill, lines, models.MustParseMoney("5")); err != nil {
+			t.Fatalf("create bill: %v", err)
+		}
+		saved, err := repo.GetSupplierBillByID(t.Context(), bill.ID)
+		if err != nil || saved == nil {
+			t.Fatalf("get bill: %+v err=%v", saved, err)
+		}
+		if saved.MatchStatus != models.MatchStatusVariance || saved.TotalAmount != models.MustParseMoney("64.5") ||
+			!saved.DueDate.Equal(models.RateDate(billDate).AddDate(0, 0, 30)) || saved.DiscountDate == nil {
+			t.Errorf("saved bill: %+v", saved)
+		}
+		for _, line := range saved.Lines {
+			switch line.ProductID {
+			case bolt.ID:
+				if line.QuantityVariance != 10 || line.PriceVariance != models.MustParseMoney("0.05") || len(line.Variances) != 2 {
+					t.Errorf("bolt line: %+v", line)
+				}
+			case nut.ID:
+				if len(line.Variances) != 0 || line.AgreedUnitCost != models.MustParseMoney("0.2") {
+					t.Errorf("nut line: %+v", line)
+				}
+			}
+		}
+
+		// The supplier cannot enter the same bill number twice
+		duplicate := models.NewSupplierBill(po, "ACME-7001", supplier.PaymentTerms, billDate, models.RateOne, "", "")
+		if err := repo.CreateSupplierBill(t.Context(), duplicate, nil, 0); err == nil {
+			t.Error("duplicate bill number was accepted")
+		}
+
+		// Approval waits until the variances are accepted
+		if _, err := repo.ApproveSupplierBill(t.Context(), bill.ID, "", false); !errors.Is(err, ErrInvalidSupplierBill) {
+			t.Errorf("approving with variances: err = %v", err)
+		}
+		if _, err := repo.PaySupplierBill(t.Context(), models.NewSupplierPayment(bill.ID, models.PaymentMethodBankTransfer, "", models.MustParseMoney("1"), time.Now(), "")); !errors.Is(err, ErrInvalidSupplierBillStatus) {
+			t.Errorf("paying a draft: err = %v", err)
+		}
+		approved, err := repo.ApproveSupplierBill(t.Context(), bill.ID, "finance", true)
+		if err != nil || approved.Status != models.SupplierBillStatusApproved || !approved.VariancesAccepted || approved.BalanceDue != models.MustParseMoney("64.5") {
+			t.Fatalf("approve: %+v err=%v", approved, err)
+		}
+
+		aging, err := repo.GetPayablesAging(t.Context(), time.Now().AddDate(0, 0, 40))
+		if err != nil || len(aging) != 1 || aging[0].Days1To30 != models.MustParseMoney("64.5") || aging[0].Bills != 1 {
+			t.Errorf("aging: %+v err=%v", aging, err)
+		}
+
+		// Overpaying is refused; paying the rest less 2% within ten days settles the bill
+		if _, err := repo.PaySupplierBill(t.Context(), models.NewSupplierPayment(bill.ID, models.PaymentMethodBankTransfer, "", models.MustParseMoney("65"), time.Now(), "")); !errors.Is(err, ErrInvalidSupplierBill) {
+			t.Errorf("overpaying: err = %v", err)
+		}
+		if _, err := repo.PaySupplierBill(t.Context(), models.NewSupplierPayment(bill.ID, models.PaymentMethodBankTransfer, "", models.MustParseMoney("20"), time.Now(), "")); err != nil {
+			t.Fatalf("part payment: %v", err)
+		}
+		paid, err := repo.PaySupplierBill(t.Context(), models.NewSupplierPayment(bill.ID, models.PaymentMethodBankTransfer, "TRF-1", models.MustParseMoney("43.21"), time.Now(), ""))
+		if err != nil {
+			t.Fatalf("final payment: %v", err)
+		}
+		if paid.Status != models.SupplierBillStatusPaid || paid.DiscountTaken != models.MustParseMoney("1.29") || paid.BalanceDue != 0 || len(paid.Payments) != 2 {
+			t.Errorf("paid bill: %+v", paid)
+		}
+		if _, err := repo.VoidSupplierBill(t.Context(), bill.ID, "entered twice", ""); !errors.Is(err, ErrInvalidSupplierBillStatus) {
+			t.Errorf("voiding a paid bill: err = %v", err)
+		}
+		if aging, _ := repo.GetPayablesAging(t.Context(), time.Now()); len(aging) != 0 {
+			t.Errorf("aging after payment: %+v", aging)
+		}
+
+		// Everything received is billed; a bill for five more nuts is voided and frees them
+		nothingLeft := models.NewSupplierBill(po, "ACME-7002", supplier.PaymentTerms, time.Now(), models.RateOne, "", "")
+		if err := repo.CreateSupplierBill(t.Context(), nothingLeft, nil, 0); !errors.Is(err, ErrInvalidSupplierBill) {
+			t.Errorf("billing with nothing left: err = %v", err)
+		}
+		extra := models.NewSupplierBill(po, "ACME-7003", supplier.PaymentTerms, time.Now(), models.RateOne, "", "")
+		if err := repo.CreateSupplierBill(t.Context(), extra, map[string]BilledUnits{nutLine.ID: {Quantity: 5}}, 0); err != nil {
+			t.Fatalf("bill extra nuts: %v", err)
+		}
+		if extra.MatchStatus != models.MatchStatusVariance || extra.Lines[0].QuantityVariance != 5 {
+			t.Errorf("extra nuts bill: %+v", extra)
+		}
+		if voided, err := repo.VoidSupplierBill(t.Context(), extra.ID, "not delivered", ""); err != nil || voided.Status != models.SupplierBillStatusVoid {
+			t.Fatalf("void: %+v err=%v", voided, err)
+		}
+		order, err := purchaseOrders.GetPurchaseOrderByID(t.Context(), po.ID)
+		if err != nil {
+			t.Fatalf("get purchase order: %v", err)
+		}
+		for _, line := range order.Lines {
+			if line.ID == nutLine.ID && line.BilledQuantity != 50 {
+				t.Errorf("nuts billed after void = %d, want 50", line.BilledQuantity)
+			}
+		}
+	})
+}
+
+func TestCustomerRepository(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		repo := NewCustomerRepository(db)
+		audit := NewAuditRepository(db)
+		actor := &models.User{ID: "u1", Username: "clerk"}
+
+		customer := models.NewCustomer("Ada Lovelace", "ada@example.com", "5550000000", "London")
+		ctx := WithAudit(t.Context(), actor, models.AuditEntityCustomer, customer.ID, models.AuditActionCreate, nil, customer)
+		if err := repo.CreateCustomer(ctx, customer); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		duplicate := models.NewCustomer("Someone Else", "ada@example.com", "", "")
+		ctx = WithAudit(t.Context(), actor, models.AuditEntityCustomer, duplicate.ID, models.AuditActionCreate, nil, duplicate)
+		if err := repo.CreateCustomer(ctx, duplicate); err == nil {
+			t.Error("duplicate email was accepted")
+		}
+
+		// Only the change that was saved is in the audit log
+		entries, total, err := audit.GetAuditLogs(t.Context(), AuditLogFilter{EntityType: models.AuditEntityCustomer}, 1, 10)
+		if err != nil || total != 1 || entries[0].EntityID != customer.ID || entries[0].ActorName != "clerk" {
+			t.Errorf("audit log: %+v, total %d, err %v", entries, total, err)
+		}
+
+		customers, total, err := repo.GetCustomerWithPagination(t.Context(), 1, 10, "LOVELACE", "")
+		if err != nil || total != 1 || len(customers) != 1 {
+			t.Errorf("search: %d customers, total %d, err %v", len(customers), total, err)
+		}
+
+		customer.Address = "Marylebone"
+		if err := repo.UpdateCustomer(t.Context(), customer); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		got, err := repo.GetCustomerByID(t.Context(), customer.ID)
+		if err != nil || got == nil || got.Address != "Marylebone" {
+			t.Errorf("get after update: %+v err=%v", got, err)
+		}
+	})
+}
+
+func TestSupplierRepository(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		repo := NewSupplierRepository(db)
+
+		product := models.NewProduct("Bolt", "", "BOLT-1", "", models.MustParseMoney("1"), 1)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		supplier := models.NewSupplier("Acme", "ACME", "Wile", "acme@example.com", "", "", "", models.DefaultSupplierPaymentTerms)
+		if err := repo.CreateSupplier(t.Context(), supplier); err != nil {
+			t.Fatalf("create supplier: %v", err)
+		}
+
+		link := models.NewProductSupplier(product.ID, supplier.ID, "AC-BOLT", models.MustParseMoney("0.4"), 5, true)
+		if err := repo.AddProductSupplier(t.Context(), link); err != nil {
+			t.Fatalf("link: %v", err)
+		}
+		got, err := repo.GetProductSupplier(t.Context(), product.ID, supplier.ID)
+		if err != nil || got == nil || !got.IsPrimary || got.LeadTimeDays != 5 {
+			t.Fatalf("get link: %+v err=%v", got, err)
+		}
+		if byID, err := repo.GetProductSupplierByID(t.Context(), link.ID); err != nil || byID == nil || byID.SupplierSKU != "AC-BOLT" {
+			t.Errorf("get link by id: %+v err=%v", byID, err)
+		}
+
+		supplierProducts, err := repo.GetSupplierProducts(t.Context(), supplier.ID)
+		if err != nil || len(supplierProducts) != 1 || supplierProducts[0].ProductName.Name != "Bolt" {
+			t.Errorf("supplier products: %+v err=%v", supplierProducts, err)
+		}
+
+		if err := repo.RemoveProductSupplier(t.Context(), link.ID); err != nil {
+			t.Fatalf("unlink: %v", err)
+		}
+		if got, err := repo.GetProductSupplier(t.Context(), product.ID, supplier.ID); err != nil || got != nil {
+			t.Errorf("get after unlink: %+v err=%v", got, err)
+		}
+	})
+}
+
+func TestWarehouseRepository(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		repo := NewWarehouseRepository(db)
+
+		product := models.NewProduct("Crate", "", "CRATE-1", "", models.MustParseMoney("10"), 21)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		warehouse := models.NewWarehouse("WH-1", "Main", "", "", "", "", 1000)
+		if err := repo.CreateWarehouse(t.Context(), warehouse); err != nil {
+			t.Fatalf("create warehouse: %v", err)
+		}
+		location := models.NewWarehouseLocation(warehouse.ID, "A-1", "Aisle 1", "A", 1, 1, 50)
+		if err := repo.CreateLocation(t.Context(), location); err != nil {
+			t.Fatalf("create location: %v", err)
+		}
+
+		// Placing the product's existing stock in a warehouse does not count it again,
+		// and no more can be placed than the product has
+		if err := repo.CreateInventory(t.Context(), models.NewInventory(product.ID, warehouse.ID, &location.ID, 22, 5), ""); !errors.Is(err, ErrInsufficientStock) {
+			t.Errorf("place more than the product has: err = %v", err)
+		}
+		inventory := models.NewInventory(product.ID, warehouse.ID, &location.ID, 20, 5)
+		if err := repo.CreateInventory(t.Context(), inventory, ""); err != nil {
+			t.Fatalf("create inventory: %v", err)
+		}
+		if saved, _ := products.GetProductByID(t.Context(), product.ID); saved == nil || saved.Quantity != 21 {
+			t.Errorf("product after placing stock: %+v", saved)
+		}
+
+		locations, err := repo.GetAvailableLocations(t.Context(), warehouse.ID)
+		if err != nil || len(locations) != 1 || locations[0].CurrentQuantity != 20 {
+			t.Errorf("available locations: %+v err=%v", locations, err)
+		}
+
+		movement, err := repo.AdjustInventory(t.Context(), &models.InventoryAdjustment{
+			InventoryID: inventory.ID,
+			Mode:        models.AdjustmentModeSet,
+			Quantity:    12,
+			ReasonCode:  models.AdjustmentReasonCountCorrection,
+		})
+		if err != nil || movement == nil || movement.Quantity != -8 {
+			t.Fatalf("adjust: %+v err=%v", movement, err)
+		}
+		got, err := repo.GetInventoryByID(t.Context(), inventory.ID)
+		if err != nil || got == nil || got.Quantity != 12 {
+			t.Errorf("get after adjust: %+v err=%v", got, err)
+		}
+
+		// The product total follows the location: 21 - 8, then + 3
+		if err := repo.UpdateInventoryQuantity(t.Context(), inventory.ID, 15, 0); err != nil {
+			t.Fatalf("update quantity: %v", err)
+		}
+		if saved, _ := products.GetProductByID(t.Context(), product.ID); saved == nil || saved.Quantity != 16 {
+			t.Errorf("product after inventory changes: %+v", saved)
+		}
+
+		// A product total that has fallen out of step cannot be driven below zero
+		if err := products.UpdateProductQuantity(t.Context(), product.ID, 4); err != nil {
+			t.Fatalf("update product quantity: %v", err)
+		}
+		_, err = repo.AdjustInventory(t.Context(), &models.InventoryAdjustment{
+			InventoryID: inventory.ID,
+			Mode:        models.AdjustmentModeDelta,
+			Quantity:    -10,
+			ReasonCode:  models.AdjustmentReasonCountCorrection,
+		})
+		if !errors.Is(err, ErrInsufficientStock) {
+			t.Errorf("adjust below product stock: err = %v", err)
+		}
+		if got, _ := repo.GetInventoryByID(t.Context(), inventory.ID); got == nil || got.Quantity != 15 {
+			t.Errorf("inventory after failed adjust: %+v", got)
+		}
+
+		// Neither past the location's capacity nor below zero
+		_, err = repo.AdjustInventory(t.Context(), &models.InventoryAdjustment{
+			InventoryID: inventory.ID,
+			Mode:        models.AdjustmentModeDelta,
+			Quantity:    40,
+			ReasonCode:  models.AdjustmentReasonFound,
+		})
+		if !errors.Is(err, ErrLocationCapacityExceeded) {
+			t.Errorf("adjust past capacity: err = %v", err)
+		}
+		_, err = repo.AdjustInventory(t.Context(), &models.InventoryAdjustment{
+			InventoryID: inventory.ID,
+			Mode:        models.AdjustmentModeSet,
+			Quantity:    -1,
+			ReasonCode:  models.AdjustmentReasonCountCorrection,
+		})
+		if !errors.Is(err, ErrInvalidAdjustment) {
+			t.Errorf("adjust below zero: err = %v", err)
+		}
+
+		// A write-off keeps its reason and note, and the location and product follow it
+		movement, err = repo.AdjustInventory(t.Context(), &models.InventoryAdjustment{
+			InventoryID: inventory.ID,
+			Mode:        models.AdjustmentModeDelta,
+			Quantity:    -2,
+			ReasonCode:  models.AdjustmentReasonDamaged,
+			Note:        "dropped",
+		})
+		if err != nil || movement == nil || movement.MovementType != models.MovementTypeAdjustment || movement.Reason != "damaged: dropped" || movement.QuantityAfter != 13 {
+			t.Fatalf("write off: %+v err=%v", movement, err)
+		}
+		locations, err = repo.GetAvailableLocations(t.Context(), warehouse.ID)
+		if err != nil || len(locations) != 1 || locations[0].CurrentQuantity != 13 {
+			t.Errorf("location after write-off: %+v err=%v", locations, err)
+		}
+		if saved, _ := products.GetProductByID(t.Context(), product.ID); saved == nil || saved.Quantity != 2 {
+			t.Errorf("product after write-off: %+v", saved)
+		}
+	})
+}
+
+func TestTransferOrders(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		products := NewProductRepository(db)
+		warehouses := NewWarehouseRepository(db)
+		movements := NewStockMovementRepository(db)
+		repo := NewTransferRepository(db)
+
+		product := models.NewProduct("Crate", "", "CRATE-1", "", models.MustParseMoney("10"), 10)
+		if err := products.CreateProduct(t.Context(), product); err != nil {
+			t.Fatalf("create product: %v", err)
+		}
+		var sites []*models.Warehouse
+		var bins []*models.WarehouseLocation
+		for _, code := range []string{"WH-1", "WH-2"} {
+			warehouse := models.NewWarehouse(code, code, "", "", "", "", 1000)
+			if err := warehouses.CreateWarehouse(t.Context(), warehouse); err != nil {
+				t.Fatalf("create warehouse: %v", err)
+			}
+			location := models.NewWarehouseLocation(warehouse.ID, "A-1", "Aisle 1", "A", 1, 1, 50)
+			if err := warehouses.CreateLocation(t.Context(), location); err != nil {
+				t.Fatalf("create location: %v", err)
+			}
+			sites = append(sites, warehouse)
+			bins = append(bins, location)
+		}
+		source := models.NewInventory(product.ID, sites[0].ID, &bins[0].ID, 10, 0)
+		if err := warehouses.CreateInventory(t.Context(), source, ""); err != nil {
+			t.Fatalf("create inventory: %v", err)
+		}
+
+		if err := repo.CreateTransferOrder(t.Context(), models.NewTransferOrder(sites[0].ID, sites[0].ID, "", "")); !errors.Is(err, ErrSameTransferWarehouse) {
+			t.Errorf("same warehouse: err = %v", err)
+		}
+
+		transfer := models.NewTransferOrder(sites[0].ID, sites[1].ID, "", "")
+		line := models.NewTransferOrderLine(transfer.ID, product.ID, &bins[0].ID, &bins[1].ID, 6)
+		transfer.Lines = append(transfer.Lines, *line)
+		if err := repo.CreateTransferOrder(t.Context(), transfer); err != nil {
+			t.Fatalf("create transfer: %v", err)
+		}
+		receive := func(received, missing int) error {
+			_, err := repo.ReceiveTransferOrder(t.Context(), transfer.ID, []models.TransferReceipt{{
+				TransferOrderLineID: line.ID,
+				ReceivedQuantity:    received,
+				DiscrepancyQuantity: missing,
+				DiscrepancyReason:   "crushed",
+			}})
+			return err
+		}
+		if err := receive(6, 0); !errors.Is(err, ErrInvalidTransferStatus) {
+			t.Errorf("receive a draft: err = %v", err)
+		}
+
+		if err := repo.DispatchTransferOrder(t.Context(), transfer.ID, "clerk"); err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+		if err := repo.DispatchTransferOrder(t.Context(), transfer.ID, "clerk"); !errors.Is(err, ErrInvalidTransferStatus) {
+			t.Errorf("dispatch twice: err = %v", err)
+		}
+		if got, _ := warehouses.GetInventoryByID(t.Context(), source.ID); got == nil || got.Quantity != 4 {
+			t.Errorf("source after dispatch: %+v", got)
+		}
+
+		// 4 arrive and 1 is written off; 1 is still on its way
+		if err := receive(4, 1); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		if err := receive(2, 0); !errors.Is(err, ErrInvalidTransferReceipt) {
+			t.Errorf("receive more than in transit: err = %v", err)
+		}
+		got, err := repo.GetTransferOrderByID(t.Context(), transfer.ID)
+		if err != nil || got == nil || got.Status != models.TransferStatusInTransit || got.Lines[0].Outstanding() != 1 {
+			t.Fatalf("after partial receipt: %+v err=%v", got, err)
+		}
+		if err := receive(1, 0); err != nil {
+			t.Fatalf("receive rest: %v", err)
+		}
+		if got, _ := repo.GetTransferOrderByID(t.Context(), transfer.ID); got == nil || got.Status != models.TransferStatusReceived {
+			t.Errorf("after full receipt: %+v", got)
+		}
+
+		destination, err := warehouses.GetInventoryByWarehouse(t.Context(), sites[1].ID)
+		if err != nil || len(destination) != 1 || destination[0].Quantity != 5 {
+			t.Errorf("destination inventory: %+v err=%v", destination, err)
+		}
+		// The lost unit is gone from the product's total too, and the ledger says why
+		if saved, _ := products.GetProductByID(t.Context(), product.ID); saved == nil || saved.Quantity != 9 {
+			t.Errorf("product after write-off: %+v", saved)
+		}
+		ledger, _, err := movements.GetMovements(t.Context(), StockMovementFilter{
+			ProductID:     product.ID,
+			MovementType:  models.MovementTypeAdjustment,
+			ReferenceType: models.ReferenceTypeTransferOrder,
+		}, 1, 10)
+		if err != nil || len(ledger) != 1 || ledger[0].Quantity != -1 || ledger[0].WarehouseID != nil || ledger[0].QuantityAfter != 9 {
+			t.Errorf("write-off movement: %+v err=%v", ledger, err)
+		}
+
+		// A line without a source location draws on every location holding the product
+		spare := models.NewWarehouseLocation(sites[0].ID, "A-2", "Aisle 2", "A", 2, 1, 50)
+		if err := warehouses.CreateLocation(t.Context(), spare); err != nil {
+			t.Fatalf("create location: %v", err)
+		}
+		if err := products.UpdateProductQuantity(t.Context(), product.ID, 12); err != nil {
+			t.Fatalf("update product quantity: %v", err)
+		}
+		if err := warehouses.CreateInventory(t.Context(), models.NewInventory(product.ID, sites[0].ID, &spare.ID, 3, 0), ""); err != nil {
+			t.Fatalf("create inventory: %v", err)
+		}
+		spread := models.NewTransferOrder(sites[0].ID, sites[1].ID, "", "")
+		spread.Lines = append(spread.Lines, *models.NewTransferOrderLine(spread.ID, product.ID, nil, &bins[1].ID, 6))
+		if err := repo.CreateTransferOrder(t.Context(), spread); err != nil {
+			t.Fatalf("create spread transfer: %v", err)
+		}
+		if err := repo.DispatchTransferOrder(t.Context(), spread.ID, "clerk"); err != nil {
+			t.Fatalf("dispatch spread transfer: %v", err)
+		}
+		left, err := warehouses.GetInventoryByWarehouse(t.Context(), sites[0].ID)
+		if err != nil || len(left) != 2 || left[0].Quantity+left[1].Quantity != 1 {
+			t.Errorf("source after spread dispatch: %+v err=%v", left, err)
+		}
+		issued, _, err := movements.GetMovements(t.Context(), StockMovementFilter{
+			MovementType: models.MovementTypeTransfer,
+			ReferenceID:  spread.ID,
+		}, 1, 10)
+		if err != nil || len(issued) != 2 || issued[0].Quantity+issued[1].Quantity != -6 {
+			t.Errorf("spread dispatch movements: %+v err=%v", issued, err)
+		}
+
+		short := models.NewTransferOrder(sites[0].ID, sites[1].ID, "", "")
+		short.Lines = append(short.Lines, *models.NewTransferOrderLine(short.ID, product.ID, nil, nil, 2))
+		if err := repo.CreateTransferOrder(t.Context(), short); err != nil {
+			t.Fatalf("create short transfer: %v", err)
+		}
+		var insufficient *InsufficientStockError
+		if err := repo.DispatchTransferOrder(t.Context(), short.ID, "clerk"); !errors.As(err, &insufficient) || insufficient.Available != 1 {
+			t.Errorf("dispatch more than held: err = %v", err)
+		}
+	})
+}
+
+func TestUserRepositoryRoles(t *testing.T) {
+	dbtest.ForEachDialect(t, func(t *testing.T, db *database.Conn) {
+		repo := NewUserRepository(db)
+
+		user, err := models.NewUser("clerk", "clerk@example.com", "Clerk", "password123", []string{models.RoleSales})
+		if err != nil {
+			t.Fatalf("new user: %v", err)
+		}
+		if err := repo.CreateUser(t.Context(), user); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		user.Roles = []string{models.RoleSales, models.RoleWarehouse}
+		if err := repo.UpdateUser(t.Context(), user); err != nil {
+			t.Fatalf("update roles: %v", err)
+		}
+		got, err := repo.GetUserByUsername(t.Context(), "clerk")
+		if err != nil || got == nil || len(got.Roles) != 2 {
+			t.Fatalf("get: %+v err=%v", got, err)
+		}
+
+		count, err := repo.CountUsersWithRole(t.Context(), models.RoleWarehouse)
+		if err != nil || count != 1 {
+			t.Errorf("count with role: %d err=%v", count, err)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"erp-project/utils"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// stockExecutor is satisfied by both *database.Conn and *database.Tx, so movements can be written
+// inside the transaction that changes the stock
+type stockExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *database.Row
+}
+
+// StockMovementFilter narrows down the stock ledger. Empty fields are ignored.
+type StockMovementFilter struct {
+	ProductID     string
+	WarehouseID   string
+	LocationID    string
+	MovementType  string
+	ReferenceType string
+	ReferenceID   string
+	From          *time.Time
+	To            *time.Time
+}
+
+type StockMovementRepository struct {
+	DB *database.Conn
+}
+
+func NewStockMovementRepository(db *database.Conn) *StockMovementRepository {
+	return &StockMovementRepository{DB: db}
+}
+
+func (r *StockMovementRepository) GetMovements(ctx context.Context, filter StockMovementFilter, page, pageSize int) ([]models.StockMovement, int, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.ProductID != "" {
+		addClause("sm.product_id = $%d", filter.ProductID)
+	}
+	if filter.WarehouseID != "" {
+		addClause("sm.warehouse_id = $%d", filter.WarehouseID)
+	}
+	if filter.LocationID != "" {
+		addClause("sm.location_id = $%d", filter.LocationID)
+	}
+	if filter.MovementType != "" {
+		addClause("sm.movement_type = $%d", filter.MovementType)
+	}
+	if filter.ReferenceType != "" {
+		addClause("sm.reference_type = $%d", filter.ReferenceType)
+	}
+	if filter.ReferenceID != "" {
+		addClause("sm.reference_id = $%d", filter.ReferenceID)
+	}
+	if filter.From != nil {
+		addClause("sm.created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addClause("sm.created_at <= $%d", *filter.To)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	var total int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM stock_movements sm "+whereClause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT sm.id, sm.movement_type, sm.product_id, sm.warehouse_id, sm.location_id, sm.inventory_id,
+		       sm.quantity, sm.quantity_before, sm.quantity_after, sm.reference_type, sm.reference_id,
+		       sm.reason, sm.created_by, sm.created_at,
+		       p.name, p.sku, w.name, wl.location_code
+		FROM stock_movements sm
+		LEFT JOIN products p ON sm.product_id = p.id
+		LEFT JOIN warehouses w ON sm.warehouse_id = w.id
+		LEFT JOIN warehouse_locations wl ON sm.location_id = wl.id
+		%s
+		ORDER BY sm.created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, whereClause, len(args)+1, len(args)+2)
+	args = append(args, pageSize, utils.CalculateOffset(page, pageSize))
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	movements := []models.StockMovement{}
+	for rows.Next() {
+		var m models.StockMovement
+		var warehouseID, locationID, inventoryID sql.NullString
+		var referenceType, referenceID, reason, createdBy sql.NullString
+		var productName, sku, warehouseName, locationCode sql.NullString
+		err := rows.Scan(
+			&m.ID,
+			&m.MovementType,
+			&m.ProductID,
+			&warehouseID,
+			&locationID,
+			&inventoryID,
+			&m.Quantity,
+			&m.QuantityBefore,
+			&m.QuantityAfter,
+			&referenceType,
+			&referenceID,
+			&reason,
+			&createdBy,
+			&m.CreatedAt,
+			&productName,
+			&sku,
+			&warehouseName,
+			&locationCode,
+		)
+		if err != nil {
+			log.Printf("Error scanning stock movement: %v", err)
+			return nil, 0, err
+		}
+
+		if warehouseID.Valid {
+			m.WarehouseID = &warehouseID.String
+		}
+		if locationID.Valid {
+			m.LocationID = &locationID.String
+		}
+		if inventoryID.Valid {
+			m.InventoryID = &inventoryID.String
+		}
+		m.ReferenceType = referenceType.String
+		m.ReferenceID = referenceID.String
+		m.Reason = reason.String
+		m.CreatedBy = createdBy.String
+		m.ProductName = productName.String
+		m.SKU = sku.String
+		m.WarehouseName = warehouseName.String
+		m.LocationCode = locationCode.String
+
+		movements = append(movements, m)
+	}
+
+	return movements, total, rows.Err()
+}
+
+// GetStockAt rebuilds the stock of a product as it stood at the given time from the
+// ledger. Without a warehouse it returns products.quantity; with one it returns the
+// sum of that warehouse's inventory rows. Stock that predates the ledger is not seen.
+func (r *StockMovementRepository) GetStockAt(ctx context.Context, productID, warehouseID string, at time.Time) (int, error) {
+	var quantity int
+
+	if warehouseID == "" {
+		query := `
+			SELECT quantity_after FROM stock_movements
+			WHERE product_id = $1 AND warehouse_id IS NULL AND created_at <= $2
+			ORDER BY created_at DESC
+			LIMIT 1
+		`
+		err := r.DB.QueryRowContext(ctx, query, productID, at).Scan(&quantity)
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return quantity, err
+	}
+
+	// Latest movement of every inventory row of the product in the warehouse
+	query := `
+		SELECT COALESCE(SUM(sm.quantity_after), 0) FROM stock_movements sm
+		WHERE sm.product_id = $1 AND sm.warehouse_id = $2 AND sm.created_at <= $3
+		  AND sm.created_at = (
+		      SELECT MAX(latest.created_at) FROM stock_movements latest
+		      WHERE latest.inventory_id = sm.inventory_id AND latest.created_at <= $3
+		  )
+	`
+	err := r.DB.QueryRowContext(ctx, query, productID, warehouseID, at).Scan(&quantity)
+	return quantity, err
+}
+
+// recordProductMovement writes a ledger entry for a change to products.quantity that
+// has already been applied through exec
+func recordProductMovement(ctx context.Context, exec stockExecutor, movement *models.StockMovement) error {
+	var quantity int
+	err := exec.QueryRowContext(ctx, `SELECT quantity FROM products WHERE id = $1`, movement.ProductID).Scan(&quantity)
+	if err != nil {
+		return err
+	}
+
+	movement.QuantityAfter = quantity
+	movement.QuantityBefore = quantity - movement.Quantity
+	return insertStockMovement(ctx, exec, movement)
+}
+
+// applyToProductStock carries a change to an inventory row over to products.quantity
+// through exec and writes the product's own ledger entry for it, so the product total
+// keeps following the stock held in warehouses. inventoryMovement must already have
+// been recorded. A change that would take the product below zero fails with an
+// InsufficientStockError.
+func applyToProductStock(ctx context.Context, exec stockExecutor, inventoryMovement *models.StockMovement) error {
+	result, err := exec.ExecContext(ctx,
+		`UPDATE products SET quantity = quantity + $1 WHERE id = $2 AND quantity + $1 >= 0`,
+		inventoryMovement.Quantity, inventoryMovement.ProductID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		var available int
+		if err := exec.QueryRowContext(ctx, `SELECT quantity FROM products WHERE id = $1`, inventoryMovement.ProductID).Scan(&available); err != nil {
+			return err
+		}
+		return &InsufficientStockError{ProductID: inventoryMovement.ProductID, Available: available, Requested: -inventoryMovement.Quantity}
+	}
+
+	movement := models.NewStockMovement(inventoryMovement.MovementType, inventoryMovement.ProductID, inventoryMovement.Quantity,
+		inventoryMovement.ReferenceType, inventoryMovement.ReferenceID, inventoryMovement.Reason, inventoryMovement.CreatedBy)
+	return recordProductMovement(ctx, exec, movement)
+}
+
+// recordInventoryMovement writes a ledger entry for a change to an inventory row that
+// has already been applied through exec. The product, warehouse and location are
+// taken from the row.
+func recordInventoryMovement(ctx context.Context, exec stockExecutor, inventoryID string, movement *models.StockMovement) error {
+	var warehouseID string
+	var locationID sql.NullString
+	var quantity int
+	err := exec.QueryRowContext(ctx,
+		`SELECT product_id, warehouse_id, location_id, quantity FROM inventory WHERE id = $1`,
+		inventoryID,
+	).Scan(&movement.ProductID, &warehouseID, &locationID, &quantity)
+	if err != nil {
+		return err
+	}
+
+	movement.InventoryID = &inventoryID
+	movement.WarehouseID = &warehouseID
+	if locationID.Valid {
+		movement.LocationID = &locationID.String
+	}
+	movement.QuantityAfter = quantity
+	movement.QuantityBefore = quantity - movement.Quantity
+	return insertStockMovement(ctx, exec, movement)
+}
+
+func insertStockMovement(ctx context.Context, exec stockExecutor, movement *models.StockMovement) error {
+	query := `
+		INSERT INTO stock_movements (id, movement_type, product_id, warehouse_id, location_id, inventory_id,
+		                             quantity, quantity_before, quantity_after, reference_type, reference_id,
+		                             reason, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`
+	_, err := exec.ExecContext(ctx,
+		query,
+		movement.ID,
+		movement.MovementType,
+		movement.ProductID,
+		movement.WarehouseID,
+		movement.LocationID,
+		movement.InventoryID,
+		movement.Quantity,
+		movement.QuantityBefore,
+		movement.QuantityAfter,
+		movement.ReferenceType,
+		movement.ReferenceID,
+		movement.Reason,
+		movement.CreatedBy,
+		movement.CreatedAt,
+	)
+	if err != nil {
+		log.Printf("Error recording stock movement: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package repositories
+
+import (
+	"fmt"
+
+	"erp-project/database"
+)
+
+// Store bundles one implementation of every repository, which is all the ERP core needs
+// to serve requests
+type Store struct {
+	Products       ProductStore
+	Customers      CustomerStore
+	Orders         OrderStore
+	Suppliers      SupplierStore
+	Warehouses     WarehouseStore
+	StockMovements StockMovementStore
+	Transfers      TransferStore
+	PurchaseOrders PurchaseOrderStore
+	Replenishment  ReplenishmentStore
+	ExchangeRates  ExchangeRateStore
+	PriceLists     PriceListStore
+	Promotions     PromotionStore
+	Taxes          TaxStore
+	Invoices       InvoiceStore
+	Payments       PaymentStore
+	SupplierBills  SupplierBillStore
+	Users          UserStore
+	Audit          AuditStore
+
+	db *database.Conn
+}
+
+// NewStore builds the SQL repositories on an open database connection
+func NewStore(db *database.Conn) *Store {
+	return &Store{
+		Products:       NewProductRepository(db),
+		Customers:      NewCustomerRepository(db),
+		Orders:         NewOrderRepository(db),
+		Suppliers:      NewSupplierRepository(db),
+		Warehouses:     NewWarehouseRepository(db),
+		StockMovements: NewStockMovementRepository(db),
+		Transfers:      NewTransferRepository(db),
+		PurchaseOrders: NewPurchaseOrderRepository(db),
+		Replenishment:  NewReplenishmentRepository(db),
+		ExchangeRates:  NewExchangeRateRepository(db),
+		PriceLists:     NewPriceListRepository(db),
+		Promotions:     NewPromotionRepository(db),
+		Taxes:          NewTaxRepository(db),
+		Invoices:       NewInvoiceRepository(db),
+		Payments:       NewPaymentRepository(db),
+		SupplierBills:  NewSupplierBillRepository(db),
+		Users:          NewUserRepository(db),
+		Audit:          NewAuditRepository(db),
+		db:             db,
+	}
+}
+
+// NewInMemoryStore returns a store backed by a private, fully migrated in-memory SQLite
+// database. Nothing is written to disk and no server is needed, so it suits handler
+// tests and tools that embed the ERP core. Call Close when done with it.
+func NewInMemoryStore() (*Store, error) {
+	db, err := database.Open(database.SQLite, ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate in-memory database: %w", err)
+	}
+	return NewStore(db), nil
+}
+
+// Close releases the database connection behind the store, if it has one
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
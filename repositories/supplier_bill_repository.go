@@ -0,0 +1,734 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"erp-project/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSupplierBillNotFound      = errors.New("supplier bill not found")
+	ErrInvalidSupplierBillStatus = errors.New("supplier bill is not in a valid status for this action")
+	ErrInvalidSupplierBill       = errors.New("invalid supplier bill")
+)
+
+// supplierBillColumns are the columns scanSupplierBill reads, in order, from
+// supplier_bills b joined to suppliers s and purchase_orders po
+const supplierBillColumns = `b.id, b.bill_number, b.supplier_id, b.purchase_order_id, b.status, b.match_status,
+	b.variances_accepted, b.currency, b.exchange_rate, b.payment_terms_net_days, b.payment_terms_discount_percent,
+	b.payment_terms_discount_days, b.bill_date, b.due_date, b.discount_date, b.subtotal_amount, b.tax_amount,
+	b.total_amount, b.base_total_amount, b.amount_paid, b.discount_taken, b.notes, b.void_reason, b.created_by,
+	b.approved_by, b.approved_at, b.voided_by, b.voided_at, b.created_at, b.updated_at, s.name, po.po_number`
+
+const supplierBillJoins = `
+	FROM supplier_bills b
+	LEFT JOIN suppliers s ON b.supplier_id = s.id
+	LEFT JOIN purchase_orders po ON b.purchase_order_id = po.id`
+
+// SupplierBillFilter narrows down supplier bill lists. Empty fields are ignored.
+type SupplierBillFilter struct {
+	SupplierID      string
+	PurchaseOrderID string
+	Status          string
+	MatchStatus     string
+	From            *time.Time // Bill date
+	To              *time.Time
+	OverdueOn       *time.Time // Approved bills with something left to pay that were due before this day
+}
+
+// BilledUnits are the units of a purchase order line a supplier bills, and what they
+// charge for each. A zero unit cost means the purchase order's.
+type BilledUnits struct {
+	Quantity int
+	UnitCost models.Money
+}
+
+type SupplierBillRepository struct {
+	DB *database.Conn
+}
+
+func NewSupplierBillRepository(db *database.Conn) *SupplierBillRepository {
+	return &SupplierBillRepository{DB: db}
+}
+
+// CreateSupplierBill saves a draft bill for received units of its purchase order,
+// matched three ways: against the order, the units received and the cost agreed with
+// the supplier. lines maps purchase order line IDs to the units billed; when it is empty
+// every received unit not yet billed is billed at the purchase order cost. A unit cost
+// more than tolerancePercent away from the agreed cost is a price variance.
+func (r *SupplierBillRepository) CreateSupplierBill(ctx context.Context, bill *models.SupplierBill, lines map[string]BilledUnits, tolerancePercent models.Money) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var supplierID, status string
+	err = tx.QueryRowContext(ctx,
+		`SELECT supplier_id, status FROM purchase_orders WHERE id = $1`,
+		bill.PurchaseOrderID,
+	).Scan(&supplierID, &status)
+	if err == sql.ErrNoRows {
+		return ErrPurchaseOrderNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading purchase order status: %v", err)
+		return err
+	}
+	if supplierID != bill.SupplierID {
+		return fmt.Errorf("%w: the purchase order is not from this supplier", ErrInvalidSupplierBill)
+	}
+	switch status {
+	case models.PurchaseOrderStatusSent, models.PurchaseOrderStatusPartiallyReceived,
+		models.PurchaseOrderStatusReceived, models.PurchaseOrderStatusClosed:
+	default:
+		return fmt.Errorf("%w: cannot bill a %s purchase order", ErrInvalidSupplierBill, status)
+	}
+
+	poLines, err := purchaseOrderLines(ctx, tx, bill.PurchaseOrderID)
+	if err != nil {
+		return err
+	}
+	byID := map[string]*models.PurchaseOrderLine{}
+	for i := range poLines {
+		byID[poLines[i].ID] = &poLines[i]
+	}
+	if len(lines) == 0 {
+		lines = map[string]BilledUnits{}
+		for _, line := range poLines {
+			if n := line.Unbilled(); n > 0 {
+				lines[line.ID] = BilledUnits{Quantity: n}
+			}
+		}
+		if len(lines) == 0 {
+			return fmt.Errorf("%w: every received unit of the purchase order is already billed", ErrInvalidSupplierBill)
+		}
+	}
+	for id := range lines {
+		if byID[id] == nil {
+			return fmt.Errorf("%w: line %s does not belong to this purchase order", ErrInvalidSupplierBill, id)
+		}
+	}
+
+	agreed, err := agreedUnitCosts(ctx, tx, bill.SupplierID, bill.Currency)
+	if err != nil {
+		return err
+	}
+
+	bill.Lines = nil
+	for _, poLine := range poLines {
+		billed, ok := lines[poLine.ID]
+		if !ok {
+			continue
+		}
+		if billed.Quantity <= 0 {
+			return fmt.Errorf("%w: quantity must be positive", ErrInvalidSupplierBill)
+		}
+		if billed.UnitCost < 0 {
+			return fmt.Errorf("%w: unit cost cannot be negative", ErrInvalidSupplierBill)
+		}
+		if billed.UnitCost == 0 {
+			billed.UnitCost = poLine.UnitCost
+		}
+		agreedCost, ok := agreed[poLine.ProductID]
+		if !ok {
+			agreedCost = poLine.UnitCost
+		}
+		bill.AddLine(byID[poLine.ID], billed.Quantity, billed.UnitCost, agreedCost, tolerancePercent)
+
+		// Only book the units while nobody else has billed any of the line since it was read
+		result, err := tx.ExecContext(ctx,
+			`UPDATE purchase_order_lines SET billed_quantity = billed_quantity + $1 WHERE id = $2 AND billed_quantity = $3`,
+			billed.Quantity,
+			poLine.ID,
+			poLine.BilledQuantity,
+		)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return fmt.Errorf("%w: line %s was billed by another request", ErrInvalidSupplierBill, poLine.ID)
+		}
+	}
+
+	if err := insertSupplierBill(ctx, tx, bill); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ApproveSupplierBill approves a draft bill for payment. A bill that does not match is
+// only approved when acceptVariances says someone has checked the differences.
+func (r *SupplierBillRepository) ApproveSupplierBill(ctx context.Context, id, approvedBy string, acceptVariances bool) (*models.SupplierBill, error) {
+	bill, err := getSupplierBill(ctx, r.DB, id)
+	if err != nil {
+		return nil, err
+	}
+	if bill.Status != models.SupplierBillStatusDraft {
+		return nil, fmt.Errorf("%w: only drafts can be approved, this one is %s", ErrInvalidSupplierBillStatus, bill.Status)
+	}
+	if bill.MatchStatus == models.MatchStatusVariance && !acceptVariances {
+		return nil, fmt.Errorf("%w: the bill does not match the purchase order and receipts; accept the variances to approve it", ErrInvalidSupplierBill)
+	}
+
+	now := time.Now()
+	result, err := r.DB.ExecContext(ctx,
+		`UPDATE supplier_bills SET status = $1, variances_accepted = $2, approved_by = $3, approved_at = $4, updated_at = $5
+		 WHERE id = $6 AND status = $7`,
+		models.SupplierBillStatusApproved,
+		bill.MatchStatus == models.MatchStatusVariance,
+		approvedBy,
+		now,
+		now,
+		id,
+		models.SupplierBillStatusDraft,
+	)
+	if err != nil {
+		log.Printf("Error approving supplier bill: %v", err)
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, fmt.Errorf("%w: the bill was changed by another request", ErrInvalidSupplierBillStatus)
+	}
+	return r.GetSupplierBillByID(ctx, id)
+}
+
+// VoidSupplierBill cancels a bill nothing has been paid against. Its units can be billed
+// again.
+func (r *SupplierBillRepository) VoidSupplierBill(ctx context.Context, id, reason, voidedBy string) (*models.SupplierBill, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bill, err := getSupplierBill(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !bill.Voidable() {
+		return nil, fmt.Errorf("%w: only drafts and unpaid bills can be voided, this one is %s", ErrInvalidSupplierBillStatus, bill.Status)
+	}
+
+	for _, line := range bill.Lines {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE purchase_order_lines SET billed_quantity = billed_quantity - $1 WHERE id = $2`,
+			line.Quantity,
+			line.PurchaseOrderLineID,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE supplier_bills SET status = $1, void_reason = $2, voided_by = $3, voided_at = $4, updated_at = $5
+		 WHERE id = $6 AND status = $7 AND amount_paid = 0`,
+		models.SupplierBillStatusVoid,
+		reason,
+		voidedBy,
+		now,
+		now,
+		id,
+		bill.Status,
+	)
+	if err != nil {
+		log.Printf("Error voiding supplier bill: %v", err)
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, fmt.Errorf("%w: the bill was changed by another request", ErrInvalidSupplierBillStatus)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetSupplierBillByID(ctx, id)
+}
+
+// PaySupplierBill records a payment against an approved bill. A payment that clears the
+// bill within its discount window also takes the early payment discount; paying more
+// than is due is refused.
+func (r *SupplierBillRepository) PaySupplierBill(ctx context.Context, payment *models.SupplierPayment) (*models.SupplierBill, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	bill, err := getSupplierBill(ctx, tx, payment.BillID)
+	if err != nil {
+		return nil, err
+	}
+	switch bill.Status {
+	case models.SupplierBillStatusApproved, models.SupplierBillStatusPartiallyPaid:
+	default:
+		return nil, fmt.Errorf("%w: cannot pay a %s bill", ErrInvalidSupplierBillStatus, bill.Status)
+	}
+	payment.Amount = models.CurrencyOf(bill.Currency).Round(payment.Amount)
+	if payment.Amount <= 0 {
+		return nil, fmt.Errorf("%w: the payment must be positive", ErrInvalidSupplierBill)
+	}
+	if payment.Amount > bill.BalanceDue {
+		return nil, fmt.Errorf("%w: the bill has %s %s left to pay", ErrInvalidSupplierBill, bill.BalanceDue, bill.Currency)
+	}
+	payment.SupplierID = bill.SupplierID
+	payment.Currency = bill.Currency
+	payment.DiscountTaken = bill.PaymentDiscount(payment.Amount, payment.PaymentDate)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO supplier_payments (id, bill_id, supplier_id, method, reference, currency, amount, discount_taken,
+		                               payment_date, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`,
+		payment.ID,
+		payment.BillID,
+		payment.SupplierID,
+		payment.Method,
+		payment.Reference,
+		payment.Currency,
+		payment.Amount,
+		payment.DiscountTaken,
+		payment.PaymentDate,
+		payment.CreatedBy,
+		payment.CreatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating supplier payment: %v", err)
+		return nil, err
+	}
+
+	previousPaid, previousDiscount := bill.AmountPaid, bill.DiscountTaken
+	bill.AmountPaid += payment.Amount
+	bill.DiscountTaken += payment.DiscountTaken
+	bill.Settle()
+
+	// Only settle while nobody else has paid the bill since it was read
+	result, err := tx.ExecContext(ctx,
+		`UPDATE supplier_bills SET amount_paid = $1, discount_taken = $2, status = $3, updated_at = $4
+		 WHERE id = $5 AND amount_paid = $6 AND discount_taken = $7`,
+		bill.AmountPaid,
+		bill.DiscountTaken,
+		bill.Status,
+		time.Now(),
+		bill.ID,
+		previousPaid,
+		previousDiscount,
+	)
+	if err != nil {
+		log.Printf("Error settling supplier bill: %v", err)
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return nil, fmt.Errorf("%w: the bill was paid by another request", ErrInvalidSupplierBillStatus)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.GetSupplierBillByID(ctx, bill.ID)
+}
+
+// GetSupplierBills lists bills without their lines, newest first
+func (r *SupplierBillRepository) GetSupplierBills(ctx context.Context, filter SupplierBillFilter, page, pageSize int) ([]models.SupplierBill, int, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.SupplierID != "" {
+		addClause("b.supplier_id = $%d", filter.SupplierID)
+	}
+	if filter.PurchaseOrderID != "" {
+		addClause("b.purchase_order_id = $%d", filter.PurchaseOrderID)
+	}
+	if filter.Status != "" {
+		addClause("b.status = $%d", filter.Status)
+	}
+	if filter.MatchStatus != "" {
+		addClause("b.match_status = $%d", filter.MatchStatus)
+	}
+	if filter.From != nil {
+		addClause("b.bill_date >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addClause("b.bill_date <= $%d", *filter.To)
+	}
+	if filter.OverdueOn != nil {
+		// Drafts are not owed yet, so this leaves approved bills with something to pay
+		addClause("b.status <> $%d", models.SupplierBillStatusDraft)
+		addClause("b.status <> $%d AND b.total_amount > b.amount_paid + b.discount_taken", models.SupplierBillStatusVoid)
+		addClause("b.due_date < $%d", models.RateDate(*filter.OverdueOn))
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	var total int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM supplier_bills b "+whereClause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT %s %s %s
+		ORDER BY b.bill_date DESC, b.created_at DESC
+		LIMIT $%d OFFSET $%d
+	`, supplierBillColumns, supplierBillJoins, whereClause, len(args)+1, len(args)+2)
+	args = append(args, pageSize, utils.CalculateOffset(page, pageSize))
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	bills := []models.SupplierBill{}
+	for rows.Next() {
+		bill, err := scanSupplierBill(rows)
+		if err != nil {
+			log.Printf("Error scanning supplier bill: %v", err)
+			return nil, 0, err
+		}
+		bills = append(bills, *bill)
+	}
+	return bills, total, rows.Err()
+}
+
+// GetSupplierBillByID returns a bill with its lines and payments
+func (r *SupplierBillRepository) GetSupplierBillByID(ctx context.Context, id string) (*models.SupplierBill, error) {
+	bill, err := getSupplierBill(ctx, r.DB, id)
+	if errors.Is(err, ErrSupplierBillNotFound) {
+		return nil, nil
+	}
+	return bill, err
+}
+
+// GetPayablesAging ages what is owed to every supplier as of a day, in the base currency
+// at each bill's rate. Only approved bills are owed; drafts are still being checked.
+func (r *SupplierBillRepository) GetPayablesAging(ctx context.Context, on time.Time) ([]models.PayablesAgingLine, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT b.supplier_id, s.name, b.due_date, b.exchange_rate, b.total_amount - b.amount_paid - b.discount_taken
+		FROM supplier_bills b
+		LEFT JOIN suppliers s ON b.supplier_id = s.id
+		WHERE b.status IN ($1, $2)
+	`, models.SupplierBillStatusApproved, models.SupplierBillStatusPartiallyPaid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := map[string]*models.PayablesAgingLine{}
+	for rows.Next() {
+		var supplierID string
+		var supplierName sql.NullString
+		var dueDate time.Time
+		var rate models.Rate
+		var due models.Money
+		if err := rows.Scan(&supplierID, &supplierName, &dueDate, &rate, &due); err != nil {
+			return nil, err
+		}
+		if due <= 0 {
+			continue
+		}
+		if lines[supplierID] == nil {
+			lines[supplierID] = &models.PayablesAgingLine{SupplierID: supplierID, SupplierName: supplierName.String}
+		}
+		lines[supplierID].Add(dueDate, on, models.BaseCurrency.Round(rate.ToBase(due)))
+		lines[supplierID].Bills++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]models.PayablesAgingLine, 0, len(lines))
+	for _, l := range lines {
+		result = append(result, *l)
+	}
+	models.SortPayablesAging(result)
+	return result, nil
+}
+
+// agreedUnitCosts maps products to the cost price agreed with the supplier, for those
+// priced in the bill's currency
+func agreedUnitCosts(ctx context.Context, q rowQuerier, supplierID, currency string) (map[string]models.Money, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT product_id, cost_price FROM product_suppliers WHERE supplier_id = $1 AND currency = $2 AND cost_price IS NOT NULL`,
+		supplierID,
+		currency,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	costs := map[string]models.Money{}
+	for rows.Next() {
+		var productID string
+		var cost models.Money
+		if err := rows.Scan(&productID, &cost); err != nil {
+			return nil, err
+		}
+		costs[productID] = cost
+	}
+	return costs, rows.Err()
+}
+
+func insertSupplierBill(ctx context.Context, tx *database.Tx, bill *models.SupplierBill) error {
+	query := `
+		INSERT INTO supplier_bills (id, bill_number, supplier_id, purchase_order_id, status, match_status,
+		                            variances_accepted, currency, exchange_rate, payment_terms_net_days,
+		                            payment_terms_discount_percent, payment_terms_discount_days, bill_date, due_date,
+		                            discount_date, subtotal_amount, tax_amount, total_amount, base_total_amount,
+		                            amount_paid, discount_taken, notes, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23,
+		        $24, $25)
+	`
+	_, err := tx.ExecContext(ctx,
+		query,
+		bill.ID,
+		bill.BillNumber,
+		bill.SupplierID,
+		bill.PurchaseOrderID,
+		bill.Status,
+		bill.MatchStatus,
+		bill.VariancesAccepted,
+		bill.Currency,
+		bill.ExchangeRate,
+		bill.PaymentTerms.NetDays,
+		bill.PaymentTerms.DiscountPercent,
+		bill.PaymentTerms.DiscountDays,
+		bill.BillDate,
+		bill.DueDate,
+		bill.DiscountDate,
+		bill.SubtotalAmount,
+		bill.TaxAmount,
+		bill.TotalAmount,
+		bill.BaseTotalAmount,
+		bill.AmountPaid,
+		bill.DiscountTaken,
+		bill.Notes,
+		bill.CreatedBy,
+		bill.CreatedAt,
+		bill.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating supplier bill: %v", err)
+		return err
+	}
+
+	for _, line := range bill.Lines {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO supplier_bill_lines (id, bill_id, purchase_order_line_id, product_id, quantity, unit_cost,
+			                                 total_cost, ordered_quantity, received_quantity, previously_billed_quantity,
+			                                 ordered_unit_cost, agreed_unit_cost, quantity_variance, price_variance)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		`,
+			line.ID,
+			line.BillID,
+			line.PurchaseOrderLineID,
+			line.ProductID,
+			line.Quantity,
+			line.UnitCost,
+			line.TotalCost,
+			line.OrderedQuantity,
+			line.ReceivedQuantity,
+			line.PreviouslyBilledQuantity,
+			line.OrderedUnitCost,
+			line.AgreedUnitCost,
+			line.QuantityVariance,
+			line.PriceVariance,
+		)
+		if err != nil {
+			log.Printf("Error creating supplier bill line: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// getSupplierBill loads a bill with its lines and payments, or ErrSupplierBillNotFound
+func getSupplierBill(ctx context.Context, q invoiceQuerier, id string) (*models.SupplierBill, error) {
+	query := `SELECT ` + supplierBillColumns + supplierBillJoins + ` WHERE b.id = $1`
+	bill, err := scanSupplierBill(q.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrSupplierBillNotFound
+	}
+	if err != nil {
+		log.Printf("Error getting supplier bill: %v", err)
+		return nil, err
+	}
+
+	if bill.Lines, err = supplierBillLines(ctx, q, id); err != nil {
+		return nil, err
+	}
+	if bill.Payments, err = supplierPayments(ctx, q, id); err != nil {
+		return nil, err
+	}
+	return bill, nil
+}
+
+func scanSupplierBill(row rowScanner) (*models.SupplierBill, error) {
+	bill := &models.SupplierBill{}
+	var notes, voidReason, createdBy, approvedBy, voidedBy, supplierName, poNumber sql.NullString
+	var discountDate, approvedAt, voidedAt sql.NullTime
+	err := row.Scan(
+		&bill.ID,
+		&bill.BillNumber,
+		&bill.SupplierID,
+		&bill.PurchaseOrderID,
+		&bill.Status,
+		&bill.MatchStatus,
+		&bill.VariancesAccepted,
+		&bill.Currency,
+		&bill.ExchangeRate,
+		&bill.PaymentTerms.NetDays,
+		&bill.PaymentTerms.DiscountPercent,
+		&bill.PaymentTerms.DiscountDays,
+		&bill.BillDate,
+		&bill.DueDate,
+		&discountDate,
+		&bill.SubtotalAmount,
+		&bill.TaxAmount,
+		&bill.TotalAmount,
+		&bill.BaseTotalAmount,
+		&bill.AmountPaid,
+		&bill.DiscountTaken,
+		&notes,
+		&voidReason,
+		&createdBy,
+		&approvedBy,
+		&approvedAt,
+		&voidedBy,
+		&voidedAt,
+		&bill.CreatedAt,
+		&bill.UpdatedAt,
+		&supplierName,
+		&poNumber,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if discountDate.Valid {
+		bill.DiscountDate = &discountDate.Time
+	}
+	if approvedAt.Valid {
+		bill.ApprovedAt = &approvedAt.Time
+	}
+	if voidedAt.Valid {
+		bill.VoidedAt = &voidedAt.Time
+	}
+	bill.Notes = notes.String
+	bill.VoidReason = voidReason.String
+	bill.CreatedBy = createdBy.String
+	bill.ApprovedBy = approvedBy.String
+	bill.VoidedBy = voidedBy.String
+	bill.SupplierName = supplierName.String
+	bill.PONumber = poNumber.String
+	bill.CalculateBalance()
+	return bill, nil
+}
+
+func supplierBillLines(ctx context.Context, q rowQuerier, billID string) ([]models.SupplierBillLine, error) {
+	query := `
+		SELECT bl.id, bl.bill_id, bl.purchase_order_line_id, bl.product_id, bl.quantity, bl.unit_cost, bl.total_cost,
+		       bl.ordered_quantity, bl.received_quantity, bl.previously_billed_quantity, bl.ordered_unit_cost,
+		       bl.agreed_unit_cost, bl.quantity_variance, bl.price_variance, p.name, p.sku
+		FROM supplier_bill_lines bl
+		LEFT JOIN products p ON bl.product_id = p.id
+		WHERE bl.bill_id = $1
+		ORDER BY p.name, bl.id
+	`
+	rows, err := q.QueryContext(ctx, query, billID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []models.SupplierBillLine{}
+	for rows.Next() {
+		var line models.SupplierBillLine
+		var productName, sku sql.NullString
+		err := rows.Scan(
+			&line.ID,
+			&line.BillID,
+			&line.PurchaseOrderLineID,
+			&line.ProductID,
+			&line.Quantity,
+			&line.UnitCost,
+			&line.TotalCost,
+			&line.OrderedQuantity,
+			&line.ReceivedQuantity,
+			&line.PreviouslyBilledQuantity,
+			&line.OrderedUnitCost,
+			&line.AgreedUnitCost,
+			&line.QuantityVariance,
+			&line.PriceVariance,
+			&productName,
+			&sku,
+		)
+		if err != nil {
+			return nil, err
+		}
+		line.ProductName = productName.String
+		line.SKU = sku.String
+		line.CalculateVariances()
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+func supplierPayments(ctx context.Context, q rowQuerier, billID string) ([]models.SupplierPayment, error) {
+	query := `
+		SELECT id, bill_id, supplier_id, method, reference, currency, amount, discount_taken, payment_date, created_by,
+		       created_at
+		FROM supplier_payments
+		WHERE bill_id = $1
+		ORDER BY payment_date, created_at
+	`
+	rows, err := q.QueryContext(ctx, query, billID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []models.SupplierPayment{}
+	for rows.Next() {
+		var payment models.SupplierPayment
+		var createdBy sql.NullString
+		err := rows.Scan(
+			&payment.ID,
+			&payment.BillID,
+			&payment.SupplierID,
+			&payment.Method,
+			&payment.Reference,
+			&payment.Currency,
+			&payment.Amount,
+			&payment.DiscountTaken,
+			&payment.PaymentDate,
+			&createdBy,
+			&payment.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		payment.CreatedBy = createdBy.String
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
@@ -1,35 +1,35 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"erp-project/database"
 	"erp-project/models"
-	"fmt"
 	"log"
-	"strings"
 	"time"
 )
 
 type SupplierRepository struct {
-	DB *sql.DB
+	DB *database.Conn
 }
 
-func NewSupplierRepository(db *sql.DB) *SupplierRepository {
+func NewSupplierRepository(db *database.Conn) *SupplierRepository {
 	return &SupplierRepository{DB: db}
 }
 
-func (r *SupplierRepository) CreateSupplier(supplier *models.Supplier) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+func (r *SupplierRepository) CreateSupplier(ctx context.Context, supplier *models.Supplier) error {
+	query := `INSERT INTO suppliers (id, name, code, contact_person, email, phone, address, tax_id, payment_terms_net_days,
+	                                payment_terms_discount_percent, payment_terms_discount_days, status, created_at, updated_at) 
+	         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
 
-	var query string
-	if isPostgreSQL {
-		query = `INSERT INTO suppliers (id, name, code, contact_person, email, phone, address, tax_id, payment_terms, status, created_at, updated_at) 
-		         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
-	} else {
-		query = `INSERT INTO suppliers (id, name, code, contact_person, email, phone, address, tax_id, payment_terms, status, created_at, updated_at) 
-		         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(
+	_, err = tx.ExecContext(ctx,
 		query,
 		supplier.ID,
 		supplier.Name,
@@ -39,7 +39,9 @@ func (r *SupplierRepository) CreateSupplier(supplier *models.Supplier) error {
 		supplier.Phone,
 		supplier.Address,
 		supplier.TaxID,
-		supplier.PaymentTerms,
+		supplier.PaymentTerms.NetDays,
+		supplier.PaymentTerms.DiscountPercent,
+		supplier.PaymentTerms.DiscountDays,
 		supplier.Status,
 		supplier.CreatedAt,
 		supplier.UpdatedAt,
@@ -49,14 +51,19 @@ func (r *SupplierRepository) CreateSupplier(supplier *models.Supplier) error {
 		log.Printf("Error creating supplier: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *SupplierRepository) GetAllSuppliers() ([]models.Supplier, error) {
-	query := `SELECT id, name, code, contact_person, email, phone, address, tax_id, payment_terms, status, created_at, updated_at 
+func (r *SupplierRepository) GetAllSuppliers(ctx context.Context) ([]models.Supplier, error) {
+	query := `SELECT id, name, code, contact_person, email, phone, address, tax_id, payment_terms_net_days,
+	                 payment_terms_discount_percent, payment_terms_discount_days, status, created_at, updated_at 
 	          FROM suppliers ORDER BY name`
 
-	rows, err := r.DB.Query(query)
+	rows, err := r.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +81,9 @@ func (r *SupplierRepository) GetAllSuppliers() ([]models.Supplier, error) {
 			&s.Phone,
 			&s.Address,
 			&s.TaxID,
-			&s.PaymentTerms,
+			&s.PaymentTerms.NetDays,
+			&s.PaymentTerms.DiscountPercent,
+			&s.PaymentTerms.DiscountDays,
 			&s.Status,
 			&s.CreatedAt,
 			&s.UpdatedAt,
@@ -89,19 +98,12 @@ func (r *SupplierRepository) GetAllSuppliers() ([]models.Supplier, error) {
 	return suppliers, nil
 }
 
-func (r *SupplierRepository) GetSupplierByID(id string) (*models.Supplier, error) {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `SELECT id, name, code, contact_person, email, phone, address, tax_id, payment_terms, status, created_at, updated_at 
-		         FROM suppliers WHERE id = $1`
-	} else {
-		query = `SELECT id, name, code, contact_person, email, phone, address, tax_id, payment_terms, status, created_at, updated_at 
-		         FROM suppliers WHERE id = ?`
-	}
+func (r *SupplierRepository) GetSupplierByID(ctx context.Context, id string) (*models.Supplier, error) {
+	query := `SELECT id, name, code, contact_person, email, phone, address, tax_id, payment_terms_net_days,
+	                 payment_terms_discount_percent, payment_terms_discount_days, status, created_at, updated_at 
+	         FROM suppliers WHERE id = $1`
 
-	row := r.DB.QueryRow(query, id)
+	row := r.DB.QueryRowContext(ctx, query, id)
 
 	var s models.Supplier
 	err := row.Scan(
@@ -113,7 +115,9 @@ func (r *SupplierRepository) GetSupplierByID(id string) (*models.Supplier, error
 		&s.Phone,
 		&s.Address,
 		&s.TaxID,
-		&s.PaymentTerms,
+		&s.PaymentTerms.NetDays,
+		&s.PaymentTerms.DiscountPercent,
+		&s.PaymentTerms.DiscountDays,
 		&s.Status,
 		&s.CreatedAt,
 		&s.UpdatedAt,
@@ -130,21 +134,20 @@ func (r *SupplierRepository) GetSupplierByID(id string) (*models.Supplier, error
 	return &s, nil
 }
 
-func (r *SupplierRepository) UpdateSupplier(supplier *models.Supplier) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `UPDATE suppliers SET name = $1, code = $2, contact_person = $3, email = $4, phone = $5, 
-		         address = $6, tax_id = $7, payment_terms = $8, status = $9, updated_at = $10 
-		         WHERE id = $11`
-	} else {
-		query = `UPDATE suppliers SET name = ?, code = ?, contact_person = ?, email = ?, phone = ?, 
-		         address = ?, tax_id = ?, payment_terms = ?, status = ?, updated_at = ? 
-		         WHERE id = ?`
+func (r *SupplierRepository) UpdateSupplier(ctx context.Context, supplier *models.Supplier) error {
+	query := `UPDATE suppliers SET name = $1, code = $2, contact_person = $3, email = $4, phone = $5, 
+	         address = $6, tax_id = $7, payment_terms_net_days = $8, payment_terms_discount_percent = $9,
+	         payment_terms_discount_days = $10, status = $11, updated_at = $12 
+	         WHERE id = $13`
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(
+	_, err = tx.ExecContext(ctx,
 		query,
 		supplier.Name,
 		supplier.Code,
@@ -153,7 +156,9 @@ func (r *SupplierRepository) UpdateSupplier(supplier *models.Supplier) error {
 		supplier.Phone,
 		supplier.Address,
 		supplier.TaxID,
-		supplier.PaymentTerms,
+		supplier.PaymentTerms.NetDays,
+		supplier.PaymentTerms.DiscountPercent,
+		supplier.PaymentTerms.DiscountDays,
 		supplier.Status,
 		time.Now(),
 		supplier.ID,
@@ -163,47 +168,55 @@ func (r *SupplierRepository) UpdateSupplier(supplier *models.Supplier) error {
 		log.Printf("Error updating supplier: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *SupplierRepository) DeleteSupplier(id string) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+func (r *SupplierRepository) DeleteSupplier(ctx context.Context, id string) error {
+	query := `DELETE FROM suppliers WHERE id = $1`
 
-	var query string
-	if isPostgreSQL {
-		query = `DELETE FROM suppliers WHERE id = $1`
-	} else {
-		query = `DELETE FROM suppliers WHERE id = ?`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(query, id)
+	_, err = tx.ExecContext(ctx, query, id)
 	if err != nil {
 		log.Printf("Error deleting supplier: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // ProductSupplier methods
-func (r *SupplierRepository) AddProductSupplier(ps *models.ProductSupplier) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `INSERT INTO product_suppliers (id, product_id, supplier_id, supplier_sku, cost_price, lead_time_days, is_primary, created_at, updated_at) 
-		         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
-	} else {
-		query = `INSERT INTO product_suppliers (id, product_id, supplier_id, supplier_sku, cost_price, lead_time_days, is_primary, created_at, updated_at) 
-		         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+func (r *SupplierRepository) AddProductSupplier(ctx context.Context, ps *models.ProductSupplier) error {
+	query := `INSERT INTO product_suppliers (id, product_id, supplier_id, supplier_sku, cost_price, currency, lead_time_days, is_primary, created_at, updated_at) 
+	         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(
+	_, err = tx.ExecContext(ctx,
 		query,
 		ps.ID,
 		ps.ProductID,
 		ps.SupplierID,
 		ps.SupplierSKU,
 		ps.CostPrice,
+		ps.Currency,
 		ps.LeadTimeDays,
 		ps.IsPrimary,
 		ps.CreatedAt,
@@ -214,34 +227,24 @@ func (r *SupplierRepository) AddProductSupplier(ps *models.ProductSupplier) erro
 		log.Printf("Error adding product supplier: %v", err)
 		return err
 	}
-	return nil
-}
 
-func (r *SupplierRepository) GetProductSuppliers(productID string) ([]models.ProductSupplier, error) {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `SELECT ps.id, ps.product_id, ps.supplier_id, ps.supplier_sku, ps.cost_price, 
-		                ps.lead_time_days, ps.is_primary, ps.created_at, ps.updated_at,
-		                p.name as product_name, s.name as supplier_name
-		         FROM product_suppliers ps
-		         JOIN products p ON ps.product_id = p.id
-		         JOIN suppliers s ON ps.supplier_id = s.id
-		         WHERE ps.product_id = $1
-		         ORDER BY ps.is_primary DESC, s.name`
-	} else {
-		query = `SELECT ps.id, ps.product_id, ps.supplier_id, ps.supplier_sku, ps.cost_price, 
-		                ps.lead_time_days, ps.is_primary, ps.created_at, ps.updated_at,
-		                p.name as product_name, s.name as supplier_name
-		         FROM product_suppliers ps
-		         JOIN products p ON ps.product_id = p.id
-		         JOIN suppliers s ON ps.supplier_id = s.id
-		         WHERE ps.product_id = ?
-		         ORDER BY ps.is_primary DESC, s.name`
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
 
-	rows, err := r.DB.Query(query, productID)
+func (r *SupplierRepository) GetProductSuppliers(ctx context.Context, productID string) ([]models.ProductSupplier, error) {
+	query := `SELECT ps.id, ps.product_id, ps.supplier_id, ps.supplier_sku, ps.cost_price, ps.currency,
+	                ps.lead_time_days, ps.is_primary, ps.created_at, ps.updated_at,
+	                p.name as product_name, s.name as supplier_name
+	         FROM product_suppliers ps
+	         JOIN products p ON ps.product_id = p.id
+	         JOIN suppliers s ON ps.supplier_id = s.id
+	         WHERE ps.product_id = $1
+	         ORDER BY ps.is_primary DESC, s.name`
+
+	rows, err := r.DB.QueryContext(ctx, query, productID)
 	if err != nil {
 		return nil, err
 	}
@@ -250,54 +253,44 @@ func (r *SupplierRepository) GetProductSuppliers(productID string) ([]models.Pro
 	var productSuppliers []models.ProductSupplier
 	for rows.Next() {
 		var ps models.ProductSupplier
+		var productName, supplierName string
 		err := rows.Scan(
 			&ps.ID,
 			&ps.ProductID,
 			&ps.SupplierID,
 			&ps.SupplierSKU,
 			&ps.CostPrice,
+			&ps.Currency,
 			&ps.LeadTimeDays,
 			&ps.IsPrimary,
 			&ps.CreatedAt,
 			&ps.UpdatedAt,
-			&ps.ProductName,
-			&ps.SupplierName,
+			&productName,
+			&supplierName,
 		)
 		if err != nil {
 			log.Printf("Error scanning product supplier: %v", err)
 			continue
 		}
+		ps.ProductName = &models.Product{ID: ps.ProductID, Name: productName}
+		ps.SupplierName = &models.Supplier{ID: ps.SupplierID, Name: supplierName}
 		productSuppliers = append(productSuppliers, ps)
 	}
 
 	return productSuppliers, nil
 }
 
-func (r *SupplierRepository) GetSupplierProducts(supplierID string) ([]models.ProductSupplier, error) {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `SELECT ps.id, ps.product_id, ps.supplier_id, ps.supplier_sku, ps.cost_price, 
-		                ps.lead_time_days, ps.is_primary, ps.created_at, ps.updated_at,
-		                p.name as product_name, s.name as supplier_name
-		         FROM product_suppliers ps
-		         JOIN products p ON ps.product_id = p.id
-		         JOIN suppliers s ON ps.supplier_id = s.id
-		         WHERE ps.supplier_id = $1
-		         ORDER BY p.name`
-	} else {
-		query = `SELECT ps.id, ps.product_id, ps.supplier_id, ps.supplier_sku, ps.cost_price, 
-		                ps.lead_time_days, ps.is_primary, ps.created_at, ps.updated_at,
-		                p.name as product_name, s.name as supplier_name
-		         FROM product_suppliers ps
-		         JOIN products p ON ps.product_id = p.id
-		         JOIN suppliers s ON ps.supplier_id = s.id
-		         WHERE ps.supplier_id = ?
-		         ORDER BY p.name`
-	}
-
-	rows, err := r.DB.Query(query, supplierID)
+func (r *SupplierRepository) GetSupplierProducts(ctx context.Context, supplierID string) ([]models.ProductSupplier, error) {
+	query := `SELECT ps.id, ps.product_id, ps.supplier_id, ps.supplier_sku, ps.cost_price, ps.currency,
+	                ps.lead_time_days, ps.is_primary, ps.created_at, ps.updated_at,
+	                p.name as product_name, s.name as supplier_name
+	         FROM product_suppliers ps
+	         JOIN products p ON ps.product_id = p.id
+	         JOIN suppliers s ON ps.supplier_id = s.id
+	         WHERE ps.supplier_id = $1
+	         ORDER BY p.name`
+
+	rows, err := r.DB.QueryContext(ctx, query, supplierID)
 	if err != nil {
 		return nil, err
 	}
@@ -306,49 +299,98 @@ func (r *SupplierRepository) GetSupplierProducts(supplierID string) ([]models.Pr
 	var productSuppliers []models.ProductSupplier
 	for rows.Next() {
 		var ps models.ProductSupplier
+		var productName, supplierName string
 		err := rows.Scan(
 			&ps.ID,
 			&ps.ProductID,
 			&ps.SupplierID,
 			&ps.SupplierSKU,
 			&ps.CostPrice,
+			&ps.Currency,
 			&ps.LeadTimeDays,
 			&ps.IsPrimary,
 			&ps.CreatedAt,
 			&ps.UpdatedAt,
-			&ps.ProductName,
-			&ps.SupplierName,
+			&productName,
+			&supplierName,
 		)
 		if err != nil {
 			log.Printf("Error scanning supplier product: %v", err)
 			continue
 		}
+		ps.ProductName = &models.Product{ID: ps.ProductID, Name: productName}
+		ps.SupplierName = &models.Supplier{ID: ps.SupplierID, Name: supplierName}
 		productSuppliers = append(productSuppliers, ps)
 	}
 
 	return productSuppliers, nil
 }
 
-func (r *SupplierRepository) RemoveProductSupplier(id string) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+// GetProductSupplier returns the terms a supplier offers for a product, or nil when
+// the product is not linked to the supplier
+func (r *SupplierRepository) GetProductSupplier(ctx context.Context, productID, supplierID string) (*models.ProductSupplier, error) {
+	return r.getProductSupplier(ctx, "product_id = $1 AND supplier_id = $2", productID, supplierID)
+}
+
+// GetProductSupplierByID returns a product-supplier link, or nil when it does not exist
+func (r *SupplierRepository) GetProductSupplierByID(ctx context.Context, id string) (*models.ProductSupplier, error) {
+	return r.getProductSupplier(ctx, "id = $1", id)
+}
+
+func (r *SupplierRepository) getProductSupplier(ctx context.Context, where string, args ...interface{}) (*models.ProductSupplier, error) {
+	query := `SELECT id, product_id, supplier_id, supplier_sku, cost_price, currency, lead_time_days, is_primary, created_at, updated_at 
+	          FROM product_suppliers WHERE ` + where
+
+	var ps models.ProductSupplier
+	var supplierSKU sql.NullString
+	var costPrice sql.Null[models.Money]
+	var leadTimeDays sql.NullInt64
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(
+		&ps.ID,
+		&ps.ProductID,
+		&ps.SupplierID,
+		&supplierSKU,
+		&costPrice,
+		&ps.Currency,
+		&leadTimeDays,
+		&ps.IsPrimary,
+		&ps.CreatedAt,
+		&ps.UpdatedAt,
+	)
 
-	var query string
-	if isPostgreSQL {
-		query = `DELETE FROM product_suppliers WHERE id = $1`
-	} else {
-		query = `DELETE FROM product_suppliers WHERE id = ?`
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting product supplier: %v", err)
+		return nil, err
 	}
 
-	_, err := r.DB.Exec(query, id)
+	ps.SupplierSKU = supplierSKU.String
+	ps.CostPrice = costPrice.V
+	ps.LeadTimeDays = int(leadTimeDays.Int64)
+
+	return &ps, nil
+}
+
+func (r *SupplierRepository) RemoveProductSupplier(ctx context.Context, id string) error {
+	query := `DELETE FROM product_suppliers WHERE id = $1`
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, id)
 	if err != nil {
 		log.Printf("Error removing product supplier: %v", err)
 		return err
 	}
-	return nil
-}
 
-// Helper function to check database type
-func isPostgreSQL(db *sql.DB) bool {
-	driver := fmt.Sprintf("%T", db.Driver())
-	return strings.Contains(driver, "postgres")
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
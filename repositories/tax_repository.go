@@ -0,0 +1,304 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// TaxRateFilter narrows down tax rates. Empty fields are ignored.
+type TaxRateFilter struct {
+	Country     string
+	Region      string
+	TaxCategory string
+	ValidOn     *time.Time // Only active rates in effect on this day
+}
+
+type TaxRepository struct {
+	DB *database.Conn
+}
+
+func NewTaxRepository(db *database.Conn) *TaxRepository {
+	return &TaxRepository{DB: db}
+}
+
+func (r *TaxRepository) CreateTaxRate(ctx context.Context, rate *models.TaxRate) error {
+	query := `
+		INSERT INTO tax_rates (id, name, country, region, tax_category, rate, valid_from, valid_to, active,
+		                       created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		query,
+		rate.ID,
+		rate.Name,
+		rate.Country,
+		rate.Region,
+		rate.TaxCategory,
+		rate.Rate,
+		rate.ValidFrom,
+		rate.ValidTo,
+		rate.Active,
+		rate.CreatedBy,
+		rate.CreatedAt,
+		rate.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating tax rate: %v", err)
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateTaxRate saves a rate's name, validity and active flag. Where it applies and the
+// percentage do not change, since orders record the rate they were taxed at.
+func (r *TaxRepository) UpdateTaxRate(ctx context.Context, rate *models.TaxRate) error {
+	rate.UpdatedAt = time.Now()
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE tax_rates SET name = $1, valid_from = $2, valid_to = $3, active = $4, updated_at = $5 WHERE id = $6`,
+		rate.Name,
+		rate.ValidFrom,
+		rate.ValidTo,
+		rate.Active,
+		rate.UpdatedAt,
+		rate.ID,
+	)
+	if err != nil {
+		log.Printf("Error updating tax rate: %v", err)
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *TaxRepository) GetTaxRates(ctx context.Context, filter TaxRateFilter) ([]models.TaxRate, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.Country != "" {
+		addClause("country = $%d", models.NormalizeCountry(filter.Country))
+	}
+	if filter.Region != "" {
+		addClause("region = $%d", models.NormalizeRegion(filter.Region))
+	}
+	if filter.TaxCategory != "" {
+		addClause("tax_category = $%d", models.NormalizeTaxCategory(filter.TaxCategory))
+	}
+	if filter.ValidOn != nil {
+		day := models.RateDate(*filter.ValidOn)
+		addClause("active = $%d", true)
+		addClause("valid_from <= $%d", day)
+		addClause("(valid_to IS NULL OR valid_to >= $%d)", day)
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	query := `
+		SELECT id, name, country, region, tax_category, rate, valid_from, valid_to, active, created_by, created_at, updated_at
+		FROM tax_rates
+		` + whereClause + `
+		ORDER BY country, region, tax_category, valid_from DESC
+	`
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []models.TaxRate{}
+	for rows.Next() {
+		rate, err := scanTaxRate(rows)
+		if err != nil {
+			log.Printf("Error scanning tax rate: %v", err)
+			return nil, err
+		}
+		rates = append(rates, *rate)
+	}
+	return rates, rows.Err()
+}
+
+func (r *TaxRepository) GetTaxRateByID(ctx context.Context, id string) (*models.TaxRate, error) {
+	query := `
+		SELECT id, name, country, region, tax_category, rate, valid_from, valid_to, active, created_by, created_at, updated_at
+		FROM tax_rates
+		WHERE id = $1
+	`
+	rate, err := scanTaxRate(r.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting tax rate: %v", err)
+		return nil, err
+	}
+	return rate, nil
+}
+
+// GetApplicableTaxRates returns the active rates in effect on a day for a jurisdiction:
+// its country's and its region's, for every tax category
+func (r *TaxRepository) GetApplicableTaxRates(ctx context.Context, jurisdiction models.TaxJurisdiction, on time.Time) ([]models.TaxRate, error) {
+	day := models.RateDate(on)
+	query := `
+		SELECT id, name, country, region, tax_category, rate, valid_from, valid_to, active, created_by, created_at, updated_at
+		FROM tax_rates
+		WHERE country = $1 AND (region = '' OR region = $2)
+		  AND active = $3 AND valid_from <= $4 AND (valid_to IS NULL OR valid_to >= $4)
+	`
+	rows, err := r.DB.QueryContext(ctx, query, jurisdiction.Country, jurisdiction.Region, true, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []models.TaxRate{}
+	for rows.Next() {
+		rate, err := scanTaxRate(rows)
+		if err != nil {
+			log.Printf("Error scanning tax rate: %v", err)
+			return nil, err
+		}
+		rates = append(rates, *rate)
+	}
+	return rates, rows.Err()
+}
+
+// taxSummaryKey identifies one row of the tax summary
+type taxSummaryKey struct {
+	country, region, category string
+	rate                      models.Money
+	exempt                    bool
+	currency                  string
+}
+
+// GetTaxSummary totals the tax on order lines by jurisdiction, tax category, rate and
+// currency, for orders placed between from and to. Cancelled units carry no tax and
+// are left out. Each line is converted to the base currency at its order's rate.
+func (r *TaxRepository) GetTaxSummary(ctx context.Context, from, to *time.Time) ([]models.TaxSummaryLine, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	addClause := func(clause string, value interface{}) {
+		args = append(args, value)
+		whereClauses = append(whereClauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	addClause("oi.quantity > oi.cancelled_quantity AND o.status <> $%d", models.OrderStatusCancelled)
+	if from != nil {
+		addClause("o.order_date >= $%d", *from)
+	}
+	if to != nil {
+		addClause("o.order_date <= $%d", *to)
+	}
+
+	query := `
+		SELECT o.tax_country, o.tax_region, oi.tax_category, oi.tax_rate, o.tax_exempt, o.currency, o.exchange_rate,
+		       oi.total_price, oi.tax_amount
+		FROM order_items oi
+		JOIN orders o ON oi.order_id = o.id
+		WHERE ` + strings.Join(whereClauses, " AND ")
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := map[taxSummaryKey]*models.TaxSummaryLine{}
+	for rows.Next() {
+		var key taxSummaryKey
+		var exchangeRate models.Rate
+		var total, tax models.Money
+		err := rows.Scan(&key.country, &key.region, &key.category, &key.rate, &key.exempt, &key.currency, &exchangeRate, &total, &tax)
+		if err != nil {
+			return nil, err
+		}
+		line, ok := summary[key]
+		if !ok {
+			line = &models.TaxSummaryLine{
+				Country:     key.country,
+				Region:      key.region,
+				TaxCategory: key.category,
+				TaxRate:     key.rate,
+				Exempt:      key.exempt,
+				Currency:    key.currency,
+			}
+			summary[key] = line
+		}
+		line.Lines++
+		line.TaxableAmount += total - tax
+		line.TaxAmount += tax
+		line.BaseTaxableAmount += models.BaseCurrency.Round(exchangeRate.ToBase(total - tax))
+		line.BaseTaxAmount += models.BaseCurrency.Round(exchangeRate.ToBase(tax))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lines := make([]models.TaxSummaryLine, 0, len(summary))
+	for _, line := range summary {
+		lines = append(lines, *line)
+	}
+	models.SortTaxSummary(lines)
+	return lines, nil
+}
+
+func scanTaxRate(row rowScanner) (*models.TaxRate, error) {
+	rate := &models.TaxRate{}
+	var createdBy sql.NullString
+	var validTo sql.NullTime
+	err := row.Scan(
+		&rate.ID,
+		&rate.Name,
+		&rate.Country,
+		&rate.Region,
+		&rate.TaxCategory,
+		&rate.Rate,
+		&rate.ValidFrom,
+		&validTo,
+		&rate.Active,
+		&createdBy,
+		&rate.CreatedAt,
+		&rate.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if validTo.Valid {
+		rate.ValidTo = &validTo.Time
+	}
+	rate.CreatedBy = createdBy.String
+	return rate, nil
+}
@@ -0,0 +1,760 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTransferNotFound       = errors.New("transfer order not found")
+	ErrInvalidTransferStatus  = errors.New("transfer order is not in a valid status for this action")
+	ErrInvalidTransferReceipt = errors.New("invalid transfer receipt")
+	ErrLocationNotInWarehouse = errors.New("location does not belong to the warehouse")
+	ErrSameTransferWarehouse  = errors.New("source and destination warehouse must differ")
+)
+
+// rowQuerier is satisfied by both *database.Conn and *database.Tx
+type rowQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// rowScanner is satisfied by both *database.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type TransferRepository struct {
+	DB *database.Conn
+}
+
+func NewTransferRepository(db *database.Conn) *TransferRepository {
+	return &TransferRepository{DB: db}
+}
+
+// CreateTransferOrder saves a draft transfer with its lines. Line locations must
+// belong to the warehouse they are taken from or delivered to.
+func (r *TransferRepository) CreateTransferOrder(ctx context.Context, transfer *models.TransferOrder) error {
+	if transfer.SourceWarehouseID == transfer.DestinationWarehouseID {
+		return ErrSameTransferWarehouse
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO transfer_orders (id, source_warehouse_id, destination_warehouse_id, status, notes, created_by,
+		                             created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err = tx.ExecContext(ctx,
+		query,
+		transfer.ID,
+		transfer.SourceWarehouseID,
+		transfer.DestinationWarehouseID,
+		transfer.Status,
+		transfer.Notes,
+		transfer.CreatedBy,
+		transfer.CreatedAt,
+		transfer.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating transfer order: %v", err)
+		return err
+	}
+
+	lineQuery := `
+		INSERT INTO transfer_order_lines (id, transfer_order_id, product_id, source_location_id,
+		                                  destination_location_id, quantity, received_quantity, discrepancy_quantity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	for _, line := range transfer.Lines {
+		if line.SourceLocationID != nil {
+			if err := checkLocationInWarehouse(ctx, tx, *line.SourceLocationID, transfer.SourceWarehouseID); err != nil {
+				return err
+			}
+		}
+		if line.DestinationLocationID != nil {
+			if err := checkLocationInWarehouse(ctx, tx, *line.DestinationLocationID, transfer.DestinationWarehouseID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			lineQuery,
+			line.ID,
+			line.TransferOrderID,
+			line.ProductID,
+			line.SourceLocationID,
+			line.DestinationLocationID,
+			line.Quantity,
+			line.ReceivedQuantity,
+			line.DiscrepancyQuantity,
+		)
+		if err != nil {
+			log.Printf("Error creating transfer order line: %v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetTransferOrders lists transfer orders, optionally filtered by status and by a
+// warehouse on either end of the transfer
+func (r *TransferRepository) GetTransferOrders(ctx context.Context, status, warehouseID string) ([]models.TransferOrder, error) {
+	var whereClauses []string
+	var args []interface{}
+
+	if status != "" {
+		args = append(args, status)
+		whereClauses = append(whereClauses, fmt.Sprintf("t.status = $%d", len(args)))
+	}
+	if warehouseID != "" {
+		args = append(args, warehouseID)
+		whereClauses = append(whereClauses, fmt.Sprintf("(t.source_warehouse_id = $%d OR t.destination_warehouse_id = $%d)", len(args), len(args)))
+	}
+
+	whereClause := ""
+	if len(whereClauses) > 0 {
+		whereClause = "WHERE " + strings.Join(whereClauses, " AND ")
+	}
+
+	query := `
+		SELECT t.id, t.source_warehouse_id, t.destination_warehouse_id, t.status, t.notes, t.created_by,
+		       t.dispatched_at, t.received_at, t.created_at, t.updated_at, sw.name, dw.name
+		FROM transfer_orders t
+		JOIN warehouses sw ON t.source_warehouse_id = sw.id
+		JOIN warehouses dw ON t.destination_warehouse_id = dw.id
+		` + whereClause + `
+		ORDER BY t.created_at DESC
+	`
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []models.TransferOrder{}
+	for rows.Next() {
+		transfer, err := scanTransferOrder(rows)
+		if err != nil {
+			log.Printf("Error scanning transfer order: %v", err)
+			return nil, err
+		}
+		transfers = append(transfers, *transfer)
+	}
+	return transfers, rows.Err()
+}
+
+func (r *TransferRepository) GetTransferOrderByID(ctx context.Context, id string) (*models.TransferOrder, error) {
+	query := `
+		SELECT t.id, t.source_warehouse_id, t.destination_warehouse_id, t.status, t.notes, t.created_by,
+		       t.dispatched_at, t.received_at, t.created_at, t.updated_at, sw.name, dw.name
+		FROM transfer_orders t
+		JOIN warehouses sw ON t.source_warehouse_id = sw.id
+		JOIN warehouses dw ON t.destination_warehouse_id = dw.id
+		WHERE t.id = $1
+	`
+	transfer, err := scanTransferOrder(r.DB.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting transfer order: %v", err)
+		return nil, err
+	}
+
+	transfer.Lines, err = transferOrderLines(ctx, r.DB, id, transfer.Status)
+	if err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+func (r *TransferRepository) GetTransferReceipts(ctx context.Context, transferOrderID string) ([]models.TransferReceipt, error) {
+	query := `
+		SELECT id, transfer_order_id, transfer_order_line_id, product_id, location_id, destination_inventory_id,
+		       received_quantity, discrepancy_quantity, discrepancy_reason, received_by, received_at
+		FROM transfer_receipts
+		WHERE transfer_order_id = $1
+		ORDER BY received_at
+	`
+	rows, err := r.DB.QueryContext(ctx, query, transferOrderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []models.TransferReceipt{}
+	for rows.Next() {
+		var receipt models.TransferReceipt
+		var locationID, inventoryID, reason, receivedBy sql.NullString
+		err := rows.Scan(
+			&receipt.ID,
+			&receipt.TransferOrderID,
+			&receipt.TransferOrderLineID,
+			&receipt.ProductID,
+			&locationID,
+			&inventoryID,
+			&receipt.ReceivedQuantity,
+			&receipt.DiscrepancyQuantity,
+			&reason,
+			&receivedBy,
+			&receipt.ReceivedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if locationID.Valid {
+			receipt.LocationID = &locationID.String
+		}
+		if inventoryID.Valid {
+			receipt.DestinationInventoryID = &inventoryID.String
+		}
+		receipt.DiscrepancyReason = reason.String
+		receipt.ReceivedBy = receivedBy.String
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// DispatchTransferOrder takes every line's quantity out of free stock in the source
+// warehouse and puts the transfer in transit. A line without a source location is
+// taken from the inventory row with the most free stock.
+func (r *TransferRepository) DispatchTransferOrder(ctx context.Context, id, dispatchedBy string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var status, sourceWarehouseID string
+	err = tx.QueryRowContext(ctx, `SELECT status, source_warehouse_id FROM transfer_orders WHERE id = $1`, id).Scan(&status, &sourceWarehouseID)
+	if err == sql.ErrNoRows {
+		return ErrTransferNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading transfer order: %v", err)
+		return err
+	}
+	if status != models.TransferStatusDraft {
+		return ErrInvalidTransferStatus
+	}
+
+	lines, err := transferOrderLines(ctx, tx, id, status)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		stock, available, err := transferSourceStock(ctx, tx, line, sourceWarehouseID)
+		if err != nil {
+			return err
+		}
+		if available < line.Quantity {
+			return &InsufficientStockError{
+				ProductID: line.ProductID,
+				Available: available,
+				Requested: line.Quantity,
+			}
+		}
+
+		// Stock spread over several locations is issued fullest row first, with a
+		// movement for each row
+		remaining := line.Quantity
+		for _, row := range stock {
+			if remaining == 0 {
+				break
+			}
+			take := min(remaining, row.available)
+			movement := models.NewStockMovement(models.MovementTypeTransfer, line.ProductID, 0,
+				models.ReferenceTypeTransferOrder, id, "Transfer dispatched", dispatchedBy)
+			if err := issueInventoryStock(ctx, tx, row.inventoryID, take, movement); err != nil {
+				return err
+			}
+			remaining -= take
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE transfer_order_lines SET source_inventory_id = $1 WHERE id = $2`, stock[0].inventoryID, line.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE transfer_orders SET status = $1, dispatched_at = $2, updated_at = $3 WHERE id = $4 AND status = $5`,
+		models.TransferStatusInTransit,
+		now,
+		now,
+		id,
+		models.TransferStatusDraft,
+	)
+	if err != nil {
+		log.Printf("Error dispatching transfer order: %v", err)
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return ErrInvalidTransferStatus
+	}
+
+	return tx.Commit()
+}
+
+// ReceiveTransferOrder books deliveries against an in-transit transfer. Each receipt
+// credits the destination warehouse with the received quantity (at the receipt's
+// location, or the line's destination location) and writes off the discrepancy
+// quantity as lost in transit, taking it off the product's total stock. The transfer
+// is received once nothing is left in transit.
+func (r *TransferRepository) ReceiveTransferOrder(ctx context.Context, id string, receipts []models.TransferReceipt) ([]*models.TransferReceipt, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var status, destinationWarehouseID string
+	err = tx.QueryRowContext(ctx, `SELECT status, destination_warehouse_id FROM transfer_orders WHERE id = $1`, id).Scan(&status, &destinationWarehouseID)
+	if err == sql.ErrNoRows {
+		return nil, ErrTransferNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading transfer order: %v", err)
+		return nil, err
+	}
+	if status != models.TransferStatusInTransit {
+		return nil, ErrInvalidTransferStatus
+	}
+
+	lines, err := transferOrderLines(ctx, tx, id, status)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]*models.TransferOrderLine{}
+	for i := range lines {
+		byID[lines[i].ID] = &lines[i]
+	}
+
+	var recorded []*models.TransferReceipt
+	for _, input := range receipts {
+		line, ok := byID[input.TransferOrderLineID]
+		if !ok {
+			return nil, fmt.Errorf("%w: line %s does not belong to this transfer", ErrInvalidTransferReceipt, input.TransferOrderLineID)
+		}
+
+		total := input.ReceivedQuantity + input.DiscrepancyQuantity
+		if input.ReceivedQuantity < 0 || input.DiscrepancyQuantity < 0 || total == 0 || total > line.Outstanding() {
+			return nil, fmt.Errorf("%w: line %s has %d units in transit", ErrInvalidTransferReceipt, line.ID, line.Outstanding())
+		}
+
+		locationID := input.LocationID
+		if locationID == nil {
+			locationID = line.DestinationLocationID
+		}
+
+		receipt := models.NewTransferReceipt(line, locationID, input.ReceivedQuantity, input.DiscrepancyQuantity,
+			input.DiscrepancyReason, input.ReceivedBy)
+
+		if receipt.ReceivedQuantity > 0 {
+			movement := models.NewStockMovement(models.MovementTypeTransfer, line.ProductID, 0,
+				models.ReferenceTypeTransferOrder, id, "Transfer received", receipt.ReceivedBy)
+			inventoryID, err := receiveInventoryStock(ctx, tx, line.ProductID, destinationWarehouseID, locationID, receipt.ReceivedQuantity, movement)
+			if err != nil {
+				return nil, err
+			}
+			receipt.DestinationInventoryID = &inventoryID
+		}
+
+		// Units lost on the way left the source warehouse on dispatch but are still part
+		// of the product's total until they are written off here
+		if receipt.DiscrepancyQuantity > 0 {
+			result, err := tx.ExecContext(ctx,
+				`UPDATE products SET quantity = quantity - $1 WHERE id = $2 AND quantity >= $1`,
+				receipt.DiscrepancyQuantity, line.ProductID,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if n, err := result.RowsAffected(); err != nil {
+				return nil, err
+			} else if n == 0 {
+				var available int
+				if err := tx.QueryRowContext(ctx, `SELECT quantity FROM products WHERE id = $1`, line.ProductID).Scan(&available); err != nil {
+					return nil, err
+				}
+				return nil, &InsufficientStockError{ProductID: line.ProductID, Available: available, Requested: receipt.DiscrepancyQuantity}
+			}
+
+			note := "Lost in transit"
+			if receipt.DiscrepancyReason != "" {
+				note = receipt.DiscrepancyReason
+			}
+			movement := models.NewStockMovement(models.MovementTypeAdjustment, line.ProductID, -receipt.DiscrepancyQuantity,
+				models.ReferenceTypeTransferOrder, id, models.AdjustmentReasonLost+": "+note, receipt.ReceivedBy)
+			if err := recordProductMovement(ctx, tx, movement); err != nil {
+				return nil, err
+			}
+		}
+
+		// Only book the receipt while the line still has that much in transit
+		result, err := tx.ExecContext(ctx,
+			`UPDATE transfer_order_lines SET received_quantity = received_quantity + $1,
+			        discrepancy_quantity = discrepancy_quantity + $2
+			 WHERE id = $3 AND quantity - received_quantity - discrepancy_quantity >= $4`,
+			receipt.ReceivedQuantity,
+			receipt.DiscrepancyQuantity,
+			line.ID,
+			total,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return nil, fmt.Errorf("%w: line %s was received by another request", ErrInvalidTransferReceipt, line.ID)
+		}
+		line.ReceivedQuantity += receipt.ReceivedQuantity
+		line.DiscrepancyQuantity += receipt.DiscrepancyQuantity
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO transfer_receipts (id, transfer_order_id, transfer_order_line_id, product_id, location_id,
+			                                destination_inventory_id, received_quantity, discrepancy_quantity,
+			                                discrepancy_reason, received_by, received_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			receipt.ID,
+			receipt.TransferOrderID,
+			receipt.TransferOrderLineID,
+			receipt.ProductID,
+			receipt.LocationID,
+			receipt.DestinationInventoryID,
+			receipt.ReceivedQuantity,
+			receipt.DiscrepancyQuantity,
+			receipt.DiscrepancyReason,
+			receipt.ReceivedBy,
+			receipt.ReceivedAt,
+		)
+		if err != nil {
+			log.Printf("Error recording transfer receipt: %v", err)
+			return nil, err
+		}
+		recorded = append(recorded, receipt)
+	}
+
+	complete := true
+	for _, line := range lines {
+		if line.Outstanding() > 0 {
+			complete = false
+			break
+		}
+	}
+
+	now := time.Now()
+	if complete {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE transfer_orders SET status = $1, received_at = $2, updated_at = $3 WHERE id = $4`,
+			models.TransferStatusReceived,
+			now,
+			now,
+			id,
+		)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE transfer_orders SET updated_at = $1 WHERE id = $2`, now, id)
+	}
+	if err != nil {
+		log.Printf("Error updating transfer order: %v", err)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// CancelTransferOrder cancels a transfer that has not been dispatched yet
+func (r *TransferRepository) CancelTransferOrder(ctx context.Context, id string) error {
+	result, err := r.DB.ExecContext(ctx,
+		`UPDATE transfer_orders SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4`,
+		models.TransferStatusCancelled,
+		time.Now(),
+		id,
+		models.TransferStatusDraft,
+	)
+	if err != nil {
+		log.Printf("Error cancelling transfer order: %v", err)
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		var exists int
+		if err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM transfer_orders WHERE id = $1`, id).Scan(&exists); err == nil && exists == 0 {
+			return ErrTransferNotFound
+		}
+		return ErrInvalidTransferStatus
+	}
+	return nil
+}
+
+func scanTransferOrder(row rowScanner) (*models.TransferOrder, error) {
+	var t models.TransferOrder
+	var notes, createdBy sql.NullString
+	var dispatchedAt, receivedAt sql.NullTime
+	err := row.Scan(
+		&t.ID,
+		&t.SourceWarehouseID,
+		&t.DestinationWarehouseID,
+		&t.Status,
+		&notes,
+		&createdBy,
+		&dispatchedAt,
+		&receivedAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.SourceWarehouseName,
+		&t.DestinationWarehouseName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.Notes = notes.String
+	t.CreatedBy = createdBy.String
+	if dispatchedAt.Valid {
+		t.DispatchedAt = &dispatchedAt.Time
+	}
+	if receivedAt.Valid {
+		t.ReceivedAt = &receivedAt.Time
+	}
+	return &t, nil
+}
+
+func transferOrderLines(ctx context.Context, q rowQuerier, transferOrderID, status string) ([]models.TransferOrderLine, error) {
+	query := `
+		SELECT tl.id, tl.transfer_order_id, tl.product_id, tl.source_location_id, tl.destination_location_id,
+		       tl.source_inventory_id, tl.quantity, tl.received_quantity, tl.discrepancy_quantity, p.name, p.sku
+		FROM transfer_order_lines tl
+		LEFT JOIN products p ON tl.product_id = p.id
+		WHERE tl.transfer_order_id = $1
+		ORDER BY p.name
+	`
+	rows, err := q.QueryContext(ctx, query, transferOrderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []models.TransferOrderLine{}
+	for rows.Next() {
+		var line models.TransferOrderLine
+		var sourceLocationID, destinationLocationID, sourceInventoryID sql.NullString
+		var productName, sku sql.NullString
+		err := rows.Scan(
+			&line.ID,
+			&line.TransferOrderID,
+			&line.ProductID,
+			&sourceLocationID,
+			&destinationLocationID,
+			&sourceInventoryID,
+			&line.Quantity,
+			&line.ReceivedQuantity,
+			&line.DiscrepancyQuantity,
+			&productName,
+			&sku,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if sourceLocationID.Valid {
+			line.SourceLocationID = &sourceLocationID.String
+		}
+		if destinationLocationID.Valid {
+			line.DestinationLocationID = &destinationLocationID.String
+		}
+		if sourceInventoryID.Valid {
+			line.SourceInventoryID = &sourceInventoryID.String
+		}
+		line.ProductName = productName.String
+		line.SKU = sku.String
+		if status == models.TransferStatusInTransit || status == models.TransferStatusReceived {
+			line.InTransitQuantity = line.Outstanding()
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+func checkLocationInWarehouse(ctx context.Context, exec stockExecutor, locationID, warehouseID string) error {
+	var locationWarehouseID string
+	err := exec.QueryRowContext(ctx, `SELECT warehouse_id FROM warehouse_locations WHERE id = $1`, locationID).Scan(&locationWarehouseID)
+	if err == sql.ErrNoRows || (err == nil && locationWarehouseID != warehouseID) {
+		return fmt.Errorf("%w: %s", ErrLocationNotInWarehouse, locationID)
+	}
+	return err
+}
+
+// transferSourceStock lists the inventory rows a transfer line can be dispatched from,
+// fullest first, with their total free stock. A line with a source location only draws
+// on that location's row.
+func transferSourceStock(ctx context.Context, tx *database.Tx, line models.TransferOrderLine, warehouseID string) ([]inventoryStock, int, error) {
+	query := `
+		SELECT id, quantity - COALESCE(reserved_quantity, 0) FROM inventory
+		WHERE product_id = $1 AND warehouse_id = $2 AND quantity - COALESCE(reserved_quantity, 0) > 0
+	`
+	args := []interface{}{line.ProductID, warehouseID}
+	if line.SourceLocationID != nil {
+		query += ` AND location_id = $3`
+		args = append(args, *line.SourceLocationID)
+	}
+	query += ` ORDER BY quantity - COALESCE(reserved_quantity, 0) DESC`
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var stock []inventoryStock
+	available := 0
+	for rows.Next() {
+		var row inventoryStock
+		if err := rows.Scan(&row.inventoryID, &row.available); err != nil {
+			return nil, 0, err
+		}
+		stock = append(stock, row)
+		available += row.available
+	}
+	return stock, available, rows.Err()
+}
+
+// issueInventoryStock takes quantity out of the free stock of an inventory row and its
+// location, and records the movement
+func issueInventoryStock(ctx context.Context, tx *database.Tx, inventoryID string, quantity int, movement *models.StockMovement) error {
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE inventory SET quantity = quantity - $1, updated_at = $2
+		 WHERE id = $3 AND quantity - COALESCE(reserved_quantity, 0) >= $1`,
+		quantity,
+		now,
+		inventoryID,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return &InsufficientStockError{ProductID: movement.ProductID, Requested: quantity}
+	}
+
+	var locationID sql.NullString
+	if err := tx.QueryRowContext(ctx, `SELECT location_id FROM inventory WHERE id = $1`, inventoryID).Scan(&locationID); err != nil {
+		return err
+	}
+	if locationID.Valid {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE warehouse_locations SET current_quantity = current_quantity - $1, updated_at = $2 WHERE id = $3`,
+			quantity,
+			now,
+			locationID.String,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	movement.Quantity = -quantity
+	return recordInventoryMovement(ctx, tx, inventoryID, movement)
+}
+
+// receiveInventoryStock adds quantity to a product's inventory row for a warehouse and
+// location, creating the row when there is none, keeps the location's current quantity
+// in step and records the movement. It returns the inventory row's id.
+func receiveInventoryStock(ctx context.Context, tx *database.Tx, productID, warehouseID string, locationID *string, quantity int, movement *models.StockMovement) (string, error) {
+	now := time.Now()
+
+	if locationID != nil {
+		var locationWarehouseID string
+		var maxCapacity, currentQuantity int
+		err := tx.QueryRowContext(ctx,
+			`SELECT warehouse_id, COALESCE(max_capacity, 0), COALESCE(current_quantity, 0) FROM warehouse_locations WHERE id = $1`,
+			*locationID,
+		).Scan(&locationWarehouseID, &maxCapacity, &currentQuantity)
+		if err == sql.ErrNoRows || (err == nil && locationWarehouseID != warehouseID) {
+			return "", fmt.Errorf("%w: %s", ErrLocationNotInWarehouse, *locationID)
+		}
+		if err != nil {
+			return "", err
+		}
+		if maxCapacity > 0 && currentQuantity+quantity > maxCapacity {
+			return "", fmt.Errorf("%w: location holds %d of %d", ErrLocationCapacityExceeded, currentQuantity, maxCapacity)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE warehouse_locations SET current_quantity = current_quantity + $1, updated_at = $2 WHERE id = $3`,
+			quantity,
+			now,
+			*locationID,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var inventoryID string
+	var err error
+	if locationID != nil {
+		err = tx.QueryRowContext(ctx,
+			`SELECT id FROM inventory WHERE product_id = $1 AND warehouse_id = $2 AND location_id = $3`,
+			productID, warehouseID, *locationID,
+		).Scan(&inventoryID)
+	} else {
+		err = tx.QueryRowContext(ctx,
+			`SELECT id FROM inventory WHERE product_id = $1 AND warehouse_id = $2 AND location_id IS NULL`,
+			productID, warehouseID,
+		).Scan(&inventoryID)
+	}
+
+	if err == sql.ErrNoRows {
+		inventoryID = uuid.New().String()
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO inventory (id, product_id, warehouse_id, location_id, quantity, reserved_quantity,
+			                        min_quantity, last_restocked, created_at, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, 0, 0, $6, $7, $8)`,
+			inventoryID,
+			productID,
+			warehouseID,
+			locationID,
+			quantity,
+			now,
+			now,
+			now,
+		)
+	} else if err == nil {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE inventory SET quantity = quantity + $1, last_restocked = $2, updated_at = $3 WHERE id = $4`,
+			quantity,
+			now,
+			now,
+			inventoryID,
+		)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	movement.Quantity = quantity
+	if err := recordInventoryMovement(ctx, tx, inventoryID, movement); err != nil {
+		return "", err
+	}
+	return inventoryID, nil
+}
@@ -0,0 +1,451 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"erp-project/database"
+	"erp-project/models"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid, expired or revoked")
+	ErrAPIKeyNotFound      = errors.New("api key not found")
+)
+
+type UserRepository struct {
+	DB *database.Conn
+}
+
+func NewUserRepository(db *database.Conn) *UserRepository {
+	return &UserRepository{DB: db}
+}
+
+const userColumns = `id, username, email, password_hash, full_name, status, last_login_at, created_at, updated_at`
+
+// CreateUser saves a user together with their roles
+func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (id, username, email, password_hash, full_name, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	var email interface{}
+	if user.Email != "" {
+		email = user.Email
+	}
+	_, err = tx.ExecContext(ctx,
+		query,
+		user.ID,
+		user.Username,
+		email,
+		user.PasswordHash,
+		user.FullName,
+		user.Status,
+		user.CreatedAt,
+		user.UpdatedAt,
+	)
+	if err != nil {
+		log.Printf("Error creating user: %v", err)
+		return err
+	}
+
+	if err := insertUserRoles(ctx, tx, user.ID, user.Roles); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *UserRepository) GetUsers(ctx context.Context) ([]models.User, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			log.Printf("Error scanning user: %v", err)
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	roles, err := r.userRoles(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Roles = roles[users[i].ID]
+		if users[i].Roles == nil {
+			users[i].Roles = []string{}
+		}
+	}
+	return users, nil
+}
+
+func (r *UserRepository) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	return r.getUser(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id)
+}
+
+func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.getUser(ctx, `SELECT `+userColumns+` FROM users WHERE username = $1`, username)
+}
+
+func (r *UserRepository) getUser(ctx context.Context, query string, arg string) (*models.User, error) {
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, arg))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		return nil, err
+	}
+
+	roles, err := r.userRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.Roles = roles[user.ID]
+	if user.Roles == nil {
+		user.Roles = []string{}
+	}
+	return user, nil
+}
+
+// UpdateUser saves a user's details and replaces their roles
+func (r *UserRepository) UpdateUser(ctx context.Context, user *models.User) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users SET email = $1, password_hash = $2, full_name = $3, status = $4, updated_at = $5
+		WHERE id = $6
+	`
+	var email interface{}
+	if user.Email != "" {
+		email = user.Email
+	}
+	_, err = tx.ExecContext(ctx, query, email, user.PasswordHash, user.FullName, user.Status, user.UpdatedAt, user.ID)
+	if err != nil {
+		log.Printf("Error updating user: %v", err)
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1`, user.ID); err != nil {
+		log.Printf("Error clearing user roles: %v", err)
+		return err
+	}
+	if err := insertUserRoles(ctx, tx, user.ID, user.Roles); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *UserRepository) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+// CountUsersWithRole counts active users holding a role
+func (r *UserRepository) CountUsersWithRole(ctx context.Context, role string) (int, error) {
+	var count int
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM user_roles ur JOIN users u ON ur.user_id = u.id WHERE ur.role = $1 AND u.status = $2`,
+		role,
+		models.UserStatusActive,
+	).Scan(&count)
+	return count, err
+}
+
+func (r *UserRepository) RecordLogin(ctx context.Context, id string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE users SET last_login_at = $1 WHERE id = $2`, time.Now(), id)
+	return err
+}
+
+// SaveRefreshToken records a newly issued refresh token
+func (r *UserRepository) SaveRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	_, err := r.DB.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (id, user_id, expires_at, created_at) VALUES ($1, $2, $3, $4)`,
+		token.ID,
+		token.UserID,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		log.Printf("Error saving refresh token: %v", err)
+	}
+	return err
+}
+
+// RotateRefreshToken revokes a refresh token and records its replacement in one
+// transaction, so a refresh token can only ever be used once
+func (r *UserRepository) RotateRefreshToken(ctx context.Context, oldID string, replacement *models.RefreshToken) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeRefreshToken(ctx, tx, oldID, replacement.UserID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (id, user_id, expires_at, created_at) VALUES ($1, $2, $3, $4)`,
+		replacement.ID,
+		replacement.UserID,
+		replacement.ExpiresAt,
+		replacement.CreatedAt,
+	)
+	if err != nil {
+		log.Printf("Error saving refresh token: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeRefreshToken revokes an unexpired refresh token belonging to userID
+func (r *UserRepository) RevokeRefreshToken(ctx context.Context, id, userID string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeRefreshToken(ctx, tx, id, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func revokeRefreshToken(ctx context.Context, tx *database.Tx, id, userID string) error {
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = $1
+		 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL AND expires_at > $1`,
+		now,
+		id,
+		userID,
+	)
+	if err != nil {
+		log.Printf("Error revoking refresh token: %v", err)
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return ErrInvalidRefreshToken
+	}
+	return nil
+}
+
+func (r *UserRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, user_id, name, key_prefix, key_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := r.DB.ExecContext(ctx, query, key.ID, key.UserID, key.Name, key.KeyPrefix, key.KeyHash, key.ExpiresAt, key.CreatedAt)
+	if err != nil {
+		log.Printf("Error creating api key: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetAPIKeys lists API keys, optionally only those of one user
+func (r *UserRepository) GetAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	query := `
+		SELECT k.id, k.user_id, k.name, k.key_prefix, k.key_hash, k.last_used_at, k.expires_at, k.revoked_at,
+		       k.created_at, u.username
+		FROM api_keys k
+		JOIN users u ON k.user_id = u.id
+	`
+	var args []interface{}
+	if userID != "" {
+		query += ` WHERE k.user_id = $1`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY k.created_at DESC`
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			log.Printf("Error scanning api key: %v", err)
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *UserRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	query := `
+		SELECT k.id, k.user_id, k.name, k.key_prefix, k.key_hash, k.last_used_at, k.expires_at, k.revoked_at,
+		       k.created_at, u.username
+		FROM api_keys k
+		JOIN users u ON k.user_id = u.id
+		WHERE k.key_hash = $1
+	`
+	key, err := scanAPIKey(r.DB.QueryRowContext(ctx, query, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting api key: %v", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey revokes a key. With a userID only that user's keys can be revoked.
+func (r *UserRepository) RevokeAPIKey(ctx context.Context, id, userID string) error {
+	query := `UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`
+	args := []interface{}{time.Now(), id}
+	if userID != "" {
+		query += ` AND user_id = $3`
+		args = append(args, userID)
+	}
+
+	result, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error revoking api key: %v", err)
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) RecordAPIKeyUse(ctx context.Context, id string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, time.Now(), id)
+	return err
+}
+
+// userRoles returns role names keyed by user id, for one user or for everyone
+func (r *UserRepository) userRoles(ctx context.Context, userID string) (map[string][]string, error) {
+	query := `SELECT user_id, role FROM user_roles`
+	var args []interface{}
+	if userID != "" {
+		query += ` WHERE user_id = $1`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY role`
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := map[string][]string{}
+	for rows.Next() {
+		var id, role string
+		if err := rows.Scan(&id, &role); err != nil {
+			log.Printf("Error scanning user role: %v", err)
+			return nil, err
+		}
+		roles[id] = append(roles[id], role)
+	}
+	return roles, rows.Err()
+}
+
+func insertUserRoles(ctx context.Context, tx *database.Tx, userID string, roles []string) error {
+	now := time.Now()
+	query := `INSERT INTO user_roles (user_id, role, created_at) VALUES ($1, $2, $3) ` +
+		tx.Dialect.Upsert([]string{"user_id", "role"})
+	for _, role := range roles {
+		_, err := tx.ExecContext(ctx, query, userID, role, now)
+		if err != nil {
+			log.Printf("Error assigning user role: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	var email, fullName sql.NullString
+	var lastLoginAt sql.NullTime
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&email,
+		&user.PasswordHash,
+		&fullName,
+		&user.Status,
+		&lastLoginAt,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lastLoginAt.Valid {
+		user.LastLoginAt = &lastLoginAt.Time
+	}
+	user.Email = email.String
+	user.FullName = fullName.String
+	return &user, nil
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var lastUsedAt, expiresAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.KeyPrefix,
+		&key.KeyHash,
+		&lastUsedAt,
+		&expiresAt,
+		&revokedAt,
+		&key.CreatedAt,
+		&key.Username,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return &key, nil
+}
@@ -1,34 +1,44 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"erp-project/database"
 	"erp-project/models"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 )
 
+var (
+	ErrInventoryNotFound        = errors.New("inventory not found")
+	ErrInvalidAdjustment        = errors.New("invalid inventory adjustment")
+	ErrBelowReservedQuantity    = errors.New("quantity would fall below reserved quantity")
+	ErrLocationCapacityExceeded = errors.New("location capacity exceeded")
+)
+
 type WarehouseRepository struct {
-	DB *sql.DB
+	DB *database.Conn
 }
 
-func NewWarehouseRepository(db *sql.DB) *WarehouseRepository {
+func NewWarehouseRepository(db *database.Conn) *WarehouseRepository {
 	return &WarehouseRepository{DB: db}
 }
 
 // Warehouse CRUD
-func (r *WarehouseRepository) CreateWarehouse(warehouse *models.Warehouse) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+func (r *WarehouseRepository) CreateWarehouse(ctx context.Context, warehouse *models.Warehouse) error {
+	query := `INSERT INTO warehouses (id, code, name, location, manager_name, phone, email, capacity, latitude, longitude, status, created_at, updated_at) 
+	         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
 
-	var query string
-	if isPostgreSQL {
-		query = `INSERT INTO warehouses (id, code, name, location, manager_name, phone, email, capacity, status, created_at, updated_at) 
-		         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
-	} else {
-		query = `INSERT INTO warehouses (id, code, name, location, manager_name, phone, email, capacity, status, created_at, updated_at) 
-		         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(
+	_, err = tx.ExecContext(ctx,
 		query,
 		warehouse.ID,
 		warehouse.Code,
@@ -38,6 +48,8 @@ func (r *WarehouseRepository) CreateWarehouse(warehouse *models.Warehouse) error
 		warehouse.Phone,
 		warehouse.Email,
 		warehouse.Capacity,
+		warehouse.Latitude,
+		warehouse.Longitude,
 		warehouse.Status,
 		warehouse.CreatedAt,
 		warehouse.UpdatedAt,
@@ -47,14 +59,18 @@ func (r *WarehouseRepository) CreateWarehouse(warehouse *models.Warehouse) error
 		log.Printf("Error creating warehouse: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *WarehouseRepository) GetAllWarehouses() ([]models.Warehouse, error) {
-	query := `SELECT id, code, name, location, manager_name, phone, email, capacity, status, created_at, updated_at 
+func (r *WarehouseRepository) GetAllWarehouses(ctx context.Context) ([]models.Warehouse, error) {
+	query := `SELECT id, code, name, location, manager_name, phone, email, capacity, latitude, longitude, status, created_at, updated_at 
 	          FROM warehouses ORDER BY name`
 
-	rows, err := r.DB.Query(query)
+	rows, err := r.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -63,6 +79,7 @@ func (r *WarehouseRepository) GetAllWarehouses() ([]models.Warehouse, error) {
 	var warehouses []models.Warehouse
 	for rows.Next() {
 		var w models.Warehouse
+		var latitude, longitude sql.NullFloat64
 		err := rows.Scan(
 			&w.ID,
 			&w.Code,
@@ -72,6 +89,8 @@ func (r *WarehouseRepository) GetAllWarehouses() ([]models.Warehouse, error) {
 			&w.Phone,
 			&w.Email,
 			&w.Capacity,
+			&latitude,
+			&longitude,
 			&w.Status,
 			&w.CreatedAt,
 			&w.UpdatedAt,
@@ -80,27 +99,21 @@ func (r *WarehouseRepository) GetAllWarehouses() ([]models.Warehouse, error) {
 			log.Printf("Error scanning warehouse: %v", err)
 			continue
 		}
+		setWarehouseCoordinates(&w, latitude, longitude)
 		warehouses = append(warehouses, w)
 	}
 
 	return warehouses, nil
 }
 
-func (r *WarehouseRepository) GetWarehouseByID(id string) (*models.Warehouse, error) {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `SELECT id, code, name, location, manager_name, phone, email, capacity, status, created_at, updated_at 
-		         FROM warehouses WHERE id = $1`
-	} else {
-		query = `SELECT id, code, name, location, manager_name, phone, email, capacity, status, created_at, updated_at 
-		         FROM warehouses WHERE id = ?`
-	}
+func (r *WarehouseRepository) GetWarehouseByID(ctx context.Context, id string) (*models.Warehouse, error) {
+	query := `SELECT id, code, name, location, manager_name, phone, email, capacity, latitude, longitude, status, created_at, updated_at 
+	         FROM warehouses WHERE id = $1`
 
-	row := r.DB.QueryRow(query, id)
+	row := r.DB.QueryRowContext(ctx, query, id)
 
 	var w models.Warehouse
+	var latitude, longitude sql.NullFloat64
 	err := row.Scan(
 		&w.ID,
 		&w.Code,
@@ -110,6 +123,8 @@ func (r *WarehouseRepository) GetWarehouseByID(id string) (*models.Warehouse, er
 		&w.Phone,
 		&w.Email,
 		&w.Capacity,
+		&latitude,
+		&longitude,
 		&w.Status,
 		&w.CreatedAt,
 		&w.UpdatedAt,
@@ -122,25 +137,24 @@ func (r *WarehouseRepository) GetWarehouseByID(id string) (*models.Warehouse, er
 		log.Printf("Error getting warehouse: %v", err)
 		return nil, err
 	}
+	setWarehouseCoordinates(&w, latitude, longitude)
 
 	return &w, nil
 }
 
-func (r *WarehouseRepository) UpdateWarehouse(warehouse *models.Warehouse) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+func (r *WarehouseRepository) UpdateWarehouse(ctx context.Context, warehouse *models.Warehouse) error {
+	query := `UPDATE warehouses SET code = $1, name = $2, location = $3, manager_name = $4, 
+	         phone = $5, email = $6, capacity = $7, latitude = $8, longitude = $9, status = $10, updated_at = $11 
+	         WHERE id = $12`
 
-	var query string
-	if isPostgreSQL {
-		query = `UPDATE warehouses SET code = $1, name = $2, location = $3, manager_name = $4, 
-		         phone = $5, email = $6, capacity = $7, status = $8, updated_at = $9 
-		         WHERE id = $10`
-	} else {
-		query = `UPDATE warehouses SET code = ?, name = ?, location = ?, manager_name = ?, 
-		         phone = ?, email = ?, capacity = ?, status = ?, updated_at = ? 
-		         WHERE id = ?`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(
+	_, err = tx.ExecContext(ctx,
 		query,
 		warehouse.Code,
 		warehouse.Name,
@@ -149,6 +163,8 @@ func (r *WarehouseRepository) UpdateWarehouse(warehouse *models.Warehouse) error
 		warehouse.Phone,
 		warehouse.Email,
 		warehouse.Capacity,
+		warehouse.Latitude,
+		warehouse.Longitude,
 		warehouse.Status,
 		time.Now(),
 		warehouse.ID,
@@ -158,43 +174,49 @@ func (r *WarehouseRepository) UpdateWarehouse(warehouse *models.Warehouse) error
 		log.Printf("Error updating warehouse: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *WarehouseRepository) DeleteWarehouse(id string) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+func (r *WarehouseRepository) DeleteWarehouse(ctx context.Context, id string) error {
+	query := `DELETE FROM warehouses WHERE id = $1`
 
-	var query string
-	if isPostgreSQL {
-		query = `DELETE FROM warehouses WHERE id = $1`
-	} else {
-		query = `DELETE FROM warehouses WHERE id = ?`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(query, id)
+	_, err = tx.ExecContext(ctx, query, id)
 	if err != nil {
 		log.Printf("Error deleting warehouse: %v", err)
 		return err
 	}
-	return nil
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // WarehouseLocation CRUD
-func (r *WarehouseRepository) CreateLocation(location *models.WarehouseLocation) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+func (r *WarehouseRepository) CreateLocation(ctx context.Context, location *models.WarehouseLocation) error {
+	query := `INSERT INTO warehouse_locations (id, warehouse_id, location_code, location_name, zone, 
+	         row_number, shelf_number, max_capacity, current_quantity, status, created_at) 
+	         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 
-	var query string
-	if isPostgreSQL {
-		query = `INSERT INTO warehouse_locations (id, warehouse_id, location_code, location_name, zone, 
-		         row_number, shelf_number, max_capacity, current_quantity, status, created_at) 
-		         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
-	} else {
-		query = `INSERT INTO warehouse_locations (id, warehouse_id, location_code, location_name, zone, 
-		         row_number, shelf_number, max_capacity, current_quantity, status, created_at) 
-		         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(
+	_, err = tx.ExecContext(ctx,
 		query,
 		location.ID,
 		location.WarehouseID,
@@ -213,32 +235,23 @@ func (r *WarehouseRepository) CreateLocation(location *models.WarehouseLocation)
 		log.Printf("Error creating warehouse location: %v", err)
 		return err
 	}
-	return nil
-}
-
-func (r *WarehouseRepository) GetLocationsByWarehouse(warehouseID string) ([]models.WarehouseLocation, error) {
-	isPostgreSQL := isPostgreSQL(r.DB)
 
-	var query string
-	if isPostgreSQL {
-		query = `SELECT wl.id, wl.warehouse_id, wl.location_code, wl.location_name, wl.zone, 
-		                wl.row_number, wl.shelf_number, wl.max_capacity, wl.current_quantity, 
-		                wl.status, wl.created_at, w.name as warehouse_name
-		         FROM warehouse_locations wl
-		         JOIN warehouses w ON wl.warehouse_id = w.id
-		         WHERE wl.warehouse_id = $1
-		         ORDER BY wl.zone, wl.row_number, wl.shelf_number`
-	} else {
-		query = `SELECT wl.id, wl.warehouse_id, wl.location_code, wl.location_name, wl.zone, 
-		                wl.row_number, wl.shelf_number, wl.max_capacity, wl.current_quantity, 
-		                wl.status, wl.created_at, w.name as warehouse_name
-		         FROM warehouse_locations wl
-		         JOIN warehouses w ON wl.warehouse_id = w.id
-		         WHERE wl.warehouse_id = ?
-		         ORDER BY wl.zone, wl.row_number, wl.shelf_number`
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
+
+func (r *WarehouseRepository) GetLocationsByWarehouse(ctx context.Context, warehouseID string) ([]models.WarehouseLocation, error) {
+	query := `SELECT wl.id, wl.warehouse_id, wl.location_code, wl.location_name, wl.zone, 
+	                wl.row_number, wl.shelf_number, COALESCE(wl.max_capacity, 0), wl.current_quantity, 
+	                wl.status, wl.created_at, w.name as warehouse_name
+	         FROM warehouse_locations wl
+	         JOIN warehouses w ON wl.warehouse_id = w.id
+	         WHERE wl.warehouse_id = $1
+	         ORDER BY wl.zone, wl.row_number, wl.shelf_number`
 
-	rows, err := r.DB.Query(query, warehouseID)
+	rows, err := r.DB.QueryContext(ctx, query, warehouseID)
 	if err != nil {
 		return nil, err
 	}
@@ -271,31 +284,17 @@ func (r *WarehouseRepository) GetLocationsByWarehouse(warehouseID string) ([]mod
 	return locations, nil
 }
 
-func (r *WarehouseRepository) GetAvailableLocations(warehouseID string) ([]models.WarehouseLocation, error) {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `SELECT wl.id, wl.warehouse_id, wl.location_code, wl.location_name, wl.zone, 
-		                wl.row_number, wl.shelf_number, wl.max_capacity, wl.current_quantity, 
-		                wl.status, wl.created_at, w.name as warehouse_name
-		         FROM warehouse_locations wl
-		         JOIN warehouses w ON wl.warehouse_id = w.id
-		         WHERE wl.warehouse_id = $1 AND wl.status = 'available' 
-		               AND (wl.max_capacity IS NULL OR wl.current_quantity < wl.max_capacity)
-		         ORDER BY wl.zone, wl.row_number, wl.shelf_number`
-	} else {
-		query = `SELECT wl.id, wl.warehouse_id, wl.location_code, wl.location_name, wl.zone, 
-		                wl.row_number, wl.shelf_number, wl.max_capacity, wl.current_quantity, 
-		                wl.status, wl.created_at, w.name as warehouse_name
-		         FROM warehouse_locations wl
-		         JOIN warehouses w ON wl.warehouse_id = w.id
-		         WHERE wl.warehouse_id = ? AND wl.status = 'available' 
-		               AND (wl.max_capacity IS NULL OR wl.current_quantity < wl.max_capacity)
-		         ORDER BY wl.zone, wl.row_number, wl.shelf_number`
-	}
-
-	rows, err := r.DB.Query(query, warehouseID)
+func (r *WarehouseRepository) GetAvailableLocations(ctx context.Context, warehouseID string) ([]models.WarehouseLocation, error) {
+	query := `SELECT wl.id, wl.warehouse_id, wl.location_code, wl.location_name, wl.zone, 
+	                wl.row_number, wl.shelf_number, COALESCE(wl.max_capacity, 0), wl.current_quantity, 
+	                wl.status, wl.created_at, w.name as warehouse_name
+	         FROM warehouse_locations wl
+	         JOIN warehouses w ON wl.warehouse_id = w.id
+	         WHERE wl.warehouse_id = $1 AND wl.status = 'available' 
+	               AND (COALESCE(wl.max_capacity, 0) = 0 OR wl.current_quantity < wl.max_capacity)
+	         ORDER BY wl.zone, wl.row_number, wl.shelf_number`
+
+	rows, err := r.DB.QueryContext(ctx, query, warehouseID)
 	if err != nil {
 		return nil, err
 	}
@@ -328,21 +327,12 @@ func (r *WarehouseRepository) GetAvailableLocations(warehouseID string) ([]model
 	return locations, nil
 }
 
-func (r *WarehouseRepository) UpdateLocation(location *models.WarehouseLocation) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `UPDATE warehouse_locations SET location_code = $1, location_name = $2, zone = $3, 
-		         row_number = $4, shelf_number = $5, max_capacity = $6, current_quantity = $7, 
-		         status = $8 WHERE id = $9`
-	} else {
-		query = `UPDATE warehouse_locations SET location_code = ?, location_name = ?, zone = ?, 
-		         row_number = ?, shelf_number = ?, max_capacity = ?, current_quantity = ?, 
-		         status = ? WHERE id = ?`
-	}
+func (r *WarehouseRepository) UpdateLocation(ctx context.Context, location *models.WarehouseLocation) error {
+	query := `UPDATE warehouse_locations SET location_code = $1, location_name = $2, zone = $3, 
+	         row_number = $4, shelf_number = $5, max_capacity = $6, current_quantity = $7, 
+	         status = $8 WHERE id = $9`
 
-	_, err := r.DB.Exec(
+	_, err := r.DB.ExecContext(ctx,
 		query,
 		location.LocationCode,
 		location.LocationName,
@@ -363,134 +353,121 @@ func (r *WarehouseRepository) UpdateLocation(location *models.WarehouseLocation)
 }
 
 // Inventory CRUD
-func (r *WarehouseRepository) CreateInventory(inventory *models.Inventory) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+func (r *WarehouseRepository) CreateInventory(ctx context.Context, inventory *models.Inventory, createdBy string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// Initial stock is product stock that no warehouse holds yet being put away, so it
+	// can be no more than that and leaves the product's total alone
+	if inventory.Quantity > 0 {
+		var unplaced int
+		err := tx.QueryRowContext(ctx,
+			`SELECT p.quantity - COALESCE((SELECT SUM(i.quantity) FROM inventory i WHERE i.product_id = p.id), 0)
+			 FROM products p WHERE p.id = $1`,
+			inventory.ProductID,
+		).Scan(&unplaced)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if unplaced < inventory.Quantity {
+			return &InsufficientStockError{
+				ProductID: inventory.ProductID,
+				Available: max(unplaced, 0),
+				Requested: inventory.Quantity,
+			}
+		}
+	}
 
 	var query string
-	var err error
 
 	if inventory.LocationID != nil {
-		if isPostgreSQL {
-			query = `INSERT INTO inventory (id, product_id, warehouse_id, location_id, quantity, 
-			         reserved_quantity, min_quantity, max_quantity, last_restocked, last_checked, 
-			         created_at, updated_at) 
-			         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
-			_, err = r.DB.Exec(
-				query,
-				inventory.ID,
-				inventory.ProductID,
-				inventory.WarehouseID,
-				inventory.LocationID,
-				inventory.Quantity,
-				inventory.ReservedQuantity,
-				inventory.MinQuantity,
-				inventory.MaxQuantity,
-				inventory.LastRestocked,
-				inventory.LastChecked,
-				inventory.CreatedAt,
-				inventory.UpdatedAt,
-			)
-		} else {
-			query = `INSERT INTO inventory (id, product_id, warehouse_id, location_id, quantity, 
-			         reserved_quantity, min_quantity, max_quantity, last_restocked, last_checked, 
-			         created_at, updated_at) 
-			         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-			_, err = r.DB.Exec(
-				query,
-				inventory.ID,
-				inventory.ProductID,
-				inventory.WarehouseID,
-				inventory.LocationID,
-				inventory.Quantity,
-				inventory.ReservedQuantity,
-				inventory.MinQuantity,
-				inventory.MaxQuantity,
-				inventory.LastRestocked,
-				inventory.LastChecked,
-				inventory.CreatedAt,
-				inventory.UpdatedAt,
-			)
-		}
+		query = `INSERT INTO inventory (id, product_id, warehouse_id, location_id, quantity, 
+		         reserved_quantity, min_quantity, max_quantity, last_restocked, last_checked, 
+		         created_at, updated_at) 
+		         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+		_, err = tx.ExecContext(ctx,
+			query,
+			inventory.ID,
+			inventory.ProductID,
+			inventory.WarehouseID,
+			inventory.LocationID,
+			inventory.Quantity,
+			inventory.ReservedQuantity,
+			inventory.MinQuantity,
+			inventory.MaxQuantity,
+			inventory.LastRestocked,
+			inventory.LastChecked,
+			inventory.CreatedAt,
+			inventory.UpdatedAt,
+		)
 	} else {
-		if isPostgreSQL {
-			query = `INSERT INTO inventory (id, product_id, warehouse_id, quantity, 
-			         reserved_quantity, min_quantity, max_quantity, last_restocked, last_checked, 
-			         created_at, updated_at) 
-			         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
-			_, err = r.DB.Exec(
-				query,
-				inventory.ID,
-				inventory.ProductID,
-				inventory.WarehouseID,
-				inventory.Quantity,
-				inventory.ReservedQuantity,
-				inventory.MinQuantity,
-				inventory.MaxQuantity,
-				inventory.LastRestocked,
-				inventory.LastChecked,
-				inventory.CreatedAt,
-				inventory.UpdatedAt,
-			)
-		} else {
-			query = `INSERT INTO inventory (id, product_id, warehouse_id, quantity, 
-			         reserved_quantity, min_quantity, max_quantity, last_restocked, last_checked, 
-			         created_at, updated_at) 
-			         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
-			_, err = r.DB.Exec(
-				query,
-				inventory.ID,
-				inventory.ProductID,
-				inventory.WarehouseID,
-				inventory.Quantity,
-				inventory.ReservedQuantity,
-				inventory.MinQuantity,
-				inventory.MaxQuantity,
-				inventory.LastRestocked,
-				inventory.LastChecked,
-				inventory.CreatedAt,
-				inventory.UpdatedAt,
-			)
-		}
+		query = `INSERT INTO inventory (id, product_id, warehouse_id, quantity, 
+		         reserved_quantity, min_quantity, max_quantity, last_restocked, last_checked, 
+		         created_at, updated_at) 
+		         VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+		_, err = tx.ExecContext(ctx,
+			query,
+			inventory.ID,
+			inventory.ProductID,
+			inventory.WarehouseID,
+			inventory.Quantity,
+			inventory.ReservedQuantity,
+			inventory.MinQuantity,
+			inventory.MaxQuantity,
+			inventory.LastRestocked,
+			inventory.LastChecked,
+			inventory.CreatedAt,
+			inventory.UpdatedAt,
+		)
 	}
 
 	if err != nil {
 		log.Printf("Error creating inventory: %v", err)
 		return err
 	}
-	return nil
-}
 
-func (r *WarehouseRepository) GetInventoryByProduct(productID string) ([]models.Inventory, error) {
-	isPostgreSQL := isPostgreSQL(r.DB)
+	// Stock placed in a location counts towards its current quantity
+	if inventory.LocationID != nil && inventory.Quantity > 0 {
+		query = `UPDATE warehouse_locations SET current_quantity = current_quantity + $1, updated_at = $2 WHERE id = $3`
 
-	var query string
-	if isPostgreSQL {
-		query = `SELECT i.id, i.product_id, i.warehouse_id, i.location_id, i.quantity, 
-		                i.reserved_quantity, i.min_quantity, i.max_quantity, i.last_restocked, 
-		                i.last_checked, i.created_at, i.updated_at,
-		                p.name as product_name, p.sku, w.name as warehouse_name, 
-		                wl.location_code
-		         FROM inventory i
-		         JOIN products p ON i.product_id = p.id
-		         JOIN warehouses w ON i.warehouse_id = w.id
-		         LEFT JOIN warehouse_locations wl ON i.location_id = wl.id
-		         WHERE i.product_id = $1
-		         ORDER BY w.name`
-	} else {
-		query = `SELECT i.id, i.product_id, i.warehouse_id, i.location_id, i.quantity, 
-		                i.reserved_quantity, i.min_quantity, i.max_quantity, i.last_restocked, 
-		                i.last_checked, i.created_at, i.updated_at,
-		                p.name as product_name, p.sku, w.name as warehouse_name, 
-		                wl.location_code
-		         FROM inventory i
-		         JOIN products p ON i.product_id = p.id
-		         JOIN warehouses w ON i.warehouse_id = w.id
-		         LEFT JOIN warehouse_locations wl ON i.location_id = wl.id
-		         WHERE i.product_id = ?
-		         ORDER BY w.name`
-	}
-
-	rows, err := r.DB.Query(query, productID)
+		if _, err := tx.ExecContext(ctx, query, inventory.Quantity, time.Now(), *inventory.LocationID); err != nil {
+			log.Printf("Error updating location quantity: %v", err)
+			return err
+		}
+	}
+
+	if inventory.Quantity > 0 {
+		movement := models.NewStockMovement(models.MovementTypeTransfer, inventory.ProductID, inventory.Quantity,
+			models.ReferenceTypeInventory, inventory.ID, "Initial stock placed", createdBy)
+		if err := recordInventoryMovement(ctx, tx, inventory.ID, movement); err != nil {
+			return err
+		}
+	}
+
+	if err := recordAudit(ctx, tx, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *WarehouseRepository) GetInventoryByProduct(ctx context.Context, productID string) ([]models.Inventory, error) {
+	query := `SELECT i.id, i.product_id, i.warehouse_id, i.location_id, i.quantity, 
+	                i.reserved_quantity, i.min_quantity, i.max_quantity, i.last_restocked, 
+	                i.last_checked, i.created_at, i.updated_at,
+	                p.name as product_name, p.sku, w.name as warehouse_name, 
+	                wl.location_code
+	         FROM inventory i
+	         JOIN products p ON i.product_id = p.id
+	         JOIN warehouses w ON i.warehouse_id = w.id
+	         LEFT JOIN warehouse_locations wl ON i.location_id = wl.id
+	         WHERE i.product_id = $1
+	         ORDER BY w.name`
+
+	rows, err := r.DB.QueryContext(ctx, query, productID)
 	if err != nil {
 		return nil, err
 	}
@@ -557,37 +534,20 @@ func (r *WarehouseRepository) GetInventoryByProduct(productID string) ([]models.
 	return inventories, nil
 }
 
-func (r *WarehouseRepository) GetInventoryByWarehouse(warehouseID string) ([]models.Inventory, error) {
-	isPostgreSQL := isPostgreSQL(r.DB)
-
-	var query string
-	if isPostgreSQL {
-		query = `SELECT i.id, i.product_id, i.warehouse_id, i.location_id, i.quantity, 
-		                i.reserved_quantity, i.min_quantity, i.max_quantity, i.last_restocked, 
-		                i.last_checked, i.created_at, i.updated_at,
-		                p.name as product_name, p.sku, w.name as warehouse_name, 
-		                wl.location_code
-		         FROM inventory i
-		         JOIN products p ON i.product_id = p.id
-		         JOIN warehouses w ON i.warehouse_id = w.id
-		         LEFT JOIN warehouse_locations wl ON i.location_id = wl.id
-		         WHERE i.warehouse_id = $1
-		         ORDER BY p.name`
-	} else {
-		query = `SELECT i.id, i.product_id, i.warehouse_id, i.location_id, i.quantity, 
-		                i.reserved_quantity, i.min_quantity, i.max_quantity, i.last_restocked, 
-		                i.last_checked, i.created_at, i.updated_at,
-		                p.name as product_name, p.sku, w.name as warehouse_name, 
-		                wl.location_code
-		         FROM inventory i
-		         JOIN products p ON i.product_id = p.id
-		         JOIN warehouses w ON i.warehouse_id = w.id
-		         LEFT JOIN warehouse_locations wl ON i.location_id = wl.id
-		         WHERE i.warehouse_id = ?
-		         ORDER BY p.name`
-	}
-
-	rows, err := r.DB.Query(query, warehouseID)
+func (r *WarehouseRepository) GetInventoryByWarehouse(ctx context.Context, warehouseID string) ([]models.Inventory, error) {
+	query := `SELECT i.id, i.product_id, i.warehouse_id, i.location_id, i.quantity, 
+	                i.reserved_quantity, i.min_quantity, i.max_quantity, i.last_restocked, 
+	                i.last_checked, i.created_at, i.updated_at,
+	                p.name as product_name, p.sku, w.name as warehouse_name, 
+	                wl.location_code
+	         FROM inventory i
+	         JOIN products p ON i.product_id = p.id
+	         JOIN warehouses w ON i.warehouse_id = w.id
+	         LEFT JOIN warehouse_locations wl ON i.location_id = wl.id
+	         WHERE i.warehouse_id = $1
+	         ORDER BY p.name`
+
+	rows, err := r.DB.QueryContext(ctx, query, warehouseID)
 	if err != nil {
 		return nil, err
 	}
@@ -654,17 +614,25 @@ func (r *WarehouseRepository) GetInventoryByWarehouse(warehouseID string) ([]mod
 	return inventories, nil
 }
 
-func (r *WarehouseRepository) UpdateInventoryQuantity(id string, quantity, reservedQuantity int) error {
-	isPostgreSQL := isPostgreSQL(r.DB)
+func (r *WarehouseRepository) UpdateInventoryQuantity(ctx context.Context, id string, quantity, reservedQuantity int) error {
+	query := `UPDATE inventory SET quantity = $1, reserved_quantity = $2, updated_at = $3 WHERE id = $4`
 
-	var query string
-	if isPostgreSQL {
-		query = `UPDATE inventory SET quantity = $1, reserved_quantity = $2, updated_at = $3 WHERE id = $4`
-	} else {
-		query = `UPDATE inventory SET quantity = ?, reserved_quantity = ?, updated_at = ? WHERE id = ?`
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(
+	previousQuery := `SELECT quantity FROM inventory WHERE id = $1`
+
+	var previousQuantity int
+	if err := tx.QueryRowContext(ctx, previousQuery, id).Scan(&previousQuantity); err != nil {
+		log.Printf("Error reading inventory quantity: %v", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
 		query,
 		quantity,
 		reservedQuantity,
@@ -676,5 +644,245 @@ func (r *WarehouseRepository) UpdateInventoryQuantity(id string, quantity, reser
 		log.Printf("Error updating inventory quantity: %v", err)
 		return err
 	}
-	return nil
+
+	if change := quantity - previousQuantity; change != 0 {
+		movement := models.NewStockMovement(models.MovementTypeAdjustment, "", change,
+			models.ReferenceTypeInventory, id, "Inventory quantity updated", "")
+		if err := recordInventoryMovement(ctx, tx, id, movement); err != nil {
+			return err
+		}
+		if movement.LocationID != nil {
+			_, err := tx.ExecContext(ctx,
+				`UPDATE warehouse_locations SET current_quantity = current_quantity + $1, updated_at = $2 WHERE id = $3`,
+				change, time.Now(), *movement.LocationID,
+			)
+			if err != nil {
+				log.Printf("Error updating location quantity: %v", err)
+				return err
+			}
+		}
+		if err := applyToProductStock(ctx, tx, movement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *WarehouseRepository) GetInventoryByID(ctx context.Context, id string) (*models.Inventory, error) {
+	return getInventory(ctx, r.DB, id)
+}
+
+// getInventory reads an inventory row through exec, so it can be read inside the
+// transaction that changes it
+func getInventory(ctx context.Context, exec stockExecutor, id string) (*models.Inventory, error) {
+	query := `SELECT i.id, i.product_id, i.warehouse_id, i.location_id, i.quantity, 
+	                i.reserved_quantity, i.min_quantity, i.max_quantity, i.last_restocked, 
+	                i.last_checked, i.created_at, i.updated_at,
+	                p.name as product_name, p.sku, w.name as warehouse_name, 
+	                wl.location_code
+	         FROM inventory i
+	         JOIN products p ON i.product_id = p.id
+	         JOIN warehouses w ON i.warehouse_id = w.id
+	         LEFT JOIN warehouse_locations wl ON i.location_id = wl.id
+	         WHERE i.id = $1`
+
+	var inv models.Inventory
+	var locationID sql.NullString
+	var locationCode sql.NullString
+	var maxQuantity sql.NullInt64
+	var lastRestocked, lastChecked sql.NullTime
+
+	err := exec.QueryRowContext(ctx, query, id).Scan(
+		&inv.ID,
+		&inv.ProductID,
+		&inv.WarehouseID,
+		&locationID,
+		&inv.Quantity,
+		&inv.ReservedQuantity,
+		&inv.MinQuantity,
+		&maxQuantity,
+		&lastRestocked,
+		&lastChecked,
+		&inv.CreatedAt,
+		&inv.UpdatedAt,
+		&inv.ProductName,
+		&inv.SKU,
+		&inv.WarehouseName,
+		&locationCode,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Error getting inventory: %v", err)
+		return nil, err
+	}
+
+	// Handle nullable fields
+	if locationID.Valid {
+		locID := locationID.String
+		inv.LocationID = &locID
+	}
+	if locationCode.Valid {
+		inv.LocationCode = locationCode.String
+	}
+	if maxQuantity.Valid {
+		maxQty := int(maxQuantity.Int64)
+		inv.MaxQuantity = &maxQty
+	}
+	if lastRestocked.Valid {
+		inv.LastRestocked = &lastRestocked.Time
+	}
+	if lastChecked.Valid {
+		inv.LastChecked = &lastChecked.Time
+	}
+
+	inv.AvailableQuantity = inv.Quantity - inv.ReservedQuantity
+
+	return &inv, nil
+}
+
+// AdjustInventory applies a manual correction to an inventory row. The new quantity may
+// not drop below what is reserved for orders, and stock added to a location may not
+// take it over its max_capacity (zero means unlimited). The location's current
+// quantity and the product's total stock follow the change, last_checked is stamped,
+// and any change is written to the stock ledger.
+func (r *WarehouseRepository) AdjustInventory(ctx context.Context, adjustment *models.InventoryAdjustment) (*models.StockMovement, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT quantity, COALESCE(reserved_quantity, 0), location_id FROM inventory WHERE id = $1`
+
+	var quantity, reserved int
+	var locationID sql.NullString
+	err = tx.QueryRowContext(ctx, query, adjustment.InventoryID).Scan(&quantity, &reserved, &locationID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInventoryNotFound
+	}
+	if err != nil {
+		log.Printf("Error reading inventory: %v", err)
+		return nil, err
+	}
+
+	newQuantity := adjustment.Quantity
+	if adjustment.Mode == models.AdjustmentModeDelta {
+		newQuantity = quantity + adjustment.Quantity
+	}
+	change := newQuantity - quantity
+
+	if newQuantity < 0 {
+		return nil, fmt.Errorf("%w: quantity cannot go below zero (on hand %d)", ErrInvalidAdjustment, quantity)
+	}
+	if newQuantity < reserved {
+		return nil, fmt.Errorf("%w: %d units are reserved", ErrBelowReservedQuantity, reserved)
+	}
+
+	if locationID.Valid && change > 0 {
+		query = `SELECT COALESCE(max_capacity, 0), COALESCE(current_quantity, 0) FROM warehouse_locations WHERE id = $1`
+
+		var maxCapacity, currentQuantity int
+		if err := tx.QueryRowContext(ctx, query, locationID.String).Scan(&maxCapacity, &currentQuantity); err != nil {
+			log.Printf("Error reading location capacity: %v", err)
+			return nil, err
+		}
+		if maxCapacity > 0 && currentQuantity+change > maxCapacity {
+			return nil, fmt.Errorf("%w: location holds %d of %d", ErrLocationCapacityExceeded, currentQuantity, maxCapacity)
+		}
+	}
+
+	now := time.Now()
+	query = `UPDATE inventory SET quantity = $1, last_checked = $2, updated_at = $3 WHERE id = $4`
+
+	if _, err := tx.ExecContext(ctx, query, newQuantity, now, now, adjustment.InventoryID); err != nil {
+		log.Printf("Error adjusting inventory: %v", err)
+		return nil, err
+	}
+
+	if change == 0 {
+		if err := recordInventoryAudit(ctx, tx, adjustment.InventoryID); err != nil {
+			return nil, err
+		}
+		return nil, tx.Commit()
+	}
+
+	if locationID.Valid {
+		query = `UPDATE warehouse_locations SET current_quantity = current_quantity + $1, updated_at = $2 WHERE id = $3`
+
+		if _, err := tx.ExecContext(ctx, query, change, now, locationID.String); err != nil {
+			log.Printf("Error updating location quantity: %v", err)
+			return nil, err
+		}
+	}
+
+	movementType := models.MovementTypeAdjustment
+	if adjustment.ReasonCode == models.AdjustmentReasonCountCorrection {
+		movementType = models.MovementTypeCountCorrection
+	}
+	reason := adjustment.ReasonCode
+	if adjustment.Note != "" {
+		reason += ": " + adjustment.Note
+	}
+
+	movement := models.NewStockMovement(movementType, "", change,
+		models.ReferenceTypeInventory, adjustment.InventoryID, reason, adjustment.AdjustedBy)
+	if err := recordInventoryMovement(ctx, tx, adjustment.InventoryID, movement); err != nil {
+		return nil, err
+	}
+	if err := applyToProductStock(ctx, tx, movement); err != nil {
+		return nil, err
+	}
+	if err := recordInventoryAudit(ctx, tx, adjustment.InventoryID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+// UpdateInventoryLevels changes the reorder thresholds of an inventory row
+func (r *WarehouseRepository) UpdateInventoryLevels(ctx context.Context, id string, minQuantity int, maxQuantity *int) error {
+	query := `UPDATE inventory SET min_quantity = $1, max_quantity = $2, updated_at = $3 WHERE id = $4`
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query, minQuantity, maxQuantity, time.Now(), id)
+	if err != nil {
+		log.Printf("Error updating inventory levels: %v", err)
+		return err
+	}
+
+	if err := recordInventoryAudit(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordInventoryAudit writes the audit entry ctx asks for with the inventory row as
+// the change through tx has left it
+func recordInventoryAudit(ctx context.Context, tx *database.Tx, id string) error {
+	inventory, err := getInventory(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	return recordAudit(ctx, tx, inventory)
+}
+
+func setWarehouseCoordinates(w *models.Warehouse, latitude, longitude sql.NullFloat64) {
+	if latitude.Valid && longitude.Valid {
+		w.Latitude = &latitude.Float64
+		w.Longitude = &longitude.Float64
+	}
 }
@@ -29,6 +29,7 @@ const (
 	CodeNotFound     = "13"
 	CodeValidation   = "14"
 	CodeDuplicate    = "15"
+	CodeTimeout      = "98"
 	CodeInternal     = "99"
 )
 
@@ -77,6 +78,8 @@ func ErrorResponse(c *gin.Context, code string, message string, details interfac
 		c.JSON(http.StatusForbidden, response)
 	case CodeNotFound:
 		c.JSON(http.StatusNotFound, response)
+	case CodeTimeout:
+		c.JSON(http.StatusGatewayTimeout, response)
 	default:
 		c.JSON(http.StatusInternalServerError, response)
 	}
@@ -91,6 +94,14 @@ func ValidationErrorResponse(c *gin.Context, message string, details interface{}
 	ErrorResponse(c, CodeValidation, message, details)
 }
 
+func UnauthorizedResponse(c *gin.Context, message string) {
+	ErrorResponse(c, CodeUnauthorized, message, nil)
+}
+
+func ForbiddenResponse(c *gin.Context, message string, details interface{}) {
+	ErrorResponse(c, CodeForbidden, message, details)
+}
+
 func NotFoundResponse(c *gin.Context, message string) {
 	ErrorResponse(c, CodeNotFound, message, nil)
 }
@@ -102,3 +113,7 @@ func DuplicateErrorResponse(c *gin.Context, message string, details interface{})
 func InternalErrorResponse(c *gin.Context, message string, details interface{}) {
 	ErrorResponse(c, CodeInternal, message, details)
 }
+
+func TimeoutResponse(c *gin.Context, message string) {
+	ErrorResponse(c, CodeTimeout, message, "The request took too long and was stopped")
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Token types carried in the typ claim
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// APIKeyPrefix starts every generated API key so they are easy to spot in config
+const APIKeyPrefix = "erp_"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// TokenClaims are the claims of the JWTs issued at login
+type TokenClaims struct {
+	Username  string `json:"username"`
+	TokenType string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair is returned by login and refresh
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int       `json:"expires_in"` // Seconds until the access token expires
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+
+	RefreshTokenID string `json:"-"`
+}
+
+// TokenManager signs and verifies HS256 access and refresh tokens
+type TokenManager struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokenManager(secret []byte, accessTTL, refreshTTL time.Duration) *TokenManager {
+	return &TokenManager{secret: secret, accessTTL: accessTTL, refreshTTL: refreshTTL}
+}
+
+// NewTokenManagerFromEnv reads JWT_SECRET, JWT_ACCESS_TTL and JWT_REFRESH_TTL. Without a
+// secret a random one is generated, so tokens stop working when the server restarts.
+func NewTokenManagerFromEnv() *TokenManager {
+	secret := []byte(os.Getenv("JWT_SECRET"))
+	if len(secret) == 0 {
+		log.Println("⚠️ JWT_SECRET is not set; using a random secret, tokens will not survive a restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal("Failed to generate JWT secret:", err)
+		}
+	}
+
+	return NewTokenManager(
+		secret,
+		DurationFromEnv("JWT_ACCESS_TTL", 15*time.Minute),
+		DurationFromEnv("JWT_REFRESH_TTL", 7*24*time.Hour),
+	)
+}
+
+// IssueTokenPair signs a new access and refresh token for a user
+func (m *TokenManager) IssueTokenPair(userID, username string) (*TokenPair, error) {
+	now := time.Now()
+
+	accessToken, err := m.sign(userID, username, TokenTypeAccess, uuid.New().String(), now, m.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshID := uuid.New().String()
+	refreshToken, err := m.sign(userID, username, TokenTypeRefresh, refreshID, now, m.refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(m.accessTTL.Seconds()),
+		RefreshExpiresAt: now.Add(m.refreshTTL),
+		RefreshTokenID:   refreshID,
+	}, nil
+}
+
+// ParseToken verifies a token's signature and expiry and that it is of the expected type
+func (m *TokenManager) ParseToken(tokenString, tokenType string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return m.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("%w: expected a %s token", ErrInvalidToken, tokenType)
+	}
+	return claims, nil
+}
+
+func (m *TokenManager) sign(userID, username, tokenType, id string, now time.Time, ttl time.Duration) (string, error) {
+	claims := TokenClaims{
+		Username:  username,
+		TokenType: tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+}
+
+// GenerateAPIKey returns a new random API key and the prefix stored to identify it
+func GenerateAPIKey() (key, prefix string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + hex.EncodeToString(raw)
+	return key, key[:len(APIKeyPrefix)+8], nil
+}
+
+// HashAPIKey returns the hash an API key is stored and looked up by. Keys are random
+// and long, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// DurationFromEnv parses the named environment variable as a duration such as "30s",
+// falling back when it is unset or not a positive duration
+func DurationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️ Invalid %s %q, using %s", name, value, fallback)
+		return fallback
+	}
+	return d
+}